@@ -20,6 +20,10 @@ import (
 func main() {
 	// Step 0: Load configuration from environment variables
 	configs.LoadConfig()
+	configs.StartHotReload()
+	if configs.MOCK_AI {
+		log.Printf("🧪 MOCK_AI=true: OCR, template matching and accounting return recorded fixtures (no AI calls)")
+	}
 
 	// Step 0.5: Set production mode
 	if ginMode := os.Getenv("GIN_MODE"); ginMode == "release" {
@@ -30,34 +34,68 @@ func main() {
 	if err := os.MkdirAll(configs.UPLOAD_DIR, 0755); err != nil {
 		log.Fatalf("Failed to create upload directory: %v", err)
 	} // Step 1.5: Initialize MongoDB connection
-	if err := storage.InitMongoDB(); err != nil {
+	store, err := storage.InitMongoDB()
+	if err != nil {
 		log.Fatalf("Failed to connect to MongoDB: %v", err)
 	}
 	defer storage.CloseMongoDB()
+	closeStore := api.InitStore(store)
+	defer closeStore()
+
+	// Step 1.6: Ensure indexes for collections owned by this service (idempotency keys, usage ledger, ...)
+	if err := storage.EnsureIndexes(); err != nil {
+		log.Printf("⚠️  Failed to ensure MongoDB indexes: %v", err)
+	}
+	// Step 1.7: Load incident response flags (suspended shops, disabled providers) and keep them in sync
+	api.StartRuntimeFlagSync()
+	// Analyses cut off by the previous shutdown → failedRequests (phase shutdown, GET /api/v1/failed + retry)
+	if recovered, err := api.RecoverInterruptedAnalyses(); err != nil {
+		log.Printf("⚠️  Failed to recover interrupted analyses: %v", err)
+	} else if recovered > 0 {
+		log.Printf("♻️  %d analyses interrupted by the previous shutdown are listed in /api/v1/failed for retry", recovered)
+	}
+	// Step 1.8: Purge stored document data older than the retention window (PDPA)
+	api.StartRetentionPurger()
+	// Step 1.9: Run queued re-processing campaigns (REPROCESS_POLL_INTERVAL_SEC)
+	api.StartReprocessRunner()
+	// Step 1.10: Poll the shops' Google Drive / Dropbox folders (FOLDER_WATCH_POLL_INTERVAL_SEC)
+	api.StartFolderWatcher()
 
 	// Step 2: Initialize the Gin router
 	router := gin.Default()
 
-	// Add CORS middleware - configure allowed origins for production
-	router.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", configs.ALLOWED_ORIGINS)
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	// CORS for browser clients (ALLOWED_ORIGINS, CORS_*) - preflight methods come from the registered routes
+	router.Use(api.CORSMiddleware(router))
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		c.Next()
-	})
+	// gzip for clients that accept it (RESPONSE_COMPRESSION) - large results are streamed in chunks
+	router.Use(api.CompressionMiddleware())
+
+	// Every /api/v1 and /api/v2 call is recorded in the audit log (actor, endpoint, request_id, outcome, cost)
+	// Registered before the suspension check so rejected calls are recorded too
+	router.Use(api.AuditMiddleware())
+
+	// Request body limits per route (MAX_REQUEST_BODY_BYTES / MAX_UPLOAD_BODY_BYTES / REQUEST_BODY_LIMITS) → 413
+	router.Use(api.BodyLimitMiddleware())
+
+	// Deprecated routes (API_DEPRECATIONS) get Deprecation / Sunset headers, 410 after the sunset with API_SUNSET_ENFORCED
+	router.Use(api.DeprecationMiddleware())
+
+	// X-Tenant-ID → tenant database of the request (TENANT_ROUTING_ENABLED)
+	router.Use(api.TenantMiddleware())
+
+	// Suspended shops (admin API) get 403 on every /shops/:shopid route
+	router.Use(api.ShopSuspensionMiddleware())
 
 	// Root endpoint for SSL verification
 	router.GET("/", func(c *gin.Context) {
 		c.String(200, "ok")
 	})
 
-	// Health check endpoint
+	// Kubernetes-style probes: liveness (no dependency checks) and readiness (MongoDB, upload dir, providers)
+	router.GET("/healthz", api.HealthzHandler)
+	router.GET("/readyz", api.ReadyzHandler)
+
+	// Health check endpoint (legacy static response - use /readyz for dependency status)
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"status":  "ok",
@@ -67,8 +105,86 @@ func main() {
 	})
 
 	// Step 3: Define the API routes
-	router.POST("/api/v1/analyze-receipt", api.AnalyzeReceiptHandler)
-	router.POST("/api/v1/test-template", api.TestTemplateHandler)
+	// Route annotations: shopRole = shop key of the shop (or admin), adminRole = admin key only
+	shopRole := api.RequireRole(api.RoleShop)
+	adminRole := api.RequireRole(api.RoleAdmin)
+	router.POST("/api/v1/analyze-receipt", shopRole, api.DrainMiddleware(), api.IdempotencyMiddleware(), api.CoalesceMiddleware(), api.AnalyzeReceiptHandler)
+	router.POST("/api/v2/analyze-receipt", shopRole, api.DrainMiddleware(), api.IdempotencyMiddleware(), api.CoalesceMiddleware(), api.AnalyzeReceiptV2Handler)
+	router.POST("/api/v1/analyze-zip", shopRole, api.AnalyzeZipHandler) // Checks draining itself (DrainMiddleware buffers the body)
+	router.POST("/api/v1/test-template", shopRole, api.DrainMiddleware(), api.TestTemplateHandler)
+	router.POST("/api/v1/classify-document", shopRole, api.DrainMiddleware(), api.ClassifyDocumentHandler)
+	router.POST("/api/v1/ocr", shopRole, api.DrainMiddleware(), api.PureOCRHandler)
+	router.POST("/api/v1/extract", shopRole, api.DrainMiddleware(), api.SchemaExtractHandler)
+	router.POST("/api/v1/quality-check", shopRole, api.QualityCheckHandler)
+	router.GET("/api/v1/providers", shopRole, api.ProvidersHandler)
+	router.GET("/api/v1/shops/:shopid/costs", adminRole, api.ShopCostsHandler)
+	router.GET("/api/v1/shops/:shopid/journal-book-rules", adminRole, api.GetJournalBookRulesHandler)
+	router.PUT("/api/v1/shops/:shopid/journal-book-rules", adminRole, api.PutJournalBookRulesHandler)
+	router.POST("/api/v1/shops/:shopid/accounts/suggest", shopRole, api.SuggestAccountsHandler)
+	router.POST("/api/v1/shops/:shopid/templates/validate", shopRole, api.ValidateTemplateHandler)
+	router.GET("/api/v1/shops/:shopid/template-subscriptions", shopRole, api.GetTemplateSubscriptionsHandler)
+	router.PUT("/api/v1/shops/:shopid/template-subscriptions/:library_id", shopRole, api.PutTemplateSubscriptionHandler)
+	router.POST("/api/v1/shops/:shopid/template-subscriptions/:library_id/suggest-mapping", shopRole, api.SuggestTemplateMappingHandler)
+	router.DELETE("/api/v1/shops/:shopid/template-subscriptions/:library_id", shopRole, api.DeleteTemplateSubscriptionHandler)
+	router.GET("/api/v1/template-library", shopRole, api.ListLibraryTemplatesHandler)
+	router.PUT("/api/v1/template-library/:library_id", adminRole, api.PutLibraryTemplateHandler)
+	router.DELETE("/api/v1/template-library/:library_id", adminRole, api.DeleteLibraryTemplateHandler)
+	router.GET("/api/v1/shops/:shopid/template-coverage", adminRole, api.TemplateCoverageHandler)
+	router.GET("/api/v1/shops/:shopid/template-suggestions", adminRole, api.TemplateSuggestionsHandler)
+	router.GET("/api/v1/shops/:shopid/template-threshold-report", adminRole, api.TemplateThresholdReportHandler)
+	router.GET("/api/v1/shops/:shopid/search", shopRole, api.SearchDocumentsHandler)
+	router.GET("/api/v1/shops/:shopid/reports/input-vat", shopRole, api.InputVATReportHandler)
+	router.GET("/api/v1/shops/:shopid/reports/withholding-tax", shopRole, api.WithholdingTaxReportHandler)
+	router.GET("/api/v1/shops/:shopid/reports/document-sequence", shopRole, api.DocumentSequenceReportHandler)
+	router.GET("/api/v1/shops/:shopid/vendor-mappings", adminRole, api.GetVendorMappingsHandler)
+	router.POST("/api/v1/shops/:shopid/vendor-mappings", adminRole, api.ApproveVendorMappingHandler)
+	router.DELETE("/api/v1/shops/:shopid/vendor-mappings/:creditor_code", adminRole, api.DeleteVendorMappingHandler)
+	router.GET("/api/v1/shops/:shopid/creditor-aliases", shopRole, api.GetCreditorAliasesHandler)
+	router.POST("/api/v1/shops/:shopid/creditor-aliases", shopRole, api.SaveCreditorAliasHandler)
+	router.DELETE("/api/v1/shops/:shopid/creditor-aliases/:alias", shopRole, api.DeleteCreditorAliasHandler)
+	router.GET("/api/v1/shops/:shopid/creditor-branches", shopRole, api.GetCreditorBranchesHandler)
+	router.PUT("/api/v1/shops/:shopid/creditor-branches/:creditor_code/:branch_code", shopRole, api.PutCreditorBranchHandler)
+	router.DELETE("/api/v1/shops/:shopid/creditor-branches/:creditor_code/:branch_code", shopRole, api.DeleteCreditorBranchHandler)
+	router.GET("/api/v1/shops/:shopid/dimensions", shopRole, api.GetDimensionsHandler)
+	router.PUT("/api/v1/shops/:shopid/dimensions/:key/:code", shopRole, api.PutDimensionHandler)
+	router.DELETE("/api/v1/shops/:shopid/dimensions/:key/:code", shopRole, api.DeleteDimensionHandler)
+	router.GET("/api/v1/shops/:shopid/settings", adminRole, api.GetShopSettingsHandler)
+	router.PUT("/api/v1/shops/:shopid/settings", adminRole, api.UpdateShopSettingsHandler)
+	router.DELETE("/api/v1/shops/:shopid/results", adminRole, api.PurgeShopResultsHandler)
+	router.POST("/api/v1/shops/:shopid/reprocess", adminRole, api.CreateReprocessCampaignHandler)
+	router.GET("/api/v1/shops/:shopid/reprocess", adminRole, api.ListReprocessCampaignsHandler)
+	router.GET("/api/v1/shops/:shopid/reprocess/:campaign_id", adminRole, api.GetReprocessCampaignHandler)
+	router.POST("/api/v1/shops/:shopid/reprocess/:campaign_id/cancel", adminRole, api.CancelReprocessCampaignHandler)
+	router.GET("/api/v1/shops/:shopid/folder-watch", adminRole, api.GetFolderWatchHandler)
+	router.PUT("/api/v1/shops/:shopid/folder-watch", adminRole, api.PutFolderWatchHandler)
+	router.DELETE("/api/v1/shops/:shopid/folder-watch", adminRole, api.DeleteFolderWatchHandler)
+	router.GET("/api/v1/shops/:shopid/folder-watch/files", adminRole, api.ListFolderWatchFilesHandler)
+	router.POST("/api/v1/results/:request_id/reanalyze", shopRole, api.DrainMiddleware(), api.ReanalyzeResultHandler)
+	router.GET("/api/v1/results/:request_id/traces", shopRole, api.AITracesHandler)
+	router.GET("/api/v1/results/compare", shopRole, api.CompareResultsHandler)
+	router.POST("/api/v1/results/:request_id/compare-modes", shopRole, api.DrainMiddleware(), api.CompareModesHandler)
+	router.POST("/api/v1/results/:request_id/approve", shopRole, api.ApproveResultHandler)
+	router.GET("/api/v1/results/:request_id/bundle", shopRole, api.ResultBundleHandler)
+	router.GET("/api/v1/results/:request_id/voucher", shopRole, api.JournalVoucherPDFHandler)
+	router.GET("/api/v1/failed", shopRole, api.ListFailedRequestsHandler)
+	router.POST("/api/v1/failed/:id/retry", shopRole, api.DrainMiddleware(), api.RetryFailedRequestHandler)
+
+	// Admin API (Authorization: Bearer ADMIN_API_KEY) - incident response without redeploying
+	admin := router.Group("/api/v1/admin", api.AdminAuthMiddleware())
+	admin.GET("/flags", api.RuntimeFlagsHandler)
+	admin.GET("/audit", api.AuditLogHandler)
+	admin.POST("/cache/refresh", api.RefreshCacheHandler)
+	admin.GET("/tenants", api.TenantRoutesHandler)
+	admin.POST("/shops/:shopid/suspension", api.SuspendShopHandler)
+	admin.DELETE("/shops/:shopid/suspension", api.ResumeShopHandler)
+	admin.POST("/providers/:provider/disable", api.DisableProviderHandler)
+	admin.DELETE("/providers/:provider/disable", api.EnableProviderHandler)
+
+	// API documentation (OpenAPI 3 spec + optional Swagger UI)
+	router.GET("/api/v1/openapi.json", api.OpenAPIHandler)
+	if configs.ENABLE_SWAGGER_UI {
+		router.GET("/api/v1/docs", api.SwaggerUIHandler)
+	}
 
 	// Step 4: Setup HTTP server with timeouts
 	srv := &http.Server{
@@ -83,8 +199,79 @@ func main() {
 	go func() {
 		log.Printf("Starting server on :%s", configs.PORT)
 		log.Println("API Endpoints:")
+		log.Println("  GET  /healthz, /readyz")
 		log.Println("  POST /api/v1/analyze-receipt")
+		log.Println("  POST /api/v2/analyze-receipt")
+		log.Println("  POST /api/v1/analyze-zip")
 		log.Println("  POST /api/v1/test-template")
+		log.Println("  POST /api/v1/classify-document")
+		log.Println("  POST /api/v1/ocr")
+		log.Println("  POST /api/v1/extract")
+		log.Println("  POST /api/v1/quality-check")
+		log.Println("  GET  /api/v1/providers")
+		log.Println("  GET  /api/v1/shops/:shopid/costs")
+		log.Println("  GET  /api/v1/shops/:shopid/journal-book-rules")
+		log.Println("  PUT  /api/v1/shops/:shopid/journal-book-rules")
+		log.Println("  POST /api/v1/shops/:shopid/accounts/suggest")
+		log.Println("  POST /api/v1/shops/:shopid/templates/validate")
+		log.Println("  GET  /api/v1/shops/:shopid/template-subscriptions")
+		log.Println("  PUT  /api/v1/shops/:shopid/template-subscriptions/:library_id")
+		log.Println("  POST /api/v1/shops/:shopid/template-subscriptions/:library_id/suggest-mapping")
+		log.Println("  DEL  /api/v1/shops/:shopid/template-subscriptions/:library_id")
+		log.Println("  GET  /api/v1/template-library")
+		log.Println("  PUT  /api/v1/template-library/:library_id")
+		log.Println("  DEL  /api/v1/template-library/:library_id")
+		log.Println("  GET  /api/v1/shops/:shopid/template-coverage")
+		log.Println("  GET  /api/v1/shops/:shopid/template-suggestions")
+		log.Println("  GET  /api/v1/shops/:shopid/template-threshold-report")
+		log.Println("  GET  /api/v1/shops/:shopid/search")
+		log.Println("  GET  /api/v1/shops/:shopid/reports/input-vat")
+		log.Println("  GET  /api/v1/shops/:shopid/reports/withholding-tax")
+		log.Println("  GET  /api/v1/shops/:shopid/reports/document-sequence")
+		log.Println("  GET  /api/v1/shops/:shopid/vendor-mappings")
+		log.Println("  POST /api/v1/shops/:shopid/vendor-mappings")
+		log.Println("  DEL  /api/v1/shops/:shopid/vendor-mappings/:creditor_code")
+		log.Println("  GET  /api/v1/shops/:shopid/creditor-aliases")
+		log.Println("  POST /api/v1/shops/:shopid/creditor-aliases")
+		log.Println("  DEL  /api/v1/shops/:shopid/creditor-aliases/:alias")
+		log.Println("  GET  /api/v1/shops/:shopid/creditor-branches")
+		log.Println("  PUT  /api/v1/shops/:shopid/creditor-branches/:creditor_code/:branch_code")
+		log.Println("  DEL  /api/v1/shops/:shopid/creditor-branches/:creditor_code/:branch_code")
+		log.Println("  GET  /api/v1/shops/:shopid/dimensions")
+		log.Println("  PUT  /api/v1/shops/:shopid/dimensions/:key/:code")
+		log.Println("  DEL  /api/v1/shops/:shopid/dimensions/:key/:code")
+		log.Println("  GET  /api/v1/shops/:shopid/settings")
+		log.Println("  PUT  /api/v1/shops/:shopid/settings")
+		log.Println("  DEL  /api/v1/shops/:shopid/results")
+		log.Println("  POST /api/v1/shops/:shopid/reprocess")
+		log.Println("  GET  /api/v1/shops/:shopid/reprocess")
+		log.Println("  GET  /api/v1/shops/:shopid/reprocess/:campaign_id")
+		log.Println("  POST /api/v1/shops/:shopid/reprocess/:campaign_id/cancel")
+		log.Println("  GET  /api/v1/shops/:shopid/folder-watch")
+		log.Println("  PUT  /api/v1/shops/:shopid/folder-watch")
+		log.Println("  DEL  /api/v1/shops/:shopid/folder-watch")
+		log.Println("  GET  /api/v1/shops/:shopid/folder-watch/files")
+		log.Println("  POST /api/v1/results/:request_id/reanalyze")
+		log.Println("  GET  /api/v1/results/:request_id/traces")
+		log.Println("  GET  /api/v1/results/compare")
+		log.Println("  POST /api/v1/results/:request_id/compare-modes")
+		log.Println("  POST /api/v1/results/:request_id/approve")
+		log.Println("  GET  /api/v1/results/:request_id/bundle")
+		log.Println("  GET  /api/v1/results/:request_id/voucher")
+		log.Println("  GET  /api/v1/failed")
+		log.Println("  POST /api/v1/failed/:id/retry")
+		log.Println("  GET  /api/v1/admin/flags")
+		log.Println("  GET  /api/v1/admin/audit")
+		log.Println("  POST /api/v1/admin/cache/refresh")
+		log.Println("  GET  /api/v1/admin/tenants")
+		log.Println("  POST /api/v1/admin/shops/:shopid/suspension")
+		log.Println("  DEL  /api/v1/admin/shops/:shopid/suspension")
+		log.Println("  POST /api/v1/admin/providers/:provider/disable")
+		log.Println("  DEL  /api/v1/admin/providers/:provider/disable")
+		log.Println("  GET  /api/v1/openapi.json")
+		if configs.ENABLE_SWAGGER_UI {
+			log.Println("  GET  /api/v1/docs")
+		}
 
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
@@ -98,7 +285,20 @@ func main() {
 
 	log.Println("Shutting down server...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Phase 1: Drain - reject new analyses, let running ones finish
+	api.StartDraining()
+	drainTimeout := time.Duration(configs.SHUTDOWN_DRAIN_TIMEOUT_SEC) * time.Second
+	log.Printf("⏳ Draining %d in-flight analyses (up to %v)...", api.InFlightCount(), drainTimeout)
+	if remaining := api.WaitForInFlight(drainTimeout); remaining > 0 {
+		if saved, err := api.PersistInFlight(); err != nil {
+			log.Printf("⚠️  Failed to persist %d unfinished analyses: %v", remaining, err)
+		} else {
+			log.Printf("💾 Saved %d unfinished analyses to interruptedAnalyses", saved)
+		}
+	}
+
+	// Phase 2: Stop the HTTP server
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(configs.SHUTDOWN_TIMEOUT_SEC)*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
@@ -0,0 +1,190 @@
+// main.go - receiptctl: run the analyze-receipt pipeline locally from the command line
+//
+// ใช้สำหรับทีม support จำลองปัญหาของลูกค้า: ส่งไฟล์ในเครื่อง (รูป / PDF / ทั้งโฟลเดอร์) เข้า pipeline เดียวกับ API
+// ใช้ MongoDB ตาม config (.env) เพื่อโหลด master data ของร้าน แล้วพิมพ์ JSON response ออก stdout
+//
+//	receiptctl analyze --shop SHOP001 --file receipt.jpg --model gemini
+//	receiptctl analyze --shop SHOP001 --file ./customer-docs --dry-run   (MOCK_AI - ไม่เรียก AI จริง)
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/api"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+)
+
+// supportedExtensions are the files accepted by analyze-receipt
+var supportedExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".pdf": true}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "analyze":
+		os.Exit(runAnalyze(os.Args[2:]))
+	case "help", "-h", "--help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `receiptctl - run the receipt analysis pipeline locally
+
+Commands:
+  analyze   Analyze an image, a PDF or every image in a folder (one request, like a multi-image upload)
+
+Run "receiptctl analyze -h" for the flags.`)
+}
+
+// runAnalyze handles "receiptctl analyze" and returns the exit code (0 = HTTP 200)
+func runAnalyze(args []string) int {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	shopID := fs.String("shop", "", "shopid whose master data is used (required)")
+	file := fs.String("file", "", "image / PDF file, or a folder of them (required)")
+	model := fs.String("model", "gemini", "OCR provider: gemini or mistral")
+	dryRun := fs.Bool("dry-run", false, "use mock AI fixtures (MOCK_AI) - no AI calls, no cost")
+	rawText := fs.Bool("raw-text", false, "include the OCR text of every image (include_raw_text)")
+	quiet := fs.Bool("quiet", false, "hide pipeline logs (only the JSON result is printed)")
+	fs.Parse(args)
+
+	if *shopID == "" || *file == "" {
+		fmt.Fprintln(os.Stderr, "--shop and --file are required")
+		fs.Usage()
+		return 2
+	}
+
+	// Step 1: Collect the files
+	files, err := collectFiles(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+
+	// Step 2: Load configuration (same .env / CONFIG_FILE as the API)
+	if *quiet {
+		log.SetOutput(io.Discard)
+	}
+	configs.LoadConfig()
+	if *dryRun {
+		configs.MOCK_AI = true
+		log.Printf("🧪 --dry-run: OCR, template matching and accounting return recorded fixtures (no AI calls)")
+	}
+	if err := os.MkdirAll(configs.UPLOAD_DIR, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to create upload directory: %v\n", err)
+		return 1
+	}
+	store, err := storage.InitMongoDB()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to connect to MongoDB: %v\n", err)
+		return 1
+	}
+	defer storage.CloseMongoDB()
+	closeStore := api.InitStore(store)
+	defer closeStore()
+
+	// Step 3: Serve the local files over HTTP so the pipeline downloads them exactly like imageuri
+	fileServer, baseURL, err := serveFiles(files)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	defer fileServer.Close()
+
+	request := api.ExtractRequest{ShopID: *shopID, Model: *model, IncludeRawText: *rawText}
+	for i, path := range files {
+		request.ImageReferences = append(request.ImageReferences, api.ImageReference{
+			DocumentImageGUID: filepath.Base(path),
+			ImageURI:          fmt.Sprintf("%s/%d%s", baseURL, i, strings.ToLower(filepath.Ext(path))),
+		})
+	}
+	log.Printf("📄 Analyzing %d file(s) for shop %s (model: %s)", len(files), *shopID, *model)
+
+	// Step 4: Run the analyze-receipt handler in-process
+	payload, _ := json.Marshal(request)
+	status, body := api.RunAnalyzeReceipt(context.Background(), payload)
+
+	// Usage ledger / traces are written in the background - give them a moment before exiting
+	time.Sleep(500 * time.Millisecond)
+
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, body, "", "  ") == nil {
+		body = pretty.Bytes()
+	}
+	fmt.Println(string(body))
+	if status != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "❌ analyze-receipt returned HTTP %d\n", status)
+		return 1
+	}
+	return 0
+}
+
+// collectFiles returns the file, or the supported files of a folder in name order
+func collectFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		if !supportedExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil, fmt.Errorf("unsupported file type %s (jpg, png, pdf)", filepath.Ext(path))
+		}
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && supportedExtensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no jpg / png / pdf files in %s", path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// serveFiles serves files[i] at /<i><ext> on a loopback port (the extension gives the pipeline the content type)
+func serveFiles(files []string) (*http.Server, string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to start local file server: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	for i, path := range files {
+		mux.HandleFunc(fmt.Sprintf("/%d%s", i, strings.ToLower(filepath.Ext(path))), func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, path)
+		})
+	}
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	return server, "http://" + listener.Addr().String(), nil
+}
@@ -0,0 +1,180 @@
+// main.go - Queue worker: consume analyze jobs (QUEUE_DRIVER) and run the analyze-receipt pipeline
+//
+// ใช้แทน HTTP สำหรับงานปริมาณมาก: producer ใส่งานลง queue → worker วิเคราะห์ด้วย pipeline เดียวกับ API
+// (internal/api, ai, storage) → บันทึกผลลง MongoDB และ publish completion event
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/api"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/queue"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ratelimit"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/google/uuid"
+)
+
+// leaseMargin is added to REQUEST_TIMEOUT so a job is not re-delivered while it is still being analyzed
+const leaseMargin = 60 * time.Second
+
+func main() {
+	// Step 0: Load configuration (same .env / CONFIG_FILE as the API)
+	configs.LoadConfig()
+	configs.StartHotReload()
+	if configs.MOCK_AI {
+		log.Printf("🧪 MOCK_AI=true: OCR, template matching and accounting return recorded fixtures (no AI calls)")
+	}
+
+	// Step 1: UPLOAD_DIR + MongoDB (master data, results, usage ledger)
+	if err := os.MkdirAll(configs.UPLOAD_DIR, 0755); err != nil {
+		log.Fatalf("Failed to create upload directory: %v", err)
+	}
+	store, err := storage.InitMongoDB()
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer storage.CloseMongoDB()
+	closeStore := api.InitStore(store)
+	defer closeStore()
+	if err := storage.EnsureIndexes(); err != nil {
+		log.Printf("⚠️  Failed to ensure MongoDB indexes: %v", err)
+	}
+	api.StartRuntimeFlagSync()
+	api.StartReprocessRunner()
+	api.StartFolderWatcher()
+
+	// Step 2: One queue per priority class - bulk jobs have their own pool and never take an interactive worker
+	hostname, _ := os.Hostname()
+	workerID := fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8])
+	pools := []struct {
+		priority    string
+		concurrency int
+	}{
+		{common.PriorityInteractive, configs.WORKER_CONCURRENCY},
+		{common.PriorityBulk, configs.WORKER_BULK_CONCURRENCY},
+	}
+
+	// Step 3: Consume until SIGTERM / SIGINT - in-flight jobs finish before exit
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("🚚 Worker %s consuming %s queue (interactive: %d, bulk: %d)", workerID, configs.QUEUE_DRIVER, configs.WORKER_CONCURRENCY, configs.WORKER_BULK_CONCURRENCY)
+	var wg sync.WaitGroup
+	for _, pool := range pools {
+		if pool.concurrency == 0 {
+			continue
+		}
+		jobs, err := queue.Open(configs.QUEUE_DRIVER, workerID, pool.priority)
+		if err != nil {
+			log.Fatalf("Failed to open queue: %v", err)
+		}
+		defer jobs.Close()
+		for i := 0; i < pool.concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				consume(ctx, jobs)
+			}()
+		}
+	}
+	wg.Wait()
+	log.Println("Worker exited")
+}
+
+// consume processes jobs one at a time until ctx is cancelled
+func consume(ctx context.Context, jobs queue.Queue) {
+	pollInterval := time.Duration(configs.WORKER_POLL_INTERVAL_SEC) * time.Second
+	for ctx.Err() == nil {
+		lease := time.Duration(configs.Get().RequestTimeout)*time.Second + leaseMargin
+		job, err := jobs.Receive(ctx, lease)
+		if err != nil {
+			log.Printf("⚠️  Failed to receive job: %v", err)
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+		process(jobs, job)
+	}
+}
+
+// process runs one job and records the outcome
+// The analysis is not cancelled on shutdown - the job finishes within REQUEST_TIMEOUT
+func process(jobs queue.Queue, job *queue.Job) {
+	start := time.Now()
+	log.Printf("📄 Job %s | ShopID: %s | Priority: %s | Attempt: %d", job.ID, job.ShopID, job.Priority, job.Attempt)
+
+	status, body := api.RunAnalyzeReceipt(ratelimit.WithPriority(context.Background(), job.Priority), job.Payload)
+	result := queue.Result{
+		RequestID:  responseRequestID(body),
+		StatusCode: status,
+		Body:       body,
+	}
+	if status != http.StatusOK {
+		result.Error = responseError(body)
+		// Timeouts, rate limits and server errors are transient - 4xx payload errors are not
+		result.Retry = isRetryable(status) && job.Attempt < configs.WORKER_MAX_ATTEMPTS
+	}
+
+	if err := jobs.Complete(context.Background(), job, result); err != nil {
+		log.Printf("⚠️  Failed to complete job %s: %v", job.ID, err)
+		return
+	}
+	switch {
+	case status == http.StatusOK:
+		log.Printf("✅ Job %s done in %v (request %s)", job.ID, time.Since(start).Round(time.Millisecond), result.RequestID)
+	case result.Retry:
+		log.Printf("🔁 Job %s failed with HTTP %d - queued again (attempt %d/%d)", job.ID, status, job.Attempt, configs.WORKER_MAX_ATTEMPTS)
+	default:
+		log.Printf("❌ Job %s failed with HTTP %d: %s", job.ID, status, result.Error)
+	}
+}
+
+// isRetryable reports whether another attempt may succeed
+func isRetryable(status int) bool {
+	return status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+// responseRequestID reads request_id from a success (metadata.request_id) or error response
+func responseRequestID(body []byte) string {
+	var response struct {
+		RequestID string `json:"request_id"`
+		Metadata  struct {
+			RequestID string `json:"request_id"`
+		} `json:"metadata"`
+	}
+	if json.Unmarshal(body, &response) != nil {
+		return ""
+	}
+	if response.Metadata.RequestID != "" {
+		return response.Metadata.RequestID
+	}
+	return response.RequestID
+}
+
+// responseError reads the error message of a failed response
+func responseError(body []byte) string {
+	var response api.ErrorResponse
+	if json.Unmarshal(body, &response) != nil {
+		return string(body)
+	}
+	if response.Message != "" {
+		return response.Error + ": " + response.Message
+	}
+	return response.Error
+}
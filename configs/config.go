@@ -1,35 +1,285 @@
-// config.go - Configuration loaded from environment variables
+// config.go - Typed configuration: defaults → environment (.env) → YAML file (CONFIG_FILE)
+//
+// ค่าทั้งหมดอยู่ใน Config (อ่านผ่าน Get()) และถูกตรวจสอบตอนเริ่มระบบ (ค่าผิด = หยุดทันที)
+// ค่าที่มี tag reload:"true" เปลี่ยนได้โดยแก้ YAML file (หรือส่ง SIGHUP) ไม่ต้อง restart - ดู reload.go
+// ตัวแปร global ด้านล่าง (OCR_PROVIDER, PORT, ...) เป็นค่าที่ใช้ตอนเริ่มระบบ อ่านอย่างเดียวหลัง LoadConfig
 
 package configs
 
 import (
+	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/goccy/go-yaml"
 	"github.com/joho/godotenv"
 )
 
-var (
-	// OCR Provider Configuration
-	OCR_PROVIDER string // "gemini" or "mistral"
+// Config holds every setting of the service
+// env = environment variable, yaml = key in CONFIG_FILE, default = value when neither is set
+// reload:"true" = applied without restart when the YAML file changes (read via Get() at use time)
+type Config struct {
+	// OCR Provider / API keys
+	OCRProvider      string `env:"OCR_PROVIDER" yaml:"ocr_provider" default:"gemini"`
+	GeminiAPIKey     string `env:"GEMINI_API_KEY" yaml:"gemini_api_key"`
+	MistralAPIKey    string `env:"MISTRAL_API_KEY" yaml:"mistral_api_key"`
+	MistralModelName string `env:"MISTRAL_MODEL_NAME" yaml:"mistral_model_name" default:"mistral-ocr-latest"`
+
+	// Phase-specific models
+	OCRModelName                string `env:"OCR_MODEL_NAME" yaml:"ocr_model_name" default:"gemini-2.5-flash-lite"`
+	TemplateModelName           string `env:"TEMPLATE_MODEL_NAME" yaml:"template_model_name" default:"gemini-2.5-flash-lite"`
+	TemplateAccountingModelName string `env:"TEMPLATE_ACCOUNTING_MODEL_NAME" yaml:"template_accounting_model_name" default:"gemini-2.5-flash-lite"`
+	AccountingModelName         string `env:"ACCOUNTING_MODEL_NAME" yaml:"accounting_model_name" default:"gemini-2.5-flash"`
+
+	// Template matching
+	TemplateConfidenceThreshold            float64 `env:"TEMPLATE_CONFIDENCE_THRESHOLD" yaml:"template_confidence_threshold" default:"95" reload:"true"`
+	HandwrittenTemplateConfidenceThreshold float64 `env:"HANDWRITTEN_TEMPLATE_CONFIDENCE_THRESHOLD" yaml:"handwritten_template_confidence_threshold" default:"85" reload:"true"`
+
+	// Exchange rate
+	USDToTHB float64 `env:"USD_TO_THB" yaml:"usd_to_thb" default:"36" reload:"true"`
+
+	// VAT rate (percent) - shops can override the rate of their own sales with settings.vatrate
+	VATRate        float64 `env:"VAT_RATE" yaml:"vat_rate" default:"7" reload:"true"`     // Current rate
+	VATRateHistory string  `env:"VAT_RATE_HISTORY" yaml:"vat_rate_history" reload:"true"` // "YYYY-MM-DD=rate,..." = rate of documents dated before that date
+
+	// Server
+	Port      string `env:"PORT" yaml:"port" default:"8080"`
+	UploadDir string `env:"UPLOAD_DIR" yaml:"upload_dir" default:"uploads"`
+
+	// CORS (reload) - origins: "*" or a comma-separated list, "https://*.example.com" = any subdomain
+	// Route methods default to the methods registered on the route; CORS_ROUTE_METHODS="/path=GET|POST,..." narrows them
+	AllowedOrigins       string `env:"ALLOWED_ORIGINS" yaml:"allowed_origins" default:"*" reload:"true"`
+	CORSAllowCredentials bool   `env:"CORS_ALLOW_CREDENTIALS" yaml:"cors_allow_credentials" default:"false" reload:"true"`
+	CORSAllowedHeaders   string `env:"CORS_ALLOWED_HEADERS" yaml:"cors_allowed_headers" default:"Content-Type, Authorization, Idempotency-Key, X-Tenant-ID" reload:"true"`
+	CORSExposedHeaders   string `env:"CORS_EXPOSED_HEADERS" yaml:"cors_exposed_headers" default:"Content-Disposition, Retry-After, Idempotent-Replayed, Request-Coalesced, Deprecation, Sunset, Link, X-Uncompressed-Content-Length" reload:"true"`
+	CORSMaxAge           int    `env:"CORS_MAX_AGE" yaml:"cors_max_age" default:"86400" reload:"true"` // Seconds browsers cache a preflight
+	CORSRouteMethods     string `env:"CORS_ROUTE_METHODS" yaml:"cors_route_methods" reload:"true"`
+
+	// Response compression - gzip for clients sending Accept-Encoding: gzip (responses of at least MIN_BYTES)
+	ResponseCompression         bool `env:"RESPONSE_COMPRESSION" yaml:"response_compression" default:"true" reload:"true"`
+	ResponseCompressionMinBytes int  `env:"RESPONSE_COMPRESSION_MIN_BYTES" yaml:"response_compression_min_bytes" default:"1024" reload:"true"`
+
+	// Graceful shutdown
+	ShutdownDrainTimeoutSec int `env:"SHUTDOWN_DRAIN_TIMEOUT_SEC" yaml:"shutdown_drain_timeout_sec" default:"240"`
+	ShutdownTimeoutSec      int `env:"SHUTDOWN_TIMEOUT_SEC" yaml:"shutdown_timeout_sec" default:"30"`
+
+	// Idempotency
+	IdempotencyTTLHours int  `env:"IDEMPOTENCY_TTL_HOURS" yaml:"idempotency_ttl_hours" default:"24"`
+	RequestCoalescing   bool `env:"REQUEST_COALESCING" yaml:"request_coalescing" default:"true" reload:"true"` // Identical concurrent analyze requests (same shop + payload) share one analysis
+
+	// Mock AI
+	MockAI            bool   `env:"MOCK_AI" yaml:"mock_ai" default:"false"`
+	MockAIFixturesDir string `env:"MOCK_AI_FIXTURES_DIR" yaml:"mock_ai_fixtures_dir"`
+
+	// Pipeline features
+	EnableFieldVerification   bool    `env:"ENABLE_FIELD_VERIFICATION" yaml:"enable_field_verification" default:"false" reload:"true"`
+	EnableQRDecoding          bool    `env:"ENABLE_QR_DECODING" yaml:"enable_qr_decoding" default:"true" reload:"true"`
+	EnableHandwritingMode     bool    `env:"ENABLE_HANDWRITING_MODE" yaml:"enable_handwriting_mode" default:"true" reload:"true"`
+	EnableDocumentClustering  bool    `env:"ENABLE_DOCUMENT_CLUSTERING" yaml:"enable_document_clustering" default:"true" reload:"true"`
+	EnableReceiptStitching    bool    `env:"ENABLE_RECEIPT_STITCHING" yaml:"enable_receipt_stitching" default:"false" reload:"true"`
+	ThumbnailMaxDimension     int     `env:"THUMBNAIL_MAX_DIMENSION" yaml:"thumbnail_max_dimension" default:"320" reload:"true"`
+	EnableFixedAssetDetection bool    `env:"ENABLE_FIXED_ASSET_DETECTION" yaml:"enable_fixed_asset_detection" default:"true" reload:"true"`
+	FixedAssetThreshold       float64 `env:"FIXED_ASSET_THRESHOLD" yaml:"fixed_asset_threshold" default:"5000" reload:"true"`
+	SafetyBlockFallback       bool    `env:"SAFETY_BLOCK_FALLBACK" yaml:"safety_block_fallback" default:"true" reload:"true"`
+
+	// Slip verification
+	SlipVerifyAPIURL     string `env:"SLIP_VERIFY_API_URL" yaml:"slip_verify_api_url"`
+	SlipVerifyAPIKey     string `env:"SLIP_VERIFY_API_KEY" yaml:"slip_verify_api_key"`
+	SlipVerifyTimeoutSec int    `env:"SLIP_VERIFY_TIMEOUT_SEC" yaml:"slip_verify_timeout_sec" default:"10"`
+
+	// Malware scanning of downloaded / uploaded files before processing ("" = off, "clamav" = clamd INSTREAM, "http" = scanning API)
+	FileScanDriver     string `env:"FILE_SCAN_DRIVER" yaml:"file_scan_driver"`
+	ClamAVAddress      string `env:"CLAMAV_ADDRESS" yaml:"clamav_address" default:"tcp://localhost:3310"` // tcp://host:port or unix:///path/clamd.ctl
+	FileScanAPIURL     string `env:"FILE_SCAN_API_URL" yaml:"file_scan_api_url"`
+	FileScanAPIKey     string `env:"FILE_SCAN_API_KEY" yaml:"file_scan_api_key"`
+	FileScanTimeoutSec int    `env:"FILE_SCAN_TIMEOUT_SEC" yaml:"file_scan_timeout_sec" default:"30" reload:"true"`
+	FileScanFailOpen   bool   `env:"FILE_SCAN_FAIL_OPEN" yaml:"file_scan_fail_open" default:"false" reload:"true"` // Scanner unavailable → continue (recorded as error) instead of 503
+
+	// Strip EXIF / text metadata (GPS, device) from JPEG and PNG before storage or OCR - orientation is applied to the pixels
+	StripImageMetadata bool `env:"STRIP_IMAGE_METADATA" yaml:"strip_image_metadata" default:"true" reload:"true"`
+
+	// Template suggestions
+	TemplateSuggestionMinDocuments int `env:"TEMPLATE_SUGGESTION_MIN_DOCUMENTS" yaml:"template_suggestion_min_documents" default:"3" reload:"true"`
+	TemplateSuggestionLookbackDays int `env:"TEMPLATE_SUGGESTION_LOOKBACK_DAYS" yaml:"template_suggestion_lookback_days" default:"90" reload:"true"`
+
+	// Document number sequence checks (duplicate / missing invoice numbers per creditor)
+	DocumentSequenceHistory int `env:"DOCUMENT_SEQUENCE_HISTORY" yaml:"document_sequence_history" default:"200" reload:"true"` // Previous documents of the creditor compared on new analyses (0 = off)
+	DocumentSequenceMaxGap  int `env:"DOCUMENT_SEQUENCE_MAX_GAP" yaml:"document_sequence_max_gap" default:"3" reload:"true"`   // Larger jumps = the vendor's other customers (0 = duplicates only)
+
+	// Phase 3 chart-of-accounts pre-filter (full mode) - top-N accounts by similarity to the OCR text + essential accounts
+	AccountPrefilterTopN        int `env:"ACCOUNT_PREFILTER_TOP_N" yaml:"account_prefilter_top_n" default:"60" reload:"true"`                // 0 = always send every posting account
+	AccountPrefilterMinAccounts int `env:"ACCOUNT_PREFILTER_MIN_ACCOUNTS" yaml:"account_prefilter_min_accounts" default:"150" reload:"true"` // Smaller charts are sent in full
+
+	// Phase 3 creditor pre-filter - top-K creditors whose name matches the OCR text + the pre-matched vendor
+	CreditorPrefilterTopK     int     `env:"CREDITOR_PREFILTER_TOP_K" yaml:"creditor_prefilter_top_k" default:"20" reload:"true"`         // 0 = always send every creditor
+	CreditorPrefilterMinScore float64 `env:"CREDITOR_PREFILTER_MIN_SCORE" yaml:"creditor_prefilter_min_score" default:"70" reload:"true"` // 0-100, no candidate above it = full list
+
+	// Self-invoice guard - vendor_tax_id is the shop's own tax ID (the shop's sales invoice read as a purchase)
+	SelfInvoiceAction string `env:"SELF_INVOICE_ACTION" yaml:"self_invoice_action" default:"flag" reload:"true"` // flag = remove creditor + requires_review, reject = 422 self_invoice
+
+	// Balance correction - suggested fix of small debit/credit differences (rounding) in validation.balance_correction
+	BalanceCorrectionMaxDifference float64 `env:"BALANCE_CORRECTION_MAX_DIFFERENCE" yaml:"balance_correction_max_difference" default:"5" reload:"true"` // Larger differences get no suggestion (0 = off)
+
+	// Re-analysis
+	OCRResultTTLDays int `env:"OCR_RESULT_TTL_DAYS" yaml:"ocr_result_ttl_days" default:"30"`
+
+	// Dead-letter store (0 = failures are not stored)
+	FailedRequestTTLDays int `env:"FAILED_REQUEST_TTL_DAYS" yaml:"failed_request_ttl_days" default:"14"`
+
+	// Readiness probe
+	ReadinessCheckProviders       bool `env:"READINESS_CHECK_PROVIDERS" yaml:"readiness_check_providers" default:"false"`
+	ReadinessProviderCacheSeconds int  `env:"READINESS_PROVIDER_CACHE_SECONDS" yaml:"readiness_provider_cache_seconds" default:"300"`
+
+	// AI traces
+	EnableAITraces bool `env:"ENABLE_AI_TRACES" yaml:"enable_ai_traces" default:"false" reload:"true"`
+	AITraceTTLDays int  `env:"AI_TRACE_TTL_DAYS" yaml:"ai_trace_ttl_days" default:"7"`
+
+	// Admin API (empty key = admin endpoints disabled)
+	AdminAPIKey            string `env:"ADMIN_API_KEY" yaml:"admin_api_key"`
+	RuntimeFlagsRefreshSec int    `env:"RUNTIME_FLAGS_REFRESH_SEC" yaml:"runtime_flags_refresh_sec" default:"15"`
+
+	// Access control (shop keys: "shopid=key,shopid=key")
+	APIAuthRequired bool   `env:"API_AUTH_REQUIRED" yaml:"api_auth_required" default:"false"`
+	ShopAPIKeys     string `env:"SHOP_API_KEYS" yaml:"shop_api_keys"`
+
+	// Audit log (0 = API calls are not recorded)
+	AuditLogTTLDays int `env:"AUDIT_LOG_TTL_DAYS" yaml:"audit_log_ttl_days" default:"365"`
+
+	// Data retention (PDPA) - 0 = no global purge (collection TTLs still apply)
+	DataRetentionDays         int `env:"DATA_RETENTION_DAYS" yaml:"data_retention_days" default:"0"`
+	RetentionPurgeIntervalMin int `env:"RETENTION_PURGE_INTERVAL_MIN" yaml:"retention_purge_interval_min" default:"60"`
+
+	// Re-processing campaigns (POST /api/v1/shops/:shopid/reprocess) - 0 = this instance does not run campaigns
+	ReprocessPollIntervalSec int `env:"REPROCESS_POLL_INTERVAL_SEC" yaml:"reprocess_poll_interval_sec" default:"10"`
+	ReprocessMaxDocuments    int `env:"REPROCESS_MAX_DOCUMENTS" yaml:"reprocess_max_documents" default:"1000" reload:"true"`
+
+	// Zip archive input (POST /api/v1/analyze-zip) - every supported file is analyzed as its own document
+	ZipMaxBytes int `env:"ZIP_MAX_BYTES" yaml:"zip_max_bytes" default:"104857600" reload:"true"` // Uploaded / downloaded archive
+	ZipMaxFiles int `env:"ZIP_MAX_FILES" yaml:"zip_max_files" default:"30" reload:"true"`        // Documents per archive
+	// Bytes extracted from one archive in total (zip bomb guard - always on, also when IMAGE_REJECT_MAX_BYTES=0)
+	ZipMaxExtractedBytes int `env:"ZIP_MAX_EXTRACTED_BYTES" yaml:"zip_max_extracted_bytes" default:"524288000" reload:"true"`
+
+	// Folder watcher (Google Drive / Dropbox drop folders per shop) - 0 = this instance does not poll folders
+	FolderWatchPollIntervalSec int    `env:"FOLDER_WATCH_POLL_INTERVAL_SEC" yaml:"folder_watch_poll_interval_sec" default:"60"`
+	FolderWatchMaxFiles        int    `env:"FOLDER_WATCH_MAX_FILES" yaml:"folder_watch_max_files" default:"20" reload:"true"` // Files per folder per poll
+	DropboxAppKey              string `env:"DROPBOX_APP_KEY" yaml:"dropbox_app_key"`
+	DropboxAppSecret           string `env:"DROPBOX_APP_SECRET" yaml:"dropbox_app_secret"`
+	GoogleDriveClientID        string `env:"GOOGLE_DRIVE_CLIENT_ID" yaml:"google_drive_client_id"`
+	GoogleDriveClientSecret    string `env:"GOOGLE_DRIVE_CLIENT_SECRET" yaml:"google_drive_client_secret"`
+
+	// Printable PDFs (result bundle) - TrueType font with Thai glyphs, e.g. Sarabun-Regular.ttf ("" = Go font, no Thai)
+	PDFFontPath     string `env:"PDF_FONT_PATH" yaml:"pdf_font_path"`
+	PDFFontBoldPath string `env:"PDF_FONT_BOLD_PATH" yaml:"pdf_font_bold_path"` // "" = PDF_FONT_PATH
+
+	// API deprecation - "path=YYYY-MM-DD,..." adds Deprecation / Sunset headers to those routes (e.g. /api/v1/analyze-receipt=2027-06-30)
+	APIDeprecations   string `env:"API_DEPRECATIONS" yaml:"api_deprecations" reload:"true"`
+	APISunsetEnforced bool   `env:"API_SUNSET_ENFORCED" yaml:"api_sunset_enforced" default:"false" reload:"true"` // true = 410 Gone after the sunset date
+
+	// Queue worker (cmd/worker)
+	QueueDriver           string `env:"QUEUE_DRIVER" yaml:"queue_driver" default:"mongodb"`
+	WorkerConcurrency     int    `env:"WORKER_CONCURRENCY" yaml:"worker_concurrency" default:"2"`
+	WorkerPollIntervalSec int    `env:"WORKER_POLL_INTERVAL_SEC" yaml:"worker_poll_interval_sec" default:"2"`
+	WorkerMaxAttempts     int    `env:"WORKER_MAX_ATTEMPTS" yaml:"worker_max_attempts" default:"3"`
+	WorkerBulkConcurrency int    `env:"WORKER_BULK_CONCURRENCY" yaml:"worker_bulk_concurrency" default:"1"` // Separate pool for priority "bulk" jobs (0 = bulk jobs are not consumed)
+
+	// Priority classes (interactive / bulk)
+	BulkRateShare         float64 `env:"BULK_RATE_SHARE" yaml:"bulk_rate_share" default:"0.5" reload:"true"`                 // Share of the Gemini rate-limit burst bulk analyses may use (rest reserved for interactive)
+	InteractiveMaxPerShop int     `env:"INTERACTIVE_MAX_PER_SHOP" yaml:"interactive_max_per_shop" default:"5" reload:"true"` // Concurrent interactive analyses per shop - more run as bulk (0 = no limit)
+
+	// API documentation
+	EnableSwaggerUI bool `env:"ENABLE_SWAGGER_UI" yaml:"enable_swagger_ui" default:"false"`
+
+	// MongoDB
+	MongoURI    string `env:"MONGO_URI" yaml:"mongo_uri" default:"mongodb://localhost:27017"`
+	MongoDBName string `env:"MONGO_DB_NAME" yaml:"mongo_db_name" default:"your_database_name"`
+
+	// Multi-tenant routing (routing table = collection tenantRoutes in MONGO_DB_NAME)
+	TenantRoutingEnabled   bool `env:"TENANT_ROUTING_ENABLED" yaml:"tenant_routing_enabled" default:"false"`
+	TenantRoutesRefreshSec int  `env:"TENANT_ROUTES_REFRESH_SEC" yaml:"tenant_routes_refresh_sec" default:"60"`
+	TenantMaxPoolSize      int  `env:"TENANT_MAX_POOL_SIZE" yaml:"tenant_max_pool_size" default:"20"`
+
+	// Image processing
+	EnableImagePreprocessing bool `env:"ENABLE_IMAGE_PREPROCESSING" yaml:"enable_image_preprocessing" default:"true"`
+	MaxImageDimension        int  `env:"MAX_IMAGE_DIMENSION" yaml:"max_image_dimension" default:"2000"`
+
+	// Image payload guard (before every Gemini call) - larger images are recompressed / downscaled, absurd files rejected (0 = off)
+	ImagePayloadMaxBytes     int `env:"IMAGE_PAYLOAD_MAX_BYTES" yaml:"image_payload_max_bytes" default:"4194304" reload:"true"`
+	ImagePayloadMaxTokens    int `env:"IMAGE_PAYLOAD_MAX_TOKENS" yaml:"image_payload_max_tokens" default:"4128" reload:"true"` // Estimated tokens per image (258 per 768px tile)
+	ImageRejectMaxBytes      int `env:"IMAGE_REJECT_MAX_BYTES" yaml:"image_reject_max_bytes" default:"31457280" reload:"true"`
+	ImageRejectMaxMegapixels int `env:"IMAGE_REJECT_MAX_MEGAPIXELS" yaml:"image_reject_max_megapixels" default:"100" reload:"true"`
+
+	// Request size limits (413 payload_too_large, 0 = no limit) - each downloaded image is also capped at IMAGE_REJECT_MAX_BYTES
+	// REQUEST_BODY_LIMITS="/path=bytes,..." overrides the body limit of a route (gin paths); analyze-zip uploads use ZIP_MAX_BYTES
+	MaxRequestBodyBytes  int    `env:"MAX_REQUEST_BODY_BYTES" yaml:"max_request_body_bytes" default:"1048576" reload:"true"`     // JSON bodies
+	MaxUploadBodyBytes   int    `env:"MAX_UPLOAD_BODY_BYTES" yaml:"max_upload_body_bytes" default:"33554432" reload:"true"`      // multipart/form-data uploads
+	MaxImagesPerRequest  int    `env:"MAX_IMAGES_PER_REQUEST" yaml:"max_images_per_request" default:"20" reload:"true"`          // imagereferences of one analysis
+	MaxTotalPayloadBytes int    `env:"MAX_TOTAL_PAYLOAD_BYTES" yaml:"max_total_payload_bytes" default:"104857600" reload:"true"` // Downloaded images of one request
+	RequestBodyLimits    string `env:"REQUEST_BODY_LIMITS" yaml:"request_body_limits" reload:"true"`
+
+	// Output token limits & chunked OCR
+	OCRMaxOutputTokens   int    `env:"OCR_MAX_OUTPUT_TOKENS" yaml:"ocr_max_output_tokens" default:"8192"`
+	ModelMaxOutputTokens string `env:"MODEL_MAX_OUTPUT_TOKENS" yaml:"model_max_output_tokens"` // "model=tokens,model=tokens"
+	EnableChunkedOCR     bool   `env:"ENABLE_CHUNKED_OCR" yaml:"enable_chunked_ocr" default:"true"`
+	OCRChunkCount        int    `env:"OCR_CHUNK_COUNT" yaml:"ocr_chunk_count" default:"3"`
+
+	// Performance
+	EnableQuickOCR     bool `env:"ENABLE_QUICK_OCR" yaml:"enable_quick_ocr" default:"false"`
+	QuickOCRTimeout    int  `env:"QUICK_OCR_TIMEOUT" yaml:"quick_ocr_timeout" default:"30"`
+	FullOCRTimeout     int  `env:"FULL_OCR_TIMEOUT" yaml:"full_ocr_timeout" default:"120" reload:"true"` // Per image (seconds)
+	AccountingTimeout  int  `env:"ACCOUNTING_TIMEOUT" yaml:"accounting_timeout" default:"180" reload:"true"`
+	ParallelProcessing bool `env:"PARALLEL_PROCESSING" yaml:"parallel_processing" default:"true"`
+	UseSmallerModel    bool `env:"USE_SMALLER_MODEL" yaml:"use_smaller_model" default:"false"`
+
+	// Request / phase deadlines (seconds, derived from the request context - a phase never outlives the request)
+	RequestTimeout       int `env:"REQUEST_TIMEOUT" yaml:"request_timeout" default:"300" reload:"true"`
+	DownloadTimeout      int `env:"DOWNLOAD_TIMEOUT" yaml:"download_timeout" default:"60" reload:"true"`
+	TemplateMatchTimeout int `env:"TEMPLATE_MATCH_TIMEOUT" yaml:"template_match_timeout" default:"45" reload:"true"`
+	MinProcessingSeconds int `env:"MIN_PROCESSING_SECONDS" yaml:"min_processing_seconds" default:"30" reload:"true"` // Lower bound of max_processing_seconds (upper bound = REQUEST_TIMEOUT)
+
+	// Complexity pre-check - estimated seconds = base + per image (before OCR) / + per 1,000 OCR characters (after OCR)
+	// Above the time limit → 422 too_complex with a split suggestion (before spending more tokens)
+	EnableComplexityCheck       bool    `env:"ENABLE_COMPLEXITY_CHECK" yaml:"enable_complexity_check" default:"true" reload:"true"`
+	ComplexityBaseSeconds       float64 `env:"COMPLEXITY_BASE_SECONDS" yaml:"complexity_base_seconds" default:"20" reload:"true"`
+	ComplexitySecondsPerImage   float64 `env:"COMPLEXITY_SECONDS_PER_IMAGE" yaml:"complexity_seconds_per_image" default:"25" reload:"true"`
+	ComplexitySecondsPer1KChars float64 `env:"COMPLEXITY_SECONDS_PER_1K_CHARS" yaml:"complexity_seconds_per_1k_chars" default:"4" reload:"true"`
+	ComplexityWarnRatio         float64 `env:"COMPLEXITY_WARN_RATIO" yaml:"complexity_warn_ratio" default:"0.8" reload:"true"`
+
+	// Config file hot reload
+	ConfigReloadIntervalSec int `env:"CONFIG_RELOAD_INTERVAL_SEC" yaml:"config_reload_interval_sec" default:"30"`
+}
+
+// current is the active configuration (replaced atomically on reload)
+var current atomic.Pointer[Config]
 
-	// Gemini AI Configuration
-	GEMINI_API_KEY string
+// Get returns the active configuration - read tunables through it at use time so reloads apply
+func Get() *Config {
+	if cfg := current.Load(); cfg != nil {
+		return cfg
+	}
+	return &Config{}
+}
 
-	// Mistral AI Configuration
-	MISTRAL_API_KEY    string
-	MISTRAL_MODEL_NAME string
+// configFile is the YAML file given by CONFIG_FILE (empty = env only)
+var configFile string
 
-	// Phase-specific Model Configuration
+var (
+	// Startup settings (read-only after LoadConfig - changing them needs a restart)
+	OCR_PROVIDER                   string // "gemini" or "mistral"
+	GEMINI_API_KEY                 string
+	MISTRAL_API_KEY                string
+	MISTRAL_MODEL_NAME             string
 	OCR_MODEL_NAME                 string
 	TEMPLATE_MODEL_NAME            string
 	TEMPLATE_ACCOUNTING_MODEL_NAME string // For template-only mode (high confidence)
 	ACCOUNTING_MODEL_NAME          string // For full analysis mode (low confidence)
 
-	// Template Matching Configuration
-	TEMPLATE_CONFIDENCE_THRESHOLD float64 // Minimum confidence to use template-only mode (default: 95%)
-
 	// Gemini Pricing Configuration (hardcoded based on official Gemini API pricing)
 	// Gemini 2.5 Flash-Lite: $0.10 input, $0.40 output per 1M tokens
 	// Gemini 2.5 Flash: $0.30 input, $2.50 output per 1M tokens
@@ -42,28 +292,63 @@ var (
 	ACCOUNTING_INPUT_PRICE_PER_MILLION           = 0.30
 	ACCOUNTING_OUTPUT_PRICE_PER_MILLION          = 2.50
 
-	USD_TO_THB float64 // Exchange rate from .env
-
-	// Server Configuration
-	PORT            string
-	UPLOAD_DIR      string
-	ALLOWED_ORIGINS string
-
-	// MongoDB Configuration
-	MONGO_URI     string
-	MONGO_DB_NAME string
-
-	// Image preprocessing settings
-	ENABLE_IMAGE_PREPROCESSING bool
-	MAX_IMAGE_DIMENSION        int
-
-	// Performance optimization settings
-	ENABLE_QUICK_OCR    bool // Enable/disable quick OCR phase (can skip to save time)
-	QUICK_OCR_TIMEOUT   int  // Timeout for quick OCR in seconds
-	FULL_OCR_TIMEOUT    int  // Timeout for full OCR in seconds
-	ACCOUNTING_TIMEOUT  int  // Timeout for accounting analysis in seconds
-	PARALLEL_PROCESSING bool // Enable parallel image processing
-	USE_SMALLER_MODEL   bool // Use smaller/faster model when speed is priority
+	PORT                             string
+	UPLOAD_DIR                       string
+	SHUTDOWN_DRAIN_TIMEOUT_SEC       int
+	SHUTDOWN_TIMEOUT_SEC             int
+	IDEMPOTENCY_TTL_HOURS            int
+	MOCK_AI                          bool
+	MOCK_AI_FIXTURES_DIR             string
+	SLIP_VERIFY_API_URL              string
+	SLIP_VERIFY_API_KEY              string
+	SLIP_VERIFY_TIMEOUT_SEC          int
+	FILE_SCAN_DRIVER                 string
+	CLAMAV_ADDRESS                   string
+	FILE_SCAN_API_URL                string
+	FILE_SCAN_API_KEY                string
+	OCR_RESULT_TTL_DAYS              int
+	FAILED_REQUEST_TTL_DAYS          int
+	READINESS_CHECK_PROVIDERS        bool
+	READINESS_PROVIDER_CACHE_SECONDS int
+	AI_TRACE_TTL_DAYS                int
+	ADMIN_API_KEY                    string
+	RUNTIME_FLAGS_REFRESH_SEC        int
+	API_AUTH_REQUIRED                bool
+	SHOP_API_KEYS                    map[string]string // shopid → key, parsed from Config.ShopAPIKeys
+	AUDIT_LOG_TTL_DAYS               int
+	DATA_RETENTION_DAYS              int
+	RETENTION_PURGE_INTERVAL_MIN     int
+	REPROCESS_POLL_INTERVAL_SEC      int
+	FOLDER_WATCH_POLL_INTERVAL_SEC   int
+	DROPBOX_APP_KEY                  string
+	DROPBOX_APP_SECRET               string
+	GOOGLE_DRIVE_CLIENT_ID           string
+	GOOGLE_DRIVE_CLIENT_SECRET       string
+	PDF_FONT_PATH                    string
+	PDF_FONT_BOLD_PATH               string
+	QUEUE_DRIVER                     string
+	WORKER_CONCURRENCY               int
+	WORKER_POLL_INTERVAL_SEC         int
+	WORKER_MAX_ATTEMPTS              int
+	WORKER_BULK_CONCURRENCY          int
+	ENABLE_SWAGGER_UI                bool
+	MONGO_URI                        string
+	MONGO_DB_NAME                    string
+	TENANT_ROUTING_ENABLED           bool
+	TENANT_ROUTES_REFRESH_SEC        int
+	TENANT_MAX_POOL_SIZE             int
+	ENABLE_IMAGE_PREPROCESSING       bool
+	MAX_IMAGE_DIMENSION              int
+	OCR_MAX_OUTPUT_TOKENS            int
+	MODEL_MAX_OUTPUT_TOKENS          map[string]int // Parsed from Config.ModelMaxOutputTokens
+	ENABLE_CHUNKED_OCR               bool
+	OCR_CHUNK_COUNT                  int
+	ENABLE_QUICK_OCR                 bool
+	QUICK_OCR_TIMEOUT                int
+	FULL_OCR_TIMEOUT                 int
+	ACCOUNTING_TIMEOUT               int
+	PARALLEL_PROCESSING              bool
+	USE_SMALLER_MODEL                bool
 
 	// Confidence threshold settings for validation
 	CONFIDENCE_HIGH_THRESHOLD   = "high"   // AI is very confident
@@ -71,100 +356,497 @@ var (
 	CONFIDENCE_LOW_THRESHOLD    = "low"    // AI is uncertain, requires review
 )
 
-// LoadConfig loads configuration from environment variables
+// LoadConfig loads, validates and activates the configuration (exits on invalid values)
 func LoadConfig() {
 	// Load .env file if exists (for local development)
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
 	}
+	configFile = os.Getenv("CONFIG_FILE")
 
-	// OCR Provider Selection
-	OCR_PROVIDER = getEnv("OCR_PROVIDER", "gemini")
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
+	current.Store(cfg)
+	applyStartupSettings(cfg)
 
-	// Gemini API Key
-	GEMINI_API_KEY = getEnv("GEMINI_API_KEY", "")
+	if configFile != "" {
+		log.Printf("✓ Configuration loaded successfully (env + %s)", configFile)
+		return
+	}
+	log.Println("✓ Configuration loaded successfully")
+}
 
-	// Mistral API Configuration
-	MISTRAL_API_KEY = getEnv("MISTRAL_API_KEY", "")
-	MISTRAL_MODEL_NAME = getEnv("MISTRAL_MODEL_NAME", "mistral-ocr-latest")
+// loadConfig builds a Config from defaults, environment and the optional YAML file, then validates it
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	var problems []string
+
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		raw, source := field.Tag.Get("default"), "default"
+		if value := os.Getenv(field.Tag.Get("env")); value != "" {
+			raw, source = value, field.Tag.Get("env")
+		}
+		if raw == "" {
+			continue
+		}
+		if err := setField(v.Field(i), raw); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", source, err))
+		}
+	}
 
-	// Validate API keys based on provider
-	if OCR_PROVIDER == "gemini" && GEMINI_API_KEY == "" {
-		log.Fatal("GEMINI_API_KEY is required when OCR_PROVIDER=gemini")
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.UnmarshalWithOptions(data, cfg, yaml.Strict()); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
 	}
-	if OCR_PROVIDER == "mistral" && MISTRAL_API_KEY == "" {
-		log.Fatal("MISTRAL_API_KEY is required when OCR_PROVIDER=mistral")
+
+	problems = append(problems, cfg.Validate()...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
 	}
+	return cfg, nil
+}
 
-	// Phase-specific models (customizable via .env)
-	OCR_MODEL_NAME = getEnv("OCR_MODEL_NAME", "gemini-2.5-flash-lite")
-	TEMPLATE_MODEL_NAME = getEnv("TEMPLATE_MODEL_NAME", "gemini-2.5-flash-lite")
-	TEMPLATE_ACCOUNTING_MODEL_NAME = getEnv("TEMPLATE_ACCOUNTING_MODEL_NAME", "gemini-2.5-flash-lite")
-	ACCOUNTING_MODEL_NAME = getEnv("ACCOUNTING_MODEL_NAME", "gemini-2.5-flash")
+// setField parses raw into a string / bool / int / float64 field
+func setField(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		field.SetBool(parsed)
+	case reflect.Int:
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		field.SetInt(int64(parsed))
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		field.SetFloat(parsed)
+	default:
+		return fmt.Errorf("unsupported config field type %s", field.Kind())
+	}
+	return nil
+}
 
-	// Pricing is hardcoded based on official Gemini API rates
-	// No need to configure in .env - automatically matches model selection
+// Validate returns every problem of the configuration (empty = valid)
+func (c *Config) Validate() []string {
+	var problems []string
+	switch c.FileScanDriver {
+	case "":
+	case "clamav":
+		if !strings.HasPrefix(c.ClamAVAddress, "tcp://") && !strings.HasPrefix(c.ClamAVAddress, "unix://") {
+			problems = append(problems, fmt.Sprintf("CLAMAV_ADDRESS must be tcp://host:port or unix:///path (got %q)", c.ClamAVAddress))
+		}
+	case "http":
+		if u, err := url.Parse(c.FileScanAPIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			problems = append(problems, "FILE_SCAN_DRIVER=http needs FILE_SCAN_API_URL (http:// or https://)")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("FILE_SCAN_DRIVER must be clamav, http or empty (got %q)", c.FileScanDriver))
+	}
+	if c.OCRProvider != "gemini" && c.OCRProvider != "mistral" {
+		problems = append(problems, fmt.Sprintf("OCR_PROVIDER must be gemini or mistral (got %q)", c.OCRProvider))
+	}
+	if !c.MockAI {
+		// Template matching and accounting always use Gemini
+		if c.GeminiAPIKey == "" {
+			problems = append(problems, "GEMINI_API_KEY is required")
+		}
+		if c.OCRProvider == "mistral" && c.MistralAPIKey == "" {
+			problems = append(problems, "MISTRAL_API_KEY is required when OCR_PROVIDER=mistral")
+		}
+	}
+	for name, threshold := range map[string]float64{
+		"TEMPLATE_CONFIDENCE_THRESHOLD":             c.TemplateConfidenceThreshold,
+		"HANDWRITTEN_TEMPLATE_CONFIDENCE_THRESHOLD": c.HandwrittenTemplateConfidenceThreshold,
+		"CREDITOR_PREFILTER_MIN_SCORE":              c.CreditorPrefilterMinScore,
+		"VAT_RATE":                                  c.VATRate,
+	} {
+		if threshold < 0 || threshold > 100 {
+			problems = append(problems, fmt.Sprintf("%s must be between 0 and 100 (got %g)", name, threshold))
+		}
+	}
+	if c.SelfInvoiceAction != "flag" && c.SelfInvoiceAction != "reject" {
+		problems = append(problems, fmt.Sprintf("SELF_INVOICE_ACTION must be flag or reject (got %q)", c.SelfInvoiceAction))
+	}
+	if _, err := ParseVATRateHistory(c.VATRateHistory); err != nil {
+		problems = append(problems, fmt.Sprintf("VAT_RATE_HISTORY is invalid: %v", err))
+	}
+	if _, err := ParseAPIDeprecations(c.APIDeprecations); err != nil {
+		problems = append(problems, fmt.Sprintf("API_DEPRECATIONS is invalid: %v", err))
+	}
+	if c.USDToTHB <= 0 {
+		problems = append(problems, fmt.Sprintf("USD_TO_THB must be > 0 (got %g)", c.USDToTHB))
+	}
+	if c.BalanceCorrectionMaxDifference < 0 {
+		problems = append(problems, fmt.Sprintf("BALANCE_CORRECTION_MAX_DIFFERENCE must be >= 0 (got %g)", c.BalanceCorrectionMaxDifference))
+	}
+	if c.FixedAssetThreshold < 0 {
+		problems = append(problems, fmt.Sprintf("FIXED_ASSET_THRESHOLD must be >= 0 (got %g)", c.FixedAssetThreshold))
+	}
+	if c.ComplexityBaseSeconds < 0 || c.ComplexitySecondsPerImage < 0 || c.ComplexitySecondsPer1KChars < 0 {
+		problems = append(problems, "COMPLEXITY_BASE_SECONDS / COMPLEXITY_SECONDS_PER_IMAGE / COMPLEXITY_SECONDS_PER_1K_CHARS must be >= 0")
+	}
+	if c.BulkRateShare <= 0 || c.BulkRateShare > 1 {
+		problems = append(problems, fmt.Sprintf("BULK_RATE_SHARE must be > 0 and <= 1 (got %g)", c.BulkRateShare))
+	}
+	if c.ComplexityWarnRatio < 0 || c.ComplexityWarnRatio > 1 {
+		problems = append(problems, fmt.Sprintf("COMPLEXITY_WARN_RATIO must be between 0 and 1 (got %g)", c.ComplexityWarnRatio))
+	}
+	if c.MinProcessingSeconds > c.RequestTimeout {
+		problems = append(problems, fmt.Sprintf("MIN_PROCESSING_SECONDS must be <= REQUEST_TIMEOUT (got %d > %d)", c.MinProcessingSeconds, c.RequestTimeout))
+	}
+	if origins, err := ParseCORSOrigins(c.AllowedOrigins); err != nil {
+		problems = append(problems, fmt.Sprintf("ALLOWED_ORIGINS: %v", err))
+	} else if c.CORSAllowCredentials && len(origins) == 1 && origins[0] == "*" {
+		problems = append(problems, "CORS_ALLOW_CREDENTIALS=true needs explicit ALLOWED_ORIGINS (not *)")
+	}
+	if _, err := ParseRequestBodyLimits(c.RequestBodyLimits); err != nil {
+		problems = append(problems, fmt.Sprintf("REQUEST_BODY_LIMITS: %v", err))
+	}
+	if _, err := ParseCORSRouteMethods(c.CORSRouteMethods); err != nil {
+		problems = append(problems, fmt.Sprintf("CORS_ROUTE_METHODS: %v", err))
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("PORT must be a port number (got %q)", c.Port))
+	}
+	if u, err := url.Parse(c.MongoURI); err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") {
+		problems = append(problems, "MONGO_URI must be a mongodb:// or mongodb+srv:// URI")
+	}
+	if c.APIAuthRequired && c.AdminAPIKey == "" && strings.TrimSpace(c.ShopAPIKeys) == "" {
+		problems = append(problems, "API_AUTH_REQUIRED=true needs ADMIN_API_KEY or SHOP_API_KEYS")
+	}
+	if c.OCRChunkCount < 1 {
+		problems = append(problems, fmt.Sprintf("OCR_CHUNK_COUNT must be >= 1 (got %d)", c.OCRChunkCount))
+	}
+	for name, value := range map[string]int{
+		"SHUTDOWN_DRAIN_TIMEOUT_SEC":     c.ShutdownDrainTimeoutSec,
+		"SHUTDOWN_TIMEOUT_SEC":           c.ShutdownTimeoutSec,
+		"SLIP_VERIFY_TIMEOUT_SEC":        c.SlipVerifyTimeoutSec,
+		"OCR_RESULT_TTL_DAYS":            c.OCRResultTTLDays,
+		"FAILED_REQUEST_TTL_DAYS":        c.FailedRequestTTLDays,
+		"CONFIG_RELOAD_INTERVAL_SEC":     c.ConfigReloadIntervalSec,
+		"RUNTIME_FLAGS_REFRESH_SEC":      c.RuntimeFlagsRefreshSec,
+		"AUDIT_LOG_TTL_DAYS":             c.AuditLogTTLDays,
+		"DATA_RETENTION_DAYS":            c.DataRetentionDays,
+		"RETENTION_PURGE_INTERVAL_MIN":   c.RetentionPurgeIntervalMin,
+		"REPROCESS_POLL_INTERVAL_SEC":    c.ReprocessPollIntervalSec,
+		"REPROCESS_MAX_DOCUMENTS":        c.ReprocessMaxDocuments,
+		"FOLDER_WATCH_POLL_INTERVAL_SEC": c.FolderWatchPollIntervalSec,
+		"TENANT_ROUTES_REFRESH_SEC":      c.TenantRoutesRefreshSec,
+		"DOCUMENT_SEQUENCE_HISTORY":      c.DocumentSequenceHistory,
+		"DOCUMENT_SEQUENCE_MAX_GAP":      c.DocumentSequenceMaxGap,
+		"IMAGE_PAYLOAD_MAX_BYTES":        c.ImagePayloadMaxBytes,
+		"IMAGE_PAYLOAD_MAX_TOKENS":       c.ImagePayloadMaxTokens,
+		"IMAGE_REJECT_MAX_BYTES":         c.ImageRejectMaxBytes,
+		"IMAGE_REJECT_MAX_MEGAPIXELS":    c.ImageRejectMaxMegapixels,
+		"ACCOUNT_PREFILTER_TOP_N":        c.AccountPrefilterTopN,
+		"ACCOUNT_PREFILTER_MIN_ACCOUNTS": c.AccountPrefilterMinAccounts,
+		"CREDITOR_PREFILTER_TOP_K":       c.CreditorPrefilterTopK,
+		"RESPONSE_COMPRESSION_MIN_BYTES": c.ResponseCompressionMinBytes,
+		"CORS_MAX_AGE":                   c.CORSMaxAge,
+		"MAX_REQUEST_BODY_BYTES":         c.MaxRequestBodyBytes,
+		"MAX_UPLOAD_BODY_BYTES":          c.MaxUploadBodyBytes,
+		"MAX_IMAGES_PER_REQUEST":         c.MaxImagesPerRequest,
+		"MAX_TOTAL_PAYLOAD_BYTES":        c.MaxTotalPayloadBytes,
+		"WORKER_BULK_CONCURRENCY":        c.WorkerBulkConcurrency,
+		"INTERACTIVE_MAX_PER_SHOP":       c.InteractiveMaxPerShop,
+	} {
+		if value < 0 {
+			problems = append(problems, fmt.Sprintf("%s must be >= 0 (got %d)", name, value))
+		}
+	}
+	for name, value := range map[string]int{
+		"REQUEST_TIMEOUT":        c.RequestTimeout,
+		"DOWNLOAD_TIMEOUT":       c.DownloadTimeout,
+		"FULL_OCR_TIMEOUT":       c.FullOCRTimeout,
+		"TEMPLATE_MATCH_TIMEOUT": c.TemplateMatchTimeout,
+		"ACCOUNTING_TIMEOUT":     c.AccountingTimeout,
+		"MIN_PROCESSING_SECONDS": c.MinProcessingSeconds,
+	} {
+		if value < 1 {
+			problems = append(problems, fmt.Sprintf("%s must be >= 1 second (got %d)", name, value))
+		}
+	}
+	for name, value := range map[string]int{
+		"WORKER_CONCURRENCY":       c.WorkerConcurrency,
+		"FILE_SCAN_TIMEOUT_SEC":    c.FileScanTimeoutSec,
+		"THUMBNAIL_MAX_DIMENSION":  c.ThumbnailMaxDimension,
+		"WORKER_POLL_INTERVAL_SEC": c.WorkerPollIntervalSec,
+		"WORKER_MAX_ATTEMPTS":      c.WorkerMaxAttempts,
+		"TENANT_MAX_POOL_SIZE":     c.TenantMaxPoolSize,
+		"ZIP_MAX_BYTES":            c.ZipMaxBytes,
+		"ZIP_MAX_FILES":            c.ZipMaxFiles,
+		"ZIP_MAX_EXTRACTED_BYTES":  c.ZipMaxExtractedBytes,
+		"FOLDER_WATCH_MAX_FILES":   c.FolderWatchMaxFiles,
+	} {
+		if value < 1 {
+			problems = append(problems, fmt.Sprintf("%s must be >= 1 (got %d)", name, value))
+		}
+	}
+	return problems
+}
 
-	// Template Matching Configuration
-	TEMPLATE_CONFIDENCE_THRESHOLD = getEnvFloat("TEMPLATE_CONFIDENCE_THRESHOLD", 95.0)
+// applyStartupSettings copies the startup settings to the package-level variables
+func applyStartupSettings(cfg *Config) {
+	OCR_PROVIDER = cfg.OCRProvider
+	GEMINI_API_KEY = cfg.GeminiAPIKey
+	MISTRAL_API_KEY = cfg.MistralAPIKey
+	MISTRAL_MODEL_NAME = cfg.MistralModelName
+	OCR_MODEL_NAME = cfg.OCRModelName
+	TEMPLATE_MODEL_NAME = cfg.TemplateModelName
+	TEMPLATE_ACCOUNTING_MODEL_NAME = cfg.TemplateAccountingModelName
+	ACCOUNTING_MODEL_NAME = cfg.AccountingModelName
+	PORT = cfg.Port
+	UPLOAD_DIR = cfg.UploadDir
+	SHUTDOWN_DRAIN_TIMEOUT_SEC = cfg.ShutdownDrainTimeoutSec
+	SHUTDOWN_TIMEOUT_SEC = cfg.ShutdownTimeoutSec
+	IDEMPOTENCY_TTL_HOURS = cfg.IdempotencyTTLHours
+	MOCK_AI = cfg.MockAI
+	MOCK_AI_FIXTURES_DIR = cfg.MockAIFixturesDir
+	SLIP_VERIFY_API_URL = cfg.SlipVerifyAPIURL
+	SLIP_VERIFY_API_KEY = cfg.SlipVerifyAPIKey
+	SLIP_VERIFY_TIMEOUT_SEC = cfg.SlipVerifyTimeoutSec
+	FILE_SCAN_DRIVER = cfg.FileScanDriver
+	CLAMAV_ADDRESS = cfg.ClamAVAddress
+	FILE_SCAN_API_URL = cfg.FileScanAPIURL
+	FILE_SCAN_API_KEY = cfg.FileScanAPIKey
+	OCR_RESULT_TTL_DAYS = cfg.OCRResultTTLDays
+	FAILED_REQUEST_TTL_DAYS = cfg.FailedRequestTTLDays
+	READINESS_CHECK_PROVIDERS = cfg.ReadinessCheckProviders
+	READINESS_PROVIDER_CACHE_SECONDS = cfg.ReadinessProviderCacheSeconds
+	AI_TRACE_TTL_DAYS = cfg.AITraceTTLDays
+	ADMIN_API_KEY = cfg.AdminAPIKey
+	RUNTIME_FLAGS_REFRESH_SEC = cfg.RuntimeFlagsRefreshSec
+	API_AUTH_REQUIRED = cfg.APIAuthRequired
+	SHOP_API_KEYS = parseShopAPIKeys(cfg.ShopAPIKeys)
+	AUDIT_LOG_TTL_DAYS = cfg.AuditLogTTLDays
+	DATA_RETENTION_DAYS = cfg.DataRetentionDays
+	RETENTION_PURGE_INTERVAL_MIN = cfg.RetentionPurgeIntervalMin
+	REPROCESS_POLL_INTERVAL_SEC = cfg.ReprocessPollIntervalSec
+	FOLDER_WATCH_POLL_INTERVAL_SEC = cfg.FolderWatchPollIntervalSec
+	DROPBOX_APP_KEY = cfg.DropboxAppKey
+	DROPBOX_APP_SECRET = cfg.DropboxAppSecret
+	GOOGLE_DRIVE_CLIENT_ID = cfg.GoogleDriveClientID
+	GOOGLE_DRIVE_CLIENT_SECRET = cfg.GoogleDriveClientSecret
+	PDF_FONT_PATH = cfg.PDFFontPath
+	PDF_FONT_BOLD_PATH = cfg.PDFFontBoldPath
+	QUEUE_DRIVER = cfg.QueueDriver
+	WORKER_CONCURRENCY = cfg.WorkerConcurrency
+	WORKER_POLL_INTERVAL_SEC = cfg.WorkerPollIntervalSec
+	WORKER_MAX_ATTEMPTS = cfg.WorkerMaxAttempts
+	WORKER_BULK_CONCURRENCY = cfg.WorkerBulkConcurrency
+	ENABLE_SWAGGER_UI = cfg.EnableSwaggerUI
+	MONGO_URI = cfg.MongoURI
+	MONGO_DB_NAME = cfg.MongoDBName
+	TENANT_ROUTING_ENABLED = cfg.TenantRoutingEnabled
+	TENANT_ROUTES_REFRESH_SEC = cfg.TenantRoutesRefreshSec
+	TENANT_MAX_POOL_SIZE = cfg.TenantMaxPoolSize
+	ENABLE_IMAGE_PREPROCESSING = cfg.EnableImagePreprocessing
+	MAX_IMAGE_DIMENSION = cfg.MaxImageDimension
+	OCR_MAX_OUTPUT_TOKENS = cfg.OCRMaxOutputTokens
+	MODEL_MAX_OUTPUT_TOKENS = parseModelTokenLimits(cfg.ModelMaxOutputTokens)
+	ENABLE_CHUNKED_OCR = cfg.EnableChunkedOCR
+	OCR_CHUNK_COUNT = cfg.OCRChunkCount
+	ENABLE_QUICK_OCR = cfg.EnableQuickOCR
+	QUICK_OCR_TIMEOUT = cfg.QuickOCRTimeout
+	FULL_OCR_TIMEOUT = cfg.FullOCRTimeout
+	ACCOUNTING_TIMEOUT = cfg.AccountingTimeout
+	PARALLEL_PROCESSING = cfg.ParallelProcessing
+	USE_SMALLER_MODEL = cfg.UseSmallerModel
+}
 
-	// Exchange rate (customizable via .env)
-	USD_TO_THB = getEnvFloat("USD_TO_THB", 36.0)
+// MaxOutputTokensFor returns the configured output token limit of a model
+// (MODEL_MAX_OUTPUT_TOKENS override, otherwise defaultTokens; 0 = model default)
+func MaxOutputTokensFor(modelName string, defaultTokens int) int {
+	if limit, ok := MODEL_MAX_OUTPUT_TOKENS[modelName]; ok && limit > 0 {
+		return limit
+	}
+	return defaultTokens
+}
 
-	PORT = getEnv("PORT", "8080")
-	UPLOAD_DIR = getEnv("UPLOAD_DIR", "uploads")
-	ALLOWED_ORIGINS = getEnv("ALLOWED_ORIGINS", "*")
+// parseModelTokenLimits parses "model=tokens,model=tokens" (invalid entries are ignored)
+func parseModelTokenLimits(value string) map[string]int {
+	limits := map[string]int{}
+	for _, entry := range strings.Split(value, ",") {
+		name, tokens, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			continue
+		}
+		if parsed, err := strconv.Atoi(strings.TrimSpace(tokens)); err == nil && parsed > 0 {
+			limits[strings.TrimSpace(name)] = parsed
+		} else {
+			log.Printf("⚠️  Ignoring invalid MODEL_MAX_OUTPUT_TOKENS entry: %q", entry)
+		}
+	}
+	return limits
+}
 
-	// MongoDB Configuration
-	MONGO_URI = getEnv("MONGO_URI", "mongodb://localhost:27017")
-	MONGO_DB_NAME = getEnv("MONGO_DB_NAME", "your_database_name")
+// parseShopAPIKeys parses "shopid=key,shopid=key" (invalid entries are ignored, the key is never logged)
+func parseShopAPIKeys(value string) map[string]string {
+	keys := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		shopID, key, found := strings.Cut(strings.TrimSpace(entry), "=")
+		shopID, key = strings.TrimSpace(shopID), strings.TrimSpace(key)
+		if !found || shopID == "" || key == "" {
+			log.Printf("⚠️  Ignoring invalid SHOP_API_KEYS entry for shop %q", shopID)
+			continue
+		}
+		keys[shopID] = key
+	}
+	return keys
+}
 
-	// Image Processing
-	ENABLE_IMAGE_PREPROCESSING = getEnvBool("ENABLE_IMAGE_PREPROCESSING", true)
-	MAX_IMAGE_DIMENSION = getEnvInt("MAX_IMAGE_DIMENSION", 2000)
+// VATRatePeriod is one VAT_RATE_HISTORY entry: documents dated before Before used Rate
+type VATRatePeriod struct {
+	Before string  // YYYY-MM-DD
+	Rate   float64 // Percent
+}
 
-	// Performance Optimization
-	ENABLE_QUICK_OCR = getEnvBool("ENABLE_QUICK_OCR", false)      // Default: skip quick OCR to save time
-	QUICK_OCR_TIMEOUT = getEnvInt("QUICK_OCR_TIMEOUT", 30)        // 30 seconds
-	FULL_OCR_TIMEOUT = getEnvInt("FULL_OCR_TIMEOUT", 45)          // Reduced from 60 to 45
-	ACCOUNTING_TIMEOUT = getEnvInt("ACCOUNTING_TIMEOUT", 60)      // 60 seconds
-	PARALLEL_PROCESSING = getEnvBool("PARALLEL_PROCESSING", true) // Enable parallel processing
-	USE_SMALLER_MODEL = getEnvBool("USE_SMALLER_MODEL", false)    // Use flash-8b for speed
+// ParseVATRateHistory parses "YYYY-MM-DD=rate,YYYY-MM-DD=rate" (sorted by date)
+func ParseVATRateHistory(value string) ([]VATRatePeriod, error) {
+	var periods []VATRatePeriod
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		date, rateText, found := strings.Cut(strings.TrimSpace(entry), "=")
+		date = strings.TrimSpace(date)
+		if _, err := time.Parse("2006-01-02", date); !found || err != nil {
+			return nil, fmt.Errorf("entry %q: expected YYYY-MM-DD=rate", entry)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rateText), 64)
+		if err != nil || rate < 0 || rate > 100 {
+			return nil, fmt.Errorf("entry %q: rate must be between 0 and 100", entry)
+		}
+		periods = append(periods, VATRatePeriod{Before: date, Rate: rate})
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Before < periods[j].Before })
+	return periods, nil
+}
 
-	log.Println("✓ Configuration loaded successfully")
+// ParseAPIDeprecations parses "path=YYYY-MM-DD,path=YYYY-MM-DD" into route path → sunset date (UTC midnight)
+func ParseAPIDeprecations(value string) (map[string]time.Time, error) {
+	sunsets := map[string]time.Time{}
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		path, date, found := strings.Cut(strings.TrimSpace(entry), "=")
+		path = strings.TrimSpace(path)
+		sunset, err := time.Parse("2006-01-02", strings.TrimSpace(date))
+		if !found || err != nil || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("entry %q: expected /path=YYYY-MM-DD", entry)
+		}
+		sunsets[path] = sunset
+	}
+	return sunsets, nil
 }
 
-// Helper functions
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// ParseCORSOrigins parses ALLOWED_ORIGINS: "*" or "scheme://host[:port],..." (host may start with "*." for any subdomain)
+func ParseCORSOrigins(value string) ([]string, error) {
+	var origins []string
+	for _, entry := range strings.Split(value, ",") {
+		origin := strings.TrimRight(strings.TrimSpace(entry), "/")
+		if origin == "" {
+			continue
+		}
+		if origin == "*" {
+			origins = append(origins, origin)
+			continue
+		}
+		scheme, host, found := strings.Cut(origin, "://")
+		if !found || (scheme != "http" && scheme != "https") || strings.TrimPrefix(host, "*.") == "" ||
+			strings.ContainsAny(strings.TrimPrefix(host, "*."), "*/") {
+			return nil, fmt.Errorf("origin %q: expected scheme://host[:port] or scheme://*.domain", entry)
+		}
+		origins = append(origins, strings.ToLower(origin))
+	}
+	if len(origins) == 0 {
+		return nil, fmt.Errorf("no origins")
 	}
-	return defaultValue
+	for _, origin := range origins {
+		if origin == "*" && len(origins) > 1 {
+			return nil, fmt.Errorf("* cannot be combined with other origins")
+		}
+	}
+	return origins, nil
 }
 
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		if parsed, err := strconv.ParseBool(value); err == nil {
-			return parsed
+// ParseCORSRouteMethods parses "path=GET|POST,path=GET" into route path → methods (gin route paths, e.g. /api/v1/results/:request_id)
+func ParseCORSRouteMethods(value string) (map[string][]string, error) {
+	routes := map[string][]string{}
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		path, methods, found := strings.Cut(strings.TrimSpace(entry), "=")
+		path = strings.TrimSpace(path)
+		if !found || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("entry %q: expected /path=METHOD|METHOD", entry)
+		}
+		for _, method := range strings.Split(methods, "|") {
+			method = strings.ToUpper(strings.TrimSpace(method))
+			switch method {
+			case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE":
+				routes[path] = append(routes[path], method)
+			default:
+				return nil, fmt.Errorf("entry %q: unknown method %q", entry, method)
+			}
 		}
 	}
-	return defaultValue
+	return routes, nil
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if parsed, err := strconv.Atoi(value); err == nil {
-			return parsed
+// ParseRequestBodyLimits parses "path=bytes,path=bytes" into route path → body limit (0 = no limit)
+func ParseRequestBodyLimits(value string) (map[string]int64, error) {
+	limits := map[string]int64{}
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
 		}
+		path, size, found := strings.Cut(strings.TrimSpace(entry), "=")
+		path = strings.TrimSpace(path)
+		limit, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+		if !found || err != nil || limit < 0 || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("entry %q: expected /path=bytes", entry)
+		}
+		limits[path] = limit
 	}
-	return defaultValue
+	return limits, nil
 }
 
-func getEnvFloat(key string, defaultValue float64) float64 {
-	if value := os.Getenv(key); value != "" {
-		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
-			return parsed
+// VATRateOn returns the VAT rate of a document dated documentDate (YYYY-MM-DD, "" = current rate)
+// The earliest VAT_RATE_HISTORY entry dated after the document wins, otherwise VAT_RATE
+func (c *Config) VATRateOn(documentDate string) float64 {
+	if documentDate == "" {
+		return c.VATRate
+	}
+	periods, _ := ParseVATRateHistory(c.VATRateHistory)
+	for _, period := range periods {
+		if documentDate < period.Before {
+			return period.Rate
 		}
 	}
-	return defaultValue
+	return c.VATRate
 }
@@ -0,0 +1,90 @@
+// reload.go - Hot reload of tunables from CONFIG_FILE (polling + SIGHUP)
+//
+// เฉพาะ field ที่มี reload:"true" เท่านั้นที่เปลี่ยนทันที ค่าอื่นที่เปลี่ยนในไฟล์จะถูก log ว่าต้อง restart
+// ไฟล์ที่ค่าไม่ผ่าน Validate จะถูกปฏิเสธทั้งไฟล์ (ใช้ค่าเดิมต่อ)
+
+package configs
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// StartHotReload re-reads CONFIG_FILE every CONFIG_RELOAD_INTERVAL_SEC (when modified) and on SIGHUP
+// No-op when CONFIG_FILE is not set
+func StartHotReload() {
+	if configFile == "" {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var ticker <-chan time.Time
+	if interval := Get().ConfigReloadIntervalSec; interval > 0 {
+		ticker = time.NewTicker(time.Duration(interval) * time.Second).C
+	}
+
+	lastModified := fileModTime(configFile)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				log.Printf("🔄 SIGHUP received - reloading %s", configFile)
+			case <-ticker:
+				modified := fileModTime(configFile)
+				if !modified.After(lastModified) {
+					continue
+				}
+			}
+			lastModified = fileModTime(configFile)
+			if err := ReloadConfig(); err != nil {
+				log.Printf("⚠️  Config reload rejected (keeping current values): %v", err)
+			}
+		}
+	}()
+	log.Printf("🔄 Config hot reload enabled for %s (SIGHUP or file change)", configFile)
+}
+
+// ReloadConfig applies the reloadable settings of CONFIG_FILE (+ environment) to the active configuration
+func ReloadConfig() error {
+	next, err := loadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	active := Get()
+	updated := *active
+	uv := reflect.ValueOf(&updated).Elem()
+	av := reflect.ValueOf(active).Elem()
+	nv := reflect.ValueOf(next).Elem()
+	t := uv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if reflect.DeepEqual(av.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if field.Tag.Get("reload") != "true" {
+			log.Printf("⚠️  %s changed in %s - restart required to apply", field.Tag.Get("env"), configFile)
+			continue
+		}
+		uv.Field(i).Set(nv.Field(i))
+		log.Printf("🔄 %s: %v → %v", field.Tag.Get("env"), av.Field(i).Interface(), nv.Field(i).Interface())
+	}
+
+	current.Store(&updated)
+	return nil
+}
+
+// fileModTime returns the modification time of path (zero when missing)
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
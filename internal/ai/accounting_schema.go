@@ -0,0 +1,357 @@
+// accounting_schema.go - ResponseSchema of Phase 3 (accounting analysis)
+//
+// โครงสร้างเดียวกับ OUTPUT FORMAT ใน prompt_output_format.go (prompt อธิบายความหมาย, schema บังคับรูปแบบ)
+// Gemini ตอบเป็น JSON ตาม schema เสมอ → ไม่ต้องตัด ```json หรือข้อความอธิบายที่ model แถมมา
+// เพิ่ม/แก้ฟิลด์ใน OUTPUT FORMAT ต้องแก้ที่นี่ด้วย (ฟิลด์ที่ไม่อยู่ใน schema จะไม่ถูกส่งกลับมา)
+
+package ai
+
+import (
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// createAccountingReceiptSchema - receipt{} (also used by document_groups[].receipt)
+// customFields = the shop's custom fields → receipt.custom_fields{} (omitted when the shop has none)
+func createAccountingReceiptSchema(customFields []common.CustomField) *genai.Schema {
+	schema := &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"number": {
+				Type:        genai.TypeString,
+				Description: "เลขที่ใบเสร็จ",
+			},
+			"date": {
+				Type:        genai.TypeString,
+				Description: "วันที่รูปแบบ YYYY-MM-DD ปี ค.ศ. (แปลง พ.ศ. → ค.ศ. ด้วยการ -543)",
+			},
+			"vendor_name": {
+				Type:        genai.TypeString,
+				Description: "ชื่อผู้ขาย",
+			},
+			"vendor_tax_id": {
+				Type:        genai.TypeString,
+				Description: "เลขผู้เสียภาษี",
+			},
+			"total": {
+				Type:        genai.TypeNumber,
+				Description: "ยอดรวมที่ระบุในเอกสาร",
+			},
+			"vat": {
+				Type:        genai.TypeNumber,
+				Description: "ยอด VAT ที่ระบุชัดเจนในเอกสาร - ไม่มีระบุใส่ null (ห้ามคำนวณ)",
+				Nullable:    true,
+			},
+			"vat_breakdown": {
+				Type:        genai.TypeArray,
+				Description: "เฉพาะเอกสารที่มีหลายอัตรา VAT หรือมีรายการยกเว้น VAT: มูลค่าฐานภาษีแยกตามอัตราที่ระบุในเอกสาร - เอกสารอัตราเดียวใส่ null",
+				Nullable:    true,
+				Items: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"rate": {Type: genai.TypeNumber, Description: "อัตรา VAT (%) เช่น 7, 0"},
+						"base": {Type: genai.TypeNumber, Description: "มูลค่าก่อน VAT ของอัตรานี้"},
+						"vat":  {Type: genai.TypeNumber, Description: "VAT ของอัตรานี้"},
+					},
+					Required: []string{"rate", "base", "vat"},
+				},
+			},
+			"exempt_amount": {
+				Type:        genai.TypeNumber,
+				Description: "มูลค่าสินค้า/บริการที่ได้รับยกเว้น VAT ตามที่ระบุในเอกสาร - ไม่มีใส่ null",
+				Nullable:    true,
+			},
+			"withholding_tax": {
+				Type:        genai.TypeNumber,
+				Description: "ภาษีหัก ณ ที่จ่ายที่ระบุในเอกสาร - ไม่มีใส่ null (ห้ามคำนวณ)",
+				Nullable:    true,
+			},
+			"currency": {
+				Type:        genai.TypeString,
+				Description: "รหัสสกุลเงิน ISO 4217 ของยอดในเอกสาร (THB, USD, CNY, JPY, ...) - เอกสารไทยใส่ THB",
+				Nullable:    true,
+			},
+			"payment_method": {
+				Type:        genai.TypeString,
+				Description: "วิธีชำระเงิน",
+			},
+			"payment_proof_available": {
+				Type: genai.TypeBoolean,
+			},
+			"original_document_number": {
+				Type:        genai.TypeString,
+				Description: "เฉพาะใบลดหนี้/ใบเพิ่มหนี้: เลขที่ใบกำกับภาษีเดิมที่อ้างอิง - ไม่มีใส่ null",
+				Nullable:    true,
+			},
+		},
+		Required: []string{"number", "date", "vendor_name", "vendor_tax_id", "total", "vat", "payment_method", "payment_proof_available"},
+	}
+	if len(customFields) > 0 {
+		schema.Properties["custom_fields"] = createCustomFieldsSchema(customFields)
+		schema.Required = append(schema.Required, "custom_fields")
+	}
+	return schema
+}
+
+// createCustomFieldsSchema - receipt.custom_fields{} (same field types as POST /api/v1/extract, every key nullable)
+func createCustomFieldsSchema(customFields []common.CustomField) *genai.Schema {
+	fields := make([]ExtractionField, 0, len(customFields))
+	for _, field := range customFields {
+		fields = append(fields, ExtractionField{Name: field.Name, Type: field.Type, Description: field.Description})
+	}
+	schema := createExtractionSchema(fields)
+	schema.Description = "ฟิลด์เพิ่มเติมที่ร้านกำหนด - อ่านจากเอกสารเท่านั้น ไม่พบใส่ null"
+	return schema
+}
+
+// createAccountingEntrySchema - accounting_entry{} (also used by document_groups[].accounting_entry)
+// dimensions = the shop's dimensions → entries[].dimensions{} (omitted when the shop has none)
+func createAccountingEntrySchema(dimensions []common.DimensionDefinition) *genai.Schema {
+	schema := &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"document_date": {
+				Type:        genai.TypeString,
+				Description: "วันที่เอกสารรูปแบบ YYYY-MM-DD ปี ค.ศ.",
+			},
+			"reference_number":  {Type: genai.TypeString},
+			"journal_book_code": {Type: genai.TypeString},
+			"journal_book_name": {Type: genai.TypeString},
+			"creditor_code": {
+				Type:        genai.TypeString,
+				Description: "รหัสเจ้าหนี้จาก Master Data - ไม่เจอใส่ null",
+				Nullable:    true,
+			},
+			"creditor_name": {Type: genai.TypeString},
+			"debtor_code": {
+				Type:        genai.TypeString,
+				Description: "รหัสลูกหนี้จาก Master Data - ไม่เจอใส่ null",
+				Nullable:    true,
+			},
+			"debtor_name": {Type: genai.TypeString},
+			"entries": {
+				Type: genai.TypeArray,
+				Items: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"account_code": {
+							Type:        genai.TypeString,
+							Description: "รหัสบัญชีจาก Master Data เท่านั้น",
+						},
+						"account_name":     {Type: genai.TypeString},
+						"debit":            {Type: genai.TypeNumber},
+						"credit":           {Type: genai.TypeNumber},
+						"description":      {Type: genai.TypeString},
+						"selection_reason": {Type: genai.TypeString},
+						"side_reason":      {Type: genai.TypeString},
+					},
+					Required: []string{"account_code", "account_name", "debit", "credit", "description", "selection_reason", "side_reason"},
+				},
+			},
+			"balance_check": {
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"balanced":     {Type: genai.TypeBoolean},
+					"total_debit":  {Type: genai.TypeNumber},
+					"total_credit": {Type: genai.TypeNumber},
+				},
+				Required: []string{"balanced", "total_debit", "total_credit"},
+			},
+		},
+		Required: []string{"document_date", "reference_number", "journal_book_code", "journal_book_name",
+			"creditor_code", "creditor_name", "debtor_code", "debtor_name", "entries", "balance_check"},
+	}
+	if len(dimensions) > 0 {
+		lineSchema := schema.Properties["entries"].Items
+		lineSchema.Properties["dimensions"] = createDimensionsSchema(dimensions)
+		lineSchema.Required = append(lineSchema.Required, "dimensions")
+	}
+	return schema
+}
+
+// createDimensionsSchema - entries[].dimensions{} (one code per dimension, null = not stated on the document)
+func createDimensionsSchema(dimensions []common.DimensionDefinition) *genai.Schema {
+	schema := &genai.Schema{
+		Type:        genai.TypeObject,
+		Description: "รหัส dimension ของรายการนี้จากรายการที่ร้านกำหนดเท่านั้น - ไม่ระบุในเอกสารใส่ null",
+		Properties:  map[string]*genai.Schema{},
+	}
+	for _, dimension := range dimensions {
+		schema.Properties[dimension.Key] = &genai.Schema{Type: genai.TypeString, Description: dimension.Name, Nullable: true}
+		schema.Required = append(schema.Required, dimension.Key)
+	}
+	return schema
+}
+
+// createAIExplanationSchema - validation.ai_explanation{}
+func createAIExplanationSchema() *genai.Schema {
+	return &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"reasoning": {
+				Type:        genai.TypeString,
+				Description: "เหตุผล 2-3 ประโยคสั้นๆ ภาษาไทย",
+			},
+			"vendor_matching": {
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"found_in_document": {Type: genai.TypeString},
+					"matched_with": {
+						Type:     genai.TypeString,
+						Nullable: true,
+					},
+					"matching_method": {
+						Type: genai.TypeString,
+						Enum: []string{"exact_match", "fuzzy_match", "tax_id_match", "not_found"},
+					},
+					"confidence": {Type: genai.TypeNumber},
+					"reason":     {Type: genai.TypeString},
+				},
+				Required: []string{"found_in_document", "matched_with", "matching_method", "confidence", "reason"},
+			},
+			"transaction_analysis": {
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"type":                       {Type: genai.TypeString},
+					"buyer_seller_determination": {Type: genai.TypeString},
+					"payment_method":             {Type: genai.TypeString},
+					"has_vat":                    {Type: genai.TypeBoolean},
+					"payment_proof":              {Type: genai.TypeBoolean},
+				},
+				Required: []string{"type", "buyer_seller_determination", "payment_method", "has_vat", "payment_proof"},
+			},
+			"account_selection_logic": {
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"template_used":    {Type: genai.TypeBoolean},
+					"template_details": {Type: genai.TypeString},
+				},
+				Required: []string{"template_used", "template_details"},
+			},
+			"risk_assessment": {
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"overall_risk": {
+						Type: genai.TypeString,
+						Enum: []string{"low", "medium", "high"},
+					},
+					"factors":         {Type: genai.TypeString},
+					"recommendations": {Type: genai.TypeString},
+				},
+				Required: []string{"overall_risk", "factors", "recommendations"},
+			},
+		},
+		Required: []string{"reasoning", "vendor_matching", "transaction_analysis", "account_selection_logic", "risk_assessment"},
+	}
+}
+
+// createAccountingSchema creates the JSON schema of the Phase 3 accounting response
+// settings adds the shop's custom fields (receipt) and dimensions (journal lines)
+func createAccountingSchema(settings common.RequestSettings) *genai.Schema {
+	return &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"document_analysis": {
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"total_images": {Type: genai.TypeInteger},
+					"relationship": {
+						Type: genai.TypeString,
+						Enum: []string{"receipt_with_payment_proof", "multi_page_receipt", "separate_receipts", "single_document"},
+					},
+					"confidence":     {Type: genai.TypeNumber},
+					"analysis_notes": {Type: genai.TypeString},
+				},
+				Required: []string{"total_images", "relationship", "confidence", "analysis_notes"},
+			},
+			"source_images": {
+				Type: genai.TypeArray,
+				Items: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"image_index": {Type: genai.TypeInteger},
+						"type": {
+							Type: genai.TypeString,
+							Enum: []string{"receipt", "invoice", "payment_slip", "tax_invoice", "credit_note", "debit_note", "unknown"},
+						},
+						"receipt_number": {Type: genai.TypeString},
+						"amount":         {Type: genai.TypeNumber},
+						"date": {
+							Type:        genai.TypeString,
+							Description: "วันที่รูปแบบ YYYY-MM-DD ปี ค.ศ.",
+						},
+						"confidence": {Type: genai.TypeNumber},
+					},
+					Required: []string{"image_index", "type", "receipt_number", "amount", "date", "confidence"},
+				},
+			},
+			"receipt": createAccountingReceiptSchema(settings.CustomFields),
+			"creditor": {
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"creditor_code": {
+						Type:        genai.TypeString,
+						Description: "รหัส - ถ้าเราเป็นผู้ซื้อ / null ถ้าไม่เจอ",
+						Nullable:    true,
+					},
+					"creditor_name": {Type: genai.TypeString},
+				},
+				Required: []string{"creditor_code", "creditor_name"},
+			},
+			"debtor": {
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"debtor_code": {
+						Type:        genai.TypeString,
+						Description: "รหัส - ถ้าเราเป็นผู้ขาย / null ถ้าไม่เจอ",
+						Nullable:    true,
+					},
+					"debtor_name": {Type: genai.TypeString},
+				},
+				Required: []string{"debtor_code", "debtor_name"},
+			},
+			"accounting_entry": createAccountingEntrySchema(settings.Dimensions),
+			"document_groups": {
+				Type:        genai.TypeArray,
+				Description: "เฉพาะ relationship = separate_receipts (1 กลุ่มต่อเอกสาร 1 ใบ) - relationship อื่นใส่ []",
+				Items: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"image_indices": {
+							Type:  genai.TypeArray,
+							Items: &genai.Schema{Type: genai.TypeInteger},
+						},
+						"receipt":          createAccountingReceiptSchema(settings.CustomFields),
+						"accounting_entry": createAccountingEntrySchema(settings.Dimensions),
+					},
+					Required: []string{"image_indices", "receipt", "accounting_entry"},
+				},
+			},
+			"validation": {
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"confidence": {
+						Type: genai.TypeObject,
+						Properties: map[string]*genai.Schema{
+							"level": {
+								Type: genai.TypeString,
+								Enum: []string{"high", "medium", "low"},
+							},
+							"score": {Type: genai.TypeNumber},
+						},
+						Required: []string{"level", "score"},
+					},
+					"requires_review": {Type: genai.TypeBoolean},
+					"fields_requiring_review": {
+						Type:  genai.TypeArray,
+						Items: &genai.Schema{Type: genai.TypeString},
+					},
+					"processing_notes": {Type: genai.TypeString},
+					"ai_explanation":   createAIExplanationSchema(),
+				},
+				Required: []string{"confidence", "requires_review", "fields_requiring_review", "processing_notes", "ai_explanation"},
+			},
+		},
+		Required: []string{"document_analysis", "source_images", "receipt", "creditor", "debtor", "accounting_entry", "document_groups", "validation"},
+	}
+}
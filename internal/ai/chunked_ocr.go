@@ -0,0 +1,210 @@
+// chunked_ocr.go - Re-OCR truncated documents in tiles/pages and stitch the text
+//
+// เมื่อ OCR ถูกตัดเพราะเกิน MaxOutputTokens (เช่น statement หลายหน้า) แทนที่จะคืนข้อความไม่ครบ
+// จะแบ่งเอกสาร → รูป: แบ่งเป็นแถบตามด้านยาว (OCR_CHUNK_COUNT), PDF: ทีละหน้า
+// แล้ว OCR ทีละส่วนแบบ plain text และต่อข้อความกลับ (ตัดบรรทัดซ้ำตรงรอยต่อออก)
+
+package ai
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ratelimit"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// maxChunkedPDFPages caps page-by-page OCR (each page is a separate API call)
+const maxChunkedPDFPages = 30
+
+// maxStitchOverlapLines - how many lines at a tile border are checked for duplicates
+const maxStitchOverlapLines = 5
+
+// chunkedOCR re-OCRs a document that was truncated by the output token limit
+// Returns an error if the document cannot be split (caller falls back to plain text OCR)
+func chunkedOCR(ctx context.Context, client *genai.Client, modelName, imagePath string, imageData []byte, mimeType string, reqCtx *common.RequestContext) (*SimpleOCRResult, *common.TokenUsage, error) {
+	isPDF := mimeType == "application/pdf"
+
+	// Step 1: Plan the chunks
+	var chunks [][]byte
+	var chunkCount int
+	if isPDF {
+		chunkCount = processor.CountPDFPages(imageData)
+		if chunkCount < 2 {
+			return nil, nil, fmt.Errorf("cannot split PDF for chunked OCR (detected %d page(s))", chunkCount)
+		}
+		if chunkCount > maxChunkedPDFPages {
+			reqCtx.LogWarning("⚠️  PDF has %d pages, chunked OCR limited to first %d", chunkCount, maxChunkedPDFPages)
+			chunkCount = maxChunkedPDFPages
+		}
+	} else {
+		tiles, err := processor.SplitImageIntoTiles(imagePath, configs.OCR_CHUNK_COUNT)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to split image for chunked OCR: %w", err)
+		}
+		chunks = tiles
+		chunkCount = len(tiles)
+	}
+	chunkKind := "tile(s)"
+	if isPDF {
+		chunkKind = "page(s)"
+	}
+	reqCtx.LogInfo("🧩 Chunked OCR: %d %s", chunkCount, chunkKind)
+
+	maxTokens := configs.MaxOutputTokensFor(modelName, configs.OCR_MAX_OUTPUT_TOKENS)
+	model := client.GenerativeModel(modelName)
+	if maxTokens > 0 {
+		model.GenerationConfig = genai.GenerationConfig{
+			MaxOutputTokens: ptr(int32(maxTokens)),
+		}
+	}
+
+	// Step 2: OCR each chunk (plain text - no JSON overhead)
+	totalUsage := &common.TokenUsage{}
+	texts := make([]string, 0, chunkCount)
+	truncatedChunks := 0
+	for i := 0; i < chunkCount; i++ {
+		var blob genai.Blob
+		if isPDF {
+			blob = genai.Blob{MIMEType: mimeType, Data: imageData}
+		} else {
+			blob = genai.Blob{MIMEType: "image/jpeg", Data: chunks[i]}
+		}
+		prompt := buildChunkOCRPrompt(i+1, chunkCount, isPDF)
+
+		projected := common.CalculateOCRTokenCost(
+			common.EstimateTextTokens(prompt)+common.EstimatedImageTokens,
+			common.EstimatedOCROutputTokens,
+		)
+		if err := reqCtx.ReserveCost(common.CostPhaseOCR, projected); err != nil {
+			return nil, nil, err
+		}
+
+		callStart := time.Now()
+		resp, err := callGeminiWithRetry(ctx, "gemini.ocr_chunk", model, reqCtx, ratelimit.DefaultRetryPolicy, genai.Text(prompt), blob)
+		chunkInput := fmt.Sprintf("%s, %d bytes (%s, chunk %d/%d)", blob.MIMEType, len(blob.Data), filepath.Base(imagePath), i+1, chunkCount)
+		reqCtx.RecordTrace(common.NewGeminiTrace(common.CostPhaseOCR, modelName, model, prompt, chunkInput, resp, err, callStart))
+		if err != nil {
+			return nil, nil, fmt.Errorf("chunk %d/%d failed: %w", i+1, chunkCount, err)
+		}
+
+		if resp.UsageMetadata != nil {
+			usage := common.CalculateOCRTokenCost(
+				int(resp.UsageMetadata.PromptTokenCount),
+				int(resp.UsageMetadata.CandidatesTokenCount),
+			)
+			reqCtx.RecordCost(common.CostPhaseOCR, &usage)
+			addTokenUsage(totalUsage, &usage)
+		}
+
+		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+			return nil, nil, fmt.Errorf("chunk %d/%d returned no content", i+1, chunkCount)
+		}
+		var chunkText strings.Builder
+		for _, part := range resp.Candidates[0].Content.Parts {
+			if text, ok := part.(genai.Text); ok {
+				chunkText.WriteString(string(text))
+			}
+		}
+		if resp.Candidates[0].FinishReason == genai.FinishReasonMaxTokens {
+			truncatedChunks++
+			reqCtx.LogWarning("⚠️  Chunk %d/%d was also truncated (FinishReason: MAX_TOKENS)", i+1, chunkCount)
+		}
+
+		texts = append(texts, strings.TrimSpace(chunkText.String()))
+		reqCtx.LogInfo("🧩 Chunk %d/%d: %d chars", i+1, chunkCount, chunkText.Len())
+	}
+
+	// Step 3: Stitch the segments together
+	stitched := stitchChunkTexts(texts, !isPDF)
+	result := &SimpleOCRResult{
+		Status:          "success",
+		RawDocumentText: stitched,
+		IsPartial:       truncatedChunks > 0,
+		TextLength:      len(stitched),
+		ChunksUsed:      chunkCount,
+		Metadata: AIMetadata{
+			ModelName:        modelName,
+			PromptTokens:     int32(totalUsage.InputTokens),
+			CandidatesTokens: int32(totalUsage.OutputTokens),
+			TotalTokens:      int32(totalUsage.TotalTokens),
+		},
+		RawResponse: stitched,
+	}
+	if truncatedChunks > 0 {
+		result.Warning = fmt.Sprintf("Chunked OCR: %d of %d chunk(s) were still truncated. Data may be incomplete.", truncatedChunks, chunkCount)
+	}
+
+	reqCtx.LogInfo("✅ Chunked OCR stitched %d chunk(s): %d chars", chunkCount, len(stitched))
+	return result, totalUsage, nil
+}
+
+// buildChunkOCRPrompt builds the plain text OCR prompt for one chunk
+func buildChunkOCRPrompt(index, total int, isPDF bool) string {
+	if isPDF {
+		return fmt.Sprintf(`This PDF has at least %d pages. Extract ALL visible text from PAGE %d ONLY.
+Read everything from top to bottom, left to right.
+Include headers, table rows, footers and notes of that page.
+Return ONLY the extracted text, nothing else.`, total, index)
+	}
+	return fmt.Sprintf(`This image is part %d of %d of a long document (split into strips in reading order).
+Extract ALL visible text from this part.
+Read everything from top to bottom, left to right.
+Lines cut at the edge of the image may be partial - include them as they appear.
+Return ONLY the extracted text, nothing else.`, index, total)
+}
+
+// stitchChunkTexts joins chunk texts in order
+// With overlapping tiles, lines repeated at the start of a chunk (read in the previous chunk too) are dropped
+func stitchChunkTexts(texts []string, overlapping bool) string {
+	var stitched []string
+	for _, text := range texts {
+		if text == "" {
+			continue
+		}
+		lines := strings.Split(text, "\n")
+		if overlapping && len(stitched) > 0 {
+			lines = lines[overlapLineCount(stitched, lines):]
+		}
+		stitched = append(stitched, lines...)
+	}
+	return strings.Join(stitched, "\n")
+}
+
+// overlapLineCount returns how many leading lines of next repeat the trailing lines of prev
+func overlapLineCount(prev, next []string) int {
+	for n := maxStitchOverlapLines; n > 0; n-- {
+		if n > len(prev) || n > len(next) {
+			continue
+		}
+		match := true
+		for i := 0; i < n; i++ {
+			if strings.TrimSpace(prev[len(prev)-n+i]) != strings.TrimSpace(next[i]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return n
+		}
+	}
+	return 0
+}
+
+// addTokenUsage adds usage into total
+func addTokenUsage(total, usage *common.TokenUsage) {
+	if usage == nil {
+		return
+	}
+	total.InputTokens += usage.InputTokens
+	total.OutputTokens += usage.OutputTokens
+	total.TotalTokens += usage.TotalTokens
+	total.CostUSD += usage.CostUSD
+	total.CostTHB += usage.CostTHB
+}
@@ -0,0 +1,61 @@
+// credentials.go - Cheap API key checks for the readiness probe (no tokens billed)
+
+package ai
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// CheckProviderCredentials verifies the API key of a provider ("gemini" or "mistral")
+// Gemini: model info of OCR_MODEL_NAME, Mistral: GET /v1/models
+func CheckProviderCredentials(ctx context.Context, providerName string) error {
+	if configs.MOCK_AI {
+		return nil
+	}
+
+	switch providerName {
+	case "gemini":
+		if configs.GEMINI_API_KEY == "" {
+			return fmt.Errorf("GEMINI_API_KEY is not set")
+		}
+		client, err := genai.NewClient(ctx, option.WithAPIKey(configs.GEMINI_API_KEY))
+		if err != nil {
+			return fmt.Errorf("failed to create Gemini client: %w", err)
+		}
+		defer client.Close()
+		if _, err := client.GenerativeModel(configs.OCR_MODEL_NAME).Info(ctx); err != nil {
+			return fmt.Errorf("failed to get Gemini model info: %w", err)
+		}
+		return nil
+
+	case "mistral":
+		if configs.MISTRAL_API_KEY == "" {
+			return fmt.Errorf("MISTRAL_API_KEY is not set")
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.mistral.ai/v1/models", nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", configs.MISTRAL_API_KEY))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to send request: %w", err)
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("Mistral API returned status %d", resp.StatusCode)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported provider: %s (supported: gemini, mistral)", providerName)
+	}
+}
@@ -12,6 +12,15 @@ import (
 // CreateOCRProvider creates an OCR provider based on provider name
 // providerName: "gemini" or "mistral"
 func CreateOCRProvider(providerName string) (OCRProvider, error) {
+	if IsProviderDisabled(providerName) {
+		return nil, fmt.Errorf("%w: %s", ErrProviderDisabled, providerName)
+	}
+
+	if configs.MOCK_AI && (providerName == "gemini" || providerName == "mistral") {
+		log.Printf("🧪 Creating mock OCR provider (MOCK_AI=true, as %s)", providerName)
+		return NewMockProvider(providerName), nil
+	}
+
 	switch providerName {
 	case "gemini":
 		log.Printf("🔵 Creating Gemini OCR provider")
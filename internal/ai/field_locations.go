@@ -0,0 +1,172 @@
+// field_locations.go - Bounding boxes of the receipt values on the original images (include_preview)
+//
+// ใช้ความสามารถ object detection ของ Gemini (box_2d) หาตำแหน่งของค่าที่อ่านได้แล้ว
+// ไม่ได้อ่านค่าใหม่ - ผลใช้แสดงกรอบบนรูปใน frontend เท่านั้น
+
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/mockai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ratelimit"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// LocatedImage is an image sent to the field locator
+type LocatedImage struct {
+	Index int
+	Path  string
+}
+
+// fieldLocationResponse is the JSON returned by the field locator
+type fieldLocationResponse struct {
+	Locations []struct {
+		Field      string    `json:"field"`
+		ImageIndex int       `json:"image_index"`
+		Box2D      []float64 `json:"box_2d"`
+	} `json:"locations"`
+}
+
+// LocateFields finds where the given receipt values (field → value) are printed on the images
+func LocateFields(ctx context.Context, images []LocatedImage, values map[string]string, reqCtx *common.RequestContext) ([]processor.FieldLocation, *common.TokenUsage, error) {
+	var response fieldLocationResponse
+	var tokenUsage *common.TokenUsage
+
+	if configs.MOCK_AI {
+		data, err := mockai.Fixture(mockai.FixtureFieldLocation)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(data, &response); err != nil {
+			return nil, nil, fmt.Errorf("invalid mock field location fixture: %w", err)
+		}
+		reqCtx.LogInfo("🧪 MOCK_AI: returning field location fixture")
+		tokenUsage = &common.TokenUsage{}
+	} else {
+		// Step 1: Load images (same preprocessing as OCR - scaling keeps the proportions of the boxes)
+		prompt := GetFieldLocationPrompt(values, processor.PreviewFields)
+		parts := []genai.Part{genai.Text(prompt)}
+		for _, img := range images {
+			imageData, mimeType, err := preprocessForGemini(img.Path, reqCtx)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to load image for field location: %w", err)
+			}
+			parts = append(parts, genai.Text(fmt.Sprintf("Image %d", img.Index)), genai.Blob{MIMEType: mimeType, Data: imageData})
+		}
+
+		// Step 2: Initialize the Gemini client
+		client, err := genai.NewClient(ctx,
+			option.WithAPIKey(configs.GEMINI_API_KEY),
+			option.WithEndpoint("https://generativelanguage.googleapis.com"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create Gemini client: %w", err)
+		}
+		defer client.Close()
+
+		model := client.GenerativeModel(reqCtx.Settings.OCRModel)
+		model.SetTemperature(0)
+		model.ResponseMIMEType = "application/json"
+		model.ResponseSchema = createFieldLocationSchema()
+
+		// Step 3: Check cost budget before calling the API
+		projected := common.CalculateOCRTokenCost(
+			common.EstimateTextTokens(prompt)+common.EstimatedImageTokens*len(images),
+			common.EstimatedTemplateOutputTokens,
+		)
+		if err := reqCtx.ReserveCost(common.CostPhaseFieldLocation, projected); err != nil {
+			return nil, nil, err
+		}
+
+		// Step 4: Call Gemini (single attempt - preview only, don't hold the request on 429)
+		callStart := time.Now()
+		resp, err := callGeminiWithRetry(ctx, "gemini.field_location", model, reqCtx, ratelimit.SingleAttemptPolicy, parts...)
+		reqCtx.RecordTrace(common.NewGeminiTrace(common.CostPhaseFieldLocation, reqCtx.Settings.OCRModel, model, prompt,
+			fmt.Sprintf("%d image(s)", len(images)), resp, err, callStart))
+		if err != nil {
+			return nil, nil, fmt.Errorf("field location call failed: %w", err)
+		}
+		if resp.UsageMetadata != nil {
+			tokens := common.CalculateOCRTokenCost(
+				int(resp.UsageMetadata.PromptTokenCount),
+				int(resp.UsageMetadata.CandidatesTokenCount),
+			)
+			tokenUsage = &tokens
+			reqCtx.RecordCost(common.CostPhaseFieldLocation, tokenUsage)
+		}
+
+		// Step 5: Parse the JSON response
+		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+			return nil, tokenUsage, fmt.Errorf("no response from Gemini API")
+		}
+		var jsonResponse string
+		for _, part := range resp.Candidates[0].Content.Parts {
+			if text, ok := part.(genai.Text); ok {
+				jsonResponse += string(text)
+			}
+		}
+		if err := json.Unmarshal([]byte(jsonResponse), &response); err != nil {
+			return nil, tokenUsage, fmt.Errorf("failed to parse field location response: %w", err)
+		}
+	}
+
+	// Step 6: Keep boxes of requested fields on images that were sent (first box per field)
+	sent := map[int]bool{}
+	for _, img := range images {
+		sent[img.Index] = true
+	}
+	locations := []processor.FieldLocation{}
+	located := map[string]bool{}
+	for _, l := range response.Locations {
+		value, requested := values[l.Field]
+		if !requested || located[l.Field] || !sent[l.ImageIndex] {
+			continue
+		}
+		box, ok := processor.FieldBoxFromBox2D(l.Box2D)
+		if !ok {
+			continue
+		}
+		located[l.Field] = true
+		locations = append(locations, processor.FieldLocation{Field: l.Field, Value: value, ImageIndex: l.ImageIndex, Box: box})
+	}
+	return locations, tokenUsage, nil
+}
+
+// createFieldLocationSchema creates the JSON schema of the field locator
+func createFieldLocationSchema() *genai.Schema {
+	return &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"locations": {
+				Type: genai.TypeArray,
+				Items: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"field": {
+							Type:        genai.TypeString,
+							Description: "ชื่อฟิลด์ตามที่ให้ไว้ (vendor_name, vendor_tax_id, number, date, total, vat)",
+						},
+						"image_index": {
+							Type:        genai.TypeInteger,
+							Description: "เลข N ของป้าย Image N ของรูปที่พบค่า",
+						},
+						"box_2d": {
+							Type:        genai.TypeArray,
+							Description: "[ymin, xmin, ymax, xmax] สเกล 0-1000",
+							Items:       &genai.Schema{Type: genai.TypeInteger},
+						},
+					},
+					Required: []string{"field", "image_index", "box_2d"},
+				},
+			},
+		},
+		Required: []string{"locations"},
+	}
+}
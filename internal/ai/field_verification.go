@@ -0,0 +1,143 @@
+// field_verification.go - Second, targeted read of critical fields (total, VAT, date, tax ID)
+//
+// Pass 2 ใช้ Gemini อ่านรูปต้นฉบับอีกครั้งด้วย prompt ที่ถามเฉพาะฟิลด์สำคัญ
+// (ถ้า OCR ใช้ Mistral ก็จะเป็นการอ่านจาก provider อีกตัวโดยอัตโนมัติ)
+
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/mockai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ratelimit"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// VerifyCriticalFields re-reads total, VAT, date and vendor tax ID from the original images
+func VerifyCriticalFields(ctx context.Context, imagePaths []string, reqCtx *common.RequestContext) (*processor.CriticalFields, *common.TokenUsage, error) {
+	if configs.MOCK_AI {
+		return mockVerifyCriticalFields(reqCtx)
+	}
+
+	// Step 1: Load images (same preprocessing as OCR)
+	var blobs []genai.Part
+	for _, imagePath := range imagePaths {
+		imageData, mimeType, err := preprocessForGemini(imagePath, reqCtx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load image for verification: %w", err)
+		}
+		blobs = append(blobs, genai.Blob{MIMEType: mimeType, Data: imageData})
+	}
+
+	// Step 2: Initialize the Gemini client
+	client, err := genai.NewClient(ctx,
+		option.WithAPIKey(configs.GEMINI_API_KEY),
+		option.WithEndpoint("https://generativelanguage.googleapis.com"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Gemini client: %w", err)
+	}
+	defer client.Close()
+
+	model := client.GenerativeModel(reqCtx.Settings.OCRModel)
+	model.SetTemperature(0)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = createCriticalFieldsSchema()
+
+	prompt := GetCriticalFieldsPrompt()
+
+	// Step 3: Check cost budget before calling the API
+	projected := common.CalculateOCRTokenCost(
+		common.EstimateTextTokens(prompt)+common.EstimatedImageTokens*len(blobs),
+		common.EstimatedTemplateOutputTokens,
+	)
+	if err := reqCtx.ReserveCost(common.CostPhaseVerification, projected); err != nil {
+		return nil, nil, err
+	}
+
+	// Step 4: Call Gemini (single attempt - this pass is optional, don't hold the request on 429)
+	parts := append([]genai.Part{genai.Text(prompt)}, blobs...)
+
+	callStart := time.Now()
+	resp, err := callGeminiWithRetry(ctx, "gemini.verification", model, reqCtx, ratelimit.SingleAttemptPolicy, parts...)
+	reqCtx.RecordTrace(common.NewGeminiTrace(common.CostPhaseVerification, reqCtx.Settings.OCRModel, model, prompt,
+		fmt.Sprintf("%d image(s)", len(blobs)), resp, err, callStart))
+	if err != nil {
+		return nil, nil, fmt.Errorf("verification call failed: %w", err)
+	}
+
+	var tokenUsage *common.TokenUsage
+	if resp.UsageMetadata != nil {
+		tokens := common.CalculateOCRTokenCost(
+			int(resp.UsageMetadata.PromptTokenCount),
+			int(resp.UsageMetadata.CandidatesTokenCount),
+		)
+		tokenUsage = &tokens
+		reqCtx.RecordCost(common.CostPhaseVerification, tokenUsage)
+	}
+
+	// Step 5: Parse the JSON response
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return nil, tokenUsage, fmt.Errorf("no response from Gemini API")
+	}
+	var jsonResponse string
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if text, ok := part.(genai.Text); ok {
+			jsonResponse += string(text)
+		}
+	}
+
+	var fields processor.CriticalFields
+	if err := json.Unmarshal([]byte(jsonResponse), &fields); err != nil {
+		return nil, tokenUsage, fmt.Errorf("failed to parse verification response: %w", err)
+	}
+	return &fields, tokenUsage, nil
+}
+
+// createCriticalFieldsSchema creates the JSON schema for the verification pass
+func createCriticalFieldsSchema() *genai.Schema {
+	return &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"total": {
+				Type:        genai.TypeNumber,
+				Description: "ยอดรวมทั้งสิ้น (Grand Total) ที่ต้องชำระ - null ถ้าอ่านไม่ได้",
+				Nullable:    true,
+			},
+			"vat": {
+				Type:        genai.TypeNumber,
+				Description: "ภาษีมูลค่าเพิ่ม (VAT) - 0 ถ้าไม่มี VAT, null ถ้าอ่านไม่ได้",
+				Nullable:    true,
+			},
+			"date": {
+				Type:        genai.TypeString,
+				Description: "วันที่เอกสาร รูปแบบ YYYY-MM-DD ปี ค.ศ. (แปลง พ.ศ. → ค.ศ. ด้วยการลบ 543) - ว่างถ้าอ่านไม่ได้",
+			},
+			"vendor_tax_id": {
+				Type:        genai.TypeString,
+				Description: "เลขประจำตัวผู้เสียภาษี 13 หลักของผู้ออกเอกสาร (ไม่ใช่ของลูกค้า) - ว่างถ้าไม่มี",
+			},
+		},
+		Required: []string{"total", "vat", "date", "vendor_tax_id"},
+	}
+}
+
+// mockVerifyCriticalFields returns the verification fixture for MOCK_AI=true
+func mockVerifyCriticalFields(reqCtx *common.RequestContext) (*processor.CriticalFields, *common.TokenUsage, error) {
+	data, err := mockai.Fixture(mockai.FixtureVerification)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fields processor.CriticalFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, nil, fmt.Errorf("invalid mock verification fixture: %w", err)
+	}
+	reqCtx.LogInfo("🧪 MOCK_AI: returning field verification fixture")
+	return &fields, &common.TokenUsage{}, nil
+}
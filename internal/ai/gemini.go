@@ -16,6 +16,7 @@ import (
 
 	"github.com/bosocmputer/account_ocr_gemini/configs"
 	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/mockai"
 	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
 	"github.com/bosocmputer/account_ocr_gemini/internal/ratelimit"
 	"github.com/google/generative-ai-go/genai"
@@ -279,11 +280,12 @@ type AIMetadata struct {
 // SimpleOCRResult represents Pure OCR result (raw text only)
 type SimpleOCRResult struct {
 	Status          string     `json:"status"`
-	RawDocumentText string     `json:"raw_document_text"` // ข้อความทั้งหมดจากเอกสาร
-	IsPartial       bool       `json:"is_partial"`        // true if response was truncated due to token limit
-	TextLength      int        `json:"text_length"`       // length of extracted text in characters
-	Warning         string     `json:"warning,omitempty"` // warning message if any issues occurred
-	FallbackUsed    bool       `json:"fallback_used"`     // true if plain text fallback was used instead of JSON
+	RawDocumentText string     `json:"raw_document_text"`     // ข้อความทั้งหมดจากเอกสาร
+	IsPartial       bool       `json:"is_partial"`            // true if response was truncated due to token limit
+	TextLength      int        `json:"text_length"`           // length of extracted text in characters
+	Warning         string     `json:"warning,omitempty"`     // warning message if any issues occurred
+	FallbackUsed    bool       `json:"fallback_used"`         // true if plain text fallback was used instead of JSON
+	ChunksUsed      int        `json:"chunks_used,omitempty"` // >0 if text was stitched from chunked OCR (tiles/pages)
 	Metadata        AIMetadata `json:"metadata"`
 	RawResponse     string     `json:"raw_response,omitempty"`
 }
@@ -334,8 +336,12 @@ func (g *GeminiProvider) GetProviderName() string {
 }
 
 // ProcessPureOCR implements OCRProvider interface
-func (g *GeminiProvider) ProcessPureOCR(imagePath string, reqCtx *common.RequestContext) (*SimpleOCRResult, *common.TokenUsage, error) {
-	return processPureOCRGemini(imagePath, reqCtx, g.apiKey, g.modelName)
+func (g *GeminiProvider) ProcessPureOCR(ctx context.Context, imagePath string, reqCtx *common.RequestContext) (*SimpleOCRResult, *common.TokenUsage, error) {
+	modelName := reqCtx.Settings.OCRModel
+	if modelName == "" {
+		modelName = g.modelName
+	}
+	return processPureOCRGemini(ctx, imagePath, reqCtx, g.apiKey, modelName)
 }
 
 // --- Core Processing Function: Pure OCR (New Simplified Version) ---
@@ -343,20 +349,59 @@ func (g *GeminiProvider) ProcessPureOCR(imagePath string, reqCtx *common.Request
 // processPureOCRGemini processes the receipt image and extracts ONLY raw text using Gemini API
 // This is faster and cheaper than full structured extraction
 // DEPRECATED: Use GeminiProvider.ProcessPureOCR() instead for new code
-func ProcessPureOCR(imagePath string, reqCtx *common.RequestContext) (*SimpleOCRResult, *common.TokenUsage, error) {
-	return processPureOCRGemini(imagePath, reqCtx, configs.GEMINI_API_KEY, configs.OCR_MODEL_NAME)
+func ProcessPureOCR(ctx context.Context, imagePath string, reqCtx *common.RequestContext) (*SimpleOCRResult, *common.TokenUsage, error) {
+	return processPureOCRGemini(ctx, imagePath, reqCtx, configs.GEMINI_API_KEY, reqCtx.Settings.OCRModel)
+}
+
+// ocrProfile selects the image preprocessing and prompt used by Gemini OCR
+type ocrProfile struct {
+	Name       string
+	Preprocess func(imagePath string) ([]byte, string, error)
+	Prompt     func() string
+}
+
+// standardOCRProfile - printed receipts / tax invoices (default)
+var standardOCRProfile = ocrProfile{
+	Name:       "standard",
+	Preprocess: processor.PreprocessImageHighQuality,
+	Prompt:     GetPureOCRPrompt,
+}
+
+// handwrittenOCRProfile - handwritten bills (บิลเงินสด)
+var handwrittenOCRProfile = ocrProfile{
+	Name:       "handwritten",
+	Preprocess: processor.PreprocessImageHandwriting,
+	Prompt:     GetHandwrittenOCRPrompt,
 }
 
-func processPureOCRGemini(imagePath string, reqCtx *common.RequestContext, apiKey string, modelName string) (*SimpleOCRResult, *common.TokenUsage, error) {
-	reqCtx.LogInfo("🔵 Using Gemini AI provider (model: %s)", modelName)
-	// Step 1: Preprocess the image with HIGH QUALITY mode for maximum accuracy
-	// This applies aggressive enhancements: sharpen, contrast, brightness, grayscale
+// ProcessHandwrittenOCR re-reads a handwritten document with the handwriting preprocessing and prompt
+// Always uses Gemini (Mistral OCR has no prompt to tune)
+func ProcessHandwrittenOCR(ctx context.Context, imagePath string, reqCtx *common.RequestContext) (*SimpleOCRResult, *common.TokenUsage, error) {
+	if configs.MOCK_AI {
+		return NewMockProvider("gemini").ProcessPureOCR(ctx, imagePath, reqCtx)
+	}
+	return processPureOCRGeminiWithProfile(ctx, imagePath, reqCtx, configs.GEMINI_API_KEY, reqCtx.Settings.OCRModel, handwrittenOCRProfile)
+}
+
+func processPureOCRGemini(ctx context.Context, imagePath string, reqCtx *common.RequestContext, apiKey string, modelName string) (*SimpleOCRResult, *common.TokenUsage, error) {
+	return processPureOCRGeminiWithProfile(ctx, imagePath, reqCtx, apiKey, modelName, standardOCRProfile)
+}
+
+func processPureOCRGeminiWithProfile(ctx context.Context, imagePath string, reqCtx *common.RequestContext, apiKey string, modelName string, profile ocrProfile) (*SimpleOCRResult, *common.TokenUsage, error) {
+	reqCtx.LogInfo("🔵 Using Gemini AI provider (model: %s, profile: %s)", modelName, profile.Name)
+	// Step 0: Reject absurd files before decoding them (IMAGE_REJECT_MAX_BYTES / IMAGE_REJECT_MAX_MEGAPIXELS)
+	if err := processor.CheckImageFile(imagePath, imagePayloadLimits()); err != nil {
+		return nil, nil, err
+	}
+
+	// Step 1: Preprocess the image according to the profile
+	// standard = HIGH QUALITY mode (aggressive: sharpen, contrast, brightness, grayscale)
 	reqCtx.StartSubStep("image_preprocessing")
-	imageData, mimeType, err := processor.PreprocessImageHighQuality(imagePath)
+	imageData, mimeType, err := profile.Preprocess(imagePath)
 	reqCtx.EndSubStep("")
 	if err != nil {
 		// If preprocessing fails, fall back to original file
-		reqCtx.LogInfo("⚠️  High-quality preprocessing failed, using original: %v", err)
+		reqCtx.LogInfo("⚠️  %s preprocessing failed, using original: %v", profile.Name, err)
 		imageData, err = os.ReadFile(imagePath)
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to read file: %w", err)
@@ -379,6 +424,12 @@ func processPureOCRGemini(imagePath string, reqCtx *common.RequestContext, apiKe
 		}
 	}
 
+	// Step 1.5: Shrink to the payload budget (IMAGE_PAYLOAD_MAX_BYTES / IMAGE_PAYLOAD_MAX_TOKENS)
+	imageData, mimeType, err = fitImagePayload(imagePath, imageData, mimeType, reqCtx)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Log file size for debugging
 	fileSize := len(imageData)
 	fileType := "Image"
@@ -394,7 +445,6 @@ func processPureOCRGemini(imagePath string, reqCtx *common.RequestContext, apiKe
 
 	// Step 2: Initialize the Gemini client
 	reqCtx.StartSubStep("init_gemini_client")
-	ctx := context.Background()
 	// Use us-central1 endpoint to avoid region restrictions
 	client, err := genai.NewClient(ctx,
 		option.WithAPIKey(apiKey),
@@ -407,12 +457,13 @@ func processPureOCRGemini(imagePath string, reqCtx *common.RequestContext, apiKe
 	// Use OCR-specific model for Phase 1
 	model := client.GenerativeModel(modelName)
 
-	// Set explicit MaxOutputTokens to prevent silent truncation
+	// Set explicit MaxOutputTokens to prevent silent truncation (OCR_MAX_OUTPUT_TOKENS / MODEL_MAX_OUTPUT_TOKENS)
+	maxOutputTokens := configs.MaxOutputTokensFor(modelName, configs.OCR_MAX_OUTPUT_TOKENS)
 	model.GenerationConfig = genai.GenerationConfig{
-		MaxOutputTokens: ptr(int32(8192)), // Gemini's max output limit
+		MaxOutputTokens: ptr(int32(maxOutputTokens)),
 	}
 
-	reqCtx.LogInfo("📖 Phase 1 - OCR Model: %s (MaxOutputTokens: 8192)", modelName)
+	reqCtx.LogInfo("📖 Phase 1 - OCR Model: %s (MaxOutputTokens: %d)", modelName, maxOutputTokens)
 	reqCtx.EndSubStep("")
 
 	// Step 3: Define the simple JSON schema (raw text only)
@@ -428,21 +479,31 @@ func processPureOCRGemini(imagePath string, reqCtx *common.RequestContext, apiKe
 
 	// Step 5: Construct the prompt for Pure OCR (simplified)
 	reqCtx.StartSubStep("build_prompt")
-	// ใช้ Pure OCR prompt จากไฟล์ prompt_ocr.go - อ่านแค่ข้อความดิบ
-	prompt := GetPureOCRPrompt()
+	// ใช้ prompt จากไฟล์ prompt_ocr.go ตาม profile - อ่านแค่ข้อความดิบ
+	prompt := profile.Prompt()
 	reqCtx.EndSubStep("")
 
+	// Step 5.5: Check cost budget before calling the API
+	projected := common.CalculateOCRTokenCost(
+		common.EstimateTextTokens(prompt)+common.EstimatedImageTokens,
+		common.EstimatedOCROutputTokens,
+	)
+	if err := reqCtx.ReserveCost(common.CostPhaseOCR, projected); err != nil {
+		return nil, nil, err
+	}
+
 	// Step 6: Call the Gemini API with the actual image (with retry logic)
 	reqCtx.StartSubStep("call_gemini_api")
-	resp, err := callGeminiWithRetry(ctx, model,
+	callStart := time.Now()
+	resp, err := callGeminiWithRetry(ctx, "gemini.ocr", model, reqCtx, ratelimit.DefaultRetryPolicy,
 		genai.Text(prompt),
 		genai.Blob{
 			MIMEType: mimeType,
 			Data:     imageData,
 		},
-		reqCtx,
-		DefaultRetryConfig,
 	)
+	imageInput := fmt.Sprintf("%s, %d bytes (%s)", mimeType, len(imageData), filepath.Base(imagePath))
+	reqCtx.RecordTrace(common.NewGeminiTrace(common.CostPhaseOCR, modelName, model, prompt, imageInput, resp, err, callStart))
 	if err != nil {
 		reqCtx.EndSubStep("❌ FAILED")
 		// Check if it's a GeminiError and build user-friendly message
@@ -493,6 +554,22 @@ func processPureOCRGemini(imagePath string, reqCtx *common.RequestContext, apiKe
 		reqCtx.LogInfo("⚠️  Failed to parse JSON response. Preview: %s", preview)
 		reqCtx.LogInfo("⚠️  JSON Parse Error: %v. Trying fallback plain text extraction...", err)
 
+		// Truncated JSON → re-OCR in tiles/pages first (plain text of a long document would be truncated too)
+		if configs.ENABLE_CHUNKED_OCR && resp.Candidates[0].FinishReason == genai.FinishReasonMaxTokens {
+			reqCtx.EndSubStep("🧩 TRUNCATED → CHUNKED OCR")
+			reqCtx.StartSubStep("chunked_ocr")
+			chunkedResult, chunkedUsage, chunkedErr := chunkedOCR(ctx, client, modelName, imagePath, imageData, mimeType, reqCtx)
+			if chunkedErr == nil {
+				reqCtx.EndSubStep(fmt.Sprintf("✅ %d chunks", chunkedResult.ChunksUsed))
+				return chunkedResult, chunkedUsage, nil
+			}
+			reqCtx.EndSubStep("❌ CHUNKED OCR FAILED")
+			reqCtx.LogWarning("⚠️  Chunked OCR failed: %v", chunkedErr)
+			if reqCtx.BudgetError() != nil {
+				return nil, nil, chunkedErr
+			}
+		}
+
 		// FALLBACK: Try plain text extraction without JSON schema
 		reqCtx.StartSubStep("fallback_plain_text_ocr")
 		fallbackResult, fallbackUsage, fallbackErr := tryPlainTextOCR(ctx, client, imageData, mimeType, reqCtx)
@@ -517,7 +594,7 @@ func processPureOCRGemini(imagePath string, reqCtx *common.RequestContext, apiKe
 	// Step 8: Add AI metadata
 	reqCtx.StartSubStep("extract_metadata")
 	result.Metadata = AIMetadata{
-		ModelName: configs.OCR_MODEL_NAME,
+		ModelName: modelName,
 	}
 
 	// Set text length metadata
@@ -546,9 +623,23 @@ func processPureOCRGemini(imagePath string, reqCtx *common.RequestContext, apiKe
 			int(resp.UsageMetadata.CandidatesTokenCount),
 		)
 		tokenUsage = &tokens
+		reqCtx.RecordCost(common.CostPhaseOCR, tokenUsage)
 	}
 	reqCtx.EndSubStep(fmt.Sprintf("tokens: %d", tokenUsage.TotalTokens))
 
+	// Truncated but parseable → re-OCR in tiles/pages and stitch instead of returning partial text
+	if result.IsPartial && configs.ENABLE_CHUNKED_OCR {
+		reqCtx.StartSubStep("chunked_ocr")
+		chunkedResult, chunkedUsage, chunkedErr := chunkedOCR(ctx, client, modelName, imagePath, imageData, mimeType, reqCtx)
+		if chunkedErr == nil {
+			reqCtx.EndSubStep(fmt.Sprintf("✅ %d chunks", chunkedResult.ChunksUsed))
+			addTokenUsage(chunkedUsage, tokenUsage) // Include the truncated first attempt
+			return chunkedResult, chunkedUsage, nil
+		}
+		reqCtx.EndSubStep("❌ CHUNKED OCR FAILED")
+		reqCtx.LogWarning("⚠️  Chunked OCR failed, keeping partial text: %v", chunkedErr)
+	}
+
 	// Debug: Log what AI extracted in Phase 2 (Pure OCR)
 	log.Printf("[%s] 📄 PHASE 2 - Pure OCR Extraction:", reqCtx.RequestID)
 	log.Printf("[%s]   - Raw Document Text Length: %d chars", reqCtx.RequestID, len(result.RawDocumentText))
@@ -589,11 +680,11 @@ func tryPlainTextOCR(ctx context.Context, client *genai.Client, imageData []byte
 	reqCtx.LogInfo("🔄 Attempting plain text OCR fallback...")
 
 	// Use same OCR model
-	model := client.GenerativeModel(configs.OCR_MODEL_NAME)
+	model := client.GenerativeModel(reqCtx.Settings.OCRModel)
 
 	// Set MaxOutputTokens
 	model.GenerationConfig = genai.GenerationConfig{
-		MaxOutputTokens: ptr(int32(8192)),
+		MaxOutputTokens: ptr(int32(configs.MaxOutputTokensFor(reqCtx.Settings.OCRModel, configs.OCR_MAX_OUTPUT_TOKENS))),
 	}
 
 	// NO JSON schema - just plain text response
@@ -602,16 +693,25 @@ Read everything from top to bottom, left to right.
 Include headers, content, footers, notes, and any other text.
 Return ONLY the extracted text, nothing else.`
 
+	projected := common.CalculateOCRTokenCost(
+		common.EstimateTextTokens(prompt)+common.EstimatedImageTokens,
+		common.EstimatedOCROutputTokens,
+	)
+	if err := reqCtx.ReserveCost(common.CostPhaseOCR, projected); err != nil {
+		return nil, nil, err
+	}
+
 	// Call Gemini API
-	resp, err := callGeminiWithRetry(ctx, model,
+	callStart := time.Now()
+	resp, err := callGeminiWithRetry(ctx, "gemini.ocr_plain_text", model, reqCtx, ratelimit.DefaultRetryPolicy,
 		genai.Text(prompt),
 		genai.Blob{
 			MIMEType: mimeType,
 			Data:     imageData,
 		},
-		reqCtx,
-		DefaultRetryConfig,
 	)
+	imageInput := fmt.Sprintf("%s, %d bytes (plain text fallback)", mimeType, len(imageData))
+	reqCtx.RecordTrace(common.NewGeminiTrace(common.CostPhaseOCR, reqCtx.Settings.OCRModel, model, prompt, imageInput, resp, err, callStart))
 	if err != nil {
 		return nil, nil, fmt.Errorf("plain text OCR failed: %w", err)
 	}
@@ -663,7 +763,7 @@ Return ONLY the extracted text, nothing else.`
 		Warning:         warningMsg,
 		FallbackUsed:    true, // this is the fallback mode
 		Metadata: AIMetadata{
-			ModelName: configs.OCR_MODEL_NAME,
+			ModelName: reqCtx.Settings.OCRModel,
 		},
 		RawResponse: plainText,
 	}
@@ -680,6 +780,7 @@ Return ONLY the extracted text, nothing else.`
 			int(resp.UsageMetadata.CandidatesTokenCount),
 		)
 		tokenUsage = &tokens
+		reqCtx.RecordCost(common.CostPhaseOCR, tokenUsage)
 	}
 
 	return result, tokenUsage, nil
@@ -980,10 +1081,93 @@ func ParseFlexibleNumber(raw interface{}, confidence float64) FlexibleValue {
 // Old processAccountingAnalysis function has been removed
 // System now uses processMultiImageAccountingAnalysis for all accounting analysis
 
+// formatDocumentDirection tells the AI the direction detected by the Backend ("" when unknown)
+func formatDocumentDirection(direction *processor.DocumentDirection) string {
+	if direction == nil {
+		return ""
+	}
+	switch direction.Direction {
+	case processor.DirectionSale:
+		return fmt.Sprintf(`
+🧭 DOCUMENT DIRECTION (จาก Backend - เทียบกับข้อมูลร้าน): เอกสารขาย (%s)
+  - ร้านเราเป็นผู้ออกเอกสาร → คู่ค้าคือลูกหนี้: ใช้ debtor_code/debtor_name, ห้ามใช้ creditor
+  - บันทึกแบบขาย (เครดิตรายได้ / ภาษีขาย)
+`, direction.Reason)
+	case processor.DirectionPurchase:
+		return fmt.Sprintf(`
+🧭 DOCUMENT DIRECTION (จาก Backend - เทียบกับข้อมูลร้าน): เอกสารซื้อ (%s)
+  - ร้านเราเป็นลูกค้าในเอกสาร → คู่ค้าคือเจ้าหนี้: ใช้ creditor_code/creditor_name, ห้ามใช้ debtor
+`, direction.Reason)
+	}
+	return ""
+}
+
+// formatCustomFields lists the shop's custom fields to read into receipt.custom_fields ("" = none)
+func formatCustomFields(fields []common.CustomField) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n🏷️ CUSTOM FIELDS (ร้านกำหนด): อ่านค่าต่อไปนี้ใส่ receipt.custom_fields\n")
+	for _, field := range fields {
+		sb.WriteString(fmt.Sprintf("  - %s (%s)", field.Name, field.Type))
+		if field.Description != "" {
+			sb.WriteString(": " + field.Description)
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("  - อ่านจากเอกสารเท่านั้น ห้ามเดา - ไม่พบในเอกสารใส่ null, วันที่ใช้ YYYY-MM-DD ปี ค.ศ.\n")
+	return sb.String()
+}
+
+// formatDimensions lists the shop's dimensions and their codes to fill entries[].dimensions ("" = none)
+func formatDimensions(dimensions []common.DimensionDefinition, values map[string][]string) string {
+	if len(dimensions) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n🗂️ DIMENSIONS (ร้านกำหนด): ใส่รหัสใน entries[].dimensions ของทุกรายการ\n")
+	for _, dimension := range dimensions {
+		name := dimension.Name
+		if name == "" {
+			name = dimension.Key
+		}
+		sb.WriteString(fmt.Sprintf("  - %s (%s)", dimension.Key, name))
+		if options := values[dimension.Key]; len(options) > 0 {
+			sb.WriteString(": " + strings.Join(options, ", "))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("  - ใช้รหัสจากรายการนี้เท่านั้น เลือกเมื่อเอกสารระบุโครงการ / หน่วยงาน / สถานที่ตรงกัน - ไม่ระบุใส่ null (ห้ามเดา)\n")
+	return sb.String()
+}
+
+// formatVATRate tells the AI the VAT rate in force (VAT_RATE, VAT_RATE_HISTORY and the shop's own sales rate)
+func formatVATRate(reqCtx *common.RequestContext) string {
+	settings := common.DefaultRequestSettings()
+	if reqCtx != nil {
+		settings = reqCtx.Settings
+	}
+	cfg := configs.Get()
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("\n💱 VAT RATE (จาก Backend): อัตราปัจจุบัน %g%%\n", cfg.VATRate))
+	periods, _ := configs.ParseVATRateHistory(cfg.VATRateHistory)
+	for _, period := range periods {
+		sb.WriteString(fmt.Sprintf("  - เอกสารลงวันที่ก่อน %s ใช้อัตรา %g%%\n", period.Before, period.Rate))
+	}
+	if rate := settings.VATRateOn("", true); rate != cfg.VATRate {
+		sb.WriteString(fmt.Sprintf("  - เอกสารขายของร้านเราใช้อัตรา %g%%\n", rate))
+	}
+	sb.WriteString("  - ใช้อัตรานี้เข้าใจบริบทเท่านั้น - ตัวเลข VAT ให้อ่านจากเอกสารเสมอ\n")
+	return sb.String()
+}
+
 // processMultiImageAccountingAnalysis analyzes multiple images and creates merged accounting entries
 // NEW: Supports conditional master data loading via mode parameter
 // Accepts vendorMatchResult to inform AI about pre-matched vendors from Backend
-func ProcessMultiImageAccountingAnalysis(downloadedImages interface{}, fullResults interface{}, mode MasterDataMode, matchedTemplate *bson.M, accounts []bson.M, journalBooks []bson.M, creditors []bson.M, debtors []bson.M, shopProfile interface{}, documentTemplates []bson.M, vendorMatchResult *processor.VendorMatchResult, reqCtx *common.RequestContext) (string, *common.TokenUsage, error) {
+// direction (nil / unknown = AI decides) is the purchase/sale direction detected from the shop profile
+func ProcessMultiImageAccountingAnalysis(ctx context.Context, downloadedImages interface{}, fullResults interface{}, mode MasterDataMode, matchedTemplate *bson.M, accounts []bson.M, journalBooks []bson.M, creditors []bson.M, debtors []bson.M, shopProfile interface{}, documentTemplates []bson.M, vendorMatchResult *processor.VendorMatchResult, direction *processor.DocumentDirection, reqCtx *common.RequestContext) (string, *common.TokenUsage, error) {
 	// Convert all OCR results to JSON for AI analysis
 	allResultsJSON, _ := json.MarshalIndent(map[string]interface{}{
 		"full_ocr_results":  fullResults,
@@ -992,7 +1176,34 @@ func ProcessMultiImageAccountingAnalysis(downloadedImages interface{}, fullResul
 
 	// Build vendor matching info for AI
 	var vendorMatchInfo string
-	if vendorMatchResult != nil && vendorMatchResult.Found {
+	if vendorMatchResult != nil && vendorMatchResult.Found && vendorMatchResult.Party == processor.PartyDebtor {
+		// Sales document - our shop is the issuer, the customer was matched against Debtors
+		vendorMatchInfo = fmt.Sprintf(`
+🎯 PRE-MATCHED DEBTOR (เอกสารขาย - ร้านเราเป็นผู้ออกเอกสาร):
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+✅ ระบบได้จับคู่ลูกค้ากับ Debtors ให้แล้วโดยอัตโนมัติ:
+
+  Matched Code: %s
+  Matched Name: %s
+  Method: %s
+  Confidence: %.1f%%
+
+⚠️ สำคัญมาก:
+  - เป็นเอกสารขาย → ใช้ debtor_code = "%s" และ debtor_name = "%s" โดยตรง (ไม่ใช้ creditor)
+  - ไม่ต้องค้นหาใน Debtors list อีก
+  - ในส่วน vendor_matching ให้ใส่ matched_with: "%s - %s"
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+`,
+			vendorMatchResult.Code,
+			vendorMatchResult.Name,
+			vendorMatchResult.Method,
+			vendorMatchResult.Similarity,
+			vendorMatchResult.Code,
+			vendorMatchResult.Name,
+			vendorMatchResult.Code,
+			vendorMatchResult.Name,
+		)
+	} else if vendorMatchResult != nil && vendorMatchResult.Found {
 		vendorMatchInfo = fmt.Sprintf(`
 🎯 PRE-MATCHED VENDOR (จาก Backend Fuzzy Matching):
 ━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
@@ -1027,9 +1238,24 @@ func ProcessMultiImageAccountingAnalysis(downloadedImages interface{}, fullResul
 			vendorMatchResult.Method,
 			vendorMatchResult.Similarity,
 		)
+		if vendorMatchResult.LearnedAccountCode != "" {
+			vendorMatchInfo += fmt.Sprintf(`📌 บัญชีที่ผู้ใช้อนุมัติไว้สำหรับเจ้าหนี้รายนี้: %s %s
+  - ใช้บัญชีนี้เป็นรายการค่าใช้จ่ายหลัก (ยกเว้น template ระบุบัญชีไว้แล้ว)
+`, vendorMatchResult.LearnedAccountCode, vendorMatchResult.LearnedAccountName)
+		}
 	} else {
 		vendorMatchInfo = ""
 	}
+	vendorMatchInfo += formatDocumentDirection(direction)
+	vendorMatchInfo += formatVATRate(reqCtx)
+	settings := common.DefaultRequestSettings()
+	var dimensionValues map[string][]string
+	if reqCtx != nil {
+		vendorMatchInfo += GetLanguagePrompt(reqCtx.DocumentLanguage)
+		settings, dimensionValues = reqCtx.Settings, reqCtx.DimensionValues
+	}
+	vendorMatchInfo += formatCustomFields(settings.CustomFields)
+	vendorMatchInfo += formatDimensions(settings.Dimensions, dimensionValues)
 
 	// Build multi-image accounting prompt with conditional master data
 	prompt := BuildMultiImageAccountingPrompt(string(allResultsJSON), mode, matchedTemplate, accounts, journalBooks, creditors, debtors, shopProfile, documentTemplates, vendorMatchInfo)
@@ -1084,9 +1310,26 @@ func ProcessMultiImageAccountingAnalysis(downloadedImages interface{}, fullResul
 		}
 	}
 
+	// 🧪 MOCK_AI: prompt is fully built above (so prompt-building still runs) - return recorded response
+	if configs.MOCK_AI {
+		reqCtx.LogInfo("🧪 MOCK_AI: returning accounting fixture (prompt: %d chars)", len(prompt))
+		data, err := mockai.Fixture(mockai.FixtureAccounting)
+		if err != nil {
+			return "", nil, err
+		}
+		reqCtx.RecordTrace(common.AITrace{
+			Phase:             common.CostPhaseAccounting,
+			Provider:          "mock",
+			Model:             mockai.FixtureAccounting,
+			SystemInstruction: BuildAccountantSystemInstruction(shopContextForSystem, templateGuidanceForSystem),
+			Prompt:            prompt,
+			RawResponse:       string(data),
+		})
+		return string(data), &common.TokenUsage{}, nil
+	}
+
 	// Call Gemini API
 	reqCtx.StartSubStep("init_gemini_client")
-	ctx := context.Background()
 	// Use us-central1 endpoint to avoid region restrictions
 	client, err := genai.NewClient(ctx,
 		option.WithAPIKey(configs.GEMINI_API_KEY),
@@ -1102,16 +1345,26 @@ func ProcessMultiImageAccountingAnalysis(downloadedImages interface{}, fullResul
 	var selectedModelName string
 	var modeDesc string
 	if mode == TemplateOnlyMode {
-		selectedModelName = configs.TEMPLATE_ACCOUNTING_MODEL_NAME
+		selectedModelName = reqCtx.Settings.TemplateAccountingModel
 		modeDesc = "Template-only (≥95%)"
 	} else {
-		selectedModelName = configs.ACCOUNTING_MODEL_NAME
+		selectedModelName = reqCtx.Settings.AccountingModel
 		modeDesc = "Full analysis (<95%)"
 	}
+	if reqCtx.AccountingModel != "" {
+		selectedModelName = reqCtx.AccountingModel
+		modeDesc += ", model override"
+	}
 	reqCtx.LogInfo("🤖 AI Model: %s [%s] → Cost-optimized selection", selectedModelName, modeDesc)
 
 	model := client.GenerativeModel(selectedModelName)
 	model.SetTemperature(0.2)
+	if maxOutputTokens := configs.MaxOutputTokensFor(selectedModelName, 0); maxOutputTokens > 0 {
+		model.SetMaxOutputTokens(int32(maxOutputTokens))
+	}
+	// Structured output - response is always the accounting JSON (no ```json fences or extra prose)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = createAccountingSchema(settings)
 
 	// 🚨 Set System Instruction - CRITICAL for Template Enforcement
 	// System instructions have higher priority than user prompts
@@ -1125,37 +1378,24 @@ func ProcessMultiImageAccountingAnalysis(downloadedImages interface{}, fullResul
 	}
 	reqCtx.EndSubStep("")
 
+	// Check cost budget before calling the API (text-only prompt: OCR text + master data)
+	estimatedInputTokens := common.EstimateTextTokens(prompt) + common.EstimateTextTokens(systemInstructionText)
+	var projected common.TokenUsage
+	if mode == TemplateOnlyMode {
+		projected = common.CalculateTemplateAccountingTokenCost(estimatedInputTokens, common.EstimatedAccountingOutputTokens)
+	} else {
+		projected = common.CalculateAccountingTokenCost(estimatedInputTokens, common.EstimatedAccountingOutputTokens)
+	}
+	if err := reqCtx.ReserveCost(common.CostPhaseAccounting, projected); err != nil {
+		return "", nil, err
+	}
+
 	reqCtx.StartSubStep("call_gemini_api")
 	// For multi-image analysis, we pass all OCR data as text in the prompt
 	// Images already analyzed in previous steps
-	reqCtx.LogInfo("📤 ส่งคำขอไปยัง Gemini API...")
-
-	// Retry logic for 429 errors
-	var resp *genai.GenerateContentResponse
-	maxRetries := 3
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		// Apply rate limiting before EVERY API call (prevent hitting 15 RPM limit)
-		ratelimit.WaitForRateLimit()
-
-		resp, err = model.GenerateContent(ctx, genai.Text(prompt))
-		if err == nil {
-			break
-		}
-
-		// Check if it's a 429 error
-		errMsg := strings.ToLower(err.Error())
-		if strings.Contains(errMsg, "429") || strings.Contains(errMsg, "resource exhausted") {
-			if attempt < maxRetries {
-				waitTime := time.Duration(attempt*10) * time.Second
-				reqCtx.LogWarning("⚠️  Rate limit (429), waiting %v before retry (attempt %d/%d)", waitTime, attempt, maxRetries)
-				time.Sleep(waitTime)
-				continue
-			}
-		}
-		break
-	}
-
-	reqCtx.LogInfo("📥 ได้รับ response จาก Gemini API")
+	callStart := time.Now()
+	resp, err := callGeminiWithRetry(ctx, "gemini.accounting", model, reqCtx, ratelimit.DefaultRetryPolicy, genai.Text(prompt))
+	reqCtx.RecordTrace(common.NewGeminiTrace(common.CostPhaseAccounting, selectedModelName, model, prompt, "", resp, err, callStart))
 
 	if err != nil {
 		reqCtx.EndSubStep("❌ FAILED")
@@ -1163,7 +1403,7 @@ func ProcessMultiImageAccountingAnalysis(downloadedImages interface{}, fullResul
 			userMsg := buildUserFriendlyError(gemErr)
 			return "", nil, fmt.Errorf("%s (technical: %w)", userMsg, err)
 		}
-		return "", nil, fmt.Errorf("Gemini API call failed after %d attempts: %w", maxRetries, err)
+		return "", nil, fmt.Errorf("accounting analysis call failed: %w", err)
 	}
 	reqCtx.EndSubStep("")
 
@@ -1172,11 +1412,8 @@ func ProcessMultiImageAccountingAnalysis(downloadedImages interface{}, fullResul
 		return "", nil, fmt.Errorf("no response from Gemini")
 	}
 
+	// ResponseSchema → the part is the JSON itself (parsed by the caller)
 	responseText := fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])
-	responseText = strings.TrimPrefix(responseText, "```json")
-	responseText = strings.TrimPrefix(responseText, "```")
-	responseText = strings.TrimSuffix(responseText, "```")
-	responseText = strings.TrimSpace(responseText)
 	reqCtx.EndSubStep("")
 
 	// Debug: Log what AI decided for multi-image accounting
@@ -1225,6 +1462,7 @@ func ProcessMultiImageAccountingAnalysis(downloadedImages interface{}, fullResul
 			)
 		}
 		tokenUsage = &tokens
+		reqCtx.RecordCost(common.CostPhaseAccounting, tokenUsage)
 	}
 
 	return responseText, tokenUsage, nil
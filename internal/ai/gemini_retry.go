@@ -4,9 +4,9 @@ package ai
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/bosocmputer/account_ocr_gemini/internal/common"
 	"github.com/bosocmputer/account_ocr_gemini/internal/ratelimit"
@@ -14,22 +14,6 @@ import (
 	"google.golang.org/api/googleapi"
 )
 
-// RetryConfig defines retry behavior for Gemini API calls
-type RetryConfig struct {
-	MaxAttempts     int
-	InitialDelay    time.Duration
-	MaxDelay        time.Duration
-	BackoffMultiple float64
-}
-
-// DefaultRetryConfig provides sensible defaults for retry behavior
-var DefaultRetryConfig = RetryConfig{
-	MaxAttempts:     3,                // 3 attempts total
-	InitialDelay:    2 * time.Second,  // Start with 2s (increased from 1s)
-	MaxDelay:        60 * time.Second, // Max 60s for rate limit (increased from 8s)
-	BackoffMultiple: 2.0,
-}
-
 // GeminiError represents a categorized Gemini API error
 type GeminiError struct {
 	OriginalError error
@@ -43,6 +27,11 @@ func (e *GeminiError) Error() string {
 	return fmt.Sprintf("[%s] %s (status: %d, retryable: %v)", e.Category, e.Message, e.StatusCode, e.Retryable)
 }
 
+// Unwrap exposes the original error (e.g. *genai.BlockedError) to errors.As
+func (e *GeminiError) Unwrap() error {
+	return e.OriginalError
+}
+
 // categorizeGeminiError analyzes error and determines retry strategy
 func categorizeGeminiError(err error) *GeminiError {
 	if err == nil {
@@ -56,8 +45,17 @@ func categorizeGeminiError(err error) *GeminiError {
 		Retryable:     false,
 	}
 
-	// Check if it's a Google API error
-	if apiErr, ok := err.(*googleapi.Error); ok {
+	// Check for safety / copyright blocks (same request will be blocked again - never retry)
+	if blockErr, ok := AsSafetyBlock(err); ok {
+		geminiErr.Category = blockErr.Kind + "_blocked"
+		geminiErr.Message = blockErr.Error()
+		geminiErr.Retryable = false
+		return geminiErr
+	}
+
+	// Check if it's a Google API error (genai wraps it)
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
 		geminiErr.StatusCode = apiErr.Code
 
 		switch apiErr.Code {
@@ -106,14 +104,14 @@ func categorizeGeminiError(err error) *GeminiError {
 	}
 
 	// Check for context errors
-	if err == context.DeadlineExceeded {
+	if errors.Is(err, context.DeadlineExceeded) {
 		geminiErr.Category = "timeout"
 		geminiErr.Message = "Request timeout - processing took too long"
 		geminiErr.Retryable = true
 		return geminiErr
 	}
 
-	if err == context.Canceled {
+	if errors.Is(err, context.Canceled) {
 		geminiErr.Category = "canceled"
 		geminiErr.Message = "Request was canceled"
 		geminiErr.Retryable = false
@@ -158,106 +156,60 @@ func categorizeGeminiError(err error) *GeminiError {
 	return geminiErr
 }
 
-// callGeminiWithRetry executes a Gemini API call with retry logic
+// geminiRetryCall builds the shared retry settings of a Gemini call site
+// categorizeGeminiError decides what is retried (safety blocks, bad requests and quota errors are not)
+func geminiRetryCall(site string, policy ratelimit.RetryPolicy, reqCtx *common.RequestContext) ratelimit.RetryCall {
+	return ratelimit.RetryCall{
+		Site:   site,
+		Policy: policy,
+		Logger: reqCtx,
+		Classify: func(err error) ratelimit.RetryClass {
+			geminiErr := categorizeGeminiError(err)
+			return ratelimit.RetryClass{Retryable: geminiErr.Retryable, RateLimited: geminiErr.Category == "rate_limit"}
+		},
+	}
+}
+
+// callGeminiWithRetry executes a Gemini API call through the shared retry helper
+// site: key of the retry stats (e.g. "gemini.ocr"), policy: ratelimit.DefaultRetryPolicy or SingleAttemptPolicy
 func callGeminiWithRetry(
 	ctx context.Context,
+	site string,
 	model *genai.GenerativeModel,
-	prompt genai.Part,
-	image genai.Part,
 	reqCtx *common.RequestContext,
-	config RetryConfig,
+	policy ratelimit.RetryPolicy,
+	parts ...genai.Part,
 ) (*genai.GenerateContentResponse, error) {
-
-	var lastGeminiErr *GeminiError
-
-	for attempt := 1; attempt <= config.MaxAttempts; attempt++ {
+	var resp *genai.GenerateContentResponse
+	var lastErr error
+	attempts, err := ratelimit.Retry(ctx, geminiRetryCall(site, policy, reqCtx), func(attempt int) error {
 		// Apply rate limiting before EVERY API call (prevent hitting 15 RPM limit)
-		ratelimit.WaitForRateLimit()
-
-		// Log attempt
-		if attempt > 1 {
-			reqCtx.LogInfo("Retry attempt %d/%d", attempt, config.MaxAttempts)
+		if lastErr = ratelimit.WaitForRateLimit(ctx); lastErr != nil {
+			return lastErr // Cancelled / timed out while waiting for a token
 		}
 
-		// Make API call
 		reqCtx.LogInfo("📤 ส่งคำขอไปยัง Gemini API (attempt %d)...", attempt)
-		resp, err := model.GenerateContent(ctx, prompt, image)
+		resp, lastErr = model.GenerateContent(ctx, parts...)
 		reqCtx.LogInfo("📥 ได้รับ response จาก Gemini API")
-
-		// Success!
-		if err == nil {
-			if attempt > 1 {
-				reqCtx.LogInfo("✅ Retry succeeded on attempt %d", attempt)
-			}
-			return resp, nil
-		}
-
-		// Categorize error
-		lastGeminiErr = categorizeGeminiError(err)
-
-		// Log error details
-		reqCtx.LogError("API call failed (attempt %d/%d): %s", attempt, config.MaxAttempts, lastGeminiErr.Error())
-
-		// If error is not retryable, fail immediately
-		if !lastGeminiErr.Retryable {
-			reqCtx.LogError("Non-retryable error detected, aborting")
-			return nil, lastGeminiErr
-		}
-
-		// If this was the last attempt, don't sleep
-		if attempt >= config.MaxAttempts {
-			break
-		}
-
-		// Calculate delay with exponential backoff
-		delay := calculateBackoff(attempt, config)
-
-		// Special case: rate limit - use much longer delay (30-90 seconds)
-		if lastGeminiErr.Category == "rate_limit" {
-			// Gemini free tier: 15 RPM → need to wait ~30-90 seconds when rate limited
-			// Increased from 10s to 30s because Gemini blocks 60-120s after 429 error
-			delay = 30 * time.Second * time.Duration(attempt)
-			if delay > 90*time.Second {
-				delay = 90 * time.Second
-			}
-			reqCtx.LogWarning("⚠️  Rate limit hit (429), waiting %v before retry (attempt %d/%d)", delay, attempt, config.MaxAttempts)
-		} else {
-			reqCtx.LogInfo("Waiting %v before retry", delay)
-		}
-
-		// Wait before retry (respect context cancellation)
-		select {
-		case <-ctx.Done():
-			return nil, fmt.Errorf("context canceled during retry wait: %w", ctx.Err())
-		case <-time.After(delay):
-			// Continue to next attempt
+		return lastErr
+	})
+	if err == nil {
+		if attempts > 1 {
+			reqCtx.LogInfo("✅ Retry succeeded on attempt %d", attempts)
 		}
+		return resp, nil
 	}
 
-	// All attempts failed
-	reqCtx.LogError("❌ All %d attempts failed, last error: %s", config.MaxAttempts, lastGeminiErr.Error())
-	return nil, fmt.Errorf("gemini API call failed after %d attempts: %w", config.MaxAttempts, lastGeminiErr)
-}
-
-// calculateBackoff computes exponential backoff delay
-func calculateBackoff(attempt int, config RetryConfig) time.Duration {
-	delay := float64(config.InitialDelay) * pow(config.BackoffMultiple, float64(attempt-1))
-
-	// Cap at max delay
-	if delay > float64(config.MaxDelay) {
-		delay = float64(config.MaxDelay)
+	lastGeminiErr := categorizeGeminiError(lastErr)
+	if !lastGeminiErr.Retryable {
+		reqCtx.LogError("Non-retryable error detected, aborting: %s", lastGeminiErr.Error())
+		return nil, lastGeminiErr
 	}
-
-	return time.Duration(delay)
-}
-
-// pow computes base^exp for floats (simple implementation)
-func pow(base, exp float64) float64 {
-	result := 1.0
-	for i := 0; i < int(exp); i++ {
-		result *= base
+	reqCtx.LogError("❌ Gemini call failed after %d attempt(s): %v", attempts, err)
+	if err == lastErr {
+		return nil, fmt.Errorf("gemini API call failed after %d attempts: %w", attempts, lastGeminiErr)
 	}
-	return result
+	return nil, fmt.Errorf("gemini API call failed after %d attempts: %w", attempts, err)
 }
 
 // buildUserFriendlyError converts technical error to user-friendly message
@@ -294,6 +246,10 @@ func buildUserFriendlyError(geminiErr *GeminiError) map[string]interface{} {
 		errorResponse["suggestion"] = "Gemini service is temporarily unavailable. Please try again in a few minutes."
 		errorResponse["retry_recommended"] = true
 
+	case "safety_blocked", "copyright_blocked", "other_blocked":
+		errorResponse["suggestion"] = "The document was blocked by AI content filters. Crop the image to the receipt only (remove ID cards, people or printed media)."
+		errorResponse["action_required"] = "crop_image"
+
 	case "network_error":
 		errorResponse["suggestion"] = "Network connection issue. Please check your internet connection and try again."
 		errorResponse["retry_recommended"] = true
@@ -0,0 +1,46 @@
+// image_payload.go - Payload guard applied to every image before a Gemini call (see processor/payload_guard.go)
+
+package ai
+
+import (
+	"fmt"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+)
+
+// imagePayloadLimits reads IMAGE_PAYLOAD_* / IMAGE_REJECT_* at use time (reloadable)
+func imagePayloadLimits() processor.ImagePayloadLimits {
+	cfg := configs.Get()
+	return processor.ImagePayloadLimits{
+		MaxBytes:         cfg.ImagePayloadMaxBytes,
+		MaxTokens:        cfg.ImagePayloadMaxTokens,
+		RejectBytes:      cfg.ImageRejectMaxBytes,
+		RejectMegapixels: cfg.ImageRejectMaxMegapixels,
+	}
+}
+
+// fitImagePayload shrinks a preprocessed image to the payload budget and records the reduction on the request
+func fitImagePayload(imagePath string, data []byte, mimeType string, reqCtx *common.RequestContext) ([]byte, string, error) {
+	fitted, fittedMIME, reduction, err := processor.FitImagePayload(imagePath, data, mimeType, imagePayloadLimits())
+	if err != nil {
+		return nil, "", err
+	}
+	if reduction != nil {
+		reqCtx.RecordImageReduction(*reduction)
+	}
+	return fitted, fittedMIME, nil
+}
+
+// preprocessForGemini rejects absurd files, applies the high quality preprocessing and fits the payload budget
+func preprocessForGemini(imagePath string, reqCtx *common.RequestContext) ([]byte, string, error) {
+	if err := processor.CheckImageFile(imagePath, imagePayloadLimits()); err != nil {
+		return nil, "", err
+	}
+	imageData, mimeType, err := processor.PreprocessImageHighQuality(imagePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to preprocess image: %w", err)
+	}
+	return fitImagePayload(imagePath, imageData, mimeType, reqCtx)
+}
@@ -3,6 +3,8 @@
 package ai
 
 import (
+	"context"
+
 	"github.com/bosocmputer/account_ocr_gemini/internal/common"
 )
 
@@ -10,10 +12,11 @@ import (
 // This allows us to support multiple AI providers (Gemini, Mistral, etc.) with the same interface
 type OCRProvider interface {
 	// ProcessPureOCR processes an image and extracts raw text
+	// ctx: deadline / cancellation of the OCR call (derived from the request)
 	// imagePath: path to the image file
 	// reqCtx: request context for logging and tracking
 	// Returns: SimpleOCRResult, TokenUsage, and error
-	ProcessPureOCR(imagePath string, reqCtx *common.RequestContext) (*SimpleOCRResult, *common.TokenUsage, error)
+	ProcessPureOCR(ctx context.Context, imagePath string, reqCtx *common.RequestContext) (*SimpleOCRResult, *common.TokenUsage, error)
 
 	// GetProviderName returns the name of the provider (e.g., "gemini", "mistral")
 	GetProviderName() string
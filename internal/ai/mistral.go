@@ -9,15 +9,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/bosocmputer/account_ocr_gemini/configs"
 	"github.com/bosocmputer/account_ocr_gemini/internal/common"
 	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ratelimit"
 )
 
 // MistralProvider implements OCRProvider interface for Mistral AI
@@ -52,8 +55,21 @@ type mistralOCRDocument struct {
 }
 
 type mistralOCRRequest struct {
-	Model    string             `json:"model"`
-	Document mistralOCRDocument `json:"document"`
+	Model       string             `json:"model"`
+	Document    mistralOCRDocument `json:"document"`
+	TableFormat string             `json:"table_format,omitempty"` // "markdown" → tables returned separately, placeholders in the page markdown
+}
+
+// mistralTableFormat - tables come back as markdown rows (inlined by normalizeMistralMarkdown)
+const mistralTableFormat = "markdown"
+
+type mistralOCRTable struct {
+	ID      string `json:"id"` // Placeholder in the page markdown: [tbl-0.md](tbl-0.md)
+	Content string `json:"content"`
+}
+
+type mistralFileResponse struct {
+	ID string `json:"id"`
 }
 
 type mistralOCRPageDimensions struct {
@@ -67,7 +83,7 @@ type mistralOCRPage struct {
 	Markdown   string                   `json:"markdown"`
 	Images     []interface{}            `json:"images"`
 	Dimensions mistralOCRPageDimensions `json:"dimensions"`
-	Tables     []interface{}            `json:"tables"`
+	Tables     []mistralOCRTable        `json:"tables"`
 	Hyperlinks []interface{}            `json:"hyperlinks"`
 	Header     interface{}              `json:"header"`
 	Footer     interface{}              `json:"footer"`
@@ -92,18 +108,57 @@ type mistralErrorResponse struct {
 	} `json:"error"`
 }
 
+// mistralAPIError is a non-200 response (status + Retry-After feed the shared retry helper)
+type mistralAPIError struct {
+	api        string
+	statusCode int
+	message    string
+	retryAfter time.Duration
+}
+
+func (e *mistralAPIError) Error() string {
+	return fmt.Sprintf("%s error (%d): %s", e.api, e.statusCode, e.message)
+}
+
+// HTTPStatus implements ratelimit.HTTPStatusError
+func (e *mistralAPIError) HTTPStatus() int {
+	return e.statusCode
+}
+
+// RetryAfter implements ratelimit.RetryAfterError
+func (e *mistralAPIError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// newMistralAPIError builds the error of a non-200 response
+func newMistralAPIError(api string, resp *http.Response, body []byte) *mistralAPIError {
+	apiErr := &mistralAPIError{
+		api:        api,
+		statusCode: resp.StatusCode,
+		message:    string(body),
+		retryAfter: ratelimit.ParseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	var errorResp mistralErrorResponse
+	if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error.Message != "" {
+		apiErr.message = errorResp.Error.Message
+	}
+	return apiErr
+}
+
 // ProcessPureOCR processes image using Mistral AI
-func (m *MistralProvider) ProcessPureOCR(imagePath string, reqCtx *common.RequestContext) (*SimpleOCRResult, *common.TokenUsage, error) {
+func (m *MistralProvider) ProcessPureOCR(ctx context.Context, imagePath string, reqCtx *common.RequestContext) (*SimpleOCRResult, *common.TokenUsage, error) {
 	reqCtx.LogInfo("🔷 Using Mistral AI provider (model: %s)", m.modelName)
 
 	// Step 1: Check if imagePath is a URL (from frontend)
 	reqCtx.StartSubStep("mistral_ocr_api_call")
 
 	var request mistralOCRRequest
+	var traceInput string // Request document without the base64 payload (for AI traces)
 
 	// If imagePath is a URL (starts with http:// or https://), use it directly
 	if strings.HasPrefix(imagePath, "http://") || strings.HasPrefix(imagePath, "https://") {
 		reqCtx.LogInfo("📊 Using URL directly: %s", imagePath)
+		traceInput = "document_url: " + imagePath
 		request = mistralOCRRequest{
 			Model: m.modelName,
 			Document: mistralOCRDocument{
@@ -142,29 +197,82 @@ func (m *MistralProvider) ProcessPureOCR(imagePath string, reqCtx *common.Reques
 		}
 
 		reqCtx.LogInfo("📊 Image size: %.2f KB, MIME type: %s", float64(len(imageData))/1024.0, mimeType)
+		traceInput = fmt.Sprintf("%s, %d bytes (%s)", mimeType, len(imageData), filepath.Base(imagePath))
 
-		// Mistral OCR API does not support PDF as base64
 		if mimeType == "application/pdf" {
-			return nil, nil, fmt.Errorf("mistral OCR API does not support PDF files sent as base64. Please use an image format (JPEG, PNG, etc.) or provide a URL")
+			// PDFs cannot be sent as base64 - upload the file and OCR it by file ID
+			reqCtx.StartSubStep("mistral_file_upload")
+			var fileID string
+			uploadCall := ratelimit.RetryCall{Site: "mistral.file_upload", Policy: ratelimit.DefaultRetryPolicy, Logger: reqCtx}
+			_, err := ratelimit.Retry(ctx, uploadCall, func(attempt int) error {
+				var uploadErr error
+				fileID, uploadErr = m.uploadFile(ctx, imagePath, imageData)
+				return uploadErr
+			})
+			reqCtx.EndSubStep("")
+			if err != nil {
+				return nil, nil, fmt.Errorf("mistral file upload failed: %w", err)
+			}
+			defer m.deleteFile(fileID, reqCtx)
+			reqCtx.LogInfo("📤 Uploaded %s to Mistral (file_id: %s)", filepath.Base(imagePath), fileID)
+			traceInput = fmt.Sprintf("file_id: %s (%s, %d bytes)", fileID, filepath.Base(imagePath), len(imageData))
+
+			reqCtx.StartSubStep("mistral_ocr_api_call")
+			request = mistralOCRRequest{
+				Model: m.modelName,
+				Document: mistralOCRDocument{
+					Type:   "file",
+					FileID: fileID,
+				},
+			}
+		} else {
+			// For images, encode to base64 with proper MIME type
+			base64Image := base64.StdEncoding.EncodeToString(imageData)
+			imageURL := fmt.Sprintf("data:%s;base64,%s", mimeType, base64Image)
+
+			reqCtx.StartSubStep("mistral_ocr_api_call")
+			request = mistralOCRRequest{
+				Model: m.modelName,
+				Document: mistralOCRDocument{
+					Type:     "image_url",
+					ImageURL: imageURL,
+				},
+			}
 		}
+	}
+	request.TableFormat = mistralTableFormat
 
-		// For images, encode to base64 with proper MIME type
-		base64Image := base64.StdEncoding.EncodeToString(imageData)
-		imageURL := fmt.Sprintf("data:%s;base64,%s", mimeType, base64Image)
-
-		reqCtx.StartSubStep("mistral_ocr_api_call")
-		request = mistralOCRRequest{
-			Model: m.modelName,
-			Document: mistralOCRDocument{
-				Type:     "image_url",
-				ImageURL: imageURL,
-			},
-		}
+	// Step 3.5: Check cost budget (page count is unknown before the call - assume 1 page)
+	if err := reqCtx.ReserveCost(common.CostPhaseOCR, common.EstimateMistralOCRCost(1)); err != nil {
+		reqCtx.EndSubStep("")
+		return nil, nil, err
 	}
 
 	// Step 4: Call Mistral OCR API
-	response, err := m.callMistralOCRAPI(request)
+	callStart := time.Now()
+	var response *mistralOCRResponse
+	retryCall := ratelimit.RetryCall{Site: "mistral.ocr", Policy: ratelimit.DefaultRetryPolicy, Logger: reqCtx}
+	_, err := ratelimit.Retry(ctx, retryCall, func(attempt int) error {
+		var callErr error
+		response, callErr = m.callMistralOCRAPI(ctx, request)
+		return callErr
+	})
 	reqCtx.EndSubStep("")
+	if common.TracingEnabled() {
+		trace := common.AITrace{
+			Phase:      common.CostPhaseOCR,
+			Provider:   "mistral",
+			Model:      m.modelName,
+			Input:      traceInput,
+			DurationMs: time.Since(callStart).Milliseconds(),
+		}
+		if err != nil {
+			trace.Error = err.Error()
+		} else if rawJSON, jsonErr := json.Marshal(response); jsonErr == nil {
+			trace.RawResponse = string(rawJSON)
+		}
+		reqCtx.RecordTrace(trace)
+	}
 	if err != nil {
 		return nil, nil, fmt.Errorf("mistral OCR API call failed: %w", err)
 	}
@@ -174,13 +282,13 @@ func (m *MistralProvider) ProcessPureOCR(imagePath string, reqCtx *common.Reques
 		return nil, nil, fmt.Errorf("no pages returned from Mistral OCR API")
 	}
 
-	// Combine all pages' markdown content
+	// Combine all pages (markdown normalized to plain lines like Gemini's raw text, table rows kept)
 	var extractedText strings.Builder
 	for i, page := range response.Pages {
 		if i > 0 {
 			extractedText.WriteString("\n\n")
 		}
-		extractedText.WriteString(page.Markdown)
+		extractedText.WriteString(normalizeMistralMarkdown(page))
 	}
 	finalText := extractedText.String()
 	reqCtx.LogInfo("✅ Extracted text from %d page(s), length: %d characters", len(response.Pages), len(finalText))
@@ -200,9 +308,9 @@ func (m *MistralProvider) ProcessPureOCR(imagePath string, reqCtx *common.Reques
 	// Step 6: Calculate costs
 	// Mistral OCR 3: $2 per 1,000 pages
 	pagesProcessed := response.UsageInfo.PagesProcessed
-	costPerPage := 0.002 // $2 / 1000 = $0.002 per page
+	costPerPage := common.MistralCostPerPageUSD // $2 / 1000 = $0.002 per page
 	totalCostUSD := float64(pagesProcessed) * costPerPage
-	totalCostTHB := totalCostUSD * configs.USD_TO_THB
+	totalCostTHB := totalCostUSD * configs.Get().USDToTHB
 
 	tokenUsage := &common.TokenUsage{
 		InputTokens:  pagesProcessed, // Store pages as "tokens" for compatibility
@@ -212,6 +320,8 @@ func (m *MistralProvider) ProcessPureOCR(imagePath string, reqCtx *common.Reques
 		CostTHB:      totalCostTHB,
 	}
 
+	reqCtx.RecordCost(common.CostPhaseOCR, tokenUsage)
+
 	reqCtx.LogInfo("💰 Cost: %d page(s) × $%.3f = $%.6f USD (%.2f THB)", pagesProcessed, costPerPage, totalCostUSD, totalCostTHB)
 
 	// Step 7: Build result
@@ -233,7 +343,7 @@ func (m *MistralProvider) ProcessPureOCR(imagePath string, reqCtx *common.Reques
 }
 
 // callMistralOCRAPI makes HTTP request to Mistral OCR API
-func (m *MistralProvider) callMistralOCRAPI(request mistralOCRRequest) (*mistralOCRResponse, error) {
+func (m *MistralProvider) callMistralOCRAPI(ctx context.Context, request mistralOCRRequest) (*mistralOCRResponse, error) {
 	// Marshal request
 	requestBody, err := json.Marshal(request)
 	if err != nil {
@@ -242,7 +352,7 @@ func (m *MistralProvider) callMistralOCRAPI(request mistralOCRRequest) (*mistral
 
 	// Create HTTP request to OCR endpoint
 	req, err := http.NewRequestWithContext(
-		context.Background(),
+		ctx,
 		"POST",
 		"https://api.mistral.ai/v1/ocr",
 		bytes.NewBuffer(requestBody),
@@ -270,11 +380,7 @@ func (m *MistralProvider) callMistralOCRAPI(request mistralOCRRequest) (*mistral
 
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
-		var errorResp mistralErrorResponse
-		if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error.Message != "" {
-			return nil, fmt.Errorf("mistral OCR API error (%d): %s", resp.StatusCode, errorResp.Error.Message)
-		}
-		return nil, fmt.Errorf("mistral OCR API error (%d): %s", resp.StatusCode, string(body))
+		return nil, newMistralAPIError("mistral OCR API", resp, body)
 	}
 
 	// Parse response
@@ -285,3 +391,130 @@ func (m *MistralProvider) callMistralOCRAPI(request mistralOCRRequest) (*mistral
 
 	return &response, nil
 }
+
+// uploadFile uploads a local document to Mistral Files (purpose "ocr") and returns its file ID
+// ใช้กับ PDF ที่ส่งเป็น base64 ไม่ได้ (ไฟล์ถูกลบหลัง OCR เสร็จ - deleteFile)
+func (m *MistralProvider) uploadFile(ctx context.Context, filePath string, data []byte) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("purpose", "ocr"); err != nil {
+		return "", fmt.Errorf("failed to write form field: %w", err)
+	}
+	part, err := writer.CreateFormFile("file", filepath.Base(filePath))
+	if err != nil {
+		return "", fmt.Errorf("failed to create form file: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return "", fmt.Errorf("failed to write form file: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to close form: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.mistral.ai/v1/files", &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", m.apiKey))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", newMistralAPIError("mistral files API", resp, respBody)
+	}
+
+	var file mistralFileResponse
+	if err := json.Unmarshal(respBody, &file); err != nil {
+		return "", fmt.Errorf("failed to parse files response: %w", err)
+	}
+	if file.ID == "" {
+		return "", fmt.Errorf("mistral files API returned no file id")
+	}
+	return file.ID, nil
+}
+
+// deleteFile removes an uploaded file (best effort - a leftover file only costs storage on Mistral)
+func (m *MistralProvider) deleteFile(fileID string, reqCtx *common.RequestContext) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", "https://api.mistral.ai/v1/files/"+fileID, nil)
+	if err != nil {
+		reqCtx.LogWarning("⚠️  Failed to delete Mistral file %s: %v", fileID, err)
+		return
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", m.apiKey))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		reqCtx.LogWarning("⚠️  Failed to delete Mistral file %s: %v", fileID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		reqCtx.LogWarning("⚠️  Failed to delete Mistral file %s: status %d", fileID, resp.StatusCode)
+	}
+}
+
+var (
+	mistralTablePlaceholder = regexp.MustCompile(`\[(tbl-[^\]]+)\]\([^)]*\)`)
+	mistralImageLink        = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	mistralTableSeparator   = regexp.MustCompile(`^\|?\s*:?-{3,}:?\s*(\|\s*:?-{3,}:?\s*)*\|?$`)
+)
+
+// normalizeMistralMarkdown converts a Mistral OCR page to plain text lines (same shape as Gemini's raw text)
+// ตารางถูกแทนที่กลับเข้าตำแหน่งเดิม แต่ละแถวเป็น 1 บรรทัด คั่นคอลัมน์ด้วย " | " → Phase 3 อ่านรายการสินค้าได้เหมือนกันทั้งสอง provider
+func normalizeMistralMarkdown(page mistralOCRPage) string {
+	// Step 1: Put the tables back in place of their placeholders
+	tables := make(map[string]string, len(page.Tables))
+	for _, table := range page.Tables {
+		tables[table.ID] = table.Content
+	}
+	markdown := mistralTablePlaceholder.ReplaceAllStringFunc(page.Markdown, func(link string) string {
+		id := mistralTablePlaceholder.FindStringSubmatch(link)[1]
+		for _, key := range []string{id, strings.TrimSuffix(id, ".md")} {
+			if content, ok := tables[key]; ok {
+				return "\n" + content + "\n"
+			}
+		}
+		return link
+	})
+
+	// Step 2: Strip markdown syntax line by line (table rows → "cell | cell | cell")
+	var lines []string
+	for _, line := range strings.Split(markdown, "\n") {
+		line = strings.TrimSpace(mistralImageLink.ReplaceAllString(line, ""))
+		if mistralTableSeparator.MatchString(line) {
+			continue
+		}
+		if strings.HasPrefix(line, "|") {
+			cells := strings.Split(strings.Trim(line, "|"), "|")
+			for i, cell := range cells {
+				cells[i] = strings.TrimSpace(cell)
+			}
+			line = strings.Join(cells, " | ")
+		}
+		line = strings.TrimSpace(strings.TrimLeft(line, "#"))
+		line = strings.NewReplacer("**", "", "__", "").Replace(line)
+		lines = append(lines, line)
+	}
+
+	// Step 3: Collapse runs of blank lines
+	var out []string
+	for _, line := range lines {
+		if line == "" && (len(out) == 0 || out[len(out)-1] == "") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
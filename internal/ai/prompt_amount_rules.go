@@ -99,8 +99,8 @@ func GetTemplateAmountDistributionRules() string {
 → **ไม่ว่าจะมี Template หรือไม่มี ให้ใช้เฉพาะตัวเลขที่อ่านได้จาก OCR เสมอ**
 
 ❌ ห้ามคำนวณ:
-  - ห้ามคำนวณรายได้จาก (ยอดรวม × 100/107)
-  - ห้ามคำนวณภาษีจาก (ยอดรวม × 7/107)
+  - ห้ามคำนวณรายได้จาก (ยอดรวม × 100/(100+อัตรา VAT))
+  - ห้ามคำนวณภาษีจาก (ยอดรวม × อัตรา VAT/(100+อัตรา VAT))
   - ห้ามใช้สูตรใดๆ แม้ Template จะระบุให้คำนวณก็ตาม
 
 ✅ ใช้เฉพาะตัวเลขที่เห็น:
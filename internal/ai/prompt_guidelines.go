@@ -18,7 +18,7 @@ func GetAdditionalGuidelines() string {
 📊 การจัดการภาษีมูลค่าเพิ่ม (VAT):
 - ธุรกิจจดทะเบียน VAT → แยกบัญชี "ภาษีซื้อ" (115810) หรือ "ภาษีขาย" (213110)
 - ธุรกิจไม่จดทะเบียน VAT → รวม VAT เข้าในค่าใช้จ่าย/รายได้เลย
-- ตรวจสอบ Shop Context เพื่อดูสถานะ VAT
+- ตรวจสอบสถานะ VAT จาก vat_registered ใน Shop Profile (ถ้าไม่มี ดูจาก Shop Context)
 
 💼 ภาษีหัก ณ ที่จ่าย (Withholding Tax):
 - มักพบในค่าบริการ, ค่าเช่า, ค่าเงินเดือน
@@ -38,7 +38,13 @@ func GetAdditionalGuidelines() string {
    - ซื้อ → Dr. ค่าใช้จ่าย + Dr. ภาษีซื้อ, Cr. เงินสด
    - ขาย → Dr. เงินสด, Cr. รายได้ + Cr. ภาษีขาย
 
-4. **บิลค่าสาธารณูปโภค** (น้ำ/ไฟ/โทรศัพท์):
+4. **ใบลดหนี้ (Credit Note) / ใบเพิ่มหนี้ (Debit Note)**:
+   - source_images[].type = credit_note / debit_note (ห้ามใส่ tax_invoice)
+   - ใบลดหนี้ = กลับรายการเดิม → ซื้อ: Dr. เจ้าหนี้/เงินสด, Cr. ค่าใช้จ่ายเดิม + Cr. ภาษีซื้อ
+   - ใบเพิ่มหนี้ = บันทึกเพิ่มเหมือนใบกำกับภาษีปกติ
+   - ระบุ receipt.original_document_number = เลขที่ใบกำกับภาษีเดิมที่อ้างอิง
+
+5. **บิลค่าสาธารณูปโภค** (น้ำ/ไฟ/โทรศัพท์):
    - มักไม่มี VAT หรือมีภาษีหัก ณ ที่จ่าย
    - ใช้ยอดรวมไปเลย + แยกภาษีหัก ณ ที่จ่าย (ถ้ามี)
 
@@ -0,0 +1,51 @@
+// prompt_language.go - Language-specific guidance for foreign documents
+//
+// prompt หลักเขียนสำหรับเอกสารไทย → เอกสารภาษาอังกฤษ / จีน / ญี่ปุ่น (ธุรกิจนำเข้า) ได้ส่วนเพิ่มนี้ต่อท้าย
+// ภาษาตรวจจากข้อความ OCR ก่อน Phase 3 (processor.DetectLanguage) - เอกสารไทยไม่มีส่วนนี้
+
+package ai
+
+import "github.com/bosocmputer/account_ocr_gemini/internal/processor"
+
+// GetLanguagePrompt returns the glossary, date and currency rules of a foreign document ("" for Thai)
+func GetLanguagePrompt(language string) string {
+	switch language {
+	case processor.LanguageEnglish:
+		return `
+🌐 DOCUMENT LANGUAGE (จาก Backend): ภาษาอังกฤษ
+📖 คำศัพท์:
+  - Invoice / Tax Invoice / Commercial Invoice = ใบแจ้งหนี้ / ใบกำกับภาษี, Receipt = ใบเสร็จรับเงิน, Credit Note = ใบลดหนี้
+  - Subtotal / Net Amount = มูลค่าก่อนภาษี, VAT / GST / Sales Tax = ภาษี, Total / Grand Total / Amount Due = ยอดรวม
+  - Bill To / Sold To = ลูกค้า (ผู้ซื้อ), Ship To = ที่อยู่จัดส่ง (ไม่ใช่ผู้ซื้อ), Seller / From = ผู้ขาย
+📅 วันที่: ตอบ YYYY-MM-DD - "March 5, 2024" / "5 Mar 2024" → 2024-03-05, ตัวเลขล้วน "03/05/2024" ของเอกสารสหรัฐ = เดือน/วัน/ปี
+💱 สกุลเงิน: ใส่ receipt.currency เป็นรหัส ISO 4217 (USD, EUR, SGD, ...) - $ ไม่มีระบุประเทศ = USD
+  - ตัวเลขใช้ตามเอกสาร (ไม่แปลงเป็นบาท), "1,234.50" = หนึ่งพันสองร้อยสามสิบสี่จุดห้า
+  - ชื่อบัญชี / description ใน entries ใช้ภาษาไทยตามผังบัญชีของร้าน
+`
+	case processor.LanguageChinese:
+		return `
+🌐 DOCUMENT LANGUAGE (จาก Backend): ภาษาจีน
+📖 คำศัพท์:
+  - 发票 / 增值税专用发票 / 增值税普通发票 = ใบกำกับภาษี, 收据 = ใบเสร็จ, 形式发票 = Proforma
+  - 金额 / 不含税金额 = มูลค่าก่อนภาษี, 税率 = อัตราภาษี, 税额 = ภาษี, 价税合计 / 合计 / 总计 = ยอดรวม
+  - 销售方 / 卖方 = ผู้ขาย, 购买方 / 买方 = ผู้ซื้อ, 纳税人识别号 = เลขผู้เสียภาษี, 开票日期 = วันที่ออกใบกำกับ
+📅 วันที่: "2024年3月5日" → 2024-03-05 (ปี/เดือน/วัน)
+💱 สกุลเงิน: ใส่ receipt.currency = CNY เมื่อเห็น ¥ / 元 / 人民币 / RMB (ยกเว้นเอกสารระบุสกุลอื่น)
+  - ยอดเป็นตัวอักษรจีน (大写: 壹贰叁...) ให้ใช้ยอดตัวเลขที่อยู่คู่กัน
+  - ตัวเลขใช้ตามเอกสาร (ไม่แปลงเป็นบาท), ชื่อบัญชี / description ใช้ภาษาไทยตามผังบัญชีของร้าน
+`
+	case processor.LanguageJapanese:
+		return `
+🌐 DOCUMENT LANGUAGE (จาก Backend): ภาษาญี่ปุ่น
+📖 คำศัพท์:
+  - 請求書 = ใบแจ้งหนี้, 領収書 = ใบเสร็จ, 納品書 = ใบส่งของ, 適格請求書 / インボイス = ใบกำกับภาษี
+  - 小計 / 税抜 = มูลค่าก่อนภาษี, 消費税 = ภาษี, 合計 / 税込 / ご請求金額 = ยอดรวม, 軽減税率 (8%) = อัตราลดหย่อน
+  - 御中 / 様 ต่อท้ายชื่อ = ผู้รับเอกสาร (ผู้ซื้อ), 登録番号 (T + 13 หลัก) = เลขทะเบียนผู้ออกใบกำกับ
+📅 วันที่: "2024年3月5日" → 2024-03-05, ปีรัชศก 令和 (R) ปีที่ N = ค.ศ. 2018 + N (令和6年 = 2024), 平成 (H) ปีที่ N = ค.ศ. 1988 + N
+💱 สกุลเงิน: ใส่ receipt.currency = JPY เมื่อเห็น 円 / ¥ / ￥ (เงินเยนไม่มีทศนิยม)
+  - เอกสารมีทั้ง 10% และ 8% → ใส่ receipt.vat_breakdown แยกตามอัตรา
+  - ตัวเลขใช้ตามเอกสาร (ไม่แปลงเป็นบาท), ชื่อบัญชี / description ใช้ภาษาไทยตามผังบัญชีของร้าน
+`
+	}
+	return ""
+}
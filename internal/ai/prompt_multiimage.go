@@ -26,7 +26,8 @@ B. **เอกสารหลายหน้า** (ใบเสร็จแบ
 C. **เอกสารแยกกัน** (ใบเสร็จหลายใบ):
    - แต่ละรูปมีเลขเอกสารต่างกัน
    - วันที่และผู้ขายอาจเหมือนหรือต่างกัน
-   - การดำเนินการ: สร้าง journal entry แยกสำหรับแต่ละเอกสาร
+   - การดำเนินการ: สร้าง journal entry แยกสำหรับแต่ละเอกสารใน document_groups[]
+     (receipt / accounting_entry ระดับบน = เอกสารใบแรก)
 
 D. **เอกสารเดี่ยว** (รูปเดียว):
    - มีเพียงรูปเดียว
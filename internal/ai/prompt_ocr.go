@@ -7,6 +7,11 @@
 
 package ai
 
+import (
+	"fmt"
+	"strings"
+)
+
 // GetPureOCRPrompt สร้าง prompt สำหรับการอ่าน OCR แบบรวดเร็ว
 // AI จะอ่านข้อความทั้งหมดที่เห็นในรูปโดยไม่กรองหรือวิเคราะห์
 func GetPureOCRPrompt() string {
@@ -59,3 +64,103 @@ AI ระบบถัดไปจะวิเคราะห์เอง
 เริ่มอ่าน! 👀
 `
 }
+
+// GetCriticalFieldsPrompt สร้าง prompt สำหรับ verification pass (อ่านซ้ำเฉพาะฟิลด์สำคัญ)
+// ใช้เทียบกับผลจาก Phase 3 - ถ้าไม่ตรงกันระบบจะลด confidence และบังคับ review
+func GetCriticalFieldsPrompt() string {
+	return `
+คุณคือผู้ตรวจสอบเอกสารบัญชี อ่านรูปเอกสารแล้วตอบ **เฉพาะ 4 ฟิลด์** นี้ให้แม่นยำที่สุด
+
+1. **total** - ยอดรวมทั้งสิ้น (Grand Total / รวมทั้งสิ้น / ยอดชำระ) รวม VAT แล้ว
+2. **vat** - ภาษีมูลค่าเพิ่ม (VAT) ตามที่พิมพ์ในเอกสาร ถ้าเอกสารไม่มี VAT ตอบ 0
+3. **date** - วันที่ออกเอกสาร รูปแบบ YYYY-MM-DD ปี ค.ศ. (ถ้าเป็น พ.ศ. ให้ลบ 543)
+4. **vendor_tax_id** - เลขประจำตัวผู้เสียภาษี 13 หลักของ **ผู้ออกเอกสาร** (ไม่ใช่ของลูกค้า)
+
+⚠️ กฎ:
+• อ่านตัวเลขทีละหลัก ระวัง 0/8, 1/7, 3/8, 5/6
+• ถ้าเอกสารมีหลายรูป ให้ใช้ยอดของเอกสารหลัก (ใบกำกับภาษี/ใบเสร็จ) ไม่ใช่สลิปโอนเงิน
+• ถ้าอ่านไม่ได้ชัดเจน ตอบ null (total, vat) หรือ "" (date, vendor_tax_id) - **ห้ามเดา**
+`
+}
+
+// GetFieldLocationPrompt สร้าง prompt หาตำแหน่งของค่าที่อ่านได้บนรูป (include_preview)
+// values = ฟิลด์ → ค่าที่ระบบอ่านได้แล้ว (ให้ AI หาว่าพิมพ์อยู่ตรงไหน ไม่ต้องอ่านใหม่)
+func GetFieldLocationPrompt(values map[string]string, fields []string) string {
+	var list strings.Builder
+	for _, field := range fields {
+		if value, ok := values[field]; ok {
+			fmt.Fprintf(&list, "- %s: %s\n", field, value)
+		}
+	}
+	return fmt.Sprintf(`
+คุณคือระบบหาตำแหน่งข้อความบนรูปเอกสาร รูปแต่ละรูปมีป้าย "Image N" นำหน้า
+
+ค่าต่อไปนี้อ่านได้จากเอกสารแล้ว - หาว่าแต่ละค่า **พิมพ์อยู่ตรงไหน** ในรูป:
+%s
+⚠️ กฎ:
+• box_2d = [ymin, xmin, ymax, xmax] สเกล 0-1000 ของรูปนั้น ครอบเฉพาะข้อความของค่านั้น (ไม่รวมป้ายกำกับ เช่น "รวมทั้งสิ้น")
+• image_index = เลข N ของรูปที่พบ
+• ค่าบนรูปอาจพิมพ์คนละรูปแบบ เช่น วันที่ 2025-11-14 = 14/11/2568, ยอด 1290.00 = 1,290.00
+• ค่าที่พบหลายที่ ให้ตอบตำแหน่งที่ชัดที่สุดของเอกสารหลักที่เดียว
+• ค่าที่หาไม่พบในรูป **ไม่ต้องตอบ** - ห้ามเดาตำแหน่ง
+`, list.String())
+}
+
+// GetHandwrittenOCRPrompt สร้าง prompt สำหรับเอกสารเขียนด้วยลายมือ (บิลเงินสด / ใบส่งของ)
+// ใช้เมื่อ DetectHandwriting พบว่า OCR รอบแรกน่าจะเป็นลายมือ - เน้นตัวเลขและใช้บริบทช่วยอ่าน
+func GetHandwrittenOCRPrompt() string {
+	return `
+คุณคือ OCR Engine สำหรับ **เอกสารเขียนด้วยลายมือภาษาไทย** (บิลเงินสด, ใบส่งของ, ใบเสร็จจากสมุดใบเสร็จ)
+
+🎯 งาน: อ่านข้อความทั้งหมดในรูป (บนลงล่าง, ซ้ายไปขวา) ทั้งส่วนที่พิมพ์และส่วนที่เขียนด้วยมือ
+
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+✍️ วิธีอ่านลายมือ:
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+• หัวกระดาษมักเป็นตัวพิมพ์ (ชื่อร้าน, "บิลเงินสด", เล่มที่/เลขที่) - อ่านตามที่พิมพ์
+• ในตารางรายการ: จำนวน × ราคาต่อหน่วย = จำนวนเงิน → ใช้ตรวจตัวเลขที่อ่านไม่ชัด
+• ยอดรวมควรเท่ากับผลรวมของจำนวนเงินทุกแถว - ถ้าไม่ตรง ให้อ่านตัวเลขนั้นซ้ำ
+• ยอดเงินที่เขียนเป็นตัวอักษร (เช่น "หนึ่งพันสองร้อยบาทถ้วน") ใช้ยืนยันยอดรวมได้
+• ระวังตัวเลขลายมือ: 1 vs 7, 4 vs 9, 5 vs 6, 0 vs 6, 2 vs 7, 3 vs 8
+• ปี พ.ศ. มักเขียนย่อ 2 หลัก (เช่น 15/11/68) - อ่านตามที่เขียน ไม่ต้องแปลง
+• ขีดฆ่า/เขียนทับ → อ่านค่าที่เขียนทับ (ค่าใหม่)
+
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+✅ กฎการอ่าน:
+━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
+• คั่นแต่ละบรรทัดด้วย newline - 1 แถวในตาราง = 1 บรรทัด
+• ส่งกลับเป็น plain text (ไม่ใส่ markdown, ไม่อธิบาย)
+• ถ้าอ่านไม่ออกจริง ๆ ใส่ "???" - **ห้ามแต่งตัวเลขขึ้นเอง**
+
+📝 ตัวอย่าง Output:
+บิลเงินสด
+ร้าน [ชื่อร้าน]
+เล่มที่ 12 เลขที่ 0457
+วันที่ 15/11/68
+ปูนซีเมนต์ 10 ถุง 145 1,450
+ทรายหยาบ 2 คิว 450 900
+รวมเงิน 2,350
+(สองพันสามร้อยห้าสิบบาทถ้วน)
+ผู้รับเงิน ???
+
+เริ่มอ่าน! 👀
+`
+}
+
+// GetSchemaExtractionPrompt สร้าง prompt สำหรับ POST /api/v1/extract (ฟิลด์ที่ผู้เรียกกำหนดอยู่ใน ResponseSchema)
+// instructions = คำอธิบายเพิ่มเติมจากผู้เรียก (ว่างได้)
+func GetSchemaExtractionPrompt(instructions string) string {
+	prompt := `
+คุณคือระบบดึงข้อมูลจากเอกสาร อ่านเอกสารในรูปแล้วตอบเป็น JSON ตาม schema ที่กำหนดเท่านั้น
+
+⚠️ กฎ:
+• แต่ละฟิลด์ให้ดูจาก description ใน schema ว่าต้องการข้อมูลอะไร
+• ตอบตามที่เห็นในเอกสาร - ถ้าไม่พบหรืออ่านไม่ได้ชัดเจน ตอบ null (array ตอบ []) **ห้ามเดา**
+• ตัวเลข: ไม่ใส่เครื่องหมายจุลภาคหรือสกุลเงิน (เช่น 1234.50)
+• อ่านตัวเลขทีละหลัก ระวัง 0/8, 1/7, 3/8, 5/6
+`
+	if instructions != "" {
+		prompt += "\n📝 คำอธิบายเพิ่มเติมจากผู้ใช้:\n" + instructions + "\n"
+	}
+	return prompt
+}
@@ -20,7 +20,7 @@ func GetOutputFormatJSON() string {
   "source_images": [
     {
       "image_index": "[ลำดับรูป]",
-      "type": "[receipt/invoice/payment_slip/tax_invoice/unknown]",
+      "type": "[receipt/invoice/payment_slip/tax_invoice/credit_note/debit_note/unknown]",
       "receipt_number": "[เลขที่]",
       "amount": "[จำนวนเงิน]",
       "date": "[วันที่ในรูปแบบ YYYY-MM-DD - แปลง พ.ศ. เป็น ค.ศ. ด้วยการ -543]",
@@ -34,8 +34,13 @@ func GetOutputFormatJSON() string {
     "vendor_tax_id": "[เลขผู้เสียภาษี]",
     "total": "[ยอดรวม]",
     "vat": "[ยอด VAT ที่ระบุชัดเจนในเอกสาร - ถ้าไม่มีระบุให้ใส่ null - ห้ามคำนวณ]",
+    "vat_breakdown": "[เฉพาะเอกสารหลายอัตรา VAT / มีรายการยกเว้น VAT: [{\"rate\": 7, \"base\": มูลค่าก่อน VAT, \"vat\": VAT}] - อัตราเดียวใส่ null]",
+    "exempt_amount": "[มูลค่ารายการยกเว้น VAT ที่ระบุในเอกสาร - ไม่มีใส่ null]",
+    "withholding_tax": "[ภาษีหัก ณ ที่จ่ายที่ระบุในเอกสาร - ไม่มีใส่ null - ห้ามคำนวณ]",
+    "currency": "[รหัสสกุลเงิน ISO 4217 ของยอดในเอกสาร - เอกสารไทยใส่ THB]",
     "payment_method": "[วิธีชำระเงิน]",
-    "payment_proof_available": "[true/false]"
+    "payment_proof_available": "[true/false]",
+    "original_document_number": "[เฉพาะใบลดหนี้/ใบเพิ่มหนี้: เลขที่ใบกำกับภาษีเดิมที่อ้างอิง - ไม่มีใส่ null]"
   },
   "creditor": {
     "creditor_code": "[รหัส - ถ้าเราเป็นผู้ซื้อ / null ถ้าไม่เจอ]",
@@ -71,6 +76,13 @@ func GetOutputFormatJSON() string {
       "total_credit": "[Sum of all credit]"
     }
   },
+  "document_groups": [
+    {
+      "image_indices": "[ลำดับรูปของเอกสารใบนี้ เช่น [1] หรือ [2, 3] ถ้ามีสลิปแนบ]",
+      "receipt": "[โครงสร้างเดียวกับ receipt ด้านบน - ของเอกสารใบนี้]",
+      "accounting_entry": "[โครงสร้างเดียวกับ accounting_entry ด้านบน - ของเอกสารใบนี้]"
+    }
+  ],
   "validation": {
     "confidence": {
       "level": "[high/medium/low]",
@@ -108,6 +120,11 @@ func GetOutputFormatJSON() string {
   }
 }
 
+📑 document_groups - เฉพาะ relationship = "separate_receipts" เท่านั้น:
+- ใส่ 1 กลุ่มต่อเอกสาร 1 ใบ ตามลำดับรูป (กลุ่มแรก = receipt/accounting_entry ด้านบน)
+- แต่ละกลุ่มมี receipt และ accounting_entry ของตัวเอง (ห้ามรวมยอดข้ามเอกสาร)
+- relationship อื่น → "document_groups": []
+
 ⚠️ สำคัญมาก - ภาษาและความกระชับ:
 ━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
 1. **ใช้ภาษาไทยทั้งหมดใน ai_explanation** - ห้ามใช้อังกฤษ
@@ -139,7 +156,7 @@ func GetValidationRequirements() string {
    - "total": ยอดรวมที่ระบุชัดเจนในเอกสาร
    - "vat": ยอด VAT ที่ระบุชัดเจนในเอกสาร
      → ถ้าเอกสารไม่มีระบุ VAT แยก → ใส่ null
-     → ห้ามคำนวณ VAT จาก total × 7/107
+     → ห้ามคำนวณ VAT จาก total × อัตรา VAT/(100+อัตรา VAT)
    
    ❌ ห้ามทำ:
    - คำนวณ VAT จาก total (เช่น 1040 × 7/107 = 72.9)
@@ -150,6 +167,10 @@ func GetValidationRequirements() string {
    ✅ ถูก: {"total": 1040, "vat": null}
    ❌ ผิด: {"total": 1040, "vat": 72.9} ← คำนวณเอง ห้าม!
    
+   📌 เอกสารมีหลายฐานภาษี (เช่น "มูลค่าสินค้าที่เสียภาษี 1,000.00 / มูลค่าสินค้ายกเว้นภาษี 500.00 / VAT 70.00"):
+   ✅ {"total": 1570, "vat": 70, "vat_breakdown": [{"rate": 7, "base": 1000, "vat": 70}], "exempt_amount": 500}
+   → อ่านตัวเลขแต่ละอัตราจากเอกสารเท่านั้น ถ้าเอกสารไม่แยกให้ใส่ null (Backend จะแยกรายการบัญชีตามฐานภาษีให้เอง)
+
    💡 หมายเหตุ: การคำนวณ VAT ใน accounting_entry.entries[] เป็นคนละเรื่อง
       → ถ้ามี Template + สูตรคำนวณ → คำนวณได้ (แต่ receipt.vat ยังคงห้าม)
 
@@ -184,8 +205,8 @@ func GetValidationRequirements() string {
 
 **ข้อมูลทั่วไปเกี่ยวกับ VAT (สำหรับเข้าใจบริบท - ไม่ใช่คำสั่งให้คำนวณ):**
 - มูลค่ารวม = ยอดก่อน VAT + VAT (โครงสร้างทั่วไป)
-- มูลค่าก่อน VAT = มูลค่ารวม × (100 ÷ 107) (สำหรับอ้างอิง เท่านั้น)
-- VAT = มูลค่ารวม × (7 ÷ 107) (สำหรับอ้างอิง เท่านั้น)
+- มูลค่าก่อน VAT = มูลค่ารวม × 100 ÷ (100 + อัตรา VAT) (สำหรับอ้างอิง เท่านั้น - อัตราดู VAT RATE จาก Backend)
+- VAT = มูลค่ารวม × อัตรา VAT ÷ (100 + อัตรา VAT) (สำหรับอ้างอิง เท่านั้น)
 
 🚨 **CRITICAL: ห้ามใช้สูตรข้างบนคำนวณตัวเลข!**
    → ใช้เฉพาะตัวเลขที่อ่านได้จาก OCR เท่านั้น
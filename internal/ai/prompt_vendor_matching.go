@@ -11,8 +11,8 @@ func GetVendorMatchingGuidance() string {
 
 ระบบได้ทำ fuzzy matching ล่วงหน้าแล้ว ด้วย algorithm ที่แม่นยำ:
 - ถ้า method = "tax_id" → จับคู่ด้วย Tax ID (100% แม่นยำ)
-- ถ้า method = "exact" → ชื่อตรงกันพอดี (99%+)
-- ถ้า method = "fuzzy" → ชื่อใกล้เคียง (70-99%)
+- ถ้า method = "exact" → ชื่อตรงกันพอดี (หลังตัด บจก./จำกัด/สาขา, 100%)
+- ถ้า method = "fuzzy" → ชื่อใกล้เคียง / สลับลำดับคำ (70-100%)
 
 **วิธีใช้:**
 1. ถ้ามี suggested_vendor_code → ใช้ creditor_code = suggested_vendor_code
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
@@ -84,14 +85,25 @@ func formatTemplateOnly(matchedTemplate *bson.M, journalBooks []bson.M, creditor
 
 %s
 
-%s
-
 ⚠️ ข้อจำกัดสำคัญ:
 - ไม่มี Chart of Accounts แบบเต็ม (เพื่อประหยัด tokens)
 - ✅ มี Creditors/Debtors list - ให้จับคู่ชื่อผู้ขาย/ลูกค้า
 - ✅ มี Journal Books list - ให้เลือกสมุดที่เหมาะสม
 - ถ้าต้องการ Chart of Accounts เต็ม → ระบุ template_used = false (AI จะ retry พร้อม full master data)
-`, businessContext, string(templateJSON), GetTemplateAmountDistributionRules(), vendorMatchingGuidance, journalBooksSection, creditorsSection, debtorsSection)
+%s`, businessContext, string(templateJSON), GetTemplateAmountDistributionRules(), vendorMatchingGuidance, journalBooksSection, creditorsSection, debtorsSection, formatTemplateFormulaGuidance(*matchedTemplate))
+}
+
+// formatTemplateFormulaGuidance explains details[].formula to the AI ("" when the template has no formula)
+func formatTemplateFormulaGuidance(template bson.M) string {
+	if !processor.HasTemplateFormula(template) {
+		return ""
+	}
+	return `
+🧮 FORMULA (จาก Backend): รายการใน template ที่มี details[].formula ระบบจะคำนวณยอดเองหลัง AI ตอบ
+- ยังต้องใส่รายการนั้นใน entries ตามปกติ (ใส่ยอดที่ประมาณได้)
+- อ่าน receipt.total / vat / withholding_tax (ภาษีหัก ณ ที่จ่าย) ให้ตรงตามเอกสาร - สูตรใช้ค่าเหล่านี้
+- ไม่มีภาษีหัก ณ ที่จ่ายในเอกสาร → withholding_tax = null
+`
 }
 
 // journalBooksSection, creditorsSection, debtorsSection are defined above
@@ -237,6 +249,8 @@ func BuildMultiImageAccountingPrompt(allResultsJSON string, mode MasterDataMode,
 
 %s
 
+%s
+
 คืนค่าเฉพาะ JSON ที่ถูกต้องเท่านั้น (ไม่ต้องมี markdown หรือ code blocks).`,
 		shopContext,
 		templateGuidance,
@@ -0,0 +1,76 @@
+// provider_flags.go - OCR providers disabled at runtime (admin API, incident response)
+
+package ai
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+)
+
+// ErrProviderDisabled is returned by CreateOCRProvider for a provider disabled through the admin API
+var ErrProviderDisabled = errors.New("OCR provider is disabled")
+
+var (
+	disabledProviders   = map[string]string{} // provider → reason
+	disabledProvidersMu sync.RWMutex
+)
+
+// SetDisabledProviders replaces the set of disabled providers (provider → reason)
+func SetDisabledProviders(providers map[string]string) {
+	next := make(map[string]string, len(providers))
+	for name, reason := range providers {
+		next[name] = reason
+	}
+
+	disabledProvidersMu.Lock()
+	disabledProviders = next
+	disabledProvidersMu.Unlock()
+}
+
+// IsProviderDisabled reports whether a provider is disabled
+func IsProviderDisabled(name string) bool {
+	disabledProvidersMu.RLock()
+	defer disabledProvidersMu.RUnlock()
+	_, disabled := disabledProviders[name]
+	return disabled
+}
+
+// EnabledOCRProvider returns requested, or the other OCR provider when requested is disabled
+// (empty = no usable provider)
+func EnabledOCRProvider(requested string) string {
+	if !IsProviderDisabled(requested) {
+		return requested
+	}
+	var other string
+	switch requested {
+	case "gemini":
+		other = "mistral"
+	case "mistral":
+		other = "gemini"
+	}
+	if other == "" || IsProviderDisabled(other) || !ProviderConfigured(other) {
+		return ""
+	}
+	return other
+}
+
+// ProviderConfigured reports whether a provider has an API key (always true with MOCK_AI)
+func ProviderConfigured(name string) bool {
+	switch name {
+	case "gemini":
+		return configs.GEMINI_API_KEY != "" || configs.MOCK_AI
+	case "mistral":
+		return configs.MISTRAL_API_KEY != "" || configs.MOCK_AI
+	}
+	return false
+}
+
+// DisabledProviderReason returns the reason a provider was disabled (ok = false when enabled)
+func DisabledProviderReason(name string) (string, bool) {
+	disabledProvidersMu.RLock()
+	defer disabledProvidersMu.RUnlock()
+	reason, disabled := disabledProviders[name]
+	return reason, disabled
+}
@@ -0,0 +1,124 @@
+// safety.go - Detect Gemini safety / copyright blocks and pick an alternate OCR provider
+//
+// Gemini บล็อกคำขอเมื่อรูปมีเนื้อหาอ่อนไหว (เช่น บัตรประชาชน รูปบุคคล) หรือเนื้อหาที่อาจติดลิขสิทธิ์
+// (PromptFeedback.BlockReason หรือ FinishReason = SAFETY/RECITATION) → แยก error ออกมาให้ชัด พร้อมคำแนะนำ
+
+package ai
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Block kinds
+const (
+	BlockKindSafety    = "safety"    // Sensitive content (ID cards, people, ...)
+	BlockKindCopyright = "copyright" // Recitation of copyrighted material
+	BlockKindOther     = "other"     // Blocked for an unspecified reason
+)
+
+// SafetyBlockError is returned when the AI provider refused to process the content
+type SafetyBlockError struct {
+	Provider    string
+	Kind        string // BlockKindSafety, BlockKindCopyright, BlockKindOther
+	Reason      string // Raw block/finish reason from the provider
+	Suggestions []string
+}
+
+func (e *SafetyBlockError) Error() string {
+	return fmt.Sprintf("content blocked by %s (%s: %s)", e.Provider, e.Kind, e.Reason)
+}
+
+// newSafetyBlockError converts a Gemini BlockedError into a SafetyBlockError
+func newSafetyBlockError(blocked *genai.BlockedError) *SafetyBlockError {
+	blockErr := &SafetyBlockError{Provider: "gemini", Kind: BlockKindOther}
+
+	switch {
+	case blocked.PromptFeedback != nil:
+		blockErr.Reason = blocked.PromptFeedback.BlockReason.String()
+		if blocked.PromptFeedback.BlockReason == genai.BlockReasonSafety {
+			blockErr.Kind = BlockKindSafety
+		}
+	case blocked.Candidate != nil:
+		blockErr.Reason = blocked.Candidate.FinishReason.String()
+		switch blocked.Candidate.FinishReason {
+		case genai.FinishReasonSafety:
+			blockErr.Kind = BlockKindSafety
+		case genai.FinishReasonRecitation:
+			blockErr.Kind = BlockKindCopyright
+		}
+	}
+
+	blockErr.Suggestions = blockSuggestions(blockErr.Kind)
+	return blockErr
+}
+
+// blockSuggestions returns actionable advice for the user per block kind
+func blockSuggestions(kind string) []string {
+	switch kind {
+	case BlockKindSafety:
+		return []string{
+			"ครอปรูปให้เหลือเฉพาะใบเสร็จ/ใบกำกับภาษี",
+			"ตัดบัตรประชาชน บัตรเครดิต หรือรูปบุคคลที่ติดมาในภาพออก",
+			"ถ่ายเอกสารแยกทีละใบ ไม่วางเอกสารส่วนตัวอื่นไว้ข้างกัน",
+			"ลองส่งใหม่ด้วย model=mistral",
+		}
+	case BlockKindCopyright:
+		return []string{
+			"เอกสารมีเนื้อหาที่อาจมีลิขสิทธิ์ (หน้าหนังสือ นิตยสาร เนื้อเพลง) ครอปให้เหลือเฉพาะส่วนใบเสร็จ",
+			"หลีกเลี่ยงการถ่ายติดโฆษณาหรือสิ่งพิมพ์อื่นที่ไม่ใช่เอกสารบัญชี",
+			"ลองส่งใหม่ด้วย model=mistral",
+		}
+	default:
+		return []string{
+			"ครอปรูปให้เหลือเฉพาะเอกสารบัญชี แล้วลองใหม่อีกครั้ง",
+			"ลองส่งใหม่ด้วย model=mistral",
+		}
+	}
+}
+
+// AsSafetyBlock reports whether err (or any error it wraps) is a content block
+func AsSafetyBlock(err error) (*SafetyBlockError, bool) {
+	var blockErr *SafetyBlockError
+	if errors.As(err, &blockErr) {
+		return blockErr, true
+	}
+	var blocked *genai.BlockedError
+	if errors.As(err, &blocked) {
+		return newSafetyBlockError(blocked), true
+	}
+	return nil, false
+}
+
+// AlternateOCRProvider returns the provider to retry with after a content block
+// Returns nil when fallback is disabled (SAFETY_BLOCK_FALLBACK=false) or the alternate has no API key
+func AlternateOCRProvider(current string) OCRProvider {
+	if !configs.Get().SafetyBlockFallback {
+		return nil
+	}
+
+	var alternate string
+	switch current {
+	case "gemini":
+		if configs.MISTRAL_API_KEY == "" && !configs.MOCK_AI {
+			return nil
+		}
+		alternate = "mistral"
+	case "mistral":
+		if configs.GEMINI_API_KEY == "" && !configs.MOCK_AI {
+			return nil
+		}
+		alternate = "gemini"
+	default:
+		return nil
+	}
+
+	provider, err := CreateOCRProvider(alternate)
+	if err != nil {
+		return nil
+	}
+	return provider
+}
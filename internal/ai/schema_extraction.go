@@ -0,0 +1,267 @@
+// schema_extraction.go - Extract caller-defined fields from a document (POST /api/v1/extract)
+//
+// ผู้เรียกกำหนดฟิลด์เอง (ชื่อ, ชนิด, คำอธิบาย) → แปลงเป็น genai ResponseSchema แล้วให้ Gemini อ่านจากรูปโดยตรง
+// ทุกฟิลด์ nullable + required → คำตอบมีทุก key เสมอ (อ่านไม่ได้ = null) ผู้เรียก parse ได้แน่นอน
+
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ratelimit"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// Extraction field types
+const (
+	ExtractionTypeString  = "string"
+	ExtractionTypeNumber  = "number"
+	ExtractionTypeInteger = "integer"
+	ExtractionTypeBoolean = "boolean"
+	ExtractionTypeDate    = "date" // String in YYYY-MM-DD (ค.ศ.)
+	ExtractionTypeArray   = "array"
+	ExtractionTypeObject  = "object"
+)
+
+// Schema limits (keep the response schema within what Gemini accepts)
+const (
+	MaxExtractionFields = 100 // All fields including nested ones
+	MaxExtractionDepth  = 3   // object / array nesting
+)
+
+// ExtractionField is one field of a caller-defined extraction schema
+type ExtractionField struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"` // string, number, integer, boolean, date, array, object
+	Description string            `json:"description,omitempty"`
+	Enum        []string          `json:"enum,omitempty"`   // Allowed values (string only)
+	Items       *ExtractionField  `json:"items,omitempty"`  // Element of an array (name is ignored)
+	Fields      []ExtractionField `json:"fields,omitempty"` // Properties of an object
+}
+
+// ValidateExtractionFields checks names, types and limits of a schema
+func ValidateExtractionFields(fields []ExtractionField) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("fields cannot be empty")
+	}
+	count := 0
+	return validateExtractionLevel(fields, "", 1, &count)
+}
+
+// validateExtractionLevel validates the fields of one object level
+func validateExtractionLevel(fields []ExtractionField, path string, depth int, count *int) error {
+	seen := map[string]bool{}
+	for _, field := range fields {
+		name := strings.TrimSpace(field.Name)
+		if name == "" {
+			return fmt.Errorf("%sname is required for every field", path)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate field %s%s", path, name)
+		}
+		seen[name] = true
+		if err := validateExtractionField(field, path+name, depth, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateExtractionField validates one field and its children
+func validateExtractionField(field ExtractionField, path string, depth int, count *int) error {
+	*count++
+	if *count > MaxExtractionFields {
+		return fmt.Errorf("too many fields (max %d including nested fields)", MaxExtractionFields)
+	}
+	if depth > MaxExtractionDepth {
+		return fmt.Errorf("%s: nesting deeper than %d levels", path, MaxExtractionDepth)
+	}
+	if len(field.Enum) > 0 && field.Type != ExtractionTypeString {
+		return fmt.Errorf("%s: enum is only supported for type string", path)
+	}
+
+	switch field.Type {
+	case ExtractionTypeString, ExtractionTypeNumber, ExtractionTypeInteger, ExtractionTypeBoolean, ExtractionTypeDate:
+		return nil
+	case ExtractionTypeArray:
+		if field.Items == nil {
+			return fmt.Errorf("%s: items is required for type array", path)
+		}
+		return validateExtractionField(*field.Items, path+"[]", depth+1, count)
+	case ExtractionTypeObject:
+		if len(field.Fields) == 0 {
+			return fmt.Errorf("%s: fields is required for type object", path)
+		}
+		return validateExtractionLevel(field.Fields, path+".", depth+1, count)
+	default:
+		return fmt.Errorf("%s: invalid type %q (allowed: string, number, integer, boolean, date, array, object)", path, field.Type)
+	}
+}
+
+// createExtractionSchema converts the caller's fields into a Gemini response schema
+func createExtractionSchema(fields []ExtractionField) *genai.Schema {
+	schema := &genai.Schema{
+		Type:       genai.TypeObject,
+		Properties: map[string]*genai.Schema{},
+	}
+	for _, field := range fields {
+		name := strings.TrimSpace(field.Name)
+		schema.Properties[name] = extractionFieldSchema(field)
+		schema.Required = append(schema.Required, name)
+	}
+	return schema
+}
+
+// extractionFieldSchema converts one field (null = not found on the document)
+func extractionFieldSchema(field ExtractionField) *genai.Schema {
+	schema := &genai.Schema{Description: field.Description, Nullable: true}
+	switch field.Type {
+	case ExtractionTypeString:
+		schema.Type = genai.TypeString
+		if len(field.Enum) > 0 {
+			schema.Format = "enum"
+			schema.Enum = field.Enum
+		}
+	case ExtractionTypeDate:
+		schema.Type = genai.TypeString
+		schema.Description = strings.TrimSpace(field.Description + " (รูปแบบ YYYY-MM-DD ปี ค.ศ. - ถ้าเป็น พ.ศ. ให้ลบ 543)")
+	case ExtractionTypeNumber:
+		schema.Type = genai.TypeNumber
+	case ExtractionTypeInteger:
+		schema.Type = genai.TypeInteger
+	case ExtractionTypeBoolean:
+		schema.Type = genai.TypeBoolean
+	case ExtractionTypeArray:
+		schema.Type = genai.TypeArray
+		schema.Items = extractionFieldSchema(*field.Items)
+		schema.Nullable = false // Nothing found = empty array
+	case ExtractionTypeObject:
+		object := createExtractionSchema(field.Fields)
+		object.Description = field.Description
+		object.Nullable = true
+		return object
+	}
+	return schema
+}
+
+// ExtractWithSchema reads the caller-defined fields from one document with Gemini
+// Returns the values keyed by field name (every field present, null when not found)
+func ExtractWithSchema(ctx context.Context, imagePath string, fields []ExtractionField, instructions string, reqCtx *common.RequestContext) (map[string]interface{}, *common.TokenUsage, error) {
+	if configs.MOCK_AI {
+		reqCtx.LogInfo("🧪 MOCK_AI: returning placeholder values for %d field(s)", len(fields))
+		return mockExtractionValues(fields), &common.TokenUsage{}, nil
+	}
+	if IsProviderDisabled("gemini") {
+		return nil, nil, fmt.Errorf("%w: gemini", ErrProviderDisabled)
+	}
+
+	// Step 1: Load the document (same preprocessing as OCR)
+	imageData, mimeType, err := preprocessForGemini(imagePath, reqCtx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load image for extraction: %w", err)
+	}
+
+	// Step 2: Initialize the Gemini client with the caller's schema
+	client, err := genai.NewClient(ctx,
+		option.WithAPIKey(configs.GEMINI_API_KEY),
+		option.WithEndpoint("https://generativelanguage.googleapis.com"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Gemini client: %w", err)
+	}
+	defer client.Close()
+
+	modelName := reqCtx.Settings.OCRModel
+	model := client.GenerativeModel(modelName)
+	model.SetTemperature(0)
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = createExtractionSchema(fields)
+
+	prompt := GetSchemaExtractionPrompt(strings.TrimSpace(instructions))
+
+	// Step 3: Check cost budget before calling the API
+	projected := common.CalculateOCRTokenCost(
+		common.EstimateTextTokens(prompt)+common.EstimatedImageTokens,
+		common.EstimatedTemplateOutputTokens+50*len(fields),
+	)
+	if err := reqCtx.ReserveCost(common.CostPhaseExtraction, projected); err != nil {
+		return nil, nil, err
+	}
+
+	// Step 4: Call Gemini (with retry logic)
+	callStart := time.Now()
+	resp, err := callGeminiWithRetry(ctx, "gemini.extraction", model, reqCtx, ratelimit.DefaultRetryPolicy,
+		genai.Text(prompt),
+		genai.Blob{MIMEType: mimeType, Data: imageData},
+	)
+	imageInput := fmt.Sprintf("%s, %d bytes (%s)", mimeType, len(imageData), filepath.Base(imagePath))
+	reqCtx.RecordTrace(common.NewGeminiTrace(common.CostPhaseExtraction, modelName, model, prompt, imageInput, resp, err, callStart))
+	if err != nil {
+		return nil, nil, fmt.Errorf("extraction call failed: %w", err)
+	}
+
+	var tokenUsage *common.TokenUsage
+	if resp.UsageMetadata != nil {
+		tokens := common.CalculateOCRTokenCost(
+			int(resp.UsageMetadata.PromptTokenCount),
+			int(resp.UsageMetadata.CandidatesTokenCount),
+		)
+		tokenUsage = &tokens
+		reqCtx.RecordCost(common.CostPhaseExtraction, tokenUsage)
+	}
+
+	// Step 5: Parse the JSON response
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return nil, tokenUsage, fmt.Errorf("no response from Gemini API")
+	}
+	var jsonResponse string
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if text, ok := part.(genai.Text); ok {
+			jsonResponse += string(text)
+		}
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(fixJSONEscaping(jsonResponse)), &values); err != nil {
+		return nil, tokenUsage, fmt.Errorf("failed to parse extraction response: %w", err)
+	}
+	return values, tokenUsage, nil
+}
+
+// mockExtractionValues returns a typed placeholder per field for MOCK_AI=true
+func mockExtractionValues(fields []ExtractionField) map[string]interface{} {
+	values := map[string]interface{}{}
+	for _, field := range fields {
+		values[strings.TrimSpace(field.Name)] = mockExtractionValue(field)
+	}
+	return values
+}
+
+// mockExtractionValue returns the placeholder of one field
+func mockExtractionValue(field ExtractionField) interface{} {
+	switch field.Type {
+	case ExtractionTypeString:
+		if len(field.Enum) > 0 {
+			return field.Enum[0]
+		}
+		return "mock"
+	case ExtractionTypeDate:
+		return "2024-01-31"
+	case ExtractionTypeNumber, ExtractionTypeInteger:
+		return 0
+	case ExtractionTypeBoolean:
+		return false
+	case ExtractionTypeArray:
+		return []interface{}{mockExtractionValue(*field.Items)}
+	case ExtractionTypeObject:
+		return mockExtractionValues(field.Fields)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+// account_prefilter.go - Chart of accounts / creditors sent to Phase 3 (see processor/account_prefilter.go, processor/creditor_prefilter.go)
+
+package api
+
+import (
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// promptAccounts narrows the accounts of a full-mode Phase 3 prompt to the ones relevant to the OCR text
+// Template-only mode does not send the chart of accounts → returned unchanged (nil result)
+// The full list must still be used for the checks after Phase 3 (VAT, fixed assets, unknown account codes)
+func promptAccounts(ocrText string, accounts []bson.M, mode ai.MasterDataMode, vendorMatch *processor.VendorMatchResult, reqCtx *common.RequestContext) ([]bson.M, *processor.AccountPrefilterResult) {
+	if mode == ai.TemplateOnlyMode {
+		return accounts, nil
+	}
+
+	var keepCodes []string
+	if vendorMatch != nil && vendorMatch.LearnedAccountCode != "" {
+		keepCodes = append(keepCodes, vendorMatch.LearnedAccountCode)
+	}
+	cfg := configs.Get()
+	result := processor.FilterRelevantAccounts(ocrText, accounts, cfg.AccountPrefilterTopN, cfg.AccountPrefilterMinAccounts, keepCodes...)
+	if result.Applied {
+		reqCtx.LogInfo("🎯 Account pre-filter: %d/%d accounts sent to Phase 3 (%d relevant + %d essential)",
+			result.SentAccounts, result.TotalAccounts, result.Relevant, result.Essential)
+	}
+	return result.Accounts, &result
+}
+
+// promptCreditors narrows the creditors of a Phase 3 prompt to the candidates whose name appears in the OCR text
+// The creditor found by vendor pre-matching is always kept. Both modes send creditors → applied in both
+func promptCreditors(ocrText string, creditors []bson.M, vendorMatch *processor.VendorMatchResult, reqCtx *common.RequestContext) ([]bson.M, *processor.CreditorPrefilterResult) {
+	var keepCodes []string
+	if vendorMatch != nil && vendorMatch.Found && vendorMatch.Party != processor.PartyDebtor {
+		keepCodes = append(keepCodes, vendorMatch.Code)
+	}
+	cfg := configs.Get()
+	result := processor.FilterCandidateCreditors(ocrText, creditors, cfg.CreditorPrefilterTopK, cfg.CreditorPrefilterMinScore, keepCodes...)
+	if result.Applied {
+		reqCtx.LogInfo("🎯 Creditor pre-filter: %d/%d creditors sent to Phase 3 (%d candidates, top score %.0f)",
+			result.SentCreditors, result.TotalCreditors, result.Candidates, result.TopScore)
+	}
+	return result.Creditors, &result
+}
@@ -0,0 +1,71 @@
+// account_suggest.go - Chart-of-accounts suggestions for free-text descriptions
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/gin-gonic/gin"
+)
+
+// Suggestion limits for POST /api/v1/shops/:shopid/accounts/suggest
+const (
+	defaultAccountSuggestLimit = 5
+	maxAccountSuggestLimit     = 20
+)
+
+// AccountSuggestRequest is a free-text expense/revenue description (e.g. "เติมน้ำมันรถส่งของ")
+type AccountSuggestRequest struct {
+	Description string `json:"description" binding:"required"`
+	Limit       int    `json:"limit,omitempty"`
+}
+
+// AccountSuggestResponse lists posting accounts ranked by score (highest first)
+type AccountSuggestResponse struct {
+	ShopID      string                        `json:"shopid"`
+	Description string                        `json:"description"`
+	Suggestions []processor.AccountSuggestion `json:"suggestions"`
+}
+
+// SuggestAccountsHandler handles POST /api/v1/shops/:shopid/accounts/suggest
+func SuggestAccountsHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	var req AccountSuggestRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Description) == "" {
+		details := "description is required"
+		if err != nil {
+			details = err.Error()
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": details,
+		})
+		return
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultAccountSuggestLimit
+	}
+	if limit > maxAccountSuggestLimit {
+		limit = maxAccountSuggestLimit
+	}
+
+	masterCache, err := dataStore.GetOrLoadMasterData(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to load master data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, AccountSuggestResponse{
+		ShopID:      shopID,
+		Description: req.Description,
+		Suggestions: processor.SuggestAccounts(req.Description, masterCache.Accounts, limit),
+	})
+}
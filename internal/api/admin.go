@@ -0,0 +1,294 @@
+// admin.go - Admin API for incident response (suspend shops, disable OCR providers, view runtime flags)
+//
+// ต้องส่ง Authorization: Bearer <ADMIN_API_KEY> (ไม่ตั้ง ADMIN_API_KEY = ปิด admin API)
+// flag เก็บใน MongoDB (runtimeFlags) และโหลดซ้ำทุก RUNTIME_FLAGS_REFRESH_SEC → ทุก instance เห็นค่าเดียวกันโดยไม่ต้อง redeploy
+
+package api
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ratelimit"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// RuntimeFlagRequest is the body of suspend / disable calls
+type RuntimeFlagRequest struct {
+	Reason string `json:"reason"`
+}
+
+// RuntimeFlagsResponse shows the current runtime state of this instance
+type RuntimeFlagsResponse struct {
+	SuspendedShops    []storage.RuntimeFlag `json:"suspended_shops"`
+	DisabledProviders []storage.RuntimeFlag `json:"disabled_providers"`
+	Draining          bool                  `json:"draining"`
+	InFlight          int                   `json:"in_flight"`
+	RefreshedAt       string                `json:"refreshed_at,omitempty"` // Last successful load from MongoDB
+
+	AIRetries map[string]ratelimit.RetryStats `json:"ai_retries"` // Retry stats per AI call site since this instance started
+}
+
+var (
+	suspendedShops    = map[string]storage.RuntimeFlag{}
+	disabledProviders = map[string]storage.RuntimeFlag{}
+	flagsRefreshedAt  time.Time
+	runtimeFlagsMu    sync.RWMutex
+)
+
+// AdminAuthMiddleware requires Authorization: Bearer <ADMIN_API_KEY>
+func AdminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if configs.ADMIN_API_KEY == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":   "admin_api_disabled",
+				"message": "ยังไม่ได้ตั้งค่า ADMIN_API_KEY",
+			})
+			return
+		}
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(configs.ADMIN_API_KEY)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error":   "unauthorized",
+				"message": "admin API key ไม่ถูกต้อง",
+			})
+			return
+		}
+		c.Set(auditActorContextKey, storage.AuditActorAdmin)
+		c.Next()
+	}
+}
+
+// ShopSuspensionMiddleware rejects requests of suspended shops on routes with a :shopid parameter
+// (analyze-receipt / test-template / reanalyze / classify-document / ocr / extract check the shop in the handler - shopid is in the body)
+func ShopSuspensionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.FullPath(), "/api/v1/admin/") {
+			c.Next()
+			return
+		}
+		if shopID := c.Param("shopid"); shopID != "" && rejectSuspendedShop(c, shopID) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// rejectSuspendedShop writes 403 when the shop is suspended (true = response written)
+func rejectSuspendedShop(c *gin.Context, shopID string) bool {
+	c.Set(auditShopIDContextKey, shopID) // Every shop-scoped request passes here - the audit entry records the shop
+	runtimeFlagsMu.RLock()
+	flag, suspended := suspendedShops[shopID]
+	runtimeFlagsMu.RUnlock()
+	if !suspended {
+		return false
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":   "shop_suspended",
+		"message": "ร้านนี้ถูกระงับการใช้งานชั่วคราว กรุณาติดต่อผู้ดูแลระบบ",
+		"shopid":  shopID,
+		"reason":  flag.Reason,
+	})
+	return true
+}
+
+// resolveOCRProvider returns the provider to use for a request (the other one when requested is disabled)
+// Writes 503 when no enabled provider is left (ok = false)
+func resolveOCRProvider(c *gin.Context, requested string) (string, bool) {
+	provider := ai.EnabledOCRProvider(requested)
+	if provider == "" {
+		c.Header("Retry-After", "60")
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "provider_disabled",
+			"message": "OCR provider " + requested + " ถูกปิดใช้งานชั่วคราว และไม่มี provider อื่นให้ใช้แทน",
+			"model":   requested,
+		})
+		return "", false
+	}
+	return provider, true
+}
+
+// RefreshRuntimeFlags loads the flags from MongoDB and applies them to this instance
+func RefreshRuntimeFlags() error {
+	flags, err := storage.GetRuntimeFlags()
+	if err != nil {
+		return err
+	}
+
+	shops := map[string]storage.RuntimeFlag{}
+	providers := map[string]storage.RuntimeFlag{}
+	providerReasons := map[string]string{}
+	for _, flag := range flags {
+		switch flag.Kind {
+		case storage.RuntimeFlagShopSuspended:
+			shops[flag.Key] = flag
+		case storage.RuntimeFlagProviderDisabled:
+			providers[flag.Key] = flag
+			providerReasons[flag.Key] = flag.Reason
+		}
+	}
+
+	runtimeFlagsMu.Lock()
+	suspendedShops = shops
+	disabledProviders = providers
+	flagsRefreshedAt = time.Now()
+	runtimeFlagsMu.Unlock()
+	ai.SetDisabledProviders(providerReasons)
+	return nil
+}
+
+// StartRuntimeFlagSync loads the flags now and re-reads them every RUNTIME_FLAGS_REFRESH_SEC
+// Flags changed on another instance apply here within one interval
+func StartRuntimeFlagSync() {
+	if err := RefreshRuntimeFlags(); err != nil {
+		log.Printf("⚠️  Failed to load runtime flags: %v", err)
+	}
+	if configs.RUNTIME_FLAGS_REFRESH_SEC <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(configs.RUNTIME_FLAGS_REFRESH_SEC) * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := RefreshRuntimeFlags(); err != nil {
+				log.Printf("⚠️  Failed to refresh runtime flags (keeping current flags): %v", err)
+			}
+		}
+	}()
+}
+
+// RuntimeFlagsHandler handles GET /api/v1/admin/flags
+func RuntimeFlagsHandler(c *gin.Context) {
+	runtimeFlagsMu.RLock()
+	response := RuntimeFlagsResponse{
+		SuspendedShops:    []storage.RuntimeFlag{},
+		DisabledProviders: []storage.RuntimeFlag{},
+		Draining:          IsDraining(),
+		InFlight:          InFlightCount(),
+		AIRetries:         ratelimit.RetryStatsSnapshot(),
+	}
+	for _, flag := range suspendedShops {
+		response.SuspendedShops = append(response.SuspendedShops, flag)
+	}
+	for _, flag := range disabledProviders {
+		response.DisabledProviders = append(response.DisabledProviders, flag)
+	}
+	if !flagsRefreshedAt.IsZero() {
+		response.RefreshedAt = flagsRefreshedAt.Format(time.RFC3339)
+	}
+	runtimeFlagsMu.RUnlock()
+
+	sort.Slice(response.SuspendedShops, func(i, j int) bool { return response.SuspendedShops[i].Key < response.SuspendedShops[j].Key })
+	sort.Slice(response.DisabledProviders, func(i, j int) bool { return response.DisabledProviders[i].Key < response.DisabledProviders[j].Key })
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SuspendShopHandler handles POST /api/v1/admin/shops/:shopid/suspension
+func SuspendShopHandler(c *gin.Context) {
+	setRuntimeFlag(c, storage.RuntimeFlagShopSuspended, c.Param("shopid"))
+}
+
+// ResumeShopHandler handles DELETE /api/v1/admin/shops/:shopid/suspension
+func ResumeShopHandler(c *gin.Context) {
+	clearRuntimeFlag(c, storage.RuntimeFlagShopSuspended, c.Param("shopid"))
+}
+
+// DisableProviderHandler handles POST /api/v1/admin/providers/:provider/disable
+func DisableProviderHandler(c *gin.Context) {
+	provider := c.Param("provider")
+	if provider != "gemini" && provider != "mistral" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":          "invalid provider",
+			"allowed_values": []string{"gemini", "mistral"},
+		})
+		return
+	}
+	setRuntimeFlag(c, storage.RuntimeFlagProviderDisabled, provider)
+}
+
+// EnableProviderHandler handles DELETE /api/v1/admin/providers/:provider/disable
+func EnableProviderHandler(c *gin.Context) {
+	clearRuntimeFlag(c, storage.RuntimeFlagProviderDisabled, c.Param("provider"))
+}
+
+// RefreshCacheHandler handles POST /api/v1/admin/cache/refresh?shopid=
+// Drops the master data cache of one shop (empty shopid = every shop) - reloaded on the next request
+// The cache is per instance: only the instance that receives the call is refreshed
+func RefreshCacheHandler(c *gin.Context) {
+	shopID := c.Query("shopid")
+	if shopID == "" {
+		dataStore.ClearAllCache()
+		log.Printf("🔄 Master data cache cleared (all shops)")
+		c.JSON(http.StatusOK, gin.H{"refreshed": "all"})
+		return
+	}
+	dataStore.InvalidateCache(shopID)
+	log.Printf("🔄 Master data cache cleared (shop %s)", shopID)
+	c.JSON(http.StatusOK, gin.H{"refreshed": shopID})
+}
+
+// setRuntimeFlag stores a flag and applies it to this instance immediately
+func setRuntimeFlag(c *gin.Context, kind, key string) {
+	var req RuntimeFlagRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	flag, err := storage.SetRuntimeFlag(storage.RuntimeFlag{Kind: kind, Key: key, Reason: strings.TrimSpace(req.Reason)})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save runtime flag",
+			"details": err.Error(),
+		})
+		return
+	}
+	if err := RefreshRuntimeFlags(); err != nil {
+		log.Printf("⚠️  Failed to refresh runtime flags: %v", err)
+	}
+
+	log.Printf("🚨 Admin: %s set for %s (reason: %q)", kind, key, flag.Reason)
+	c.JSON(http.StatusOK, flag)
+}
+
+// clearRuntimeFlag removes a flag and applies the change to this instance immediately
+func clearRuntimeFlag(c *gin.Context, kind, key string) {
+	deleted, err := storage.DeleteRuntimeFlag(kind, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to delete runtime flag",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "runtime flag not found",
+			"message": "ไม่พบ " + kind + " สำหรับ " + key,
+		})
+		return
+	}
+	if err := RefreshRuntimeFlags(); err != nil {
+		log.Printf("⚠️  Failed to refresh runtime flags: %v", err)
+	}
+
+	log.Printf("✅ Admin: %s cleared for %s", kind, key)
+	c.JSON(http.StatusOK, gin.H{"kind": kind, "key": key, "deleted": true})
+}
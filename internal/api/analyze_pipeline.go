@@ -0,0 +1,424 @@
+// analyze_pipeline.go - Whole analyze-receipt run (Download → OCR → documents → Template match → Analyze → Validate → Compose)
+//
+// AnalyzeReceiptHandler ตรวจ request / สิทธิ์ / master data / timeout แล้วเรียก AnalyzeService.Run ครั้งเดียว
+// Run ไม่เขียน response เอง: error ที่คืนบอกขั้นที่หยุด (DownloadError, OCRProviderError, ImageError, ComplexityError,
+// AccountingError, errSelfInvoiceRejected) → handler แปลงเป็น HTTP response
+// ctx หมดเวลา / client ตัดการเชื่อมต่อ → คืน ctx.Err() (handler ตอบ timeout หรือหยุดโดยไม่ตอบ)
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// AnalyzeRun is a validated analyze-receipt request with the shop data loaded by LoadShopData
+type AnalyzeRun struct {
+	Request        ExtractRequest // IncludeRawText / IncludePreview already merged with the query parameters
+	RequestedModel string         // Model asked for by the client (before a disabled provider was replaced)
+	MasterCache    *storage.MasterDataCache
+	Templates      []bson.M
+	Limit          time.Duration // Processing limit of the request (complexity estimate after OCR)
+	Debug          bool
+	// OnComplexity receives the estimate after OCR (the timeout response reports the latest one) - optional
+	OnComplexity func(processor.ComplexityEstimate)
+}
+
+// AnalyzeResult is a finished analysis and its response
+type AnalyzeResult struct {
+	Input      *AnalysisInput
+	Analysis   *Analysis
+	Validation *Validation
+	Response   gin.H
+	Analytics  storage.DocumentAnalyticsRecord // Recorded by the handler (template coverage reports)
+}
+
+// OCRProviderError means the OCR provider of the model could not be created
+type OCRProviderError struct {
+	Model string
+	Err   error
+}
+
+func (e *OCRProviderError) Error() string {
+	return fmt.Sprintf("ocr provider %s: %v", e.Model, e.Err)
+}
+
+func (e *OCRProviderError) Unwrap() error {
+	return e.Err
+}
+
+// ImageError is an image whose OCR was refused (content filter, payload guard) - the entry would miss its text
+type ImageError struct {
+	Index int
+	Err   error
+}
+
+func (e *ImageError) Error() string {
+	return fmt.Sprintf("image %d: %v", e.Index, e.Err)
+}
+
+func (e *ImageError) Unwrap() error {
+	return e.Err
+}
+
+// ComplexityError means the estimate after OCR does not fit the processing limit (ENABLE_COMPLEXITY_CHECK)
+type ComplexityError struct {
+	Estimate processor.ComplexityEstimate
+}
+
+func (e *ComplexityError) Error() string {
+	return "too complex: " + e.Estimate.Message
+}
+
+// Run downloads and reads the images, groups them into documents, matches the template and runs
+// Analyze → Validate → Compose. Downloaded files are removed when Run returns
+// On errSelfInvoiceRejected the result holds the validation (SelfInvoice)
+func (s *AnalyzeService) Run(ctx context.Context, run *AnalyzeRun, reqCtx *common.RequestContext) (*AnalyzeResult, error) {
+	req := run.Request
+
+	// Step 2: Download ALL images from Azure Blob Storage
+	reqCtx.StartStep("download_images")
+	reqCtx.LogInfo("Downloading %d image(s)", len(req.ImageReferences))
+	downloadedImages, err := s.DownloadImages(ctx, req.ImageReferences, reqCtx)
+	if err != nil {
+		reqCtx.EndStep("failed", nil, err)
+		return nil, err
+	}
+	reqCtx.LogInfo("✓ Downloaded %d image(s) successfully", len(downloadedImages))
+	reqCtx.EndStep("success", nil, nil)
+
+	// Auto-cleanup all downloaded files
+	// (args are evaluated now - Step 3.3 narrows the images to the first document)
+	defer RemoveDownloadedImages(downloadedImages, reqCtx)
+
+	// Step 3: Process PURE OCR for ALL images (raw text only - saves ~25,000 tokens per image)
+	reqCtx.StartStep("pure_ocr_extraction_all")
+	reqCtx.LogInfo("Pure OCR extraction (raw text only) for %d image(s)", len(downloadedImages))
+	if err := ctx.Err(); err != nil {
+		reqCtx.EndStep("cancelled", nil, fmt.Errorf("timeout before pure OCR"))
+		return nil, err
+	}
+	ocrProvider, pureOCRResults, totalPureOCRTokens, err := s.RunPureOCR(ctx, req.Model, downloadedImages, reqCtx)
+	if err != nil {
+		reqCtx.LogError("Failed to create OCR provider: %v", err)
+		reqCtx.EndStep("failed", nil, err)
+		return nil, &OCRProviderError{Model: req.Model, Err: err}
+	}
+	if err := ctx.Err(); err != nil {
+		reqCtx.EndStep("cancelled", nil, err)
+		return nil, err
+	}
+	reqCtx.LogInfo("✓ Pure OCR completed for %d image(s) - Token savings: ~82%% vs old method", len(pureOCRResults))
+
+	// Step 3.1-3.3: Handwriting, stitching, QR codes, stored OCR text, document groups
+	in := &AnalysisInput{
+		Request:        req,
+		RequestedModel: run.RequestedModel,
+		MasterCache:    run.MasterCache,
+		Templates:      run.Templates,
+		OCRProvider:    ocrProvider.GetProviderName(),
+		Images:         downloadedImages,
+		OCRResults:     pureOCRResults,
+		Debug:          run.Debug,
+	}
+	s.prepareDocuments(ctx, in, &totalPureOCRTokens, reqCtx)
+	in.OCRTokens = totalPureOCRTokens
+	reqCtx.EndStep("success", &totalPureOCRTokens, nil)
+
+	// Stop here if OCR of any image was blocked by the cost budget
+	if err := reqCtx.BudgetError(); err != nil {
+		return nil, err
+	}
+	// Stop here if any image was blocked by AI content filters or refused by the payload guard
+	// (its text would be missing from the entry)
+	for _, ocrResult := range in.OCRResults {
+		if _, blocked := ai.AsSafetyBlock(ocrResult.Error); blocked {
+			return nil, &ImageError{Index: ocrResult.ImageIndex, Err: ocrResult.Error}
+		}
+	}
+	for _, ocrResult := range in.OCRResults {
+		var sizeErr *processor.ImageTooLargeError
+		if errors.As(ocrResult.Error, &sizeErr) {
+			return nil, &ImageError{Index: ocrResult.ImageIndex, Err: ocrResult.Error}
+		}
+	}
+
+	// Step 3.4: Complexity check from the OCR text length (all documents of the request) - stop before the accounting would time out
+	allOCRResults := append([]PureOCRImageResult{}, in.OCRResults...)
+	for _, secondary := range in.secondaryClusters {
+		allOCRResults = append(allOCRResults, secondary.ocrResults...)
+	}
+	in.Complexity = postOCRComplexity(allOCRResults, reqCtx, run.Limit)
+	if run.OnComplexity != nil {
+		run.OnComplexity(in.Complexity)
+	}
+	if !complexityAllowed(reqCtx, in.Complexity) {
+		return nil, &ComplexityError{Estimate: in.Complexity}
+	}
+
+	// Step 3.5: Template Matching Analysis
+	// If match found (≥TEMPLATE_CONFIDENCE_THRESHOLD) → Use template-only mode (saves another ~20,000 tokens in Phase 3!)
+	reqCtx.StartStep("template_matching_analysis")
+	reqCtx.LogInfo("Analyzing text to find matching accounting templates...")
+
+	// Handwritten OCR is noisier → template match scores are lower for the same document
+	templateThreshold := reqCtx.Settings.TemplateConfidenceThreshold
+	if in.Handwritten {
+		templateThreshold = reqCtx.Settings.HandwrittenTemplateConfidenceThreshold
+	}
+	detectDocumentLanguage(combinedOCRText(in.OCRResults), reqCtx)
+	in.TemplateMatch = s.MatchTemplate(ctx, in.OCRResults, in.Templates, templateThreshold, reqCtx)
+	if err := ctx.Err(); err != nil {
+		reqCtx.EndStep("cancelled", nil, err)
+		return nil, err
+	}
+	if match := in.TemplateMatch.Result; in.TemplateMatch.Mode == ai.TemplateOnlyMode {
+		reqCtx.LogInfo("✅ Template matched: %s (ID: %v, Confidence: %.1f%%) - Using template-only mode",
+			match.Description, match.TemplateID, match.Confidence)
+	} else {
+		reqCtx.LogInfo("❌ No template match (Confidence: %.1f%% < %.0f%%) - Using full master data mode",
+			match.Confidence, templateThreshold)
+	}
+	reqCtx.EndStep("success", nil, nil)
+
+	// Template matching swallows AI errors - check if it was blocked by the cost budget
+	if err := reqCtx.BudgetError(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Step 5-6.9: Phase 3 accounting analysis of every document + deterministic corrections
+	analysis, err := s.Analyze(ctx, in, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 7-8.5: Balance, parties, VAT and confidence of the entry + every reason it needs review
+	validation, err := s.Validate(ctx, in, analysis, reqCtx)
+	if err != nil {
+		return &AnalyzeResult{Input: in, Analysis: analysis, Validation: validation}, err
+	}
+
+	// Step 8.7: Preview for the frontend (include_preview) - thumbnails + where total/date/vendor were read
+	var thumbnails []ImageThumbnail
+	var fieldLocations *FieldLocationsSection
+	if req.IncludePreview {
+		reqCtx.StartStep("preview")
+		thumbnails = buildThumbnails(reqCtx, in.Images)
+		receiptSection, _ := analysis.Response["receipt"].(map[string]interface{})
+		section, locateTokens := locateFields(ctx, reqCtx, in.OCRProvider, in.Images, receiptSection)
+		fieldLocations = &section
+		reqCtx.EndStep("success", locateTokens, nil)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Step 10: Build multi-image response with document analysis
+	response, analyticsRecord := s.Compose(in, analysis, validation, reqCtx)
+	if fieldLocations != nil {
+		response["thumbnails"] = thumbnails
+		response["field_locations"] = fieldLocations
+	}
+	return &AnalyzeResult{
+		Input:      in,
+		Analysis:   analysis,
+		Validation: validation,
+		Response:   response,
+		Analytics:  analyticsRecord,
+	}, nil
+}
+
+// prepareDocuments runs the OCR follow-ups of Steps 3.1-3.3 on in.Images / in.OCRResults:
+// handwriting re-OCR, stitching of a long receipt, QR codes, stored OCR text, raw texts and document groups
+// Handwriting re-OCR tokens are added to ocrTokens
+func (s *AnalyzeService) prepareDocuments(ctx context.Context, in *AnalysisInput, ocrTokens *common.TokenUsage, reqCtx *common.RequestContext) {
+	req := in.Request
+	downloadedImages, pureOCRResults := in.Images, in.OCRResults
+
+	// Step 3.1: Handwritten receipt detection (บิลเงินสด)
+	// Handwritten images are re-read with the handwriting preprocessing + prompt
+	in.HandwritingSignals = map[int]processor.HandwritingSignal{}
+	if configs.Get().EnableHandwritingMode || req.Handwritten {
+		for i, ocrResult := range pureOCRResults {
+			if ocrResult.Result == nil {
+				continue
+			}
+			signal := processor.DetectHandwriting(ocrResult.Result.RawDocumentText)
+			if req.Handwritten {
+				signal.Detected = true
+				signal.Reasons = append(signal.Reasons, "request hint")
+			}
+			if !signal.Detected {
+				continue
+			}
+			in.Handwritten = true
+			in.HandwritingSignals[ocrResult.ImageIndex] = signal
+			reqCtx.LogInfo("✍️  Image %d looks handwritten (score %.2f: %s) → re-OCR with handwriting profile",
+				ocrResult.ImageIndex, signal.Score, strings.Join(signal.Reasons, ", "))
+
+			var img ImageData
+			for _, d := range downloadedImages {
+				if d.Index == ocrResult.ImageIndex {
+					img = d
+					break
+				}
+			}
+			hwCtx, cancelHW := phaseContext(ctx, failurePhaseOCR)
+			hwResult, hwTokens, hwErr := s.OCR.Handwritten(hwCtx, img.Filename, reqCtx)
+			if phaseTimedOut(hwCtx, ctx) {
+				reqCtx.RecordPhaseTimeout(failurePhaseOCR, phaseTimeout(failurePhaseOCR), fmt.Sprintf("image %d (handwriting)", ocrResult.ImageIndex))
+			}
+			cancelHW()
+			addTokenUsage(ocrTokens, hwTokens)
+			if hwErr != nil || hwResult == nil || strings.TrimSpace(hwResult.RawDocumentText) == "" {
+				// Keep the standard OCR text - still flagged handwritten (forces review)
+				reqCtx.LogWarning("⚠️  Handwriting OCR failed for image %d, keeping standard OCR: %v", ocrResult.ImageIndex, hwErr)
+				continue
+			}
+			pureOCRResults[i].Result = hwResult
+		}
+	}
+
+	// Step 3.15: Long receipt photographed in overlapping parts → one OCR text (top to bottom, repeated lines removed)
+	// Stitched = a single OCR result, so document clustering below sees one document
+	if (configs.Get().EnableReceiptStitching || req.StitchImages) && len(pureOCRResults) > 1 {
+		pureOCRResults, in.Stitch = stitchOCRResults(pureOCRResults)
+		if in.Stitch.Stitched {
+			reqCtx.LogInfo("🧵 Stitched %d photos of one receipt (order %v)", len(in.Stitch.Order), in.Stitch.Order)
+		} else {
+			reqCtx.LogInfo("🧵 Photos not stitched: %s", in.Stitch.Reason)
+		}
+	}
+
+	// Step 3.2: Decode QR codes / barcodes (PromptPay, bill payment, e-Tax, slip mini QR)
+	// QR data is appended to the OCR text so the accounting AI sees it, and overrides OCR values after Phase 3
+	var decodedCodes []processor.DecodedCode
+	if configs.Get().EnableQRDecoding {
+		for _, img := range downloadedImages {
+			codes, err := processor.DecodeImageCodes(img.Filename, img.Index)
+			if err != nil {
+				reqCtx.LogWarning("⚠️  Image %d QR decoding failed: %v", img.Index, err)
+				continue
+			}
+			if len(codes) == 0 {
+				continue
+			}
+			decodedCodes = append(decodedCodes, codes...)
+			for i := range pureOCRResults {
+				if (pureOCRResults[i].ImageIndex != img.Index && !in.Stitch.Stitched) || pureOCRResults[i].Result == nil {
+					continue
+				}
+				for _, code := range codes {
+					pureOCRResults[i].Result.RawDocumentText += "\n" + code.Summary()
+				}
+			}
+			reqCtx.LogInfo("🔳 Image %d: decoded %d QR/barcode(s)", img.Index, len(codes))
+		}
+	}
+
+	// Step 3.25: Keep the OCR text so the accounting can be re-run without re-OCR (POST /results/:request_id/reanalyze)
+	if configs.OCR_RESULT_TTL_DAYS > 0 {
+		var storedImages []storage.StoredOCRImage
+		for _, ocrResult := range pureOCRResults {
+			if ocrResult.Result == nil {
+				continue
+			}
+			stored := storage.StoredOCRImage{ImageIndex: ocrResult.ImageIndex, RawText: ocrResult.Result.RawDocumentText}
+			for _, img := range downloadedImages {
+				if img.Index == ocrResult.ImageIndex {
+					stored.DocumentImageGUID, stored.ImageURI = img.GUID, img.URI
+				}
+			}
+			storedImages = append(storedImages, stored)
+		}
+		if in.Stitch.Stitched {
+			// Keep the references of the photos merged into the top photo's text
+			for _, img := range downloadedImages {
+				if img.Index != in.Stitch.Order[0] {
+					storedImages = append(storedImages, storage.StoredOCRImage{ImageIndex: img.Index, DocumentImageGUID: img.GUID, ImageURI: img.URI})
+				}
+			}
+		}
+		storeOCRResult(s.Results, reqCtx, in.OCRProvider, "", storedImages, nil, nil)
+	}
+
+	// Step 3.26: OCR text of every image for the response (include_raw_text) - same text the accounting AI reads
+	if req.IncludeRawText {
+		in.RawDocumentTexts = make([]RawDocumentText, 0, len(pureOCRResults))
+		for _, ocrResult := range pureOCRResults {
+			in.RawDocumentTexts = append(in.RawDocumentTexts, newRawDocumentText(ocrResult.ImageIndex, req.ImageReferences, ocrResult.Result, ocrResult.Error))
+		}
+	}
+
+	// Step 3.3: Group images of unrelated documents (invoice + its slip, multi-page documents)
+	// The first document continues as in.Images / in.OCRResults, the others get their own Phase 3 in Step 6.4
+	// Petty cash batch: every receipt is its own document (small receipts often have no document number)
+	if (configs.Get().EnableDocumentClustering || req.PettyCash) && len(pureOCRResults) > 1 {
+		fingerprints := make([]processor.DocumentFingerprint, 0, len(pureOCRResults))
+		for _, ocrResult := range pureOCRResults {
+			if ocrResult.Result != nil {
+				fingerprints = append(fingerprints, processor.FingerprintDocument(ocrResult.ImageIndex, ocrResult.Result.RawDocumentText))
+			}
+		}
+		if len(fingerprints) == len(pureOCRResults) {
+			if req.PettyCash {
+				in.Clusters = processor.ClusterReceipts(fingerprints)
+			} else {
+				in.Clusters = processor.ClusterDocuments(fingerprints)
+			}
+		}
+		if len(in.Clusters) > 1 {
+			inputs := make([]documentClusterInput, len(in.Clusters))
+			clusterOf := map[int]int{}
+			for ci, cluster := range in.Clusters {
+				inputs[ci].cluster = cluster
+				for _, idx := range cluster.ImageIndices {
+					clusterOf[idx] = ci
+				}
+				reqCtx.LogInfo("🗂️  Document %d: images %v (%s)", ci+1, cluster.ImageIndices, strings.Join(cluster.Reasons, ", "))
+			}
+			for _, img := range downloadedImages {
+				inputs[clusterOf[img.Index]].images = append(inputs[clusterOf[img.Index]].images, img)
+			}
+			for _, ocrResult := range pureOCRResults {
+				inputs[clusterOf[ocrResult.ImageIndex]].ocrResults = append(inputs[clusterOf[ocrResult.ImageIndex]].ocrResults, ocrResult)
+			}
+			for _, code := range decodedCodes {
+				inputs[clusterOf[code.ImageIndex]].codes = append(inputs[clusterOf[code.ImageIndex]].codes, code)
+			}
+			downloadedImages, pureOCRResults, decodedCodes = inputs[0].images, inputs[0].ocrResults, inputs[0].codes
+			in.secondaryClusters = inputs[1:]
+		}
+	}
+
+	// 🔍 DEBUG: Log pure OCR results (only when debug=true)
+	if in.Debug {
+		reqCtx.LogInfo("📋 DEBUG: Pure OCR Results Overview:")
+		for i, ocrResult := range pureOCRResults {
+			if ocrResult.Result != nil {
+				// Show first 500 chars of raw text
+				rawText := ocrResult.Result.RawDocumentText
+				if len(rawText) > 500 {
+					rawText = rawText[:500] + "..."
+				}
+				reqCtx.LogInfo("Image %d Raw Text:\n%s", i, rawText)
+			}
+		}
+	}
+
+	in.Images, in.OCRResults, in.DecodedCodes = downloadedImages, pureOCRResults, decodedCodes
+}
@@ -0,0 +1,415 @@
+// analyze_service.go - Pipeline stages of analyze-receipt that do not depend on HTTP
+//
+// AnalyzeReceiptHandler แปลง request/response ส่วน AnalyzeService ทำงานจริงของแต่ละขั้น
+// (Load master data → Download → OCR → Template match → Analyze → Validate → Compose ใน analyze_stages.go,
+// ต่อกันทั้งหมดใน Run - analyze_pipeline.go)
+// ผ่าน interface ที่ inject ได้ (storage.Store จาก main)
+// → เปลี่ยน storage / AI / downloader เป็นตัวจำลองได้โดยไม่ต้องมี MongoDB, network หรือ API key
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FileDownloader saves the file at uri to filename and returns its extension (".jpg", ".pdf", ...)
+type FileDownloader interface {
+	Download(ctx context.Context, uri, filename string) (string, error)
+}
+
+// OCRProviders creates the OCR provider of a model and the alternate used when content is blocked
+type OCRProviders interface {
+	Create(model string) (ai.OCRProvider, error)
+	Alternate(current string) ai.OCRProvider
+	// Handwritten re-reads a handwritten image with the handwriting preprocessing + prompt
+	Handwritten(ctx context.Context, imagePath string, reqCtx *common.RequestContext) (*ai.SimpleOCRResult, *common.TokenUsage, error)
+}
+
+// TemplateMatcher scores the OCR text against the shop's documentFormate templates
+type TemplateMatcher interface {
+	Match(ctx context.Context, text string, templates []bson.M, reqCtx *common.RequestContext) processor.TemplateMatchResult
+}
+
+// AnalyzeService runs the analyze-receipt stages with injected dependencies
+type AnalyzeService struct {
+	MasterData storage.MasterDataRepo
+	Templates  storage.TemplateRepo
+	Downloader FileDownloader
+	OCR        OCRProviders
+	Matcher    TemplateMatcher
+	Accounting Accountant
+	Results    storage.ResultRepo // Previous documents of the creditor (document sequence check)
+	UploadDir  string             // Temporary files of downloaded images (default: UPLOAD_DIR)
+}
+
+// NewAnalyzeService returns the service backed by store, HTTP downloads and the configured AI providers
+func NewAnalyzeService(store storage.Store) *AnalyzeService {
+	return &AnalyzeService{
+		MasterData: store,
+		Templates:  store,
+		Downloader: httpFileDownloader{},
+		OCR:        aiOCRProviders{},
+		Matcher:    aiTemplateMatcher{},
+		Accounting: aiAccountant{},
+		Results:    store,
+	}
+}
+
+// analyzeService is used by AnalyzeReceiptHandler (set by UseStore)
+var analyzeService *AnalyzeService
+
+// errMasterDataMissing means the shop has no chart of accounts or no journal books
+var errMasterDataMissing = errors.New("master data not found")
+
+// ImageData is a downloaded image of the request (field names are part of the Phase 3 prompt JSON)
+type ImageData struct {
+	Filename string
+	Index    int
+	GUID     string
+	URI      string
+}
+
+// PureOCRImageResult is the OCR outcome of one image (field names are part of the Phase 3 prompt JSON)
+type PureOCRImageResult struct {
+	ImageIndex int
+	Result     *ai.SimpleOCRResult
+	Tokens     *common.TokenUsage
+	Error      error
+}
+
+// DownloadError is a failed download of imagereferences[Index]
+type DownloadError struct {
+	Index        int
+	URI          string
+	Reason       string // "missing_uri", "download", "too_large" (*PayloadTooLargeError), "rejected" (file scan), "save"
+	PhaseTimeout bool   // The download phase ran out of its own deadline (DOWNLOAD_TIMEOUT)
+	Err          error
+}
+
+func (e *DownloadError) Error() string {
+	return fmt.Sprintf("image %d: %v", e.Index, e.Err)
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// TemplateMatchOutcome is the template match result and the master data mode it selects for Phase 3
+type TemplateMatchOutcome struct {
+	Result    processor.TemplateMatchResult
+	Mode      ai.MasterDataMode
+	Template  *bson.M // nil in full mode
+	Threshold float64
+}
+
+// LoadShopData loads the master data and the accounting templates of the shop
+// Returns errMasterDataMissing (with the loaded cache) when accounts or journal books are missing
+// Templates are optional - a failed template load continues with none
+func (s *AnalyzeService) LoadShopData(ctx context.Context, shopID string, reqCtx *common.RequestContext) (*storage.MasterDataCache, []bson.M, error) {
+	masterCache, err := s.MasterData.GetOrLoadMasterData(ctx, shopID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(masterCache.Accounts) == 0 || len(masterCache.JournalBooks) == 0 {
+		return masterCache, nil, errMasterDataMissing
+	}
+
+	templates, err := s.Templates.ListDocumentTemplates(ctx, shopID)
+	if err != nil {
+		reqCtx.LogWarning("Failed to fetch documentFormate templates: %v", err)
+		templates = []bson.M{}
+	}
+	return masterCache, templates, nil
+}
+
+// DownloadImages downloads every image reference into the upload directory
+// On error the files downloaded so far are removed and a *DownloadError is returned
+func (s *AnalyzeService) DownloadImages(ctx context.Context, refs []ImageReference, reqCtx *common.RequestContext) ([]ImageData, error) {
+	uploadDir := s.UploadDir
+	if uploadDir == "" {
+		uploadDir = configs.UPLOAD_DIR
+	}
+
+	downloadCtx, cancelDownload := phaseContext(ctx, phaseDownload)
+	defer cancelDownload()
+
+	var images []ImageData
+	var totalBytes int64
+	maxTotalBytes := int64(configs.Get().MaxTotalPayloadBytes)
+	for i, imgRef := range refs {
+		if imgRef.ImageURI == "" {
+			RemoveDownloadedImages(images, reqCtx)
+			return nil, &DownloadError{Index: i, Reason: "missing_uri", Err: fmt.Errorf("imageuri is required in imagereferences[%d]", i)}
+		}
+
+		// Generate temporary filename (extension will be set after download)
+		uniqueID := uuid.New().String()
+		tempFilename := filepath.Join(uploadDir, fmt.Sprintf("%s_%d.tmp", uniqueID, i))
+
+		// Download file from Azure Blob Storage (supports images and PDFs)
+		fileExt, err := s.Downloader.Download(downloadCtx, imgRef.ImageURI, tempFilename)
+		if err != nil {
+			os.Remove(tempFilename)
+			RemoveDownloadedImages(images, reqCtx)
+			if _, tooLarge := asPayloadTooLarge(err); tooLarge {
+				return nil, &DownloadError{Index: i, URI: imgRef.ImageURI, Reason: "too_large", Err: err}
+			}
+			return nil, &DownloadError{Index: i, URI: imgRef.ImageURI, Reason: "download", PhaseTimeout: phaseTimedOut(downloadCtx, ctx), Err: err}
+		}
+
+		// Total of the request (MAX_TOTAL_PAYLOAD_BYTES)
+		if info, err := os.Stat(tempFilename); err == nil {
+			totalBytes += info.Size()
+		}
+		if maxTotalBytes > 0 && totalBytes > maxTotalBytes {
+			os.Remove(tempFilename)
+			RemoveDownloadedImages(images, reqCtx)
+			err := &PayloadTooLargeError{Reason: payloadReasonTotalPayload, Value: totalBytes, Limit: maxTotalBytes}
+			return nil, &DownloadError{Index: i, URI: imgRef.ImageURI, Reason: "too_large", Err: err}
+		}
+
+		// Malware scan before anything reads the file (FILE_SCAN_DRIVER)
+		if err := scanDocumentFile(ctx, reqCtx, tempFilename, i); err != nil {
+			os.Remove(tempFilename)
+			RemoveDownloadedImages(images, reqCtx)
+			return nil, &DownloadError{Index: i, URI: imgRef.ImageURI, Reason: "rejected", Err: err}
+		}
+		sanitizeDocumentFile(reqCtx, tempFilename, i)
+
+		// Rename file with correct extension
+		finalFilename := filepath.Join(uploadDir, fmt.Sprintf("%s_%d%s", uniqueID, i, fileExt))
+		if err := os.Rename(tempFilename, finalFilename); err != nil {
+			os.Remove(tempFilename)
+			RemoveDownloadedImages(images, reqCtx)
+			return nil, &DownloadError{Index: i, URI: imgRef.ImageURI, Reason: "save", Err: err}
+		}
+
+		reqCtx.LogInfo("Downloaded file %d: %s (type: %s)", i, filepath.Base(finalFilename), fileExt)
+		images = append(images, ImageData{
+			Filename: finalFilename,
+			Index:    i,
+			GUID:     imgRef.DocumentImageGUID,
+			URI:      imgRef.ImageURI,
+		})
+	}
+	return images, nil
+}
+
+// RemoveDownloadedImages deletes the temporary files of the downloaded images
+func RemoveDownloadedImages(images []ImageData, reqCtx *common.RequestContext) {
+	for _, img := range images {
+		if err := os.Remove(img.Filename); err != nil {
+			reqCtx.LogWarning("Failed to delete temporary file %s: %v", img.Filename, err)
+		}
+	}
+}
+
+// RunPureOCR extracts the raw text of every image with the provider of model
+// Results are in image order; an image that fails keeps its error and the others continue
+// Content blocked by one provider is retried with the alternate provider
+func (s *AnalyzeService) RunPureOCR(ctx context.Context, model string, images []ImageData, reqCtx *common.RequestContext) (ai.OCRProvider, []PureOCRImageResult, common.TokenUsage, error) {
+	var totalTokens common.TokenUsage
+
+	ocrProvider, err := s.OCR.Create(model)
+	if err != nil {
+		return nil, nil, totalTokens, err
+	}
+
+	// Sequential processing (1 image at a time) to prevent 429 Rate Limit errors
+	// Gemini Free Tier: 15 RPM - parallel requests cause burst traffic → 429 errors
+	results := make([]PureOCRImageResult, 0, len(images))
+	for _, img := range images {
+		result, tokens, err := s.ocrImage(ctx, ocrProvider, img, reqCtx)
+		if ctx.Err() != nil {
+			// Request deadline passed or client went away - the remaining images would fail the same way
+			return ocrProvider, results, totalTokens, nil
+		}
+
+		if err != nil {
+			reqCtx.LogWarning("⚠️  Image %d Pure OCR failed: %v", img.Index, err)
+		}
+		// Basic validation: check if we got text
+		if result != nil && result.RawDocumentText == "" {
+			reqCtx.LogWarning("⚠️  Image %d - No text extracted (blank or unreadable image)", img.Index)
+		}
+
+		results = append(results, PureOCRImageResult{
+			ImageIndex: img.Index,
+			Result:     result,
+			Tokens:     tokens,
+			Error:      err,
+		})
+		addTokenUsage(&totalTokens, tokens)
+	}
+	return ocrProvider, results, totalTokens, nil
+}
+
+// ocrImage runs OCR of one image, retrying with the alternate provider if the content was blocked
+func (s *AnalyzeService) ocrImage(ctx context.Context, ocrProvider ai.OCRProvider, img ImageData, reqCtx *common.RequestContext) (*ai.SimpleOCRResult, *common.TokenUsage, error) {
+	// Sub-steps of this image are timed on their own track (safe if images are processed in parallel)
+	reqCtx = reqCtx.Track(fmt.Sprintf("image %d", img.Index))
+
+	// Each image gets its own OCR deadline (FULL_OCR_TIMEOUT)
+	ocrCtx, cancelOCR := phaseContext(ctx, failurePhaseOCR)
+	result, tokens, err := ocrProvider.ProcessPureOCR(ocrCtx, ocrImagePath(ocrProvider, img), reqCtx)
+	if phaseTimedOut(ocrCtx, ctx) {
+		reqCtx.RecordPhaseTimeout(failurePhaseOCR, phaseTimeout(failurePhaseOCR), fmt.Sprintf("image %d", img.Index))
+	}
+	cancelOCR()
+
+	// Content blocked (safety/copyright) → retry this image with the alternate provider if allowed
+	blockErr, blocked := ai.AsSafetyBlock(err)
+	if !blocked {
+		return result, tokens, err
+	}
+	alternate := s.OCR.Alternate(ocrProvider.GetProviderName())
+	if alternate == nil {
+		return result, tokens, err
+	}
+
+	reqCtx.LogWarning("🛡️  Image %d blocked by %s (%s) → retrying with %s",
+		img.Index, blockErr.Provider, blockErr.Kind, alternate.GetProviderName())
+	altCtx, cancelAlt := phaseContext(ctx, failurePhaseOCR)
+	defer cancelAlt()
+	altResult, altTokens, altErr := alternate.ProcessPureOCR(altCtx, ocrImagePath(alternate, img), reqCtx)
+	if altErr != nil {
+		if phaseTimedOut(altCtx, ctx) {
+			reqCtx.RecordPhaseTimeout(failurePhaseOCR, phaseTimeout(failurePhaseOCR), fmt.Sprintf("image %d (%s)", img.Index, alternate.GetProviderName()))
+		}
+		reqCtx.LogWarning("⚠️  Alternate provider %s also failed: %v", alternate.GetProviderName(), altErr)
+		return result, tokens, err
+	}
+	altResult.Warning = strings.TrimSpace(fmt.Sprintf("%s content was blocked (%s), OCR done by %s. %s",
+		blockErr.Provider, blockErr.Kind, alternate.GetProviderName(), altResult.Warning))
+	return altResult, altTokens, nil
+}
+
+// ocrImagePath is the local file for Gemini and the original URL for Mistral (when available)
+// STRIP_IMAGE_METADATA: Mistral also gets the local file (sent inline / uploaded) - the URL still serves the image with its EXIF
+func ocrImagePath(provider ai.OCRProvider, img ImageData) string {
+	if provider.GetProviderName() == "mistral" && img.URI != "" && !configs.Get().StripImageMetadata {
+		return img.URI
+	}
+	return img.Filename
+}
+
+// MatchTemplate scores the combined OCR text against the templates and selects the master data mode
+// ≥ threshold → template-only mode (Phase 3 gets only the matched template), otherwise full master data
+func (s *AnalyzeService) MatchTemplate(ctx context.Context, ocrResults []PureOCRImageResult, templates []bson.M, threshold float64, reqCtx *common.RequestContext) TemplateMatchOutcome {
+	// TEMPLATE_MATCH_TIMEOUT - on timeout continue in full mode
+	matchCtx, cancelMatch := phaseContext(ctx, failurePhaseTemplateMatch)
+	defer cancelMatch()
+	outcome := TemplateMatchOutcome{
+		Result:    s.Matcher.Match(matchCtx, combinedOCRText(ocrResults), templates, reqCtx),
+		Mode:      ai.FullMode,
+		Threshold: threshold,
+	}
+	if phaseTimedOut(matchCtx, ctx) {
+		reqCtx.RecordPhaseTimeout(failurePhaseTemplateMatch, phaseTimeout(failurePhaseTemplateMatch), "continuing in full mode")
+	}
+
+	if outcome.Result.Confidence >= threshold && outcome.Result.Template != nil {
+		outcome.Mode = ai.TemplateOnlyMode
+		outcome.Template = &outcome.Result.Template
+	}
+	return outcome
+}
+
+// stitchOCRResults merges overlapping photos of one long receipt into a single OCR result
+// (image index of the top photo). Returns the results unchanged when the photos do not form one chain
+func stitchOCRResults(ocrResults []PureOCRImageResult) ([]PureOCRImageResult, processor.ReceiptStitch) {
+	segments := make([]processor.StitchSegment, 0, len(ocrResults))
+	for _, ocrResult := range ocrResults {
+		if ocrResult.Result == nil {
+			return ocrResults, processor.ReceiptStitch{Reason: fmt.Sprintf("image %d has no OCR text", ocrResult.ImageIndex)}
+		}
+		segments = append(segments, processor.StitchSegment{ImageIndex: ocrResult.ImageIndex, Text: ocrResult.Result.RawDocumentText})
+	}
+	stitch := processor.StitchReceiptText(segments)
+	if !stitch.Stitched {
+		return ocrResults, stitch
+	}
+
+	var merged PureOCRImageResult
+	for _, ocrResult := range ocrResults {
+		if ocrResult.ImageIndex == stitch.Order[0] {
+			merged = ocrResult
+		}
+	}
+	result := *merged.Result
+	result.RawDocumentText = stitch.Text
+	result.TextLength = len([]rune(stitch.Text))
+	for _, ocrResult := range ocrResults {
+		result.IsPartial = result.IsPartial || ocrResult.Result.IsPartial
+	}
+	merged.Result = &result
+	return []PureOCRImageResult{merged}, stitch
+}
+
+// combinedOCRText joins the raw text of all images for matching across the whole document
+func combinedOCRText(ocrResults []PureOCRImageResult) string {
+	var combinedText string
+	for _, ocrResult := range ocrResults {
+		if ocrResult.Result != nil {
+			combinedText += ocrResult.Result.RawDocumentText + "\n\n"
+		}
+	}
+	return combinedText
+}
+
+// addTokenUsage adds usage (if any) to total
+func addTokenUsage(total *common.TokenUsage, usage *common.TokenUsage) {
+	if usage == nil {
+		return
+	}
+	total.InputTokens += usage.InputTokens
+	total.OutputTokens += usage.OutputTokens
+	total.TotalTokens += usage.TotalTokens
+	total.CostUSD += usage.CostUSD
+	total.CostTHB += usage.CostTHB
+}
+
+// httpFileDownloader downloads over HTTP(S) (MOCK_AI: copies the fixture image, archive://: file of a running zip batch)
+type httpFileDownloader struct{}
+
+func (httpFileDownloader) Download(ctx context.Context, uri, filename string) (string, error) {
+	if strings.HasPrefix(uri, archiveURIScheme) {
+		return copyArchiveFile(uri, filename)
+	}
+	return downloadImageFromURL(ctx, uri, filename)
+}
+
+// aiOCRProviders uses the provider factory of the ai package
+type aiOCRProviders struct{}
+
+func (aiOCRProviders) Create(model string) (ai.OCRProvider, error) {
+	return ai.CreateOCRProvider(model)
+}
+
+func (aiOCRProviders) Alternate(current string) ai.OCRProvider {
+	return ai.AlternateOCRProvider(current)
+}
+
+func (aiOCRProviders) Handwritten(ctx context.Context, imagePath string, reqCtx *common.RequestContext) (*ai.SimpleOCRResult, *common.TokenUsage, error) {
+	return ai.ProcessHandwrittenOCR(ctx, imagePath, reqCtx)
+}
+
+// aiTemplateMatcher uses the AI template matcher of the processor package
+type aiTemplateMatcher struct{}
+
+func (aiTemplateMatcher) Match(ctx context.Context, text string, templates []bson.M, reqCtx *common.RequestContext) processor.TemplateMatchResult {
+	return processor.AnalyzeTemplateMatch(ctx, text, templates, reqCtx)
+}
@@ -0,0 +1,521 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv("GEMINI_API_KEY") == "" {
+		os.Setenv("GEMINI_API_KEY", "test")
+	}
+	configs.LoadConfig()
+	os.Exit(m.Run())
+}
+
+const testShopID = "shop-test"
+
+// fakeDownloader writes the content registered for a URI (unknown URI = download error)
+type fakeDownloader struct {
+	files map[string]string
+}
+
+func (d fakeDownloader) Download(ctx context.Context, uri, filename string) (string, error) {
+	content, ok := d.files[uri]
+	if !ok {
+		return "", fmt.Errorf("GET %s: 404 Not Found", uri)
+	}
+	return ".png", os.WriteFile(filename, []byte(content), 0644)
+}
+
+// fakeOCRProvider returns the text registered for the file content (the downloaded "image")
+type fakeOCRProvider struct {
+	name  string
+	texts map[string]string
+	paths []string
+}
+
+func (p *fakeOCRProvider) ProcessPureOCR(ctx context.Context, imagePath string, reqCtx *common.RequestContext) (*ai.SimpleOCRResult, *common.TokenUsage, error) {
+	p.paths = append(p.paths, imagePath)
+	content, err := os.ReadFile(imagePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	text, ok := p.texts[string(content)]
+	if !ok {
+		return nil, nil, fmt.Errorf("unreadable image %s", imagePath)
+	}
+	return &ai.SimpleOCRResult{RawDocumentText: text, TextLength: len([]rune(text))}, &common.TokenUsage{InputTokens: 100, OutputTokens: 50, TotalTokens: 150}, nil
+}
+
+func (p *fakeOCRProvider) GetProviderName() string {
+	return p.name
+}
+
+type fakeOCRProviders struct {
+	provider *fakeOCRProvider
+}
+
+func (f fakeOCRProviders) Create(model string) (ai.OCRProvider, error) {
+	if model != f.provider.name {
+		return nil, fmt.Errorf("unsupported model %s", model)
+	}
+	return f.provider, nil
+}
+
+func (f fakeOCRProviders) Alternate(current string) ai.OCRProvider {
+	return nil
+}
+
+func (f fakeOCRProviders) Handwritten(ctx context.Context, imagePath string, reqCtx *common.RequestContext) (*ai.SimpleOCRResult, *common.TokenUsage, error) {
+	return nil, nil, errors.New("handwriting OCR is not used in these tests")
+}
+
+// fakeMatcher returns the same template match for every text
+type fakeMatcher struct {
+	result processor.TemplateMatchResult
+}
+
+func (m fakeMatcher) Match(ctx context.Context, text string, templates []bson.M, reqCtx *common.RequestContext) processor.TemplateMatchResult {
+	return m.result
+}
+
+// fakeAccountant returns a fixed Phase 3 response and records its inputs
+type fakeAccountant struct {
+	response string
+	err      error
+	inputs   []AccountingInput
+}
+
+func (a *fakeAccountant) Analyze(ctx context.Context, in AccountingInput, reqCtx *common.RequestContext) (string, *common.TokenUsage, error) {
+	a.inputs = append(a.inputs, in)
+	if a.err != nil {
+		return "", nil, a.err
+	}
+	return a.response, &common.TokenUsage{InputTokens: 1000, OutputTokens: 200, TotalTokens: 1200}, nil
+}
+
+func (a *fakeAccountant) VerifyCriticalFields(ctx context.Context, imagePaths []string, reqCtx *common.RequestContext) (*processor.CriticalFields, *common.TokenUsage, error) {
+	return nil, nil, errors.New("field verification is not used in these tests")
+}
+
+const testReceiptText = "บริษัท สยามแม็คโคร จำกัด (มหาชน)\nใบกำกับภาษี เลขที่ INV-001\nสินค้า 100.00\nภาษีมูลค่าเพิ่ม 7.00\nรวม 107.00"
+
+const testAccountingResponse = `{
+  "receipt": {"number": "INV-001", "date": "2026-01-15", "vendor_name": "บริษัท สยามแม็คโคร จำกัด (มหาชน)", "vendor_tax_id": "0107537000521", "total": 107, "vat": 7},
+  "accounting_entry": {
+    "document_date": "2026-01-15",
+    "reference_number": "INV-001",
+    "journal_book_code": "02",
+    "entries": [
+      {"account_code": "5100", "account_name": "ซื้อสินค้า", "debit": 100, "credit": 0},
+      {"account_code": "1154", "account_name": "ภาษีซื้อ", "debit": 7, "credit": 0},
+      {"account_code": "2120", "account_name": "เจ้าหนี้การค้า", "debit": 0, "credit": 107}
+    ]
+  },
+  "creditor": {"creditor_code": "C999", "creditor_name": "ไม่มีในระบบ"},
+  "validation": {"ai_explanation": {"reasoning": "ซื้อสินค้าเชื่อ", "evidence_from_receipt": "..."}}
+}`
+
+func testMasterData() *storage.MasterDataCache {
+	return &storage.MasterDataCache{
+		Accounts: []bson.M{
+			{"accountcode": "1000", "accountname": "สินทรัพย์", "accountlevel": int32(1)},
+			{"accountcode": "1154", "accountname": "ภาษีซื้อ", "accountlevel": int32(4)},
+			{"accountcode": "2120", "accountname": "เจ้าหนี้การค้า", "accountlevel": int32(4)},
+			{"accountcode": "5100", "accountname": "ซื้อสินค้า", "accountlevel": int32(4)},
+		},
+		JournalBooks: []bson.M{{"code": "02", "name1": "สมุดรายวันซื้อ"}},
+		Creditors: []bson.M{{
+			"code":  "C001",
+			"names": bson.A{bson.M{"code": "th", "name": "บริษัท สยามแม็คโคร จำกัด (มหาชน)"}},
+		}},
+	}
+}
+
+// newTestService returns a service on a MemoryStore with the fake downloader / OCR / matcher / accountant
+func newTestService(t *testing.T, accountant *fakeAccountant, match processor.TemplateMatchResult) (*AnalyzeService, *fakeOCRProvider) {
+	t.Helper()
+	store := storage.NewMemoryStore()
+	store.SetMasterData(testShopID, testMasterData())
+	store.SetDocumentTemplates(testShopID, []bson.M{{"description": "ซื้อสินค้าเชื่อ", "details": bson.A{bson.M{"accountcode": "5100"}}}})
+
+	provider := &fakeOCRProvider{name: "gemini", texts: map[string]string{"receipt-image": testReceiptText}}
+	return &AnalyzeService{
+		MasterData: store,
+		Templates:  store,
+		Downloader: fakeDownloader{files: map[string]string{"https://files.test/receipt.png": "receipt-image"}},
+		OCR:        fakeOCRProviders{provider: provider},
+		Matcher:    fakeMatcher{result: match},
+		Accounting: accountant,
+		Results:    store,
+		UploadDir:  t.TempDir(),
+	}, provider
+}
+
+// runOCRStages runs load → download → OCR → template match and returns the input of the accounting stages
+func runOCRStages(t *testing.T, service *AnalyzeService, reqCtx *common.RequestContext) *AnalysisInput {
+	t.Helper()
+	ctx := context.Background()
+	req := ExtractRequest{
+		ShopID:          testShopID,
+		Model:           "gemini",
+		ImageReferences: []ImageReference{{DocumentImageGUID: "guid-1", ImageURI: "https://files.test/receipt.png"}},
+	}
+
+	masterCache, templates, err := service.LoadShopData(ctx, req.ShopID, reqCtx)
+	if err != nil {
+		t.Fatalf("LoadShopData: %v", err)
+	}
+	images, err := service.DownloadImages(ctx, req.ImageReferences, reqCtx)
+	if err != nil {
+		t.Fatalf("DownloadImages: %v", err)
+	}
+	t.Cleanup(func() { RemoveDownloadedImages(images, reqCtx) })
+	provider, ocrResults, ocrTokens, err := service.RunPureOCR(ctx, req.Model, images, reqCtx)
+	if err != nil {
+		t.Fatalf("RunPureOCR: %v", err)
+	}
+	return &AnalysisInput{
+		Request:     req,
+		MasterCache: masterCache,
+		Templates:   templates,
+		OCRProvider: provider.GetProviderName(),
+		OCRTokens:   ocrTokens,
+		Images:      images,
+		OCRResults:  ocrResults,
+		TemplateMatch: service.MatchTemplate(ctx, ocrResults, templates,
+			reqCtx.Settings.TemplateConfidenceThreshold, reqCtx),
+	}
+}
+
+func TestAnalyzeServicePipeline(t *testing.T) {
+	accountant := &fakeAccountant{response: testAccountingResponse}
+	match := processor.TemplateMatchResult{Confidence: 95, Description: "ซื้อสินค้าเชื่อ", Template: bson.M{"description": "ซื้อสินค้าเชื่อ"}}
+	service, _ := newTestService(t, accountant, match)
+	reqCtx := common.NewRequestContext(testShopID)
+	ctx := context.Background()
+
+	in := runOCRStages(t, service, reqCtx)
+	if in.TemplateMatch.Mode != ai.TemplateOnlyMode {
+		t.Fatalf("template match mode = %s, want template-only (confidence 95)", in.TemplateMatch.Mode)
+	}
+
+	analysis, err := service.Analyze(ctx, in, reqCtx)
+	if err != nil {
+		t.Fatalf("Analyze: %v", err)
+	}
+	if len(accountant.inputs) != 1 {
+		t.Fatalf("Phase 3 calls = %d, want 1", len(accountant.inputs))
+	}
+	prompt := accountant.inputs[0]
+	if prompt.Mode != ai.TemplateOnlyMode || prompt.Template == nil {
+		t.Errorf("Phase 3 mode = %s (template %v), want template-only with the matched template", prompt.Mode, prompt.Template)
+	}
+	if len(prompt.Accounts) != 3 {
+		t.Errorf("Phase 3 accounts = %d, want 3 (level 1 header left out)", len(prompt.Accounts))
+	}
+	if !analysis.Vendor.Found || analysis.Vendor.Code != "C001" {
+		t.Errorf("vendor pre-match = %+v, want C001", analysis.Vendor)
+	}
+
+	validation, err := service.Validate(ctx, in, analysis, reqCtx)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	// Pre-matched vendor wins over the creditor the AI chose
+	if code := validation.AccountingEntry["creditor_code"]; code != "C001" {
+		t.Errorf("creditor_code = %v, want C001", code)
+	}
+	balance, _ := validation.AccountingEntry["balance_check"].(map[string]interface{})
+	if balanced, _ := balance["balanced"].(bool); !balanced {
+		t.Errorf("balance_check = %v, want balanced", balance)
+	}
+	if _, ok := validation.Data["confidence"].(map[string]interface{}); !ok {
+		t.Errorf("validation has no confidence: %v", validation.Data)
+	}
+
+	response, record := service.Compose(in, analysis, validation, reqCtx)
+	if response["shopid"] != testShopID || response["status"] != "success" {
+		t.Errorf("response shopid/status = %v/%v", response["shopid"], response["status"])
+	}
+	metadata, _ := response["metadata"].(gin.H)
+	if metadata["images_processed"] != 1 || metadata["ocr_provider"] != "gemini" {
+		t.Errorf("metadata images_processed/ocr_provider = %v/%v", metadata["images_processed"], metadata["ocr_provider"])
+	}
+	explanation, _ := validation.Data["ai_explanation"].(map[string]interface{})
+	if _, ok := explanation["evidence_from_receipt"]; ok {
+		t.Error("evidence_from_receipt is not removed from ai_explanation")
+	}
+	if record.Mode != storage.AnalysisModeTemplateOnly || record.VendorName != "บริษัท สยามแม็คโคร จำกัด (มหาชน)" {
+		t.Errorf("analytics record mode/vendor = %s/%s", record.Mode, record.VendorName)
+	}
+}
+
+func TestAnalyzeServiceFullModeBelowThreshold(t *testing.T) {
+	accountant := &fakeAccountant{response: testAccountingResponse}
+	service, _ := newTestService(t, accountant, processor.TemplateMatchResult{Confidence: 40, Template: bson.M{"description": "x"}})
+	reqCtx := common.NewRequestContext(testShopID)
+
+	in := runOCRStages(t, service, reqCtx)
+	if _, err := service.Analyze(context.Background(), in, reqCtx); err != nil {
+		t.Fatalf("Analyze: %v", err)
+	}
+	if prompt := accountant.inputs[0]; prompt.Mode != ai.FullMode || prompt.Template != nil {
+		t.Errorf("Phase 3 mode = %s (template %v), want full mode without a template", prompt.Mode, prompt.Template)
+	}
+}
+
+func TestAnalyzeServiceUnknownCreditorCleared(t *testing.T) {
+	// No creditor in the master data matches the OCR text → the AI's creditor is used, then cleared (not in master data)
+	accountant := &fakeAccountant{response: testAccountingResponse}
+	service, _ := newTestService(t, accountant, processor.TemplateMatchResult{})
+	store := storage.NewMemoryStore()
+	masterData := testMasterData()
+	masterData.Creditors = nil
+	store.SetMasterData(testShopID, masterData)
+	service.MasterData, service.Templates = store, store
+	reqCtx := common.NewRequestContext(testShopID)
+
+	in := runOCRStages(t, service, reqCtx)
+	analysis, err := service.Analyze(context.Background(), in, reqCtx)
+	if err != nil {
+		t.Fatalf("Analyze: %v", err)
+	}
+	validation, err := service.Validate(context.Background(), in, analysis, reqCtx)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if code := validation.AccountingEntry["creditor_code"]; code != "" {
+		t.Errorf("creditor_code = %v, want cleared", code)
+	}
+}
+
+func TestAnalyzeServiceAccountingErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		accountant *fakeAccountant
+		wantParse  bool
+	}{
+		{"ai error", &fakeAccountant{err: errors.New("503 overloaded")}, false},
+		{"invalid json", &fakeAccountant{response: "not json"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestService(t, tt.accountant, processor.TemplateMatchResult{})
+			reqCtx := common.NewRequestContext(testShopID)
+			in := runOCRStages(t, service, reqCtx)
+
+			_, err := service.Analyze(context.Background(), in, reqCtx)
+			var accountingErr *AccountingError
+			if !errors.As(err, &accountingErr) {
+				t.Fatalf("Analyze error = %v, want *AccountingError", err)
+			}
+			if accountingErr.Parse != tt.wantParse {
+				t.Errorf("Parse = %v, want %v", accountingErr.Parse, tt.wantParse)
+			}
+			if _, ok := accountingErr.Artifacts["raw_response"]; ok != tt.wantParse {
+				t.Errorf("raw_response artifact present = %v, want %v", ok, tt.wantParse)
+			}
+		})
+	}
+}
+
+func TestAnalyzeServiceForcedParty(t *testing.T) {
+	// reanalyze / compare-modes resolve the party themselves → no pre-match, the forced creditor goes to Phase 3
+	accountant := &fakeAccountant{response: testAccountingResponse}
+	service, _ := newTestService(t, accountant, processor.TemplateMatchResult{})
+	reqCtx := common.NewRequestContext(testShopID)
+	in := runOCRStages(t, service, reqCtx)
+	in.Vendor = &processor.VendorMatchResult{Found: true, Code: "C777", Name: "ร้านที่ผู้ใช้เลือก", Method: "override", Party: processor.PartyCreditor}
+	in.Direction = &processor.DocumentDirection{Direction: processor.DirectionUnknown}
+
+	analysis, err := service.Analyze(context.Background(), in, reqCtx)
+	if err != nil {
+		t.Fatalf("Analyze: %v", err)
+	}
+	if analysis.Vendor.Code != "C777" || accountant.inputs[0].Vendor.Code != "C777" {
+		t.Errorf("vendor = %s (prompt %s), want the forced C777", analysis.Vendor.Code, accountant.inputs[0].Vendor.Code)
+	}
+	if analysis.Tokens == nil || analysis.Tokens.TotalTokens != 1200 {
+		t.Errorf("Phase 3 tokens = %+v, want 1200", analysis.Tokens)
+	}
+}
+
+func TestAnalyzeServiceRun(t *testing.T) {
+	accountant := &fakeAccountant{response: testAccountingResponse}
+	match := processor.TemplateMatchResult{Confidence: 95, Description: "ซื้อสินค้าเชื่อ", Template: bson.M{"description": "ซื้อสินค้าเชื่อ"}}
+	service, _ := newTestService(t, accountant, match)
+	reqCtx := common.NewRequestContext(testShopID)
+	masterCache, templates, err := service.LoadShopData(context.Background(), testShopID, reqCtx)
+	if err != nil {
+		t.Fatalf("LoadShopData: %v", err)
+	}
+
+	var estimates []processor.ComplexityEstimate
+	result, err := service.Run(context.Background(), &AnalyzeRun{
+		Request: ExtractRequest{
+			ShopID:          testShopID,
+			Model:           "gemini",
+			ImageReferences: []ImageReference{{DocumentImageGUID: "guid-1", ImageURI: "https://files.test/receipt.png"}},
+			IncludeRawText:  true,
+		},
+		MasterCache:  masterCache,
+		Templates:    templates,
+		Limit:        time.Minute,
+		OnComplexity: func(estimate processor.ComplexityEstimate) { estimates = append(estimates, estimate) },
+	}, reqCtx)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if result.Input.TemplateMatch.Mode != ai.TemplateOnlyMode || len(accountant.inputs) != 1 {
+		t.Errorf("mode = %s, Phase 3 calls = %d, want template-only once", result.Input.TemplateMatch.Mode, len(accountant.inputs))
+	}
+	if result.Response["status"] != "success" || result.Validation.AccountingEntry["creditor_code"] != "C001" {
+		t.Errorf("response status = %v, creditor = %v", result.Response["status"], result.Validation.AccountingEntry["creditor_code"])
+	}
+	if len(result.Input.RawDocumentTexts) != 1 || len(estimates) != 1 {
+		t.Errorf("raw texts = %d, complexity estimates = %d, want 1 each", len(result.Input.RawDocumentTexts), len(estimates))
+	}
+	// Downloaded files are removed when Run returns
+	if entries, _ := os.ReadDir(service.UploadDir); len(entries) != 0 {
+		t.Errorf("upload dir has %d file(s) left, want 0", len(entries))
+	}
+}
+
+func TestAnalyzeServiceRunErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		accountant *fakeAccountant
+		uri        string
+		model      string
+		check      func(error) bool
+	}{
+		{"download", &fakeAccountant{}, "https://files.test/missing.png", "gemini", func(err error) bool {
+			var downloadErr *DownloadError
+			return errors.As(err, &downloadErr) && downloadErr.Reason == "download"
+		}},
+		{"ocr provider", &fakeAccountant{}, "https://files.test/receipt.png", "mistral", func(err error) bool {
+			var providerErr *OCRProviderError
+			return errors.As(err, &providerErr) && providerErr.Model == "mistral"
+		}},
+		{"accounting", &fakeAccountant{err: errors.New("503 overloaded")}, "https://files.test/receipt.png", "gemini", func(err error) bool {
+			var accountingErr *AccountingError
+			return errors.As(err, &accountingErr)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestService(t, tt.accountant, processor.TemplateMatchResult{})
+			reqCtx := common.NewRequestContext(testShopID)
+			_, err := service.Run(context.Background(), &AnalyzeRun{
+				Request:     ExtractRequest{ShopID: testShopID, Model: tt.model, ImageReferences: []ImageReference{{ImageURI: tt.uri}}},
+				MasterCache: testMasterData(),
+				Limit:       time.Minute,
+			}, reqCtx)
+			if !tt.check(err) {
+				t.Errorf("Run error = %v (%T)", err, err)
+			}
+			if entries, _ := os.ReadDir(service.UploadDir); len(entries) != 0 {
+				t.Errorf("upload dir has %d file(s) left, want 0", len(entries))
+			}
+		})
+	}
+}
+
+func TestAnalyzeServiceMasterDataMissing(t *testing.T) {
+	service, _ := newTestService(t, &fakeAccountant{}, processor.TemplateMatchResult{})
+	reqCtx := common.NewRequestContext("unknown-shop")
+
+	if _, _, err := service.LoadShopData(context.Background(), "unknown-shop", reqCtx); !errors.Is(err, errMasterDataMissing) {
+		t.Errorf("LoadShopData error = %v, want errMasterDataMissing", err)
+	}
+}
+
+func TestAnalyzeServiceDownloadError(t *testing.T) {
+	service, _ := newTestService(t, &fakeAccountant{}, processor.TemplateMatchResult{})
+	reqCtx := common.NewRequestContext(testShopID)
+	refs := []ImageReference{
+		{ImageURI: "https://files.test/receipt.png"},
+		{ImageURI: "https://files.test/missing.png"},
+	}
+
+	_, err := service.DownloadImages(context.Background(), refs, reqCtx)
+	var downloadErr *DownloadError
+	if !errors.As(err, &downloadErr) || downloadErr.Index != 1 || downloadErr.Reason != "download" {
+		t.Fatalf("DownloadImages error = %v, want download error of image 1", err)
+	}
+	// The image downloaded before the failure is removed
+	entries, _ := os.ReadDir(service.UploadDir)
+	if len(entries) != 0 {
+		t.Errorf("upload dir has %d file(s) left, want 0", len(entries))
+	}
+}
+
+func TestAnalyzeServiceOCRFailureKeepsOtherImages(t *testing.T) {
+	service, provider := newTestService(t, &fakeAccountant{}, processor.TemplateMatchResult{})
+	service.Downloader = fakeDownloader{files: map[string]string{
+		"https://files.test/receipt.png": "receipt-image",
+		"https://files.test/blurry.png":  "blurry-image",
+	}}
+	reqCtx := common.NewRequestContext(testShopID)
+	refs := []ImageReference{{ImageURI: "https://files.test/blurry.png"}, {ImageURI: "https://files.test/receipt.png"}}
+
+	images, err := service.DownloadImages(context.Background(), refs, reqCtx)
+	if err != nil {
+		t.Fatalf("DownloadImages: %v", err)
+	}
+	defer RemoveDownloadedImages(images, reqCtx)
+	_, results, _, err := service.RunPureOCR(context.Background(), "gemini", images, reqCtx)
+	if err != nil {
+		t.Fatalf("RunPureOCR: %v", err)
+	}
+	if len(results) != 2 || results[0].Error == nil || results[1].Result == nil {
+		t.Fatalf("OCR results = %+v, want image 0 failed and image 1 read", results)
+	}
+	if !strings.Contains(results[1].Result.RawDocumentText, "INV-001") {
+		t.Errorf("image 1 text = %q", results[1].Result.RawDocumentText)
+	}
+	if len(provider.paths) != 2 {
+		t.Errorf("OCR calls = %d, want 2", len(provider.paths))
+	}
+}
+
+func TestOCRImagePathMistralMetadata(t *testing.T) {
+	img := ImageData{URI: "https://files.test/receipt.jpg", Filename: "/uploads/receipt.jpg"}
+	mistral := &fakeOCRProvider{name: "mistral"}
+	gemini := &fakeOCRProvider{name: "gemini"}
+
+	// STRIP_IMAGE_METADATA on (default): the URL still serves the EXIF → Mistral gets the cleaned local file
+	if got := ocrImagePath(mistral, img); got != img.Filename {
+		t.Errorf("mistral (strip on) path = %q, want %q", got, img.Filename)
+	}
+	if got := ocrImagePath(gemini, img); got != img.Filename {
+		t.Errorf("gemini path = %q, want %q", got, img.Filename)
+	}
+
+	t.Cleanup(func() { configs.ReloadConfig() }) // runs after the env is restored
+	t.Setenv("STRIP_IMAGE_METADATA", "false")
+	if err := configs.ReloadConfig(); err != nil {
+		t.Fatalf("ReloadConfig: %v", err)
+	}
+	if got := ocrImagePath(mistral, img); got != img.URI {
+		t.Errorf("mistral (strip off) path = %q, want %q", got, img.URI)
+	}
+	if got := ocrImagePath(mistral, ImageData{Filename: img.Filename}); got != img.Filename {
+		t.Errorf("mistral without URI path = %q, want %q", got, img.Filename)
+	}
+}
@@ -0,0 +1,1253 @@
+// analyze_stages.go - Accounting stages of analyze-receipt (Analyze → Validate → Compose)
+//
+// Analyze:  Phase 3 วิเคราะห์บัญชีของทุกเอกสารใน request + ปรับผลแบบ deterministic (QR, VAT, ใบลดหนี้, สินทรัพย์, สมุดรายวัน)
+// Validate: ตรวจ entry (ยอดดุล, เจ้าหนี้/ลูกหนี้, VAT, เลขที่เอกสาร) คำนวณ confidence และรวมเหตุผลที่ต้อง review
+// Compose:  ประกอบ response + metadata (ไม่มี side effect - handler บันทึก analytics / summary เอง)
+// Phase 3 และการอ่านซ้ำ (field verification) ผ่าน Accountant → test ใช้ตัวจำลองได้โดยไม่ต้องมี API key
+// Analyze ใช้กับ reanalyze / compare-modes / test-template ด้วย (ผู้เรียกกำหนด template, เจ้าหนี้ได้เอง)
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/slipverify"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Accountant runs the Gemini calls of the accounting stages
+type Accountant interface {
+	// Analyze returns the Phase 3 accounting JSON of one document
+	Analyze(ctx context.Context, in AccountingInput, reqCtx *common.RequestContext) (string, *common.TokenUsage, error)
+	// VerifyCriticalFields re-reads total / VAT / date / tax ID with a targeted prompt (two-pass verification)
+	VerifyCriticalFields(ctx context.Context, imagePaths []string, reqCtx *common.RequestContext) (*processor.CriticalFields, *common.TokenUsage, error)
+}
+
+// AccountingInput is the prompt input of one Phase 3 accounting analysis
+type AccountingInput struct {
+	Images       []ImageData
+	OCRResults   []PureOCRImageResult
+	Mode         ai.MasterDataMode
+	Template     *bson.M // nil in full mode
+	Accounts     []bson.M
+	JournalBooks []bson.M
+	Creditors    []bson.M
+	Debtors      []bson.M
+	ShopProfile  *storage.ShopProfile
+	Templates    []bson.M
+	Vendor       *processor.VendorMatchResult // nil for the other documents of the request
+	Direction    *processor.DocumentDirection
+}
+
+// documentClusterInput is another document of the request (Step 3.3) - own template match + Phase 3 in Step 6.4
+type documentClusterInput struct {
+	cluster    processor.DocumentCluster
+	images     []ImageData
+	ocrResults []PureOCRImageResult
+	codes      []processor.DecodedCode
+}
+
+// AnalysisInput is the outcome of the OCR stages that Analyze, Validate and Compose work on
+type AnalysisInput struct {
+	Request            ExtractRequest
+	RequestedModel     string // Model asked for by the client (before a disabled provider was replaced)
+	MasterCache        *storage.MasterDataCache
+	Templates          []bson.M
+	OCRProvider        string            // Provider that read the images ("gemini" / "mistral")
+	OCRTokens          common.TokenUsage // Pure OCR usage (including handwriting re-OCR)
+	Images             []ImageData       // Images of the first document
+	OCRResults         []PureOCRImageResult
+	DecodedCodes       []processor.DecodedCode
+	Clusters           []processor.DocumentCluster // More than one = several documents in the request
+	secondaryClusters  []documentClusterInput
+	Stitch             processor.ReceiptStitch
+	Handwritten        bool
+	HandwritingSignals map[int]processor.HandwritingSignal
+	TemplateMatch      TemplateMatchOutcome
+	Vendor             *processor.VendorMatchResult // Party resolved by the caller (reanalyze, compare-modes) - nil = pre-matched in Step 5.5
+	Direction          *processor.DocumentDirection // Used with Vendor
+	RawDocumentTexts   []RawDocumentText            // include_raw_text
+	Complexity         processor.ComplexityEstimate
+	Debug              bool
+}
+
+// Analysis is the Phase 3 accounting of the request and the corrections applied to it
+type Analysis struct {
+	Response          map[string]interface{} // Phase 3 JSON (receipt, accounting_entry, validation, ...)
+	Tokens            *common.TokenUsage     // Phase 3 usage of the first document
+	CombinedText      string
+	Accounts          []bson.M // Posting-level chart of accounts (compact)
+	Vendor            processor.VendorMatchResult
+	Direction         processor.DocumentDirection
+	AccountPrefilter  *processor.AccountPrefilterResult
+	CreditorPrefilter *processor.CreditorPrefilterResult
+	ClusterFailures   int // Other documents whose Phase 3 failed
+	QROverrides       []processor.QRFieldOverride
+	ForeignDocument   *processor.ForeignDocument
+	SlipResults       []slipverify.Result
+	TemplateFormulas  *processor.TemplateFormulaResult
+	VATEnforcement    *processor.VATEnforcementResult
+	VATSplit          *processor.VATSplitResult
+	AdjustmentNote    *processor.AdjustmentNoteResult
+	FixedAssets       *processor.FixedAssetResult
+	AccountChecks     []processor.AccountCheck
+}
+
+// Validation is the checked accounting entry, its confidence and the review flags
+type Validation struct {
+	Data            map[string]interface{} // response "validation"
+	Receipt         map[string]interface{}
+	AccountingEntry map[string]interface{}
+	Confidence      processor.ConfidenceResult
+	LearnedMapping  *processor.LearnedMappingResult
+	SelfInvoice     *processor.SelfInvoiceResult
+	EntryGroups     []AccountingEntryGroup
+	PettyCash       *processor.PettyCashVoucher
+}
+
+// AccountingError is a failed Phase 3 (first document, or another document stopped by the budget / deadline)
+type AccountingError struct {
+	Parse        bool // The AI answered but the response is not valid JSON
+	PhaseTimeout bool // ACCOUNTING_TIMEOUT ran out (the request deadline did not)
+	Tokens       *common.TokenUsage
+	Artifacts    map[string]interface{}
+	Err          error
+}
+
+func (e *AccountingError) Error() string {
+	return fmt.Sprintf("accounting analysis: %v", e.Err)
+}
+
+func (e *AccountingError) Unwrap() error {
+	return e.Err
+}
+
+// errSelfInvoiceRejected means the document is the shop's own tax invoice and SELF_INVOICE_ACTION=reject
+var errSelfInvoiceRejected = errors.New("shop's own invoice rejected")
+
+// Analyze runs Phase 3 of the first document and of every other document of the request,
+// then applies the deterministic corrections (QR values, VAT registration, credit notes, fixed assets, journal books)
+// Returns an *AccountingError when the analysis cannot continue
+func (s *AnalyzeService) Analyze(ctx context.Context, in *AnalysisInput, reqCtx *common.RequestContext) (*Analysis, error) {
+	masterCache := in.MasterCache
+	mode, matchedTemplate := in.TemplateMatch.Mode, in.TemplateMatch.Template
+	analysis := &Analysis{CombinedText: combinedOCRText(in.OCRResults)}
+
+	// Step 5: Prepare master data (already validated and loaded at the beginning)
+	// Level 1-2 headers (สินทรัพย์, หนี้สิน) are left out and only essential fields are sent to reduce tokens
+	reqCtx.StartStep("prepare_master_data")
+	accounts, journalBooks, creditors, debtors := compactMasterData(masterCache)
+	analysis.Accounts = accounts
+	reqCtx.LogInfo("✓ Master data ready: %d accounts (filtered from %d), %d journal books, %d creditors, %d debtors",
+		len(accounts), len(masterCache.Accounts), len(journalBooks), len(creditors), len(debtors))
+	reqCtx.EndStep("success", nil, nil)
+
+	// Step 5.5: Pre-match vendors using fuzzy matching (before sending to AI) - unless the caller resolved the party
+	if in.Vendor != nil {
+		analysis.Vendor = *in.Vendor
+		if in.Direction != nil {
+			analysis.Direction = *in.Direction
+		}
+	} else {
+		analysis.Vendor, analysis.Direction = preMatchVendor(in.OCRResults, masterCache, reqCtx)
+	}
+
+	// Step 6: Phase 3 - AI Multi-Image Accounting Analysis (with conditional master data loading)
+	reqCtx.StartStep("phase3_multi_image_accounting")
+	reqCtx.LogInfo("Analyzing relationships between %d image(s) - Mode: %s", len(in.OCRResults), mode)
+
+	// Full mode: send only the accounts relevant to the OCR text (ACCOUNT_PREFILTER_*)
+	phase3Accounts, accountPrefilter := promptAccounts(analysis.CombinedText, accounts, mode, &analysis.Vendor, reqCtx)
+	// Both modes: send only the creditors whose name matches the OCR text (CREDITOR_PREFILTER_*)
+	phase3Creditors, creditorPrefilter := promptCreditors(analysis.CombinedText, creditors, &analysis.Vendor, reqCtx)
+	analysis.AccountPrefilter, analysis.CreditorPrefilter = accountPrefilter, creditorPrefilter
+
+	// Process multi-image accounting analysis with conditional master data (ACCOUNTING_TIMEOUT)
+	accountingCtx, cancelAccounting := phaseContext(ctx, failurePhaseAccounting)
+	defer cancelAccounting()
+	accountingJSON, phase3Tokens, err := s.Accounting.Analyze(accountingCtx, AccountingInput{
+		Images:       in.Images,
+		OCRResults:   in.OCRResults,
+		Mode:         mode,
+		Template:     matchedTemplate,
+		Accounts:     phase3Accounts,
+		JournalBooks: journalBooks,
+		Creditors:    phase3Creditors,
+		Debtors:      debtors,
+		ShopProfile:  masterCache.ShopProfile,
+		Templates:    in.Templates,
+		Vendor:       &analysis.Vendor,
+		Direction:    &analysis.Direction,
+	}, reqCtx)
+	if err != nil {
+		reqCtx.EndStep("failed", phase3Tokens, err)
+		return nil, &AccountingError{
+			PhaseTimeout: phaseTimedOut(accountingCtx, ctx),
+			Tokens:       phase3Tokens,
+			Artifacts:    failureMatchArtifacts(&in.TemplateMatch.Result, &analysis.Vendor),
+			Err:          err,
+		}
+	}
+	reqCtx.EndStep("success", phase3Tokens, nil)
+	analysis.Tokens = phase3Tokens
+
+	// Parse accounting JSON
+	if err := json.Unmarshal([]byte(accountingJSON), &analysis.Response); err != nil {
+		artifacts := failureMatchArtifacts(&in.TemplateMatch.Result, &analysis.Vendor)
+		artifacts["raw_response"] = truncateString(accountingJSON, maxFailureRawResponse)
+		return nil, &AccountingError{Parse: true, Tokens: phase3Tokens, Artifacts: artifacts, Err: err}
+	}
+
+	// Step 6.4: Phase 3 for the other documents found in Step 3.3 (own template match + analysis each)
+	if len(in.secondaryClusters) > 0 {
+		if err := s.analyzeSecondaryClusters(ctx, in, analysis, journalBooks, creditors, debtors, reqCtx); err != nil {
+			return nil, err
+		}
+	}
+
+	applyAccountingCorrections(in, analysis, reqCtx)
+	return analysis, nil
+}
+
+// preMatchVendor matches the issuer of the first document against the creditors (or the customer against
+// the debtors when the shop issued the document) - Step 5.5
+func preMatchVendor(ocrResults []PureOCRImageResult, masterCache *storage.MasterDataCache, reqCtx *common.RequestContext) (processor.VendorMatchResult, processor.DocumentDirection) {
+	reqCtx.LogInfo("\n┌── vendor_pre_matching")
+	defer reqCtx.LogInfo("└── ✅ สำเร็จ")
+
+	vendorMatchResult := processor.VendorMatchResult{Method: "not_found"}
+	// Document direction: shop name / tax ID in the issuer header = sale, in the customer section = purchase
+	documentDirection := processor.DocumentDirection{Direction: processor.DirectionUnknown}
+	if len(ocrResults) == 0 || ocrResults[0].Result == nil {
+		return vendorMatchResult, documentDirection
+	}
+
+	// First non-empty line is usually the vendor name
+	rawText := ocrResults[0].Result.RawDocumentText
+	vendorNameFromOCR := ""
+	for _, line := range strings.Split(rawText, "\n") {
+		if trimmed := strings.TrimSpace(line); len(trimmed) > 5 {
+			vendorNameFromOCR = trimmed
+			break
+		}
+	}
+
+	// Sales document (our shop is the issuer): the party is a debtor, not the first line of the document
+	documentDirection = detectDocumentDirection(rawText, masterCache, reqCtx)
+	if documentDirection.IsSale() {
+		return preMatchSaleDebtor(documentDirection, masterCache, reqCtx), documentDirection
+	}
+	if vendorNameFromOCR == "" {
+		return vendorMatchResult, documentDirection
+	}
+
+	vendorMatchResult = processor.MatchVendor(vendorNameFromOCR, masterCache.Creditors, "", masterCache.CreditorAliases)
+	if vendorMatchResult.Found {
+		reqCtx.LogInfo("✅ Vendor matched: '%s' → '%s' (code: %s, method: %s, %.1f%%)",
+			vendorNameFromOCR, vendorMatchResult.Name, vendorMatchResult.Code, vendorMatchResult.Method, vendorMatchResult.Similarity)
+
+		// Learned mapping: the account a user approved for this creditor
+		if mapping, ok := masterCache.VendorAccountMappings[vendorMatchResult.Code]; ok {
+			vendorMatchResult.LearnedAccountCode = mapping.AccountCode
+			vendorMatchResult.LearnedAccountName = mapping.AccountName
+			reqCtx.LogInfo("📌 Learned mapping: %s → %s %s (%d approvals)", mapping.CreditorCode, mapping.AccountCode, mapping.AccountName, mapping.Approvals)
+		}
+	} else {
+		reqCtx.LogInfo("⚠️  No vendor match found for: '%s'", vendorNameFromOCR)
+	}
+	for i, candidate := range vendorMatchResult.TopCandidates(vendorCandidatesInResponse) {
+		reqCtx.LogInfo("   %d. %s %s (%s, %.1f%%)", i+1, candidate.Code, candidate.Name, candidate.Method, candidate.Similarity)
+	}
+	return vendorMatchResult, documentDirection
+}
+
+// analyzeSecondaryClusters runs template match + Phase 3 of every other document and adds them to document_groups
+// A failed document is counted in ClusterFailures; only the budget or the end of the request stops the analysis
+func (s *AnalyzeService) analyzeSecondaryClusters(ctx context.Context, in *AnalysisInput, analysis *Analysis, journalBooks, creditors, debtors []bson.M, reqCtx *common.RequestContext) error {
+	reqCtx.StartStep("phase3_document_clusters")
+	accountingResponse := analysis.Response
+	receiptSection, _ := accountingResponse["receipt"].(map[string]interface{})
+	primaryEntry, _ := accountingResponse["accounting_entry"].(map[string]interface{})
+	var clusterTotalTokens common.TokenUsage
+	documentGroups := []interface{}{map[string]interface{}{
+		"image_indices":    toAnySlice(in.Clusters[0].ImageIndices),
+		"receipt":          receiptSection,
+		"accounting_entry": primaryEntry,
+	}}
+
+	for _, input := range in.secondaryClusters {
+		clusterText := combinedOCRText(input.ocrResults)
+		clusterMode, clusterTemplate := ai.FullMode, (*bson.M)(nil)
+		clusterMatchCtx, cancelClusterMatch := phaseContext(ctx, failurePhaseTemplateMatch)
+		clusterMatch := s.Matcher.Match(clusterMatchCtx, clusterText, in.Templates, reqCtx)
+		if phaseTimedOut(clusterMatchCtx, ctx) {
+			reqCtx.RecordPhaseTimeout(failurePhaseTemplateMatch, phaseTimeout(failurePhaseTemplateMatch), fmt.Sprintf("document images %v", input.cluster.ImageIndices))
+		}
+		cancelClusterMatch()
+		if clusterMatch.Confidence >= in.TemplateMatch.Threshold && clusterMatch.Template != nil {
+			clusterMode, clusterTemplate = ai.TemplateOnlyMode, &clusterMatch.Template
+		}
+
+		clusterAccounts, _ := promptAccounts(clusterText, analysis.Accounts, clusterMode, nil, reqCtx)
+		clusterCreditors, _ := promptCreditors(clusterText, creditors, nil, reqCtx)
+		clusterDirection := detectDocumentDirection(clusterText, in.MasterCache, reqCtx)
+		clusterCtx, cancelCluster := phaseContext(ctx, failurePhaseAccounting)
+		clusterJSON, clusterTokens, err := s.Accounting.Analyze(clusterCtx, AccountingInput{
+			Images:       input.images,
+			OCRResults:   input.ocrResults,
+			Mode:         clusterMode,
+			Template:     clusterTemplate,
+			Accounts:     clusterAccounts,
+			JournalBooks: journalBooks,
+			Creditors:    clusterCreditors,
+			Debtors:      debtors,
+			ShopProfile:  in.MasterCache.ShopProfile,
+			Templates:    in.Templates,
+			Direction:    &clusterDirection,
+		}, reqCtx)
+		addTokenUsage(&clusterTotalTokens, clusterTokens)
+		clusterTimedOut := phaseTimedOut(clusterCtx, ctx)
+		cancelCluster()
+		if err != nil {
+			if ctx.Err() != nil {
+				reqCtx.EndStep("cancelled", &clusterTotalTokens, err)
+				return &AccountingError{Err: err}
+			}
+			if clusterTimedOut {
+				reqCtx.RecordPhaseTimeout(failurePhaseAccounting, phaseTimeout(failurePhaseAccounting), fmt.Sprintf("document images %v", input.cluster.ImageIndices))
+			}
+			if reqCtx.BudgetError() != nil {
+				reqCtx.EndStep("failed", &clusterTotalTokens, err)
+				return &AccountingError{Err: err}
+			}
+			reqCtx.LogWarning("⚠️  Document images %v: accounting analysis failed: %v", input.cluster.ImageIndices, err)
+			analysis.ClusterFailures++
+			continue
+		}
+		var clusterResponse map[string]interface{}
+		if err := json.Unmarshal([]byte(clusterJSON), &clusterResponse); err != nil {
+			reqCtx.LogWarning("⚠️  Document images %v: invalid accounting response: %v", input.cluster.ImageIndices, err)
+			analysis.ClusterFailures++
+			continue
+		}
+		clusterReceipt, _ := clusterResponse["receipt"].(map[string]interface{})
+		if clusterReceipt != nil {
+			processor.MergeDecodedCodes(clusterReceipt, input.codes)
+		}
+		if sourceImages, ok := clusterResponse["source_images"].([]interface{}); ok {
+			existing, _ := accountingResponse["source_images"].([]interface{})
+			accountingResponse["source_images"] = append(existing, sourceImages...)
+		}
+		documentGroups = append(documentGroups, map[string]interface{}{
+			"image_indices":    toAnySlice(input.cluster.ImageIndices),
+			"receipt":          clusterReceipt,
+			"accounting_entry": clusterResponse["accounting_entry"],
+		})
+	}
+
+	accountingResponse["document_groups"] = documentGroups
+	if documentAnalysis, ok := accountingResponse["document_analysis"].(map[string]interface{}); ok {
+		documentAnalysis["relationship"] = RelationshipSeparateReceipts
+	} else {
+		accountingResponse["document_analysis"] = map[string]interface{}{"relationship": RelationshipSeparateReceipts}
+	}
+	reqCtx.EndStep("success", &clusterTotalTokens, nil)
+	return nil
+}
+
+// applyAccountingCorrections applies the deterministic rules to the Phase 3 result (Steps 6.5 - 6.9)
+func applyAccountingCorrections(in *AnalysisInput, analysis *Analysis, reqCtx *common.RequestContext) {
+	masterCache := in.MasterCache
+	accountingResponse := analysis.Response
+	accounts := analysis.Accounts
+	accountingEntry, hasEntry := accountingResponse["accounting_entry"].(map[string]interface{})
+	receiptSection, hasReceipt := accountingResponse["receipt"].(map[string]interface{})
+
+	// Step 6.5: QR-decoded values win over OCR (tax ID, total)
+	if hasReceipt {
+		analysis.QROverrides = processor.MergeDecodedCodes(receiptSection, in.DecodedCodes)
+		for _, o := range analysis.QROverrides {
+			reqCtx.LogInfo("🔳 %s from QR: %v → %v", o.Field, o.OCRValue, o.QRValue)
+		}
+	}
+
+	// Step 6.55: Foreign documents - date in the document's format → YYYY-MM-DD, currency of the amounts
+	if hasReceipt {
+		analysis.ForeignDocument = foreignDocument(receiptSection, analysis.CombinedText, reqCtx)
+		normalizeCustomFields(receiptSection, reqCtx.Settings.CustomFields)
+	}
+
+	// Step 6.6: Verify transfer slips with the bank (per shop: settings.slipverification)
+	if slipverify.Enabled(masterCache.ShopProfile) && len(in.DecodedCodes) > 0 {
+		reqCtx.StartStep("slip_verification")
+		analysis.SlipResults = slipverify.VerifySlips(masterCache.ShopProfile, in.DecodedCodes, getFloatValue(receiptSection, "total"))
+		for _, r := range analysis.SlipResults {
+			if r.NeedsReview() {
+				reqCtx.LogWarning("🏦 Slip %s (image %d): %s %s", r.TransactionRef, r.ImageIndex, r.Status, r.Message)
+			} else {
+				reqCtx.LogInfo("🏦 Slip %s (image %d): %s", r.TransactionRef, r.ImageIndex, r.Status)
+			}
+		}
+		reqCtx.EndStep("success", nil, nil)
+	}
+
+	// Step 6.65: Template formulas - amounts of the template lines computed from the extracted fields
+	// (matched template, or the template under test in full mode - test-template)
+	if in.TemplateMatch.Template != nil {
+		analysis.TemplateFormulas = applyTemplateFormulas(accountingEntry, receiptSection, in.TemplateMatch.Template, reqCtx)
+	}
+
+	// Step 6.7: Enforce the shop's VAT registration (independent of what the AI returned)
+	if masterCache.ShopProfile != nil && masterCache.ShopProfile.VATRegistered != nil && accountingEntry != nil {
+		result := processor.EnforceVATRegistration(accountingEntry, receiptSection, accounts, *masterCache.ShopProfile.VATRegistered)
+		analysis.VATEnforcement = &result
+		if result.Action != processor.VATActionNone {
+			reqCtx.LogInfo("🧾 VAT enforcement (vat_registered=%v): %s - %s", result.VATRegistered, result.Action, result.Note)
+		}
+	}
+
+	// Step 6.72: Mixed VAT bases (several rates / exempt items) - one revenue/expense line per base
+	if hasEntry {
+		if analysis.VATSplit = processor.SplitMixedVATEntries(accountingEntry, receiptSection, accounts); analysis.VATSplit != nil {
+			reqCtx.LogInfo("🧾 %s", analysis.VATSplit.Note)
+		}
+	}
+
+	// Step 6.75: Credit/debit notes - reverse credit notes booked like invoices, reference the original invoice
+	if hasEntry {
+		if noteType, detectedBy := processor.DetectAdjustmentNote(accountingResponse, analysis.CombinedText); noteType != "" {
+			result := processor.ApplyAdjustmentNote(accountingEntry, noteType, detectedBy, getStringValue(receiptSection, "original_document_number"), analysis.CombinedText)
+			analysis.AdjustmentNote = &result
+			reqCtx.LogInfo("🔁 %s (detected by %s, original: %s, reversed=%v) - %s",
+				result.DocumentType, result.DetectedBy, result.OriginalDocumentNumber, result.Reversed, result.Note)
+		}
+	}
+
+	// Step 6.78: Capitalize asset-like expense lines (computer, machinery, ... ≥ shop threshold)
+	if rule, enabled := fixedAssetRule(masterCache); enabled && hasEntry {
+		result := processor.ApplyFixedAssetRules(accountingEntry, accounts, rule)
+		if len(result.Lines) > 0 {
+			analysis.FixedAssets = &result
+			for _, line := range result.Lines {
+				reqCtx.LogInfo("🏭 Fixed asset '%s' ฿%s: %s %s → %s %s", line.MatchedKeyword, line.Amount,
+					line.FromAccountCode, line.FromAccountName, line.ToAccountCode, line.ToAccountName)
+			}
+		}
+	}
+
+	// Step 6.8: Deterministic journal book selection (per-shop rules override the AI's choice)
+	if len(masterCache.JournalBookRules) > 0 {
+		selection := processor.ApplyJournalBookRules(accountingResponse, masterCache.JournalBookRules, masterCache.JournalBooks)
+		if hasEntry {
+			accountingEntry["journal_book_selection"] = selection
+		}
+		if selection.Source == "rule" {
+			reqCtx.LogInfo("📚 Journal book rule '%s' fired: %s → %s (%s, vat=%v, %s)",
+				selection.RuleName, selection.AIJournalBook, selection.JournalBookCode,
+				selection.Facts.DocumentType, selection.Facts.HasVAT, selection.Facts.Direction)
+		}
+	}
+
+	// Step 6.9: AI-chosen account codes must exist in the chart of accounts (suggest replacements if not)
+	if hasEntry {
+		analysis.AccountChecks = processor.CheckEntryAccounts(accountingEntry, accounts)
+		for _, check := range analysis.AccountChecks {
+			suggested := "-"
+			if len(check.Suggestions) > 0 {
+				suggested = check.Suggestions[0].AccountCode + " " + check.Suggestions[0].AccountName
+			}
+			reqCtx.LogWarning("⚠️  AI ใช้บัญชี '%s %s' ที่ไม่มีในผังบัญชี → แนะนำ: %s", check.AccountCode, check.AccountName, suggested)
+		}
+	}
+}
+
+// Validate checks the accounting entry (balance, parties, VAT, sequence), scores the confidence and
+// collects every reason the document needs review into Validation.Data
+// Returns errSelfInvoiceRejected (with the validation) when the document is the shop's own invoice and SELF_INVOICE_ACTION=reject
+func (s *AnalyzeService) Validate(ctx context.Context, in *AnalysisInput, analysis *Analysis, reqCtx *common.RequestContext) (*Validation, error) {
+	masterCache := in.MasterCache
+	accountingResponse := analysis.Response
+	accounts := analysis.Accounts
+	vendorMatchResult := analysis.Vendor
+	validation := &Validation{}
+
+	// Step 7: Validate double-entry balance (+ suggested rounding fix when off by a few satang)
+	var balanceFix *processor.BalanceCorrection
+	if accountingEntry, ok := accountingResponse["accounting_entry"].(map[string]interface{}); ok {
+		if _, ok := accountingEntry["entries"].([]interface{}); ok && !setBalanceCheck(accountingEntry) {
+			receiptSection, _ := accountingResponse["receipt"].(map[string]interface{})
+			balanceFix = balanceCorrection(accountingEntry, receiptSection, masterCache, accounts)
+			if balanceFix != nil {
+				reqCtx.LogInfo("🧮 Balance correction: %s", balanceFix.Message)
+			}
+		}
+	}
+
+	// Step 7.5: Fill creditor/debtor info from multiple sources
+	accountingEntry, ok := accountingResponse["accounting_entry"].(map[string]interface{})
+	if !ok {
+		accountingEntry = map[string]interface{}{}
+	}
+	fillAccountingParties(accountingEntry, accountingResponse, vendorMatchResult, reqCtx)
+
+	// Step 7.52: Creditor/debtor chosen by the AI vs the direction detected from the shop profile
+	documentDirectionCheck := directionCheck(analysis.Direction, accountingEntry, reqCtx)
+
+	// Step 7.53: Shop's own tax invoice booked as a purchase (vendor_tax_id = shop's tax ID)
+	receiptSection, _ := accountingResponse["receipt"].(map[string]interface{})
+	validation.SelfInvoice = selfInvoiceCheck(receiptSection, accountingEntry, masterCache, reqCtx)
+	if validation.SelfInvoice != nil && configs.Get().SelfInvoiceAction == "reject" {
+		return validation, errSelfInvoiceRejected
+	}
+
+	// Step 7.54: VAT read from the document vs the VAT rate in force on the document date
+	vatCheck := vatMathCheck(receiptSection, accountingEntry, reqCtx.Settings, reqCtx)
+
+	// Step 7.55: Learned creditor → account mapping (user-approved account for this creditor)
+	if mapping, ok := masterCache.VendorAccountMappings[getStringValue(accountingEntry, "creditor_code")]; ok && !in.Request.PettyCash {
+		result := processor.ApplyLearnedMapping(accountingEntry, mapping, in.TemplateMatch.Mode == ai.TemplateOnlyMode)
+		validation.LearnedMapping = &result
+		reqCtx.LogInfo("📌 Learned mapping %s → %s (used=%v): %s", result.CreditorCode, result.AccountCode, result.Used, result.Reason)
+	}
+
+	// Step 7.56: Dimensions (project / cost center) of each journal line - keyword rules → AI → default
+	dimensions := assignDimensions(accountingEntry, analysis.CombinedText, reqCtx.Settings, masterCache, reqCtx)
+
+	// Step 7.6: Calculate weighted confidence score (replaces the AI's confidence)
+	reqCtx.StartStep("calculate_confidence")
+	templateMatchResult := in.TemplateMatch.Result
+	validation.Confidence = processor.CalculateWeightedConfidence(&templateMatchResult, &vendorMatchResult, accountingEntry, reqCtx)
+	validationData := confidenceValidation(validation.Confidence, accountingEntry)
+	mergeAIValidation(validationData, accountingResponse, vendorMatchResult)
+	accountingResponse["validation"] = validationData
+	validation.Data = validationData
+	reqCtx.EndStep("success", nil, nil)
+
+	// Step 7.7: Two-pass verification of critical fields (optional)
+	// Re-read total/VAT/date/tax ID with a targeted prompt - disagreement lowers confidence and forces review
+	var fieldVerification *processor.FieldVerificationResult
+	if configs.Get().EnableFieldVerification || in.Request.VerifyFields {
+		reqCtx.StartStep("verify_critical_fields")
+		imagePaths := make([]string, 0, len(in.Images))
+		for _, img := range in.Images {
+			imagePaths = append(imagePaths, img.Filename)
+		}
+
+		verified, verifyTokens, err := s.Accounting.VerifyCriticalFields(ctx, imagePaths, reqCtx)
+		if err != nil {
+			// Optional pass - keep the result from pass 1
+			reqCtx.LogWarning("⚠️  Critical field verification failed: %v", err)
+			fieldVerification = &processor.FieldVerificationResult{Status: "failed", Error: err.Error()}
+			reqCtx.EndStep("failed", verifyTokens, err)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		} else {
+			result := processor.CompareCriticalFields(receiptSection, *verified)
+			fieldVerification = &result
+			if len(result.Disagreements) > 0 {
+				reqCtx.LogWarning("🔎 Field verification: %d field(s) disagree → confidence -%.0f", len(result.Disagreements), result.ConfidencePenalty)
+			} else {
+				reqCtx.LogInfo("🔎 Field verification: %d field(s) agree", len(result.CheckedFields))
+			}
+			reqCtx.EndStep("success", verifyTokens, nil)
+		}
+	}
+
+	// Step 7.8: Separate documents in one request → one accounting entry per document
+	validation.EntryGroups = buildAccountingEntryGroups(accountingResponse, masterCache, accounts, &templateMatchResult, &vendorMatchResult, reqCtx.Settings)
+	if len(validation.EntryGroups) > 0 {
+		reqCtx.LogInfo("🗂️  Separate documents: %d accounting entries", len(validation.EntryGroups))
+	}
+
+	// Step 7.85: Petty cash batch → one voucher (debit lines of every receipt + one credit to petty cash)
+	if in.Request.PettyCash {
+		validation.PettyCash = buildPettyCash(in, analysis, validation.EntryGroups, reqCtx)
+	}
+
+	// Step 8: Validate creditor/debtor codes and template usage of the final entry
+	// Re-extract accountingEntry after confidence calculation
+	if ae, ok := accountingResponse["accounting_entry"].(map[string]interface{}); ok {
+		accountingEntry = ae
+		checkEntryParties(accountingEntry, masterCache, in.TemplateMatch.Template, reqCtx)
+	} else {
+		accountingEntry = map[string]interface{}{}
+	}
+	validation.AccountingEntry = accountingEntry
+
+	// Get primary receipt data from accounting response (Pure OCR doesn't extract structured data)
+	receiptData, ok := accountingResponse["receipt"].(map[string]interface{})
+	if !ok {
+		// Pure OCR only has raw text, so accounting response should provide structured data
+		// If missing, use minimal fallback
+		receiptData = gin.H{
+			"number":        "N/A",
+			"date":          "N/A",
+			"vendor_name":   "N/A", // All info comes from Phase 3 accounting analysis
+			"vendor_tax_id": "N/A",
+			"total":         0,
+			"vat":           0,
+		}
+	}
+	validation.Receipt = receiptData
+
+	// Step 8.5: Branch of the issuer (สาขาที่ 00123 / สำนักงานใหญ่) attributed to the creditor for VAT reporting
+	applyCreditorBranch(reqCtx, receiptData, accountingEntry, analysis.CombinedText, masterCache, true)
+
+	// Priority 1: Add fields_requiring_review array
+	fieldsRequiringReview := []string{}
+	if vendorName, ok := receiptData["vendor_name"].(string); ok && (vendorName == "Unknown Vendor" || vendorName == "N/A" || vendorName == "") {
+		fieldsRequiringReview = append(fieldsRequiringReview, "vendor_name")
+	}
+	if vendorTaxID, ok := receiptData["vendor_tax_id"].(string); ok && (vendorTaxID == "Unknown Vendor" || vendorTaxID == "N/A" || vendorTaxID == "") {
+		fieldsRequiringReview = append(fieldsRequiringReview, "vendor_tax_id")
+	}
+	if len(fieldsRequiringReview) > 0 {
+		validationData["fields_requiring_review"] = fieldsRequiringReview
+		validationData["requires_review"] = true
+	}
+
+	// Priority 2: Fields where the verification pass disagreed with pass 1
+	if fieldVerification != nil {
+		processor.ApplyFieldVerification(validationData, *fieldVerification)
+	}
+
+	// Priority 3: Total replaced by the QR amount - journal entries were built from the OCR total
+	for _, o := range analysis.QROverrides {
+		if o.Field == "total" {
+			validationData["requires_review"] = true
+			reqCtx.LogWarning("⚠️  Receipt total corrected from QR - review journal entry amounts")
+		}
+	}
+
+	// Priority 4: Slip not found at the bank / amount differs from the document
+	for _, r := range analysis.SlipResults {
+		if r.NeedsReview() {
+			validationData["requires_review"] = true
+		}
+	}
+
+	// Priority 5: VAT entries adjusted to the shop's VAT registration
+	if analysis.VATEnforcement != nil {
+		validationData["vat_enforcement"] = *analysis.VATEnforcement
+		if analysis.VATEnforcement.RequiresReview() {
+			validationData["requires_review"] = true
+		}
+	}
+
+	// Priority 6: Handwritten documents always require review
+	if in.Handwritten {
+		processor.ApplyHandwritingReview(validationData)
+	}
+
+	// Priority 7: Account codes not found in the chart of accounts
+	if len(analysis.AccountChecks) > 0 {
+		validationData["account_checks"] = analysis.AccountChecks
+		validationData["requires_review"] = true
+	}
+
+	// Priority 8: Any separate document that needs review
+	for _, group := range validation.EntryGroups {
+		if group.RequiresReview {
+			validationData["requires_review"] = true
+		}
+	}
+
+	// Priority 9: A document found by clustering could not be analyzed
+	if analysis.ClusterFailures > 0 {
+		validationData["requires_review"] = true
+	}
+
+	// Priority 10: Credit/debit note without the original invoice number
+	if analysis.AdjustmentNote != nil {
+		validationData["adjustment_note"] = *analysis.AdjustmentNote
+		if analysis.AdjustmentNote.NeedsReview {
+			validationData["requires_review"] = true
+		}
+	}
+
+	// Priority 11: Petty cash voucher with a receipt to check or no petty cash account
+	if validation.PettyCash != nil && validation.PettyCash.RequiresReview {
+		validationData["requires_review"] = true
+	}
+
+	// Priority 12: Expense lines capitalized as fixed assets
+	if analysis.FixedAssets != nil {
+		validationData["fixed_assets"] = *analysis.FixedAssets
+		validationData["requires_review"] = true
+	}
+
+	// Priority 13: Invoice number already analyzed for the creditor (gaps are only reported)
+	if sequenceAnomalies := checkDocumentSequence(ctx, s.Results, reqCtx, receiptData, accountingEntry); len(sequenceAnomalies) > 0 {
+		validationData["document_sequence"] = sequenceAnomalies
+		for _, anomaly := range sequenceAnomalies {
+			if anomaly.Type == processor.SequenceAnomalyDuplicate {
+				validationData["requires_review"] = true
+			}
+		}
+	}
+
+	// Priority 14: Creditor/debtor contradicts the document direction (e.g. the shop's own invoice booked as a purchase)
+	if documentDirectionCheck != nil {
+		validationData["direction_check"] = *documentDirectionCheck
+		if !documentDirectionCheck.Agreed {
+			validationData["requires_review"] = true
+		}
+	}
+
+	// Priority 15: The shop's own tax invoice (vendor = shop) - creditor removed, sales document
+	if validation.SelfInvoice != nil {
+		validationData["self_invoice"] = *validation.SelfInvoice
+		validationData["requires_review"] = true
+	}
+
+	// Priority 16: Unbalanced by a few satang - suggested fix (auto-applied fixes are only reported)
+	if balanceFix != nil {
+		validationData["balance_correction"] = *balanceFix
+		if !balanceFix.Applied {
+			validationData["requires_review"] = true
+		}
+	}
+
+	// Priority 17: VAT does not match the VAT rate (misread amount or a different rate)
+	if vatCheck != nil {
+		validationData["vat_check"] = *vatCheck
+		if !vatCheck.Agreed {
+			validationData["requires_review"] = true
+		}
+	}
+
+	// Priority 18: Revenue/expense line split by VAT base of a mixed-VAT document (informational)
+	if analysis.VATSplit != nil {
+		validationData["vat_split"] = *analysis.VATSplit
+	}
+
+	// Priority 19: Foreign document - amounts in another currency must be converted to baht
+	if analysis.ForeignDocument != nil {
+		validationData["foreign_document"] = *analysis.ForeignDocument
+		if analysis.ForeignDocument.RequiresReview() {
+			validationData["requires_review"] = true
+		}
+	}
+
+	// Priority 20: Dimension codes not in the shop's dimensions, or a required dimension without a value
+	if dimensions != nil {
+		validationData["dimensions"] = *dimensions
+		if dimensions.RequiresReview() {
+			validationData["requires_review"] = true
+		}
+	}
+
+	// Priority 21: Template formula failed or used a field the document does not have (computed amounts are only reported)
+	if analysis.TemplateFormulas != nil {
+		validationData["template_formulas"] = *analysis.TemplateFormulas
+		if analysis.TemplateFormulas.RequiresReview() {
+			validationData["requires_review"] = true
+		}
+	}
+	return validation, nil
+}
+
+// fillAccountingParties sets creditor/debtor of the entry: pre-matched vendor first, then the AI's choice (Step 7.5)
+func fillAccountingParties(accountingEntry, accountingResponse map[string]interface{}, vendorMatchResult processor.VendorMatchResult, reqCtx *common.RequestContext) {
+	// Priority 1: Pre-matched vendor from Backend (vendor_pre_matching) - debtor of a sales document
+	if vendorMatchResult.Found && vendorMatchResult.Party == processor.PartyDebtor {
+		accountingEntry["debtor_code"] = vendorMatchResult.Code
+		accountingEntry["debtor_name"] = vendorMatchResult.Name
+		reqCtx.LogInfo("✅ Auto-filled debtor from vendor_pre_matching: %s (code: %s)",
+			vendorMatchResult.Name, vendorMatchResult.Code)
+		return
+	}
+	if vendorMatchResult.Found {
+		accountingEntry["creditor_code"] = vendorMatchResult.Code
+		accountingEntry["creditor_name"] = vendorMatchResult.Name
+		reqCtx.LogInfo("✅ Auto-filled creditor from vendor_pre_matching: %s (code: %s)",
+			vendorMatchResult.Name, vendorMatchResult.Code)
+		return
+	}
+
+	// Priority 2: AI-matched creditor from Phase 3 (from creditor/debtor objects)
+	if creditorObj, ok := accountingResponse["creditor"].(map[string]interface{}); ok {
+		if code := getStringValue(creditorObj, "creditor_code"); code != "" {
+			accountingEntry["creditor_code"] = code
+			accountingEntry["creditor_name"] = getStringValue(creditorObj, "creditor_name")
+			reqCtx.LogInfo("✅ Auto-filled creditor from AI Phase 3: %s (code: %s)",
+				accountingEntry["creditor_name"], code)
+		}
+	}
+	if debtorObj, ok := accountingResponse["debtor"].(map[string]interface{}); ok {
+		if code := getStringValue(debtorObj, "debtor_code"); code != "" {
+			accountingEntry["debtor_code"] = code
+			accountingEntry["debtor_name"] = getStringValue(debtorObj, "debtor_name")
+			reqCtx.LogInfo("✅ Auto-filled debtor from AI Phase 3: %s (code: %s)",
+				accountingEntry["debtor_name"], code)
+		}
+	}
+}
+
+// confidenceValidation is the validation section built from the weighted confidence (Step 7.6)
+func confidenceValidation(confidenceResult processor.ConfidenceResult, accountingEntry map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"confidence": map[string]interface{}{
+			"level": confidenceResult.OverallLevel,
+			"score": confidenceResult.OverallScore,
+		},
+		"requires_review": confidenceResult.RequiresReview,
+		"confidence_breakdown": map[string]interface{}{
+			"factors": map[string]interface{}{
+				"template_match":     confidenceResult.Factors.TemplateMatch,
+				"party_match":        confidenceResult.Factors.PartyMatch,
+				"data_completeness":  confidenceResult.Factors.DataCompleteness,
+				"field_validation":   confidenceResult.Factors.FieldValidation,
+				"balance_validation": confidenceResult.Factors.BalanceValidation,
+			},
+			"explanations": confidenceResult.Breakdown,
+			"weights": map[string]interface{}{
+				"template_match":     processor.DefaultWeights.TemplateMatch * 100,
+				"party_match":        processor.DefaultWeights.PartyMatch * 100,
+				"data_completeness":  processor.DefaultWeights.DataCompleteness * 100,
+				"field_validation":   processor.DefaultWeights.FieldValidation * 100,
+				"balance_validation": processor.DefaultWeights.BalanceValidation * 100,
+			},
+			"calculation": map[string]interface{}{
+				"formula": "(เทมเพลต×30%) + (คู่ค้า×25%) + (ข้อมูล×20%) + (ฟิลด์×15%) + (ยอดเงิน×10%)",
+				"steps": []string{
+					fmt.Sprintf("เทมเพลต: %.0f × 30%% = %.1f", confidenceResult.Factors.TemplateMatch, confidenceResult.Factors.TemplateMatch*0.3),
+					fmt.Sprintf("คู่ค้า: %.0f × 25%% = %.1f", confidenceResult.Factors.PartyMatch, confidenceResult.Factors.PartyMatch*0.25),
+					fmt.Sprintf("ข้อมูล: %.0f × 20%% = %.1f", confidenceResult.Factors.DataCompleteness, confidenceResult.Factors.DataCompleteness*0.2),
+					fmt.Sprintf("ฟิลด์: %.0f × 15%% = %.1f", confidenceResult.Factors.FieldValidation, confidenceResult.Factors.FieldValidation*0.15),
+					fmt.Sprintf("ยอดเงิน: %.0f × 10%% = %.1f", confidenceResult.Factors.BalanceValidation, confidenceResult.Factors.BalanceValidation*0.1),
+				},
+				"total": confidenceResult.OverallScore,
+			},
+		},
+		"review_requirements": generateReviewRequirements(confidenceResult, accountingEntry),
+	}
+}
+
+// mergeAIValidation keeps the AI's explanation (vendor matching replaced by the backend's result)
+func mergeAIValidation(validationData, accountingResponse map[string]interface{}, vendorMatchResult processor.VendorMatchResult) {
+	existingValidation, ok := accountingResponse["validation"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	// Keep AI's explanation but override confidence and requires_review
+	validationData["ai_explanation"] = existingValidation["ai_explanation"]
+	validationData["processing_notes"] = existingValidation["processing_notes"]
+	validationData["fields_requiring_review"] = existingValidation["fields_requiring_review"]
+
+	// Override AI's vendor_matching with Backend's result
+	aiExplanation, ok := existingValidation["ai_explanation"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if vendorMatchResult.Found {
+		aiExplanation["vendor_matching"] = map[string]interface{}{
+			"found_in_document": vendorMatchResult.Name,
+			"matched_with":      vendorMatchResult.Code + " - " + vendorMatchResult.Name,
+			"matching_method":   vendorMatchResult.Method,
+			"party":             vendorMatchResult.Party,
+			"confidence":        vendorMatchResult.Similarity,
+			"reason":            fmt.Sprintf("ระบบจับคู่ vendor สำเร็จด้วยวิธี %s (ความแม่นยำ %.1f%%)", vendorMatchResult.Method, vendorMatchResult.Similarity),
+		}
+	}
+	// Ranked alternatives (also when not found) - review UIs can pick one and reanalyze with creditor_code
+	if candidates := vendorMatchResult.TopCandidates(vendorCandidatesInResponse); len(candidates) > 0 {
+		if vendorMatching, ok := aiExplanation["vendor_matching"].(map[string]interface{}); ok {
+			vendorMatching["candidates"] = candidates
+		} else {
+			aiExplanation["vendor_matching"] = map[string]interface{}{"candidates": candidates}
+		}
+	}
+	validationData["ai_explanation"] = aiExplanation
+}
+
+// buildPettyCash replaces the entry lines with one voucher of every receipt of the batch (Step 7.85)
+func buildPettyCash(in *AnalysisInput, analysis *Analysis, entryGroups []AccountingEntryGroup, reqCtx *common.RequestContext) *processor.PettyCashVoucher {
+	primaryEntry, ok := analysis.Response["accounting_entry"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var receipts []processor.PettyCashReceipt
+	for _, group := range entryGroups {
+		receipts = append(receipts, processor.PettyCashReceipt{
+			ImageIndices:    group.ImageIndices,
+			Receipt:         group.Receipt,
+			AccountingEntry: group.AccountingEntry,
+			RequiresReview:  group.RequiresReview,
+		})
+	}
+	if len(receipts) == 0 {
+		// Single receipt (or clustering not possible) - the primary entry is the only receipt
+		receiptSection, _ := analysis.Response["receipt"].(map[string]interface{})
+		receipts = append(receipts, processor.PettyCashReceipt{
+			ImageIndices:    []int{},
+			Receipt:         receiptSection,
+			AccountingEntry: primaryEntry,
+		})
+		for _, img := range in.Images {
+			receipts[0].ImageIndices = append(receipts[0].ImageIndices, img.Index)
+		}
+	}
+
+	configuredCode := ""
+	if in.MasterCache.ShopProfile != nil {
+		configuredCode = in.MasterCache.ShopProfile.Settings.PettyCashAccountCode
+	}
+	pettyCashCode, pettyCashName := processor.FindPettyCashAccount(analysis.Accounts, configuredCode)
+	voucher, lines := processor.BuildPettyCashVoucher(receipts, pettyCashCode, pettyCashName)
+
+	primaryEntry["entries"] = lines
+	setBalanceCheck(primaryEntry)
+	if len(receipts) > 1 {
+		// One voucher for many vendors - no single creditor
+		primaryEntry["creditor_code"] = ""
+		primaryEntry["creditor_name"] = ""
+	}
+	reqCtx.LogInfo("💵 Petty cash voucher: %d receipts, ฿%s → %s %s", voucher.ReceiptCount, voucher.TotalAmount, pettyCashCode, pettyCashName)
+	return &voucher
+}
+
+// checkEntryParties clears creditor/debtor codes that are not in the master data and warns about unused template accounts (Step 8)
+func checkEntryParties(accountingEntry map[string]interface{}, masterCache *storage.MasterDataCache, matchedTemplate *bson.M, reqCtx *common.RequestContext) {
+	// 🔥 CRITICAL: Validate creditor/debtor codes against master data
+	if creditorCode := getStringValue(accountingEntry, "creditor_code"); creditorCode != "" && !hasPartyCode(masterCache.Creditors, creditorCode) {
+		reqCtx.LogWarning("⚠️  AI ส่ง creditor_code '%s' ที่ไม่มีในฐานข้อมูล → เปลี่ยนเป็น Unknown", creditorCode)
+		accountingEntry["creditor_code"] = ""
+		accountingEntry["creditor_name"] = ""
+	}
+	if debtorCode := getStringValue(accountingEntry, "debtor_code"); debtorCode != "" && !hasPartyCode(masterCache.Debtors, debtorCode) {
+		reqCtx.LogWarning("⚠️  AI ส่ง debtor_code '%s' ที่ไม่มีในฐานข้อมูล → เปลี่ยนเป็น Unknown", debtorCode)
+		accountingEntry["debtor_code"] = ""
+		accountingEntry["debtor_name"] = ""
+	}
+
+	// 🔥 CRITICAL: Validate template usage - check if all accounts are used
+	if matchedTemplate != nil {
+		if details, ok := (*matchedTemplate)["details"].(bson.A); ok && len(details) > 0 {
+			entriesRaw, _ := accountingEntry["entries"].([]interface{})
+			if len(entriesRaw) < len(details) {
+				reqCtx.LogWarning("⚠️  Template has %d accounts but AI only used %d → Missing accounts!", len(details), len(entriesRaw))
+			}
+		}
+	}
+}
+
+// hasPartyCode reports whether a creditor / debtor with code exists
+func hasPartyCode(parties []bson.M, code string) bool {
+	for _, party := range parties {
+		if partyCode, ok := party["code"].(string); ok && partyCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Compose builds the analyze-receipt response and the analytics record of the document
+// Nothing is stored here - the caller records the analytics and the OCR summary
+func (s *AnalyzeService) Compose(in *AnalysisInput, analysis *Analysis, validation *Validation, reqCtx *common.RequestContext) (gin.H, storage.DocumentAnalyticsRecord) {
+	accountingResponse := analysis.Response
+	matchedTemplate := in.TemplateMatch.Template
+	summary := reqCtx.GetSummary()
+
+	// Extract document analysis if available
+	documentAnalysis, ok := accountingResponse["document_analysis"].(map[string]interface{})
+	if !ok {
+		// Default analysis for single image
+		documentAnalysis = map[string]interface{}{
+			"total_images": len(in.Images),
+			"relationship": "single_document",
+			"confidence":   95,
+		}
+	}
+
+	// QR codes / barcodes found on the images and the receipt fields they replaced
+	if len(in.DecodedCodes) > 0 {
+		documentAnalysis["qr_codes"] = in.DecodedCodes
+		if len(analysis.QROverrides) > 0 {
+			documentAnalysis["qr_overrides"] = analysis.QROverrides
+		}
+	}
+	if len(analysis.SlipResults) > 0 {
+		documentAnalysis["slip_verification"] = analysis.SlipResults
+	}
+	if len(validation.EntryGroups) > 0 {
+		documentAnalysis["document_groups"] = len(validation.EntryGroups)
+	}
+	if in.Stitch.Stitched {
+		documentAnalysis["stitching"] = in.Stitch
+	}
+	if len(in.Clusters) > 1 {
+		documentAnalysis["clusters"] = in.Clusters
+		documentAnalysis["cluster_failures"] = analysis.ClusterFailures
+	}
+
+	// Extract source images info if available
+	sourceImages, _ := accountingResponse["source_images"].([]interface{})
+
+	// Extract template information (which template AI used and why)
+	templateInfo := processor.ExtractTemplateInfo(accountingResponse, in.Templates, matchedTemplate, reqCtx)
+	templateInfo["learned_mapping_used"] = validation.LearnedMapping != nil && validation.LearnedMapping.Used
+	if validation.LearnedMapping != nil {
+		templateInfo["learned_mapping"] = *validation.LearnedMapping
+	}
+
+	response := gin.H{
+		"shopid": in.Request.ShopID,
+		"status": "success",
+
+		// NEW: Document analysis showing relationship between images
+		"document_analysis": documentAnalysis,
+
+		// Essential: Receipt information (merged/primary)
+		"receipt": validation.Receipt,
+
+		// Essential: Accounting entry (merged from all images)
+		"accounting_entry": validation.AccountingEntry,
+
+		// Essential: Validation summary
+		"validation": validation.Data,
+
+		// NEW: Template information - shows which template AI selected and why
+		"template_info": templateInfo,
+
+		// NEW: Custom prompts used for AI analysis
+		"custom_prompts": gin.H{
+			"shop_context":      extractShopContextForResponse(in.MasterCache.ShopProfile),
+			"template_guidance": extractTemplateGuidanceForResponse(matchedTemplate),
+		},
+
+		// NEW: Source images metadata
+		"source_images": sourceImages,
+
+		// Metadata: For tracking and debugging (includes OCR warnings if any)
+		"metadata": analyzeMetadata(in, analysis, summary, reqCtx),
+
+		// Note: IMPORTANT - Always verify request_id matches your request log!
+		// If IDs don't match, this might be a cached/wrong response.
+	}
+
+	// Separate documents: accounting_entry stays the first document, accounting_entries has all of them
+	if len(validation.EntryGroups) > 0 {
+		response["accounting_entries"] = validation.EntryGroups
+	}
+
+	// Petty cash batch: accounting_entry is the consolidated voucher, petty_cash has the per-receipt breakdown
+	if validation.PettyCash != nil {
+		response["petty_cash"] = validation.PettyCash
+	}
+
+	// OCR text per image (include_raw_text=true)
+	if in.RawDocumentTexts != nil {
+		response["raw_document_texts"] = in.RawDocumentTexts
+	}
+
+	// Debug mode: pure OCR extraction data (raw text only) + template match
+	if in.Debug {
+		ocrDebugData := []map[string]interface{}{}
+		for i, ocrResult := range in.OCRResults {
+			if ocrResult.Result != nil {
+				ocrDebugData = append(ocrDebugData, map[string]interface{}{
+					"image_index": i,
+					"ocr_result":  ocrResult.Result,
+				})
+			}
+		}
+		response["debug_data"] = map[string]interface{}{
+			"pure_ocr_results": ocrDebugData,
+			"note":             "Debug mode enabled - showing pure OCR extraction data (raw text only)",
+			"template_match":   in.TemplateMatch.Result,
+		}
+	}
+
+	// Filter out internal fields from ai_explanation before sending response
+	if aiExplanation, ok := validation.Data["ai_explanation"].(map[string]interface{}); ok {
+		// Remove evidence_from_receipt (ซ้ำกับ receipt{})
+		delete(aiExplanation, "evidence_from_receipt")
+
+		// Keep account_selection_logic but remove redundant fields
+		if accountSelectionLogic, ok := aiExplanation["account_selection_logic"].(map[string]interface{}); ok {
+			// Keep only template_used and template_details for user reference
+			// Remove debit_accounts/credit_accounts (ซ้ำกับ entries[] 100%)
+			delete(accountSelectionLogic, "debit_accounts")
+			delete(accountSelectionLogic, "credit_accounts")
+			delete(accountSelectionLogic, "verification")
+		}
+	}
+
+	// Template matching outcome for coverage reports and template suggestions
+	analysisMode := storage.AnalysisModeFull
+	if in.TemplateMatch.Mode == ai.TemplateOnlyMode {
+		analysisMode = storage.AnalysisModeTemplateOnly
+	}
+	templateMatchResult := in.TemplateMatch.Result
+	analyticsRecord := storage.DocumentAnalyticsRecord{
+		Mode:               analysisMode,
+		TemplateConfidence: templateMatchResult.Confidence,
+		OverallConfidence:  validation.Confidence.OverallScore,
+		DocumentType:       processor.BuildJournalBookFacts(accountingResponse).DocumentType,
+		VendorName:         getStringValue(validation.Receipt, "vendor_name"),
+		VendorTaxID:        getStringValue(validation.Receipt, "vendor_tax_id"),
+		Entries:            analyticsEntries(validation.AccountingEntry),
+	}
+	analyticsRecord.VendorKey = analyticsVendorKey(analyticsRecord.VendorName, analyticsRecord.VendorTaxID)
+	if templateMatchResult.Template != nil {
+		analyticsRecord.TemplateID = templateIDString(templateMatchResult.TemplateID)
+		analyticsRecord.TemplateName = templateMatchResult.Description
+	}
+	analyticsRecord.RequiresReview, _ = validation.Data["requires_review"].(bool)
+	return response, analyticsRecord
+}
+
+// analyzeMetadata is the metadata section of the response (usage, settings, warnings of every stage)
+func analyzeMetadata(in *AnalysisInput, analysis *Analysis, summary map[string]interface{}, reqCtx *common.RequestContext) gin.H {
+	totalPureOCRTokens := in.OCRTokens
+	tokenUsage := summary["token_usage"].(map[string]interface{})
+
+	// Separate Mistral OCR usage from Gemini AI processing
+	metadata := gin.H{
+		"request_id":       reqCtx.RequestID,
+		"processed_at":     time.Now().Format(time.RFC3339),
+		"duration_sec":     summary["total_duration_sec"],
+		"images_processed": len(in.Images),
+	}
+
+	if in.OCRProvider == "mistral" {
+		// Mistral: Show separate OCR and AI processing costs
+		metadata["ocr_provider"] = "mistral"
+		metadata["token_usage"] = gin.H{
+			"ocr_usage": gin.H{
+				"provider":        "mistral",
+				"pages_processed": totalPureOCRTokens.InputTokens, // pages stored as input_tokens
+				"cost_thb":        fmt.Sprintf("฿%.2f", totalPureOCRTokens.CostTHB),
+				"cost_usd":        fmt.Sprintf("$%.6f", totalPureOCRTokens.CostUSD),
+			},
+			"ai_processing": gin.H{
+				"provider":      "gemini",
+				"input_tokens":  tokenUsage["input_tokens"].(int) - totalPureOCRTokens.InputTokens,
+				"output_tokens": tokenUsage["output_tokens"],
+				"total_tokens":  tokenUsage["total_tokens"],
+				"cost_thb":      fmt.Sprintf("฿%.2f", reqCtx.TotalTokens.CostTHB-totalPureOCRTokens.CostTHB),
+			},
+			"total": gin.H{
+				"cost_thb": tokenUsage["cost_thb"],
+				"cost_usd": tokenUsage["cost_usd"],
+			},
+		}
+	} else {
+		// Gemini: Show combined usage (traditional format)
+		metadata["ocr_provider"] = "gemini"
+		metadata["token_usage"] = gin.H{
+			"input_tokens":  tokenUsage["input_tokens"],
+			"output_tokens": tokenUsage["output_tokens"],
+			"total_tokens":  tokenUsage["total_tokens"],
+			"cost_thb":      tokenUsage["cost_thb"],
+		}
+	}
+	// Projected vs actual cost per phase (always reported, budget or not)
+	metadata["cost_breakdown"] = reqCtx.GetCostBreakdown()
+	// Per-step timings (sub-steps, parallel tracks) and tokens / cost - where time and money went
+	metadata["steps"] = reqCtx.GetSteps()
+	// Models / thresholds actually used (global config + shop overrides)
+	metadata["settings"] = reqCtx.Settings
+	if reqCtx.Priority != "" {
+		metadata["priority"] = reqCtx.Priority
+	}
+	// Estimated vs allowed processing time (max_processing_seconds) - warning level = close to the limit
+	metadata["complexity"] = in.Complexity
+	if in.RequestedModel != "" && in.RequestedModel != in.Request.Model {
+		metadata["ocr_provider_requested"] = in.RequestedModel
+	}
+	// Phases that ran out of their own deadline (OCR image failed / template match fell back to full mode)
+	if phaseTimeouts := reqCtx.PhaseTimeouts(); len(phaseTimeouts) > 0 {
+		metadata["phase_timeouts"] = phaseTimeouts
+	}
+	// Chart of accounts narrowed for the Phase 3 prompt (full mode)
+	if analysis.AccountPrefilter != nil && analysis.AccountPrefilter.Applied {
+		metadata["account_prefilter"] = analysis.AccountPrefilter
+	}
+	// Creditor list narrowed for the Phase 3 prompt
+	if analysis.CreditorPrefilter != nil && analysis.CreditorPrefilter.Applied {
+		metadata["creditor_prefilter"] = analysis.CreditorPrefilter
+	}
+	// Images shrunk by the payload guard before Gemini calls
+	if imageReductions := reqCtx.ImageReductions(); len(imageReductions) > 0 {
+		metadata["image_reductions"] = imageReductions
+	}
+	// Malware scans of the downloaded files (FILE_SCAN_DRIVER)
+	if fileScans := reqCtx.FileScans(); len(fileScans) > 0 {
+		metadata["file_scans"] = fileScans
+	}
+	if sanitizations := reqCtx.ImageSanitizations(); len(sanitizations) > 0 {
+		metadata["image_sanitization"] = sanitizations
+	}
+
+	// Add OCR warnings if any issues were detected
+	if ocrWarnings := ocrWarnings(in.OCRResults); len(ocrWarnings) > 0 {
+		metadata["ocr_warnings"] = ocrWarnings
+	}
+
+	// Handwritten receipt mode - which images were detected and why
+	if in.Handwritten {
+		metadata["handwritten"] = true
+		metadata["handwriting"] = in.HandwritingSignals
+	}
+	return metadata
+}
+
+// ocrWarnings collects partial / fallback / failed OCR of the processed images
+func ocrWarnings(ocrResults []PureOCRImageResult) []gin.H {
+	var warnings []gin.H
+	for i, ocrResult := range ocrResults {
+		// Case 1: OCR succeeded with warnings
+		if ocrResult.Result != nil && (ocrResult.Result.IsPartial || ocrResult.Result.FallbackUsed || ocrResult.Result.Warning != "") {
+			warning := gin.H{
+				"image_index": i,
+			}
+			if ocrResult.Result.IsPartial {
+				warning["is_partial"] = true
+			}
+			if ocrResult.Result.FallbackUsed {
+				warning["fallback_used"] = true
+			}
+			if ocrResult.Result.Warning != "" {
+				warning["warning"] = ocrResult.Result.Warning
+			}
+			if ocrResult.Result.TextLength > 0 {
+				warning["text_length"] = ocrResult.Result.TextLength
+			}
+			warnings = append(warnings, warning)
+		} else if ocrResult.Error != nil {
+			// Case 2: OCR failed completely
+			warnings = append(warnings, gin.H{
+				"image_index": i,
+				"error":       "OCR extraction failed",
+				"details":     ocrResult.Error.Error(),
+			})
+		}
+	}
+	return warnings
+}
+
+// aiAccountant uses the Gemini accounting analysis and field verification of the ai package
+type aiAccountant struct{}
+
+func (aiAccountant) Analyze(ctx context.Context, in AccountingInput, reqCtx *common.RequestContext) (string, *common.TokenUsage, error) {
+	return ai.ProcessMultiImageAccountingAnalysis(ctx, in.Images, in.OCRResults, in.Mode, in.Template,
+		in.Accounts, in.JournalBooks, in.Creditors, in.Debtors, in.ShopProfile, in.Templates, in.Vendor, in.Direction, reqCtx)
+}
+
+func (aiAccountant) VerifyCriticalFields(ctx context.Context, imagePaths []string, reqCtx *common.RequestContext) (*processor.CriticalFields, *common.TokenUsage, error) {
+	return ai.VerifyCriticalFields(ctx, imagePaths, reqCtx)
+}
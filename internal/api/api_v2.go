@@ -0,0 +1,347 @@
+// api_v2.go - POST /api/v2/analyze-receipt (typed, stable response schema)
+//
+// v1 response มีข้อมูลซ้ำ (ไทย/อังกฤษ, accounting_entry + accounting_entries) และชนิดข้อมูลไม่คงที่
+// (total เป็นตัวเลขหรือข้อความ, "N/A" แทนค่าว่าง, cost เป็น "฿0.12") → v2 = AnalyzeReceiptV2Response ชนิดตายตัว
+// pipeline เดียวกับ v1: adapter เรียก AnalyzeReceiptHandler โดยเก็บ response ไว้ก่อน แล้วแปลงเป็น v2
+// error ยังเป็นรูปแบบ ErrorResponse เดิม (status code เดิม)
+// v1 ยังใช้ได้ตามเดิม - ประกาศเลิกใช้ด้วย API_DEPRECATIONS (deprecation.go)
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"github.com/gin-gonic/gin"
+)
+
+// APIVersion2 is AnalyzeReceiptV2Response.api_version
+const APIVersion2 = "2"
+
+// bufferedResponseWriter keeps the v1 response of AnalyzeReceiptHandler so the adapter can rewrite it
+// (the timeout monitor may write from its own goroutine)
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	mu     sync.Mutex
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedResponseWriter) WriteHeaderNow() {}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *bufferedResponseWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *bufferedResponseWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status != 0
+}
+
+// result returns the status and the body written so far
+func (w *bufferedResponseWriter) result() (int, []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return status, append([]byte(nil), w.body.Bytes()...)
+}
+
+// v1AnalyzeBody is the part of the v1 response the v2 adapter reads
+type v1AnalyzeBody struct {
+	ShopID            string                 `json:"shopid"`
+	Status            string                 `json:"status"`
+	Receipt           map[string]interface{} `json:"receipt"`
+	AccountingEntry   map[string]interface{} `json:"accounting_entry"`
+	AccountingEntries []v1EntryGroup         `json:"accounting_entries"`
+	Validation        map[string]interface{} `json:"validation"`
+	TemplateInfo      map[string]interface{} `json:"template_info"`
+	Metadata          map[string]interface{} `json:"metadata"`
+	RawDocumentTexts  []RawDocumentText      `json:"raw_document_texts"`
+}
+
+// v1EntryGroup is the part of an accounting_entries[] group the adapter reads (AccountingEntryGroup)
+type v1EntryGroup struct {
+	ImageIndices    []int                  `json:"image_indices"`
+	Receipt         map[string]interface{} `json:"receipt"`
+	AccountingEntry map[string]interface{} `json:"accounting_entry"`
+	Confidence      map[string]interface{} `json:"confidence"`
+	RequiresReview  bool                   `json:"requires_review"`
+}
+
+// AnalyzeReceiptV2Handler handles POST /api/v2/analyze-receipt (same request body as v1)
+func AnalyzeReceiptV2Handler(c *gin.Context) {
+	// Step 1: Keep the image references for images[] (the body is read again by the v1 handler)
+	rawBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to read request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(rawBody))
+	var req ExtractRequest
+	_ = json.Unmarshal(rawBody, &req) // Invalid bodies get the v1 validation error below
+	// v2 has one fixed schema - sparse fieldsets would leave the converted response empty
+	if req.Fields != "" || req.ResponseProfile != "" || c.Query("fields") != "" || c.Query("response_profile") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid response field selection",
+			"details": "fields / response_profile are only supported by /api/v1/analyze-receipt",
+		})
+		return
+	}
+
+	// Step 2: Run the analysis with the v1 response held back
+	original := c.Writer
+	buffer := &bufferedResponseWriter{ResponseWriter: original}
+	c.Writer = buffer
+	AnalyzeReceiptHandler(c)
+	c.Writer = original
+	status, body := buffer.result()
+	c.Writer.Header().Del("Content-Length") // Length of the v1 body (writeLargeJSON)
+
+	// Errors keep their v1 body (ErrorResponse); 499 (client gone) has no body
+	if status != http.StatusOK {
+		if len(body) == 0 {
+			c.AbortWithStatus(status)
+			return
+		}
+		c.Data(status, "application/json; charset=utf-8", body)
+		return
+	}
+
+	// Step 3: Convert to the v2 schema
+	var v1 v1AnalyzeBody
+	if err := json.Unmarshal(body, &v1); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to build v2 response",
+			"details": err.Error(),
+		})
+		return
+	}
+	writeLargeJSON(c, http.StatusOK, convertAnalyzeResponseV2(v1, req.ImageReferences))
+}
+
+// convertAnalyzeResponseV2 maps a v1 analyze-receipt response to the v2 schema
+func convertAnalyzeResponseV2(v1 v1AnalyzeBody, refs []ImageReference) AnalyzeReceiptV2Response {
+	response := AnalyzeReceiptV2Response{
+		APIVersion: APIVersion2,
+		RequestID:  v2Text(v1.Metadata["request_id"]),
+		ShopID:     v1.ShopID,
+		Status:     v1.Status,
+		Documents:  []V2Document{},
+		Images:     []V2Image{},
+	}
+
+	// Review (whole request)
+	confidence, _ := v1.Validation["confidence"].(map[string]interface{})
+	response.Review = V2Review{
+		Confidence: v2Confidence(confidence),
+		Fields:     []string{},
+	}
+	response.Review.Required, _ = v1.Validation["requires_review"].(bool)
+	if fields, ok := v1.Validation["fields_requiring_review"].([]interface{}); ok {
+		for _, field := range fields {
+			if name := v2Text(field); name != "" {
+				response.Review.Fields = append(response.Review.Fields, name)
+			}
+		}
+	}
+
+	// Documents: one per document group, else the single receipt / accounting entry
+	if len(v1.AccountingEntries) > 0 {
+		for _, group := range v1.AccountingEntries {
+			response.Documents = append(response.Documents, V2Document{
+				ImageIndices:   append([]int{}, group.ImageIndices...),
+				Receipt:        v2Receipt(group.Receipt),
+				Journal:        v2Journal(group.AccountingEntry),
+				Confidence:     v2Confidence(group.Confidence),
+				RequiresReview: group.RequiresReview,
+			})
+		}
+	} else {
+		imageIndices := make([]int, 0, len(refs))
+		for i := range refs {
+			imageIndices = append(imageIndices, i)
+		}
+		response.Documents = append(response.Documents, V2Document{
+			ImageIndices:   imageIndices,
+			Receipt:        v2Receipt(v1.Receipt),
+			Journal:        v2Journal(v1.AccountingEntry),
+			Confidence:     response.Review.Confidence,
+			RequiresReview: response.Review.Required,
+		})
+	}
+
+	// Template
+	response.Template.Used, _ = v1.TemplateInfo["template_used"].(bool)
+	if response.Template.Used {
+		response.Template.Name = v2Text(v1.TemplateInfo["template_name"])
+		response.Template.Confidence = v2Number(v1.TemplateInfo["confidence"])
+	}
+
+	// Images (+ OCR text with include_raw_text)
+	texts := map[int]RawDocumentText{}
+	for _, text := range v1.RawDocumentTexts {
+		texts[text.ImageIndex] = text
+	}
+	for i, ref := range refs {
+		image := V2Image{ImageIndex: i, DocumentImageGUID: ref.DocumentImageGUID}
+		if text, ok := texts[i]; ok {
+			image.OCRText, image.OCRError = text.RawDocumentText, text.Error
+		}
+		response.Images = append(response.Images, image)
+	}
+
+	// Usage - gemini reports tokens at the top of token_usage, mistral under ai_processing
+	tokenUsage, _ := v1.Metadata["token_usage"].(map[string]interface{})
+	if aiProcessing, ok := tokenUsage["ai_processing"].(map[string]interface{}); ok {
+		tokenUsage = aiProcessing
+	}
+	costBreakdown, _ := v1.Metadata["cost_breakdown"].(map[string]interface{})
+	response.Usage = V2Usage{
+		OCRProvider:  v2Text(v1.Metadata["ocr_provider"]),
+		DurationSec:  v2Number(v1.Metadata["duration_sec"]),
+		InputTokens:  int(v2Number(tokenUsage["input_tokens"])),
+		OutputTokens: int(v2Number(tokenUsage["output_tokens"])),
+		CostTHB:      money.FromBaht(v2Number(costBreakdown["actual_total_thb"])).Baht(),
+	}
+	return response
+}
+
+// v2Receipt normalizes the v1 receipt section
+func v2Receipt(receipt map[string]interface{}) V2Receipt {
+	result := V2Receipt{
+		Number:        v2Text(receipt["number"]),
+		Date:          v2Text(receipt["date"]),
+		VendorName:    v2Text(receipt["vendor_name"]),
+		VendorTaxID:   v2Text(receipt["vendor_tax_id"]),
+		VendorBranch:  v2Text(receipt["vendor_branch"]),
+		Total:         v2Amount(receipt["total"]),
+		Currency:      v2Text(receipt["currency"]),
+		PaymentMethod: v2Text(receipt["payment_method"]),
+	}
+	if vat, ok := receipt["vat"]; ok && vat != nil && v2Text(vat) != "" {
+		if amount, ok := money.Parse(vat); ok {
+			result.VAT = &amount // Unreadable VAT stays null (unknown), not 0
+		}
+	}
+	return result
+}
+
+// v2Journal normalizes the v1 accounting entry (totals are recomputed from the lines)
+func v2Journal(entry map[string]interface{}) V2Journal {
+	journal := V2Journal{
+		DocumentDate:    v2Text(entry["document_date"]),
+		ReferenceNumber: v2Text(entry["reference_number"]),
+		JournalBook:     V2Code{Code: v2Text(entry["journal_book_code"]), Name: v2Text(entry["journal_book_name"])},
+		Lines:           []V2JournalLine{},
+	}
+	if code := v2Text(entry["creditor_code"]); code != "" {
+		journal.Creditor = &V2Code{Code: code, Name: v2Text(entry["creditor_name"])}
+	}
+	if code := v2Text(entry["debtor_code"]); code != "" {
+		journal.Debtor = &V2Code{Code: code, Name: v2Text(entry["debtor_name"])}
+	}
+	lines, _ := entry["entries"].([]interface{})
+	for _, raw := range lines {
+		line, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		v2Line := V2JournalLine{
+			AccountCode: v2Text(line["account_code"]),
+			AccountName: v2Text(line["account_name"]),
+			Debit:       v2Amount(line["debit"]),
+			Credit:      v2Amount(line["credit"]),
+			Description: v2Text(line["description"]),
+			Dimensions:  map[string]string{},
+		}
+		if dimensions, ok := line["dimensions"].(map[string]interface{}); ok {
+			for key, value := range dimensions {
+				v2Line.Dimensions[key] = v2Text(value)
+			}
+		}
+		journal.TotalDebit += v2Line.Debit
+		journal.TotalCredit += v2Line.Credit
+		journal.Lines = append(journal.Lines, v2Line)
+	}
+	journal.Balanced = journal.TotalDebit == journal.TotalCredit && journal.TotalDebit > 0
+	return journal
+}
+
+// v2Confidence reads a v1 {level, score} map
+func v2Confidence(confidence map[string]interface{}) V2Confidence {
+	return V2Confidence{Score: v2Number(confidence["score"]), Level: v2Text(confidence["level"])}
+}
+
+// v2Text returns a v1 value as text - placeholders ("N/A", "Unknown Vendor") and null become ""
+func v2Text(value interface{}) string {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		text = strings.TrimSpace(v)
+	default:
+		text = fmt.Sprintf("%v", v)
+	}
+	switch text {
+	case "N/A", "Unknown Vendor", "null", "-":
+		return ""
+	}
+	return text
+}
+
+// v2Number returns a v1 number (numbers sent as text, e.g. "95", are parsed too)
+func v2Number(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case string:
+		return v2Amount(v).Baht()
+	}
+	return 0
+}
+
+// v2Amount returns a v1 amount (unreadable text = 0 - the v1 balance check already flags the entry)
+func v2Amount(value interface{}) money.Amount {
+	amount, _ := money.Parse(value)
+	return amount
+}
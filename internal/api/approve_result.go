@@ -0,0 +1,350 @@
+// approve_result.go - Approve an analyzed result (draft → final) and return the journal voucher
+//
+// ผลวิเคราะห์เป็น draft จนกว่านักบัญชีจะอนุมัติ: แก้ไขฟิลด์ได้ก่อนอนุมัติ, ตรวจ balance + รหัสบัญชีซ้ำ
+// แล้ว freeze เป็น final (แก้ไขอีกไม่ได้) พร้อม voucher รูปแบบเดียวสำหรับ export / post เข้าระบบบัญชี
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ApproveResultRequest holds the optional corrections applied before the result is frozen
+type ApproveResultRequest struct {
+	Receipt         map[string]interface{} `json:"receipt,omitempty"`           // Corrected receipt fields, e.g. {"total": 1070, "number": "INV-001"} (scalar values only)
+	DocumentDate    string                 `json:"document_date,omitempty"`     // YYYY-MM-DD
+	ReferenceNumber string                 `json:"reference_number,omitempty"`  // Voucher document number
+	JournalBookCode string                 `json:"journal_book_code,omitempty"` // Must exist in journalBook
+	CreditorCode    string                 `json:"creditor_code,omitempty"`     // Must exist in creditor
+	DebtorCode      string                 `json:"debtor_code,omitempty"`       // Must exist in debtor
+	Entries         []ApproveEntry         `json:"entries,omitempty"`           // Replaces every entry (account names are taken from the chart of accounts)
+	ApprovedBy      string                 `json:"approved_by,omitempty"`
+	Note            string                 `json:"note,omitempty"`
+}
+
+// ApproveEntry is one corrected journal line
+type ApproveEntry struct {
+	AccountCode string            `json:"account_code"`
+	Debit       money.Amount      `json:"debit"` // Number or "1,234.50" - rounded to satang
+	Credit      money.Amount      `json:"credit"`
+	Description string            `json:"description,omitempty"`
+	Dimensions  map[string]string `json:"dimensions,omitempty"` // Dimension key → code (must exist in the shop's dimensions)
+}
+
+// ApproveResultResponse is the frozen result
+type ApproveResultResponse struct {
+	RequestID   string                 `json:"request_id"`
+	Status      string                 `json:"status"` // final
+	Corrections []string               `json:"corrections"`
+	ApprovedBy  string                 `json:"approved_by,omitempty"`
+	ApprovedAt  time.Time              `json:"approved_at"`
+	Voucher     storage.JournalVoucher `json:"voucher"`
+}
+
+// masterDataName returns the name of the record with code (found = false when the code does not exist)
+func masterDataName(records []bson.M, code, nameField string) (string, bool) {
+	for _, record := range records {
+		if recordCode, _ := record["code"].(string); recordCode == code {
+			name, _ := record[nameField].(string)
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// applyApproveCorrections applies the request to a copy of the stored analysis and lists the corrected fields
+// Unknown creditor / debtor / journal book codes are returned as issues
+func applyApproveCorrections(analysis storage.OCRAnalysisSnapshot, req ApproveResultRequest, masterCache *storage.MasterDataCache) (storage.OCRAnalysisSnapshot, []string, []string) {
+	corrections := []string{}
+	issues := []string{}
+	_, journalBooks, creditors, debtors := compactMasterData(masterCache)
+
+	receipt := make(map[string]interface{}, len(analysis.Receipt)+len(req.Receipt))
+	for key, value := range analysis.Receipt {
+		receipt[key] = value
+	}
+	for key, value := range req.Receipt {
+		if !receiptValuesEqual(receipt[key], value) {
+			corrections = append(corrections, "receipt."+key)
+		}
+		receipt[key] = value
+	}
+	analysis.Receipt = receipt
+
+	if req.DocumentDate != "" && req.DocumentDate != analysis.DocumentDate {
+		if _, err := time.Parse("2006-01-02", req.DocumentDate); err != nil {
+			issues = append(issues, "document_date ต้องอยู่ในรูปแบบ YYYY-MM-DD")
+		}
+		analysis.DocumentDate = req.DocumentDate
+		corrections = append(corrections, "document_date")
+	}
+	if req.ReferenceNumber != "" && req.ReferenceNumber != analysis.ReferenceNumber {
+		analysis.ReferenceNumber = req.ReferenceNumber
+		corrections = append(corrections, "reference_number")
+	}
+	if req.JournalBookCode != "" && req.JournalBookCode != analysis.JournalBookCode {
+		name, found := masterDataName(journalBooks, req.JournalBookCode, "name1")
+		if !found {
+			issues = append(issues, fmt.Sprintf("ไม่พบสมุดรายวัน %s", req.JournalBookCode))
+		}
+		analysis.JournalBookCode, analysis.JournalBookName = req.JournalBookCode, name
+		corrections = append(corrections, "journal_book_code")
+	}
+	if req.CreditorCode != "" && req.CreditorCode != analysis.CreditorCode {
+		name, found := masterDataName(creditors, req.CreditorCode, "name")
+		if !found {
+			issues = append(issues, fmt.Sprintf("ไม่พบเจ้าหนี้ %s", req.CreditorCode))
+		}
+		analysis.CreditorCode, analysis.CreditorName = req.CreditorCode, name
+		corrections = append(corrections, "creditor_code")
+	}
+	if req.DebtorCode != "" && req.DebtorCode != analysis.DebtorCode {
+		name, found := masterDataName(debtors, req.DebtorCode, "name")
+		if !found {
+			issues = append(issues, fmt.Sprintf("ไม่พบลูกหนี้ %s", req.DebtorCode))
+		}
+		analysis.DebtorCode, analysis.DebtorName = req.DebtorCode, name
+		corrections = append(corrections, "debtor_code")
+	}
+
+	if len(req.Entries) > 0 {
+		entries := make([]storage.OCRAnalysisEntry, 0, len(req.Entries))
+		for i, entry := range req.Entries {
+			if entry.Debit < 0 || entry.Credit < 0 {
+				issues = append(issues, fmt.Sprintf("entries[%d]: debit/credit ต้องไม่ติดลบ", i))
+			}
+			issues = append(issues, validateApproveDimensions(i, entry.Dimensions, masterCache)...)
+			entries = append(entries, storage.OCRAnalysisEntry{
+				AccountCode: strings.TrimSpace(entry.AccountCode),
+				Debit:       entry.Debit.Baht(),
+				Credit:      entry.Credit.Baht(),
+				Description: entry.Description,
+				Dimensions:  entry.Dimensions,
+			})
+		}
+		analysis.Entries = entries
+		corrections = append(corrections, "entries")
+	}
+	return analysis, corrections, issues
+}
+
+// validateApprovedEntries checks the balance and the account codes of the entries again
+// Account names are (re)filled from the chart of accounts
+func validateApprovedEntries(analysis *storage.OCRAnalysisSnapshot, accounts []bson.M) ([]processor.AccountCheck, map[string]interface{}, bool) {
+	names := map[string]string{}
+	for _, acc := range accounts {
+		code, _ := acc["accountcode"].(string)
+		name, _ := acc["accountname"].(string)
+		names[code] = name
+	}
+
+	entriesRaw := make([]interface{}, 0, len(analysis.Entries))
+	journalEntries := make([]JournalEntry, 0, len(analysis.Entries))
+	for i, entry := range analysis.Entries {
+		if name, ok := names[entry.AccountCode]; ok && name != "" {
+			analysis.Entries[i].AccountName = name
+		}
+		entriesRaw = append(entriesRaw, map[string]interface{}{
+			"account_code": entry.AccountCode,
+			"account_name": analysis.Entries[i].AccountName,
+			"description":  entry.Description,
+		})
+		journalEntries = append(journalEntries, JournalEntry{AccountCode: entry.AccountCode, Debit: money.FromBaht(entry.Debit), Credit: money.FromBaht(entry.Credit)})
+	}
+	accountChecks := processor.CheckEntryAccounts(map[string]interface{}{"entries": entriesRaw}, accounts)
+
+	balanced, totalDebit, totalCredit := ValidateDoubleEntry(journalEntries)
+	balanceCheck := map[string]interface{}{
+		"balanced":     balanced,
+		"total_debit":  totalDebit.Baht(),
+		"total_credit": totalCredit.Baht(),
+	}
+	return accountChecks, balanceCheck, balanced && totalDebit > 0 && len(accountChecks) == 0
+}
+
+// buildJournalVoucher converts the approved analysis into the canonical voucher payload
+func buildJournalVoucher(shopID, requestID string, analysis storage.OCRAnalysisSnapshot) storage.JournalVoucher {
+	voucher := storage.JournalVoucher{
+		RequestID:       requestID,
+		ShopID:          shopID,
+		DocumentDate:    analysis.DocumentDate,
+		DocumentNumber:  analysis.ReferenceNumber,
+		JournalBookCode: analysis.JournalBookCode,
+		JournalBookName: analysis.JournalBookName,
+		CreditorCode:    analysis.CreditorCode,
+		CreditorName:    analysis.CreditorName,
+		DebtorCode:      analysis.DebtorCode,
+		DebtorName:      analysis.DebtorName,
+		Lines:           make([]storage.JournalVoucherLine, 0, len(analysis.Entries)),
+	}
+	summary := ocrDocumentSummary(analysis.Receipt)
+	voucher.VendorName, voucher.VendorTaxID = summary.VendorName, summary.VendorTaxID
+	// AI leaves the header empty on some documents - fall back to the receipt
+	if voucher.DocumentDate == "" {
+		voucher.DocumentDate = summary.DocumentDate
+	}
+	if voucher.DocumentNumber == "" {
+		voucher.DocumentNumber = summary.DocumentNumber
+	}
+	var totalDebit, totalCredit money.Amount
+	for i, entry := range analysis.Entries {
+		voucher.Lines = append(voucher.Lines, storage.JournalVoucherLine{
+			LineNo:      i + 1,
+			AccountCode: entry.AccountCode,
+			AccountName: entry.AccountName,
+			Debit:       money.FromBaht(entry.Debit).Baht(),
+			Credit:      money.FromBaht(entry.Credit).Baht(),
+			Description: entry.Description,
+			Dimensions:  entry.Dimensions,
+		})
+		totalDebit += money.FromBaht(entry.Debit)
+		totalCredit += money.FromBaht(entry.Credit)
+	}
+	voucher.TotalDebit, voucher.TotalCredit = totalDebit.Baht(), totalCredit.Baht()
+	return voucher
+}
+
+// ApproveResultHandler handles POST /api/v1/results/:request_id/approve
+// Body (optional) = corrections; the result must still be a draft
+func ApproveResultHandler(c *gin.Context) {
+	requestID := c.Param("request_id")
+
+	var req ApproveResultRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+	for key, value := range req.Receipt {
+		switch value.(type) {
+		case string, float64, bool, nil:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid receipt correction",
+				"message": fmt.Sprintf("receipt.%s ต้องเป็นค่าเดี่ยว (ข้อความ ตัวเลข หรือ true/false)", key),
+			})
+			return
+		}
+	}
+	req.JournalBookCode = strings.TrimSpace(req.JournalBookCode)
+	req.CreditorCode = strings.TrimSpace(req.CreditorCode)
+	req.DebtorCode = strings.TrimSpace(req.DebtorCode)
+
+	// Step 1: Load the stored draft
+	record, err := dataStore.GetOCRResult(c.Request.Context(), requestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load result",
+			"details": err.Error(),
+		})
+		return
+	}
+	if record == nil || record.Analysis == nil {
+		message := "ไม่พบผลวิเคราะห์ของ request นี้ (อาจหมดอายุแล้ว, วิเคราะห์ไม่สำเร็จ หรือ request_id ไม่ถูกต้อง)"
+		if configs.OCR_RESULT_TTL_DAYS <= 0 {
+			message = "OCR result storage is disabled (set OCR_RESULT_TTL_DAYS > 0)"
+		}
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":      "result not found",
+			"message":    message,
+			"request_id": requestID,
+		})
+		return
+	}
+	if rejectForeignShop(c, record.ShopID) || rejectSuspendedShop(c, record.ShopID) {
+		return
+	}
+	if record.Status == storage.OCRResultStatusFinal {
+		respondResultFinal(c, record)
+		return
+	}
+
+	// Step 2: Apply corrections and validate again against the current master data
+	masterCache, err := dataStore.GetOrLoadMasterData(c.Request.Context(), record.ShopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load master data",
+			"details": err.Error(),
+		})
+		return
+	}
+	accounts, _, _, _ := compactMasterData(masterCache)
+	analysis, corrections, issues := applyApproveCorrections(*record.Analysis, req, masterCache)
+	accountChecks, balanceCheck, valid := validateApprovedEntries(&analysis, accounts)
+	if !valid || len(issues) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":          "validation failed",
+			"message":        "ตรวจสอบไม่ผ่าน: ยอด debit/credit ต้องเท่ากัน (มากกว่า 0) และรหัสบัญชีต้องมีในผังบัญชี",
+			"issues":         issues,
+			"account_checks": accountChecks,
+			"balance_check":  balanceCheck,
+			"request_id":     requestID,
+		})
+		return
+	}
+
+	// Step 3: Freeze as final
+	approval := storage.OCRResultApproval{
+		Voucher:     buildJournalVoucher(record.ShopID, requestID, analysis),
+		Corrections: corrections,
+		ApprovedBy:  strings.TrimSpace(req.ApprovedBy),
+		Note:        strings.TrimSpace(req.Note),
+		ApprovedAt:  time.Now(),
+	}
+	err = dataStore.ApproveOCRResult(record.ShopID, requestID, ocrDocumentSummary(analysis.Receipt), analysis, approval)
+	if errors.Is(err, storage.ErrResultFinal) {
+		// Approved by a concurrent request - return what was frozen
+		if current, getErr := dataStore.GetOCRResult(c.Request.Context(), requestID); getErr == nil && current != nil {
+			respondResultFinal(c, current)
+			return
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to approve result",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Label the template decision for the threshold report
+	recordTemplateOutcome(record, analysis, corrections)
+
+	c.JSON(http.StatusOK, ApproveResultResponse{
+		RequestID:   requestID,
+		Status:      storage.OCRResultStatusFinal,
+		Corrections: corrections,
+		ApprovedBy:  approval.ApprovedBy,
+		ApprovedAt:  approval.ApprovedAt,
+		Voucher:     approval.Voucher,
+	})
+}
+
+// respondResultFinal writes the 409 of a result that was already approved (with the frozen voucher)
+func respondResultFinal(c *gin.Context, record *storage.StoredOCRResult) {
+	response := gin.H{
+		"error":      "result already final",
+		"message":    "ผลวิเคราะห์นี้อนุมัติแล้ว แก้ไขไม่ได้",
+		"request_id": record.RequestID,
+	}
+	if record.Approval != nil {
+		response["approved_at"] = record.Approval.ApprovedAt
+		response["voucher"] = record.Approval.Voucher
+	}
+	c.JSON(http.StatusConflict, response)
+}
@@ -0,0 +1,179 @@
+// audit.go - Audit log of every API call + admin query endpoint
+//
+// บันทึกทุก request ใต้ /api/v1 หลังตอบแล้ว: ใคร (admin key / ร้าน), endpoint ไหน, request_id, ผลลัพธ์, ค่าใช้จ่าย AI
+// ใช้ตอบคำถามเช่น "ใครส่งเอกสารนี้" หรือ "ใครแก้ settings ของร้าน" - ไม่เก็บ body / เนื้อหาเอกสาร
+
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// gin context keys filled by handlers for the audit entry
+const (
+	auditActorContextKey  = "audit_actor"   // storage.AuditActorAdmin once the admin key is verified
+	auditShopIDContextKey = "audit_shop_id" // Shop from the request body (routes without :shopid)
+	auditCostContextKey   = "audit_cost"    // common.TokenUsage of the AI calls
+)
+
+// AuditLogResponse is the response of GET /api/v1/admin/audit
+type AuditLogResponse struct {
+	Entries []storage.AuditEntry `json:"entries"`
+}
+
+// AuditMiddleware records every /api/v1 and /api/v2 call in the audit log after the response is written
+func AuditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if configs.AUDIT_LOG_TTL_DAYS <= 0 || !strings.HasPrefix(c.Request.URL.Path, "/api/") || c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		c.Next()
+
+		entry := storage.AuditEntry{
+			Actor:      c.GetString(auditActorContextKey),
+			APIKey:     apiKeyFingerprint(c.GetHeader("Authorization")),
+			ShopID:     c.Param("shopid"),
+			Method:     c.Request.Method,
+			Endpoint:   c.FullPath(),
+			Path:       c.Request.URL.Path,
+			RequestID:  c.GetString(requestIDContextKey),
+			StatusCode: c.Writer.Status(),
+			Outcome:    auditOutcome(c.Writer.Status()),
+			DurationMs: time.Since(start).Milliseconds(),
+			ClientIP:   c.ClientIP(),
+			UserAgent:  c.Request.UserAgent(),
+		}
+		if entry.ShopID == "" {
+			entry.ShopID = c.GetString(auditShopIDContextKey)
+		}
+		if entry.Endpoint == "" {
+			entry.Endpoint = entry.Path // No matching route (404)
+		}
+		if entry.Actor == "" {
+			entry.Actor = storage.AuditActorAnonymous
+			if entry.ShopID != "" {
+				entry.Actor = storage.AuditActorShop
+			}
+		}
+		if value, ok := c.Get(auditCostContextKey); ok {
+			if cost, ok := value.(common.TokenUsage); ok {
+				entry.CostTHB = cost.CostTHB
+				entry.CostUSD = cost.CostUSD
+			}
+		}
+
+		// Write in background - the audit log must not delay the response
+		go func() {
+			if err := storage.RecordAudit(entry); err != nil {
+				log.Printf("⚠️  Failed to record audit entry (%s %s): %v", entry.Method, entry.Path, err)
+			}
+		}()
+	}
+}
+
+// auditOutcome maps the HTTP status to an audit outcome
+func auditOutcome(status int) string {
+	switch {
+	case status == statusClientClosedRequest:
+		return storage.AuditOutcomeAborted
+	case status >= 500:
+		return storage.AuditOutcomeError
+	case status >= 400:
+		return storage.AuditOutcomeRejected
+	default:
+		return storage.AuditOutcomeSuccess
+	}
+}
+
+// apiKeyFingerprint identifies the bearer key without storing it (first 12 hex of SHA-256, empty = no key)
+func apiKeyFingerprint(authorization string) string {
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// AuditLogHandler handles GET /api/v1/admin/audit
+func AuditLogHandler(c *gin.Context) {
+	query := storage.AuditQuery{
+		ShopID:    c.Query("shopid"),
+		Actor:     c.Query("actor"),
+		RequestID: c.Query("request_id"),
+		Endpoint:  c.Query("endpoint"),
+		Limit:     100,
+	}
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid limit",
+				"message": "limit ต้องเป็นตัวเลข 1-1000",
+			})
+			return
+		}
+		query.Limit = parsed
+	}
+	if v := c.Query("from"); v != "" {
+		from, ok := parseAuditTime(v, false)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid from",
+				"message": "from ต้องอยู่ในรูปแบบ YYYY-MM-DD หรือ RFC3339",
+			})
+			return
+		}
+		query.From = from
+	}
+	if v := c.Query("to"); v != "" {
+		to, ok := parseAuditTime(v, true)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid to",
+				"message": "to ต้องอยู่ในรูปแบบ YYYY-MM-DD หรือ RFC3339",
+			})
+			return
+		}
+		query.To = to
+	}
+
+	entries, err := storage.ListAuditEntries(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load audit log",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, AuditLogResponse{Entries: entries})
+}
+
+// parseAuditTime parses a day (YYYY-MM-DD, inclusive - endOfRange moves "to" to the next day) or an RFC3339 time
+func parseAuditTime(value string, endOfRange bool) (time.Time, bool) {
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfRange {
+			return day.AddDate(0, 0, 1), true
+		}
+		return day, true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,140 @@
+// auth.go - Role-based access control (admin key vs shop keys)
+//
+// Authorization: Bearer <key>
+//   - ADMIN_API_KEY → role admin: ทุก endpoint ของทุกร้าน (rules, settings, ledger, cache, admin API)
+//   - SHOP_API_KEYS (shopid=key) → role shop: วิเคราะห์เอกสารและอ่านผลของร้านตัวเองเท่านั้น
+// แต่ละ route ระบุ role ที่ต้องการด้วย RequireRole(...) ใน cmd/api/main.go
+// API_AUTH_REQUIRED=false (default) → request ที่ไม่มี key ผ่าน route ของ shop ได้เหมือนเดิม แต่ shop key ที่ส่งมายังถูกจำกัดเฉพาะร้านตัวเอง
+// route ของ admin ต้องมี admin key เสมอ (ไม่ได้ตั้ง ADMIN_API_KEY / ไม่ได้ส่ง → 403)
+
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Roles
+const (
+	RoleAdmin = "admin" // ADMIN_API_KEY
+	RoleShop  = "shop"  // Key of one shop (SHOP_API_KEYS) - admin keys pass as well
+)
+
+// gin context keys of the authenticated caller
+const (
+	authRoleContextKey   = "auth_role"    // RoleAdmin / RoleShop (empty = no valid key)
+	authShopIDContextKey = "auth_shop_id" // Shop of a shop key
+)
+
+// RequireRole rejects callers without the role (route annotation)
+// Shop keys are also limited to their own shop on routes with a :shopid parameter
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		callerRole, callerShop := authenticateCaller(c)
+
+		if callerRole == "" {
+			if role == RoleAdmin {
+				abortAdminRequired(c) // Admin routes never run in open mode
+				return
+			}
+			if !configs.API_AUTH_REQUIRED {
+				c.Next() // Open mode (API_AUTH_REQUIRED=false) - shop routes only
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error":   "unauthorized",
+				"message": "ต้องส่ง Authorization: Bearer <API key> ของร้านหรือผู้ดูแลระบบ",
+			})
+			return
+		}
+
+		if role == RoleAdmin && callerRole != RoleAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":         "forbidden",
+				"message":       "endpoint นี้ใช้ได้เฉพาะ admin key",
+				"required_role": RoleAdmin,
+				"role":          callerRole,
+			})
+			return
+		}
+		if shopID := c.Param("shopid"); shopID != "" && callerRole == RoleShop && shopID != callerShop {
+			respondForeignShop(c, shopID)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// abortAdminRequired rejects an admin route without a valid admin key (fails closed like AdminAuthMiddleware)
+func abortAdminRequired(c *gin.Context) {
+	if configs.ADMIN_API_KEY == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error":   "admin_api_disabled",
+			"message": "endpoint นี้ใช้ได้เฉพาะ admin key - ยังไม่ได้ตั้งค่า ADMIN_API_KEY",
+		})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error":         "forbidden",
+		"message":       "endpoint นี้ใช้ได้เฉพาะ admin key",
+		"required_role": RoleAdmin,
+	})
+}
+
+// authenticateCaller resolves the bearer key into a role (and the shop of a shop key)
+// The result is kept in the gin context for rejectForeignShop and the audit log
+func authenticateCaller(c *gin.Context) (string, string) {
+	token := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	if token == "" {
+		return "", ""
+	}
+
+	role, shopID := "", ""
+	if configs.ADMIN_API_KEY != "" && subtle.ConstantTimeCompare([]byte(token), []byte(configs.ADMIN_API_KEY)) == 1 {
+		role = RoleAdmin
+	} else {
+		for shop, key := range configs.SHOP_API_KEYS {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+				role, shopID = RoleShop, shop
+				break
+			}
+		}
+	}
+	if role == "" {
+		return "", ""
+	}
+
+	c.Set(authRoleContextKey, role)
+	c.Set(authShopIDContextKey, shopID)
+	if role == RoleAdmin {
+		c.Set(auditActorContextKey, storage.AuditActorAdmin)
+	} else {
+		c.Set(auditActorContextKey, storage.AuditActorShop)
+	}
+	return role, shopID
+}
+
+// rejectForeignShop writes 403 when a shop key acts on another shop (true = response written)
+// Used where the shop comes from the body or a stored record instead of the :shopid path
+func rejectForeignShop(c *gin.Context, shopID string) bool {
+	if c.GetString(authRoleContextKey) != RoleShop || c.GetString(authShopIDContextKey) == shopID {
+		return false
+	}
+	respondForeignShop(c, shopID)
+	return true
+}
+
+// respondForeignShop writes the 403 of a shop key used on another shop
+func respondForeignShop(c *gin.Context, shopID string) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":   "forbidden",
+		"message": "API key ของร้านใช้ได้เฉพาะข้อมูลของร้านตัวเอง",
+		"shopid":  shopID,
+	})
+}
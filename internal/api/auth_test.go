@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/gin-gonic/gin"
+)
+
+// TestRequireRoleOpenMode - API_AUTH_REQUIRED=false opens shop routes only; admin routes (purge) always need the admin key
+func TestRequireRoleOpenMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if configs.API_AUTH_REQUIRED {
+		t.Fatal("test expects API_AUTH_REQUIRED=false (default)")
+	}
+	tests := []struct {
+		name       string
+		adminKey   string
+		method     string
+		path       string
+		bearer     string
+		wantStatus int
+		wantError  string
+	}{
+		{name: "purge without ADMIN_API_KEY", method: http.MethodDelete, path: "/api/v1/shops/s1/results", wantStatus: http.StatusForbidden, wantError: "admin_api_disabled"},
+		{name: "purge without a key", adminKey: "admin-key", method: http.MethodDelete, path: "/api/v1/shops/s1/results", wantStatus: http.StatusForbidden, wantError: "forbidden"},
+		{name: "purge with a wrong key", adminKey: "admin-key", method: http.MethodDelete, path: "/api/v1/shops/s1/results", bearer: "guess", wantStatus: http.StatusForbidden, wantError: "forbidden"},
+		{name: "purge with the shop key", adminKey: "admin-key", method: http.MethodDelete, path: "/api/v1/shops/s1/results", bearer: "key-s1", wantStatus: http.StatusForbidden, wantError: "forbidden"},
+		{name: "purge with the admin key", adminKey: "admin-key", method: http.MethodDelete, path: "/api/v1/shops/s1/results", bearer: "admin-key", wantStatus: http.StatusOK},
+		{name: "shop route without a key", method: http.MethodGet, path: "/api/v1/shops/s1/failed", wantStatus: http.StatusOK},
+		{name: "shop route with another shop's key", method: http.MethodGet, path: "/api/v1/shops/s2/failed", bearer: "key-s1", wantStatus: http.StatusForbidden, wantError: "forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestAPIKeys(t, tt.adminKey, map[string]string{"s1": "key-s1"})
+			ok := func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "success"}) }
+			router := gin.New()
+			router.DELETE("/api/v1/shops/:shopid/results", RequireRole(RoleAdmin), ok)
+			router.GET("/api/v1/shops/:shopid/failed", RequireRole(RoleShop), ok)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d - body %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantError != "" && !strings.Contains(rec.Body.String(), `"error":"`+tt.wantError+`"`) {
+				t.Errorf("body = %s, want error %q", rec.Body.String(), tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+// balance_correction.go - Rounding fix of unbalanced entries (see processor/balance_correction.go)
+
+package api
+
+import (
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// balanceAutoApplyMaxAmount - auto-apply is limited to sub-baht differences whatever the shop configured
+const balanceAutoApplyMaxAmount money.Amount = 100
+
+// balanceCorrection suggests the fix of a small debit/credit difference (nil = balanced / no suggestion)
+// Shops with settings.balancecorrection.autoapply get it applied and balance_check recomputed
+func balanceCorrection(entry, receipt map[string]interface{}, masterCache *storage.MasterDataCache, accounts []bson.M) *processor.BalanceCorrection {
+	correction := processor.SuggestBalanceCorrection(entry, receipt, accounts, configs.Get().BalanceCorrectionMaxDifference)
+	if correction == nil || masterCache.ShopProfile == nil {
+		return correction
+	}
+	settings := masterCache.ShopProfile.Settings.BalanceCorrection
+	limit := money.FromBaht(settings.MaxAmount)
+	if limit <= 0 || limit > balanceAutoApplyMaxAmount {
+		limit = balanceAutoApplyMaxAmount
+	}
+	if settings.AutoApply && correction.Difference.Abs() < limit {
+		processor.ApplyBalanceCorrection(entry, correction)
+		setBalanceCheck(entry)
+	}
+	return correction
+}
@@ -0,0 +1,170 @@
+// body_limit.go - Request size limits (413 payload_too_large)
+//
+// - body ของ request: JSON ≤ MAX_REQUEST_BODY_BYTES, multipart ≤ MAX_UPLOAD_BODY_BYTES (analyze-zip ≤ ZIP_MAX_BYTES)
+//   ปรับราย route ได้ด้วย REQUEST_BODY_LIMITS="/api/v1/shops/:shopid/templates/validate=4194304"
+// - จำนวนรูปต่อ request ≤ MAX_IMAGES_PER_REQUEST
+// - รูปที่ดาวน์โหลด: แต่ละรูป ≤ IMAGE_REJECT_MAX_BYTES (หยุดดาวน์โหลดทันทีที่เกิน) รวมทั้ง request ≤ MAX_TOTAL_PAYLOAD_BYTES
+// ทุกกรณีตอบ 413 พร้อม reason / limit และคำแนะนำภาษาไทยว่าต้องแก้อย่างไร (0 = ไม่จำกัด)
+
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/gin-gonic/gin"
+)
+
+// Payload limit reasons (PayloadTooLargeError.Reason)
+const (
+	payloadReasonBody         = "body"
+	payloadReasonImageCount   = "image_count"
+	payloadReasonImageSize    = "image_size"
+	payloadReasonTotalPayload = "total_payload"
+)
+
+// PayloadTooLargeError is a request above one of the size limits
+type PayloadTooLargeError struct {
+	Reason string
+	Value  int64 // Bytes or images (-1 = unknown, stopped at the limit)
+	Limit  int64
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	switch e.Reason {
+	case payloadReasonImageCount:
+		return fmt.Sprintf("%d images (limit %d per request)", e.Value, e.Limit)
+	case payloadReasonImageSize:
+		return fmt.Sprintf("image is larger than %d bytes", e.Limit)
+	case payloadReasonTotalPayload:
+		return fmt.Sprintf("images total %d bytes (limit %d per request)", e.Value, e.Limit)
+	default:
+		return fmt.Sprintf("request body is larger than %d bytes", e.Limit)
+	}
+}
+
+// payloadGuidance is the Thai hint of each reason
+var payloadGuidance = map[string]string{
+	payloadReasonBody:         "ขนาด request ใหญ่เกินกำหนด - ส่ง URL ของรูปใน imagereferences แทนการฝังข้อมูล หรืออัปโหลดทีละไฟล์",
+	payloadReasonImageCount:   "จำนวนรูปเกินกำหนด - แบ่งเอกสารเป็นหลาย request (หรือใช้ analyze-zip สำหรับงานจำนวนมาก)",
+	payloadReasonImageSize:    "ไฟล์รูปใหญ่เกินกำหนด - ถ่ายใหม่ด้วยความละเอียดปกติหรือลดขนาดไฟล์แล้วส่งใหม่",
+	payloadReasonTotalPayload: "ขนาดรูปรวมของ request เกินกำหนด - ลดขนาดรูปหรือแบ่งเป็นหลาย request",
+}
+
+// BodyLimitMiddleware rejects request bodies above the limit of the route with 413
+// Declared sizes are rejected before reading; JSON bodies without Content-Length are read up to the limit,
+// uploads are capped while streaming (the handler's read fails at the limit)
+func BodyLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := requestBodyLimit(c)
+		if limit <= 0 || c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > limit {
+			abortPayloadTooLarge(c, &PayloadTooLargeError{Reason: payloadReasonBody, Value: c.Request.ContentLength, Limit: limit})
+			return
+		}
+		if c.Request.ContentLength < 0 && !isMultipartRequest(c) {
+			body, err := io.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"error":   "Failed to read request body",
+					"details": err.Error(),
+				})
+				return
+			}
+			if int64(len(body)) > limit {
+				abortPayloadTooLarge(c, &PayloadTooLargeError{Reason: payloadReasonBody, Value: -1, Limit: limit})
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			c.Next()
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// requestBodyLimit returns the body limit of the route (REQUEST_BODY_LIMITS, then zip / upload / JSON defaults)
+func requestBodyLimit(c *gin.Context) int64 {
+	cfg := configs.Get()
+	limits, err := configs.ParseRequestBodyLimits(cfg.RequestBodyLimits)
+	if err != nil {
+		// Validated on load - an invalid reload keeps the previous config
+		log.Printf("⚠️  Ignoring REQUEST_BODY_LIMITS: %v", err)
+	}
+	if limit, ok := limits[c.FullPath()]; ok {
+		return limit
+	}
+	switch {
+	case c.FullPath() == "/api/v1/analyze-zip" && cfg.ZipMaxBytes > 0:
+		return int64(cfg.ZipMaxBytes) + 1<<20 // Form fields around the archive
+	case isMultipartRequest(c):
+		return int64(cfg.MaxUploadBodyBytes)
+	default:
+		return int64(cfg.MaxRequestBodyBytes)
+	}
+}
+
+// checkImageCount writes 413 when count is above MAX_IMAGES_PER_REQUEST (false = response written)
+func checkImageCount(c *gin.Context, requestID string, count int) bool {
+	limit := configs.Get().MaxImagesPerRequest
+	if limit <= 0 || count <= limit {
+		return true
+	}
+	respondPayloadTooLarge(c, requestID, &PayloadTooLargeError{Reason: payloadReasonImageCount, Value: int64(count), Limit: int64(limit)}, -1)
+	return false
+}
+
+// respondPayloadTooLarge writes the 413 response of a size limit (imageIndex < 0 = not about one image)
+func respondPayloadTooLarge(c *gin.Context, requestID string, err *PayloadTooLargeError, imageIndex int) {
+	response := payloadTooLargeBody(err)
+	if requestID != "" {
+		response["request_id"] = requestID
+	}
+	if imageIndex >= 0 {
+		response["image_index"] = imageIndex
+	}
+	c.JSON(http.StatusRequestEntityTooLarge, response)
+}
+
+// abortPayloadTooLarge rejects the request body before the handler runs
+func abortPayloadTooLarge(c *gin.Context, err *PayloadTooLargeError) {
+	log.Printf("🚫 %s %s rejected: %v", c.Request.Method, c.Request.URL.Path, err)
+	c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, payloadTooLargeBody(err))
+}
+
+// payloadTooLargeBody is the 413 body shared by every size limit
+func payloadTooLargeBody(err *PayloadTooLargeError) gin.H {
+	response := gin.H{
+		"error":   "payload_too_large",
+		"message": payloadGuidance[err.Reason],
+		"details": err.Error(),
+		"reason":  err.Reason,
+		"limit":   err.Limit,
+	}
+	if err.Value >= 0 {
+		response["value"] = err.Value
+	}
+	return response
+}
+
+// asPayloadTooLarge reports whether err is (or wraps) a size limit error
+func asPayloadTooLarge(err error) (*PayloadTooLargeError, bool) {
+	var sizeErr *PayloadTooLargeError
+	if errors.As(err, &sizeErr) {
+		return sizeErr, true
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &PayloadTooLargeError{Reason: payloadReasonBody, Value: -1, Limit: maxBytesErr.Limit}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,83 @@
+// classify_document.go - Classify a document without accounting analysis
+//
+// OCR อย่างเดียว + แยกประเภทเอกสารจาก keyword (processor.ClassifyDocument)
+// ไม่ใช้ master data / template / accounting → ถูกกว่า analyze-receipt มาก
+// ให้ frontend ส่งเอกสารไปขั้นตอนที่ถูกต้องก่อนวิเคราะห์เต็ม
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/gin-gonic/gin"
+)
+
+// ClassifyDocumentResponse is the response of POST /api/v1/classify-document
+type ClassifyDocumentResponse struct {
+	RequestID         string `json:"request_id"`
+	DocumentImageGUID string `json:"documentimageguid,omitempty"`
+	processor.DocumentClassification
+	Provider          string                     `json:"provider"` // OCR provider that read the document
+	TextLength        int                        `json:"text_length"`
+	ProcessingTimeMs  int64                      `json:"processing_time_ms"`
+	TokenUsage        common.TokenUsage          `json:"token_usage"`
+	ImageReductions   []common.ImageReduction    `json:"image_reductions,omitempty"`   // Images shrunk before the Gemini call
+	FileScans         []common.FileScan          `json:"file_scans,omitempty"`         // Malware scan of the file (FILE_SCAN_DRIVER)
+	ImageSanitization []common.ImageSanitization `json:"image_sanitization,omitempty"` // Metadata stripped from the image (STRIP_IMAGE_METADATA)
+	Steps             []common.StepLog           `json:"steps"`                        // Per-step timings and tokens / cost
+}
+
+// ClassifyDocumentHandler handles POST /api/v1/classify-document
+func ClassifyDocumentHandler(c *gin.Context) {
+	// Step 1: Parse request (multipart file or JSON imageuri)
+	var req StandaloneDocumentRequest
+	if !bindStandaloneRequest(c, &req, &req) {
+		return
+	}
+
+	reqCtx := common.NewRequestContext(req.ShopID)
+	c.Set(requestIDContextKey, reqCtx.RequestID)
+	reqCtx.LogInfo("🗂️  Classify document | ShopID: %s | OCR Provider: %s", req.ShopID, req.Model)
+	defer recordUsageLedger(c, reqCtx, "classify-document", req.Model)
+	defer saveAITraces(reqCtx, "classify-document")
+
+	// Request deadline (REQUEST_TIMEOUT) - each phase derives its own deadline from it
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout())
+	defer cancel()
+
+	// Step 2: Receive the document
+	path, ok := receiveStandaloneDocument(c, ctx, reqCtx, req)
+	if !ok {
+		return
+	}
+	defer os.Remove(path)
+
+	// Step 3: OCR
+	ocrResult, provider := runStandaloneOCR(c, ctx, reqCtx, req.Model, path)
+	if ocrResult == nil {
+		return
+	}
+
+	// Step 4: Classify from the OCR text
+	classification := processor.ClassifyDocument(ocrResult.RawDocumentText)
+	reqCtx.LogInfo("✅ Document type: %s (%.2f) %v", classification.DocumentType, classification.Confidence, classification.Signals)
+	summary := reqCtx.GetSummary()
+
+	c.JSON(http.StatusOK, ClassifyDocumentResponse{
+		RequestID:              reqCtx.RequestID,
+		DocumentImageGUID:      req.DocumentImageGUID,
+		DocumentClassification: classification,
+		Provider:               provider,
+		TextLength:             len([]rune(ocrResult.RawDocumentText)),
+		ProcessingTimeMs:       summary["total_duration_ms"].(int64),
+		TokenUsage:             reqCtx.TotalTokens,
+		ImageReductions:        reqCtx.ImageReductions(),
+		FileScans:              reqCtx.FileScans(),
+		ImageSanitization:      reqCtx.ImageSanitizations(),
+		Steps:                  reqCtx.GetSteps(),
+	})
+}
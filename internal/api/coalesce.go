@@ -0,0 +1,127 @@
+// coalesce.go - Single-flight coalescing of identical analyze requests running at the same time
+//
+// กดส่งซ้ำ (double-click) → 2 request เหมือนกันวิ่งพร้อมกันและเสียค่า AI 2 เท่า
+// key = shopid + hash ของ payload (รูปชุดเดียวกัน + ตัวเลือกเดียวกัน, ไม่รวม client_request_id) + route/query
+//   - ยังไม่มี request นี้กำลังทำ → ทำตามปกติ (leader)
+//   - มี request เดียวกันกำลังทำอยู่ → รอผลของ leader แล้วตอบผลเดียวกัน (header Request-Coalesced: true)
+//   - leader ล้มเหลว (ไม่ใช่ 2xx) → request ที่รออยู่ทำเองตามปกติ
+// เฉพาะในแต่ละ instance (ข้าม instance ใช้ Idempotency-Key)
+// shop key ของร้านอื่น (shopid ใน body ไม่ตรงกับ key) → 403 ก่อนเข้าร่วม call ใด ๆ ไม่ได้ผลของร้านอื่น
+//
+// ขอบเขต: key มาจาก payload (URL ของรูป) ไม่ใช่เนื้อหาของไฟล์ - ตัดสินก่อนดาวน์โหลด → request ซ้ำไม่ดาวน์โหลดอะไรเลย
+//   - รวมได้: กดส่งซ้ำ / client retry ที่ส่ง URL ชุดเดิม
+//   - ไม่รวม: รูปเดียวกันแต่ URL ต่างกัน (เช่น signed URL ที่สร้างใหม่ทุกครั้ง, อัปโหลดไฟล์เดิมซ้ำ) → วิเคราะห์แยกกัน
+//     ต้องการผลเดียวกันในกรณีนี้ → ให้ client ส่ง Idempotency-Key
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/gin-gonic/gin"
+)
+
+const coalescedHeader = "Request-Coalesced"
+
+// coalescedCall is an analysis in flight and, once done is closed, its response
+type coalescedCall struct {
+	done        chan struct{}
+	status      int
+	contentType string
+	body        []byte
+	waiters     int
+}
+
+var (
+	coalesceMu    sync.Mutex
+	coalesceCalls = map[string]*coalescedCall{}
+)
+
+// CoalesceMiddleware lets an identical concurrent request (same shop and payload) reuse the running analysis (REQUEST_COALESCING)
+// The payload holds the image URLs - the same images sent under different URLs are not coalesced (see the header)
+func CoalesceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !configs.Get().RequestCoalescing {
+			c.Next()
+			return
+		}
+		rawBody, err := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(rawBody))
+		if err != nil {
+			c.Next()
+			return
+		}
+		var payload map[string]interface{}
+		if err := json.Unmarshal(rawBody, &payload); err != nil {
+			c.Next()
+			return
+		}
+		shopID, _ := payload["shopid"].(string)
+		if shopID == "" {
+			c.Next()
+			return
+		}
+		// Shop check before joining a call - a waiter gets the leader's response without reaching the handler's check
+		if rejectForeignShop(c, shopID) {
+			c.Abort()
+			return
+		}
+		key := shopID + ":" + hashIdempotentPayload(payload, payloadScope(c))
+
+		coalesceMu.Lock()
+		if call, running := coalesceCalls[key]; running {
+			call.waiters++
+			coalesceMu.Unlock()
+			waitCoalescedCall(c, call, shopID)
+			return
+		}
+		call := &coalescedCall{done: make(chan struct{})}
+		coalesceCalls[key] = call
+		coalesceMu.Unlock()
+
+		// Leader: run the analysis and keep the response for the waiters
+		writer := &bodyCaptureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		defer func() {
+			if writer.Written() {
+				call.status = writer.Status() // Nothing written (panic) = failed
+			}
+			call.contentType = writer.Header().Get("Content-Type")
+			call.body = writer.body.Bytes()
+			coalesceMu.Lock()
+			delete(coalesceCalls, key)
+			waiters := call.waiters
+			coalesceMu.Unlock()
+			close(call.done)
+			if waiters > 0 {
+				log.Printf("🔗 Coalesced %d duplicate request(s) for shop %s (status %d)", waiters, shopID, call.status)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// waitCoalescedCall waits for the leader and replays its response (runs the request itself when the leader failed)
+func waitCoalescedCall(c *gin.Context, call *coalescedCall, shopID string) {
+	log.Printf("🔗 Duplicate request for shop %s is waiting for the identical request in progress", shopID)
+	select {
+	case <-call.done:
+	case <-c.Request.Context().Done():
+		// Client went away - nothing to answer
+		c.Abort()
+		return
+	}
+	if call.status < 200 || call.status >= 300 {
+		log.Printf("⚠️  Coalesced request failed (status %d) - processing the duplicate request (shop %s)", call.status, shopID)
+		c.Next()
+		return
+	}
+	c.Header(coalescedHeader, "true")
+	c.Data(call.status, call.contentType, call.body)
+	c.Abort()
+}
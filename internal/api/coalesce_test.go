@@ -0,0 +1,167 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/gin-gonic/gin"
+)
+
+// TestCoalesceMiddlewareScope - duplicates are matched on the payload (image URLs), not on the image content
+func TestCoalesceMiddlewareScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		bodies     [2]string
+		wantRuns   int32
+		coalescedN int
+	}{
+		{
+			name:       "same URLs",
+			bodies:     [2]string{`{"shopid":"s1","imagereferences":[{"imageuri":"https://files.test/a.jpg"}]}`, `{"imagereferences":[{"imageuri":"https://files.test/a.jpg"}],"shopid":"s1","client_request_id":"x"}`},
+			wantRuns:   1,
+			coalescedN: 1,
+		},
+		{
+			name:     "same image under another URL",
+			bodies:   [2]string{`{"shopid":"s1","imagereferences":[{"imageuri":"https://files.test/a.jpg?sig=1"}]}`, `{"shopid":"s1","imagereferences":[{"imageuri":"https://files.test/a.jpg?sig=2"}]}`},
+			wantRuns: 2,
+		},
+		{
+			name:     "other shop",
+			bodies:   [2]string{`{"shopid":"s1","imagereferences":[{"imageuri":"https://files.test/a.jpg"}]}`, `{"shopid":"s2","imagereferences":[{"imageuri":"https://files.test/a.jpg"}]}`},
+			wantRuns: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var runs atomic.Int32
+			started := make(chan struct{}, 2)
+			release := make(chan struct{})
+			router := gin.New()
+			router.POST("/api/v1/analyze-receipt", CoalesceMiddleware(), func(c *gin.Context) {
+				runs.Add(1)
+				started <- struct{}{}
+				<-release
+				c.JSON(http.StatusOK, gin.H{"status": "success"})
+			})
+
+			recorders := [2]*httptest.ResponseRecorder{httptest.NewRecorder(), httptest.NewRecorder()}
+			var wg sync.WaitGroup
+			send := func(i int) {
+				defer wg.Done()
+				req := httptest.NewRequest(http.MethodPost, "/api/v1/analyze-receipt", strings.NewReader(tt.bodies[i]))
+				router.ServeHTTP(recorders[i], req)
+			}
+			wg.Add(1)
+			go send(0)
+			<-started // Leader is running
+			wg.Add(1)
+			go send(1)
+			if tt.wantRuns == 2 {
+				<-started
+			} else {
+				waitForCoalesceWaiter(t)
+			}
+			close(release)
+			wg.Wait()
+
+			if got := runs.Load(); got != tt.wantRuns {
+				t.Errorf("handler runs = %d, want %d", got, tt.wantRuns)
+			}
+			coalesced := 0
+			for _, rec := range recorders {
+				if rec.Code != http.StatusOK {
+					t.Errorf("status = %d, want 200", rec.Code)
+				}
+				if rec.Header().Get(coalescedHeader) == "true" {
+					coalesced++
+				}
+			}
+			if coalesced != tt.coalescedN {
+				t.Errorf("coalesced responses = %d, want %d", coalesced, tt.coalescedN)
+			}
+		})
+	}
+}
+
+// setTestAPIKeys configures the admin / shop keys for one test
+func setTestAPIKeys(t *testing.T, adminKey string, shopKeys map[string]string) {
+	t.Helper()
+	admin, shops := configs.ADMIN_API_KEY, configs.SHOP_API_KEYS
+	configs.ADMIN_API_KEY, configs.SHOP_API_KEYS = adminKey, shopKeys
+	t.Cleanup(func() { configs.ADMIN_API_KEY, configs.SHOP_API_KEYS = admin, shops })
+}
+
+// TestCoalesceMiddlewareForeignShop - a shop key cannot join another shop's analysis in flight
+func TestCoalesceMiddlewareForeignShop(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setTestAPIKeys(t, "", map[string]string{"s1": "key-s1", "s2": "key-s2"})
+	var runs atomic.Int32
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	router := gin.New()
+	router.POST("/api/v1/analyze-receipt", RequireRole(RoleShop), CoalesceMiddleware(), func(c *gin.Context) {
+		runs.Add(1)
+		started <- struct{}{}
+		<-release
+		c.JSON(http.StatusOK, gin.H{"status": "success", "shopid": "s1"})
+	})
+	body := `{"shopid":"s1","imagereferences":[{"imageuri":"https://files.test/a.jpg"}]}`
+	send := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/analyze-receipt", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	leader := make(chan *httptest.ResponseRecorder)
+	go func() { leader <- send("key-s1") }()
+	<-started // Leader of shop s1 is running
+
+	foreignDone := make(chan *httptest.ResponseRecorder, 1)
+	go func() { foreignDone <- send("key-s2") }() // Same payload, key of shop s2
+	var foreign *httptest.ResponseRecorder
+	select {
+	case foreign = <-foreignDone:
+	case <-time.After(2 * time.Second):
+		close(release)
+		t.Fatal("foreign shop request is waiting on the running call")
+	}
+	close(release)
+	if rec := <-leader; rec.Code != http.StatusOK {
+		t.Errorf("leader status = %d, want 200", rec.Code)
+	}
+	if foreign.Code != http.StatusForbidden || foreign.Header().Get(coalescedHeader) != "" {
+		t.Errorf("foreign shop status = %d (coalesced %q), want 403 - body %s", foreign.Code, foreign.Header().Get(coalescedHeader), foreign.Body.String())
+	}
+	if got := runs.Load(); got != 1 {
+		t.Errorf("handler runs = %d, want 1", got)
+	}
+}
+
+// waitForCoalesceWaiter waits until a duplicate request is waiting on the running call
+func waitForCoalesceWaiter(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		coalesceMu.Lock()
+		waiting := 0
+		for _, call := range coalesceCalls {
+			waiting += call.waiters
+		}
+		coalesceMu.Unlock()
+		if waiting > 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("duplicate request never waited on the running call")
+}
@@ -0,0 +1,362 @@
+// compare_modes.go - Run Phase 3 of a stored document in template-only mode and full mode side by side
+//
+// template จับคู่ได้ 86% แต่ threshold 95% → ผู้ใช้อยากรู้ว่าถ้าใช้ template ผลจะต่างจาก full mode แค่ไหน
+// ใช้ข้อความ OCR ที่เก็บไว้ (เหมือน reanalyze) เรียก Phase 3 สองครั้ง: template ที่ดีที่สุด (ไม่สน threshold) และ full mode
+// คืนผลทั้งสองแบบ + ส่วนที่ต่างกัน + ค่าใช้จ่ายของแต่ละแบบ - ใช้ตั้ง TEMPLATE_CONFIDENCE_THRESHOLD (ไม่บันทึกผลเป็น request ใหม่)
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CompareModesRequest holds the optional overrides of a mode comparison
+type CompareModesRequest struct {
+	TemplateID string `json:"template_id,omitempty"` // Compare with this documentFormate template (default = best match)
+	Model      string `json:"model,omitempty"`       // Accounting model of both runs (default = configured model)
+}
+
+// CompareModesTemplate is the template used by the template-only run
+type CompareModesTemplate struct {
+	TemplateID  string  `json:"template_id"`
+	Description string  `json:"description"`
+	Confidence  float64 `json:"confidence"` // Template match confidence (100 = forced by template_id)
+	Threshold   float64 `json:"threshold"`  // Threshold of the shop for this document
+	WouldUse    bool    `json:"would_use"`  // analyze-receipt would use the template (confidence ≥ threshold)
+	Forced      bool    `json:"forced,omitempty"`
+	Reason      string  `json:"reason,omitempty"`
+}
+
+// ModeSimulation is the result of one Phase 3 run
+type ModeSimulation struct {
+	Mode        string                       `json:"mode"` // template_only or full
+	Analysis    *storage.OCRAnalysisSnapshot `json:"analysis,omitempty"`
+	Balanced    bool                         `json:"balanced"`
+	Tokens      int                          `json:"tokens"`
+	CostUSD     float64                      `json:"cost_usd"`
+	CostTHB     float64                      `json:"cost_thb"`
+	DurationSec float64                      `json:"duration_sec"`
+	Error       string                       `json:"error,omitempty"` // Run failed - no analysis
+}
+
+// CompareModesResponse compares the full-mode run (B) against the template-only run (A)
+type CompareModesResponse struct {
+	RequestID         string               `json:"request_id"`
+	OriginalRequestID string               `json:"original_request_id"`
+	ShopID            string               `json:"shopid"`
+	Template          CompareModesTemplate `json:"template"`
+	TemplateOnly      ModeSimulation       `json:"template_only"`
+	Full              ModeSimulation       `json:"full"`
+	Identical         bool                 `json:"identical"`            // Same receipt fields, entries and creditor / debtor
+	Receipt           []ReceiptFieldDiff   `json:"receipt"`              // a = template_only, b = full
+	Entries           []EntryDiff          `json:"entries"`              // Deltas = full - template_only
+	Confidence        *ConfidenceCompare   `json:"confidence,omitempty"` // nil = a run failed
+	CostTHBDelta      float64              `json:"cost_thb_delta"`       // full - template_only
+	TokensDelta       int                  `json:"tokens_delta"`         // full - template_only
+	PartyDiffers      bool                 `json:"party_differs"`        // Different creditor / debtor
+	Recommendation    string               `json:"recommendation"`       // Short hint for the threshold
+	Metadata          gin.H                `json:"metadata"`
+}
+
+// CompareModesHandler handles POST /api/v1/results/:request_id/compare-modes
+func CompareModesHandler(c *gin.Context) {
+	originalRequestID := c.Param("request_id")
+
+	var req CompareModesRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+	req.TemplateID = strings.TrimSpace(req.TemplateID)
+	req.Model = strings.TrimSpace(req.Model)
+	if req.Model != "" && !strings.HasPrefix(req.Model, "gemini-") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid model",
+			"message": fmt.Sprintf("Model '%s' ไม่ถูกต้อง การวิเคราะห์บัญชีใช้ Gemini เท่านั้น (เช่น gemini-2.5-pro)", req.Model),
+		})
+		return
+	}
+
+	// Step 1: Stored OCR text
+	record := loadReanalysisRecord(c, originalRequestID)
+	if record == nil {
+		return
+	}
+
+	reqCtx := common.NewRequestContext(record.ShopID)
+	reqCtx.AccountingModel = req.Model
+	c.Set(requestIDContextKey, reqCtx.RequestID)
+	defer recordUsageLedger(c, reqCtx, "compare_modes", record.OCRProvider)
+	defer saveAITraces(reqCtx, "compare_modes")
+	reqCtx.LogInfo("⚖️ Mode comparison of %s | ShopID: %s | template: %q, model: %q", originalRequestID, record.ShopID, req.TemplateID, req.Model)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout())
+	defer cancel()
+
+	// Step 2: Master data + templates
+	masterCache, err := dataStore.GetOrLoadMasterData(ctx, record.ShopID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Failed to load master data",
+			"details":    err.Error(),
+			"request_id": reqCtx.RequestID,
+		})
+		return
+	}
+	applyShopSettings(reqCtx, masterCache.ShopSettings)
+	loadDimensionValues(reqCtx, masterCache)
+	documentTemplates, err := dataStore.ListDocumentTemplates(ctx, record.ShopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to load document templates",
+			"details":    err.Error(),
+			"request_id": reqCtx.RequestID,
+		})
+		return
+	}
+	images, ocrResults, combinedText := reanalysisInputs(record)
+	detectDocumentLanguage(combinedText, reqCtx)
+
+	// Step 3: Template - forced, else the best match whatever its confidence
+	var templateMatchResult processor.TemplateMatchResult
+	if req.TemplateID != "" {
+		for _, t := range documentTemplates {
+			if templateIDString(t["_id"]) == req.TemplateID {
+				description, _ := t["description"].(string)
+				templateMatchResult = processor.TemplateMatchResult{
+					Template:    t,
+					Confidence:  100,
+					Description: description,
+					TemplateID:  t["_id"],
+					Reason:      "ผู้ใช้ระบุ template (compare-modes override)",
+				}
+				break
+			}
+		}
+	} else {
+		templateMatchResult = analyzeService.MatchTemplate(ctx, ocrResults, documentTemplates, reqCtx.Settings.TemplateConfidenceThreshold, reqCtx).Result
+		if err := reqCtx.BudgetError(); err != nil {
+			respondBudgetExceeded(c, reqCtx, err)
+			return
+		}
+	}
+	if templateMatchResult.Template == nil {
+		message := "ไม่พบ template ที่ใกล้เคียงเอกสารนี้ - ไม่มีผล template-only ให้เทียบ (ระบุ template_id เพื่อเทียบกับ template ที่ต้องการ)"
+		if req.TemplateID != "" {
+			message = "ไม่พบ template " + req.TemplateID + " ในร้าน"
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":      "no template to compare",
+			"message":    message,
+			"request_id": reqCtx.RequestID,
+		})
+		return
+	}
+	threshold := reqCtx.Settings.TemplateConfidenceThreshold
+	templateInfo := CompareModesTemplate{
+		TemplateID:  templateIDString(templateMatchResult.TemplateID),
+		Description: templateMatchResult.Description,
+		Confidence:  templateMatchResult.Confidence,
+		Threshold:   threshold,
+		WouldUse:    templateMatchResult.Confidence >= threshold,
+		Forced:      req.TemplateID != "",
+		Reason:      templateMatchResult.Reason,
+	}
+
+	// Step 4: Party (same for both runs)
+	vendorMatchResult, documentDirection, ok := reanalysisParty(c, record, "", masterCache, reqCtx)
+	if !ok {
+		return
+	}
+
+	// Step 5: Phase 3 twice - template-only first, then full mode
+	input := modeSimulationInput{
+		images:              images,
+		ocrResults:          ocrResults,
+		combinedText:        combinedText,
+		masterCache:         masterCache,
+		documentTemplates:   documentTemplates,
+		templateMatchResult: templateMatchResult,
+		vendorMatchResult:   vendorMatchResult,
+		documentDirection:   documentDirection,
+	}
+	templateOnly := simulateMode(ctx, input, ai.TemplateOnlyMode, reqCtx)
+	if err := reqCtx.BudgetError(); err != nil {
+		respondBudgetExceeded(c, reqCtx, err)
+		return
+	}
+	full := simulateMode(ctx, input, ai.FullMode, reqCtx)
+	if err := reqCtx.BudgetError(); err != nil {
+		respondBudgetExceeded(c, reqCtx, err)
+		return
+	}
+
+	// Step 6: Diff (full against template-only)
+	response := CompareModesResponse{
+		RequestID:         reqCtx.RequestID,
+		OriginalRequestID: originalRequestID,
+		ShopID:            record.ShopID,
+		Template:          templateInfo,
+		TemplateOnly:      templateOnly,
+		Full:              full,
+		Receipt:           []ReceiptFieldDiff{},
+		Entries:           []EntryDiff{},
+		CostTHBDelta:      full.CostTHB - templateOnly.CostTHB,
+		TokensDelta:       full.Tokens - templateOnly.Tokens,
+	}
+	if templateOnly.Analysis != nil && full.Analysis != nil {
+		a, b := templateOnly.Analysis, full.Analysis
+		response.Receipt = diffReceiptFields(a.Receipt, b.Receipt)
+		response.Entries = diffEntries(a.Entries, b.Entries)
+		confidence := compareConfidence(a, b)
+		response.Confidence = &confidence
+		response.PartyDiffers = a.CreditorCode != b.CreditorCode || a.DebtorCode != b.DebtorCode
+		response.Identical = len(response.Receipt) == 0 && len(response.Entries) == 0 && !response.PartyDiffers
+	}
+	response.Recommendation = compareModesRecommendation(response)
+	reqCtx.LogInfo("⚖️ %s | template %.1f%% (threshold %.1f%%) | %d entry diff(s) | cost ฿%.4f vs ฿%.4f",
+		response.Recommendation, templateInfo.Confidence, threshold, len(response.Entries), templateOnly.CostTHB, full.CostTHB)
+
+	summary := reqCtx.GetSummary()
+	response.Metadata = gin.H{
+		"processed_at":   time.Now().Format(time.RFC3339),
+		"duration_sec":   summary["total_duration_sec"],
+		"token_usage":    summary["token_usage"],
+		"cost_breakdown": reqCtx.GetCostBreakdown(),
+		"steps":          reqCtx.GetSteps(),
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// modeSimulationInput is what both runs share
+type modeSimulationInput struct {
+	images              []ImageData
+	ocrResults          []PureOCRImageResult
+	combinedText        string
+	masterCache         *storage.MasterDataCache
+	documentTemplates   []bson.M
+	templateMatchResult processor.TemplateMatchResult
+	vendorMatchResult   processor.VendorMatchResult
+	documentDirection   processor.DocumentDirection
+}
+
+// simulateMode runs Phase 3 in one mode through AnalyzeService.Analyze (same corrections as reanalyze, nothing is stored)
+func simulateMode(ctx context.Context, input modeSimulationInput, mode ai.MasterDataMode, reqCtx *common.RequestContext) ModeSimulation {
+	result := ModeSimulation{Mode: string(mode)}
+	started := time.Now()
+	defer func() { result.DurationSec = time.Since(started).Seconds() }()
+
+	templateMatch := TemplateMatchOutcome{Result: input.templateMatchResult, Mode: mode}
+	if mode == ai.TemplateOnlyMode {
+		templateMatch.Template = &templateMatch.Result.Template
+	}
+	// Each run gets its own copy - the prompt builders and rules may modify the vendor result
+	vendorMatchResult := input.vendorMatchResult
+	documentDirection := input.documentDirection
+
+	reqCtx.LogInfo("⚖️ %s run", mode)
+	analysis, err := analyzeService.Analyze(ctx, &AnalysisInput{
+		Request:       ExtractRequest{ShopID: reqCtx.ShopID},
+		MasterCache:   input.masterCache,
+		Templates:     input.documentTemplates,
+		Images:        input.images,
+		OCRResults:    input.ocrResults,
+		TemplateMatch: templateMatch,
+		Vendor:        &vendorMatchResult,
+		Direction:     &documentDirection,
+	}, reqCtx)
+	var tokens *common.TokenUsage
+	var accountingErr *AccountingError
+	if errors.As(err, &accountingErr) {
+		tokens = accountingErr.Tokens
+	} else if analysis != nil {
+		tokens = analysis.Tokens
+	}
+	if tokens != nil {
+		result.Tokens, result.CostUSD, result.CostTHB = tokens.TotalTokens, tokens.CostUSD, tokens.CostTHB
+	}
+	if err != nil {
+		result.Error = err.Error()
+		if accountingErr != nil {
+			result.Error = accountingErr.Err.Error()
+			if accountingErr.Parse {
+				result.Error = "Failed to parse accounting response: " + result.Error
+			}
+		}
+		reqCtx.LogWarning("⚖️ %s run failed: %v", mode, err)
+		return result
+	}
+
+	accountingResponse := analysis.Response
+	vendorMatchResult = analysis.Vendor
+	receipt, _ := accountingResponse["receipt"].(map[string]interface{})
+	if receipt == nil {
+		receipt = map[string]interface{}{}
+	}
+	accountingEntry, _ := accountingResponse["accounting_entry"].(map[string]interface{})
+	if accountingEntry == nil {
+		accountingEntry = map[string]interface{}{}
+	}
+	templateFormulas := analysis.TemplateFormulas
+	rules := analysisEntryRules(analysis, accountingEntry, receipt, input.masterCache)
+	if vendorMatchResult.Found && vendorMatchResult.Party == processor.PartyDebtor {
+		accountingEntry["debtor_code"] = vendorMatchResult.Code
+		accountingEntry["debtor_name"] = vendorMatchResult.Name
+	} else if vendorMatchResult.Found {
+		accountingEntry["creditor_code"] = vendorMatchResult.Code
+		accountingEntry["creditor_name"] = vendorMatchResult.Name
+	} else if creditorObj, ok := accountingResponse["creditor"].(map[string]interface{}); ok {
+		if code := getStringValue(creditorObj, "creditor_code"); code != "" {
+			accountingEntry["creditor_code"] = code
+			accountingEntry["creditor_name"] = getStringValue(creditorObj, "creditor_name")
+		}
+	}
+	if mapping, ok := input.masterCache.VendorAccountMappings[getStringValue(accountingEntry, "creditor_code")]; ok {
+		processor.ApplyLearnedMapping(accountingEntry, mapping, mode == ai.TemplateOnlyMode)
+	}
+	dimensions := assignDimensions(accountingEntry, input.combinedText, reqCtx.Settings, input.masterCache, nil)
+
+	templateMatchResult := input.templateMatchResult
+	confidence := processor.CalculateWeightedConfidence(&templateMatchResult, &vendorMatchResult, accountingEntry, reqCtx)
+	requiresReview := confidence.RequiresReview || rules.requiresReview() ||
+		(templateFormulas != nil && templateFormulas.RequiresReview()) ||
+		(dimensions != nil && dimensions.RequiresReview())
+
+	templateName := ""
+	if mode == ai.TemplateOnlyMode {
+		templateName = templateMatchResult.Description
+	}
+	result.Analysis = ocrAnalysisSnapshot(receipt, accountingEntry, templateName, confidence, requiresReview)
+	result.Balanced = rules.Balanced
+	return result
+}
+
+// compareModesRecommendation summarizes the comparison for threshold tuning
+func compareModesRecommendation(response CompareModesResponse) string {
+	switch {
+	case response.TemplateOnly.Error != "" || response.Full.Error != "":
+		return "incomplete"
+	case response.Identical && response.TemplateOnly.Analysis.RequiresReview == response.Full.Analysis.RequiresReview:
+		return "template_sufficient" // Same result for less cost - the threshold could be lower for this template
+	case !response.TemplateOnly.Balanced && response.Full.Balanced:
+		return "full_mode_better"
+	}
+	return "results_differ" // Review the diff before lowering the threshold
+}
@@ -0,0 +1,112 @@
+// complexity.go - Per-request time limit (max_processing_seconds) and complexity pre-check
+// (see processor/complexity.go)
+//
+// ตรวจ 2 จุด: ก่อนดาวน์โหลด (จำนวนรูป - ยังไม่เสีย token) และหลัง OCR (ความยาวข้อความ - ก่อนเรียก accounting)
+// ประเมินแล้วเกินเวลา → 422 too_complex พร้อมวิธีแบ่งรูปเป็นหลาย request แทนการรอจนหมดเวลา (408)
+
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/gin-gonic/gin"
+)
+
+// processingLimit returns the deadline of an analysis: max_processing_seconds bounded by
+// MIN_PROCESSING_SECONDS and REQUEST_TIMEOUT (0 = REQUEST_TIMEOUT)
+func processingLimit(requestedSeconds int, reqCtx *common.RequestContext) time.Duration {
+	cfg := configs.Get()
+	if requestedSeconds <= 0 {
+		return requestTimeout()
+	}
+	seconds := requestedSeconds
+	switch {
+	case seconds > cfg.RequestTimeout:
+		seconds = cfg.RequestTimeout
+		reqCtx.LogWarning("⏱️  max_processing_seconds %d above the server limit → %ds", requestedSeconds, seconds)
+	case seconds < cfg.MinProcessingSeconds:
+		seconds = cfg.MinProcessingSeconds
+		reqCtx.LogWarning("⏱️  max_processing_seconds %d below the minimum → %ds", requestedSeconds, seconds)
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// complexityHeuristics returns the configured cost factors
+func complexityHeuristics() processor.ComplexityHeuristics {
+	cfg := configs.Get()
+	return processor.ComplexityHeuristics{
+		BaseSeconds:       cfg.ComplexityBaseSeconds,
+		SecondsPerImage:   cfg.ComplexitySecondsPerImage,
+		SecondsPer1KChars: cfg.ComplexitySecondsPer1KChars,
+		WarnRatio:         cfg.ComplexityWarnRatio,
+	}
+}
+
+// preOCRComplexity estimates from the image count (before downloading)
+func preOCRComplexity(references []ImageReference, limit time.Duration) processor.ComplexityEstimate {
+	images := make([]processor.ComplexityImage, len(references))
+	for i := range references {
+		images[i] = processor.ComplexityImage{Index: i, Characters: -1}
+	}
+	return processor.EstimateComplexity(images, 0, limit.Seconds(), complexityHeuristics())
+}
+
+// postOCRComplexity estimates from the OCR text length and the time already spent
+func postOCRComplexity(ocrResults []PureOCRImageResult, reqCtx *common.RequestContext, limit time.Duration) processor.ComplexityEstimate {
+	images := make([]processor.ComplexityImage, 0, len(ocrResults))
+	for _, ocrResult := range ocrResults {
+		characters := 0
+		if ocrResult.Result != nil {
+			characters = len([]rune(ocrResult.Result.RawDocumentText))
+		}
+		images = append(images, processor.ComplexityImage{Index: ocrResult.ImageIndex, Characters: characters})
+	}
+	return processor.EstimateComplexity(images, time.Since(reqCtx.StartTime).Seconds(), limit.Seconds(), complexityHeuristics())
+}
+
+// checkComplexity logs the estimate and writes 422 when it exceeds the limit (ENABLE_COMPLEXITY_CHECK)
+// Returns false when the analysis must stop
+func checkComplexity(c *gin.Context, reqCtx *common.RequestContext, estimate processor.ComplexityEstimate) bool {
+	if complexityAllowed(reqCtx, estimate) {
+		return true
+	}
+	respondTooComplex(c, reqCtx, estimate)
+	return false
+}
+
+// complexityAllowed logs the estimate and reports whether the analysis may continue
+func complexityAllowed(reqCtx *common.RequestContext, estimate processor.ComplexityEstimate) bool {
+	switch estimate.Level {
+	case processor.ComplexityOK:
+		reqCtx.LogInfo("⏱️  Complexity (%s): ~%.0fs of %.0fs", estimate.Stage, estimate.EstimatedSeconds, estimate.LimitSeconds)
+		return true
+	case processor.ComplexityWarning:
+		reqCtx.LogWarning("⏱️  Complexity (%s): %s", estimate.Stage, estimate.Message)
+		return true
+	}
+	if !configs.Get().EnableComplexityCheck {
+		reqCtx.LogWarning("⏱️  Complexity (%s): %s - check disabled, continuing", estimate.Stage, estimate.Message)
+		return true
+	}
+	reqCtx.LogWarning("🛑 Complexity (%s): %s - stopped before the time limit", estimate.Stage, estimate.Message)
+	return false
+}
+
+// respondTooComplex writes 422 with the estimate (after OCR: with the reanalyze URL of the kept OCR text)
+func respondTooComplex(c *gin.Context, reqCtx *common.RequestContext, estimate processor.ComplexityEstimate) {
+	body := gin.H{
+		"error":      "too_complex",
+		"message":    estimate.Message,
+		"complexity": estimate,
+		"request_id": reqCtx.RequestID,
+	}
+	if estimate.Stage == "post_ocr" && configs.OCR_RESULT_TTL_DAYS > 0 {
+		// OCR is kept - the accounting can be re-run with a longer limit without OCR again
+		body["reanalyze_url"] = "/api/v1/results/" + reqCtx.RequestID + "/reanalyze"
+	}
+	c.JSON(http.StatusUnprocessableEntity, body)
+}
@@ -0,0 +1,217 @@
+// compression.go - gzip response compression and chunked streaming of large responses
+//
+// ข้อความ OCR ของ PDF หลายหน้า (include_raw_text, traces) ทำให้ JSON ใหญ่หลาย MB
+// - client ที่ส่ง Accept-Encoding: gzip → บีบอัด response ที่ใหญ่กว่า RESPONSE_COMPRESSION_MIN_BYTES
+// - response ใหญ่ส่งทีละ chunk (responseChunkSize) + flush → client เริ่มรับได้ก่อนเขียนเสร็จ (Transfer-Encoding: chunked)
+// - writeLargeJSON ใส่ Content-Length ให้ (บีบอัดแล้วย้ายไปที่ X-Uncompressed-Content-Length เป็น hint ขนาดจริง)
+// ไฟล์ที่บีบอัดอยู่แล้ว (zip, pdf, xlsx, รูป) ส่งตามเดิม
+
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/gin-gonic/gin"
+)
+
+// responseChunkSize - large bodies are written (and flushed) in pieces of this size
+const responseChunkSize = 32 * 1024
+
+// uncompressedLengthHeader carries the Content-Length of a compressed response before compression
+const uncompressedLengthHeader = "X-Uncompressed-Content-Length"
+
+// incompressibleTypes are content types that are already compressed
+var incompressibleTypes = []string{
+	"application/zip",
+	"application/pdf",
+	"application/gzip",
+	"application/vnd.openxmlformats",
+	"image/",
+	"text/event-stream",
+}
+
+// compressWriter holds the first bytes of the response until it can tell whether to gzip it
+type compressWriter struct {
+	gin.ResponseWriter
+	acceptsGzip bool
+	minBytes    int
+	decided     bool
+	buffer      bytes.Buffer
+	gz          *gzip.Writer
+}
+
+// CompressionMiddleware gzips responses for clients that accept it and streams large bodies in chunks
+func CompressionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := configs.Get()
+		if !cfg.ResponseCompression || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		writer := &compressWriter{
+			ResponseWriter: c.Writer,
+			acceptsGzip:    acceptsGzip(c.GetHeader("Accept-Encoding")),
+			minBytes:       cfg.ResponseCompressionMinBytes,
+		}
+		c.Writer = writer
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		defer func() {
+			writer.finish()
+			c.Writer = writer.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+// writeLargeJSON writes a JSON body with a Content-Length hint (streamed in chunks by CompressionMiddleware)
+func writeLargeJSON(c *gin.Context, status int, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to encode response",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.Header("Content-Length", strconv.Itoa(len(data)))
+	c.Data(status, "application/json; charset=utf-8", data)
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		// Content-Length known (writeLargeJSON, c.Data) → decide now, else wait for minBytes
+		length, err := strconv.Atoi(w.Header().Get("Content-Length"))
+		if err != nil {
+			w.buffer.Write(data)
+			if w.buffer.Len() < w.minBytes {
+				return len(data), nil
+			}
+			w.decide(w.buffer.Len())
+			pending := w.buffer.Bytes()
+			w.buffer = bytes.Buffer{}
+			if _, err := w.writeChunks(pending); err != nil {
+				return 0, err
+			}
+			return len(data), nil
+		}
+		w.decide(length)
+	}
+	return w.writeChunks(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what is buffered (handlers that stream decide the encoding at their first flush)
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(w.minBytes)
+		pending := w.buffer.Bytes()
+		w.buffer = bytes.Buffer{}
+		w.writeChunks(pending)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) Written() bool {
+	return w.buffer.Len() > 0 || w.ResponseWriter.Written()
+}
+
+// decide picks plain or gzip for a body of (at least) length bytes and sets the headers
+func (w *compressWriter) decide(length int) {
+	w.decided = true
+	header := w.Header()
+	status := w.Status()
+	// Headers already sent (WriteHeaderNow) → too late to switch encoding
+	if w.ResponseWriter.Written() {
+		return
+	}
+	if !w.acceptsGzip || length < w.minBytes || header.Get("Content-Encoding") != "" ||
+		status == http.StatusNoContent || status == http.StatusNotModified || incompressible(header.Get("Content-Type")) {
+		return
+	}
+	if contentLength := header.Get("Content-Length"); contentLength != "" {
+		header.Set(uncompressedLengthHeader, contentLength)
+		header.Del("Content-Length")
+	}
+	header.Set("Content-Encoding", "gzip")
+	w.gz, _ = gzip.NewWriterLevel(w.ResponseWriter, gzip.DefaultCompression)
+}
+
+// writeChunks writes data in responseChunkSize pieces, flushing between pieces of large bodies
+func (w *compressWriter) writeChunks(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		size := len(data)
+		if size > responseChunkSize {
+			size = responseChunkSize
+		}
+		var err error
+		if w.gz != nil {
+			_, err = w.gz.Write(data[:size])
+		} else {
+			_, err = w.ResponseWriter.Write(data[:size])
+		}
+		if err != nil {
+			return written, err
+		}
+		written += size
+		data = data[size:]
+		if len(data) > 0 {
+			if w.gz != nil {
+				w.gz.Flush()
+			}
+			w.ResponseWriter.Flush()
+		}
+	}
+	return written, nil
+}
+
+// finish writes a small buffered body as is and closes the gzip stream
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decided = true
+		if w.buffer.Len() > 0 {
+			if w.Header().Get("Content-Length") == "" {
+				w.Header().Set("Content-Length", strconv.Itoa(w.buffer.Len()))
+			}
+			w.ResponseWriter.Write(w.buffer.Bytes())
+		}
+	}
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
+// acceptsGzip reports whether Accept-Encoding allows gzip (q=0 = refused)
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(strings.ToLower(name)) != "gzip" {
+			continue
+		}
+		params = strings.ReplaceAll(params, " ", "")
+		return params != "q=0" && params != "q=0.0" && params != "q=0.00" && params != "q=0.000"
+	}
+	return false
+}
+
+// incompressible reports whether the content type is already compressed
+func incompressible(contentType string) bool {
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
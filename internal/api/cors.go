@@ -0,0 +1,162 @@
+// cors.go - CORS for browser clients (ALLOWED_ORIGINS / CORS_*)
+//
+// - ALLOWED_ORIGINS="*" หรือหลาย origin คั่นด้วยจุลภาค, "https://*.example.com" = ทุก subdomain ของ example.com
+// - origin ที่ไม่อยู่ในรายการไม่ได้ header CORS (browser บล็อกเอง) - request จาก server ไม่มี Origin ไม่กระทบ
+// - preflight ตอบ method ของ route นั้นจริง (จาก router) หรือที่กำหนดใน CORS_ROUTE_METHODS
+// - CORS_ALLOW_CREDENTIALS=true → ส่ง cookie / Authorization ได้ (ต้องระบุ origin ชัดเจน ห้ามใช้ *)
+// ค่าทั้งหมด reload ได้ (ไม่ต้อง restart)
+
+package api
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/gin-gonic/gin"
+)
+
+// corsRoute is a registered route pattern and its methods
+type corsRoute struct {
+	segments []string
+	methods  []string
+}
+
+// CORSMiddleware answers preflight requests and adds the CORS headers for allowed origins
+// router is read on the first request (all routes are registered by then)
+func CORSMiddleware(router *gin.Engine) gin.HandlerFunc {
+	var (
+		once   sync.Once
+		routes map[string]*corsRoute
+	)
+	return func(c *gin.Context) {
+		once.Do(func() { routes = registeredCORSRoutes(router) })
+		cfg := configs.Get()
+		origin := c.GetHeader("Origin")
+		preflight := c.Request.Method == http.MethodOptions
+
+		allowed := false
+		if origin != "" {
+			origins, err := configs.ParseCORSOrigins(cfg.AllowedOrigins)
+			if err != nil {
+				// Validated on load - an invalid reload keeps the previous config
+				log.Printf("⚠️  Ignoring ALLOWED_ORIGINS: %v", err)
+			}
+			allowed = corsOriginAllowed(origins, origin)
+			if len(origins) != 1 || origins[0] != "*" || cfg.CORSAllowCredentials {
+				c.Writer.Header().Add("Vary", "Origin")
+			}
+			if allowed {
+				if origins[0] == "*" && !cfg.CORSAllowCredentials {
+					c.Header("Access-Control-Allow-Origin", "*")
+				} else {
+					c.Header("Access-Control-Allow-Origin", origin)
+				}
+				if cfg.CORSAllowCredentials {
+					c.Header("Access-Control-Allow-Credentials", "true")
+				}
+			}
+		}
+
+		if preflight {
+			if allowed {
+				methods := corsRouteMethods(routes, c.Request.URL.Path, cfg.CORSRouteMethods)
+				c.Header("Access-Control-Allow-Methods", strings.Join(append(methods, http.MethodOptions), ", "))
+				c.Header("Access-Control-Allow-Headers", cfg.CORSAllowedHeaders)
+				c.Header("Access-Control-Max-Age", strconv.Itoa(cfg.CORSMaxAge))
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		if allowed && cfg.CORSExposedHeaders != "" {
+			c.Header("Access-Control-Expose-Headers", cfg.CORSExposedHeaders)
+		}
+		c.Next()
+	}
+}
+
+// corsOriginAllowed reports whether origin matches ALLOWED_ORIGINS ("https://*.example.com" = any subdomain, not example.com itself)
+func corsOriginAllowed(origins []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range origins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		scheme, host, _ := strings.Cut(allowed, "://")
+		domain, wildcard := strings.CutPrefix(host, "*.")
+		if !wildcard {
+			continue
+		}
+		requestScheme, requestHost, _ := strings.Cut(origin, "://")
+		if requestScheme == scheme && strings.HasSuffix(requestHost, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// registeredCORSRoutes groups the router's routes by path
+func registeredCORSRoutes(router *gin.Engine) map[string]*corsRoute {
+	routes := map[string]*corsRoute{}
+	for _, info := range router.Routes() {
+		route, ok := routes[info.Path]
+		if !ok {
+			route = &corsRoute{segments: strings.Split(strings.Trim(info.Path, "/"), "/")}
+			routes[info.Path] = route
+		}
+		route.methods = append(route.methods, info.Method)
+	}
+	for _, route := range routes {
+		sort.Strings(route.methods)
+	}
+	return routes
+}
+
+// corsRouteMethods returns the methods of the route matching path (CORS_ROUTE_METHODS overrides the router)
+// When several patterns match (/results/compare vs /results/:request_id) the most specific one wins
+func corsRouteMethods(routes map[string]*corsRoute, path, overrides string) []string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	bestPath, bestScore := "", -1
+	for routePath, route := range routes {
+		if score := matchRouteSegments(route.segments, segments); score > bestScore {
+			bestPath, bestScore = routePath, score
+		}
+	}
+	if bestScore < 0 {
+		return nil
+	}
+	if custom, err := configs.ParseCORSRouteMethods(overrides); err == nil {
+		if methods, ok := custom[bestPath]; ok {
+			return methods
+		}
+	}
+	return append([]string{}, routes[bestPath].methods...)
+}
+
+// matchRouteSegments returns the number of static segments when path matches the pattern (-1 = no match)
+func matchRouteSegments(pattern, path []string) int {
+	score := 0
+	for i, segment := range pattern {
+		if strings.HasPrefix(segment, "*") {
+			return score
+		}
+		if i >= len(path) {
+			return -1
+		}
+		if strings.HasPrefix(segment, ":") {
+			continue
+		}
+		if segment != path[i] {
+			return -1
+		}
+		score++
+	}
+	if len(pattern) != len(path) {
+		return -1
+	}
+	return score
+}
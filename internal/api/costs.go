@@ -0,0 +1,92 @@
+// costs.go - Usage ledger recording and per-shop cost reporting
+
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// recordUsageLedger writes the actual per-phase usage of a request to the usage ledger
+// Called (deferred) at the end of every AI endpoint - also on failures, since tokens were already billed
+func recordUsageLedger(c *gin.Context, reqCtx *common.RequestContext, endpoint, ocrProvider string) {
+	c.Set(auditCostContextKey, reqCtx.TotalTokens)
+	phaseCosts := reqCtx.GetPhaseCosts()
+
+	var phases []storage.UsageLedgerPhase
+	for _, pc := range phaseCosts {
+		if pc.Actual.TotalTokens == 0 && pc.Actual.CostTHB == 0 {
+			continue // Call was blocked or failed before usage was reported
+		}
+		provider := "gemini" // Template matching and accounting always use Gemini
+		if pc.Phase == common.CostPhaseOCR {
+			provider = ocrProvider
+		}
+		phases = append(phases, storage.UsageLedgerPhase{
+			Phase:        pc.Phase,
+			Provider:     provider,
+			InputTokens:  pc.Actual.InputTokens,
+			OutputTokens: pc.Actual.OutputTokens,
+			TotalTokens:  pc.Actual.TotalTokens,
+			CostUSD:      pc.Actual.CostUSD,
+			CostTHB:      pc.Actual.CostTHB,
+		})
+	}
+	if len(phases) == 0 {
+		return
+	}
+
+	entry := storage.UsageLedgerEntry{
+		RequestID:   reqCtx.RequestID,
+		ShopID:      reqCtx.ShopID,
+		Endpoint:    endpoint,
+		OCRProvider: ocrProvider,
+		StatusCode:  c.Writer.Status(),
+		Phases:      phases,
+	}
+
+	// Write in background - billing data must not delay the response
+	go func() {
+		if err := storage.RecordUsage(entry); err != nil {
+			reqCtx.LogWarning("Failed to record usage ledger: %v", err)
+		}
+	}()
+}
+
+// ShopCostsHandler handles GET /api/v1/shops/:shopid/costs?period=2024-06 (month) or 2024-06-15 (day)
+func ShopCostsHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	period := c.Query("period")
+	if period == "" {
+		period = time.Now().Format("2006-01")
+	}
+
+	var from, to time.Time
+	if month, err := time.ParseInLocation("2006-01", period, time.Local); err == nil {
+		from, to = month, month.AddDate(0, 1, 0)
+	} else if day, err := time.ParseInLocation("2006-01-02", period, time.Local); err == nil {
+		from, to = day, day.AddDate(0, 0, 1)
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid period",
+			"message": "period ต้องอยู่ในรูปแบบ YYYY-MM (รายเดือน) หรือ YYYY-MM-DD (รายวัน)",
+		})
+		return
+	}
+
+	report, err := storage.GetShopCostReport(c.Request.Context(), shopID, period, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load cost report",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
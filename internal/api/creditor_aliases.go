@@ -0,0 +1,136 @@
+// creditor_aliases.go - Creditor aliases (brand / short names checked before fuzzy vendor matching)
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// CreditorAliasRequest registers an alias ("7-Eleven") for a creditor in the shop's master data
+type CreditorAliasRequest struct {
+	Alias        string `json:"alias"`
+	CreditorCode string `json:"creditor_code"`
+}
+
+// CreditorAliasesResponse lists the creditor aliases of a shop
+type CreditorAliasesResponse struct {
+	ShopID  string                  `json:"shopid"`
+	Aliases []storage.CreditorAlias `json:"aliases"`
+}
+
+// GetCreditorAliasesHandler handles GET /api/v1/shops/:shopid/creditor-aliases
+func GetCreditorAliasesHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	aliases, err := storage.GetCreditorAliases(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load creditor aliases",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, CreditorAliasesResponse{ShopID: shopID, Aliases: aliases})
+}
+
+// SaveCreditorAliasHandler handles POST /api/v1/shops/:shopid/creditor-aliases
+// An alias that already exists is moved to the given creditor
+func SaveCreditorAliasHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	var req CreditorAliasRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	req.Alias = strings.Join(strings.Fields(req.Alias), " ")
+	req.CreditorCode = strings.TrimSpace(req.CreditorCode)
+	if req.Alias == "" || req.CreditorCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "alias and creditor_code are required",
+			"message": "กรุณาระบุ alias และ creditor_code",
+		})
+		return
+	}
+	// The alias is the key of DELETE /creditor-aliases/:alias
+	if strings.Contains(req.Alias, "/") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid alias",
+			"message": "alias ต้องไม่มีเครื่องหมาย /",
+		})
+		return
+	}
+
+	masterCache, err := dataStore.GetOrLoadMasterData(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to load master data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	alias := storage.CreditorAlias{ShopID: shopID, Alias: req.Alias, CreditorCode: req.CreditorCode}
+	creditorFound := false
+	for _, creditor := range masterCache.Creditors {
+		if code, ok := creditor["code"].(string); ok && code == req.CreditorCode {
+			alias.CreditorName = extractNameFromNamesArray(creditor)
+			creditorFound = true
+			break
+		}
+	}
+	if !creditorFound {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "creditor not found",
+			"message": "ไม่พบเจ้าหนี้ " + req.CreditorCode + " ในร้าน",
+		})
+		return
+	}
+
+	saved, err := storage.SaveCreditorAlias(alias)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save creditor alias",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Aliases are part of the master data cache - reload on the next request
+	dataStore.InvalidateCache(shopID)
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// DeleteCreditorAliasHandler handles DELETE /api/v1/shops/:shopid/creditor-aliases/:alias
+func DeleteCreditorAliasHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+	alias := c.Param("alias")
+
+	deleted, err := storage.DeleteCreditorAlias(shopID, alias)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to delete creditor alias",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "creditor alias not found",
+			"message": "ไม่พบชื่อเรียก " + alias,
+		})
+		return
+	}
+
+	dataStore.InvalidateCache(shopID)
+	c.JSON(http.StatusOK, gin.H{"shopid": shopID, "alias": alias, "deleted": true})
+}
@@ -0,0 +1,167 @@
+// creditor_branches.go - Branch (สาขา) of the creditor for VAT reporting (analysis step + list / register / delete)
+
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// branchCodePattern - branch codes on tax invoices are 5 digits (00000 = head office)
+var branchCodePattern = regexp.MustCompile(`^\d{5}$`)
+
+// CreditorBranchRequest is the body of PUT /api/v1/shops/:shopid/creditor-branches/:creditor_code/:branch_code
+type CreditorBranchRequest struct {
+	BranchName string `json:"branch_name"`
+}
+
+// CreditorBranchesResponse lists the creditor branches of a shop
+type CreditorBranchesResponse struct {
+	ShopID   string                   `json:"shopid"`
+	Branches []storage.CreditorBranch `json:"branches"`
+}
+
+// applyCreditorBranch sets receipt.vendor_branch and accounting_entry.creditor_branch(_code) from the OCR text
+// countDocument = count the document on the creditor's branch (false for re-analyses of the same document)
+func applyCreditorBranch(reqCtx *common.RequestContext, receipt, accountingEntry map[string]interface{}, ocrText string, masterCache *storage.MasterDataCache, countDocument bool) *processor.CreditorBranchMatch {
+	branch := processor.ExtractBranch(ocrText)
+	if branch == nil {
+		return nil
+	}
+	receipt["vendor_branch"] = branch.BranchCode
+
+	creditorCode := getStringValue(accountingEntry, "creditor_code")
+	if creditorCode == "" {
+		return nil
+	}
+	match := processor.MatchCreditorBranch(creditorCode, *branch, masterCache.CreditorBranches[creditorCode])
+	accountingEntry["creditor_branch_code"] = match.BranchCode
+	accountingEntry["creditor_branch"] = match
+	reqCtx.LogInfo("🏬 Creditor branch: %s สาขา %s (head office=%v, registered=%v) from '%s'",
+		creditorCode, match.BranchCode, match.HeadOffice, match.Registered, match.Text)
+
+	if countDocument {
+		// Write in background - must not delay the response
+		go func() {
+			if err := storage.RecordCreditorBranch(reqCtx.ShopID, creditorCode, match.BranchCode, reqCtx.RequestID); err != nil {
+				reqCtx.LogWarning("Failed to record creditor branch: %v", err)
+			}
+		}()
+	}
+	return &match
+}
+
+// GetCreditorBranchesHandler handles GET /api/v1/shops/:shopid/creditor-branches[?creditor_code=]
+func GetCreditorBranchesHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	branches, err := storage.GetCreditorBranches(c.Request.Context(), shopID, strings.TrimSpace(c.Query("creditor_code")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load creditor branches",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, CreditorBranchesResponse{ShopID: shopID, Branches: branches})
+}
+
+// PutCreditorBranchHandler handles PUT /api/v1/shops/:shopid/creditor-branches/:creditor_code/:branch_code
+func PutCreditorBranchHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+	creditorCode := c.Param("creditor_code")
+	branchCode := c.Param("branch_code")
+
+	if !branchCodePattern.MatchString(branchCode) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid branch_code",
+			"message": "branch_code ต้องเป็นตัวเลข 5 หลัก (สำนักงานใหญ่ = 00000)",
+		})
+		return
+	}
+	var req CreditorBranchRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	masterCache, err := dataStore.GetOrLoadMasterData(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to load master data",
+			"details": err.Error(),
+		})
+		return
+	}
+	creditorFound := false
+	for _, creditor := range masterCache.Creditors {
+		if code, ok := creditor["code"].(string); ok && code == creditorCode {
+			creditorFound = true
+			break
+		}
+	}
+	if !creditorFound {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "creditor not found",
+			"message": "ไม่พบเจ้าหนี้ " + creditorCode + " ในร้าน",
+		})
+		return
+	}
+
+	saved, err := storage.SaveCreditorBranch(storage.CreditorBranch{
+		ShopID:       shopID,
+		CreditorCode: creditorCode,
+		BranchCode:   branchCode,
+		BranchName:   strings.TrimSpace(req.BranchName),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save creditor branch",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Branches are part of the master data cache - reload on the next request
+	dataStore.InvalidateCache(shopID)
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// DeleteCreditorBranchHandler handles DELETE /api/v1/shops/:shopid/creditor-branches/:creditor_code/:branch_code
+func DeleteCreditorBranchHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+	creditorCode := c.Param("creditor_code")
+	branchCode := c.Param("branch_code")
+
+	deleted, err := storage.DeleteCreditorBranch(shopID, creditorCode, branchCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to delete creditor branch",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "creditor branch not found",
+			"message": "ไม่พบสาขา " + branchCode + " ของเจ้าหนี้ " + creditorCode,
+		})
+		return
+	}
+
+	dataStore.InvalidateCache(shopID)
+	c.JSON(http.StatusOK, gin.H{"shopid": shopID, "creditor_code": creditorCode, "branch_code": branchCode, "deleted": true})
+}
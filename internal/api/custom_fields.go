@@ -0,0 +1,68 @@
+// custom_fields.go - Per-shop custom fields read by Phase 3 into receipt.custom_fields
+//
+// ร้านกำหนดฟิลด์เพิ่มเติมเอง (เลขที่ PO, ทะเบียนรถ, เลขมิเตอร์, ...) ใน shopSettings.custom_fields
+// → ใส่ใน schema + prompt ของ Phase 3 → คืนใน receipt.custom_fields และเก็บใน ocrResults (analysis.custom_fields)
+
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+)
+
+// normalizeCustomFields keeps the shop's fields only (every key present, null = not found) and coerces the values to the field type
+func normalizeCustomFields(receipt map[string]interface{}, fields []common.CustomField) {
+	if receipt == nil || len(fields) == 0 {
+		return
+	}
+	values, _ := receipt["custom_fields"].(map[string]interface{})
+	normalized := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		normalized[field.Name] = customFieldValue(values[field.Name], field.Type)
+	}
+	receipt["custom_fields"] = normalized
+}
+
+// customFieldValue converts a value returned by the AI to the field type (nil = empty or not convertible)
+func customFieldValue(value interface{}, fieldType string) interface{} {
+	if value == nil {
+		return nil
+	}
+	text := strings.TrimSpace(fmt.Sprint(value))
+	switch fieldType {
+	case common.CustomFieldNumber, common.CustomFieldInteger:
+		number, ok := value.(float64)
+		if !ok {
+			parsed, err := strconv.ParseFloat(strings.ReplaceAll(text, ",", ""), 64)
+			if err != nil {
+				return nil
+			}
+			number = parsed
+		}
+		if fieldType == common.CustomFieldInteger {
+			return float64(int64(number))
+		}
+		return number
+	case common.CustomFieldBoolean:
+		if b, ok := value.(bool); ok {
+			return b
+		}
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+		return nil
+	case common.CustomFieldDate:
+		if date := processor.NormalizeForeignDate(text, ""); date != "" {
+			return date
+		}
+		return nil
+	}
+	if text == "" {
+		return nil
+	}
+	return text
+}
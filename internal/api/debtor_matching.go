@@ -0,0 +1,71 @@
+// debtor_matching.go - Document direction, debtor pre-matching of sales documents and the self-invoice guard
+// (see processor/document_direction.go, processor/self_invoice.go)
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// detectDocumentDirection compares the shop profile with the OCR text (unknown for shops without a profile)
+func detectDocumentDirection(rawText string, masterCache *storage.MasterDataCache, reqCtx *common.RequestContext) processor.DocumentDirection {
+	if masterCache.ShopProfile == nil {
+		return processor.DocumentDirection{Direction: processor.DirectionUnknown}
+	}
+	direction := processor.DetectDocumentDirection(rawText, masterCache.ShopProfile.GetCompanyName(), masterCache.ShopProfile.Settings.TaxID)
+	if direction.Direction != processor.DirectionUnknown {
+		reqCtx.LogInfo("🧭 Document direction: %s (%s)", direction.Direction, direction.Reason)
+	}
+	return direction
+}
+
+// preMatchSaleDebtor matches the customer of a sales document (our shop is the issuer) against the debtors
+func preMatchSaleDebtor(direction processor.DocumentDirection, masterCache *storage.MasterDataCache, reqCtx *common.RequestContext) processor.VendorMatchResult {
+	reqCtx.LogInfo("🧾 Sales document → matching customer '%s' against %d debtors", direction.CustomerName, len(masterCache.Debtors))
+
+	debtorMatch := processor.MatchDebtor(direction.CustomerName, masterCache.Debtors, direction.CustomerTaxID)
+	if debtorMatch.Found {
+		reqCtx.LogInfo("✅ Debtor matched: '%s' → '%s' (code: %s, method: %s, %.1f%%)",
+			direction.CustomerName, debtorMatch.Name, debtorMatch.Code, debtorMatch.Method, debtorMatch.Similarity)
+	} else {
+		reqCtx.LogInfo("⚠️  No debtor match found for: '%s'", direction.CustomerName)
+	}
+	return debtorMatch
+}
+
+// directionCheck validates the AI's creditor/debtor against the detected direction (nil = direction unknown)
+func directionCheck(direction processor.DocumentDirection, accountingEntry map[string]interface{}, reqCtx *common.RequestContext) *processor.DirectionCheck {
+	check := processor.CheckDirectionChoice(direction, accountingEntry)
+	if check != nil && !check.Agreed {
+		reqCtx.LogWarning("⚠️  Direction check: %s", check.Message)
+	}
+	return check
+}
+
+// selfInvoiceCheck flags the shop's own tax invoice booked as a purchase (nil = vendor is not the shop / no shop profile)
+func selfInvoiceCheck(receipt, accountingEntry map[string]interface{}, masterCache *storage.MasterDataCache, reqCtx *common.RequestContext) *processor.SelfInvoiceResult {
+	if masterCache.ShopProfile == nil {
+		return nil
+	}
+	result := processor.CheckSelfInvoice(receipt, accountingEntry, masterCache.ShopProfile.Settings.TaxID)
+	if result != nil && reqCtx != nil {
+		reqCtx.LogWarning("🪞 Self-invoice: %s", result.Message)
+	}
+	return result
+}
+
+// respondSelfInvoice returns 422 for the shop's own tax invoice when SELF_INVOICE_ACTION=reject
+func respondSelfInvoice(c *gin.Context, reqCtx *common.RequestContext, result *processor.SelfInvoiceResult) {
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":        "self_invoice",
+		"message":      "เอกสารนี้เป็นใบกำกับภาษีที่ร้านออกเอง (เอกสารขาย) ไม่สามารถบันทึกเป็นเอกสารซื้อได้",
+		"details":      result.Message,
+		"self_invoice": result,
+		"request_id":   reqCtx.RequestID,
+	})
+}
@@ -0,0 +1,65 @@
+// deprecation.go - Deprecation / Sunset headers for retiring API versions (API_DEPRECATIONS)
+//
+// API_DEPRECATIONS="/api/v1/analyze-receipt=2027-06-30" → ทุก response ของ route นั้นมี
+//   Deprecation: true, Sunset: <วันที่> และ Link ไปยัง route ใหม่ (rel="successor-version")
+// API_SUNSET_ENFORCED=true → หลังวัน sunset ตอบ 410 Gone แทน (ปิด v1 โดยไม่ต้อง deploy)
+// path เป็นรูปแบบของ gin (เช่น /api/v1/results/:request_id/approve) - ตั้งค่าใหม่มีผลทันที (reload)
+
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/gin-gonic/gin"
+)
+
+// apiSuccessors maps deprecated routes to their replacement (Link header / 410 body)
+var apiSuccessors = map[string]string{
+	"/api/v1/analyze-receipt": "/api/v2/analyze-receipt",
+}
+
+// DeprecationMiddleware adds the deprecation headers of API_DEPRECATIONS and rejects sunset routes (API_SUNSET_ENFORCED)
+func DeprecationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := configs.Get()
+		if cfg.APIDeprecations == "" {
+			c.Next()
+			return
+		}
+		sunsets, err := configs.ParseAPIDeprecations(cfg.APIDeprecations)
+		if err != nil {
+			// Validated on load - an invalid reload keeps the previous config
+			log.Printf("⚠️  Ignoring API_DEPRECATIONS: %v", err)
+			c.Next()
+			return
+		}
+		route := c.FullPath()
+		sunset, deprecated := sunsets[route]
+		if !deprecated {
+			c.Next()
+			return
+		}
+
+		successor := apiSuccessors[route]
+		c.Header("Deprecation", "true")
+		c.Header("Sunset", sunset.Format(http.TimeFormat))
+		if successor != "" {
+			c.Header("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+		}
+
+		if cfg.APISunsetEnforced && !time.Now().Before(sunset) {
+			c.AbortWithStatusJSON(http.StatusGone, gin.H{
+				"error":     "api_sunset",
+				"message":   fmt.Sprintf("%s ถูกยกเลิกแล้วตั้งแต่ %s", route, sunset.Format("2006-01-02")),
+				"sunset":    sunset.Format("2006-01-02"),
+				"successor": successor,
+			})
+			return
+		}
+		c.Next()
+	}
+}
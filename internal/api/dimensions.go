@@ -0,0 +1,203 @@
+// dimensions.go - Dimensions (project / cost center) of journal lines (analysis step + list / save / delete values)
+// (see processor/dimensions.go)
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Values listed per dimension in the Phase 3 prompt (larger lists are matched by keyword / default only)
+const maxPromptDimensionValues = 100
+
+// DimensionRequest is the body of PUT /api/v1/shops/:shopid/dimensions/:key/:code
+type DimensionRequest struct {
+	Name     string   `json:"name"`
+	Keywords []string `json:"keywords,omitempty"` // Text on the document that selects this value
+	Inactive bool     `json:"inactive,omitempty"`
+}
+
+// DimensionsResponse lists the dimensions of a shop and their values
+type DimensionsResponse struct {
+	ShopID      string                       `json:"shopid"`
+	Definitions []common.DimensionDefinition `json:"definitions"` // shopSettings.dimensions
+	Values      []storage.Dimension          `json:"values"`
+}
+
+// loadDimensionValues lists the active values of the shop's dimensions for the Phase 3 prompt
+func loadDimensionValues(reqCtx *common.RequestContext, masterCache *storage.MasterDataCache) {
+	if len(reqCtx.Settings.Dimensions) == 0 {
+		return
+	}
+	reqCtx.DimensionValues = map[string][]string{}
+	for _, definition := range reqCtx.Settings.Dimensions {
+		var options []string
+		for _, value := range masterCache.Dimensions[definition.Key] {
+			if !value.Inactive && len(options) < maxPromptDimensionValues {
+				options = append(options, strings.TrimSpace(value.Code+" "+value.Name))
+			}
+		}
+		reqCtx.DimensionValues[definition.Key] = options
+	}
+}
+
+// assignDimensions sets entries[].dimensions (rule → AI → default) and validates the codes (nil = shop has no dimensions)
+// reqCtx may be nil (document groups)
+func assignDimensions(accountingEntry map[string]interface{}, ocrText string, settings common.RequestSettings, masterCache *storage.MasterDataCache, reqCtx *common.RequestContext) *processor.DimensionResult {
+	result := processor.AssignDimensions(accountingEntry, settings.Dimensions, masterCache.Dimensions, ocrText)
+	if result == nil || reqCtx == nil {
+		return result
+	}
+	for _, assigned := range result.Assigned {
+		reqCtx.LogInfo("🗂️ Dimension %s = %s %s (%s)", assigned.Key, assigned.Code, assigned.Name, assigned.Source)
+	}
+	for _, issue := range result.Issues {
+		reqCtx.LogWarning("🗂️ %s", issue.Message)
+	}
+	return result
+}
+
+// validateApproveDimensions checks the dimension codes of corrected entries against the dimensions collection
+func validateApproveDimensions(index int, dimensions map[string]string, masterCache *storage.MasterDataCache) []string {
+	var issues []string
+	for key, code := range dimensions {
+		found := false
+		for _, value := range masterCache.Dimensions[key] {
+			if value.Code == code {
+				found = !value.Inactive
+				break
+			}
+		}
+		if !found {
+			issues = append(issues, fmt.Sprintf("entries[%d]: ไม่พบ dimension %s %s (หรือปิดใช้งานแล้ว)", index, key, code))
+		}
+	}
+	return issues
+}
+
+// GetDimensionsHandler handles GET /api/v1/shops/:shopid/dimensions[?key=]
+func GetDimensionsHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	values, err := storage.GetDimensions(c.Request.Context(), shopID, strings.TrimSpace(c.Query("key")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load dimensions",
+			"details": err.Error(),
+		})
+		return
+	}
+	response := DimensionsResponse{ShopID: shopID, Definitions: []common.DimensionDefinition{}, Values: values}
+	if settings, err := storage.GetShopSettings(c.Request.Context(), shopID); err == nil && settings != nil && settings.Dimensions != nil {
+		response.Definitions = settings.Dimensions
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// PutDimensionHandler handles PUT /api/v1/shops/:shopid/dimensions/:key/:code
+func PutDimensionHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+	key := c.Param("key")
+	code := strings.TrimSpace(c.Param("code"))
+
+	var req DimensionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	keywords := []string{}
+	for _, keyword := range req.Keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	if code == "" || req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid dimension",
+			"message": "ต้องระบุ code และ name",
+		})
+		return
+	}
+
+	// The dimension must be defined in the shop settings first
+	settings, err := storage.GetShopSettings(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load shop settings",
+			"details": err.Error(),
+		})
+		return
+	}
+	defined := false
+	if settings != nil {
+		for _, definition := range settings.Dimensions {
+			defined = defined || definition.Key == key
+		}
+	}
+	if !defined {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "dimension not defined",
+			"message": fmt.Sprintf("ร้านยังไม่ได้กำหนด dimension %q - เพิ่มใน PUT /api/v1/shops/%s/settings (dimensions) ก่อน", key, shopID),
+		})
+		return
+	}
+
+	saved, err := storage.SaveDimension(storage.Dimension{
+		ShopID:   shopID,
+		Key:      key,
+		Code:     code,
+		Name:     req.Name,
+		Keywords: keywords,
+		Inactive: req.Inactive,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save dimension",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Dimensions are part of the master data cache - reload on the next request
+	dataStore.InvalidateCache(shopID)
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// DeleteDimensionHandler handles DELETE /api/v1/shops/:shopid/dimensions/:key/:code
+func DeleteDimensionHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+	key := c.Param("key")
+	code := c.Param("code")
+
+	deleted, err := storage.DeleteDimension(shopID, key, code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to delete dimension",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "dimension not found",
+			"message": "ไม่พบ " + key + " " + code,
+		})
+		return
+	}
+
+	dataStore.InvalidateCache(shopID)
+	c.JSON(http.StatusOK, gin.H{"shopid": shopID, "key": key, "code": code, "deleted": true})
+}
@@ -0,0 +1,153 @@
+// document_groups.go - One accounting entry per unrelated document in a multi-image request
+//
+// เมื่อ AI ระบุ relationship = separate_receipts จะส่ง document_groups[] (แต่ละกลุ่ม = เอกสาร 1 ใบ + รูปที่เกี่ยวข้อง)
+// receipt / accounting_entry ระดับบนยังคงเป็นเอกสารใบแรก (backward compatible)
+// แต่ละกลุ่มผ่าน VAT enforcement, ใบลดหนี้/เพิ่มหนี้, สินทรัพย์ถาวร, กฎสมุดรายวัน, balance check, ตรวจรหัสบัญชี และคำนวณ confidence แยกกัน
+
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// RelationshipSeparateReceipts - document_analysis.relationship for unrelated documents
+const RelationshipSeparateReceipts = "separate_receipts"
+
+// AccountingEntryGroup is one document of a separate_receipts request (response accounting_entries[])
+type AccountingEntryGroup struct {
+	GroupIndex        int                          `json:"group_index"`
+	ImageIndices      []int                        `json:"image_indices"`
+	Receipt           map[string]interface{}       `json:"receipt"`
+	AccountingEntry   map[string]interface{}       `json:"accounting_entry"`
+	Confidence        map[string]interface{}       `json:"confidence"` // level, score
+	RequiresReview    bool                         `json:"requires_review"`
+	AccountChecks     []processor.AccountCheck     `json:"account_checks,omitempty"`
+	SelfInvoice       *processor.SelfInvoiceResult `json:"self_invoice,omitempty"`
+	BalanceCorrection *processor.BalanceCorrection `json:"balance_correction,omitempty"`
+	VATCheck          *processor.VATMathCheck      `json:"vat_check,omitempty"`
+	VATSplit          *processor.VATSplitResult    `json:"vat_split,omitempty"`
+	Dimensions        *processor.DimensionResult   `json:"dimensions,omitempty"`
+}
+
+// buildAccountingEntryGroups returns one group per document when the AI split the request into
+// separate documents (nil for related images - the single accounting_entry covers them)
+func buildAccountingEntryGroups(accountingResponse map[string]interface{}, masterCache *storage.MasterDataCache, accounts []bson.M, templateMatchResult *processor.TemplateMatchResult, vendorMatchResult *processor.VendorMatchResult, settings common.RequestSettings) []AccountingEntryGroup {
+	documentAnalysis, _ := accountingResponse["document_analysis"].(map[string]interface{})
+	if getStringValue(documentAnalysis, "relationship") != RelationshipSeparateReceipts {
+		return nil
+	}
+	groupsRaw, _ := accountingResponse["document_groups"].([]interface{})
+	if len(groupsRaw) < 2 {
+		return nil
+	}
+	sourceImages, _ := accountingResponse["source_images"].([]interface{})
+
+	var groups []AccountingEntryGroup
+	for i, g := range groupsRaw {
+		groupMap, ok := g.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		receipt, _ := groupMap["receipt"].(map[string]interface{})
+		if receipt == nil {
+			receipt = map[string]interface{}{}
+		}
+		normalizeCustomFields(receipt, settings.CustomFields)
+		entry, _ := groupMap["accounting_entry"].(map[string]interface{})
+		if entry == nil {
+			continue
+		}
+
+		group := AccountingEntryGroup{
+			GroupIndex:      i,
+			ImageIndices:    []int{},
+			Receipt:         receipt,
+			AccountingEntry: entry,
+		}
+		if indices, ok := groupMap["image_indices"].([]interface{}); ok {
+			for _, idx := range indices {
+				if n, ok := imageIndexValue(idx); ok {
+					group.ImageIndices = append(group.ImageIndices, n)
+				}
+			}
+		}
+
+		// Same deterministic post-processing as the primary entry
+		rules := applyEntryRules(entry, receipt, groupSourceImages(sourceImages, group.ImageIndices), "", masterCache, accounts)
+		group.AccountChecks = rules.AccountChecks
+		group.BalanceCorrection = rules.BalanceCorrection
+		group.VATSplit = rules.VATSplit
+		group.SelfInvoice = selfInvoiceCheck(receipt, entry, masterCache, nil)
+		group.VATCheck = vatMathCheck(receipt, entry, settings, nil)
+		// No per-document OCR text - keyword rules do not apply, AI values and defaults do
+		group.Dimensions = assignDimensions(entry, "", settings, masterCache, nil)
+
+		// Vendor pre-matching ran for the first document only
+		groupVendor := vendorMatchResult
+		if i > 0 {
+			groupVendor = nil
+		}
+		confidence := processor.CalculateWeightedConfidence(templateMatchResult, groupVendor, entry, nil)
+		group.Confidence = map[string]interface{}{
+			"level": confidence.OverallLevel,
+			"score": confidence.OverallScore,
+		}
+		group.RequiresReview = confidence.RequiresReview || rules.requiresReview() || group.SelfInvoice != nil ||
+			(group.VATCheck != nil && !group.VATCheck.Agreed) || (group.Dimensions != nil && group.Dimensions.RequiresReview())
+
+		groups = append(groups, group)
+	}
+
+	if len(groups) < 2 {
+		return nil
+	}
+	return groups
+}
+
+// groupSourceImages returns the source_images[] entries of the group's images
+func groupSourceImages(sourceImages []interface{}, imageIndices []int) []interface{} {
+	wanted := map[int]bool{}
+	for _, idx := range imageIndices {
+		wanted[idx] = true
+	}
+	var result []interface{}
+	for _, si := range sourceImages {
+		image, ok := si.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if n, ok := imageIndexValue(image["image_index"]); ok && wanted[n] {
+			result = append(result, image)
+		}
+	}
+	return result
+}
+
+// imageIndexValue reads an image index the AI may return as number or string
+func imageIndexValue(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case float64:
+		return int(n), true
+	case string:
+		i, err := strconv.Atoi(strings.TrimSpace(n))
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// toAnySlice converts image indices to the JSON-decoded representation of document_groups
+func toAnySlice(indices []int) []interface{} {
+	out := make([]interface{}, len(indices))
+	for i, idx := range indices {
+		out[i] = idx
+	}
+	return out
+}
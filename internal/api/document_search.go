@@ -0,0 +1,221 @@
+// document_search.go - Search past documents of a shop by OCR text, vendor or amount
+//
+// ค้นจากข้อความ OCR ที่เก็บไว้ (ocrResults, เก็บ OCR_RESULT_TTL_DAYS วัน) + หัวเอกสารที่ได้หลังวิเคราะห์เสร็จ
+// (ผู้ขาย, เลขผู้เสียภาษี, เลขที่เอกสาร, วันที่, ยอดรวม) เช่น หา "ใบกำกับจากเดือนที่แล้ว"
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// searchSnippetRadius is the number of characters kept on each side of the match
+const searchSnippetRadius = 60
+
+// DocumentSearchResponse lists the documents matching a search (newest first)
+type DocumentSearchResponse struct {
+	ShopID  string              `json:"shopid"`
+	Query   string              `json:"q,omitempty"`
+	Amount  *float64            `json:"amount,omitempty"`
+	Results []DocumentSearchHit `json:"results"`
+}
+
+// DocumentSearchHit is one analyzed request whose document matched
+type DocumentSearchHit struct {
+	RequestID       string                      `json:"request_id"` // Use with POST /api/v1/results/:request_id/reanalyze
+	ParentRequestID string                      `json:"parent_request_id,omitempty"`
+	AnalyzedAt      time.Time                   `json:"analyzed_at"`
+	Status          string                      `json:"status"`            // draft or final (POST /api/v1/results/:request_id/approve)
+	Summary         *storage.OCRDocumentSummary `json:"summary,omitempty"` // nil = analysis did not finish
+	Images          []DocumentSearchImage       `json:"images"`
+}
+
+// DocumentSearchImage is an image of a hit with the text around the match
+type DocumentSearchImage struct {
+	ImageIndex        int    `json:"image_index"`
+	DocumentImageGUID string `json:"documentimageguid,omitempty"`
+	ImageURI          string `json:"imageuri,omitempty"`
+	Snippet           string `json:"snippet,omitempty"` // OCR text around the match (empty = matched on summary / amount)
+}
+
+// ocrDocumentSummary extracts the document header from the receipt section of an analysis
+func ocrDocumentSummary(receipt map[string]interface{}) storage.OCRDocumentSummary {
+	value := func(key string) string {
+		if v := strings.TrimSpace(getStringValue(receipt, key)); v != "N/A" {
+			return v
+		}
+		return ""
+	}
+	return storage.OCRDocumentSummary{
+		VendorName:     value("vendor_name"),
+		VendorTaxID:    value("vendor_tax_id"),
+		DocumentNumber: value("number"),
+		DocumentDate:   value("date"),
+		Total:          getFloatValue(receipt, "total"),
+	}
+}
+
+// storeOCRSummary adds the document header (searchable by vendor / amount) and the accounting result
+// (GET /results/compare) to the OCR text stored in Step 3.25
+func storeOCRSummary(reqCtx *common.RequestContext, receipt map[string]interface{}, analysis *storage.OCRAnalysisSnapshot) {
+	if configs.OCR_RESULT_TTL_DAYS <= 0 {
+		return
+	}
+	summary := ocrDocumentSummary(receipt)
+
+	// Write in background - must not delay the response
+	go func() {
+		if err := dataStore.SetOCRResultSummary(reqCtx.ShopID, reqCtx.RequestID, summary, analysis); err != nil {
+			reqCtx.LogWarning("Failed to store OCR result summary: %v", err)
+		}
+	}()
+}
+
+// searchSnippet returns the text around the first case-insensitive match of q ("" = no match)
+func searchSnippet(text, q string) string {
+	if q == "" {
+		return ""
+	}
+	at := strings.Index(strings.ToLower(text), strings.ToLower(q))
+	if at < 0 {
+		return ""
+	}
+
+	start, end := at, at+len(q)
+	for i := 0; i < searchSnippetRadius && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+	for i := 0; i < searchSnippetRadius && end < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+
+	snippet := strings.Join(strings.Fields(text[start:end]), " ")
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(text) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// SearchDocumentsHandler handles GET /api/v1/shops/:shopid/search?q=ค่าไฟ&amount=1234.50&from=2024-06-01&to=2024-06-30&limit=20
+// q and/or amount required; from/to are inclusive days (default: everything still kept)
+func SearchDocumentsHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+	if configs.OCR_RESULT_TTL_DAYS <= 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "search disabled",
+			"message": "OCR result storage is disabled (set OCR_RESULT_TTL_DAYS > 0)",
+		})
+		return
+	}
+
+	query := storage.OCRSearchQuery{
+		ShopID: shopID,
+		Text:   strings.TrimSpace(c.Query("q")),
+		Limit:  20,
+	}
+	if raw := c.Query("amount"); raw != "" {
+		amount, err := strconv.ParseFloat(strings.ReplaceAll(raw, ",", ""), 64)
+		if err != nil || amount <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid amount",
+				"message": "amount ต้องเป็นตัวเลขมากกว่า 0",
+			})
+			return
+		}
+		query.Amount = &amount
+	}
+	if utf8.RuneCountInString(query.Text) < 2 && query.Amount == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "missing query",
+			"message": "ต้องระบุ q (อย่างน้อย 2 ตัวอักษร) หรือ amount",
+		})
+		return
+	}
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid limit",
+				"message": "limit ต้องเป็นตัวเลข 1-100",
+			})
+			return
+		}
+		query.Limit = limit
+	}
+	if v := c.Query("from"); v != "" {
+		day, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid from",
+				"message": "from ต้องอยู่ในรูปแบบ YYYY-MM-DD",
+			})
+			return
+		}
+		query.From = day
+	}
+	if v := c.Query("to"); v != "" {
+		day, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid to",
+				"message": "to ต้องอยู่ในรูปแบบ YYYY-MM-DD",
+			})
+			return
+		}
+		query.To = day.AddDate(0, 0, 1)
+	}
+
+	records, err := dataStore.SearchOCRResults(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to search documents",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	response := DocumentSearchResponse{
+		ShopID:  shopID,
+		Query:   query.Text,
+		Amount:  query.Amount,
+		Results: make([]DocumentSearchHit, 0, len(records)),
+	}
+	for _, record := range records {
+		hit := DocumentSearchHit{
+			RequestID:       record.RequestID,
+			ParentRequestID: record.ParentRequestID,
+			AnalyzedAt:      record.CreatedAt,
+			Status:          storage.OCRResultStatusDraft,
+			Summary:         record.Summary,
+			Images:          make([]DocumentSearchImage, 0, len(record.Images)),
+		}
+		if record.Status != "" {
+			hit.Status = record.Status
+		}
+		for _, img := range record.Images {
+			hit.Images = append(hit.Images, DocumentSearchImage{
+				ImageIndex:        img.ImageIndex,
+				DocumentImageGUID: img.DocumentImageGUID,
+				ImageURI:          img.ImageURI,
+				Snippet:           searchSnippet(img.RawText, query.Text),
+			})
+		}
+		response.Results = append(response.Results, hit)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,191 @@
+// document_sequence.go - Duplicate / missing invoice numbers per creditor (analysis warning + monthly report)
+//
+// เทียบเลขที่เอกสารกับเอกสารก่อนหน้าของเจ้าหนี้รายเดียวกันใน ocrResults
+// (ผล approve แล้วใช้ค่าที่แก้ไข, re-analyze ของเอกสารเดียวกันนับครั้งเดียว)
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// documentSequenceLookbackMonths - documents before the period compared by the report
+const documentSequenceLookbackMonths = 12
+
+// DocumentSequenceVendor lists the anomalies of one creditor
+type DocumentSequenceVendor struct {
+	CreditorCode string                      `json:"creditor_code,omitempty"`
+	CreditorName string                      `json:"creditor_name"`
+	VendorTaxID  string                      `json:"vendor_tax_id,omitempty"` // Set when the creditor was not matched
+	Documents    int                         `json:"documents"`               // Compared documents (period + lookback)
+	Anomalies    []processor.SequenceAnomaly `json:"anomalies"`
+}
+
+// DocumentSequenceReport is the response of GET /api/v1/shops/:shopid/reports/document-sequence
+type DocumentSequenceReport struct {
+	ShopID     string                   `json:"shopid"`
+	Period     string                   `json:"period"`      // YYYY-MM
+	ComparedTo string                   `json:"compared_to"` // YYYY-MM-DD - history start
+	Vendors    []DocumentSequenceVendor `json:"vendors"`     // Creditors with at least one anomaly in the period
+	Duplicates int                      `json:"duplicates"`
+	Gaps       int                      `json:"gaps"`
+}
+
+// sequenceDocument reads the document number and date of a stored result (false = no number)
+func sequenceDocument(record storage.StoredOCRResult) (processor.SequenceDocument, bool) {
+	document := processor.SequenceDocument{RequestID: record.RequestID}
+	if record.Analysis != nil {
+		document.Number = record.Analysis.ReferenceNumber
+		document.DocumentDate = record.Analysis.DocumentDate
+	}
+	if record.Summary != nil {
+		if document.Number == "" {
+			document.Number = record.Summary.DocumentNumber
+		}
+		if document.DocumentDate == "" {
+			document.DocumentDate = record.Summary.DocumentDate
+		}
+	}
+	document.Number = strings.TrimSpace(document.Number)
+	return document, document.Number != "" && document.Number != "N/A"
+}
+
+// checkDocumentSequence compares the invoice number of a new analysis with the creditor's previous documents
+// Returns nil when the check is off, no creditor was matched or the document has no number
+func checkDocumentSequence(ctx context.Context, results storage.ResultRepo, reqCtx *common.RequestContext, receipt, accountingEntry map[string]interface{}) []processor.SequenceAnomaly {
+	settings := configs.Get()
+	if configs.OCR_RESULT_TTL_DAYS <= 0 || settings.DocumentSequenceHistory <= 0 {
+		return nil
+	}
+	creditorCode := getStringValue(accountingEntry, "creditor_code")
+	if creditorCode == "" {
+		return nil
+	}
+	document := processor.SequenceDocument{
+		RequestID:    reqCtx.RequestID,
+		Number:       strings.TrimSpace(getStringValue(accountingEntry, "reference_number")),
+		DocumentDate: getStringValue(accountingEntry, "document_date"),
+	}
+	if document.Number == "" || document.Number == "N/A" {
+		document.Number = strings.TrimSpace(getStringValue(receipt, "number"))
+	}
+	if document.Number == "" || document.Number == "N/A" {
+		return nil
+	}
+
+	records, err := results.ListOCRResultsByCreditor(ctx, reqCtx.ShopID, creditorCode, settings.DocumentSequenceHistory)
+	if err != nil {
+		reqCtx.LogWarning("Failed to load creditor documents for sequence check: %v", err)
+		return nil
+	}
+	history := []processor.SequenceDocument{}
+	for _, record := range latestOCRResults(records) {
+		if record.RequestID == reqCtx.RequestID {
+			continue
+		}
+		if previous, ok := sequenceDocument(record); ok {
+			history = append(history, previous)
+		}
+	}
+
+	anomalies := processor.CheckDocumentSequence(document, history, settings.DocumentSequenceMaxGap)
+	for _, anomaly := range anomalies {
+		reqCtx.LogWarning("🔢 Document sequence (%s): %s", creditorCode, anomaly.Message)
+	}
+	return anomalies
+}
+
+// DocumentSequenceReportHandler handles GET /api/v1/shops/:shopid/reports/document-sequence?period=2024-06
+func DocumentSequenceReportHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+	period, ok := taxReportPeriod(c)
+	if !ok {
+		return
+	}
+	month, _ := time.Parse("2006-01", period)
+	from := month.AddDate(0, -documentSequenceLookbackMonths, 0)
+	periodStart, periodEnd := month.Format("2006-01-02"), month.AddDate(0, 1, 0).Format("2006-01-02")
+
+	records, ok := reportResults(c, shopID, from, month.AddDate(0, 1, 0))
+	if !ok {
+		return
+	}
+
+	// Step 1: Group the documents by creditor (tax ID when the creditor was not matched)
+	type vendorDocuments struct {
+		vendor    DocumentSequenceVendor
+		documents []processor.SequenceDocument
+	}
+	vendors := map[string]*vendorDocuments{}
+	keys := []string{}
+	for _, record := range records {
+		document, ok := sequenceDocument(record)
+		if !ok || record.Analysis.DebtorCode != "" {
+			continue // Sales documents are numbered by the shop itself
+		}
+		key, vendor := "creditor:"+record.Analysis.CreditorCode, DocumentSequenceVendor{
+			CreditorCode: record.Analysis.CreditorCode,
+			CreditorName: record.Analysis.CreditorName,
+		}
+		if record.Analysis.CreditorCode == "" {
+			taxID := strings.NewReplacer("-", "", " ", "").Replace(getStringValue(record.Analysis.Receipt, "vendor_tax_id"))
+			if !validTaxID(taxID) {
+				continue
+			}
+			key, vendor = "taxid:"+taxID, DocumentSequenceVendor{
+				CreditorName: getStringValue(record.Analysis.Receipt, "vendor_name"),
+				VendorTaxID:  taxID,
+			}
+		}
+		group, seen := vendors[key]
+		if !seen {
+			group = &vendorDocuments{vendor: vendor}
+			vendors[key] = group
+			keys = append(keys, key)
+		}
+		group.documents = append(group.documents, document)
+	}
+	sort.Strings(keys)
+
+	// Step 2: Anomalies with at least one document dated in the period
+	report := DocumentSequenceReport{
+		ShopID:     shopID,
+		Period:     period,
+		ComparedTo: from.Format("2006-01-02"),
+		Vendors:    []DocumentSequenceVendor{},
+	}
+	for _, key := range keys {
+		group := vendors[key]
+		vendor := group.vendor
+		vendor.Documents = len(group.documents)
+		vendor.Anomalies = []processor.SequenceAnomaly{}
+		for _, anomaly := range processor.DetectSequenceAnomalies(group.documents, configs.Get().DocumentSequenceMaxGap) {
+			for _, d := range anomaly.Documents {
+				if d.DocumentDate >= periodStart && d.DocumentDate < periodEnd {
+					vendor.Anomalies = append(vendor.Anomalies, anomaly)
+					if anomaly.Type == processor.SequenceAnomalyDuplicate {
+						report.Duplicates++
+					} else {
+						report.Gaps++
+					}
+					break
+				}
+			}
+		}
+		if len(vendor.Anomalies) > 0 {
+			report.Vendors = append(report.Vendors, vendor)
+		}
+	}
+
+	c.JSON(http.StatusOK, report)
+}
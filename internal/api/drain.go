@@ -0,0 +1,208 @@
+// drain.go - In-flight analysis tracking for graceful shutdown
+//
+// SIGTERM → หยุดรับงานวิเคราะห์ใหม่ (503 + Retry-After, /readyz = not_ready)
+// → รองานที่กำลังทำให้เสร็จภายใน SHUTDOWN_DRAIN_TIMEOUT_SEC
+// → งานที่ยังไม่เสร็จบันทึกลง interruptedAnalyses (payload เดิม)
+// start ครั้งถัดไป → ย้ายเข้า failedRequests (phase shutdown) ให้ client เห็นใน GET /api/v1/failed และ retry ได้
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	draining       atomic.Bool
+	inFlight       = map[uint64]storage.InterruptedAnalysis{}
+	inFlightMu     sync.Mutex
+	inFlightNextID uint64
+)
+
+// DrainMiddleware tracks in-flight analyses and rejects new ones while the server is draining
+func DrainMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if draining.Load() {
+			c.Header("Retry-After", "30")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error":   "server_draining",
+				"message": "เซิร์ฟเวอร์กำลังปิดเพื่อ deploy กรุณาส่งคำขอใหม่อีกครั้ง",
+			})
+			return
+		}
+
+		rawBody, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error":   "Failed to read request body",
+				"details": err.Error(),
+			})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(rawBody))
+
+		record := storage.InterruptedAnalysis{
+			ShopID:    c.Param("shopid"),
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.RequestURI(),
+			Payload:   string(rawBody),
+			StartedAt: time.Now(),
+		}
+		if record.ShopID == "" {
+			var payload struct {
+				ShopID string `json:"shopid"`
+			}
+			if json.Unmarshal(rawBody, &payload) == nil {
+				record.ShopID = payload.ShopID
+			}
+		}
+
+		inFlightMu.Lock()
+		inFlightNextID++
+		id := inFlightNextID
+		inFlight[id] = record
+		inFlightMu.Unlock()
+
+		defer func() {
+			inFlightMu.Lock()
+			delete(inFlight, id)
+			inFlightMu.Unlock()
+		}()
+
+		c.Next()
+	}
+}
+
+// StartDraining stops accepting new analyses (in-flight ones continue)
+func StartDraining() {
+	draining.Store(true)
+}
+
+// IsDraining reports whether the server is shutting down
+func IsDraining() bool {
+	return draining.Load()
+}
+
+// InFlightCount returns the number of analyses still running
+func InFlightCount() int {
+	inFlightMu.Lock()
+	defer inFlightMu.Unlock()
+	return len(inFlight)
+}
+
+// WaitForInFlight waits until all analyses finished or timeout passed, returns how many are still running
+func WaitForInFlight(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		remaining := InFlightCount()
+		if remaining == 0 || time.Now().After(deadline) {
+			return remaining
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// PersistInFlight stores the analyses that are still running so they can be resubmitted after restart
+func PersistInFlight() (int, error) {
+	return persistInFlight(mongoInterruptedAnalysisStore{})
+}
+
+func persistInFlight(store interruptedAnalysisStore) (int, error) {
+	inFlightMu.Lock()
+	records := make([]storage.InterruptedAnalysis, 0, len(inFlight))
+	for _, record := range inFlight {
+		records = append(records, record)
+	}
+	inFlightMu.Unlock()
+
+	if err := store.SaveInterruptedAnalyses(records); err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+// interruptedAnalysisStore is where recovery takes the interrupted analyses from and files them as failures
+type interruptedAnalysisStore interface {
+	TakeInterruptedAnalysis() (*storage.InterruptedAnalysis, error)
+	SaveInterruptedAnalyses(records []storage.InterruptedAnalysis) error
+	SaveFailedRequest(record storage.FailedRequest, ttl time.Duration) (*storage.FailedRequest, error)
+}
+
+// mongoInterruptedAnalysisStore uses the storage package (MongoDB)
+type mongoInterruptedAnalysisStore struct{}
+
+func (mongoInterruptedAnalysisStore) TakeInterruptedAnalysis() (*storage.InterruptedAnalysis, error) {
+	return storage.TakeInterruptedAnalysis()
+}
+
+func (mongoInterruptedAnalysisStore) SaveInterruptedAnalyses(records []storage.InterruptedAnalysis) error {
+	return storage.SaveInterruptedAnalyses(records)
+}
+
+func (mongoInterruptedAnalysisStore) SaveFailedRequest(record storage.FailedRequest, ttl time.Duration) (*storage.FailedRequest, error) {
+	return storage.SaveFailedRequest(record, ttl)
+}
+
+// RecoverInterruptedAnalyses files the analyses cut off by the previous shutdown as failed requests
+// (status failed, phase shutdown) so clients see them in GET /api/v1/failed and can retry them
+func RecoverInterruptedAnalyses() (int, error) {
+	return recoverInterruptedAnalyses(mongoInterruptedAnalysisStore{})
+}
+
+func recoverInterruptedAnalyses(store interruptedAnalysisStore) (int, error) {
+	recovered := 0
+	for {
+		record, err := store.TakeInterruptedAnalysis()
+		if err != nil || record == nil {
+			return recovered, err
+		}
+		failure, ok := interruptedAnalysisFailure(*record)
+		// Kept no longer than the interrupted record would have been
+		ttl := time.Until(record.ExpiresAt)
+		if !ok || ttl <= 0 {
+			continue
+		}
+		if _, err := store.SaveFailedRequest(failure, ttl); err != nil {
+			// Put it back - the next start tries again
+			return recovered, errors.Join(err, store.SaveInterruptedAnalyses([]storage.InterruptedAnalysis{*record}))
+		}
+		recovered++
+	}
+}
+
+// interruptedAnalysisFailure converts an interrupted request into a failed request
+// false = nothing to file (an interrupted retry - its failure is still listed as failed)
+func interruptedAnalysisFailure(record storage.InterruptedAnalysis) (storage.FailedRequest, bool) {
+	requestPath, _, _ := strings.Cut(record.Path, "?")
+	failure := storage.FailedRequest{
+		ShopID:   record.ShopID,
+		Endpoint: path.Base(requestPath), // analyze-receipt (v1 / v2), reanalyze, test-template, ocr, ...
+		Phase:    failurePhaseShutdown,
+		Error:    "interrupted by server shutdown (not finished within SHUTDOWN_DRAIN_TIMEOUT_SEC)",
+		Payload:  record.Payload,
+		Artifacts: map[string]interface{}{
+			"path":           record.Path,
+			"started_at":     record.StartedAt,
+			"interrupted_at": record.InterruptedAt,
+		},
+	}
+	switch failure.Endpoint {
+	case "retry":
+		return failure, false
+	case "reanalyze":
+		// /api/v1/results/:request_id/reanalyze - the retry continues from the stored OCR text
+		failure.OCRRequestID = path.Base(path.Dir(requestPath))
+	}
+	return failure, true
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// fakeInterruptedStore keeps interrupted analyses and failures in memory
+type fakeInterruptedStore struct {
+	interrupted []storage.InterruptedAnalysis
+	failures    []storage.FailedRequest
+	saveErr     error
+}
+
+func (s *fakeInterruptedStore) TakeInterruptedAnalysis() (*storage.InterruptedAnalysis, error) {
+	if len(s.interrupted) == 0 {
+		return nil, nil
+	}
+	record := s.interrupted[0]
+	s.interrupted = s.interrupted[1:]
+	return &record, nil
+}
+
+func (s *fakeInterruptedStore) SaveInterruptedAnalyses(records []storage.InterruptedAnalysis) error {
+	now := time.Now()
+	for _, record := range records {
+		record.InterruptedAt = now
+		record.ExpiresAt = now.Add(7 * 24 * time.Hour)
+		s.interrupted = append(s.interrupted, record)
+	}
+	return nil
+}
+
+func (s *fakeInterruptedStore) SaveFailedRequest(record storage.FailedRequest, ttl time.Duration) (*storage.FailedRequest, error) {
+	if s.saveErr != nil {
+		return nil, s.saveErr
+	}
+	record.Status = storage.FailedRequestStatusFailed
+	record.ExpiresAt = time.Now().Add(ttl)
+	s.failures = append(s.failures, record)
+	return &record, nil
+}
+
+// TestInterruptedAnalysesRecoveredOnRestart - analyses still running at shutdown are listed as failures after the restart
+func TestInterruptedAnalysesRecoveredOnRestart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	release := make(chan struct{})
+	router := gin.New()
+	handler := func(c *gin.Context) {
+		<-release
+		c.Status(http.StatusOK)
+	}
+	router.POST("/api/v1/analyze-receipt", DrainMiddleware(), handler)
+	router.POST("/api/v1/results/:request_id/reanalyze", DrainMiddleware(), handler)
+	router.POST("/api/v1/failed/:id/retry", DrainMiddleware(), handler)
+
+	requests := []struct{ path, body string }{
+		{"/api/v1/analyze-receipt?debug=true", `{"shopid":"s1","imagereferences":[{"imageuri":"https://files.test/a.jpg"}]}`},
+		{"/api/v1/results/req-1/reanalyze", `{"shopid":"s1"}`},
+		{"/api/v1/failed/f-1/retry", ``},
+	}
+	done := make(chan struct{}, len(requests))
+	for _, r := range requests {
+		go func() {
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, r.path, strings.NewReader(r.body)))
+			done <- struct{}{}
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for InFlightCount() < len(requests) {
+		if time.Now().After(deadline) {
+			t.Fatalf("in-flight = %d, want %d", InFlightCount(), len(requests))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Shutdown: the drain window passed with the requests still running
+	store := &fakeInterruptedStore{}
+	saved, err := persistInFlight(store)
+	close(release)
+	for range requests {
+		<-done
+	}
+	if err != nil || saved != len(requests) {
+		t.Fatalf("persistInFlight = %d, %v, want %d", saved, err, len(requests))
+	}
+
+	// Restart
+	recovered, err := recoverInterruptedAnalyses(store)
+	if err != nil {
+		t.Fatalf("recoverInterruptedAnalyses: %v", err)
+	}
+	if recovered != 2 || len(store.interrupted) != 0 {
+		t.Fatalf("recovered = %d (left %d), want 2 (the interrupted retry is not filed again)", recovered, len(store.interrupted))
+	}
+	sort.Slice(store.failures, func(i, j int) bool { return store.failures[i].Endpoint < store.failures[j].Endpoint })
+	analyze, reanalyze := store.failures[0], store.failures[1]
+	if analyze.Endpoint != "analyze-receipt" || analyze.ShopID != "s1" || analyze.Payload != requests[0].body || analyze.OCRRequestID != "" {
+		t.Errorf("analyze-receipt failure = %+v", analyze)
+	}
+	if reanalyze.Endpoint != "reanalyze" || reanalyze.OCRRequestID != "req-1" {
+		t.Errorf("reanalyze failure = %+v, want ocr_request_id req-1", reanalyze)
+	}
+	for _, failure := range store.failures {
+		if failure.Status != storage.FailedRequestStatusFailed || failure.Phase != failurePhaseShutdown {
+			t.Errorf("%s: status %q phase %q, want failed / shutdown", failure.Endpoint, failure.Status, failure.Phase)
+		}
+		if ttl := time.Until(failure.ExpiresAt); ttl <= 0 || ttl > 7*24*time.Hour {
+			t.Errorf("%s: expires in %v, want the remaining lifetime of the interrupted record", failure.Endpoint, ttl)
+		}
+	}
+}
+
+// TestRecoverInterruptedAnalysesKeepsRecordOnError - a record that could not be filed stays for the next start
+func TestRecoverInterruptedAnalysesKeepsRecordOnError(t *testing.T) {
+	store := &fakeInterruptedStore{saveErr: errors.New("mongo down")}
+	store.SaveInterruptedAnalyses([]storage.InterruptedAnalysis{{ShopID: "s1", Path: "/api/v2/analyze-receipt", Payload: "{}"}})
+
+	recovered, err := recoverInterruptedAnalyses(store)
+	if err == nil || !strings.Contains(err.Error(), "mongo down") {
+		t.Fatalf("error = %v, want mongo down", err)
+	}
+	if recovered != 0 || len(store.interrupted) != 1 {
+		t.Fatalf("recovered = %d, left %d, want 0 and the record kept", recovered, len(store.interrupted))
+	}
+}
@@ -0,0 +1,124 @@
+// entry_rules.go - Deterministic post-processing of one accounting entry
+//
+// ใช้กับเอกสารแต่ละใบของ separate_receipts (reanalyze / compare-modes ได้ผลของกฎจาก AnalyzeService.Analyze → analysisEntryRules)
+// ลำดับเดียวกับ AnalyzeReceiptHandler: VAT → แยกฐานภาษี → ใบลดหนี้/เพิ่มหนี้ → สินทรัพย์ถาวร → สมุดรายวัน → ตรวจรหัสบัญชี → balance (+ แนะนำการปรับเศษ)
+
+package api
+
+import (
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// entryRuleResults collects what the rules changed or found
+type entryRuleResults struct {
+	VATEnforcement    *processor.VATEnforcementResult
+	VATSplit          *processor.VATSplitResult // Revenue/expense line split by VAT base (mixed-VAT documents)
+	AdjustmentNote    *processor.AdjustmentNoteResult
+	FixedAssets       *processor.FixedAssetResult
+	AccountChecks     []processor.AccountCheck
+	BalanceCorrection *processor.BalanceCorrection // Suggested (or auto-applied) rounding fix of an unbalanced entry
+	Balanced          bool
+}
+
+// requiresReview reports whether any rule needs an accountant's decision
+func (r entryRuleResults) requiresReview() bool {
+	return (r.VATEnforcement != nil && r.VATEnforcement.RequiresReview()) ||
+		(r.AdjustmentNote != nil && r.AdjustmentNote.NeedsReview) ||
+		(r.FixedAssets != nil && r.FixedAssets.RequiresReview()) ||
+		len(r.AccountChecks) > 0 || !r.Balanced
+}
+
+// applyEntryRules runs the shop's deterministic rules on entry (modified in place) and sets balance_check
+// ocrText is only used to detect credit/debit notes the AI did not label ("" = AI labels only)
+func applyEntryRules(entry, receipt map[string]interface{}, sourceImages []interface{}, ocrText string, masterCache *storage.MasterDataCache, accounts []bson.M) entryRuleResults {
+	var results entryRuleResults
+
+	if masterCache.ShopProfile != nil && masterCache.ShopProfile.VATRegistered != nil {
+		result := processor.EnforceVATRegistration(entry, receipt, accounts, *masterCache.ShopProfile.VATRegistered)
+		results.VATEnforcement = &result
+	}
+	results.VATSplit = processor.SplitMixedVATEntries(entry, receipt, accounts)
+	if noteType, detectedBy := processor.DetectAdjustmentNote(map[string]interface{}{"source_images": sourceImages}, ocrText); noteType != "" {
+		result := processor.ApplyAdjustmentNote(entry, noteType, detectedBy, getStringValue(receipt, "original_document_number"), ocrText)
+		results.AdjustmentNote = &result
+	}
+	if rule, enabled := fixedAssetRule(masterCache); enabled {
+		if result := processor.ApplyFixedAssetRules(entry, accounts, rule); len(result.Lines) > 0 {
+			results.FixedAssets = &result
+		}
+	}
+	if len(masterCache.JournalBookRules) > 0 {
+		response := map[string]interface{}{
+			"receipt":          receipt,
+			"accounting_entry": entry,
+			"source_images":    sourceImages,
+		}
+		entry["journal_book_selection"] = processor.ApplyJournalBookRules(response, masterCache.JournalBookRules, masterCache.JournalBooks)
+	}
+	results.AccountChecks = processor.CheckEntryAccounts(entry, accounts)
+
+	results.checkBalance(entry, receipt, masterCache, accounts)
+	return results
+}
+
+// analysisEntryRules returns what the corrections of Analyze (Steps 6.7 - 6.9) found on the first document
+// and checks the balance of entry the same way as applyEntryRules
+func analysisEntryRules(analysis *Analysis, entry, receipt map[string]interface{}, masterCache *storage.MasterDataCache) entryRuleResults {
+	results := entryRuleResults{
+		VATEnforcement: analysis.VATEnforcement,
+		VATSplit:       analysis.VATSplit,
+		AdjustmentNote: analysis.AdjustmentNote,
+		FixedAssets:    analysis.FixedAssets,
+		AccountChecks:  analysis.AccountChecks,
+	}
+	results.checkBalance(entry, receipt, masterCache, analysis.Accounts)
+	return results
+}
+
+// checkBalance sets balance_check of entry (+ suggested / auto-applied rounding fix when unbalanced)
+func (r *entryRuleResults) checkBalance(entry, receipt map[string]interface{}, masterCache *storage.MasterDataCache, accounts []bson.M) {
+	r.Balanced = setBalanceCheck(entry)
+	if !r.Balanced {
+		r.BalanceCorrection = balanceCorrection(entry, receipt, masterCache, accounts)
+		r.Balanced = r.BalanceCorrection != nil && r.BalanceCorrection.Applied
+	}
+}
+
+// setBalanceCheck validates the double entry of entry and sets balance_check (returns balanced)
+// A line whose debit / credit is not a readable amount (e.g. "12-15") makes the entry unbalanced
+func setBalanceCheck(entry map[string]interface{}) bool {
+	var journalEntries []JournalEntry
+	invalidLines := []int{}
+	if entriesRaw, ok := entry["entries"].([]interface{}); ok {
+		for i, e := range entriesRaw {
+			if entryMap, ok := e.(map[string]interface{}); ok {
+				debit, debitOK := getAmountValue(entryMap, "debit")
+				credit, creditOK := getAmountValue(entryMap, "credit")
+				if !debitOK || !creditOK {
+					invalidLines = append(invalidLines, i)
+				}
+				journalEntries = append(journalEntries, JournalEntry{
+					AccountCode: getStringValue(entryMap, "account_code"),
+					AccountName: getStringValue(entryMap, "account_name"),
+					Debit:       debit,
+					Credit:      credit,
+					Description: getStringValue(entryMap, "description"),
+				})
+			}
+		}
+	}
+	balanced, totalDebit, totalCredit := ValidateDoubleEntry(journalEntries)
+	balanced = balanced && len(invalidLines) == 0
+	check := map[string]interface{}{
+		"balanced":     balanced,
+		"total_debit":  totalDebit.Baht(),
+		"total_credit": totalCredit.Baht(),
+	}
+	if len(invalidLines) > 0 {
+		check["invalid_amount_lines"] = invalidLines
+	}
+	entry["balance_check"] = check
+	return balanced
+}
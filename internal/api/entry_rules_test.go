@@ -0,0 +1,45 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSetBalanceCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		lines       []interface{}
+		wantBalance bool
+		wantInvalid []int
+	}{
+		{
+			name: "balanced formatted amounts",
+			lines: []interface{}{
+				map[string]interface{}{"account_code": "5100", "debit": "1,000.00", "credit": 0.0},
+				map[string]interface{}{"account_code": "2120", "debit": nil, "credit": 1000.0},
+			},
+			wantBalance: true,
+		},
+		{
+			name: "unreadable amount is not zero",
+			lines: []interface{}{
+				map[string]interface{}{"account_code": "5100", "debit": "12-15", "credit": 0.0},
+				map[string]interface{}{"account_code": "2120", "debit": 0.0, "credit": 0.0},
+			},
+			wantInvalid: []int{0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := map[string]interface{}{"entries": tt.lines}
+			if got := setBalanceCheck(entry); got != tt.wantBalance {
+				t.Errorf("balanced = %v, want %v", got, tt.wantBalance)
+			}
+			check, _ := entry["balance_check"].(map[string]interface{})
+			invalid, _ := check["invalid_amount_lines"].([]int)
+			if !reflect.DeepEqual(invalid, tt.wantInvalid) {
+				t.Errorf("invalid_amount_lines = %v, want %v", invalid, tt.wantInvalid)
+			}
+		})
+	}
+}
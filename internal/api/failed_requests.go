@@ -0,0 +1,271 @@
+// failed_requests.go - Dead-letter store for failed analyses (record / list / retry)
+//
+// การวิเคราะห์ที่ล้มเหลว (AI error, JSON จาก AI พัง, timeout) ถูกเก็บใน failedRequests พร้อม payload เดิม
+// retry: ถ้ามีข้อความ OCR เก็บไว้ → ทำต่อจาก template matching + accounting (ไม่ OCR ใหม่)
+// ไม่มี (ล้มเหลวตอน OCR หรือข้อความหมดอายุ) → ส่ง payload เดิมเข้า analyze-receipt ใหม่ทั้งหมด
+
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Failure phases
+const (
+	failurePhaseOCR           = "ocr"
+	failurePhaseTemplateMatch = "template_match"
+	failurePhaseAccounting    = "accounting"
+	failurePhaseShutdown      = "shutdown" // Interrupted by a server shutdown (RecoverInterruptedAnalyses)
+)
+
+// gin context keys shared by the analysis handlers and the retry endpoint
+const (
+	requestIDContextKey      = "request_id"       // RequestID of the analysis that handled the request
+	retryFailureIDContextKey = "retry_failure_id" // Set while retrying - failures update this record instead of adding one
+)
+
+// maxFailureRawResponse limits the raw AI response kept as an artifact
+const maxFailureRawResponse = 20000
+
+// FailedRequestsResponse lists failures
+type FailedRequestsResponse struct {
+	Failures []storage.FailedRequest `json:"failures"`
+}
+
+// analysisFailure describes where and why an analysis failed
+type analysisFailure struct {
+	Endpoint     string // analyze-receipt, reanalyze
+	Phase        string // failurePhase*
+	Payload      interface{}
+	OCRRequestID string // Request whose OCR text is stored ("" = retry must OCR again)
+	Err          error
+	Artifacts    map[string]interface{}
+}
+
+// failurePhaseOfStep maps a RequestContext step name to a failure phase
+func failurePhaseOfStep(step string) string {
+	switch {
+	case step == "download_images" || strings.HasPrefix(step, "pure_ocr"):
+		return failurePhaseOCR
+	case strings.HasPrefix(step, "template_matching"):
+		return failurePhaseTemplateMatch
+	default:
+		return failurePhaseAccounting
+	}
+}
+
+// storedOCRRequestID returns the request whose OCR text a retry can start from ("" = OCR again)
+// analyze-receipt stores the OCR text right after OCR (Step 3.25) when OCR_RESULT_TTL_DAYS > 0
+func storedOCRRequestID(reqCtx *common.RequestContext, phase string) string {
+	if phase == failurePhaseOCR || configs.OCR_RESULT_TTL_DAYS <= 0 {
+		return ""
+	}
+	return reqCtx.RequestID
+}
+
+// failureMatchArtifacts returns the template / vendor match of a failed analysis
+func failureMatchArtifacts(templateMatch *processor.TemplateMatchResult, vendorMatch *processor.VendorMatchResult) map[string]interface{} {
+	artifacts := map[string]interface{}{}
+	if templateMatch != nil && templateMatch.Template != nil {
+		artifacts["template_match"] = map[string]interface{}{
+			"template_id": templateIDString(templateMatch.TemplateID),
+			"description": templateMatch.Description,
+			"confidence":  templateMatch.Confidence,
+		}
+	}
+	if vendorMatch != nil && vendorMatch.Found {
+		artifacts["vendor_match"] = map[string]interface{}{
+			"code":   vendorMatch.Code,
+			"name":   vendorMatch.Name,
+			"method": vendorMatch.Method,
+		}
+	}
+	return artifacts
+}
+
+// truncateString cuts s to max bytes (keeping valid UTF-8)
+func truncateString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return strings.ToValidUTF8(s[:max], "")
+}
+
+// recordFailedRequest stores the failure in the dead-letter store (FAILED_REQUEST_TTL_DAYS)
+// During a retry the retried record is updated instead
+func recordFailedRequest(c *gin.Context, reqCtx *common.RequestContext, failure analysisFailure) {
+	if configs.FAILED_REQUEST_TTL_DAYS <= 0 {
+		return
+	}
+	if failure.Artifacts == nil {
+		failure.Artifacts = map[string]interface{}{}
+	}
+	failure.Artifacts["completed_steps"] = reqCtx.GetPartialSummary()["completed_steps"]
+	errMsg := ""
+	if failure.Err != nil {
+		errMsg = failure.Err.Error()
+	}
+
+	if failureID := c.GetString(retryFailureIDContextKey); failureID != "" {
+		go func() {
+			if err := storage.UpdateFailedRequestFailure(failureID, reqCtx.RequestID, failure.Phase, errMsg, failure.Artifacts); err != nil {
+				reqCtx.LogWarning("Failed to update failed request %s: %v", failureID, err)
+			}
+		}()
+		return
+	}
+
+	payload, err := json.Marshal(failure.Payload)
+	if err != nil {
+		reqCtx.LogWarning("Failed to encode failed request payload: %v", err)
+		return
+	}
+	record := storage.FailedRequest{
+		RequestID:    reqCtx.RequestID,
+		ShopID:       reqCtx.ShopID,
+		Endpoint:     failure.Endpoint,
+		Phase:        failure.Phase,
+		Error:        errMsg,
+		Payload:      string(payload),
+		OCRRequestID: failure.OCRRequestID,
+		Artifacts:    failure.Artifacts,
+	}
+	ttl := time.Duration(configs.FAILED_REQUEST_TTL_DAYS) * 24 * time.Hour
+
+	// Write in background - must not delay the error response
+	go func() {
+		saved, err := storage.SaveFailedRequest(record, ttl)
+		if err != nil {
+			reqCtx.LogWarning("Failed to store failed request: %v", err)
+			return
+		}
+		reqCtx.LogInfo("📥 Failure stored for retry: %s (phase: %s)", saved.FailureID, saved.Phase)
+	}()
+}
+
+// ListFailedRequestsHandler handles GET /api/v1/failed
+func ListFailedRequestsHandler(c *gin.Context) {
+	status := c.DefaultQuery("status", storage.FailedRequestStatusFailed)
+	if status == "all" {
+		status = ""
+	}
+	limit := 50
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid limit",
+				"message": "limit ต้องเป็นตัวเลข 1-500",
+			})
+			return
+		}
+		limit = parsed
+	}
+
+	// Shop keys only see their own failures
+	shopID := c.Query("shopid")
+	if c.GetString(authRoleContextKey) == RoleShop {
+		shopID = c.GetString(authShopIDContextKey)
+	}
+
+	failures, err := storage.ListFailedRequests(c.Request.Context(), shopID, status, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load failed requests",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, FailedRequestsResponse{Failures: failures})
+}
+
+// RetryFailedRequestHandler handles POST /api/v1/failed/:id/retry
+// The response is the normal analyze-receipt / reanalyze response of the retry
+func RetryFailedRequestHandler(c *gin.Context) {
+	failureID := c.Param("id")
+
+	record, err := storage.GetFailedRequest(c.Request.Context(), failureID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load failed request",
+			"details": err.Error(),
+		})
+		return
+	}
+	if record == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "failed request not found",
+			"message": "ไม่พบรายการที่ล้มเหลว (อาจหมดอายุแล้ว หรือ id ไม่ถูกต้อง)",
+		})
+		return
+	}
+	if rejectForeignShop(c, record.ShopID) {
+		return
+	}
+	if record.Status == storage.FailedRequestStatusResolved {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":               "already resolved",
+			"message":             "รายการนี้ retry สำเร็จแล้ว",
+			"resolved_request_id": record.ResolvedRequestID,
+		})
+		return
+	}
+
+	// Resume from the stored OCR text when the failure happened after OCR
+	resumeFromOCR := false
+	if record.OCRRequestID != "" && record.Phase != failurePhaseOCR {
+		stored, err := dataStore.GetOCRResult(c.Request.Context(), record.OCRRequestID)
+		resumeFromOCR = err == nil && stored != nil
+	}
+
+	c.Set(retryFailureIDContextKey, failureID)
+	switch {
+	case resumeFromOCR:
+		var req ReanalyzeRequest
+		if record.Endpoint == "reanalyze" {
+			if err := json.Unmarshal([]byte(record.Payload), &req); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error":   "Failed to decode stored payload",
+					"details": err.Error(),
+				})
+				return
+			}
+		}
+		runReanalysis(c, record.OCRRequestID, req)
+	case record.Endpoint == "analyze-receipt":
+		c.Request.Body = io.NopCloser(strings.NewReader(record.Payload))
+		c.Request.ContentLength = int64(len(record.Payload))
+		AnalyzeReceiptHandler(c)
+	case record.Endpoint == "reanalyze":
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "cannot retry",
+			"message": "ข้อความ OCR ของรายการนี้หมดอายุแล้ว กรุณาส่งเอกสารวิเคราะห์ใหม่",
+		})
+		return
+	default:
+		// Interrupted test-template / ocr / extract / ... - only analyze-receipt and reanalyze are retried here
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "cannot retry",
+			"message": "retry รองรับเฉพาะ analyze-receipt และ reanalyze กรุณาส่ง payload เดิมไปที่ " + record.Endpoint + " อีกครั้ง",
+		})
+		return
+	}
+
+	resolved := c.Writer.Status() == http.StatusOK
+	if err := storage.RecordFailedRequestRetry(failureID, c.GetString(requestIDContextKey), resolved); err != nil {
+		log.Printf("⚠️  Failed to record retry of %s: %v", failureID, err)
+	}
+}
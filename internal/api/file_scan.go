@@ -0,0 +1,139 @@
+// file_scan.go - Malware scan of downloaded / uploaded files before processing (FILE_SCAN_DRIVER)
+//
+// - ทุกไฟล์ของ analyze-receipt (รวม zip และ folder watch), /ocr, /extract, /classify-document, /test-template
+//   ถูกสแกนหลังดาวน์โหลด/อัปโหลด ก่อน OCR หรือส่งต่อ
+// - พบมัลแวร์ → ลบไฟล์ทันทีและตอบ 422 malicious_file (พร้อม signature)
+// - scanner ใช้ไม่ได้ → 503 file_scan_unavailable (FILE_SCAN_FAIL_OPEN=true → ทำต่อและบันทึก status "error")
+// ผลการสแกนทุกไฟล์อยู่ใน metadata.file_scans
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/filescan"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	fileScannerOnce sync.Once
+	fileScanner     filescan.Scanner
+)
+
+// MaliciousFileError is a file the scanner reported as infected
+type MaliciousFileError struct {
+	Scanner   string
+	Signature string
+}
+
+func (e *MaliciousFileError) Error() string {
+	return fmt.Sprintf("malware detected by %s: %s", e.Scanner, e.Signature)
+}
+
+// FileScanUnavailableError is a file that could not be scanned (FILE_SCAN_FAIL_OPEN=false)
+type FileScanUnavailableError struct {
+	Err error
+}
+
+func (e *FileScanUnavailableError) Error() string {
+	return fmt.Sprintf("file scan unavailable: %v", e.Err)
+}
+
+func (e *FileScanUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// documentScanner returns the scanner of FILE_SCAN_DRIVER (nil = scanning off)
+func documentScanner() filescan.Scanner {
+	fileScannerOnce.Do(func() {
+		scanner, err := filescan.New()
+		if err != nil {
+			log.Printf("⚠️  File scanning disabled: %v", err)
+			return
+		}
+		if scanner != nil {
+			log.Printf("🛡️  File scanning enabled (%s)", scanner.Name())
+		}
+		fileScanner = scanner
+	})
+	return fileScanner
+}
+
+// scanDocumentFile scans the file at path and records the result in the request metadata
+// Returns *MaliciousFileError or *FileScanUnavailableError when the file must not be processed
+func scanDocumentFile(ctx context.Context, reqCtx *common.RequestContext, path string, imageIndex int) error {
+	scanner := documentScanner()
+	if scanner == nil {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file for scanning: %w", err)
+	}
+	defer file.Close()
+
+	scan := common.FileScan{ImageIndex: imageIndex, Scanner: scanner.Name(), Status: filescan.StatusClean}
+	if info, err := file.Stat(); err == nil {
+		scan.Bytes = info.Size()
+	}
+	started := time.Now()
+	verdict, err := scanner.Scan(ctx, file)
+	scan.DurationMs = time.Since(started).Milliseconds()
+
+	switch {
+	case err != nil:
+		scan.Status = filescan.StatusError
+		scan.Error = err.Error()
+		reqCtx.RecordFileScan(scan)
+		if configs.Get().FileScanFailOpen {
+			reqCtx.LogWarning("⚠️  File scan failed, continuing (FILE_SCAN_FAIL_OPEN): %v", err)
+			return nil
+		}
+		return &FileScanUnavailableError{Err: err}
+	case verdict.Infected:
+		scan.Status = filescan.StatusInfected
+		scan.Signature = verdict.Signature
+		reqCtx.RecordFileScan(scan)
+		return &MaliciousFileError{Scanner: scanner.Name(), Signature: verdict.Signature}
+	}
+	reqCtx.RecordFileScan(scan)
+	return nil
+}
+
+// respondFileScanRejected writes 422 malicious_file or 503 file_scan_unavailable
+// Returns false when err is not a scan rejection
+func respondFileScanRejected(c *gin.Context, reqCtx *common.RequestContext, err error, imageIndex int) bool {
+	switch scanErr := err.(type) {
+	case *MaliciousFileError:
+		reqCtx.LogWarning("🦠 Request aborted: image %d: %v", imageIndex, scanErr)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":       "malicious_file",
+			"message":     "ตรวจพบมัลแวร์ในไฟล์ที่ส่งมา ระบบลบไฟล์แล้วและไม่ได้ประมวลผล กรุณาตรวจสอบแหล่งที่มาของไฟล์",
+			"scanner":     scanErr.Scanner,
+			"signature":   scanErr.Signature,
+			"image_index": imageIndex,
+			"request_id":  reqCtx.RequestID,
+		})
+	case *FileScanUnavailableError:
+		reqCtx.LogError("Request aborted: image %d: %v", imageIndex, scanErr)
+		c.Header("Retry-After", "30")
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":       "file_scan_unavailable",
+			"message":     "ระบบตรวจไวรัสไม่พร้อมใช้งานชั่วคราว กรุณาส่งใหม่อีกครั้ง",
+			"details":     scanErr.Err.Error(),
+			"image_index": imageIndex,
+			"request_id":  reqCtx.RequestID,
+		})
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,451 @@
+// folder_watch.go - Drop-folder ingestion from Google Drive / Dropbox / SFTP (see internal/folderwatch)
+//
+// PUT /api/v1/shops/:shopid/folder-watch ตั้งโฟลเดอร์ของร้าน (provider + folder + refresh_token หรือ sftp login)
+// runner (StartFolderWatcher) claim โฟลเดอร์ที่ถึงรอบแบบ lease → ดาวน์โหลดไฟล์ใหม่ทีละไฟล์ → analyze-receipt
+// (documentimageguid = ชื่อไฟล์เดิม) → ย้ายไป processed/ หรือ failed/ และบันทึกไฟล์ + request_id ใน folderWatchFiles
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/folderwatch"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+// folderWatchFilesLimit - files returned by GET /api/v1/shops/:shopid/folder-watch/files
+const folderWatchFilesLimit = 100
+
+// FolderWatchRequest is the body of PUT /api/v1/shops/:shopid/folder-watch
+type FolderWatchRequest struct {
+	Provider     string           `json:"provider" binding:"required"` // dropbox, google_drive, sftp
+	Folder       string           `json:"folder"`                      // Dropbox path, Google Drive folder ID or SFTP directory
+	Model        string           `json:"model,omitempty"`             // OCR provider: "gemini" (default) or "mistral"
+	RefreshToken string           `json:"refresh_token,omitempty"`     // Dropbox / Google Drive: required when creating / changing provider - kept otherwise
+	SFTP         *FolderWatchSFTP `json:"sftp,omitempty"`              // Required for provider sftp
+	Enabled      *bool            `json:"enabled,omitempty"`           // Default true
+}
+
+// FolderWatchSFTP is the SFTP login of PUT /api/v1/shops/:shopid/folder-watch
+// password / private_key are kept when omitted on an existing sftp watch
+type FolderWatchSFTP struct {
+	Host               string `json:"host"`
+	Port               int    `json:"port,omitempty"` // Default 22
+	Username           string `json:"username"`
+	Password           string `json:"password,omitempty"`
+	PrivateKey         string `json:"private_key,omitempty"` // PEM, unencrypted
+	HostKeyFingerprint string `json:"host_key_fingerprint"`  // SHA256:... of the server host key (ssh-keygen -lf)
+}
+
+// FolderWatchFilesResponse lists the latest picked-up files of a shop
+type FolderWatchFilesResponse struct {
+	ShopID string                    `json:"shopid"`
+	Files  []storage.FolderWatchFile `json:"files"`
+}
+
+// folderFileOutcome is the result of one picked-up file (moved = counted in processed / failed)
+type folderFileOutcome struct {
+	status string
+	moved  bool
+}
+
+// StartFolderWatcher polls the watched folders in the background (FOLDER_WATCH_POLL_INTERVAL_SEC, 0 = off)
+// Every instance may run it - a folder is polled by one instance at a time (lease)
+func StartFolderWatcher() {
+	if configs.FOLDER_WATCH_POLL_INTERVAL_SEC <= 0 {
+		return
+	}
+	hostname, _ := os.Hostname()
+	workerID := fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8])
+
+	go func() {
+		interval := time.Duration(configs.FOLDER_WATCH_POLL_INTERVAL_SEC) * time.Second
+		for {
+			if IsDraining() {
+				return
+			}
+			watch, err := storage.ClaimFolderWatch(context.Background(), workerID, folderWatchLease())
+			if err != nil {
+				log.Printf("⚠️  Failed to claim folder watch: %v", err)
+			}
+			if watch == nil {
+				time.Sleep(interval)
+				continue
+			}
+			pollFolderWatch(*watch, workerID, interval)
+		}
+	}()
+}
+
+// folderWatchLease - one file must finish within REQUEST_TIMEOUT
+func folderWatchLease() time.Duration {
+	return requestTimeout() + reprocessLeaseMargin
+}
+
+// pollFolderWatch analyzes the new files of a claimed folder (oldest first, FOLDER_WATCH_MAX_FILES per poll)
+func pollFolderWatch(watch storage.FolderWatch, workerID string, interval time.Duration) {
+	processed, failed := 0, 0
+	pollErr := ""
+	defer func() {
+		if err := storage.FinishFolderWatchPoll(watch.ShopID, workerID, interval, processed, failed, pollErr); err != nil {
+			log.Printf("⚠️  Folder watch %s: %v", watch.ShopID, err)
+		}
+	}()
+
+	// Step 1: List the folder
+	folder, err := folderwatch.Open(watch)
+	if err != nil {
+		pollErr = err.Error()
+		log.Printf("⚠️  Folder watch %s (%s): %v", watch.ShopID, watch.Provider, err)
+		return
+	}
+	if closer, ok := folder.(io.Closer); ok {
+		defer closer.Close()
+	}
+	files, err := folder.List(context.Background())
+	if err != nil {
+		pollErr = err.Error()
+		log.Printf("⚠️  Folder watch %s (%s): %v", watch.ShopID, watch.Provider, err)
+		return
+	}
+	if len(files) == 0 {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModifiedAt.Before(files[j].ModifiedAt) })
+	log.Printf("📂 Folder watch %s (%s %s): %d file(s)", watch.ShopID, watch.Provider, watch.Folder, len(files))
+
+	// Step 2: Analyze and move each file
+	maxFiles := configs.Get().FolderWatchMaxFiles
+	for i, file := range files {
+		if i >= maxFiles {
+			log.Printf("📂 Folder watch %s: %d file(s) left for the next poll (FOLDER_WATCH_MAX_FILES)", watch.ShopID, len(files)-i)
+			break
+		}
+		if IsDraining() {
+			break
+		}
+		outcome := processFolderFile(folder, watch, file)
+		if outcome.moved {
+			if outcome.status == storage.FolderWatchFileProcessed {
+				processed++
+			} else {
+				failed++
+			}
+		}
+		if !storage.RenewFolderWatchLease(watch.ShopID, workerID, folderWatchLease()) {
+			log.Printf("⏹️  Folder watch %s: lease lost (deleted or claimed by another instance)", watch.ShopID)
+			return
+		}
+	}
+}
+
+// processFolderFile analyzes one file and moves it to processed/ or failed/
+// A file picked up before is only moved again (the analysis is never repeated)
+func processFolderFile(folder folderwatch.Folder, watch storage.FolderWatch, file folderwatch.File) folderFileOutcome {
+	ctx := context.Background()
+	record, err := storage.StartFolderWatchFile(storage.FolderWatchFile{
+		ShopID:   watch.ShopID,
+		Provider: watch.Provider,
+		FileID:   file.ID,
+		FileName: file.Name,
+	})
+	if errors.Is(err, storage.ErrFolderWatchFileSeen) {
+		switch {
+		case record.Status == storage.FolderWatchFileProcessing && time.Since(record.CreatedAt) > folderWatchLease():
+			// The instance analyzing it stopped - pick it up again on the next poll
+			log.Printf("♻️  Folder watch %s: %s was interrupted, retrying next poll", watch.ShopID, file.Name)
+			if err := storage.ForgetFolderWatchFile(watch.ShopID, watch.Provider, file.ID); err != nil {
+				log.Printf("⚠️  Folder watch %s: %v", watch.ShopID, err)
+			}
+		case record.Status != storage.FolderWatchFileProcessing && record.MovedTo == "":
+			return moveFolderFile(ctx, folder, watch, file, *record)
+		}
+		return folderFileOutcome{}
+	}
+	if err != nil {
+		log.Printf("⚠️  Folder watch %s: %v", watch.ShopID, err)
+		return folderFileOutcome{}
+	}
+
+	if !folderwatch.SupportedFile(file.Name) {
+		record.Status = storage.FolderWatchFileFailed
+		record.Error = "unsupported file type (jpg, png, pdf)"
+		return moveFolderFile(ctx, folder, watch, file, *record)
+	}
+	// Step 1: Download to UPLOAD_DIR (local name never uses the provider's file name)
+	batchID := uuid.New().String()
+	localPath := filepath.Join(configs.UPLOAD_DIR, "folder_"+batchID+strings.ToLower(filepath.Ext(file.Name)))
+	defer os.Remove(localPath)
+	err = downloadFolderFile(ctx, folder, file, localPath)
+	var tooLarge *folderwatch.FileTooLargeError
+	if errors.As(err, &tooLarge) {
+		// Cap applies to the bytes read - the size the provider listed is not trusted
+		record.Status = storage.FolderWatchFileFailed
+		record.Error = tooLarge.Error()
+		return moveFolderFile(ctx, folder, watch, file, *record)
+	}
+	if err != nil {
+		// Provider problem - not the document's fault, retry next poll
+		log.Printf("⚠️  Folder watch %s: %s: %v", watch.ShopID, file.Name, err)
+		if err := storage.ForgetFolderWatchFile(watch.ShopID, watch.Provider, file.ID); err != nil {
+			log.Printf("⚠️  Folder watch %s: %v", watch.ShopID, err)
+		}
+		return folderFileOutcome{}
+	}
+
+	// Step 2: Analyze (same pipeline as analyze-receipt, documentimageguid = original file name)
+	result := analyzeArchiveFile(ctx, ZipAnalysisRequest{ShopID: watch.ShopID, Model: watch.Model}, batchID, 0,
+		extractedArchiveFile{name: file.Name, path: localPath})
+	if result.StatusCode == statusClientClosedRequest || result.StatusCode == http.StatusServiceUnavailable || result.StatusCode == http.StatusTooManyRequests {
+		// Interrupted / overloaded - leave the file in place for the next poll
+		log.Printf("♻️  Folder watch %s: %s not analyzed (HTTP %d), retrying next poll", watch.ShopID, file.Name, result.StatusCode)
+		if err := storage.ForgetFolderWatchFile(watch.ShopID, watch.Provider, file.ID); err != nil {
+			log.Printf("⚠️  Folder watch %s: %v", watch.ShopID, err)
+		}
+		return folderFileOutcome{}
+	}
+	record.RequestID = result.RequestID
+	record.StatusCode = result.StatusCode
+	record.Error = result.Error
+	record.Status = storage.FolderWatchFileFailed
+	if result.Status == "success" {
+		record.Status = storage.FolderWatchFileProcessed
+	}
+	log.Printf("📂 Folder watch %s: %s → %s (request %s, %.1fs)", watch.ShopID, file.Name, record.Status, record.RequestID, result.DurationSec)
+
+	// Step 3: Move to processed/ or failed/
+	return moveFolderFile(ctx, folder, watch, file, *record)
+}
+
+// downloadFolderFile writes the file to localPath
+func downloadFolderFile(ctx context.Context, folder folderwatch.Folder, file folderwatch.File, localPath string) error {
+	ctx, cancel := phaseContext(ctx, phaseDownload)
+	defer cancel()
+	out, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer out.Close()
+	return folder.Download(ctx, file, out)
+}
+
+// moveFolderFile moves the file to the subfolder of its status and stores the outcome
+// (move failure → moved_to stays empty and the next poll moves it again)
+func moveFolderFile(ctx context.Context, folder folderwatch.Folder, watch storage.FolderWatch, file folderwatch.File, record storage.FolderWatchFile) folderFileOutcome {
+	subfolder := folderwatch.ProcessedFolder
+	if record.Status == storage.FolderWatchFileFailed {
+		subfolder = folderwatch.FailedFolder
+	}
+	outcome := folderFileOutcome{status: record.Status}
+	if err := folder.Move(ctx, file, subfolder); err != nil {
+		log.Printf("⚠️  Folder watch %s: failed to move %s to %s/: %v", watch.ShopID, file.Name, subfolder, err)
+	} else {
+		record.MovedTo = subfolder
+		outcome.moved = true
+	}
+	if err := storage.FinishFolderWatchFile(record); err != nil {
+		log.Printf("⚠️  Folder watch %s: %v", watch.ShopID, err)
+	}
+	return outcome
+}
+
+// GetFolderWatchHandler handles GET /api/v1/shops/:shopid/folder-watch
+func GetFolderWatchHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	watch, err := storage.GetFolderWatch(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load folder watch",
+			"details": err.Error(),
+		})
+		return
+	}
+	if watch == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "folder watch not found",
+			"message": "ร้านนี้ยังไม่ได้ตั้งโฟลเดอร์ (PUT /api/v1/shops/:shopid/folder-watch)",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, watch)
+}
+
+// PutFolderWatchHandler handles PUT /api/v1/shops/:shopid/folder-watch
+func PutFolderWatchHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	var req FolderWatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	switch req.Provider {
+	case folderwatch.ProviderDropbox, folderwatch.ProviderGoogleDrive, folderwatch.ProviderSFTP:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":          "invalid provider",
+			"provided_value": req.Provider,
+			"allowed_values": []string{folderwatch.ProviderDropbox, folderwatch.ProviderGoogleDrive, folderwatch.ProviderSFTP},
+		})
+		return
+	}
+	if !folderwatch.Configured(req.Provider) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "provider not configured",
+			"message": fmt.Sprintf("เซิร์ฟเวอร์ยังไม่ได้ตั้ง app credentials ของ %s (DROPBOX_APP_* / GOOGLE_DRIVE_*)", req.Provider),
+		})
+		return
+	}
+	if req.Provider == folderwatch.ProviderGoogleDrive && req.Folder == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "folder is required (Google Drive folder ID)"})
+		return
+	}
+	if req.Model == "" {
+		req.Model = "gemini"
+	}
+	if req.Model != "gemini" && req.Model != "mistral" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":          "invalid model",
+			"provided_value": req.Model,
+			"allowed_values": []string{"gemini", "mistral"},
+		})
+		return
+	}
+
+	existing, err := storage.GetFolderWatch(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load folder watch",
+			"details": err.Error(),
+		})
+		return
+	}
+	watch := storage.FolderWatch{
+		ShopID:   shopID,
+		Provider: req.Provider,
+		Folder:   req.Folder,
+		Model:    req.Model,
+		Enabled:  req.Enabled == nil || *req.Enabled,
+	}
+	if existing != nil && existing.Provider != req.Provider {
+		existing = nil // Secrets of another provider are never reused
+	}
+	if req.Provider == folderwatch.ProviderSFTP {
+		login, err := sftpLogin(req.SFTP, existing)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sftp login", "details": err.Error()})
+			return
+		}
+		watch.SFTP = login
+	} else {
+		watch.RefreshToken = req.RefreshToken
+		if watch.RefreshToken == "" {
+			if existing == nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+				return
+			}
+			watch.RefreshToken = existing.RefreshToken
+		}
+	}
+	if err := storage.SaveFolderWatch(watch); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save folder watch",
+			"details": err.Error(),
+		})
+		return
+	}
+	log.Printf("📂 Folder watch %s set: %s %s (enabled: %v)", shopID, watch.Provider, watch.Folder, watch.Enabled)
+
+	saved, err := storage.GetFolderWatch(c.Request.Context(), shopID)
+	if err != nil || saved == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load folder watch"})
+		return
+	}
+	c.JSON(http.StatusOK, saved)
+}
+
+// sftpLogin validates the SFTP login of the request (password / private key of the existing watch are kept when omitted)
+func sftpLogin(req *FolderWatchSFTP, existing *storage.FolderWatch) (*storage.SFTPLogin, error) {
+	if req == nil {
+		return nil, fmt.Errorf("sftp is required")
+	}
+	login := &storage.SFTPLogin{
+		Host:               strings.TrimSpace(req.Host),
+		Port:               req.Port,
+		Username:           req.Username,
+		Password:           req.Password,
+		PrivateKey:         req.PrivateKey,
+		HostKeyFingerprint: strings.TrimSpace(req.HostKeyFingerprint),
+	}
+	if login.Password == "" && login.PrivateKey == "" && existing != nil && existing.SFTP != nil {
+		login.Password, login.PrivateKey = existing.SFTP.Password, existing.SFTP.PrivateKey
+	}
+	switch {
+	case login.Host == "" || login.Username == "":
+		return nil, fmt.Errorf("sftp.host and sftp.username are required")
+	case login.Port < 0 || login.Port > 65535:
+		return nil, fmt.Errorf("sftp.port must be 1-65535")
+	case login.Password == "" && login.PrivateKey == "":
+		return nil, fmt.Errorf("sftp.password or sftp.private_key is required")
+	case !strings.HasPrefix(login.HostKeyFingerprint, "SHA256:"):
+		return nil, fmt.Errorf("sftp.host_key_fingerprint is required (SHA256:... from ssh-keygen -lf)")
+	}
+	if login.PrivateKey != "" {
+		if _, err := ssh.ParsePrivateKey([]byte(login.PrivateKey)); err != nil {
+			return nil, fmt.Errorf("sftp.private_key: %w", err)
+		}
+	}
+	return login, nil
+}
+
+// DeleteFolderWatchHandler handles DELETE /api/v1/shops/:shopid/folder-watch
+func DeleteFolderWatchHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	deleted, err := storage.DeleteFolderWatch(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to delete folder watch",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "folder watch not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "folder watch deleted", "shopid": shopID})
+}
+
+// ListFolderWatchFilesHandler handles GET /api/v1/shops/:shopid/folder-watch/files
+func ListFolderWatchFilesHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	files, err := storage.ListFolderWatchFiles(c.Request.Context(), shopID, folderWatchFilesLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load folder watch files",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, FolderWatchFilesResponse{ShopID: shopID, Files: files})
+}
@@ -0,0 +1,32 @@
+// foreign_document.go - Language of the document and the currency / date of foreign documents
+// (see processor/language.go, ai/prompt_language.go)
+
+package api
+
+import (
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+)
+
+// detectDocumentLanguage sets reqCtx.DocumentLanguage from the OCR text (Phase 3 adds the language's prompt)
+func detectDocumentLanguage(ocrText string, reqCtx *common.RequestContext) {
+	reqCtx.DocumentLanguage = processor.DetectLanguage(ocrText)
+	if reqCtx.DocumentLanguage != processor.LanguageThai {
+		reqCtx.LogInfo("🌐 Foreign document: %s", reqCtx.DocumentLanguage)
+	}
+}
+
+// foreignDocument normalizes the date / currency of a foreign document's receipt (nil = Thai document)
+func foreignDocument(receipt map[string]interface{}, ocrText string, reqCtx *common.RequestContext) *processor.ForeignDocument {
+	result := processor.ApplyForeignDocument(receipt, reqCtx.DocumentLanguage, ocrText)
+	if result == nil {
+		return nil
+	}
+	if result.DateOriginal != "" {
+		reqCtx.LogInfo("📅 Date %q → %s", result.DateOriginal, getStringValue(receipt, "date"))
+	}
+	if result.RequiresReview() {
+		reqCtx.LogWarning("💱 %s", result.Message)
+	}
+	return result
+}
@@ -5,23 +5,27 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/bosocmputer/account_ocr_gemini/configs"
 	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
 	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
 	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ratelimit"
 	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // --- Image Quality Validation Constants ---
@@ -33,12 +37,17 @@ const (
 	// MAX_NA_PERCENTAGE removed - not all documents have items (e.g., tax receipts, utility bills)
 )
 
+// vendorCandidatesInResponse - ranked creditor alternatives shown in validation.ai_explanation.vendor_matching
+const vendorCandidatesInResponse = 3
+
 // ImageQualityIssue represents a single quality issue found
 type ImageQualityIssue struct {
 	Field        string `json:"field"`
 	Issue        string `json:"issue"`
 	CurrentValue string `json:"current_value,omitempty"`
 	MinRequired  string `json:"min_required,omitempty"`
+	Advice       string `json:"advice,omitempty"`
+	Blocking     bool   `json:"blocking,omitempty"` // Quality check: retake the photo
 }
 
 // FailedImageInfo contains details about an image that failed quality checks
@@ -137,62 +146,44 @@ type ImageReference struct {
 type ExtractRequest struct {
 	ShopID          string           `json:"shopid"`
 	ImageReferences []ImageReference `json:"imagereferences"`
-	Model           string           `json:"model"` // Required: "gemini" or "mistral"
+	Model           string           `json:"model"`                       // Required: "gemini" or "mistral"
+	ClientRequestID string           `json:"client_request_id,omitempty"` // Optional idempotency key (same as Idempotency-Key header)
+	MaxCostTHB      float64          `json:"max_cost_thb,omitempty"`      // Optional budget: abort before an AI call that would exceed it
+	VerifyFields    bool             `json:"verify_fields,omitempty"`     // Re-read total/VAT/date/tax ID in a second pass (also ENABLE_FIELD_VERIFICATION)
+	Handwritten     bool             `json:"handwritten,omitempty"`       // Hint: documents are handwritten (skip detection, always use the handwriting profile)
+	PettyCash       bool             `json:"petty_cash,omitempty"`        // Batch of small receipts → one petty-cash voucher (each image analyzed as its own receipt)
+	StitchImages    bool             `json:"stitch_images,omitempty"`     // Overlapping photos of one long receipt → one merged OCR text (also ENABLE_RECEIPT_STITCHING)
+	IncludeRawText  bool             `json:"include_raw_text,omitempty"`  // Return the OCR text of every image (raw_document_texts)
+	IncludePreview  bool             `json:"include_preview,omitempty"`   // Return thumbnails + positions of total/date/vendor (field_locations)
+	Fields          string           `json:"fields,omitempty"`            // Sparse fieldset: dotted paths to return, e.g. "receipt,accounting_entry,validation.confidence"
+	ResponseProfile string           `json:"response_profile,omitempty"`  // minimal, standard or full (default) - instead of fields
+	Priority        string           `json:"priority,omitempty"`          // interactive (default) or bulk - bulk gets a smaller share of the AI rate limit
+	// Optional time limit (seconds) - bounded by MIN_PROCESSING_SECONDS and REQUEST_TIMEOUT (0 = REQUEST_TIMEOUT)
+	MaxProcessingSeconds int `json:"max_processing_seconds,omitempty"`
 }
 
 // JournalEntry represents an accounting entry
 type JournalEntry struct {
-	AccountCode     string  `json:"account_code"`
-	AccountName     string  `json:"account_name"`
-	Debit           float64 `json:"debit"`
-	Credit          float64 `json:"credit"`
-	Description     string  `json:"description"`
-	SelectionReason string  `json:"selection_reason"` // เหตุผลในการเลือกผังบัญชีนี้
-	SideReason      string  `json:"side_reason"`      // เหตุผลในการลงฝั่ง debit หรือ credit
+	AccountCode     string            `json:"account_code"`
+	AccountName     string            `json:"account_name"`
+	Debit           money.Amount      `json:"debit"`
+	Credit          money.Amount      `json:"credit"`
+	Description     string            `json:"description"`
+	SelectionReason string            `json:"selection_reason"`     // เหตุผลในการเลือกผังบัญชีนี้
+	SideReason      string            `json:"side_reason"`          // เหตุผลในการลงฝั่ง debit หรือ credit
+	VATRate         *float64          `json:"vat_rate,omitempty"`   // ฐานภาษีของรายการ (เอกสารหลายอัตรา VAT)
+	VATExempt       bool              `json:"vat_exempt,omitempty"` // รายการยกเว้น VAT
+	Dimensions      map[string]string `json:"dimensions,omitempty"` // dimension key → รหัส (โครงการ / ศูนย์ต้นทุน)
 }
 
-// ValidateDoubleEntry checks if debits equal credits
-func ValidateDoubleEntry(entries []JournalEntry) (bool, float64, float64) {
-	var totalDebit, totalCredit float64
+// ValidateDoubleEntry checks if debits equal credits (exact - amounts are in satang)
+func ValidateDoubleEntry(entries []JournalEntry) (bool, money.Amount, money.Amount) {
+	var totalDebit, totalCredit money.Amount
 	for _, entry := range entries {
 		totalDebit += entry.Debit
 		totalCredit += entry.Credit
 	}
-
-	// Allow small floating point differences (0.01 baht tolerance)
-	const tolerance = 0.01
-	balanced := (totalDebit-totalCredit) >= -tolerance && (totalDebit-totalCredit) <= tolerance
-	return balanced, totalDebit, totalCredit
-}
-
-// FetchDocumentFormate retrieves accounting templates from documentFormate collection
-// Returns only templates that have details (not empty templates)
-func FetchDocumentFormate(shopID string) ([]bson.M, error) {
-	collection := storage.GetMongoDB().Collection("documentFormate")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Query by shopid and filter out empty templates
-	filter := bson.M{
-		"shopid":  shopID,
-		"details": bson.M{"$exists": true, "$ne": []interface{}{}},
-	}
-
-	cursor, err := collection.Find(ctx, filter)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return []bson.M{}, nil // No templates found is OK
-		}
-		return nil, fmt.Errorf("failed to query documentFormate: %w", err)
-	}
-	defer cursor.Close(ctx)
-
-	var templates []bson.M
-	if err = cursor.All(ctx, &templates); err != nil {
-		return nil, fmt.Errorf("failed to decode documentFormate: %w", err)
-	}
-
-	return templates, nil
+	return totalDebit == totalCredit, totalDebit, totalCredit
 }
 
 // Helper functions for custom prompts extraction
@@ -261,18 +252,31 @@ func getStringValue(m map[string]interface{}, key string) string {
 	return ""
 }
 
+// getFloatValue reads a money amount in baht, formatted strings included (unreadable = 0 - use getAmountValue to report it)
 func getFloatValue(m map[string]interface{}, key string) float64 {
-	if val, ok := m[key].(float64); ok {
-		return val
-	}
-	return 0.0
+	amount, _ := getAmountValue(m, key)
+	return amount.Baht()
+}
+
+// getAmountValue reads a money amount (number or formatted string) rounded to satang (false = not an amount)
+func getAmountValue(m map[string]interface{}, key string) (money.Amount, bool) {
+	return money.Parse(m[key])
 }
 
 // downloadImageFromURL downloads an image or PDF from a URL and saves it to a local file
 // Returns the detected file extension based on Content-Type
-func downloadImageFromURL(imageURL, filename string) (string, error) {
-	// Send GET request to download the file
-	resp, err := http.Get(imageURL)
+func downloadImageFromURL(ctx context.Context, imageURL, filename string) (string, error) {
+	// 🧪 MOCK_AI: allow local files (file://) and placeholders (mock://) - no network in CI
+	if configs.MOCK_AI && (strings.HasPrefix(imageURL, "file://") || strings.HasPrefix(imageURL, "mock://")) {
+		return copyMockImage(imageURL, filename)
+	}
+
+	// Send GET request to download the file (deadline = download phase)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to download file: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to download file: %w", err)
 	}
@@ -283,6 +287,12 @@ func downloadImageFromURL(imageURL, filename string) (string, error) {
 		return "", fmt.Errorf("failed to download file: HTTP %d", resp.StatusCode)
 	}
 
+	// Stop at IMAGE_REJECT_MAX_BYTES - an oversized file is never written to disk (0 = no limit)
+	maxBytes := int64(configs.Get().ImageRejectMaxBytes)
+	if maxBytes > 0 && resp.ContentLength > maxBytes {
+		return "", &PayloadTooLargeError{Reason: payloadReasonImageSize, Value: resp.ContentLength, Limit: maxBytes}
+	}
+
 	// Detect file type from Content-Type header
 	contentType := resp.Header.Get("Content-Type")
 	var fileExt string
@@ -312,11 +322,48 @@ func downloadImageFromURL(imageURL, filename string) (string, error) {
 	defer out.Close()
 
 	// Copy the downloaded content to the file
-	_, err = io.Copy(out, resp.Body)
+	var source io.Reader = resp.Body
+	if maxBytes > 0 {
+		source = io.LimitReader(resp.Body, maxBytes+1)
+	}
+	written, err := io.Copy(out, source)
 	if err != nil {
 		return "", fmt.Errorf("failed to save file: %w", err)
 	}
+	if maxBytes > 0 && written > maxBytes {
+		return "", &PayloadTooLargeError{Reason: payloadReasonImageSize, Value: -1, Limit: maxBytes}
+	}
+
+	return fileExt, nil
+}
+
+// copyMockImage stores a local file (file://path) or an empty placeholder (mock://name) for MOCK_AI runs
+// The mock OCR provider ignores image content, so a placeholder is enough to exercise the pipeline
+func copyMockImage(imageURL, filename string) (string, error) {
+	fileExt := strings.ToLower(filepath.Ext(imageURL))
+	if fileExt != ".pdf" && fileExt != ".png" {
+		fileExt = ".jpg"
+	}
 
+	out, err := os.Create(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	defer out.Close()
+
+	if strings.HasPrefix(imageURL, "mock://") {
+		return fileExt, nil
+	}
+
+	in, err := os.Open(strings.TrimPrefix(imageURL, "file://"))
+	if err != nil {
+		return "", fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer in.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return "", fmt.Errorf("failed to save file: %w", err)
+	}
 	return fileExt, nil
 }
 
@@ -339,6 +386,29 @@ func AnalyzeReceiptHandler(c *gin.Context) {
 	// Check for debug mode from query parameter
 	debugMode := c.Query("debug") == "true"
 
+	// Response fields (fields / response_profile, body or query) - mobile clients select only what they use
+	// include_raw_text / include_preview also work as query parameters
+	if req.Fields == "" {
+		req.Fields = c.Query("fields")
+	}
+	if req.ResponseProfile == "" {
+		req.ResponseProfile = c.Query("response_profile")
+	}
+	if c.Query("include_raw_text") == "true" {
+		req.IncludeRawText = true
+	}
+	if c.Query("include_preview") == "true" {
+		req.IncludePreview = true
+	}
+	responseFields, fieldsErr := parseResponseSelection(req.Fields, req.ResponseProfile)
+	if fieldsErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid response field selection",
+			"details": fieldsErr.Error(),
+		})
+		return
+	}
+
 	// Validate shopid
 	if req.ShopID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -354,6 +424,9 @@ func AnalyzeReceiptHandler(c *gin.Context) {
 		})
 		return
 	}
+	if !checkImageCount(c, "", len(req.ImageReferences)) {
+		return
+	}
 
 	// Validate model (required field)
 	if req.Model == "" {
@@ -383,27 +456,65 @@ func AnalyzeReceiptHandler(c *gin.Context) {
 		return
 	}
 
+	// Incident response flags (admin API): suspended shop → 403, disabled provider → the other provider
+	if rejectForeignShop(c, req.ShopID) || rejectSuspendedShop(c, req.ShopID) {
+		return
+	}
+	requestedModel := req.Model
+	enabledModel, ok := resolveOCRProvider(c, req.Model)
+	if !ok {
+		return
+	}
+	req.Model = enabledModel
+
+	// Validate priority (optional)
+	if !common.ValidPriority(req.Priority) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":          "invalid priority",
+			"message":        fmt.Sprintf("priority '%s' ไม่ถูกต้อง กรุณาเลือก 'interactive' หรือ 'bulk'", req.Priority),
+			"allowed_values": []string{common.PriorityInteractive, common.PriorityBulk},
+		})
+		return
+	}
+
+	// Validate budget (optional)
+	if req.MaxCostTHB < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid max_cost_thb",
+			"message": "max_cost_thb ต้องมากกว่า 0 (หรือไม่ระบุเพื่อไม่จำกัดงบ)",
+		})
+		return
+	}
+	if req.MaxProcessingSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid max_processing_seconds",
+			"message": "max_processing_seconds ต้องมากกว่า 0 (หรือไม่ระบุเพื่อใช้ค่าสูงสุดของระบบ)",
+		})
+		return
+	}
+
 	// Create request context for tracking
 	reqCtx := common.NewRequestContext(req.ShopID)
 	reqCtx.LogInfo("🔷 OCR Provider: %s (from request)", req.Model)
+	c.Set(requestIDContextKey, reqCtx.RequestID)
+	if req.Model != requestedModel {
+		reqCtx.LogWarning("🚨 OCR provider %s is disabled (admin) → using %s", requestedModel, req.Model)
+	}
+	if req.MaxCostTHB > 0 {
+		reqCtx.SetMaxCostTHB(req.MaxCostTHB)
+		reqCtx.LogInfo("💸 Cost budget: ฿%.2f", req.MaxCostTHB)
+	}
+	defer recordUsageLedger(c, reqCtx, "analyze-receipt", req.Model)
+	defer saveAITraces(reqCtx, "analyze-receipt")
 
 	// Log request received with ID for tracking
 	reqCtx.LogInfo("🚀 เริ่มรับคำขอใหม่ | ShopID: %s | เวลา: %s", req.ShopID, time.Now().Format("15:04:05"))
 
 	// ⚡ VALIDATE MASTER DATA FIRST (before any AI processing)
 	// This saves tokens and processing time if master data is missing
-	masterCache, err := storage.GetOrLoadMasterData(req.ShopID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":      "Failed to load master data",
-			"details":    err.Error(),
-			"request_id": reqCtx.RequestID,
-		})
-		return
-	}
-
-	// Check if master data exists
-	if len(masterCache.Accounts) == 0 || len(masterCache.JournalBooks) == 0 {
+	// Document templates (documentFormate) provide AI with predefined accounting entry patterns
+	masterCache, documentTemplates, err := analyzeService.LoadShopData(c.Request.Context(), req.ShopID, reqCtx)
+	if errors.Is(err, errMasterDataMissing) {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"status":  "error",
 			"error":   "master_data_not_found",
@@ -423,23 +534,44 @@ func AnalyzeReceiptHandler(c *gin.Context) {
 		})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to load master data",
+			"details":    err.Error(),
+			"request_id": reqCtx.RequestID,
+		})
+		return
+	}
 
 	reqCtx.LogInfo("✓ Master data validated: %d accounts, %d journal books, %d creditors, %d debtors",
 		len(masterCache.Accounts), len(masterCache.JournalBooks), len(masterCache.Creditors), len(masterCache.Debtors))
 
-	// ⚡ FETCH DOCUMENT FORMATE TEMPLATES (accounting patterns)
-	// This provides AI with predefined accounting entry templates for consistency
-	documentTemplates, err := FetchDocumentFormate(req.ShopID)
-	if err != nil {
-		reqCtx.LogWarning("Failed to fetch documentFormate templates: %v", err)
-		// Continue without templates - AI will work without them
-		documentTemplates = []bson.M{}
-	}
+	// Per-shop model / threshold overrides (shopSettings)
+	applyShopSettings(reqCtx, masterCache.ShopSettings)
+	loadDimensionValues(reqCtx, masterCache)
 	reqCtx.LogInfo("✓ Document templates loaded: %d templates found", len(documentTemplates))
 
-	// Setup timeout context (5 minutes max for very complex receipts)
+	// Setup timeout context (max_processing_seconds or REQUEST_TIMEOUT, default 5 minutes for very complex receipts)
 	// Note: Complex receipts with many items can take 2-3 minutes
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Minute)
+	// Every phase derives its own deadline from ctx (see phase_timeouts.go)
+	totalTimeout := processingLimit(req.MaxProcessingSeconds, reqCtx)
+
+	// Step 1.9: Complexity pre-check from the image count - too many images for the limit → 422 before any token is spent
+	complexity := preOCRComplexity(req.ImageReferences, totalTimeout)
+	if !checkComplexity(c, reqCtx, complexity) {
+		return
+	}
+	// Latest estimate for the timeout response (the monitor goroutine reads it)
+	var latestComplexity atomic.Pointer[processor.ComplexityEstimate]
+	latestComplexity.Store(&complexity)
+
+	// Priority class (interactive / bulk) - every AI call takes its rate-limit share from the request context
+	priority, releasePriority := acquirePriority(c.Request.Context(), reqCtx, req.ShopID, req.Priority)
+	defer releasePriority()
+	reqCtx.Priority = priority
+	c.Request = c.Request.WithContext(ratelimit.WithPriority(c.Request.Context(), priority))
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), totalTimeout)
 	defer cancel()
 
 	// Channel to signal completion
@@ -451,26 +583,44 @@ func AnalyzeReceiptHandler(c *gin.Context) {
 		select {
 		case <-ctx.Done():
 			if ctx.Err() == context.DeadlineExceeded {
-				reqCtx.LogError("⚠️  Request timeout after 5 minutes - receipt too complex")
+				reqCtx.LogError("⚠️  Request timeout after %v - receipt too complex (step: %s)", totalTimeout, reqCtx.CurrentStep)
 
 				// Send timeout response immediately
+				estimate := latestComplexity.Load()
+				suggestions := []string{
+					"Try taking a clearer photo with better lighting",
+					"Ensure the receipt is flat and fully visible",
+					"Consider splitting very long receipts into sections",
+					"Check if the receipt has unusually complex layout",
+				}
+				if len(estimate.SuggestedBatches) > 0 {
+					suggestions = append([]string{fmt.Sprintf("Split the images into %d requests: %v", len(estimate.SuggestedBatches), estimate.SuggestedBatches)}, suggestions...)
+				}
+				if totalTimeout < requestTimeout() {
+					suggestions = append(suggestions, fmt.Sprintf("Increase max_processing_seconds (server limit %v)", requestTimeout()))
+				}
 				c.JSON(http.StatusRequestTimeout, gin.H{
-					"error":   "Processing timeout",
-					"message": "Receipt is too complex and processing exceeded 5 minutes. Please try with a clearer or simpler receipt image.",
-					"details": "This usually happens with very long receipts (50+ items) or low-quality images requiring extensive processing.",
-					"suggestions": []string{
-						"Try taking a clearer photo with better lighting",
-						"Ensure the receipt is flat and fully visible",
-						"Consider splitting very long receipts into sections",
-						"Check if the receipt has unusually complex layout",
-					},
-					"request_id": reqCtx.RequestID,
+					"error":          "Processing timeout",
+					"message":        fmt.Sprintf("Processing exceeded %v (%d image(s), estimated %.0fs).", totalTimeout, estimate.Images, estimate.EstimatedSeconds),
+					"details":        "This usually happens with very long receipts (50+ items) or low-quality images requiring extensive processing.",
+					"suggestions":    suggestions,
+					"complexity":     estimate,
+					"request_id":     reqCtx.RequestID,
+					"phase":          failurePhaseOfStep(reqCtx.CurrentStep),
+					"phase_timeouts": reqCtx.PhaseTimeouts(),
 					"processing_summary": map[string]interface{}{
-						"timeout_at":      "5 minutes",
+						"timeout_at":      totalTimeout.String(),
 						"total_duration":  time.Since(reqCtx.StartTime).Seconds(),
 						"completed_steps": reqCtx.GetPartialSummary(),
 					},
 				})
+				recordFailedRequest(c, reqCtx, analysisFailure{
+					Endpoint:     "analyze-receipt",
+					Phase:        failurePhaseOfStep(reqCtx.CurrentStep),
+					Payload:      req,
+					OCRRequestID: storedOCRRequestID(reqCtx, failurePhaseOfStep(reqCtx.CurrentStep)),
+					Err:          ctx.Err(),
+				})
 
 				timeout <- true
 			}
@@ -479,866 +629,188 @@ func AnalyzeReceiptHandler(c *gin.Context) {
 		}
 	}()
 
-	// Step 2: Download ALL images from Azure Blob Storage
-	reqCtx.StartStep("download_images")
-	reqCtx.LogInfo("Downloading %d image(s)", len(req.ImageReferences))
-
-	type ImageData struct {
-		Filename string
-		Index    int
-		GUID     string
-		URI      string
-	}
-
-	var downloadedImages []ImageData
-
-	for i, imgRef := range req.ImageReferences {
-		if imgRef.ImageURI == "" {
-			reqCtx.EndStep("failed", nil, fmt.Errorf("imageuri is required in imagereferences[%d]", i))
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error":      fmt.Sprintf("imageuri is required in imagereferences[%d]", i),
-				"request_id": reqCtx.RequestID,
-			})
-			return
-		}
-
-		// Generate temporary filename (extension will be set after download)
-		uniqueID := uuid.New().String()
-		tempFilename := filepath.Join(configs.UPLOAD_DIR, fmt.Sprintf("%s_%d.tmp", uniqueID, i))
-
-		// Download file from Azure Blob Storage (supports images and PDFs)
-		fileExt, err := downloadImageFromURL(imgRef.ImageURI, tempFilename)
-		if err != nil {
-			reqCtx.EndStep("failed", nil, err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":       "Failed to download file from Azure Blob Storage",
-				"details":     err.Error(),
-				"image_uri":   imgRef.ImageURI,
-				"image_index": i,
-				"request_id":  reqCtx.RequestID,
-			})
-			return
-		}
-
-		// Rename file with correct extension
-		finalFilename := filepath.Join(configs.UPLOAD_DIR, fmt.Sprintf("%s_%d%s", uniqueID, i, fileExt))
-		if err := os.Rename(tempFilename, finalFilename); err != nil {
-			os.Remove(tempFilename) // cleanup
-			reqCtx.EndStep("failed", nil, err)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":      "Failed to save downloaded file",
-				"details":    err.Error(),
-				"request_id": reqCtx.RequestID,
-			})
-			return
+	// requestAborted reports whether the request deadline passed or the client went away
+	// On deadline the monitor above writes the response - wait for it so nothing is written twice
+	// On disconnect the AI calls were cancelled with the request context - stop without spending more tokens
+	requestAborted := func() bool {
+		switch ctx.Err() {
+		case nil:
+			return false
+		case context.DeadlineExceeded:
+			<-timeout
+		default:
+			reqCtx.LogWarning("🔌 Client disconnected during %s - analysis stopped", reqCtx.CurrentStep)
+			c.AbortWithStatus(statusClientClosedRequest)
 		}
-
-		reqCtx.LogInfo("Downloaded file %d: %s (type: %s)", i, filepath.Base(finalFilename), fileExt)
-
-		downloadedImages = append(downloadedImages, ImageData{
-			Filename: finalFilename,
-			Index:    i,
-			GUID:     imgRef.DocumentImageGUID,
-			URI:      imgRef.ImageURI,
-		})
+		return true
+	}
+
+	// Step 2-10: Download → OCR → template match → accounting → validation → response (analyze_pipeline.go)
+	result, err := analyzeService.Run(ctx, &AnalyzeRun{
+		Request:        req,
+		RequestedModel: requestedModel,
+		MasterCache:    masterCache,
+		Templates:      documentTemplates,
+		Limit:          totalTimeout,
+		Debug:          debugMode,
+		OnComplexity: func(estimate processor.ComplexityEstimate) {
+			latestComplexity.Store(&estimate)
+		},
+	}, reqCtx)
+	if err != nil {
+		respondAnalyzeError(c, reqCtx, req, result, err, requestAborted)
+		return
 	}
 
-	reqCtx.LogInfo("✓ Downloaded %d image(s) successfully", len(downloadedImages))
-	reqCtx.EndStep("success", nil, nil)
+	// Record template matching outcome for coverage reports and template suggestions
+	recordDocumentAnalytics(reqCtx, result.Analytics)
+	// Document header for GET /shops/:shopid/search (vendor, number, total) + result for GET /results/compare
+	validation := result.Validation
+	storeOCRSummary(reqCtx, validation.Receipt,
+		ocrAnalysisSnapshot(validation.Receipt, validation.AccountingEntry, result.Analytics.TemplateName, validation.Confidence, result.Analytics.RequiresReview))
 
-	// Auto-cleanup all downloaded files
-	defer func() {
-		for _, img := range downloadedImages {
-			if err := os.Remove(img.Filename); err != nil {
-				reqCtx.LogWarning("Failed to delete temporary file %s: %v", img.Filename, err)
-			}
-		}
-	}()
-
-	// Step 3: Process PURE OCR for ALL images (NEW OPTIMIZED VERSION)
-	// Changed from full structured extraction to raw text only - saves ~25,000 tokens per image!
-	reqCtx.StartStep("pure_ocr_extraction_all")
-	reqCtx.LogInfo("Pure OCR extraction (raw text only) for %d image(s)", len(downloadedImages))
-
-	// Check if we should continue (not timed out)
+	// Signal completion
 	select {
-	case <-timeout:
-		reqCtx.EndStep("cancelled", nil, fmt.Errorf("timeout before pure OCR"))
-		return
+	case done <- true:
+		// Successfully signaled
 	default:
-		// Continue
-	}
-
-	type PureOCRImageResult struct {
-		ImageIndex int
-		Result     *ai.SimpleOCRResult
-		Tokens     *common.TokenUsage
-		Error      error
+		// Channel might be closed or blocked
 	}
 
-	var pureOCRResults []PureOCRImageResult
-	var totalPureOCRTokens common.TokenUsage
-
-	// ⚡ PARALLEL PROCESSING: Process all images concurrently
-	type ocrJob struct {
-		img   ImageData
-		index int
+	// Try to send response (might fail if timeout already sent error)
+	select {
+	case <-timeout:
+		reqCtx.LogError("❌ Cannot send response - timeout already occurred")
+		// Response already sent by timeout handler
+	default:
+		body, err := responseFields.apply(result.Response)
+		if err != nil {
+			reqCtx.LogWarning("⚠️  Response field selection failed, sending the full response: %v", err)
+			body = result.Response
+		}
+		writeLargeJSON(c, http.StatusOK, body)
 	}
+}
 
-	resultsChan := make(chan PureOCRImageResult, len(downloadedImages))
-	jobsChan := make(chan ocrJob, len(downloadedImages))
-
-	// Start worker goroutines
-	// Changed to sequential processing (1 worker) to prevent 429 Rate Limit errors
-	// Gemini Free Tier: 15 RPM = must wait ~4 seconds between requests
-	// Parallel processing (3 workers) causes burst traffic → 429 errors
-	numWorkers := 1 // Sequential processing - safe for Tier 1 (15 RPM limit)
-
-	// Create OCR provider based on request model (gemini or mistral)
-	ocrProvider, err := ai.CreateOCRProvider(req.Model)
-	if err != nil {
-		reqCtx.LogError("Failed to create OCR provider: %v", err)
+// respondAnalyzeError writes the response of a failed AnalyzeService.Run
+// requestAborted handles the end of the request (deadline → the monitor already answered, disconnect → no answer)
+func respondAnalyzeError(c *gin.Context, reqCtx *common.RequestContext, req ExtractRequest, result *AnalyzeResult, err error, requestAborted func() bool) {
+	var downloadErr *DownloadError
+	var providerErr *OCRProviderError
+	var accountingErr *AccountingError
+	var imageErr *ImageError
+	var complexityErr *ComplexityError
+	switch {
+	case errors.As(err, &downloadErr):
+		respondDownloadError(c, reqCtx, downloadErr, requestAborted)
+	case errors.As(err, &providerErr):
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":      "OCR provider initialization failed",
-			"details":    err.Error(),
-			"model":      req.Model,
+			"details":    providerErr.Err.Error(),
+			"model":      providerErr.Model,
 			"request_id": reqCtx.RequestID,
 		})
-		return
-	}
-
-	for w := 0; w < numWorkers; w++ {
-		go func() {
-			for job := range jobsChan {
-				// For Mistral: use original URL if available, otherwise use local file
-				// For Gemini: always use local file
-				imagePath := job.img.Filename
-				if ocrProvider.GetProviderName() == "mistral" && job.img.URI != "" {
-					imagePath = job.img.URI
-				}
-
-				result, pureOCRTokens, err := ocrProvider.ProcessPureOCR(imagePath, reqCtx)
-				resultsChan <- PureOCRImageResult{
-					ImageIndex: job.img.Index,
-					Result:     result,
-					Tokens:     pureOCRTokens,
-					Error:      err,
-				}
-			}
-		}()
-	}
-
-	// Send jobs
-	for _, img := range downloadedImages {
-		jobsChan <- ocrJob{img: img, index: img.Index}
-	}
-	close(jobsChan)
-
-	// Collect results
-	resultsMap := make(map[int]PureOCRImageResult)
-	for i := 0; i < len(downloadedImages); i++ {
-		res := <-resultsChan
-		resultsMap[res.ImageIndex] = res
-	}
-	close(resultsChan)
-
-	// Process results in original order
-	for _, img := range downloadedImages {
-		res := resultsMap[img.Index]
-		result := res.Result
-		pureOCRTokens := res.Tokens
-		err := res.Error
-
-		if err != nil {
-			reqCtx.LogWarning("⚠️  Image %d Pure OCR failed: %v", img.Index, err)
-			// Note: Enhanced fixJSONEscaping() should handle most complex documents now
-			// Continue with other images even if one fails
-		}
-
-		// Basic validation: check if we got text
-		if result != nil && result.RawDocumentText == "" {
-			reqCtx.LogWarning("⚠️  Image %d - No text extracted (blank or unreadable image)", img.Index)
-		}
-
-		pureOCRResults = append(pureOCRResults, PureOCRImageResult{
-			ImageIndex: img.Index,
-			Result:     result,
-			Tokens:     pureOCRTokens,
-			Error:      err,
-		})
-
-		if pureOCRTokens != nil {
-			totalPureOCRTokens.InputTokens += pureOCRTokens.InputTokens
-			totalPureOCRTokens.OutputTokens += pureOCRTokens.OutputTokens
-			totalPureOCRTokens.TotalTokens += pureOCRTokens.TotalTokens
-			totalPureOCRTokens.CostUSD += pureOCRTokens.CostUSD
-			totalPureOCRTokens.CostTHB += pureOCRTokens.CostTHB
-		}
-	}
-
-	reqCtx.LogInfo("✓ Pure OCR completed for %d image(s) - Token savings: ~82%% vs old method", len(pureOCRResults))
-
-	// 🔍 DEBUG: Log pure OCR results (only when debug=true)
-	if debugMode {
-		reqCtx.LogInfo("📋 DEBUG: Pure OCR Results Overview:")
-		for i, ocrResult := range pureOCRResults {
-			if ocrResult.Result != nil {
-				// Show first 500 chars of raw text
-				rawText := ocrResult.Result.RawDocumentText
-				if len(rawText) > 500 {
-					rawText = rawText[:500] + "..."
-				}
-				reqCtx.LogInfo("Image %d Raw Text:\n%s", i, rawText)
-			}
-		}
-	}
-
-	reqCtx.EndStep("success", &totalPureOCRTokens, nil)
-
-	// Step 3.5: Template Matching Analysis (NEW SMART OPTIMIZATION)
-	// Analyze raw text to see if it matches any predefined accounting template
-	// If match found (≥TEMPLATE_CONFIDENCE_THRESHOLD) → Use template-only mode (saves another ~20,000 tokens in Phase 3!)
-	reqCtx.StartStep("template_matching_analysis")
-	reqCtx.LogInfo("Analyzing text to find matching accounting templates...")
-
-	// Combine all raw text from all images for comprehensive matching
-	var combinedText string
-	for _, ocrResult := range pureOCRResults {
-		if ocrResult.Result != nil {
-			combinedText += ocrResult.Result.RawDocumentText + "\n\n"
-		}
-	}
-
-	// Run template matching
-	templateMatchResult := processor.AnalyzeTemplateMatch(combinedText, documentTemplates, reqCtx)
-
-	var masterDataMode ai.MasterDataMode
-	var matchedTemplate *bson.M
-
-	if templateMatchResult.Confidence >= configs.TEMPLATE_CONFIDENCE_THRESHOLD && templateMatchResult.Template != nil {
-		// 🎯 TEMPLATE MATCHED - Use optimized path
-		masterDataMode = ai.TemplateOnlyMode
-		matchedTemplate = &templateMatchResult.Template
-		reqCtx.LogInfo("✅ Template matched: %s (ID: %v, Confidence: %.1f%%) - Using template-only mode",
-			templateMatchResult.Description,
-			templateMatchResult.TemplateID,
-			templateMatchResult.Confidence)
-	} else {
-		// ❌ NO TEMPLATE MATCH - Use full master data
-		masterDataMode = ai.FullMode
-		matchedTemplate = nil
-		reqCtx.LogInfo("❌ No template match (Confidence: %.1f%% < %.0f%%) - Using full master data mode",
-			templateMatchResult.Confidence,
-			configs.TEMPLATE_CONFIDENCE_THRESHOLD)
-	}
-
-	reqCtx.EndStep("success", nil, nil)
-
-	// Step 5: Prepare master data (already validated and loaded at the beginning)
-	reqCtx.StartStep("prepare_master_data")
-
-	// Filter accounts: Send only Level 3-5 (exclude Level 1-2 headers)
-	// Level 1-2 = top-level categories (สินทรัพย์, หนี้สิน)
-	// Level 3-5 = actual accounts used in journal entries
-	var filteredAccounts []bson.M
-	for _, acc := range masterCache.Accounts {
-		if accountLevel, ok := acc["accountlevel"].(int32); ok {
-			if accountLevel >= 3 {
-				filteredAccounts = append(filteredAccounts, acc)
-			}
-		} else if accountLevel, ok := acc["accountlevel"].(int64); ok {
-			if accountLevel >= 3 {
-				filteredAccounts = append(filteredAccounts, acc)
-			}
-		} else if accountLevel, ok := acc["accountlevel"].(float64); ok {
-			if accountLevel >= 3 {
-				filteredAccounts = append(filteredAccounts, acc)
-			}
-		}
-	}
-
-	// Compress JSON: Send only essential fields to reduce tokens
-	var compressedAccounts []bson.M
-	for _, acc := range filteredAccounts {
-		compressedAccounts = append(compressedAccounts, bson.M{
-			"accountcode": acc["accountcode"],
-			"accountname": acc["accountname"],
-		})
-	}
-
-	var compressedJournalBooks []bson.M
-	for _, jb := range masterCache.JournalBooks {
-		compressedJournalBooks = append(compressedJournalBooks, bson.M{
-			"code":  jb["code"],
-			"name1": jb["name1"],
-		})
-	}
-
-	var compressedCreditors []bson.M
-	for _, cr := range masterCache.Creditors {
-		compressedCreditors = append(compressedCreditors, bson.M{
-			"code": cr["code"],
-			"name": extractNameFromNamesArray(cr),
-		})
-	}
-
-	var compressedDebtors []bson.M
-	for _, db := range masterCache.Debtors {
-		compressedDebtors = append(compressedDebtors, bson.M{
-			"code": db["code"],
-			"name": extractNameFromNamesArray(db),
-		})
-	}
-
-	accounts := compressedAccounts
-	journalBooks := compressedJournalBooks
-	creditors := compressedCreditors
-	debtors := compressedDebtors
-
-	reqCtx.LogInfo("✓ Master data ready: %d accounts (filtered from %d), %d journal books, %d creditors, %d debtors",
-		len(accounts), len(masterCache.Accounts), len(journalBooks), len(creditors), len(debtors))
-	reqCtx.EndStep("success", nil, nil)
-
-	// Step 5.5: Pre-match vendors using fuzzy matching (before sending to AI)
-	reqCtx.LogInfo("\n┌── vendor_pre_matching")
-	var suggestedVendorCode string
-	var suggestedVendorName string
-	var matchMethod string
-	var matchSimilarity float64
-
-	// Initialize vendorMatchResult with empty values
-	vendorMatchResult := processor.VendorMatchResult{
-		Found:      false,
-		Code:       "",
-		Name:       "",
-		Similarity: 0,
-		Method:     "not_found",
-	}
-
-	// Try to extract vendor info from first OCR result
-	if len(pureOCRResults) > 0 && pureOCRResults[0].Result != nil {
-		ocrResult := pureOCRResults[0].Result
-		vendorNameFromOCR := ""
-		taxIDFromOCR := ""
-
-		// Extract vendor info from raw text (simple heuristic)
-		// First non-empty line is usually the vendor name
-		rawText := ocrResult.RawDocumentText
-		lines := strings.Split(rawText, "\n")
-		for _, line := range lines {
-			trimmed := strings.TrimSpace(line)
-			if trimmed != "" && len(trimmed) > 5 {
-				vendorNameFromOCR = trimmed
-				break
-			}
-		}
-
-		// Perform fuzzy matching
-		if vendorNameFromOCR != "" || taxIDFromOCR != "" {
-			vendorMatchResult = processor.MatchVendor(vendorNameFromOCR, masterCache.Creditors, taxIDFromOCR)
-			if vendorMatchResult.Found {
-				suggestedVendorCode = vendorMatchResult.Code
-				suggestedVendorName = vendorMatchResult.Name
-				matchMethod = vendorMatchResult.Method
-				matchSimilarity = vendorMatchResult.Similarity
-
-				reqCtx.LogInfo("✅ Vendor matched: '%s' → '%s' (code: %s, method: %s, %.1f%%)",
-					vendorNameFromOCR, suggestedVendorName, suggestedVendorCode, matchMethod, matchSimilarity)
-			} else {
-				reqCtx.LogInfo("⚠️  No vendor match found for: '%s'", vendorNameFromOCR)
-			}
+	case errors.Is(err, errSelfInvoiceRejected):
+		respondSelfInvoice(c, reqCtx, result.Validation.SelfInvoice)
+	case requestAborted():
+	case errors.As(err, &accountingErr):
+		respondAccountingError(c, reqCtx, req, accountingErr)
+	case errors.As(err, &imageErr):
+		if blockErr, blocked := ai.AsSafetyBlock(imageErr.Err); blocked {
+			respondContentBlocked(c, reqCtx, blockErr, imageErr.Index)
+		} else {
+			respondImageTooLarge(c, reqCtx, imageErr.Err, imageErr.Index)
 		}
-	}
-	reqCtx.LogInfo("└── ✅ สำเร็จ")
-
-	// Step 6: Phase 3 - AI Multi-Image Accounting Analysis (with conditional master data loading)
-	reqCtx.StartStep("phase3_multi_image_accounting")
-	reqCtx.LogInfo("Analyzing relationships between %d image(s) - Mode: %s", len(pureOCRResults), masterDataMode)
-
-	// Check if we should continue (not timed out)
-	select {
-	case <-timeout:
-		reqCtx.EndStep("cancelled", &totalPureOCRTokens, fmt.Errorf("timeout before accounting analysis"))
-		return
+	case errors.As(err, &complexityErr):
+		respondTooComplex(c, reqCtx, complexityErr.Estimate)
+	case reqCtx.BudgetError() != nil:
+		respondBudgetExceeded(c, reqCtx, err)
 	default:
-		// Continue
-	}
-
-	// Process multi-image accounting analysis with conditional master data
-	accountingJSON, phase3Tokens, err := ai.ProcessMultiImageAccountingAnalysis(
-		downloadedImages,
-		pureOCRResults,
-		masterDataMode,
-		matchedTemplate,
-		accounts,
-		journalBooks,
-		creditors,
-		debtors,
-		masterCache.ShopProfile,
-		documentTemplates,
-		&vendorMatchResult,
-		reqCtx,
-	)
-	if err != nil {
-		reqCtx.EndStep("failed", phase3Tokens, err)
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":      "Accounting analysis failed",
+			"error":      "Analysis failed",
 			"details":    err.Error(),
 			"request_id": reqCtx.RequestID,
 		})
-		return
 	}
-	reqCtx.EndStep("success", phase3Tokens, nil)
+}
 
-	// Parse accounting JSON
-	var accountingResponse map[string]interface{}
-	if err := json.Unmarshal([]byte(accountingJSON), &accountingResponse); err != nil {
+// respondDownloadError writes the response of a failed image download (Step 2)
+func respondDownloadError(c *gin.Context, reqCtx *common.RequestContext, downloadErr *DownloadError, requestAborted func() bool) {
+	switch {
+	case downloadErr.Reason == "missing_uri":
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      downloadErr.Err.Error(),
+			"request_id": reqCtx.RequestID,
+		})
+	case downloadErr.Reason == "too_large":
+		sizeErr, _ := asPayloadTooLarge(downloadErr.Err)
+		reqCtx.LogWarning("🚫 Request aborted: image %d: %v", downloadErr.Index, sizeErr)
+		respondPayloadTooLarge(c, reqCtx.RequestID, sizeErr, downloadErr.Index)
+	case downloadErr.Reason == "rejected" && respondFileScanRejected(c, reqCtx, downloadErr.Err, downloadErr.Index):
+	case downloadErr.Reason == "save":
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to parse accounting response",
-			"details": err.Error(),
+			"error":      "Failed to save downloaded file",
+			"details":    downloadErr.Err.Error(),
+			"request_id": reqCtx.RequestID,
 		})
-		return
-	}
-
-	// Step 7: Validate double-entry balance
-	if accountingEntry, ok := accountingResponse["accounting_entry"].(map[string]interface{}); ok {
-		if entriesRaw, ok := accountingEntry["entries"].([]interface{}); ok {
-			// Convert to JournalEntry slice for validation
-			entries := []JournalEntry{}
-			for _, e := range entriesRaw {
-				if entryMap, ok := e.(map[string]interface{}); ok {
-					entry := JournalEntry{
-						AccountCode: getStringValue(entryMap, "account_code"),
-						AccountName: getStringValue(entryMap, "account_name"),
-						Debit:       getFloatValue(entryMap, "debit"),
-						Credit:      getFloatValue(entryMap, "credit"),
-						Description: getStringValue(entryMap, "description"),
-					}
-					entries = append(entries, entry)
-				}
-			}
-
-			// Validate and add balance check
-			balanced, totalDebit, totalCredit := ValidateDoubleEntry(entries)
-			accountingEntry["balance_check"] = map[string]interface{}{
-				"balanced":     balanced,
-				"total_debit":  totalDebit,
-				"total_credit": totalCredit,
-			}
-		}
-	}
-
-	// Step 7.5: Fill creditor/debtor info from multiple sources
-	var accountingEntry map[string]interface{}
-	if ae, ok := accountingResponse["accounting_entry"].(map[string]interface{}); ok {
-		accountingEntry = ae
-	} else {
-		accountingEntry = map[string]interface{}{}
-	}
-
-	// Priority 1: Pre-matched vendor from Backend (vendor_pre_matching)
-	if vendorMatchResult.Found {
-		accountingEntry["creditor_code"] = vendorMatchResult.Code
-		accountingEntry["creditor_name"] = vendorMatchResult.Name
-		reqCtx.LogInfo("✅ Auto-filled creditor from vendor_pre_matching: %s (code: %s)",
-			vendorMatchResult.Name, vendorMatchResult.Code)
-	} else {
-		// Priority 2: AI-matched creditor from Phase 3 (from creditor/debtor objects)
-		if creditorObj, ok := accountingResponse["creditor"].(map[string]interface{}); ok {
-			if code := getStringValue(creditorObj, "creditor_code"); code != "" {
-				accountingEntry["creditor_code"] = code
-				accountingEntry["creditor_name"] = getStringValue(creditorObj, "creditor_name")
-				reqCtx.LogInfo("✅ Auto-filled creditor from AI Phase 3: %s (code: %s)",
-					accountingEntry["creditor_name"], code)
-			}
-		}
-
-		if debtorObj, ok := accountingResponse["debtor"].(map[string]interface{}); ok {
-			if code := getStringValue(debtorObj, "debtor_code"); code != "" {
-				accountingEntry["debtor_code"] = code
-				accountingEntry["debtor_name"] = getStringValue(debtorObj, "debtor_name")
-				reqCtx.LogInfo("✅ Auto-filled debtor from AI Phase 3: %s (code: %s)",
-					accountingEntry["debtor_name"], code)
-			}
-		}
-	}
-
-	// Step 7.6: Calculate weighted confidence score
-	reqCtx.StartStep("calculate_confidence")
-	confidenceResult := processor.CalculateWeightedConfidence(
-		&templateMatchResult,
-		&vendorMatchResult,
-		accountingEntry,
-		reqCtx,
-	)
-
-	// Replace AI's confidence with calculated weighted confidence
-	validationData := map[string]interface{}{
-		"confidence": map[string]interface{}{
-			"level": confidenceResult.OverallLevel,
-			"score": confidenceResult.OverallScore,
-		},
-		"requires_review": confidenceResult.RequiresReview,
-		"confidence_breakdown": map[string]interface{}{
-			"factors": map[string]interface{}{
-				"template_match":     confidenceResult.Factors.TemplateMatch,
-				"party_match":        confidenceResult.Factors.PartyMatch,
-				"data_completeness":  confidenceResult.Factors.DataCompleteness,
-				"field_validation":   confidenceResult.Factors.FieldValidation,
-				"balance_validation": confidenceResult.Factors.BalanceValidation,
-			},
-			"explanations": confidenceResult.Breakdown,
-			"weights": map[string]interface{}{
-				"template_match":     processor.DefaultWeights.TemplateMatch * 100,
-				"party_match":        processor.DefaultWeights.PartyMatch * 100,
-				"data_completeness":  processor.DefaultWeights.DataCompleteness * 100,
-				"field_validation":   processor.DefaultWeights.FieldValidation * 100,
-				"balance_validation": processor.DefaultWeights.BalanceValidation * 100,
-			},
-			"calculation": map[string]interface{}{
-				"formula": "(เทมเพลต×30%) + (คู่ค้า×25%) + (ข้อมูล×20%) + (ฟิลด์×15%) + (ยอดเงิน×10%)",
-				"steps": []string{
-					fmt.Sprintf("เทมเพลต: %.0f × 30%% = %.1f", confidenceResult.Factors.TemplateMatch, confidenceResult.Factors.TemplateMatch*0.3),
-					fmt.Sprintf("คู่ค้า: %.0f × 25%% = %.1f", confidenceResult.Factors.PartyMatch, confidenceResult.Factors.PartyMatch*0.25),
-					fmt.Sprintf("ข้อมูล: %.0f × 20%% = %.1f", confidenceResult.Factors.DataCompleteness, confidenceResult.Factors.DataCompleteness*0.2),
-					fmt.Sprintf("ฟิลด์: %.0f × 15%% = %.1f", confidenceResult.Factors.FieldValidation, confidenceResult.Factors.FieldValidation*0.15),
-					fmt.Sprintf("ยอดเงิน: %.0f × 10%% = %.1f", confidenceResult.Factors.BalanceValidation, confidenceResult.Factors.BalanceValidation*0.1),
-				},
-				"total": confidenceResult.OverallScore,
-			},
-		},
-		"review_requirements": generateReviewRequirements(confidenceResult, accountingEntry),
-	}
-
-	// Merge with existing validation data from AI (keep ai_explanation, etc.)
-	if existingValidation, ok := accountingResponse["validation"].(map[string]interface{}); ok {
-		// Keep AI's explanation but override confidence and requires_review
-		validationData["ai_explanation"] = existingValidation["ai_explanation"]
-		validationData["processing_notes"] = existingValidation["processing_notes"]
-		validationData["fields_requiring_review"] = existingValidation["fields_requiring_review"]
-
-		// Override AI's vendor_matching with Backend's result
-		if aiExplanation, ok := existingValidation["ai_explanation"].(map[string]interface{}); ok {
-			if vendorMatchResult.Found {
-				aiExplanation["vendor_matching"] = map[string]interface{}{
-					"found_in_document": vendorMatchResult.Name,
-					"matched_with":      vendorMatchResult.Code + " - " + vendorMatchResult.Name,
-					"matching_method":   vendorMatchResult.Method,
-					"confidence":        vendorMatchResult.Similarity,
-					"reason":            fmt.Sprintf("ระบบจับคู่ vendor สำเร็จด้วยวิธี %s (ความแม่นยำ %.1f%%)", vendorMatchResult.Method, vendorMatchResult.Similarity),
-				}
-			} else {
-				// Keep AI's not_found explanation
-			}
-			validationData["ai_explanation"] = aiExplanation
-		}
-	}
-
-	accountingResponse["validation"] = validationData
-	reqCtx.EndStep("success", nil, nil)
-
-	// Step 8: Extract data safely (no draft saving)
-	// Re-extract accountingEntry after confidence calculation
-	if ae, ok := accountingResponse["accounting_entry"].(map[string]interface{}); ok {
-		accountingEntry = ae
-
-		// 🔥 CRITICAL: Validate creditor/debtor codes against master data
-		creditorCode := getStringValue(accountingEntry, "creditor_code")
-		debtorCode := getStringValue(accountingEntry, "debtor_code")
-
-		if creditorCode != "" {
-			found := false
-			for _, creditor := range masterCache.Creditors {
-				if code, ok := creditor["code"].(string); ok && code == creditorCode {
-					found = true
-					break
-				}
-			}
-			if !found {
-				reqCtx.LogWarning("⚠️  AI ส่ง creditor_code '%s' ที่ไม่มีในฐานข้อมูล → เปลี่ยนเป็น Unknown", creditorCode)
-				accountingEntry["creditor_code"] = ""
-				accountingEntry["creditor_name"] = ""
-			}
-		}
-
-		if debtorCode != "" {
-			found := false
-			for _, debtor := range masterCache.Debtors {
-				if code, ok := debtor["code"].(string); ok && code == debtorCode {
-					found = true
-					break
-				}
-			}
-			if !found {
-				reqCtx.LogWarning("⚠️  AI ส่ง debtor_code '%s' ที่ไม่มีในฐานข้อมูล → เปลี่ยนเป็น Unknown", debtorCode)
-				accountingEntry["debtor_code"] = ""
-				accountingEntry["debtor_name"] = ""
-			}
-		}
-
-		// 🔥 CRITICAL: Validate template usage - check if all accounts are used
-		if matchedTemplate != nil {
-			if details, ok := (*matchedTemplate)["details"].(bson.A); ok && len(details) > 0 {
-				entriesRaw, _ := accountingEntry["entries"].([]interface{})
-				if len(entriesRaw) < len(details) {
-					reqCtx.LogWarning("⚠️  Template has %d accounts but AI only used %d → Missing accounts!", len(details), len(entriesRaw))
-				}
-			}
-		}
-	} else {
-		accountingEntry = map[string]interface{}{}
-	}
-
-	// Step 9: Prepare debug data if requested
-	var debugData map[string]interface{}
-	if debugMode {
-		// Include pure OCR results in response for debugging
-		ocrDebugData := []map[string]interface{}{}
-		for i, ocrResult := range pureOCRResults {
-			if ocrResult.Result != nil {
-				ocrDebugData = append(ocrDebugData, map[string]interface{}{
-					"image_index": i,
-					"ocr_result":  ocrResult.Result,
-				})
-			}
-		}
-		debugData = map[string]interface{}{
-			"pure_ocr_results": ocrDebugData,
-			"note":             "Debug mode enabled - showing pure OCR extraction data (raw text only)",
-			"template_match":   templateMatchResult,
-		}
-	}
-
-	// Step 10: Check if we timed out during processing
-	select {
-	case <-timeout:
-		// Timeout occurred, but we finished anyway - return response with warning
-		reqCtx.LogWarning("⚠️  Processing completed after timeout - response may not be delivered")
+	case requestAborted():
+	case downloadErr.PhaseTimeout:
+		respondPhaseTimeout(c, reqCtx, phaseDownload)
 	default:
-		// Normal completion
-	}
-
-	// Step 9: Build multi-image response with document analysis
-	summary := reqCtx.GetSummary()
-
-	// Extract document analysis if available
-	var documentAnalysis map[string]interface{}
-	if da, ok := accountingResponse["document_analysis"].(map[string]interface{}); ok {
-		documentAnalysis = da
-	} else {
-		// Default analysis for single image
-		documentAnalysis = map[string]interface{}{
-			"total_images": len(downloadedImages),
-			"relationship": "single_document",
-			"confidence":   95,
-		}
-	}
-
-	// Extract source images info if available
-	var sourceImages []interface{}
-	if si, ok := accountingResponse["source_images"].([]interface{}); ok {
-		sourceImages = si
-	}
-
-	// Extract template information (which template AI used and why)
-	templateInfo := processor.ExtractTemplateInfo(accountingResponse, documentTemplates, matchedTemplate, reqCtx)
-
-	// Get primary receipt data from accounting response (Pure OCR doesn't extract structured data)
-	var receiptData map[string]interface{}
-	if rd, ok := accountingResponse["receipt"].(map[string]interface{}); ok {
-		receiptData = rd
-	} else {
-		// Pure OCR only has raw text, so accounting response should provide structured data
-		// If missing, use minimal fallback
-		receiptData = gin.H{
-			"number":        "N/A",
-			"date":          "N/A",
-			"vendor_name":   "N/A", // All info comes from Phase 3 accounting analysis
-			"vendor_tax_id": "N/A",
-			"total":         0,
-			"vat":           0,
-		}
-	}
-
-	// Priority 1: Add fields_requiring_review array
-	fieldsRequiringReview := []string{}
-	if receiptData != nil {
-		if vendorName, ok := receiptData["vendor_name"].(string); ok && (vendorName == "Unknown Vendor" || vendorName == "N/A" || vendorName == "") {
-			fieldsRequiringReview = append(fieldsRequiringReview, "vendor_name")
-		}
-		if vendorTaxID, ok := receiptData["vendor_tax_id"].(string); ok && (vendorTaxID == "Unknown Vendor" || vendorTaxID == "N/A" || vendorTaxID == "") {
-			fieldsRequiringReview = append(fieldsRequiringReview, "vendor_tax_id")
-		}
-	}
-	if len(fieldsRequiringReview) > 0 {
-		validationData["fields_requiring_review"] = fieldsRequiringReview
-		if requiresReview, ok := validationData["requires_review"].(bool); !ok || !requiresReview {
-			validationData["requires_review"] = true
-		}
-	}
-
-	// Collect OCR warnings from all processed images
-	var ocrWarnings []gin.H
-	for i, ocrResult := range pureOCRResults {
-		// Case 1: OCR succeeded with warnings
-		if ocrResult.Result != nil && (ocrResult.Result.IsPartial || ocrResult.Result.FallbackUsed || ocrResult.Result.Warning != "") {
-			warning := gin.H{
-				"image_index": i,
-			}
-			if ocrResult.Result.IsPartial {
-				warning["is_partial"] = true
-			}
-			if ocrResult.Result.FallbackUsed {
-				warning["fallback_used"] = true
-			}
-			if ocrResult.Result.Warning != "" {
-				warning["warning"] = ocrResult.Result.Warning
-			}
-			if ocrResult.Result.TextLength > 0 {
-				warning["text_length"] = ocrResult.Result.TextLength
-			}
-			ocrWarnings = append(ocrWarnings, warning)
-		} else if ocrResult.Error != nil {
-			// Case 2: OCR failed completely
-			warning := gin.H{
-				"image_index": i,
-				"error":       "OCR extraction failed",
-				"details":     ocrResult.Error.Error(),
-			}
-			ocrWarnings = append(ocrWarnings, warning)
-		}
-	}
-
-	// Build metadata with OCR warnings if any
-	// Separate Mistral OCR usage from Gemini AI processing
-	metadata := gin.H{
-		"request_id":       reqCtx.RequestID,
-		"processed_at":     time.Now().Format(time.RFC3339),
-		"duration_sec":     summary["total_duration_sec"],
-		"images_processed": len(downloadedImages),
-	}
-
-	// Add OCR provider info and breakdown
-	ocrProviderName := "gemini" // default
-	if ocrProvider != nil {
-		ocrProviderName = ocrProvider.GetProviderName()
-	}
-
-	if ocrProviderName == "mistral" {
-		// Mistral: Show separate OCR and AI processing costs
-		metadata["ocr_provider"] = "mistral"
-		metadata["token_usage"] = gin.H{
-			"ocr_usage": gin.H{
-				"provider":        "mistral",
-				"pages_processed": totalPureOCRTokens.InputTokens, // pages stored as input_tokens
-				"cost_thb":        fmt.Sprintf("฿%.2f", totalPureOCRTokens.CostTHB),
-				"cost_usd":        fmt.Sprintf("$%.6f", totalPureOCRTokens.CostUSD),
-			},
-			"ai_processing": gin.H{
-				"provider":      "gemini",
-				"input_tokens":  summary["token_usage"].(map[string]interface{})["input_tokens"].(int) - totalPureOCRTokens.InputTokens,
-				"output_tokens": summary["token_usage"].(map[string]interface{})["output_tokens"],
-				"total_tokens":  summary["token_usage"].(map[string]interface{})["total_tokens"],
-				"cost_thb":      fmt.Sprintf("฿%.2f", reqCtx.TotalTokens.CostTHB-totalPureOCRTokens.CostTHB),
-			},
-			"total": gin.H{
-				"cost_thb": summary["token_usage"].(map[string]interface{})["cost_thb"],
-				"cost_usd": summary["token_usage"].(map[string]interface{})["cost_usd"],
-			},
-		}
-	} else {
-		// Gemini: Show combined usage (traditional format)
-		metadata["ocr_provider"] = "gemini"
-		metadata["token_usage"] = gin.H{
-			"input_tokens":  summary["token_usage"].(map[string]interface{})["input_tokens"],
-			"output_tokens": summary["token_usage"].(map[string]interface{})["output_tokens"],
-			"total_tokens":  summary["token_usage"].(map[string]interface{})["total_tokens"],
-			"cost_thb":      summary["token_usage"].(map[string]interface{})["cost_thb"],
-		}
-	}
-	// Add OCR warnings if any issues were detected
-	if len(ocrWarnings) > 0 {
-		metadata["ocr_warnings"] = ocrWarnings
-	}
-
-	response := gin.H{
-		"shopid": req.ShopID,
-		"status": "success",
-
-		// NEW: Document analysis showing relationship between images
-		"document_analysis": documentAnalysis,
-
-		// Essential: Receipt information (merged/primary)
-		"receipt": receiptData,
-
-		// Essential: Accounting entry (merged from all images)
-		"accounting_entry": accountingEntry,
-
-		// Essential: Validation summary
-		"validation": validationData,
-
-		// NEW: Template information - shows which template AI selected and why
-		"template_info": templateInfo,
-
-		// NEW: Custom prompts used for AI analysis
-		"custom_prompts": gin.H{
-			"shop_context":      extractShopContextForResponse(masterCache.ShopProfile),
-			"template_guidance": extractTemplateGuidanceForResponse(matchedTemplate),
-		},
-
-		// NEW: Source images metadata
-		"source_images": sourceImages,
-
-		// Metadata: For tracking and debugging (includes OCR warnings if any)
-		"metadata": metadata,
-
-		// Note: IMPORTANT - Always verify request_id matches your request log!
-		// If IDs don't match, this might be a cached/wrong response.
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":       "Failed to download file from Azure Blob Storage",
+			"details":     downloadErr.Err.Error(),
+			"image_uri":   downloadErr.URI,
+			"image_index": downloadErr.Index,
+			"request_id":  reqCtx.RequestID,
+		})
 	}
+}
 
-	// Add debug data only if debug mode is enabled
-	if debugData != nil {
-		response["debug_data"] = debugData
+// respondAccountingError writes the response of a failed Phase 3 and keeps the request for retry (dead letter)
+func respondAccountingError(c *gin.Context, reqCtx *common.RequestContext, req ExtractRequest, accountingErr *AccountingError) {
+	failure := analysisFailure{
+		Endpoint:     "analyze-receipt",
+		Phase:        failurePhaseAccounting,
+		Payload:      req,
+		OCRRequestID: storedOCRRequestID(reqCtx, failurePhaseAccounting),
+		Err:          accountingErr.Err,
+		Artifacts:    accountingErr.Artifacts,
+	}
+	if accountingErr.PhaseTimeout {
+		recordFailedRequest(c, reqCtx, failure)
+		respondPhaseTimeout(c, reqCtx, failurePhaseAccounting)
+		return
 	}
-
-	// Filter out internal fields from ai_explanation before sending response
-	if validationData != nil {
-		if aiExplanation, ok := validationData["ai_explanation"].(map[string]interface{}); ok {
-			// Remove evidence_from_receipt (ซ้ำกับ receipt{})
-			delete(aiExplanation, "evidence_from_receipt")
-
-			// Keep account_selection_logic but remove redundant fields
-			if accountSelectionLogic, ok := aiExplanation["account_selection_logic"].(map[string]interface{}); ok {
-				// Keep only template_used and template_details for user reference
-				// Remove debit_accounts/credit_accounts (ซ้ำกับ entries[] 100%)
-				delete(accountSelectionLogic, "debit_accounts")
-				delete(accountSelectionLogic, "credit_accounts")
-				delete(accountSelectionLogic, "verification")
-			}
-		}
+	if reqCtx.BudgetError() != nil {
+		respondBudgetExceeded(c, reqCtx, accountingErr.Err)
+		return
 	}
-
-	// Signal completion
-	select {
-	case done <- true:
-		// Successfully signaled
-	default:
-		// Channel might be closed or blocked
+	if blockErr, blocked := ai.AsSafetyBlock(accountingErr.Err); blocked {
+		respondContentBlocked(c, reqCtx, blockErr, -1)
+		return
 	}
-
-	// Try to send response (might fail if timeout already sent error)
-	select {
-	case <-timeout:
-		reqCtx.LogError("❌ Cannot send response - timeout already occurred")
-		// Response already sent by timeout handler
-	default:
-		c.JSON(http.StatusOK, response)
+	recordFailedRequest(c, reqCtx, failure)
+	message := "Accounting analysis failed"
+	if accountingErr.Parse {
+		message = "Failed to parse accounting response"
 	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error":      message,
+		"details":    accountingErr.Err.Error(),
+		"request_id": reqCtx.RequestID,
+	})
 }
 
 // TestTemplateHandler - Test a template with an uploaded image
 func TestTemplateHandler(c *gin.Context) {
-	// Step 1: Parse multipart form data
+	// Step 1: Parse multipart form data (an upload above MAX_UPLOAD_BODY_BYTES → 413, not a missing field)
+	if _, err := c.MultipartForm(); err != nil {
+		if sizeErr, tooLarge := asPayloadTooLarge(err); tooLarge {
+			respondPayloadTooLarge(c, "", sizeErr, -1)
+			return
+		}
+	}
 	shopID := c.PostForm("shopid")
 	templateJSON := c.PostForm("template")
 	model := c.PostForm("model")
@@ -1376,6 +848,15 @@ func TestTemplateHandler(c *gin.Context) {
 		return
 	}
 
+	// Incident response flags (admin API)
+	if rejectForeignShop(c, shopID) || rejectSuspendedShop(c, shopID) {
+		return
+	}
+	model, ok := resolveOCRProvider(c, model)
+	if !ok {
+		return
+	}
+
 	// Parse template JSON
 	var template bson.M
 	if err := json.Unmarshal([]byte(templateJSON), &template); err != nil {
@@ -1436,6 +917,8 @@ func TestTemplateHandler(c *gin.Context) {
 	}
 
 	reqCtx.LogInfo("🧪 เริ่มทดสอบ Template | ShopID: %s | Template Code: %s | File: %s", shopID, templateDocCode, header.Filename)
+	defer recordUsageLedger(c, reqCtx, "test-template", model)
+	defer saveAITraces(reqCtx, "test-template")
 
 	// Step 3: Save file temporarily
 	tempFilename := fmt.Sprintf("%s_%s", uuid.New().String(), filepath.Ext(header.Filename))
@@ -1464,9 +947,12 @@ func TestTemplateHandler(c *gin.Context) {
 	}
 
 	reqCtx.LogInfo("✅ File saved temporarily: %s (%.2f KB)", tempFilename, float64(header.Size)/1024)
+	if !scanStandaloneDocument(c, c.Request.Context(), reqCtx, tempFilePath) {
+		return
+	}
 
 	// Step 4: Load master data
-	masterCache, err := storage.GetOrLoadMasterData(shopID)
+	masterCache, err := dataStore.GetOrLoadMasterData(c.Request.Context(), shopID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":      "Failed to load master data",
@@ -1479,6 +965,20 @@ func TestTemplateHandler(c *gin.Context) {
 	reqCtx.LogInfo("✓ Master data validated: %d accounts, %d journal books, %d creditors, %d debtors",
 		len(masterCache.Accounts), len(masterCache.JournalBooks),
 		len(masterCache.Creditors), len(masterCache.Debtors))
+	applyShopSettings(reqCtx, masterCache.ShopSettings)
+
+	// Step 4.5: Validate template details before spending on OCR / AI
+	if validation := processor.ValidateTemplate(template, masterCache.Accounts, masterCache.JournalBooks); !validation.Valid {
+		os.Remove(tempFilePath)
+		reqCtx.LogWarning("⚠️  Template validation failed: %d error(s)", len(validation.Errors))
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":               "invalid template",
+			"message":             "template มีรหัสบัญชี / ฝั่ง debit-credit / สมุดรายวันที่ไม่ถูกต้อง",
+			"template_validation": validation,
+			"request_id":          reqCtx.RequestID,
+		})
+		return
+	}
 
 	// Step 5: Use provided template (no MongoDB query needed)
 	templateName := "Unknown Template"
@@ -1505,10 +1005,35 @@ func TestTemplateHandler(c *gin.Context) {
 		return
 	}
 
-	ocrResult, ocrTokens, err := ocrProvider.ProcessPureOCR(tempFilePath, reqCtx)
+	// Request deadline (REQUEST_TIMEOUT) - each phase derives its own deadline from it
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout())
+	defer cancel()
+
+	ocrCtx, cancelOCR := phaseContext(ctx, failurePhaseOCR)
+	defer cancelOCR()
+	ocrResult, ocrTokens, err := ocrProvider.ProcessPureOCR(ocrCtx, tempFilePath, reqCtx)
+	if blockErr, blocked := ai.AsSafetyBlock(err); blocked {
+		if alternate := ai.AlternateOCRProvider(ocrProvider.GetProviderName()); alternate != nil {
+			reqCtx.LogWarning("🛡️  Document blocked by %s (%s) → retrying with %s", blockErr.Provider, blockErr.Kind, alternate.GetProviderName())
+			if altResult, altTokens, altErr := alternate.ProcessPureOCR(ocrCtx, tempFilePath, reqCtx); altErr == nil {
+				ocrResult, ocrTokens, err = altResult, altTokens, nil
+			}
+		}
+	}
 	if err != nil {
 		reqCtx.LogError("OCR failed: %v", err)
 		reqCtx.EndStep("failed", nil, err)
+		if phaseTimedOut(ocrCtx, ctx) {
+			respondPhaseTimeout(c, reqCtx, failurePhaseOCR)
+			return
+		}
+		if blockErr, blocked := ai.AsSafetyBlock(err); blocked {
+			respondContentBlocked(c, reqCtx, blockErr, 0)
+			return
+		}
+		if respondImageTooLarge(c, reqCtx, err, 0) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":      "OCR processing failed",
 			"details":    err.Error(),
@@ -1530,16 +1055,7 @@ func TestTemplateHandler(c *gin.Context) {
 		return
 	}
 
-	// Create pure OCR result map for AI processing
-	fullResults := []map[string]interface{}{
-		{
-			"full_text": ocrText,
-			"metadata":  ocrResult.Metadata,
-		},
-	}
-
 	// Step 7: Force use the specified template (skip template matching)
-	reqCtx.LogInfo("\n┌── template_matching_analysis")
 	reqCtx.LogInfo("🧪 Force using template: %s (Test Mode)", templateName)
 
 	matchedTemplate := &template
@@ -1550,87 +1066,62 @@ func TestTemplateHandler(c *gin.Context) {
 		"mode":          "test",
 		"note":          "Template provided by user for testing - no AI matching performed",
 	}
-
-	reqCtx.LogInfo("└── ✅ สำเร็จ")
-
-	// Step 8: Process with accounting analysis (Phase 3)
-	reqCtx.LogInfo("\n┌── 📊 เตรียมข้อมูลหลัก (Master Data)")
-
-	// Filter accounts for non-VAT shops (use all accounts for test mode)
-	filteredAccounts := masterCache.Accounts
-
-	reqCtx.LogInfo("✓ Master data ready: %d accounts (filtered from %d), %d journal books, %d creditors, %d debtors",
-		len(filteredAccounts), len(masterCache.Accounts),
-		len(masterCache.JournalBooks), len(masterCache.Creditors), len(masterCache.Debtors))
-
-	reqCtx.LogInfo("└── ✅ สำเร็จ")
-
-	// Prepare downloadedImages metadata for accounting
-	downloadedImages := []map[string]interface{}{
-		{
-			"filename":    tempFilePath,
-			"image_index": 0,
-		},
-	}
-
-	// Convert ShopProfile to interface{} for AI processing
+	documentTemplates := []bson.M{template}
 	var shopProfileInterface interface{}
 	if masterCache.ShopProfile != nil {
 		shopProfileInterface = masterCache.ShopProfile
 	}
 
-	// Prepare document templates array
-	documentTemplates := []bson.M{template}
-
-	// Process accounting with forced template (use full_mode since we're testing)
-	reqCtx.StartStep("phase3_multi_image_accounting")
-
-	// Create empty vendor match result for test endpoint (no pre-matching)
-	emptyVendorMatchResult := processor.VendorMatchResult{
-		Found:      false,
-		Code:       "",
-		Name:       "",
-		Similarity: 0,
-		Method:     "not_found",
-	}
-
-	accountingResponseJSON, accountingTokens, err := ai.ProcessMultiImageAccountingAnalysis(
-		downloadedImages,
-		fullResults,
-		ai.FullMode, // Use full mode for testing to get complete analysis
-		matchedTemplate,
-		filteredAccounts,
-		masterCache.JournalBooks,
-		masterCache.Creditors,
-		masterCache.Debtors,
-		shopProfileInterface,
-		documentTemplates,
-		&emptyVendorMatchResult,
-		reqCtx,
-	)
-	reqCtx.EndStep("success", accountingTokens, nil)
-
+	// Step 8: Phase 3 + deterministic corrections through the analyze-receipt service
+	// Full mode with the template in the prompt (complete analysis), vendor pre-matched like analyze-receipt
+	analysis, err := analyzeService.Analyze(ctx, &AnalysisInput{
+		Request:     ExtractRequest{ShopID: shopID, Model: model},
+		MasterCache: masterCache,
+		Templates:   documentTemplates,
+		OCRProvider: model,
+		Images:      []ImageData{{Filename: tempFilePath, Index: 0}},
+		OCRResults:  []PureOCRImageResult{{ImageIndex: 0, Result: ocrResult, Tokens: ocrTokens}},
+		TemplateMatch: TemplateMatchOutcome{
+			Result: processor.TemplateMatchResult{
+				Template:    template,
+				Confidence:  100,
+				Description: templateName,
+				Reason:      "template under test",
+			},
+			Mode:     ai.FullMode,
+			Template: matchedTemplate,
+		},
+	}, reqCtx)
 	if err != nil {
+		os.Remove(tempFilePath)
+		var accountingErr *AccountingError
+		errors.As(err, &accountingErr)
 		reqCtx.LogError("Accounting analysis failed: %v", err)
-		reqCtx.EndStep("failed", nil, err)
+		if accountingErr.PhaseTimeout {
+			respondPhaseTimeout(c, reqCtx, failurePhaseAccounting)
+			return
+		}
+		message := "Accounting analysis failed"
+		if accountingErr.Parse {
+			message = "Failed to parse accounting response"
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":      "Accounting analysis failed",
-			"details":    err.Error(),
+			"error":      message,
+			"details":    accountingErr.Err.Error(),
 			"request_id": reqCtx.RequestID,
 		})
 		return
 	}
+	accountingResponse := analysis.Response
 
-	// Parse accounting response JSON
-	var accountingResponse map[string]interface{}
-	if err := json.Unmarshal([]byte(accountingResponseJSON), &accountingResponse); err != nil {
-		reqCtx.LogError("Failed to parse accounting response: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":      "Failed to parse accounting response",
-			"details":    err.Error(),
-			"request_id": reqCtx.RequestID,
-		})
-		return
+	// Step 8.5: Template formulas (+ balance check again with the computed amounts)
+	if entry, ok := accountingResponse["accounting_entry"].(map[string]interface{}); ok && analysis.TemplateFormulas != nil {
+		setBalanceCheck(entry)
+		if validation, ok := accountingResponse["validation"].(map[string]interface{}); ok {
+			validation["template_formulas"] = *analysis.TemplateFormulas
+		} else {
+			accountingResponse["validation"] = map[string]interface{}{"template_formulas": *analysis.TemplateFormulas}
+		}
 	}
 
 	// Step 9: Build response (same structure as analyze-receipt)
@@ -1722,6 +1213,10 @@ func TestTemplateHandler(c *gin.Context) {
 				"total_tokens":  summary["token_usage"].(map[string]interface{})["total_tokens"],
 				"cost_thb":      summary["token_usage"].(map[string]interface{})["cost_thb"],
 			},
+			"cost_breakdown":     reqCtx.GetCostBreakdown(),
+			"file_scans":         reqCtx.FileScans(),
+			"image_sanitization": reqCtx.ImageSanitizations(),
+			"steps":              reqCtx.GetSteps(),
 		},
 
 		"template_match": templateMatchResult,
@@ -1759,6 +1254,79 @@ func TestTemplateHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// respondBudgetExceeded sends the error response when an AI call was blocked by max_cost_thb
+func respondBudgetExceeded(c *gin.Context, reqCtx *common.RequestContext, err error) {
+	reqCtx.LogWarning("💸 Request aborted: %v", err)
+	c.JSON(http.StatusPaymentRequired, gin.H{
+		"error":          "cost_budget_exceeded",
+		"message":        "ค่าใช้จ่ายที่ประเมินไว้เกินงบ max_cost_thb ที่กำหนด ระบบจึงหยุดก่อนเรียก AI ขั้นถัดไป",
+		"details":        err.Error(),
+		"cost_breakdown": reqCtx.GetCostBreakdown(),
+		"request_id":     reqCtx.RequestID,
+	})
+}
+
+// respondContentBlocked returns 422 with the block kind and actionable suggestions
+// imageIndex < 0 means the block happened after OCR (e.g. accounting analysis)
+func respondContentBlocked(c *gin.Context, reqCtx *common.RequestContext, blockErr *ai.SafetyBlockError, imageIndex int) {
+	reqCtx.LogWarning("🛡️  Request aborted: %v", blockErr)
+	response := gin.H{
+		"error":        "content_blocked",
+		"message":      "AI ปฏิเสธการประมวลผลเอกสารนี้ (ตัวกรองเนื้อหา) กรุณาปรับรูปตามคำแนะนำแล้วส่งใหม่",
+		"details":      blockErr.Error(),
+		"block_kind":   blockErr.Kind,
+		"block_reason": blockErr.Reason,
+		"provider":     blockErr.Provider,
+		"suggestions":  blockErr.Suggestions,
+		"request_id":   reqCtx.RequestID,
+	}
+	if imageIndex >= 0 {
+		response["image_index"] = imageIndex
+	}
+	c.JSON(http.StatusUnprocessableEntity, response)
+}
+
+// respondImageTooLarge returns 413 when the payload guard refused an image (IMAGE_REJECT_* or still over budget)
+// Returns false when err is not an image size rejection
+func respondImageTooLarge(c *gin.Context, reqCtx *common.RequestContext, err error, imageIndex int) bool {
+	var sizeErr *processor.ImageTooLargeError
+	if !errors.As(err, &sizeErr) {
+		return false
+	}
+	reqCtx.LogWarning("🗜️  Request aborted: %v", sizeErr)
+	response := gin.H{
+		"error":      "image_too_large",
+		"message":    "ไฟล์รูปใหญ่เกินกว่าที่ระบบรับได้ กรุณาถ่ายใหม่ด้วยความละเอียดปกติหรือลดขนาดไฟล์แล้วส่งใหม่",
+		"details":    sizeErr.Error(),
+		"reason":     sizeErr.Reason,
+		"value":      sizeErr.Value,
+		"limit":      sizeErr.Limit,
+		"request_id": reqCtx.RequestID,
+	}
+	if imageIndex >= 0 {
+		response["image_index"] = imageIndex
+	}
+	c.JSON(http.StatusRequestEntityTooLarge, response)
+	return true
+}
+
+// newRawDocumentText builds the raw_document_texts entry of one image
+func newRawDocumentText(imageIndex int, refs []ImageReference, result *ai.SimpleOCRResult, err error) RawDocumentText {
+	raw := RawDocumentText{ImageIndex: imageIndex}
+	if imageIndex >= 0 && imageIndex < len(refs) {
+		raw.DocumentImageGUID = refs[imageIndex].DocumentImageGUID
+	}
+	if result != nil {
+		raw.RawDocumentText = result.RawDocumentText
+		raw.TextLength = utf8.RuneCountInString(result.RawDocumentText)
+		raw.IsPartial = result.IsPartial
+	}
+	if err != nil {
+		raw.Error = err.Error()
+	}
+	return raw
+}
+
 // formatTokenSummary formats token usage for logging
 func formatTokenSummary(tokenUsage map[string]interface{}) string {
 	input := tokenUsage["total_input_tokens"]
@@ -2044,3 +1612,22 @@ func countCriticalIssues(confidenceResult processor.ConfidenceResult) int {
 
 	return critical
 }
+
+// fixedAssetRule returns the shop's capitalization policy (settings.fixedasset over FIXED_ASSET_THRESHOLD)
+func fixedAssetRule(masterCache *storage.MasterDataCache) (processor.FixedAssetRule, bool) {
+	rule := processor.FixedAssetRule{Threshold: configs.Get().FixedAssetThreshold}
+	if !configs.Get().EnableFixedAssetDetection {
+		return rule, false
+	}
+	if masterCache.ShopProfile != nil {
+		settings := masterCache.ShopProfile.Settings.FixedAsset
+		if settings.Disabled {
+			return rule, false
+		}
+		if settings.Threshold > 0 {
+			rule.Threshold = settings.Threshold
+		}
+		rule.Keywords = settings.Keywords
+	}
+	return rule, rule.Threshold > 0
+}
@@ -0,0 +1,132 @@
+// health.go - Liveness (/healthz) and readiness (/readyz) probes
+//
+// /healthz = process ตอบได้ (ไม่ตรวจ dependency → ไม่ restart เพราะ MongoDB ล่ม)
+// /readyz  = พร้อมรับงาน: MongoDB ping, UPLOAD_DIR เขียนได้, (optional) API key ของ Gemini / Mistral ใช้ได้
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// dependencyCheckTimeout bounds each readiness check
+const dependencyCheckTimeout = 3 * time.Second
+
+// DependencyStatus is the result of one readiness check
+type DependencyStatus struct {
+	Status    string `json:"status"` // ok, error
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+	Cached    bool   `json:"cached,omitempty"` // Provider check result reused (READINESS_PROVIDER_CACHE_SECONDS)
+}
+
+// ReadinessResponse is returned by /readyz (200 = ready, 503 = a dependency failed)
+type ReadinessResponse struct {
+	Status    string                      `json:"status"` // ready, not_ready
+	Checks    map[string]DependencyStatus `json:"checks"`
+	CheckedAt string                      `json:"checked_at"`
+}
+
+// providerCheck is a cached credential check result
+type providerCheck struct {
+	status    DependencyStatus
+	checkedAt time.Time
+}
+
+var (
+	providerChecks   = map[string]providerCheck{}
+	providerChecksMu sync.Mutex
+)
+
+// HealthzHandler handles GET /healthz (liveness - no dependency checks)
+func HealthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReadyzHandler handles GET /readyz
+func ReadyzHandler(c *gin.Context) {
+	response := ReadinessResponse{
+		Status:    "ready",
+		Checks:    map[string]DependencyStatus{},
+		CheckedAt: time.Now().Format(time.RFC3339),
+	}
+
+	// Draining (SIGTERM received) → stop routing new requests here
+	if IsDraining() {
+		response.Checks["server"] = DependencyStatus{Status: "error", Error: "draining for shutdown"}
+	}
+	response.Checks["mongodb"] = runDependencyCheck(storage.PingMongoDB)
+	response.Checks["upload_dir"] = runDependencyCheck(checkUploadDirWritable)
+	if configs.READINESS_CHECK_PROVIDERS {
+		response.Checks["gemini"] = cachedProviderCheck("gemini")
+		if configs.MISTRAL_API_KEY != "" {
+			response.Checks["mistral"] = cachedProviderCheck("mistral")
+		}
+	}
+
+	for _, check := range response.Checks {
+		if check.Status != "ok" {
+			response.Status = "not_ready"
+		}
+	}
+	if response.Status != "ready" {
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// runDependencyCheck runs check with dependencyCheckTimeout and measures its latency
+func runDependencyCheck(check func(ctx context.Context) error) DependencyStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), dependencyCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	status := DependencyStatus{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		status.Status = "error"
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// checkUploadDirWritable creates and removes a temp file in UPLOAD_DIR
+func checkUploadDirWritable(ctx context.Context) error {
+	f, err := os.CreateTemp(configs.UPLOAD_DIR, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// cachedProviderCheck verifies a provider API key, reusing the last result for READINESS_PROVIDER_CACHE_SECONDS
+// Probes run every few seconds - the provider is called at most once per cache window
+func cachedProviderCheck(provider string) DependencyStatus {
+	providerChecksMu.Lock()
+	defer providerChecksMu.Unlock()
+
+	ttl := time.Duration(configs.READINESS_PROVIDER_CACHE_SECONDS) * time.Second
+	if cached, ok := providerChecks[provider]; ok && time.Since(cached.checkedAt) < ttl {
+		status := cached.status
+		status.Cached = true
+		return status
+	}
+
+	status := runDependencyCheck(func(ctx context.Context) error {
+		return ai.CheckProviderCredentials(ctx, provider)
+	})
+	providerChecks[provider] = providerCheck{status: status, checkedAt: time.Now()}
+	return status
+}
@@ -0,0 +1,198 @@
+// idempotency.go - Idempotency-Key middleware to prevent duplicate expensive analyses
+//
+// Key source: "Idempotency-Key" header, or "client_request_id" field in the JSON body
+// Behavior for a repeated key (same shop) within IDEMPOTENCY_TTL_HOURS:
+//   - same payload, original completed  → replay original response (header Idempotent-Replayed: true)
+//   - same payload, original in progress → 409 Conflict (retry later)
+//   - different payload                  → 422 Unprocessable Entity (key reused with another request)
+// Only successful (2xx) responses are cached - failed requests release the key so clients can retry
+// A shop key sending another shop's shopid gets 403 before any lookup (no replay of another shop's result)
+
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength guards against abuse of the key as a storage vector
+const maxIdempotencyKeyLength = 255
+
+// idempotencyStaleMargin - time on top of REQUEST_TIMEOUT before a "processing" record counts as abandoned
+// (waiting for a processing slot, storing the result)
+const idempotencyStaleMargin = 5 * time.Minute
+
+// idempotencyStaleAfter - a "processing" record older than this is treated as abandoned
+// Follows REQUEST_TIMEOUT so a longer timeout never lets a retry take over an analysis that is still running
+func idempotencyStaleAfter() time.Duration {
+	return requestTimeout() + idempotencyStaleMargin
+}
+
+// bodyCaptureWriter copies the response body so it can be stored for replay
+type bodyCaptureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyCaptureWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *bodyCaptureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware honors Idempotency-Key / client_request_id for JSON endpoints
+func IdempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rawBody, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error":   "Failed to read request body",
+				"details": err.Error(),
+			})
+			return
+		}
+		// Restore body for the actual handler
+		c.Request.Body = io.NopCloser(bytes.NewReader(rawBody))
+
+		var payload map[string]interface{}
+		if err := json.Unmarshal(rawBody, &payload); err != nil {
+			// Let the handler produce its usual validation error
+			c.Next()
+			return
+		}
+
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			key, _ = payload["client_request_id"].(string)
+		}
+		shopID, _ := payload["shopid"].(string)
+		if key == "" || shopID == "" {
+			c.Next()
+			return
+		}
+		// Shop check before the key is reserved or replayed - the replay never reaches the handler's check
+		if rejectForeignShop(c, shopID) {
+			c.Abort()
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid_idempotency_key",
+				"message": "Idempotency-Key ยาวเกินไป (สูงสุด 255 ตัวอักษร)",
+			})
+			return
+		}
+
+		payloadHash := hashIdempotentPayload(payload, payloadScope(c))
+		ttl := time.Duration(configs.IDEMPOTENCY_TTL_HOURS) * time.Hour
+
+		existing, err := storage.ReserveIdempotencyKey(shopID, key, payloadHash, ttl)
+		if err != nil {
+			// Storage problems must not block processing - continue without idempotency
+			log.Printf("⚠️  Idempotency check skipped (key: %s): %v", key, err)
+			c.Next()
+			return
+		}
+
+		if existing != nil {
+			if existing.PayloadHash != payloadHash {
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+					"error":           "idempotency_key_conflict",
+					"message":         "Idempotency-Key นี้ถูกใช้กับคำขอที่มีข้อมูลต่างกันแล้ว กรุณาใช้ key ใหม่สำหรับคำขอใหม่",
+					"idempotency_key": key,
+					"request_id":      existing.RequestID,
+				})
+				return
+			}
+
+			switch existing.Status {
+			case storage.IdempotencyStatusCompleted:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(existing.StatusCode, "application/json; charset=utf-8", []byte(existing.ResponseBody))
+				c.Abort()
+				return
+
+			case storage.IdempotencyStatusProcessing:
+				tookOver, err := storage.TakeOverIdempotencyKey(shopID, key, time.Now().Add(-idempotencyStaleAfter()))
+				if err != nil || !tookOver {
+					c.Header("Retry-After", "30")
+					c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+						"error":           "idempotency_key_in_progress",
+						"message":         "คำขอเดิมที่ใช้ Idempotency-Key นี้ยังประมวลผลอยู่ กรุณาลองใหม่ภายหลัง",
+						"idempotency_key": key,
+					})
+					return
+				}
+				log.Printf("♻️  Taking over abandoned idempotency key: %s", key)
+			}
+		}
+
+		// Capture response and persist it after the handler finishes
+		writer := &bodyCaptureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		status := writer.Status()
+		if status >= 200 && status < 300 {
+			requestID := ""
+			var response map[string]interface{}
+			if err := json.Unmarshal(writer.body.Bytes(), &response); err == nil {
+				if metadata, ok := response["metadata"].(map[string]interface{}); ok {
+					requestID, _ = metadata["request_id"].(string)
+				}
+			}
+			if err := storage.CompleteIdempotencyKey(shopID, key, requestID, status, writer.body.String()); err != nil {
+				log.Printf("⚠️  Failed to store idempotent response (key: %s): %v", key, err)
+			}
+			return
+		}
+
+		if err := storage.ReleaseIdempotencyKey(shopID, key); err != nil {
+			log.Printf("⚠️  Failed to release idempotency key %s: %v", key, err)
+		}
+	}
+}
+
+// payloadScope is the route / query part of the payload hash
+// Other API versions hash their route too (the same key on v1 and v2 = a different request); v1 hashes are unchanged
+func payloadScope(c *gin.Context) string {
+	scope := c.Request.URL.Query().Encode()
+	if route := c.FullPath(); route != "/api/v1/analyze-receipt" {
+		scope = route + "?" + scope
+	}
+	return scope
+}
+
+// hashIdempotentPayload hashes the request payload (without client_request_id) and query string
+// encoding/json sorts map keys, so semantically equal payloads produce the same hash
+func hashIdempotentPayload(payload map[string]interface{}, query string) string {
+	canonical := make(map[string]interface{}, len(payload)+1)
+	canonical["__query"] = query
+	for k, v := range payload {
+		if k == "client_request_id" {
+			continue
+		}
+		canonical[k] = v
+	}
+	data, _ := json.Marshal(canonical)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
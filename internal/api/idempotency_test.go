@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotencyStaleAfterFollowsRequestTimeout(t *testing.T) {
+	if got, want := idempotencyStaleAfter(), 300*time.Second+idempotencyStaleMargin; got != want {
+		t.Errorf("default stale after = %v, want %v", got, want)
+	}
+
+	t.Cleanup(func() { configs.ReloadConfig() }) // runs after the env is restored
+	t.Setenv("REQUEST_TIMEOUT", "900")
+	if err := configs.ReloadConfig(); err != nil {
+		t.Fatalf("ReloadConfig: %v", err)
+	}
+	if got, want := idempotencyStaleAfter(), 15*time.Minute+idempotencyStaleMargin; got != want {
+		t.Errorf("stale after (REQUEST_TIMEOUT=900) = %v, want %v", got, want)
+	}
+}
+
+// TestIdempotencyMiddlewareForeignShop - a shop key cannot replay another shop's result by reusing its client_request_id
+// The 403 comes before the idempotency store is read (the test has no MongoDB - a lookup would panic)
+func TestIdempotencyMiddlewareForeignShop(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setTestAPIKeys(t, "", map[string]string{"s1": "key-s1", "s2": "key-s2"})
+	handlerRuns := 0
+	router := gin.New()
+	router.POST("/api/v1/analyze-receipt", RequireRole(RoleShop), IdempotencyMiddleware(), func(c *gin.Context) {
+		handlerRuns++
+		c.JSON(http.StatusOK, gin.H{"status": "success"})
+	})
+
+	for _, header := range []string{"", "receipt-001"} {
+		body := `{"shopid":"s1","client_request_id":"receipt-001","imagereferences":[{"imageuri":"https://files.test/a.jpg"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/analyze-receipt", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer key-s2")
+		if header != "" {
+			req.Header.Set(idempotencyKeyHeader, header)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden || rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("Idempotency-Key %q: status = %d (replayed %q), want 403", header, rec.Code, rec.Header().Get("Idempotent-Replayed"))
+		}
+	}
+	if handlerRuns != 0 {
+		t.Errorf("handler runs = %d, want 0", handlerRuns)
+	}
+}
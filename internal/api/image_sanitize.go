@@ -0,0 +1,30 @@
+// image_sanitize.go - Strip image metadata right after a file is received (STRIP_IMAGE_METADATA)
+//
+// ทำหลังสแกนมัลแวร์ ก่อนเก็บไฟล์ ทำ thumbnail หรือส่งให้ Gemini / Mistral
+// → GPS และข้อมูลเครื่องถ่ายไม่ออกไปนอกระบบ; orientation ถูกหมุนลงพิกเซลแล้ว
+// รายงานใน metadata.image_sanitization
+
+package api
+
+import (
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+)
+
+// sanitizeDocumentFile strips the metadata of the image at path in place and records it
+// A file that cannot be parsed is left as is (OCR reports the broken image)
+func sanitizeDocumentFile(reqCtx *common.RequestContext, path string, imageIndex int) {
+	if !configs.Get().StripImageMetadata {
+		return
+	}
+	sanitization, err := processor.SanitizeImageFile(path)
+	if err != nil {
+		reqCtx.LogWarning("⚠️  Image %d metadata not stripped: %v", imageIndex, err)
+		return
+	}
+	if sanitization != nil {
+		sanitization.ImageIndex = imageIndex
+		reqCtx.RecordImageSanitization(*sanitization)
+	}
+}
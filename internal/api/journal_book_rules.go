@@ -0,0 +1,141 @@
+// journal_book_rules.go - Per-shop journal book rule configuration
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// JournalBookRulesRequest replaces the whole rule set of a shop
+type JournalBookRulesRequest struct {
+	Rules []storage.JournalBookRule `json:"rules"`
+}
+
+// JournalBookRulesResponse lists the rules of a shop (ordered by priority)
+type JournalBookRulesResponse struct {
+	ShopID string                    `json:"shopid"`
+	Rules  []storage.JournalBookRule `json:"rules"`
+}
+
+// GetJournalBookRulesHandler handles GET /api/v1/shops/:shopid/journal-book-rules
+func GetJournalBookRulesHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	rules, err := storage.GetJournalBookRules(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load journal book rules",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, JournalBookRulesResponse{ShopID: shopID, Rules: rules})
+}
+
+// PutJournalBookRulesHandler handles PUT /api/v1/shops/:shopid/journal-book-rules
+func PutJournalBookRulesHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	var req JournalBookRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Validate rules against the shop's journal books
+	masterCache, err := dataStore.GetOrLoadMasterData(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to load master data",
+			"details": err.Error(),
+		})
+		return
+	}
+	journalBookCodes := map[string]bool{}
+	for _, jb := range masterCache.JournalBooks {
+		if code, ok := jb["code"].(string); ok {
+			journalBookCodes[code] = true
+		}
+	}
+
+	for i := range req.Rules {
+		rule := &req.Rules[i]
+		if err := validateJournalBookRule(rule, journalBookCodes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":      "Invalid journal book rule",
+				"details":    err.Error(),
+				"rule_index": i,
+			})
+			return
+		}
+		if rule.RuleID == "" {
+			rule.RuleID = uuid.New().String()
+		}
+	}
+
+	if err := storage.ReplaceJournalBookRules(shopID, req.Rules); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save journal book rules",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Rules are part of the master data cache - reload on the next request
+	dataStore.InvalidateCache(shopID)
+
+	rules, err := storage.GetJournalBookRules(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load journal book rules",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, JournalBookRulesResponse{ShopID: shopID, Rules: rules})
+}
+
+// validateJournalBookRule normalizes conditions and checks the journal book exists
+func validateJournalBookRule(rule *storage.JournalBookRule, journalBookCodes map[string]bool) error {
+	if rule.JournalBookCode == "" {
+		return fmt.Errorf("journal_book_code is required")
+	}
+	if !journalBookCodes[rule.JournalBookCode] {
+		return fmt.Errorf("journal book %q not found in shop", rule.JournalBookCode)
+	}
+
+	rule.DocumentType = normalizeRuleCondition(rule.DocumentType)
+	rule.VAT = normalizeRuleCondition(rule.VAT)
+	rule.Direction = normalizeRuleCondition(rule.Direction)
+
+	switch rule.VAT {
+	case "any", "with", "without":
+	default:
+		return fmt.Errorf("vat must be with, without or any (got %q)", rule.VAT)
+	}
+	switch rule.Direction {
+	case "any", "purchase", "sale":
+	default:
+		return fmt.Errorf("direction must be purchase, sale or any (got %q)", rule.Direction)
+	}
+	return nil
+}
+
+// normalizeRuleCondition lowercases a condition ("" = any)
+func normalizeRuleCondition(condition string) string {
+	condition = strings.ToLower(strings.TrimSpace(condition))
+	if condition == "" {
+		return "any"
+	}
+	return condition
+}
@@ -0,0 +1,49 @@
+// local_run.go - Run analyze-receipt / reanalyze in-process (receiptctl CLI, queue worker, re-processing campaigns)
+//
+// ส่ง payload เดียวกับ POST /api/v1/analyze-receipt เข้า handler ตรงๆ โดยไม่ผ่าน HTTP server
+// ได้ status code + JSON body เหมือนที่ client ของ API ได้รับ
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	localRouter     *gin.Engine
+	localRouterOnce sync.Once
+)
+
+// RunAnalyzeReceipt runs the analyze-receipt handler on a JSON payload (ExtractRequest)
+// ctx cancels the analysis like a client disconnect (no drain / idempotency middleware)
+func RunAnalyzeReceipt(ctx context.Context, payload []byte) (int, []byte) {
+	return runLocal(ctx, "/api/v1/analyze-receipt", payload)
+}
+
+// runLocalReanalysis runs the reanalyze handler on a stored request (payload = ReanalyzeRequest JSON)
+func runLocalReanalysis(ctx context.Context, requestID string, payload []byte) (int, []byte) {
+	return runLocal(ctx, "/api/v1/results/"+url.PathEscape(requestID)+"/reanalyze", payload)
+}
+
+// runLocal sends a POST to the in-process router
+func runLocal(ctx context.Context, path string, payload []byte) (int, []byte) {
+	localRouterOnce.Do(func() {
+		gin.SetMode(gin.ReleaseMode)
+		localRouter = gin.New()
+		localRouter.POST("/api/v1/analyze-receipt", AnalyzeReceiptHandler)
+		localRouter.POST("/api/v1/results/:request_id/reanalyze", ReanalyzeResultHandler)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	localRouter.ServeHTTP(recorder, req)
+	return recorder.Code, recorder.Body.Bytes()
+}
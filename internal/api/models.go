@@ -0,0 +1,207 @@
+// models.go - Typed response models used for API documentation and client generation
+
+package api
+
+import (
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+)
+
+// ErrorResponse represents the common error body returned by all endpoints
+type ErrorResponse struct {
+	Error       string   `json:"error"`
+	Message     string   `json:"message,omitempty"`
+	Details     string   `json:"details,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"` // content_blocked: how to fix the image
+	RequestID   string   `json:"request_id,omitempty"`
+}
+
+// ReceiptData represents the structured receipt section of an analysis result
+type ReceiptData struct {
+	Number                string                 `json:"number"`
+	Date                  string                 `json:"date"` // YYYY-MM-DD (ค.ศ.)
+	VendorName            string                 `json:"vendor_name"`
+	VendorTaxID           string                 `json:"vendor_tax_id"`
+	Total                 float64                `json:"total"`
+	VAT                   *float64               `json:"vat"`                     // null when the document does not state VAT explicitly
+	VATBreakdown          []processor.VATBase    `json:"vat_breakdown,omitempty"` // Documents with several VAT rates / exempt items
+	ExemptAmount          *float64               `json:"exempt_amount,omitempty"`
+	WithholdingTax        *float64               `json:"withholding_tax,omitempty"` // ภาษีหัก ณ ที่จ่ายที่ระบุในเอกสาร (template formula)
+	Currency              string                 `json:"currency,omitempty"`        // ISO 4217 (foreign documents)
+	PaymentMethod         string                 `json:"payment_method,omitempty"`
+	PaymentProofAvailable bool                   `json:"payment_proof_available,omitempty"`
+	CustomFields          map[string]interface{} `json:"custom_fields,omitempty"` // Shop-defined fields (shopSettings.custom_fields), null = not found
+}
+
+// BalanceCheck represents the double-entry validation result
+type BalanceCheck struct {
+	Balanced    bool         `json:"balanced"`
+	TotalDebit  money.Amount `json:"total_debit"`
+	TotalCredit money.Amount `json:"total_credit"`
+	// Lines whose debit / credit is not a readable amount (e.g. "12-15") - the entry is never balanced with them
+	InvalidAmountLines []int `json:"invalid_amount_lines,omitempty"`
+}
+
+// AccountingEntryData represents the accounting entry section of an analysis result
+type AccountingEntryData struct {
+	DocumentDate    string         `json:"document_date"`
+	ReferenceNumber string         `json:"reference_number"`
+	JournalBookCode string         `json:"journal_book_code"`
+	JournalBookName string         `json:"journal_book_name"`
+	CreditorCode    string         `json:"creditor_code,omitempty"`
+	CreditorName    string         `json:"creditor_name,omitempty"`
+	DebtorCode      string         `json:"debtor_code,omitempty"`
+	DebtorName      string         `json:"debtor_name,omitempty"`
+	Entries         []JournalEntry `json:"entries"`
+	BalanceCheck    BalanceCheck   `json:"balance_check"`
+}
+
+// CustomPrompts represents the shop/template prompts that were applied during analysis
+type CustomPrompts struct {
+	ShopContext      string `json:"shop_context"`
+	TemplateGuidance string `json:"template_guidance"`
+}
+
+// AnalyzeReceiptResponse represents the successful response of /api/v1/analyze-receipt
+type AnalyzeReceiptResponse struct {
+	ShopID           string                   `json:"shopid"`
+	Status           string                   `json:"status"`
+	DocumentAnalysis map[string]interface{}   `json:"document_analysis"`
+	Receipt          ReceiptData              `json:"receipt"`
+	AccountingEntry  AccountingEntryData      `json:"accounting_entry"`
+	Validation       map[string]interface{}   `json:"validation"`
+	TemplateInfo     map[string]interface{}   `json:"template_info"`
+	CustomPrompts    CustomPrompts            `json:"custom_prompts"`
+	SourceImages     []map[string]interface{} `json:"source_images"`
+	Metadata         map[string]interface{}   `json:"metadata"`
+	RawDocumentTexts []RawDocumentText        `json:"raw_document_texts,omitempty"` // only when include_raw_text=true
+	DebugData        map[string]interface{}   `json:"debug_data,omitempty"`         // only when ?debug=true
+}
+
+// RawDocumentText is the OCR text of one submitted image (include_raw_text=true)
+type RawDocumentText struct {
+	ImageIndex        int    `json:"image_index"`
+	DocumentImageGUID string `json:"documentimageguid"`
+	RawDocumentText   string `json:"raw_document_text"` // "" when OCR of the image failed
+	TextLength        int    `json:"text_length"`       // Characters
+	IsPartial         bool   `json:"is_partial"`        // Text was cut at the output token limit
+	Error             string `json:"error,omitempty"`   // Why OCR of the image failed
+}
+
+// TestTemplateRequest documents the multipart form accepted by /api/v1/test-template
+type TestTemplateRequest struct {
+	ShopID   string `json:"shopid"`
+	Template string `json:"template"` // JSON string with doccode, description, promptdescription, details
+	Model    string `json:"model"`    // "gemini" or "mistral"
+	File     []byte `json:"file" format:"binary"`
+}
+
+// TestTemplateResponse represents the successful response of /api/v1/test-template
+type TestTemplateResponse struct {
+	AnalyzeReceiptResponse
+	Mode          string                 `json:"mode"` // "test_template"
+	TemplateMatch map[string]interface{} `json:"template_match"`
+}
+
+// AnalyzeReceiptV2Response is the stable response of /api/v2/analyze-receipt
+// Every field is always present with one type: amounts are numbers (2 decimals), missing text is "" (never "N/A"),
+// one documents[] entry per document (v1: accounting_entry + accounting_entries), no Thai/English duplicates
+type AnalyzeReceiptV2Response struct {
+	APIVersion string       `json:"api_version"` // "2"
+	RequestID  string       `json:"request_id"`
+	ShopID     string       `json:"shop_id"`
+	Status     string       `json:"status"` // "success"
+	Documents  []V2Document `json:"documents"`
+	Review     V2Review     `json:"review"`
+	Template   V2Template   `json:"template"`
+	Images     []V2Image    `json:"images"`
+	Usage      V2Usage      `json:"usage"`
+}
+
+// V2Document is one analyzed document (receipt fields + its journal entry)
+type V2Document struct {
+	ImageIndices   []int        `json:"image_indices"`
+	Receipt        V2Receipt    `json:"receipt"`
+	Journal        V2Journal    `json:"journal"`
+	Confidence     V2Confidence `json:"confidence"`
+	RequiresReview bool         `json:"requires_review"`
+}
+
+// V2Receipt is the document header read from the images
+type V2Receipt struct {
+	Number        string        `json:"number"`
+	Date          string        `json:"date"` // YYYY-MM-DD (ค.ศ.), "" = not found
+	VendorName    string        `json:"vendor_name"`
+	VendorTaxID   string        `json:"vendor_tax_id"`
+	VendorBranch  string        `json:"vendor_branch"` // 00000 = head office, "" = not stated
+	Total         money.Amount  `json:"total"`
+	VAT           *money.Amount `json:"vat"` // null = the document does not state VAT
+	Currency      string        `json:"currency"`
+	PaymentMethod string        `json:"payment_method"`
+}
+
+// V2Journal is the journal entry of a document
+type V2Journal struct {
+	DocumentDate    string          `json:"document_date"`
+	ReferenceNumber string          `json:"reference_number"`
+	JournalBook     V2Code          `json:"journal_book"`
+	Creditor        *V2Code         `json:"creditor"` // null = none
+	Debtor          *V2Code         `json:"debtor"`   // null = none
+	Lines           []V2JournalLine `json:"lines"`
+	TotalDebit      money.Amount    `json:"total_debit"`
+	TotalCredit     money.Amount    `json:"total_credit"`
+	Balanced        bool            `json:"balanced"`
+}
+
+// V2Code is a master data reference (journal book, creditor, debtor)
+type V2Code struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// V2JournalLine is one posting line
+type V2JournalLine struct {
+	AccountCode string            `json:"account_code"`
+	AccountName string            `json:"account_name"`
+	Debit       money.Amount      `json:"debit"`
+	Credit      money.Amount      `json:"credit"`
+	Description string            `json:"description"`
+	Dimensions  map[string]string `json:"dimensions"` // {} = none
+}
+
+// V2Confidence is a confidence score (0-100) and its level
+type V2Confidence struct {
+	Score float64 `json:"score"`
+	Level string  `json:"level"` // high / medium / low
+}
+
+// V2Review says whether an accountant must check the result and which fields
+type V2Review struct {
+	Required   bool         `json:"required"`
+	Confidence V2Confidence `json:"confidence"`
+	Fields     []string     `json:"fields"` // Receipt fields that could not be read ([] = none)
+}
+
+// V2Template is the accounting template used for the entry
+type V2Template struct {
+	Used       bool    `json:"used"`
+	Name       string  `json:"name"`
+	Confidence float64 `json:"confidence"` // 0 when no template was used
+}
+
+// V2Image is one submitted image
+type V2Image struct {
+	ImageIndex        int    `json:"image_index"`
+	DocumentImageGUID string `json:"documentimageguid"`
+	OCRText           string `json:"ocr_text,omitempty"` // include_raw_text=true
+	OCRError          string `json:"ocr_error,omitempty"`
+}
+
+// V2Usage is the processing time and cost of the request
+type V2Usage struct {
+	OCRProvider  string  `json:"ocr_provider"`
+	DurationSec  float64 `json:"duration_sec"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	CostTHB      float64 `json:"cost_thb"`
+}
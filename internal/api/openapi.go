@@ -0,0 +1,1366 @@
+// openapi.go - OpenAPI 3 specification generated from the typed request/response models
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// apiRoute describes a single documented endpoint
+// RequestBody and response bodies are zero values of the typed models (nil = no body)
+type apiRoute struct {
+	Method             string
+	Path               string // gin-style path, e.g. /api/v1/results/:request_id
+	Summary            string
+	Description        string
+	Tag                string
+	Role               string // RoleShop / RoleAdmin (RequireRole in cmd/api/main.go), empty = public
+	Params             []apiParam
+	RequestBody        interface{}
+	RequestContentType string // default: application/json
+	Responses          map[int]apiResponse
+}
+
+// apiParam describes a path, query or header parameter
+type apiParam struct {
+	Name        string
+	In          string // "path", "query" or "header"
+	Description string
+	Required    bool
+	Type        string // "string", "integer", "boolean"
+}
+
+// apiResponse describes a single response of an endpoint
+type apiResponse struct {
+	Description string
+	Body        interface{}
+}
+
+// apiRoutes is the registry of documented endpoints
+// ⚠️ เพิ่ม endpoint ใหม่ที่นี่ทุกครั้ง เพื่อให้ client generator เห็น endpoint นั้น
+var apiRoutes = []apiRoute{
+	{
+		Method:      http.MethodGet,
+		Path:        "/healthz",
+		Summary:     "Liveness probe",
+		Description: "Always 200 while the process is serving requests. Does not check dependencies.",
+		Tag:         "health",
+		Responses: map[int]apiResponse{
+			http.StatusOK: {Description: "Process is alive"},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/readyz",
+		Summary:     "Readiness probe with per-dependency status",
+		Description: "Checks MongoDB ping and UPLOAD_DIR writability; not ready while draining for shutdown. With READINESS_CHECK_PROVIDERS=true also verifies the Gemini (and Mistral, when configured) API key; provider results are cached for READINESS_PROVIDER_CACHE_SECONDS.",
+		Tag:         "health",
+		Responses: map[int]apiResponse{
+			http.StatusOK:                 {Description: "All dependencies ok", Body: ReadinessResponse{}},
+			http.StatusServiceUnavailable: {Description: "At least one dependency failed", Body: ReadinessResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/analyze-receipt",
+		Summary:     "Analyze receipt images and create an accounting entry",
+		Description: "Downloads the referenced images, runs OCR, template matching and AI accounting analysis against the shop's master data. New clients should use /api/v2/analyze-receipt; once listed in API_DEPRECATIONS the response carries Deprecation / Sunset / Link headers (410 Gone after the sunset date with API_SUNSET_ENFORCED).",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		Params: []apiParam{
+			{Name: "debug", In: "query", Description: "Include pure OCR results and template match details", Type: "boolean"},
+			{Name: "fields", In: "query", Description: "Sparse fieldset (same as body fields): comma-separated dotted paths, e.g. receipt,accounting_entry,validation.confidence. status, shopid and metadata.request_id are always returned"},
+			{Name: "response_profile", In: "query", Description: "minimal (receipt, accounting_entry, validation.confidence / requires_review), standard (without custom_prompts, template_match, ai_explanation, cost breakdown and steps) or full (default)"},
+			{Name: "Idempotency-Key", In: "header", Description: "Repeats with the same key and payload replay the original result"},
+		},
+		RequestBody: ExtractRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                    {Description: "Analysis completed (or replayed for a repeated Idempotency-Key, or shared with an identical request in progress: header Request-Coalesced)", Body: AnalyzeReceiptResponse{}},
+			http.StatusBadRequest:            {Description: "Invalid request or missing master data", Body: ErrorResponse{}},
+			http.StatusPaymentRequired:       {Description: "Projected cost exceeded max_cost_thb (aborted before the next AI call)", Body: ErrorResponse{}},
+			http.StatusConflict:              {Description: "A request with the same Idempotency-Key is still processing", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity:   {Description: "Idempotency-Key was reused with a different payload, or the document was blocked by AI content filters (error: content_blocked, with suggestions), or the estimated processing time exceeds the limit (error: too_complex, with complexity.suggested_batches), or malware found by the file scanner (error: malicious_file, with signature)", Body: ErrorResponse{}},
+			http.StatusRequestTimeout:        {Description: "Processing exceeded the time limit (max_processing_seconds or REQUEST_TIMEOUT)", Body: ErrorResponse{}},
+			http.StatusRequestEntityTooLarge: {Description: "Request above a size limit (error: payload_too_large, reason: body / image_count / image_size / total_payload, with limit and guidance) or image refused by the payload guard (error: image_too_large)", Body: ErrorResponse{}},
+			http.StatusGone:                  {Description: "v1 was retired (API_DEPRECATIONS sunset date passed and API_SUNSET_ENFORCED=true)", Body: ErrorResponse{}},
+			http.StatusServiceUnavailable:    {Description: "File scanner (FILE_SCAN_DRIVER) unavailable and FILE_SCAN_FAIL_OPEN=false (error: file_scan_unavailable, Retry-After)", Body: ErrorResponse{}},
+			http.StatusInternalServerError:   {Description: "Download, OCR or AI analysis failed", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v2/analyze-receipt",
+		Summary:     "Analyze receipt images (v2 typed response)",
+		Description: "Same request and pipeline as /api/v1/analyze-receipt with a stable, typed response: amounts are numbers, missing text is \"\" (never \"N/A\"), one documents[] entry per document (receipt + journal), review / template / usage sections and no debug or prompt data. Errors keep the v1 ErrorResponse body. fields / response_profile are not supported (400).",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		Params: []apiParam{
+			{Name: "Idempotency-Key", In: "header", Description: "Repeats with the same key and payload replay the original result (keys are not shared with v1)"},
+		},
+		RequestBody: ExtractRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                    {Description: "Analysis completed (or shared with an identical request in progress: header Request-Coalesced)", Body: AnalyzeReceiptV2Response{}},
+			http.StatusBadRequest:            {Description: "Invalid request, missing master data or fields / response_profile given", Body: ErrorResponse{}},
+			http.StatusPaymentRequired:       {Description: "Projected cost exceeded max_cost_thb", Body: ErrorResponse{}},
+			http.StatusConflict:              {Description: "A request with the same Idempotency-Key is still processing", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity:   {Description: "Idempotency-Key reused with a different payload, content blocked or too complex (same as v1), or malware found by the file scanner (error: malicious_file, with signature)", Body: ErrorResponse{}},
+			http.StatusRequestTimeout:        {Description: "Processing exceeded the time limit", Body: ErrorResponse{}},
+			http.StatusRequestEntityTooLarge: {Description: "Request above a size limit (same as v1)", Body: ErrorResponse{}},
+			http.StatusServiceUnavailable:    {Description: "File scanner (FILE_SCAN_DRIVER) unavailable and FILE_SCAN_FAIL_OPEN=false (error: file_scan_unavailable, Retry-After)", Body: ErrorResponse{}},
+			http.StatusInternalServerError:   {Description: "Download, OCR or AI analysis failed", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/analyze-zip",
+		Summary:     "Analyze every document inside a zip archive",
+		Description: "Extracts the jpg / png / pdf files of a zip (multipart/form-data with file, shopid and model, or JSON with zip_url) and runs analyze-receipt on each file as its own document (documentimageguid = path inside the zip). Returns a consolidated summary; files above ZIP_MAX_FILES, of other types or over the extraction caps (IMAGE_REJECT_MAX_BYTES per file, ZIP_MAX_EXTRACTED_BYTES per archive) are listed in skipped.",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		RequestBody: ZipAnalysisRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                    {Description: "Per-file results (request_id, document number, total, requires_review) and totals", Body: ZipAnalysisResponse{}},
+			http.StatusBadRequest:            {Description: "Missing shopid / file / zip_url, invalid model or not a zip", Body: ErrorResponse{}},
+			http.StatusRequestEntityTooLarge: {Description: "Archive above ZIP_MAX_BYTES", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity:   {Description: "No jpg / png / pdf files in the archive", Body: ErrorResponse{}},
+			http.StatusBadGateway:            {Description: "zip_url could not be downloaded", Body: ErrorResponse{}},
+			http.StatusServiceUnavailable:    {Description: "Server is draining for shutdown", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:             http.MethodPost,
+		Path:               "/api/v1/test-template",
+		Summary:            "Test an accounting template against an uploaded document",
+		Description:        "Runs OCR on the uploaded file and forces the given template during accounting analysis.",
+		Tag:                "templates",
+		Role:               RoleShop,
+		RequestBody:        TestTemplateRequest{},
+		RequestContentType: "multipart/form-data",
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Template test completed", Body: TestTemplateResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid form data or template", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity: {Description: "Template details failed validation (error: invalid template, with template_validation) or document was blocked by AI content filters (error: content_blocked), or malware found by the file scanner (error: malicious_file, with signature)", Body: ErrorResponse{}},
+			http.StatusServiceUnavailable:  {Description: "File scanner (FILE_SCAN_DRIVER) unavailable and FILE_SCAN_FAIL_OPEN=false (error: file_scan_unavailable, Retry-After)", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "OCR or AI analysis failed", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/classify-document",
+		Summary:     "Classify a document without accounting analysis",
+		Description: "Runs OCR only and detects the document type (receipt, tax_invoice, wht_certificate, utility_bill, payment_slip, other) from keywords in the text. No master data is needed. Send JSON with imageuri, or multipart/form-data with file, shopid and model (default: gemini).",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		RequestBody: StandaloneDocumentRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                    {Description: "Document type with score and matched keywords", Body: ClassifyDocumentResponse{}},
+			http.StatusBadRequest:            {Description: "Missing shopid / file / imageuri or invalid model", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity:   {Description: "Document was blocked by AI content filters (error: content_blocked, with suggestions), or malware found by the file scanner (error: malicious_file, with signature)", Body: ErrorResponse{}},
+			http.StatusRequestEntityTooLarge: {Description: "Upload above MAX_UPLOAD_BODY_BYTES or downloaded file above IMAGE_REJECT_MAX_BYTES (error: payload_too_large)", Body: ErrorResponse{}},
+			http.StatusGatewayTimeout:        {Description: "Download or OCR exceeded its phase timeout (error: phase_timeout)", Body: ErrorResponse{}},
+			http.StatusServiceUnavailable:    {Description: "File scanner (FILE_SCAN_DRIVER) unavailable and FILE_SCAN_FAIL_OPEN=false (error: file_scan_unavailable, Retry-After)", Body: ErrorResponse{}},
+			http.StatusInternalServerError:   {Description: "Download or OCR failed", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/ocr",
+		Summary:     "OCR a document (raw text only)",
+		Description: "Runs pure OCR on one image or PDF through the same providers as analyze-receipt (rate limit, safety fallback, chunked OCR). No master data and no accounting analysis. Send JSON with imageuri, or multipart/form-data with file, shopid and model (default: gemini).",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		RequestBody: StandaloneDocumentRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                    {Description: "Raw document text with warnings and token usage", Body: PureOCRResponse{}},
+			http.StatusBadRequest:            {Description: "Missing shopid / file / imageuri or invalid model", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity:   {Description: "Document was blocked by AI content filters (error: content_blocked, with suggestions), or malware found by the file scanner (error: malicious_file, with signature)", Body: ErrorResponse{}},
+			http.StatusRequestEntityTooLarge: {Description: "Upload above MAX_UPLOAD_BODY_BYTES or downloaded file above IMAGE_REJECT_MAX_BYTES (error: payload_too_large)", Body: ErrorResponse{}},
+			http.StatusGatewayTimeout:        {Description: "Download or OCR exceeded its phase timeout (error: phase_timeout)", Body: ErrorResponse{}},
+			http.StatusServiceUnavailable:    {Description: "File scanner (FILE_SCAN_DRIVER) unavailable and FILE_SCAN_FAIL_OPEN=false (error: file_scan_unavailable, Retry-After)", Body: ErrorResponse{}},
+			http.StatusInternalServerError:   {Description: "Download or OCR failed", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/quality-check",
+		Summary:     "Check photo quality before analysis",
+		Description: "Measures the photo only (no OCR or AI call, no cost): brightness, contrast, sharpness, glare, uneven lighting, text density and text cut by the frame. Returns a 0-100 score, the metrics and issues with Thai advice; acceptable is false when any issue is blocking (retake the photo). Send JSON with shopid and imageuri, or multipart/form-data with file and shopid. Images only (JPG/PNG).",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		RequestBody: StandaloneDocumentRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Quality score, metrics and issues with advice", Body: QualityCheckResponse{}},
+			http.StatusBadRequest:          {Description: "Missing shopid / file / imageuri, PDF file or unreadable image", Body: ErrorResponse{}},
+			http.StatusGatewayTimeout:      {Description: "Download exceeded its phase timeout (error: phase_timeout)", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Download failed", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/extract",
+		Summary:     "Extract caller-defined fields from a document",
+		Description: "Gemini reads the document into a response schema built from the given fields (types: string, number, integer, boolean, date, array, object; up to 100 fields, 3 levels). Every field is returned; null means it was not found. Send JSON with imageuri, or multipart/form-data with file, shopid and fields as a JSON string. Only model gemini is supported.",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		RequestBody: SchemaExtractRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Extracted values keyed by field name", Body: SchemaExtractResponse{}},
+			http.StatusBadRequest:          {Description: "Missing shopid / file / imageuri, invalid fields or model other than gemini", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity: {Description: "Document was blocked by AI content filters (error: content_blocked, with suggestions), or malware found by the file scanner (error: malicious_file, with signature)", Body: ErrorResponse{}},
+			http.StatusServiceUnavailable:  {Description: "Gemini is disabled through the admin API (error: provider_disabled), or the file scanner is unavailable (error: file_scan_unavailable)", Body: ErrorResponse{}},
+			http.StatusGatewayTimeout:      {Description: "Download or extraction exceeded its phase timeout (error: phase_timeout)", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Download or extraction failed", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/providers",
+		Summary:     "Available OCR providers and models",
+		Description: "OCR providers accepted in the model field (configured, enabled, file types, client-side rate limit, price per token or per page) and the Gemini models of the accounting phases, all from the running configuration. Prices are in USD; usd_to_thb is the rate used for cost_thb.",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		Responses: map[int]apiResponse{
+			http.StatusOK: {Description: "Providers, models, file types and pricing", Body: ProvidersResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/costs",
+		Summary:     "Cost report of a shop",
+		Description: "Aggregates the usage ledger: request count, tokens and THB cost broken down by phase (ocr, template_match, accounting) and provider, plus daily totals.",
+		Tag:         "billing",
+		Role:        RoleAdmin,
+		Params: []apiParam{
+			{Name: "period", In: "query", Description: "YYYY-MM (month) or YYYY-MM-DD (day), default: current month"},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Cost report", Body: storage.ShopCostReport{}},
+			http.StatusBadRequest:          {Description: "Invalid period", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to aggregate the usage ledger", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/journal-book-rules",
+		Summary:     "Journal book rules of a shop",
+		Description: "Rules (document type + VAT presence + direction → journal book code) evaluated after AI analysis, ordered by priority. The first matching rule overrides the journal book chosen by the AI.",
+		Tag:         "rules",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Rules ordered by priority", Body: JournalBookRulesResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load rules", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v1/shops/:shopid/journal-book-rules",
+		Summary:     "Replace the journal book rules of a shop",
+		Description: "Replaces the whole rule set. Conditions: document_type (receipt, invoice, tax_invoice, payment_slip, any), vat (with, without, any), direction (purchase, sale, any). journal_book_code must exist in the shop.",
+		Tag:         "rules",
+		Role:        RoleAdmin,
+		RequestBody: JournalBookRulesRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Saved rules", Body: JournalBookRulesResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid rule or unknown journal book", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to save rules", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/shops/:shopid/accounts/suggest",
+		Summary:     "Suggest accounts for a description",
+		Description: "Ranks the shop's posting accounts (level 3-5) against a free-text description using account code, name containment, character-bigram similarity and related Thai terms. Score 0-100; results below 30 are dropped. limit defaults to 5 (max 20).",
+		Tag:         "accounts",
+		Role:        RoleShop,
+		RequestBody: AccountSuggestRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "Ranked account suggestions", Body: AccountSuggestResponse{}},
+			http.StatusBadRequest: {Description: "Missing description or master data unavailable", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/shops/:shopid/templates/validate",
+		Summary:     "Validate a template against the shop's master data",
+		Description: "Checks a documentFormate template before it is saved: every details[].accountcode must exist in the chart of accounts and be a posting account (level 3-5), the template needs at least two accounts, optional details[].side (debit/credit) must cover both sides, the optional journalbookcode must exist and optional details[].formula must parse (invalid_formula). Unknown / header accounts come with suggested replacements. POST /api/v1/test-template runs the same validation (422 invalid template).",
+		Tag:         "templates",
+		Role:        RoleShop,
+		Responses: map[int]apiResponse{
+			http.StatusOK:         {Description: "Validation result (valid, errors, warnings)", Body: TemplateValidationResponse{}},
+			http.StatusBadRequest: {Description: "Invalid template JSON or master data unavailable", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/template-subscriptions",
+		Summary:     "Library templates the shop subscribed to",
+		Description: "Each subscription with the template the shop gets from it (library account codes mapped to the shop's chart, description overrides applied). Subscribed templates are matched like documentFormate templates with _id library:<library_id>; a shop template with the same description takes precedence.",
+		Tag:         "templates",
+		Role:        RoleShop,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Subscriptions ordered by library_id", Body: TemplateSubscriptionsResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load subscriptions", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v1/shops/:shopid/template-subscriptions/:library_id",
+		Summary:     "Subscribe the shop to a library template",
+		Description: "Creates or replaces the subscription. account_map maps library account codes to the shop's own codes (unmapped codes are used as-is); description, promptdescription and journalbookcode override the library values. The resolved template must pass the same validation as POST /shops/:shopid/templates/validate.",
+		Tag:         "templates",
+		Role:        RoleShop,
+		RequestBody: TemplateSubscriptionRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Saved subscription with the resolved template", Body: TemplateSubscriptionView{}},
+			http.StatusNotFound:            {Description: "Unknown library_id", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity: {Description: "Resolved template is invalid for the shop's chart of accounts (with template_validation)", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to save subscription", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/shops/:shopid/template-subscriptions/:library_id/suggest-mapping",
+		Summary:     "Suggest account_map for a library template",
+		Description: "Maps every library account code to a shop account: mappings already saved in the subscription are kept, codes that exist in the shop's chart are used as-is, the rest get the best name match of the detail text (score >= min_score, default 60). apply=true saves the proposed account_map as the subscription when the resolved template passes validation; analysis uses it from the next request.",
+		Tag:         "templates",
+		Role:        RoleShop,
+		RequestBody: SuggestMappingRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Proposed mappings, account_map, resolved template and its validation", Body: SuggestMappingResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid body or master data unavailable", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "Unknown library_id", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity: {Description: "apply=true but the resolved template is still invalid (with mappings and template_validation)", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to save subscription", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:  http.MethodDelete,
+		Path:    "/api/v1/shops/:shopid/template-subscriptions/:library_id",
+		Summary: "Unsubscribe the shop from a library template",
+		Tag:     "templates",
+		Role:    RoleShop,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Subscription deleted"},
+			http.StatusNotFound:            {Description: "Shop is not subscribed", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to delete subscription", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/template-library",
+		Summary:     "Shared template library",
+		Description: "Standard templates (documentFormate field names, standard account codes) every shop can subscribe to.",
+		Tag:         "templates",
+		Role:        RoleShop,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Library templates ordered by library_id", Body: LibraryTemplatesResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load the library", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v1/template-library/:library_id",
+		Summary:     "Create or replace a library template",
+		Description: "library_id: a-z, 0-9, - and _ (max 64). Needs description, promptdescription and at least two account lines; details[].side (debit / credit) and details[].formula are optional. Changes apply to every subscribed shop on its next analysis.",
+		Tag:         "templates",
+		Role:        RoleAdmin,
+		RequestBody: LibraryTemplateRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Saved library template", Body: storage.LibraryTemplate{}},
+			http.StatusBadRequest:          {Description: "Invalid library_id or template", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to save", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v1/template-library/:library_id",
+		Summary:     "Delete a library template",
+		Description: "Also removes every shop subscription to it.",
+		Tag:         "templates",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Template and subscriptions deleted"},
+			http.StatusNotFound:            {Description: "Unknown library_id", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to delete", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/template-coverage",
+		Summary:     "Template coverage of a shop",
+		Description: "Per-template document counts, average confidence, template-only vs full mode usage and full-mode document types (with vendors) over an inclusive date range. Query: from, to (YYYY-MM-DD, default last 30 days).",
+		Tag:         "analytics",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Coverage report", Body: storage.TemplateCoverageReport{}},
+			http.StatusBadRequest:          {Description: "Invalid date range", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load report", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/template-suggestions",
+		Summary:     "Draft templates from recurring unmatched documents",
+		Description: "Groups full-mode documents by vendor (tax ID or name) and the accounts the AI posted to. Groups seen at least min_documents times in the last days days get a draft documentFormate template (description, promptdescription, details). Query: min_documents (default TEMPLATE_SUGGESTION_MIN_DOCUMENTS), days (default TEMPLATE_SUGGESTION_LOOKBACK_DAYS).",
+		Tag:         "analytics",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Suggestions, most frequent first", Body: TemplateSuggestionsResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid query parameter", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load suggestions", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/template-threshold-report",
+		Summary:     "Recommend the template confidence threshold of a shop",
+		Description: "Uses approved results of the last days days whose analysis had a template candidate. The template was correct when the approved voucher uses exactly the template's accounts. Every threshold is scored as false_positives × fp_weight + false_negatives (template used but wrong vs full mode although the template was right); the lowest score wins, ties go to the higher threshold. No recommendation below 20 labeled documents. Query: days (default 90), fp_weight (default 2).",
+		Tag:         "analytics",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Current vs recommended threshold, every candidate and per-template results", Body: TemplateThresholdReport{}},
+			http.StatusBadRequest:          {Description: "Invalid query parameter", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load report", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/search",
+		Summary:     "Search past documents by OCR text, vendor or amount",
+		Description: "Case-insensitive substring search over the stored OCR text (kept OCR_RESULT_TTL_DAYS days) and the document header of finished analyses (vendor name, tax ID, document number). amount matches the document total (±0.01) or the amount printed in the text. Newest first, with a snippet around the match per image.",
+		Tag:         "analytics",
+		Role:        RoleShop,
+		Params: []apiParam{
+			{Name: "q", In: "query", Description: "Text to find (at least 2 characters; required unless amount is given)"},
+			{Name: "amount", In: "query", Description: "Document amount, e.g. 1234.50"},
+			{Name: "from", In: "query", Description: "First day (YYYY-MM-DD, inclusive)"},
+			{Name: "to", In: "query", Description: "Last day (YYYY-MM-DD, inclusive)"},
+			{Name: "limit", In: "query", Description: "Maximum results (1-100, default 20)", Type: "integer"},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Matching documents, newest first", Body: DocumentSearchResponse{}},
+			http.StatusBadRequest:          {Description: "Missing query or invalid parameter", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "OCR result storage is disabled (OCR_RESULT_TTL_DAYS=0)", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Search failed", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/reports/input-vat",
+		Summary:     "Input VAT report (ภ.พ.30) of a month",
+		Description: "Purchase documents (no debtor, VAT ≠ 0) whose document date is in the month: date, invoice number, vendor, tax ID, branch, base (total - VAT) and VAT. Built from stored results (kept OCR_RESULT_TTL_DAYS days, approved results are kept); re-analyses of a document count once (approved result, else the newest). missing_fields lists what must be completed before filing. format=csv returns a UTF-8 CSV with a total line.",
+		Tag:         "analytics",
+		Role:        RoleShop,
+		Params: []apiParam{
+			{Name: "period", In: "query", Description: "Tax month YYYY-MM (default: previous month)"},
+			{Name: "format", In: "query", Description: "json (default) or csv"},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Report rows ordered by document date with totals (or CSV file)", Body: InputVATReport{}},
+			http.StatusBadRequest:          {Description: "Invalid period or format", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "OCR result storage is disabled (OCR_RESULT_TTL_DAYS=0)", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load documents", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/reports/withholding-tax",
+		Summary:     "Withholding tax report (ภ.ง.ด.3 / ภ.ง.ด.53) of a month",
+		Description: "Documents dated in the month whose accounting entries credit a withholding tax payable account (ภาษีหัก ณ ที่จ่าย; the prepaid ถูกหัก account is ignored): payee, tax ID, income type (largest expense account), amount paid before tax (VAT excluded), rate and tax withheld. Payees with a juristic tax ID (starting with 0) or a company name go to pnd53, individuals to pnd3. Same document selection as reports/input-vat. format=csv|xlsx exports the rows with a total line.",
+		Tag:         "analytics",
+		Role:        RoleShop,
+		Params: []apiParam{
+			{Name: "period", In: "query", Description: "Tax month YYYY-MM (default: previous month)"},
+			{Name: "form", In: "query", Description: "pnd3 or pnd53 (default: both)"},
+			{Name: "format", In: "query", Description: "json (default), csv or xlsx"},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Report rows ordered by payment date with totals per form (or CSV / XLSX file)", Body: WithholdingTaxReport{}},
+			http.StatusBadRequest:          {Description: "Invalid period, form or format", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "OCR result storage is disabled (OCR_RESULT_TTL_DAYS=0)", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load documents", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/reports/document-sequence",
+		Summary:     "Duplicate / missing invoice numbers per creditor",
+		Description: "Compares the document numbers of each creditor's purchase documents (creditor code, else vendor tax ID) over the month and the 12 months before it. duplicate = the same number on more than one document (possible double entry); gap = 1..DOCUMENT_SEQUENCE_MAX_GAP running numbers missing between two documents of the same prefix (possible missing documents). Only anomalies involving a document dated in the month are listed. New analyses run the same check against the creditor's last DOCUMENT_SEQUENCE_HISTORY documents (validation.document_sequence).",
+		Tag:         "analytics",
+		Role:        RoleShop,
+		Params: []apiParam{
+			{Name: "period", In: "query", Description: "Month YYYY-MM (default: previous month)"},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Creditors with anomalies", Body: DocumentSequenceReport{}},
+			http.StatusBadRequest:          {Description: "Invalid period", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "OCR result storage is disabled (OCR_RESULT_TTL_DAYS=0)", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load documents", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/vendor-mappings",
+		Summary:     "Learned creditor → account mappings",
+		Description: "Accounts users approved per creditor. When a document's creditor has a mapping, the account is given to the AI and the main expense line is moved to it after analysis (unless a template was matched). template_info.learned_mapping_used reports whether it was applied.",
+		Tag:         "rules",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Mappings ordered by creditor code", Body: VendorMappingsResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load mappings", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/shops/:shopid/vendor-mappings",
+		Summary:     "Approve the account of a creditor",
+		Description: "Records that documents from creditor_code go to account_code. Approving the same account again increments approvals; a different account replaces the mapping. Both codes must exist in the shop's master data.",
+		Tag:         "rules",
+		Role:        RoleAdmin,
+		RequestBody: VendorMappingApproveRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Saved mapping", Body: storage.VendorAccountMapping{}},
+			http.StatusBadRequest:          {Description: "Missing codes or unknown creditor / account", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to save mapping", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v1/shops/:shopid/vendor-mappings/:creditor_code",
+		Summary:     "Forget the learned account of a creditor",
+		Description: "Later documents from the creditor fall back to the AI's / template's account choice.",
+		Tag:         "rules",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Mapping deleted"},
+			http.StatusNotFound:            {Description: "No mapping for this creditor", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to delete mapping", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/creditor-aliases",
+		Summary:     "Creditor aliases of the shop",
+		Description: "Alternative names (brand / short names) of creditors. Vendor matching checks them after the tax ID and before fuzzy name matching: an OCR vendor name containing an alias matches its creditor with method alias.",
+		Tag:         "rules",
+		Role:        RoleShop,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Aliases ordered by creditor code", Body: CreditorAliasesResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load aliases", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/shops/:shopid/creditor-aliases",
+		Summary:     "Register a creditor alias",
+		Description: "Creates the alias, or moves an existing alias to creditor_code. The creditor must exist in the shop's master data; used from the next analysis.",
+		Tag:         "rules",
+		Role:        RoleShop,
+		RequestBody: CreditorAliasRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Saved alias", Body: storage.CreditorAlias{}},
+			http.StatusBadRequest:          {Description: "Missing alias / creditor_code or unknown creditor", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to save alias", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:  http.MethodDelete,
+		Path:    "/api/v1/shops/:shopid/creditor-aliases/:alias",
+		Summary: "Delete a creditor alias",
+		Tag:     "rules",
+		Role:    RoleShop,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Alias deleted"},
+			http.StatusNotFound:            {Description: "Unknown alias", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to delete alias", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/creditor-branches",
+		Summary:     "Branches of the shop's creditors",
+		Description: "Branches (5-digit code, 00000 = head office) read from analyzed tax invoices or registered by users, with the number of documents per branch. The analysis reports the issuer's branch as receipt.vendor_branch and, for a matched creditor, accounting_entry.creditor_branch_code / creditor_branch.",
+		Tag:         "rules",
+		Role:        RoleShop,
+		Params: []apiParam{
+			{Name: "creditor_code", In: "query", Description: "Only branches of this creditor"},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Branches ordered by creditor and branch code", Body: CreditorBranchesResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load branches", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v1/shops/:shopid/creditor-branches/:creditor_code/:branch_code",
+		Summary:     "Register or rename a creditor branch",
+		Description: "branch_code is 5 digits; the creditor must exist in the shop's master data. The documents count is kept.",
+		Tag:         "rules",
+		Role:        RoleShop,
+		RequestBody: CreditorBranchRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Saved branch", Body: storage.CreditorBranch{}},
+			http.StatusBadRequest:          {Description: "Invalid branch_code or unknown creditor", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to save branch", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:  http.MethodDelete,
+		Path:    "/api/v1/shops/:shopid/creditor-branches/:creditor_code/:branch_code",
+		Summary: "Delete a creditor branch",
+		Tag:     "rules",
+		Role:    RoleShop,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Branch deleted"},
+			http.StatusNotFound:            {Description: "Unknown branch", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to delete branch", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/dimensions",
+		Summary:     "Dimensions of the shop and their values",
+		Description: "definitions = dimensions booked on journal lines (shopSettings.dimensions, e.g. project, cost_center); values = the codes accepted on entries[].dimensions. The analysis assigns a value per line from keyword rules, the AI, then the dimension default and reports validation.dimensions.",
+		Tag:         "rules",
+		Role:        RoleShop,
+		Params: []apiParam{
+			{Name: "key", In: "query", Description: "Only values of this dimension"},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Definitions and values ordered by key and code", Body: DimensionsResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load dimensions", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v1/shops/:shopid/dimensions/:key/:code",
+		Summary:     "Create or replace a dimension value",
+		Description: "key must be defined in the shop settings (dimensions). keywords select the value when found in the OCR text; inactive values are rejected on new entries.",
+		Tag:         "rules",
+		Role:        RoleShop,
+		RequestBody: DimensionRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Saved value", Body: storage.Dimension{}},
+			http.StatusBadRequest:          {Description: "Missing name or dimension not defined", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to save value", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:  http.MethodDelete,
+		Path:    "/api/v1/shops/:shopid/dimensions/:key/:code",
+		Summary: "Delete a dimension value",
+		Tag:     "rules",
+		Role:    RoleShop,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Value deleted"},
+			http.StatusNotFound:            {Description: "Unknown value", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to delete value", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/settings",
+		Summary:     "Per-shop model and threshold overrides",
+		Description: "settings = fields the shop has set (missing = global config). effective = models and template thresholds the shop's next analysis will use; the same object is reported as metadata.settings on every analysis.",
+		Tag:         "rules",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Overrides and effective settings", Body: ShopSettingsResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load settings", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v1/shops/:shopid/settings",
+		Summary:     "Replace the overrides of a shop",
+		Description: "Replaces all overrides (omitted fields fall back to the global config). Model names must be Gemini models; thresholds are 0-100; retention_days (>= 1) overrides DATA_RETENTION_DAYS; vat_rate (0-100) is the VAT rate of the shop's own sales; custom_fields (max 20, name [a-z0-9_], type string/number/integer/boolean/date) are read into receipt.custom_fields; dimensions (max 5, key [a-z0-9_], required, default) are booked on entries[].dimensions.",
+		Tag:         "rules",
+		Role:        RoleAdmin,
+		RequestBody: storage.ShopSettings{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Saved overrides", Body: storage.ShopSettings{}},
+			http.StatusBadRequest:          {Description: "Invalid model name or threshold", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to save settings", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v1/shops/:shopid/results",
+		Summary:     "Delete the stored document data of a shop (PDPA)",
+		Description: "Deletes OCR results, AI traces, failed requests, idempotency records, interrupted analyses, document analytics, drafts and queued analyze jobs of the shop. ?before=YYYY-MM-DD limits the purge to data created before that day (default = everything). The usage ledger is kept.",
+		Tag:         "rules",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Deleted documents per collection", Body: PurgeShopResultsResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid before date", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Purge failed", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/results/:request_id/reanalyze",
+		Summary:     "Re-run template matching and accounting on stored OCR text",
+		Description: "Reuses the OCR text kept for OCR_RESULT_TTL_DAYS (no re-OCR). Optional overrides force a template (template-only mode), a creditor or the accounting model. QR overrides, clustering and slip verification are not re-run. The response has a new request_id and reanalysis_of = the original request.",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		RequestBody: ReanalyzeRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "New accounting result linked to the original request"},
+			http.StatusBadRequest:          {Description: "Invalid model or unknown template / creditor", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "No stored OCR text (disabled, expired or unknown request_id)", Body: ErrorResponse{}},
+			http.StatusPaymentRequired:     {Description: "Cost budget exceeded", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity: {Description: "Content blocked by the AI safety filter", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Accounting analysis failed", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/shops/:shopid/reprocess",
+		Summary:     "Queue a re-processing campaign over stored documents",
+		Description: "Selects the latest analysis of every document dated from..to (inclusive) with stored OCR text, optionally filtered by creditor, requires_review and approval status (approved results are skipped unless include_final), and re-analyzes them in the background one at a time like POST /results/:request_id/reanalyze. Progress and a summary of changed outcomes (creditor, journal_book, entries, receipt, review_added, review_cleared) are on GET /reprocess/:campaign_id. dry_run only counts the documents. One active campaign per shop; at most REPROCESS_MAX_DOCUMENTS documents.",
+		Tag:         "analysis",
+		Role:        RoleAdmin,
+		RequestBody: ReprocessRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Dry run: number of matching documents", Body: ReprocessDryRunResponse{}},
+			http.StatusAccepted:            {Description: "Campaign queued", Body: storage.ReprocessCampaign{}},
+			http.StatusBadRequest:          {Description: "Invalid date range or model, no documents or too many documents", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "OCR result storage is disabled (OCR_RESULT_TTL_DAYS=0)", Body: ErrorResponse{}},
+			http.StatusConflict:            {Description: "The shop already has a queued or running campaign", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load documents or create the campaign", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/reprocess",
+		Summary:     "Re-processing campaigns of a shop",
+		Description: "The latest 50 campaigns with progress and summary (without the per-document items), newest first.",
+		Tag:         "analysis",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Campaigns", Body: ReprocessCampaignsResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load campaigns", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/reprocess/:campaign_id",
+		Summary:     "Progress and outcome of a re-processing campaign",
+		Description: "Campaign with its items: the re-analyzed request, the new request_id (compare both with GET /results/compare), the changes or the error of each document.",
+		Tag:         "analysis",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Campaign with items", Body: storage.ReprocessCampaign{}},
+			http.StatusNotFound:            {Description: "Unknown campaign", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load campaign", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/shops/:shopid/reprocess/:campaign_id/cancel",
+		Summary:     "Cancel a re-processing campaign",
+		Description: "Stops a queued or running campaign. The document being analyzed still finishes; the remaining items stay pending.",
+		Tag:         "analysis",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Campaign cancelled"},
+			http.StatusNotFound:            {Description: "Unknown campaign or already finished", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to cancel campaign", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/folder-watch",
+		Summary:     "Watched folder of a shop",
+		Description: "Google Drive / Dropbox / SFTP folder polled for new receipts, with the last poll, its error (listing / authorization / host key) and the number of files moved to processed/ and failed/. The refresh token and the SFTP password / private key are never returned.",
+		Tag:         "analysis",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Watched folder", Body: storage.FolderWatch{}},
+			http.StatusNotFound:            {Description: "The shop has no watched folder", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load folder watch", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPut,
+		Path:        "/api/v1/shops/:shopid/folder-watch",
+		Summary:     "Watch a Google Drive / Dropbox / SFTP folder of a shop",
+		Description: "Every FOLDER_WATCH_POLL_INTERVAL_SEC the folder is listed and up to FOLDER_WATCH_MAX_FILES new files (oldest first) are analyzed like POST /analyze-receipt with documentimageguid = the original file name, then moved to the processed/ or failed/ subfolder. folder = Dropbox path (\"\" or / = app root) or Google Drive folder ID. refresh_token is the OAuth refresh token of the shop's account (required when creating or changing provider, kept when omitted). Provider sftp (office scanners): folder = remote directory, sftp = host, port, username, password or private_key (kept when omitted) and the required host_key_fingerprint (SHA256:...); files modified in the last 30 seconds are left for the next poll. The first poll runs right away.",
+		Tag:         "analysis",
+		Role:        RoleAdmin,
+		RequestBody: FolderWatchRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Saved folder watch", Body: storage.FolderWatch{}},
+			http.StatusBadRequest:          {Description: "Invalid provider or model, provider not configured on the server, missing folder or refresh_token, invalid sftp login", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to save folder watch", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodDelete,
+		Path:        "/api/v1/shops/:shopid/folder-watch",
+		Summary:     "Stop watching the folder of a shop",
+		Description: "The picked-up file history is kept (GET /folder-watch/files).",
+		Tag:         "analysis",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Folder watch deleted"},
+			http.StatusNotFound:            {Description: "The shop has no watched folder", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to delete folder watch", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/shops/:shopid/folder-watch/files",
+		Summary:     "Files picked up from the watched folder",
+		Description: "The latest 100 files, newest first: original file name, request_id of the analysis, status (processing, processed, failed), error and the subfolder the file was moved to (empty = the move failed and is retried on the next poll).",
+		Tag:         "analysis",
+		Role:        RoleAdmin,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Picked-up files", Body: FolderWatchFilesResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load folder watch files", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/results/:request_id/traces",
+		Summary:     "Recorded AI interactions of a request",
+		Description: "Returns the exact prompt, system instruction, response schema and raw model response of every AI call (OCR, template matching, accounting). Only recorded when ENABLE_AI_TRACES=true; kept for AI_TRACE_TTL_DAYS.",
+		Tag:         "debug",
+		Role:        RoleShop,
+		Params: []apiParam{
+			{Name: "phase", In: "query", Description: "Only return traces of one phase: ocr, template_match or accounting"},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Recorded traces", Body: storage.AITraceRecord{}},
+			http.StatusNotFound:            {Description: "No traces for this request (disabled, expired or unknown request_id)", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load traces", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/results/compare",
+		Summary:     "Compare two analyses of a document",
+		Description: "Diff of analysis b against analysis a: changed receipt fields, accounts whose debit/credit changed (added / removed / changed, totalled per account), every confidence factor and the billed cost of both requests. Uses the analysis stored with the OCR text (OCR_RESULT_TTL_DAYS); both requests must belong to the same shop.",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		Params: []apiParam{
+			{Name: "a", In: "query", Required: true, Description: "request_id of the first analysis (e.g. the original)"},
+			{Name: "b", In: "query", Required: true, Description: "request_id of the second analysis (e.g. a re-analysis)"},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Structured diff", Body: ResultCompareResponse{}},
+			http.StatusBadRequest:          {Description: "Missing / equal request ids or requests of different shops", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "No stored analysis (disabled, expired, failed or unknown request_id)", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load the analyses", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/results/:request_id/compare-modes",
+		Summary:     "Compare template-only and full-mode results of a document",
+		Description: "Runs Phase 3 on the stored OCR text (OCR_RESULT_TTL_DAYS) twice: template-only with the best matching template regardless of TEMPLATE_CONFIDENCE_THRESHOLD (or template_id), and full mode. Returns both results, their diff (a = template_only, b = full), the cost of each run and a recommendation for tuning the threshold. Nothing is stored as a new request; both runs are billed.",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		RequestBody: CompareModesRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Both results and their diff", Body: CompareModesResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid model or master data unavailable", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "No stored OCR text (disabled, expired or unknown request_id)", Body: ErrorResponse{}},
+			http.StatusPaymentRequired:     {Description: "Cost budget exceeded", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity: {Description: "No template to compare (none matched or unknown template_id)", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/results/:request_id/approve",
+		Summary:     "Approve a result (draft → final) and get its journal voucher",
+		Description: "Applies the optional corrections (receipt fields, voucher header, creditor / debtor / journal book, replacement entries) to the stored analysis, validates the debit/credit balance and the account codes against the chart of accounts again, then freezes the result as final (no longer expires with OCR_RESULT_TTL_DAYS). Returns the canonical journal voucher for export / posting.",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		RequestBody: ApproveResultRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Result is final", Body: ApproveResultResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid corrections", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "No stored analysis (disabled, expired, failed or unknown request_id)", Body: ErrorResponse{}},
+			http.StatusConflict:            {Description: "Result already final (body has the frozen voucher)", Body: ErrorResponse{}},
+			http.StatusUnprocessableEntity: {Description: "Unbalanced entries, unknown account codes or unknown creditor / debtor / journal book", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to approve", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/results/:request_id/bundle",
+		Summary:     "Download an audit bundle of a result (zip)",
+		Description: "Returns application/zip with summary.pdf (journal entry summary, Thai layout; the frozen voucher when approved), voucher.pdf (journal voucher, see /voucher), result.json (the stored result), ocr/image_N.txt (OCR text), images/image_N.* (original images downloaded again from imageuri) and manifest.json (SHA-256 of every file, images that could not be downloaded). Set PDF_FONT_PATH to a Thai TTF font.",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Zip archive (application/zip)"},
+			http.StatusForbidden:           {Description: "Result belongs to another shop", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "No stored result (disabled, expired or unknown request_id)", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to render the summary PDF", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/results/:request_id/voucher",
+		Summary:     "Download the journal voucher of a result (PDF)",
+		Description: "Returns application/pdf: a printable Thai journal voucher (ใบสำคัญลงบัญชี) with the shop name, journal book, creditor / debtor, document number and date, the debit / credit lines with totals (and the total in Thai words) and signature lines for preparer, reviewer, approver and bookkeeper. Approved results print the frozen voucher and the approver; drafts are built from the stored analysis and marked as not approved. Set PDF_FONT_PATH to a Thai TTF font.",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "PDF document (application/pdf)"},
+			http.StatusForbidden:           {Description: "Result belongs to another shop", Body: ErrorResponse{}},
+			http.StatusNotFound:            {Description: "No stored analysis (disabled, expired, failed or unknown request_id)", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to render the PDF", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/failed",
+		Summary:     "Failed analyses (dead-letter store)",
+		Description: "Analyses that failed with an AI error, an unparseable AI response or a timeout, or were cut off by a server shutdown (phase shutdown), newest first. Each record keeps the original payload, the failed phase, the error and partial artifacts (template / vendor match, raw AI response). Kept for FAILED_REQUEST_TTL_DAYS.",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		Params: []apiParam{
+			{Name: "shopid", In: "query", Description: "Only failures of this shop"},
+			{Name: "status", In: "query", Description: "failed (default), resolved or all"},
+			{Name: "limit", In: "query", Description: "Max records (1-500, default 50)", Type: "integer"},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Failures", Body: FailedRequestsResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid limit", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load failures", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/failed/:id/retry",
+		Summary:     "Retry a failed analysis",
+		Description: "Failures after OCR resume from the stored OCR text (template matching + accounting, like reanalyze). OCR failures, or failures whose OCR text expired, replay the original analyze-receipt payload. The response is the analyze-receipt / reanalyze response of the retry; a successful retry marks the failure resolved, a failed one updates its phase and error.",
+		Tag:         "analysis",
+		Role:        RoleShop,
+		Responses: map[int]apiResponse{
+			http.StatusOK:       {Description: "Retry succeeded (analysis response)"},
+			http.StatusNotFound: {Description: "Unknown or expired failure", Body: ErrorResponse{}},
+			http.StatusConflict: {Description: "Already resolved, or nothing left to retry from", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/admin/flags",
+		Summary:     "Current runtime flags",
+		Description: "Suspended shops, disabled OCR providers, drain state, in-flight analyses and AI retry stats per call site (ai_retries: attempts, retries, 429s, waits taken from Retry-After, failures) of the instance that answered. Flags are shared through MongoDB and re-read every RUNTIME_FLAGS_REFRESH_SEC.",
+		Tag:         "admin",
+		Role:        RoleAdmin,
+		Params:      []apiParam{adminAuthParam},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Runtime flags", Body: RuntimeFlagsResponse{}},
+			http.StatusUnauthorized: {Description: "Missing or wrong admin API key", Body: ErrorResponse{}},
+			http.StatusForbidden:    {Description: "ADMIN_API_KEY not configured", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/admin/audit",
+		Summary:     "Audit log of API calls",
+		Description: "One entry per /api/v1 call, newest first: actor (admin, shop, anonymous), fingerprint of the bearer key, shop, endpoint, request_id, HTTP status, outcome (success, rejected, aborted, error) and AI cost. Kept for AUDIT_LOG_TTL_DAYS.",
+		Tag:         "admin",
+		Role:        RoleAdmin,
+		Params: []apiParam{
+			adminAuthParam,
+			{Name: "shopid", In: "query", Description: "Only calls of this shop"},
+			{Name: "actor", In: "query", Description: "admin, shop or anonymous"},
+			{Name: "request_id", In: "query", Description: "Only calls of this analysis request"},
+			{Name: "endpoint", In: "query", Description: "Route template, e.g. /api/v1/analyze-receipt"},
+			{Name: "from", In: "query", Description: "Start (YYYY-MM-DD or RFC3339, inclusive)"},
+			{Name: "to", In: "query", Description: "End (YYYY-MM-DD inclusive, or RFC3339 exclusive)"},
+			{Name: "limit", In: "query", Description: "Max entries (1-1000, default 100)", Type: "integer"},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Audit entries", Body: AuditLogResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid limit or time range", Body: ErrorResponse{}},
+			http.StatusUnauthorized:        {Description: "Missing or wrong admin API key", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to load the audit log", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/admin/cache/refresh",
+		Summary:     "Refresh the master data cache",
+		Description: "Drops the cached master data (accounts, journal books, creditors, debtors, journal book rules, vendor mappings, shop settings) of one shop, or of every shop when shopid is omitted; it is reloaded from MongoDB on the next request. The cache is per instance - only the instance that receives the call is refreshed.",
+		Tag:         "admin",
+		Role:        RoleAdmin,
+		Params: []apiParam{
+			adminAuthParam,
+			{Name: "shopid", In: "query", Description: "Only this shop (default = every shop)"},
+		},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Cache dropped"},
+			http.StatusUnauthorized: {Description: "Missing or wrong admin API key", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/v1/admin/tenants",
+		Summary:     "Tenant routing table",
+		Description: "Lists the tenants of TENANT_ROUTING_ENABLED with their database and shops (collection tenantRoutes, reloaded every TENANT_ROUTES_REFRESH_SEC). Connection URIs are never returned. Shops without a route use the main database.",
+		Tag:         "admin",
+		Role:        RoleAdmin,
+		Params:      []apiParam{adminAuthParam},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Routing table", Body: TenantRoutesResponse{}},
+			http.StatusUnauthorized: {Description: "Missing or wrong admin API key", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/admin/shops/:shopid/suspension",
+		Summary:     "Suspend a shop",
+		Description: "Every request of the shop (analyze-receipt, test-template, reanalyze, classify-document, ocr, extract, quality-check and /shops/:shopid routes) gets 403 shop_suspended with the reason until the suspension is removed.",
+		Tag:         "admin",
+		Role:        RoleAdmin,
+		Params:      []apiParam{adminAuthParam},
+		RequestBody: RuntimeFlagRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Suspension saved", Body: storage.RuntimeFlag{}},
+			http.StatusUnauthorized:        {Description: "Missing or wrong admin API key", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to save flag", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:  http.MethodDelete,
+		Path:    "/api/v1/admin/shops/:shopid/suspension",
+		Summary: "Resume a suspended shop",
+		Tag:     "admin",
+		Role:    RoleAdmin,
+		Params:  []apiParam{adminAuthParam},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Suspension removed"},
+			http.StatusUnauthorized: {Description: "Missing or wrong admin API key", Body: ErrorResponse{}},
+			http.StatusNotFound:     {Description: "Shop is not suspended", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/v1/admin/providers/:provider/disable",
+		Summary:     "Disable an OCR provider globally",
+		Description: "Requests for the provider (gemini or mistral) use the other provider for OCR; metadata.ocr_provider_requested reports the switch. When both are disabled (or the other has no API key) requests get 503 provider_disabled. Template matching and accounting always use Gemini and are not affected.",
+		Tag:         "admin",
+		Role:        RoleAdmin,
+		Params:      []apiParam{adminAuthParam},
+		RequestBody: RuntimeFlagRequest{},
+		Responses: map[int]apiResponse{
+			http.StatusOK:                  {Description: "Provider disabled", Body: storage.RuntimeFlag{}},
+			http.StatusBadRequest:          {Description: "Unknown provider", Body: ErrorResponse{}},
+			http.StatusUnauthorized:        {Description: "Missing or wrong admin API key", Body: ErrorResponse{}},
+			http.StatusInternalServerError: {Description: "Failed to save flag", Body: ErrorResponse{}},
+		},
+	},
+	{
+		Method:  http.MethodDelete,
+		Path:    "/api/v1/admin/providers/:provider/disable",
+		Summary: "Re-enable an OCR provider",
+		Tag:     "admin",
+		Role:    RoleAdmin,
+		Params:  []apiParam{adminAuthParam},
+		Responses: map[int]apiResponse{
+			http.StatusOK:           {Description: "Provider enabled"},
+			http.StatusUnauthorized: {Description: "Missing or wrong admin API key", Body: ErrorResponse{}},
+			http.StatusNotFound:     {Description: "Provider is not disabled", Body: ErrorResponse{}},
+		},
+	},
+}
+
+// adminAuthParam documents the admin API key header
+var adminAuthParam = apiParam{Name: "Authorization", In: "header", Description: "Bearer <ADMIN_API_KEY>", Required: true}
+
+var (
+	openAPISpec     map[string]interface{}
+	openAPISpecOnce sync.Once
+)
+
+// ginPathParam matches gin path parameters (:name)
+var ginPathParam = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+// OpenAPIHandler handles GET /api/v1/openapi.json
+func OpenAPIHandler(c *gin.Context) {
+	openAPISpecOnce.Do(func() {
+		openAPISpec = BuildOpenAPISpec()
+	})
+	c.JSON(http.StatusOK, openAPISpec)
+}
+
+// SwaggerUIHandler handles GET /api/v1/docs (only mounted when ENABLE_SWAGGER_UI=true)
+func SwaggerUIHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
+
+// BuildOpenAPISpec generates the OpenAPI 3 document from apiRoutes
+func BuildOpenAPISpec() map[string]interface{} {
+	gen := &schemaGenerator{components: map[string]interface{}{}}
+	paths := map[string]interface{}{}
+
+	for _, route := range apiRoutes {
+		openAPIPath := ginPathParam.ReplaceAllString(route.Path, "{$1}")
+		pathItem, ok := paths[openAPIPath].(map[string]interface{})
+		if !ok {
+			pathItem = map[string]interface{}{}
+			paths[openAPIPath] = pathItem
+		}
+
+		operation := map[string]interface{}{
+			"summary":     route.Summary,
+			"operationId": operationID(route),
+		}
+		if route.Description != "" {
+			operation["description"] = route.Description
+		}
+		if route.Tag != "" {
+			operation["tags"] = []string{route.Tag}
+		}
+		if route.Role != "" {
+			operation["x-required-role"] = route.Role
+			operation["security"] = []map[string][]string{{"bearerAuth": {}}}
+		}
+
+		// Path parameters are always required and derived from the gin path
+		var params []map[string]interface{}
+		for _, match := range ginPathParam.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, map[string]interface{}{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, p := range route.Params {
+			paramType := p.Type
+			if paramType == "" {
+				paramType = "string"
+			}
+			params = append(params, map[string]interface{}{
+				"name":        p.Name,
+				"in":          p.In,
+				"description": p.Description,
+				"required":    p.Required,
+				"schema":      map[string]interface{}{"type": paramType},
+			})
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+
+		if route.RequestBody != nil {
+			contentType := route.RequestContentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					contentType: map[string]interface{}{
+						"schema": gen.schemaFor(reflect.TypeOf(route.RequestBody)),
+					},
+				},
+			}
+		}
+
+		responses := map[string]interface{}{}
+		for status, resp := range route.Responses {
+			r := map[string]interface{}{"description": resp.Description}
+			if resp.Body != nil {
+				r["content"] = map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": gen.schemaFor(reflect.TypeOf(resp.Body)),
+					},
+				}
+			}
+			responses[strconv.Itoa(status)] = r
+		}
+		operation["responses"] = responses
+
+		pathItem[strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Bill Scan API",
+			"description": "ระบบวิเคราะห์ใบเสร็จ/ใบกำกับภาษีอัตโนมัติด้วย AI และสร้างรายการบัญชี",
+			"version":     "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": gen.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Shop key (SHOP_API_KEYS) or admin key (ADMIN_API_KEY)",
+				},
+			},
+		},
+	}
+}
+
+// operationID builds a stable operationId from method and path
+// e.g. POST /api/v1/analyze-receipt → post_analyze_receipt
+func operationID(route apiRoute) string {
+	path := strings.TrimPrefix(route.Path, "/api/v1/")
+	path = ginPathParam.ReplaceAllString(path, "by_$1")
+	path = strings.NewReplacer("/", "_", "-", "_", ".", "_").Replace(path)
+	return strings.ToLower(route.Method) + "_" + strings.Trim(path, "_")
+}
+
+// schemaGenerator converts Go types into OpenAPI schemas
+// Named structs are emitted once into components/schemas and referenced with $ref
+type schemaGenerator struct {
+	components map[string]interface{}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if t == rawMessageType {
+		return map[string]interface{}{} // Arbitrary embedded JSON
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, exists := g.components[t.Name()]; !exists {
+			g.components[t.Name()] = map[string]interface{}{} // placeholder for recursive types
+			g.components[t.Name()] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	return map[string]interface{}{}
+}
+
+// structSchema builds an object schema using json tags (fields without omitempty are required)
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+
+			// Embedded structs are flattened the same way encoding/json does
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				collect(field.Type)
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			schema := g.schemaFor(field.Type)
+			if format := field.Tag.Get("format"); format != "" {
+				schema = map[string]interface{}{"type": "string", "format": format}
+			}
+			if field.Type.Kind() == reflect.Ptr {
+				schema = withNullable(schema)
+			}
+			properties[name] = schema
+
+			if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+				required = append(required, name)
+			}
+		}
+	}
+	collect(t)
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// withNullable marks a schema as nullable ($ref schemas are wrapped with allOf)
+func withNullable(schema map[string]interface{}) map[string]interface{} {
+	if _, isRef := schema["$ref"]; isRef {
+		return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+	}
+	schema["nullable"] = true
+	return schema
+}
+
+// swaggerUIPage loads Swagger UI from CDN and points it at the generated spec
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8" />
+  <title>Bill Scan API - Swagger UI</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css" />
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({
+        url: '/api/v1/openapi.json',
+        dom_id: '#swagger-ui',
+      });
+    };
+  </script>
+</body>
+</html>`
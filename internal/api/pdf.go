@@ -0,0 +1,410 @@
+// pdf.go - Minimal PDF writer for printable documents (no external dependency)
+//
+// A4 แนวตั้ง: ข้อความ + เส้น + กรอบ, พิกัดเป็น point จากมุมซ้ายบน (y = baseline ของข้อความ)
+// ฟอนต์ TrueType ฝังทั้งไฟล์ (CIDFontType2 / Identity-H + ToUnicode) → แสดงและค้นหา/คัดลอกข้อความภาษาไทยได้
+// ฟอนต์ไทย: PDF_FONT_PATH / PDF_FONT_BOLD_PATH (เช่น Sarabun-Regular.ttf) - ไม่ตั้ง = Go font ซึ่งไม่มีตัวอักษรไทย
+// สระบน/ล่างและวรรณยุกต์ใช้ตำแหน่งที่ฟอนต์กำหนด (ไม่มี shaping) - ฟอนต์ไทยทั่วไปวางถูกต้องอยู่แล้ว
+
+package api
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	pdfContentType = "application/pdf"
+	pdfPageWidth   = 595.28 // A4 in points
+	pdfPageHeight  = 841.89
+	pdfMargin      = 40.0
+)
+
+// pdfFont is a parsed TrueType font (metrics in 1/1000 em)
+type pdfFont struct {
+	name      string
+	data      []byte
+	font      *sfnt.Font
+	ascent    int
+	descent   int
+	capHeight int
+	bbox      [4]int
+	mu        sync.Mutex
+	buf       sfnt.Buffer
+	widths    map[sfnt.GlyphIndex]int
+}
+
+var (
+	pdfFonts     [2]*pdfFont // regular, bold
+	pdfFontsErr  error
+	pdfFontsOnce sync.Once
+)
+
+// loadPDFFonts parses PDF_FONT_PATH / PDF_FONT_BOLD_PATH once
+// (no bold font → the regular Thai font is used for bold text too)
+func loadPDFFonts() ([2]*pdfFont, error) {
+	pdfFontsOnce.Do(func() {
+		if configs.PDF_FONT_PATH == "" {
+			log.Println("⚠️  PDF_FONT_PATH not set - PDFs use the Go font (Thai text cannot be rendered)")
+		}
+		paths := [2]string{configs.PDF_FONT_PATH, configs.PDF_FONT_BOLD_PATH}
+		fallbacks := [2][]byte{goregular.TTF, gobold.TTF}
+		for i := range paths {
+			if i == 1 && paths[1] == "" && paths[0] != "" {
+				pdfFonts[1] = pdfFonts[0]
+				continue
+			}
+			data := fallbacks[i]
+			if paths[i] != "" {
+				var err error
+				if data, err = os.ReadFile(paths[i]); err != nil {
+					pdfFontsErr = fmt.Errorf("failed to read PDF font: %w", err)
+					return
+				}
+			}
+			parsed, err := parsePDFFont(data)
+			if err != nil {
+				pdfFontsErr = fmt.Errorf("invalid PDF font %s: %w", paths[i], err)
+				return
+			}
+			pdfFonts[i] = parsed
+		}
+	})
+	return pdfFonts, pdfFontsErr
+}
+
+// parsePDFFont reads the metrics needed by the font descriptor
+func parsePDFFont(data []byte) (*pdfFont, error) {
+	parsed, err := sfnt.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	f := &pdfFont{data: data, font: parsed, widths: map[sfnt.GlyphIndex]int{}}
+	name, _ := parsed.Name(&f.buf, sfnt.NameIDPostScript)
+	f.name = strings.Map(func(r rune) rune {
+		if r < '!' || r > '~' || strings.ContainsRune("()<>[]{}/%#", r) {
+			return -1
+		}
+		return r
+	}, name)
+	if f.name == "" {
+		f.name = "EmbeddedFont"
+	}
+
+	// ppem = units per em → 26.6 values are font units × 64
+	ppem := fixed.I(int(parsed.UnitsPerEm()))
+	metrics, err := parsed.Metrics(&f.buf, ppem, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	bounds, err := parsed.Bounds(&f.buf, ppem, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	f.ascent = f.scale(metrics.Ascent)
+	f.descent = f.scale(metrics.Descent)
+	f.capHeight = f.scale(metrics.CapHeight)
+	if f.capHeight == 0 {
+		f.capHeight = f.ascent
+	}
+	// sfnt Y axis points down
+	f.bbox = [4]int{f.scale(bounds.Min.X), -f.scale(bounds.Max.Y), f.scale(bounds.Max.X), -f.scale(bounds.Min.Y)}
+	return f, nil
+}
+
+// scale converts a 26.6 value at ppem = units per em to 1/1000 em
+func (f *pdfFont) scale(value fixed.Int26_6) int {
+	return int(float64(value) / 64 * 1000 / float64(f.font.UnitsPerEm()))
+}
+
+// glyph returns the glyph of r (0 = missing) and its advance in 1/1000 em
+func (f *pdfFont) glyph(r rune) (sfnt.GlyphIndex, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	index, err := f.font.GlyphIndex(&f.buf, r)
+	if err != nil {
+		index = 0
+	}
+	width, ok := f.widths[index]
+	if !ok {
+		advance, err := f.font.GlyphAdvance(&f.buf, index, fixed.I(int(f.font.UnitsPerEm())), font.HintingNone)
+		if err == nil {
+			width = f.scale(advance)
+		}
+		f.widths[index] = width
+	}
+	return index, width
+}
+
+// pdfDocument collects the pages of one PDF
+type pdfDocument struct {
+	fonts [2]*pdfFont
+	used  [2]map[sfnt.GlyphIndex]rune // Glyphs drawn per font (widths + ToUnicode)
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+}
+
+// newPDFDocument starts an empty document (addPage before drawing)
+func newPDFDocument() (*pdfDocument, error) {
+	fonts, err := loadPDFFonts()
+	if err != nil {
+		return nil, err
+	}
+	return &pdfDocument{
+		fonts: fonts,
+		used:  [2]map[sfnt.GlyphIndex]rune{{}, {}},
+	}, nil
+}
+
+// addPage starts a new page
+func (d *pdfDocument) addPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+}
+
+// fontIndex returns the font used for bold / regular text
+func (d *pdfDocument) fontIndex(bold bool) int {
+	if bold && d.fonts[1] != d.fonts[0] {
+		return 1
+	}
+	return 0
+}
+
+// textWidth returns the width of s in points
+func (d *pdfDocument) textWidth(s string, size float64, bold bool) float64 {
+	f := d.fonts[d.fontIndex(bold)]
+	total := 0
+	for _, r := range s {
+		_, width := f.glyph(r)
+		total += width
+	}
+	return float64(total) * size / 1000
+}
+
+// text draws s with its baseline at (x, y)
+func (d *pdfDocument) text(x, y, size float64, bold bool, s string) {
+	if s == "" {
+		return
+	}
+	index := d.fontIndex(bold)
+	var hex strings.Builder
+	for _, r := range s {
+		if r == '\t' || r == '\n' || r == '\r' {
+			r = ' '
+		}
+		glyph, _ := d.fonts[index].glyph(r)
+		if _, ok := d.used[index][glyph]; !ok {
+			d.used[index][glyph] = r
+		}
+		fmt.Fprintf(&hex, "%04X", uint16(glyph))
+	}
+	fmt.Fprintf(d.page, "BT /F%d %.2f Tf 1 0 0 1 %.2f %.2f Tm <%s> Tj ET\n", index+1, size, x, pdfPageHeight-y, hex.String())
+}
+
+// textRight draws s ending at right
+func (d *pdfDocument) textRight(right, y, size float64, bold bool, s string) {
+	d.text(right-d.textWidth(s, size, bold), y, size, bold, s)
+}
+
+// textCenter draws s centered on center
+func (d *pdfDocument) textCenter(center, y, size float64, bold bool, s string) {
+	d.text(center-d.textWidth(s, size, bold)/2, y, size, bold, s)
+}
+
+// line draws a line
+func (d *pdfDocument) line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(d.page, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, pdfPageHeight-y1, x2, pdfPageHeight-y2)
+}
+
+// rect draws a box with its top-left corner at (x, y); gray > 0 fills it (0-1, 1 = white)
+func (d *pdfDocument) rect(x, y, w, h, width, gray float64) {
+	if gray > 0 {
+		fmt.Fprintf(d.page, "%.2f g %.2f %.2f %.2f %.2f re f 0 g\n", gray, x, pdfPageHeight-y-h, w, h)
+	}
+	if width > 0 {
+		fmt.Fprintf(d.page, "%.2f w %.2f %.2f %.2f %.2f re S\n", width, x, pdfPageHeight-y-h, w, h)
+	}
+}
+
+// wrap splits s into lines no wider than width (Thai has no spaces - long words break between characters,
+// never before a vowel / tone mark)
+func (d *pdfDocument) wrap(s string, size float64, bold bool, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(s, "\n") {
+		current := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if current != "" {
+				candidate = current + " " + word
+			}
+			if d.textWidth(candidate, size, bold) <= width {
+				current = candidate
+				continue
+			}
+			if current != "" {
+				lines = append(lines, current)
+				current = ""
+			}
+			for _, cluster := range pdfClusters(word) {
+				if current != "" && d.textWidth(current+cluster, size, bold) > width {
+					lines = append(lines, current)
+					current = ""
+				}
+				current += cluster
+			}
+		}
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// pdfClusters splits a word into characters with their combining marks (ก + ุ + ้ stay together)
+func pdfClusters(word string) []string {
+	var clusters []string
+	for _, r := range word {
+		if len(clusters) > 0 && unicode.Is(unicode.Mn, r) {
+			clusters[len(clusters)-1] += string(r)
+			continue
+		}
+		clusters = append(clusters, string(r))
+	}
+	return clusters
+}
+
+// bytes writes the document (title = document information title)
+func (d *pdfDocument) bytes(title string) []byte {
+	w := &pdfWriter{}
+	w.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	catalogID := w.reserve()
+	pagesID := w.reserve()
+
+	fontResources := ""
+	for i, f := range d.fonts {
+		if len(d.used[i]) == 0 || (i == 1 && f == d.fonts[0]) {
+			continue
+		}
+		fontResources += fmt.Sprintf("/F%d %d 0 R ", i+1, w.writeFont(f, d.used[i]))
+	}
+
+	kids := make([]string, 0, len(d.pages))
+	for _, page := range d.pages {
+		contentID := w.stream("", page.Bytes())
+		pageID := w.add(fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << %s>> >> /Contents %d 0 R >>",
+			pagesID, pdfPageWidth, pdfPageHeight, fontResources, contentID))
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageID))
+	}
+	w.write(pagesID, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+	w.write(catalogID, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesID))
+	infoID := w.add(fmt.Sprintf("<< /Title %s /Producer (account_ocr_gemini) /CreationDate (D:%s) >>",
+		pdfTextString(title), time.Now().UTC().Format("20060102150405Z")))
+
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, catalogID, infoID, xref)
+	return w.buf.Bytes()
+}
+
+// pdfWriter numbers the objects and records their offsets for the xref table
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int // offsets[id-1]
+}
+
+func (w *pdfWriter) reserve() int {
+	w.offsets = append(w.offsets, 0)
+	return len(w.offsets)
+}
+
+func (w *pdfWriter) write(id int, body string) {
+	w.offsets[id-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+func (w *pdfWriter) add(body string) int {
+	id := w.reserve()
+	w.write(id, body)
+	return id
+}
+
+// stream writes a Flate-compressed stream (dict = extra dictionary entries)
+func (w *pdfWriter) stream(dict string, data []byte) int {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(data)
+	zw.Close()
+
+	id := w.reserve()
+	w.offsets[id-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<< %s/Length %d /Filter /FlateDecode >>\nstream\n", id, dict, compressed.Len())
+	w.buf.Write(compressed.Bytes())
+	w.buf.WriteString("\nendstream\nendobj\n")
+	return id
+}
+
+// writeFont embeds a font with the widths and ToUnicode map of the glyphs used; returns the Type0 font object
+func (w *pdfWriter) writeFont(f *pdfFont, used map[sfnt.GlyphIndex]rune) int {
+	glyphs := make([]sfnt.GlyphIndex, 0, len(used))
+	for glyph := range used {
+		glyphs = append(glyphs, glyph)
+	}
+	sort.Slice(glyphs, func(i, j int) bool { return glyphs[i] < glyphs[j] })
+
+	var widths, cmap strings.Builder
+	for _, glyph := range glyphs {
+		_, width := f.glyph(used[glyph])
+		fmt.Fprintf(&widths, "%d [%d] ", glyph, width)
+	}
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(glyphs); start += 100 {
+		end := min(start+100, len(glyphs))
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", end-start)
+		for _, glyph := range glyphs[start:end] {
+			unicodeHex := ""
+			for _, unit := range utf16.Encode([]rune{used[glyph]}) {
+				unicodeHex += fmt.Sprintf("%04X", unit)
+			}
+			fmt.Fprintf(&cmap, "<%04X> <%s>\n", uint16(glyph), unicodeHex)
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+
+	fontFileID := w.stream(fmt.Sprintf("/Length1 %d ", len(f.data)), f.data)
+	descriptorID := w.add(fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		f.name, f.bbox[0], f.bbox[1], f.bbox[2], f.bbox[3], f.ascent, -f.descent, f.capHeight, fontFileID))
+	cidFontID := w.add(fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /W [%s] /CIDToGIDMap /Identity >>",
+		f.name, descriptorID, widths.String()))
+	toUnicodeID := w.stream("", []byte(cmap.String()))
+	return w.add(fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		f.name, cidFontID, toUnicodeID))
+}
+
+// pdfTextString encodes a text string as UTF-16BE with BOM (Thai titles)
+func pdfTextString(s string) string {
+	hex := "FEFF"
+	for _, unit := range utf16.Encode([]rune(s)) {
+		hex += fmt.Sprintf("%04X", unit)
+	}
+	return "<" + hex + ">"
+}
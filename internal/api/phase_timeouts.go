@@ -0,0 +1,72 @@
+// phase_timeouts.go - Per-phase deadlines derived from the request context
+//
+// REQUEST_TIMEOUT คุมทั้ง request ส่วนแต่ละ phase มี deadline ของตัวเอง:
+// download (DOWNLOAD_TIMEOUT), OCR ต่อรูป (FULL_OCR_TIMEOUT), template match (TEMPLATE_MATCH_TIMEOUT), accounting (ACCOUNTING_TIMEOUT)
+// phase ไม่มีทางเกิน deadline ของ request เพราะ context แตกมาจาก request
+//
+// หมดเวลา: OCR รูปนั้นล้มเหลว (รูปอื่นทำต่อ), template match → ใช้ full mode, download / accounting → 504 phase_timeout
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/gin-gonic/gin"
+)
+
+// phaseDownload - downloading the images (the other phases reuse failurePhase*)
+const phaseDownload = "download"
+
+// statusClientClosedRequest - the client disconnected before the analysis finished (nginx convention)
+// Non-2xx → the idempotency key is released and the same request can be sent again
+const statusClientClosedRequest = 499
+
+// requestTimeout returns the deadline of a whole analysis (REQUEST_TIMEOUT)
+func requestTimeout() time.Duration {
+	return time.Duration(configs.Get().RequestTimeout) * time.Second
+}
+
+// phaseTimeout returns the configured deadline of a phase
+func phaseTimeout(phase string) time.Duration {
+	cfg := configs.Get()
+	seconds := cfg.AccountingTimeout
+	switch phase {
+	case phaseDownload:
+		seconds = cfg.DownloadTimeout
+	case failurePhaseOCR:
+		seconds = cfg.FullOCRTimeout
+	case failurePhaseTemplateMatch:
+		seconds = cfg.TemplateMatchTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// phaseContext derives the deadline of a phase from the request context
+func phaseContext(parent context.Context, phase string) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, phaseTimeout(phase))
+}
+
+// phaseTimedOut reports whether the phase ran out of its own time
+// (false when the request itself expired or the client went away - the request deadline is reported instead)
+func phaseTimedOut(phaseCtx, parent context.Context) bool {
+	return errors.Is(phaseCtx.Err(), context.DeadlineExceeded) && parent.Err() == nil
+}
+
+// respondPhaseTimeout writes 504 for a phase the analysis cannot continue without (download, accounting)
+func respondPhaseTimeout(c *gin.Context, reqCtx *common.RequestContext, phase string) {
+	timeout := phaseTimeout(phase)
+	reqCtx.RecordPhaseTimeout(phase, timeout, "")
+	c.JSON(http.StatusGatewayTimeout, gin.H{
+		"error":          "phase_timeout",
+		"message":        "ขั้นตอน " + phase + " ใช้เวลาเกินกำหนด กรุณาลองใหม่อีกครั้ง",
+		"phase":          phase,
+		"timeout_sec":    int(timeout / time.Second),
+		"phase_timeouts": reqCtx.PhaseTimeouts(),
+		"request_id":     reqCtx.RequestID,
+	})
+}
@@ -0,0 +1,117 @@
+// preview.go - Thumbnails + field locations of analyze-receipt (include_preview)
+//
+// thumbnails: รูปย่อ JPEG (base64) ของทุกรูป ขนาดตาม THUMBNAIL_MAX_DIMENSION
+// field_locations: กรอบตำแหน่งของ vendor / เลขที่ / วันที่ / ยอดรวม บนรูป (เฉพาะ OCR provider gemini)
+
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+)
+
+// Field location statuses
+const (
+	FieldLocationsLocated             = "located"
+	FieldLocationsUnsupportedProvider = "unsupported_provider" // OCR provider does not return positions (mistral)
+	FieldLocationsNoValues            = "no_values"            // Nothing was read to locate
+	FieldLocationsFailed              = "failed"
+)
+
+// ImageThumbnail is a downscaled image of the request
+type ImageThumbnail struct {
+	ImageIndex        int    `json:"image_index"`
+	DocumentImageGUID string `json:"documentimageguid,omitempty"`
+	Width             int    `json:"width"`
+	Height            int    `json:"height"`
+	MimeType          string `json:"mime_type"`
+	Data              string `json:"data"` // Base64
+}
+
+// FieldLocationsSection is the field_locations section of the response
+type FieldLocationsSection struct {
+	Status    string                    `json:"status"`
+	Provider  string                    `json:"provider"`
+	Locations []processor.FieldLocation `json:"locations"`
+	Error     string                    `json:"error,omitempty"`
+}
+
+// buildThumbnails downscales every image (PDFs are skipped - no renderer)
+func buildThumbnails(reqCtx *common.RequestContext, images []ImageData) []ImageThumbnail {
+	thumbnails := []ImageThumbnail{}
+	for _, img := range images {
+		if strings.ToLower(filepath.Ext(img.Filename)) == ".pdf" {
+			continue
+		}
+		data, width, height, err := processor.GenerateThumbnail(img.Filename, configs.Get().ThumbnailMaxDimension)
+		if err != nil {
+			reqCtx.LogWarning("⚠️  Thumbnail of image %d failed: %v", img.Index, err)
+			continue
+		}
+		thumbnails = append(thumbnails, ImageThumbnail{
+			ImageIndex:        img.Index,
+			DocumentImageGUID: img.GUID,
+			Width:             width,
+			Height:            height,
+			MimeType:          "image/jpeg",
+			Data:              base64.StdEncoding.EncodeToString(data),
+		})
+	}
+	return thumbnails
+}
+
+// previewFieldValues returns the receipt values to locate (unread values are skipped)
+func previewFieldValues(receipt map[string]interface{}) map[string]string {
+	values := map[string]string{}
+	for _, field := range processor.PreviewFields {
+		switch v := receipt[field].(type) {
+		case string:
+			if v = strings.TrimSpace(v); v != "" && v != "N/A" && v != "Unknown Vendor" {
+				values[field] = v
+			}
+		case float64:
+			if v != 0 {
+				values[field] = fmt.Sprintf("%.2f", v)
+			}
+		}
+	}
+	return values
+}
+
+// locateFields finds the receipt values on the images (optional - failures only fill the section's error)
+func locateFields(ctx context.Context, reqCtx *common.RequestContext, provider string, images []ImageData, receipt map[string]interface{}) (FieldLocationsSection, *common.TokenUsage) {
+	section := FieldLocationsSection{Provider: provider, Locations: []processor.FieldLocation{}}
+	if provider != "gemini" {
+		section.Status = FieldLocationsUnsupportedProvider
+		return section, nil
+	}
+	values := previewFieldValues(receipt)
+	located := make([]ai.LocatedImage, 0, len(images))
+	for _, img := range images {
+		if strings.ToLower(filepath.Ext(img.Filename)) != ".pdf" {
+			located = append(located, ai.LocatedImage{Index: img.Index, Path: img.Filename})
+		}
+	}
+	if len(values) == 0 || len(located) == 0 {
+		section.Status = FieldLocationsNoValues
+		return section, nil
+	}
+
+	locations, tokens, err := ai.LocateFields(ctx, located, values, reqCtx)
+	if err != nil {
+		reqCtx.LogWarning("⚠️  Field location failed: %v", err)
+		section.Status, section.Error = FieldLocationsFailed, err.Error()
+		return section, tokens
+	}
+	section.Status, section.Locations = FieldLocationsLocated, locations
+	reqCtx.LogInfo("📍 Located %d of %d field(s) on the image(s)", len(locations), len(values))
+	return section, tokens
+}
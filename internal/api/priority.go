@@ -0,0 +1,46 @@
+// priority.go - Priority class of an analyze-receipt request (field "priority": interactive / bulk)
+//
+// - bulk: ใช้ rate limit ของ Gemini ได้ไม่เกิน BULK_RATE_SHARE (ที่เหลือกันไว้ให้ interactive)
+// - interactive ต่อร้านพร้อมกันได้ไม่เกิน INTERACTIVE_MAX_PER_SHOP → เกินแล้วทำเป็น bulk
+//   (ร้านเดียวส่งงานจำนวนมากเป็น interactive แล้วแย่ง quota ร้านอื่นไม่ได้)
+// - งานจาก worker pool bulk / reprocess เป็น bulk เสมอ (context มาจาก ratelimit.WithPriority)
+// class ที่ใช้จริงอยู่ใน metadata.priority
+
+package api
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ratelimit"
+)
+
+var (
+	interactiveMu       sync.Mutex
+	interactiveInFlight = map[string]int{} // shopid → interactive analyses running in this instance
+)
+
+// acquirePriority resolves the class of an analysis and reserves its interactive slot
+// release must be called when the analysis ends
+func acquirePriority(ctx context.Context, reqCtx *common.RequestContext, shopID, requested string) (string, func()) {
+	if requested == common.PriorityBulk || ratelimit.PriorityFrom(ctx) == common.PriorityBulk {
+		return common.PriorityBulk, func() {}
+	}
+
+	interactiveMu.Lock()
+	defer interactiveMu.Unlock()
+	if limit := configs.Get().InteractiveMaxPerShop; limit > 0 && interactiveInFlight[shopID] >= limit {
+		reqCtx.LogWarning("⏬ Shop has %d interactive analyses running (INTERACTIVE_MAX_PER_SHOP=%d) - running as bulk", interactiveInFlight[shopID], limit)
+		return common.PriorityBulk, func() {}
+	}
+	interactiveInFlight[shopID]++
+	return common.PriorityInteractive, func() {
+		interactiveMu.Lock()
+		defer interactiveMu.Unlock()
+		if interactiveInFlight[shopID]--; interactiveInFlight[shopID] <= 0 {
+			delete(interactiveInFlight, shopID)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// providers.go - Provider capability discovery (GET /api/v1/providers)
+//
+// ให้หน้าบ้านสร้างตัวเลือก model แบบ dynamic: provider ที่ใช้ได้, ชนิดไฟล์, rate limit และราคา
+// ค่าทั้งหมดมาจาก configs (ค่าตั้งต้นของระบบ - shop settings อาจ override model ของแต่ละร้าน)
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// Pricing units
+const (
+	PricingUnitToken = "token" // input_usd_per_million + output_usd_per_million
+	PricingUnitPage  = "page"  // usd_per_thousand_pages
+)
+
+// ProviderPricing is the list price of a model (USD, converted to THB with USD_TO_THB)
+type ProviderPricing struct {
+	Unit                string  `json:"unit"`
+	InputUSDPerMillion  float64 `json:"input_usd_per_million,omitempty"`
+	OutputUSDPerMillion float64 `json:"output_usd_per_million,omitempty"`
+	USDPerThousandPages float64 `json:"usd_per_thousand_pages,omitempty"`
+}
+
+// ProviderRateLimit is the client-side limiter applied before each call (shared by the whole instance)
+type ProviderRateLimit struct {
+	Burst             int   `json:"burst"`
+	RefillIntervalMs  int64 `json:"refill_interval_ms"`
+	RequestsPerMinute int   `json:"requests_per_minute"`
+}
+
+// OCRProviderInfo is one value accepted in the model field of analysis requests
+type OCRProviderInfo struct {
+	Name           string             `json:"name"` // Value of the model field
+	Model          string             `json:"model"`
+	Configured     bool               `json:"configured"` // API key set (or MOCK_AI)
+	Enabled        bool               `json:"enabled"`    // false = disabled through the admin API
+	DisabledReason string             `json:"disabled_reason,omitempty"`
+	FileTypes      []string           `json:"file_types"` // MIME types
+	Pricing        ProviderPricing    `json:"pricing"`
+	RateLimit      *ProviderRateLimit `json:"rate_limit,omitempty"` // nil = no client-side limit
+}
+
+// AccountingModelInfo is the model of one analysis phase after OCR (always Gemini)
+type AccountingModelInfo struct {
+	Phase    string          `json:"phase"`
+	Provider string          `json:"provider"`
+	Model    string          `json:"model"`
+	Usage    string          `json:"usage"`
+	Pricing  ProviderPricing `json:"pricing"`
+}
+
+// FileTypeInfo is a file type accepted by imageuri / multipart uploads
+type FileTypeInfo struct {
+	MimeType   string   `json:"mime_type"`
+	Extensions []string `json:"extensions"`
+}
+
+// ProvidersResponse is the response of GET /api/v1/providers
+type ProvidersResponse struct {
+	OCRProviders     []OCRProviderInfo     `json:"ocr_providers"`
+	AccountingModels []AccountingModelInfo `json:"accounting_models"`
+	FileTypes        []FileTypeInfo        `json:"file_types"`
+	USDToTHB         float64               `json:"usd_to_thb"`
+	MockAI           bool                  `json:"mock_ai"`
+}
+
+// supportedFileTypes - same list as the upload validation of test-template / standalone endpoints
+var supportedFileTypes = []FileTypeInfo{
+	{MimeType: "image/jpeg", Extensions: []string{".jpg", ".jpeg"}},
+	{MimeType: "image/png", Extensions: []string{".png"}},
+	{MimeType: "application/pdf", Extensions: []string{".pdf"}},
+}
+
+// tokenPricing builds token pricing from a configs price pair
+func tokenPricing(input, output float64) ProviderPricing {
+	return ProviderPricing{Unit: PricingUnitToken, InputUSDPerMillion: input, OutputUSDPerMillion: output}
+}
+
+// geminiRateLimit describes the shared Gemini rate limiter
+func geminiRateLimit() *ProviderRateLimit {
+	burst, refill := ratelimit.Limits()
+	limit := &ProviderRateLimit{Burst: burst, RefillIntervalMs: refill.Milliseconds()}
+	if limit.RefillIntervalMs > 0 {
+		limit.RequestsPerMinute = int(60_000 / limit.RefillIntervalMs)
+	}
+	return limit
+}
+
+// ProvidersHandler handles GET /api/v1/providers
+func ProvidersHandler(c *gin.Context) {
+	mimeTypes := make([]string, 0, len(supportedFileTypes))
+	for _, fileType := range supportedFileTypes {
+		mimeTypes = append(mimeTypes, fileType.MimeType)
+	}
+
+	// Step 1: OCR providers (model field of analyze-receipt, ocr, classify-document ...)
+	ocrProviders := []OCRProviderInfo{
+		{
+			Name:      "gemini",
+			Model:     configs.OCR_MODEL_NAME,
+			FileTypes: mimeTypes,
+			Pricing:   tokenPricing(configs.OCR_INPUT_PRICE_PER_MILLION, configs.OCR_OUTPUT_PRICE_PER_MILLION),
+			RateLimit: geminiRateLimit(),
+		},
+		{
+			Name:      "mistral",
+			Model:     configs.MISTRAL_MODEL_NAME,
+			FileTypes: mimeTypes,
+			Pricing:   ProviderPricing{Unit: PricingUnitPage, USDPerThousandPages: common.MistralCostPerPageUSD * 1000},
+		},
+	}
+	for i := range ocrProviders {
+		provider := &ocrProviders[i]
+		provider.Configured = ai.ProviderConfigured(provider.Name)
+		reason, disabled := ai.DisabledProviderReason(provider.Name)
+		provider.Enabled = !disabled
+		provider.DisabledReason = reason
+	}
+
+	// Step 2: Accounting models (chosen by the pipeline, not by the client)
+	accountingModels := []AccountingModelInfo{
+		{
+			Phase:    common.CostPhaseTemplateMatch,
+			Provider: "gemini",
+			Model:    configs.TEMPLATE_MODEL_NAME,
+			Usage:    "Template matching",
+			Pricing:  tokenPricing(configs.TEMPLATE_INPUT_PRICE_PER_MILLION, configs.TEMPLATE_OUTPUT_PRICE_PER_MILLION),
+		},
+		{
+			Phase:    common.CostPhaseAccounting,
+			Provider: "gemini",
+			Model:    configs.TEMPLATE_ACCOUNTING_MODEL_NAME,
+			Usage:    "Accounting analysis with a matched template (template-only mode)",
+			Pricing:  tokenPricing(configs.TEMPLATE_ACCOUNTING_INPUT_PRICE_PER_MILLION, configs.TEMPLATE_ACCOUNTING_OUTPUT_PRICE_PER_MILLION),
+		},
+		{
+			Phase:    common.CostPhaseAccounting,
+			Provider: "gemini",
+			Model:    configs.ACCOUNTING_MODEL_NAME,
+			Usage:    "Full accounting analysis (no confident template match)",
+			Pricing:  tokenPricing(configs.ACCOUNTING_INPUT_PRICE_PER_MILLION, configs.ACCOUNTING_OUTPUT_PRICE_PER_MILLION),
+		},
+	}
+
+	c.JSON(http.StatusOK, ProvidersResponse{
+		OCRProviders:     ocrProviders,
+		AccountingModels: accountingModels,
+		FileTypes:        supportedFileTypes,
+		USDToTHB:         configs.Get().USDToTHB,
+		MockAI:           configs.MOCK_AI,
+	})
+}
@@ -0,0 +1,79 @@
+// pure_ocr.go - Standalone OCR of any document (raw text only)
+//
+// ใช้ OCR provider เดียวกับ analyze-receipt (gemini / mistral, rate limit, safety fallback, chunked OCR)
+// แต่ไม่ต้องมี master data และไม่วิเคราะห์บัญชี - ให้ทีมอื่นใช้อ่านเอกสารทั่วไป
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/gin-gonic/gin"
+)
+
+// PureOCRResponse is the response of POST /api/v1/ocr
+type PureOCRResponse struct {
+	RequestID         string `json:"request_id"`
+	DocumentImageGUID string `json:"documentimageguid,omitempty"`
+	Provider          string `json:"provider"` // OCR provider that read the document
+	ai.SimpleOCRResult
+	ProcessingTimeMs  int64                      `json:"processing_time_ms"`
+	TokenUsage        common.TokenUsage          `json:"token_usage"`
+	ImageReductions   []common.ImageReduction    `json:"image_reductions,omitempty"`   // Images shrunk before the Gemini call
+	FileScans         []common.FileScan          `json:"file_scans,omitempty"`         // Malware scan of the file (FILE_SCAN_DRIVER)
+	ImageSanitization []common.ImageSanitization `json:"image_sanitization,omitempty"` // Metadata stripped from the image (STRIP_IMAGE_METADATA)
+	Steps             []common.StepLog           `json:"steps"`                        // Per-step timings and tokens / cost
+}
+
+// PureOCRHandler handles POST /api/v1/ocr
+func PureOCRHandler(c *gin.Context) {
+	// Step 1: Parse request (multipart file or JSON imageuri)
+	var req StandaloneDocumentRequest
+	if !bindStandaloneRequest(c, &req, &req) {
+		return
+	}
+
+	reqCtx := common.NewRequestContext(req.ShopID)
+	c.Set(requestIDContextKey, reqCtx.RequestID)
+	reqCtx.LogInfo("📄 Standalone OCR | ShopID: %s | OCR Provider: %s", req.ShopID, req.Model)
+	defer recordUsageLedger(c, reqCtx, "ocr", req.Model)
+	defer saveAITraces(reqCtx, "ocr")
+
+	// Request deadline (REQUEST_TIMEOUT) - each phase derives its own deadline from it
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout())
+	defer cancel()
+
+	// Step 2: Receive the document
+	path, ok := receiveStandaloneDocument(c, ctx, reqCtx, req)
+	if !ok {
+		return
+	}
+	defer os.Remove(path)
+
+	// Step 3: OCR
+	ocrResult, provider := runStandaloneOCR(c, ctx, reqCtx, req.Model, path)
+	if ocrResult == nil {
+		return
+	}
+	reqCtx.LogInfo("✅ OCR completed: %d characters (partial: %v)", ocrResult.TextLength, ocrResult.IsPartial)
+	summary := reqCtx.GetSummary()
+
+	result := *ocrResult
+	result.RawResponse = "" // Raw AI response is internal (kept in the AI traces)
+	c.JSON(http.StatusOK, PureOCRResponse{
+		RequestID:         reqCtx.RequestID,
+		DocumentImageGUID: req.DocumentImageGUID,
+		Provider:          provider,
+		SimpleOCRResult:   result,
+		ProcessingTimeMs:  summary["total_duration_ms"].(int64),
+		TokenUsage:        reqCtx.TotalTokens,
+		ImageReductions:   reqCtx.ImageReductions(),
+		FileScans:         reqCtx.FileScans(),
+		ImageSanitization: reqCtx.ImageSanitizations(),
+		Steps:             reqCtx.GetSteps(),
+	})
+}
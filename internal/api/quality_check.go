@@ -0,0 +1,136 @@
+// quality_check.go - Photo quality check before analysis (no OCR / AI call)
+//
+// ให้แอปมือถือตรวจรูปก่อนเรียก analyze-receipt: มืด, เบลอ, แสงสะท้อน, เงา, ถ่ายไม่ครบ, ไม่มีตัวอักษร
+// วัดจาก pixel อย่างเดียว (processor.AssessImageQuality) ไม่มีค่าใช้จ่าย AI และไม่บันทึก usage ledger
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/gin-gonic/gin"
+)
+
+// QualityCheckResponse is the response of POST /api/v1/quality-check
+type QualityCheckResponse struct {
+	RequestID         string                        `json:"request_id"`
+	DocumentImageGUID string                        `json:"documentimageguid,omitempty"`
+	Acceptable        bool                          `json:"acceptable"` // false = retake the photo (at least one blocking issue)
+	Score             float64                       `json:"score"`      // 0-100
+	Metrics           processor.ImageQualityMetrics `json:"metrics"`
+	Issues            []ImageQualityIssue           `json:"issues"` // blocking = false: analysis still works, accuracy may drop
+	ProcessingTimeMs  int64                         `json:"processing_time_ms"`
+}
+
+// formatQualityValue renders a measured value (shares as %, the rest with 1 decimal)
+func formatQualityValue(check string, value float64) string {
+	switch check {
+	case "glare", "text_density":
+		return fmt.Sprintf("%.1f%%", value*100)
+	case "resolution":
+		return fmt.Sprintf("%.0fpx", value)
+	}
+	return fmt.Sprintf("%.1f", value)
+}
+
+// QualityCheckHandler handles POST /api/v1/quality-check
+func QualityCheckHandler(c *gin.Context) {
+	// Step 1: Parse request (multipart file or JSON imageuri) - model is not used
+	var req StandaloneDocumentRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Invalid request format",
+			"details":  err.Error(),
+			"expected": "multipart/form-data with file + shopid, or JSON with shopid + imageuri",
+		})
+		return
+	}
+	if req.ShopID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "shopid is required",
+		})
+		return
+	}
+	if !isMultipartRequest(c) && req.ImageURI == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "imageuri is required",
+			"message": "ส่ง imageuri (JSON) หรืออัปโหลดไฟล์ในฟิลด์ 'file' (multipart/form-data)",
+		})
+		return
+	}
+	if rejectForeignShop(c, req.ShopID) || rejectSuspendedShop(c, req.ShopID) {
+		return
+	}
+
+	reqCtx := common.NewRequestContext(req.ShopID)
+	c.Set(requestIDContextKey, reqCtx.RequestID)
+	reqCtx.LogInfo("🔍 Quality check | ShopID: %s", req.ShopID)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout())
+	defer cancel()
+
+	// Step 2: Receive the photo
+	path, ok := receiveStandaloneDocument(c, ctx, reqCtx, req)
+	if !ok {
+		return
+	}
+	defer os.Remove(path)
+
+	if strings.ToLower(filepath.Ext(path)) == ".pdf" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "quality check supports images only",
+			"message": "ตรวจคุณภาพได้เฉพาะรูปถ่าย (JPG/PNG) - ไฟล์ PDF ส่ง analyze-receipt ได้โดยตรง",
+		})
+		return
+	}
+
+	// Step 3: Measure
+	metrics, err := processor.AssessImageFile(path)
+	if err != nil {
+		reqCtx.LogError("Failed to read image: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid image",
+			"message": "อ่านไฟล์รูปไม่ได้ กรุณาส่งไฟล์ JPG หรือ PNG",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Step 4: Advice
+	response := QualityCheckResponse{
+		RequestID:         reqCtx.RequestID,
+		DocumentImageGUID: req.DocumentImageGUID,
+		Acceptable:        true,
+		Score:             metrics.Score,
+		Metrics:           metrics,
+		Issues:            []ImageQualityIssue{},
+	}
+	for _, finding := range metrics.Findings() {
+		limit := ">= "
+		if finding.Max {
+			limit = "<= "
+		}
+		response.Issues = append(response.Issues, ImageQualityIssue{
+			Field:        finding.Check,
+			Issue:        finding.Issue,
+			CurrentValue: formatQualityValue(finding.Check, finding.Value),
+			MinRequired:  limit + formatQualityValue(finding.Check, finding.Limit),
+			Advice:       finding.Advice,
+			Blocking:     finding.Blocking,
+		})
+		if finding.Blocking {
+			response.Acceptable = false
+		}
+	}
+	reqCtx.LogInfo("✅ Quality check: score %.1f | acceptable: %v | issues: %d", metrics.Score, response.Acceptable, len(response.Issues))
+	response.ProcessingTimeMs = reqCtx.GetSummary()["total_duration_ms"].(int64)
+
+	c.JSON(http.StatusOK, response)
+}
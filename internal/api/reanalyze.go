@@ -0,0 +1,495 @@
+// reanalyze.go - Re-run template matching + accounting on the stored OCR text of a request
+//
+// ผู้ใช้ไม่พอใจผลวิเคราะห์ (เลือก template ผิด, เจ้าหนี้ผิด) → วิเคราะห์ซ้ำโดยไม่ต้อง OCR ใหม่
+// ใช้ raw text ที่เก็บไว้ (ocrResults, OCR_RESULT_TTL_DAYS) + override ที่ผู้ใช้ระบุ
+// ไม่ทำซ้ำ: OCR, ลายมือ, QR override, การจัดกลุ่มเอกสาร, ตรวจสลิป (ต้องใช้รูปภาพ)
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ReanalyzeRequest holds the optional overrides of a re-analysis
+type ReanalyzeRequest struct {
+	TemplateID   string `json:"template_id,omitempty"`   // Force this documentFormate template (template-only mode)
+	CreditorCode string `json:"creditor_code,omitempty"` // Force this creditor (skips vendor pre-matching)
+	Model        string `json:"model,omitempty"`         // Accounting model, e.g. "gemini-2.5-pro" (default = configured model)
+}
+
+// storeOCRResult keeps the OCR text of a request for re-analysis and document search (OCR_RESULT_TTL_DAYS)
+// summary / analysis = set when the analysis already finished (nil = added later by storeOCRSummary)
+func storeOCRResult(repo storage.ResultRepo, reqCtx *common.RequestContext, ocrProvider, parentRequestID string, images []storage.StoredOCRImage, summary *storage.OCRDocumentSummary, analysis *storage.OCRAnalysisSnapshot) {
+	if len(images) == 0 {
+		return
+	}
+	record := storage.StoredOCRResult{
+		RequestID:       reqCtx.RequestID,
+		ShopID:          reqCtx.ShopID,
+		OCRProvider:     ocrProvider,
+		ParentRequestID: parentRequestID,
+		Images:          images,
+		Summary:         summary,
+		Analysis:        analysis,
+	}
+	ttl := time.Duration(configs.OCR_RESULT_TTL_DAYS) * 24 * time.Hour
+
+	// Write in background - must not delay the response
+	go func() {
+		if err := repo.SaveOCRResult(record, ttl); err != nil {
+			reqCtx.LogWarning("Failed to store OCR result: %v", err)
+		}
+	}()
+}
+
+// compactMasterData returns the master data sent to Phase 3 (posting-level accounts, essential fields only)
+func compactMasterData(masterCache *storage.MasterDataCache) (accounts, journalBooks, creditors, debtors []bson.M) {
+	for _, acc := range masterCache.Accounts {
+		level := 0
+		switch v := acc["accountlevel"].(type) {
+		case int32:
+			level = int(v)
+		case int64:
+			level = int(v)
+		case float64:
+			level = int(v)
+		}
+		if level >= 3 {
+			accounts = append(accounts, bson.M{"accountcode": acc["accountcode"], "accountname": acc["accountname"]})
+		}
+	}
+	for _, jb := range masterCache.JournalBooks {
+		journalBooks = append(journalBooks, bson.M{"code": jb["code"], "name1": jb["name1"]})
+	}
+	for _, cr := range masterCache.Creditors {
+		creditors = append(creditors, bson.M{"code": cr["code"], "name": extractNameFromNamesArray(cr)})
+	}
+	for _, db := range masterCache.Debtors {
+		debtors = append(debtors, bson.M{"code": db["code"], "name": extractNameFromNamesArray(db)})
+	}
+	return accounts, journalBooks, creditors, debtors
+}
+
+// ReanalyzeResultHandler handles POST /api/v1/results/:request_id/reanalyze
+func ReanalyzeResultHandler(c *gin.Context) {
+	originalRequestID := c.Param("request_id")
+
+	var req ReanalyzeRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+	req.TemplateID = strings.TrimSpace(req.TemplateID)
+	req.CreditorCode = strings.TrimSpace(req.CreditorCode)
+	req.Model = strings.TrimSpace(req.Model)
+	if req.Model != "" && !strings.HasPrefix(req.Model, "gemini-") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid model",
+			"message": fmt.Sprintf("Model '%s' ไม่ถูกต้อง การวิเคราะห์บัญชีใช้ Gemini เท่านั้น (เช่น gemini-2.5-pro)", req.Model),
+		})
+		return
+	}
+
+	runReanalysis(c, originalRequestID, req)
+}
+
+// loadReanalysisRecord loads the stored OCR text of a request (nil = error response already written)
+func loadReanalysisRecord(c *gin.Context, originalRequestID string) *storage.StoredOCRResult {
+	record, err := dataStore.GetOCRResult(c.Request.Context(), originalRequestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load OCR result",
+			"details": err.Error(),
+		})
+		return nil
+	}
+	if record == nil {
+		message := "ไม่พบข้อความ OCR ของ request นี้ (อาจหมดอายุแล้ว หรือ request_id ไม่ถูกต้อง)"
+		if configs.OCR_RESULT_TTL_DAYS <= 0 {
+			message = "OCR result storage is disabled (set OCR_RESULT_TTL_DAYS > 0)"
+		}
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":      "ocr result not found",
+			"message":    message,
+			"request_id": originalRequestID,
+		})
+		return nil
+	}
+
+	// Result of another shop (shop key) or suspended shop (admin API) → 403
+	if rejectForeignShop(c, record.ShopID) || rejectSuspendedShop(c, record.ShopID) {
+		return nil
+	}
+	return record
+}
+
+// reanalysisInputs rebuilds the Phase 3 image / OCR lists from the stored OCR text
+func reanalysisInputs(record *storage.StoredOCRResult) (images []ImageData, ocrResults []PureOCRImageResult, combinedText string) {
+	for _, img := range record.Images {
+		images = append(images, ImageData{Index: img.ImageIndex, GUID: img.DocumentImageGUID, URI: img.ImageURI})
+		ocrResults = append(ocrResults, PureOCRImageResult{
+			ImageIndex: img.ImageIndex,
+			Result: &ai.SimpleOCRResult{
+				Status:          "success",
+				RawDocumentText: img.RawText,
+				TextLength:      len(img.RawText),
+			},
+		})
+		combinedText += img.RawText + "\n\n"
+	}
+	return images, ocrResults, combinedText
+}
+
+// reanalysisParty resolves the creditor / debtor of a stored document (creditorCode = forced by the user)
+// false = error response already written
+func reanalysisParty(c *gin.Context, record *storage.StoredOCRResult, creditorCode string, masterCache *storage.MasterDataCache, reqCtx *common.RequestContext) (processor.VendorMatchResult, processor.DocumentDirection, bool) {
+	vendorMatchResult := processor.VendorMatchResult{Method: "not_found"}
+	documentDirection := processor.DocumentDirection{Direction: processor.DirectionUnknown}
+	if len(record.Images) > 0 {
+		documentDirection = detectDocumentDirection(record.Images[0].RawText, masterCache, reqCtx)
+	}
+	if creditorCode != "" {
+		for _, creditor := range masterCache.Creditors {
+			if code, ok := creditor["code"].(string); ok && code == creditorCode {
+				vendorMatchResult = processor.VendorMatchResult{
+					Found:      true,
+					Code:       code,
+					Name:       extractNameFromNamesArray(creditor),
+					Similarity: 100,
+					Method:     "override",
+					Party:      processor.PartyCreditor,
+				}
+				break
+			}
+		}
+		if !vendorMatchResult.Found {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":      "creditor not found",
+				"message":    "ไม่พบเจ้าหนี้ " + creditorCode + " ในร้าน",
+				"request_id": reqCtx.RequestID,
+			})
+			return vendorMatchResult, documentDirection, false
+		}
+	} else if len(record.Images) > 0 {
+		// Sales document (our shop is the issuer) → debtor
+		if documentDirection.IsSale() {
+			vendorMatchResult = preMatchSaleDebtor(documentDirection, masterCache, reqCtx)
+		} else {
+			for _, line := range strings.Split(record.Images[0].RawText, "\n") {
+				if trimmed := strings.TrimSpace(line); len(trimmed) > 5 {
+					vendorMatchResult = processor.MatchVendor(trimmed, masterCache.Creditors, "", masterCache.CreditorAliases)
+					break
+				}
+			}
+		}
+	}
+	if mapping, ok := masterCache.VendorAccountMappings[vendorMatchResult.Code]; ok && vendorMatchResult.Found && vendorMatchResult.Party != processor.PartyDebtor {
+		vendorMatchResult.LearnedAccountCode = mapping.AccountCode
+		vendorMatchResult.LearnedAccountName = mapping.AccountName
+	}
+	return vendorMatchResult, documentDirection, true
+}
+
+// runReanalysis re-runs template matching + accounting on the stored OCR text of originalRequestID
+// Shared by the reanalyze endpoint and the dead-letter retry
+func runReanalysis(c *gin.Context, originalRequestID string, req ReanalyzeRequest) {
+	// Step 1: Load the stored OCR text
+	record := loadReanalysisRecord(c, originalRequestID)
+	if record == nil {
+		return
+	}
+
+	reqCtx := common.NewRequestContext(record.ShopID)
+	reqCtx.AccountingModel = req.Model
+	c.Set(requestIDContextKey, reqCtx.RequestID)
+	defer recordUsageLedger(c, reqCtx, "reanalyze", record.OCRProvider)
+	defer saveAITraces(reqCtx, "reanalyze")
+	reqCtx.LogInfo("🔁 Re-analysis of %s | ShopID: %s | template: %q, creditor: %q, model: %q",
+		originalRequestID, record.ShopID, req.TemplateID, req.CreditorCode, req.Model)
+
+	// Request deadline (REQUEST_TIMEOUT) - template match / accounting derive their own deadline from it
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout())
+	defer cancel()
+
+	// Step 2: Master data + templates
+	masterCache, err := dataStore.GetOrLoadMasterData(ctx, record.ShopID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Failed to load master data",
+			"details":    err.Error(),
+			"request_id": reqCtx.RequestID,
+		})
+		return
+	}
+	applyShopSettings(reqCtx, masterCache.ShopSettings)
+	loadDimensionValues(reqCtx, masterCache)
+	documentTemplates, err := dataStore.ListDocumentTemplates(ctx, record.ShopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to load document templates",
+			"details":    err.Error(),
+			"request_id": reqCtx.RequestID,
+		})
+		return
+	}
+
+	images, ocrResults, combinedText := reanalysisInputs(record)
+	detectDocumentLanguage(combinedText, reqCtx)
+
+	// Step 3: Template - forced by the user, else matched again
+	templateMatch := TemplateMatchOutcome{Mode: ai.FullMode, Threshold: reqCtx.Settings.TemplateConfidenceThreshold}
+	if req.TemplateID != "" {
+		for _, t := range documentTemplates {
+			if templateIDString(t["_id"]) == req.TemplateID {
+				description, _ := t["description"].(string)
+				templateMatch.Result = processor.TemplateMatchResult{
+					Template:    t,
+					Confidence:  100,
+					Description: description,
+					TemplateID:  t["_id"],
+					Reason:      "ผู้ใช้ระบุ template (reanalyze override)",
+				}
+				break
+			}
+		}
+		if templateMatch.Result.Template == nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":      "template not found",
+				"message":    "ไม่พบ template " + req.TemplateID + " ในร้าน",
+				"request_id": reqCtx.RequestID,
+			})
+			return
+		}
+		templateMatch.Mode = ai.TemplateOnlyMode
+		templateMatch.Template = &templateMatch.Result.Template
+		reqCtx.LogInfo("🎯 Template forced: %s (ID: %s)", templateMatch.Result.Description, req.TemplateID)
+	} else {
+		templateMatch = analyzeService.MatchTemplate(ctx, ocrResults, documentTemplates, templateMatch.Threshold, reqCtx)
+		if err := reqCtx.BudgetError(); err != nil {
+			respondBudgetExceeded(c, reqCtx, err)
+			return
+		}
+		if templateMatch.Mode == ai.TemplateOnlyMode {
+			reqCtx.LogInfo("✅ Template matched: %s (Confidence: %.1f%%)", templateMatch.Result.Description, templateMatch.Result.Confidence)
+		}
+	}
+	templateMatchResult, matchedTemplate := templateMatch.Result, templateMatch.Template
+
+	// Step 4: Party - creditor forced by the user, else the debtor of a sales document / creditor fuzzy-matched on the first text line
+	vendorMatchResult, documentDirection, ok := reanalysisParty(c, record, req.CreditorCode, masterCache, reqCtx)
+	if !ok {
+		return
+	}
+
+	// Step 5-6: Phase 3 + the deterministic rules of analyze-receipt (QR, slips and document groups need the images)
+	analysis, err := analyzeService.Analyze(ctx, &AnalysisInput{
+		Request:       ExtractRequest{ShopID: record.ShopID, Model: record.OCRProvider},
+		MasterCache:   masterCache,
+		Templates:     documentTemplates,
+		OCRProvider:   record.OCRProvider,
+		Images:        images,
+		OCRResults:    ocrResults,
+		TemplateMatch: templateMatch,
+		Vendor:        &vendorMatchResult,
+		Direction:     &documentDirection,
+	}, reqCtx)
+	if err != nil {
+		var accountingErr *AccountingError
+		errors.As(err, &accountingErr)
+		failure := analysisFailure{
+			Endpoint:     "reanalyze",
+			Phase:        failurePhaseAccounting,
+			Payload:      req,
+			OCRRequestID: originalRequestID,
+			Err:          accountingErr.Err,
+			Artifacts:    accountingErr.Artifacts,
+		}
+		if accountingErr.PhaseTimeout {
+			recordFailedRequest(c, reqCtx, failure)
+			respondPhaseTimeout(c, reqCtx, failurePhaseAccounting)
+			return
+		}
+		if reqCtx.BudgetError() != nil {
+			respondBudgetExceeded(c, reqCtx, accountingErr.Err)
+			return
+		}
+		if blockErr, blocked := ai.AsSafetyBlock(accountingErr.Err); blocked {
+			respondContentBlocked(c, reqCtx, blockErr, -1)
+			return
+		}
+		recordFailedRequest(c, reqCtx, failure)
+		message := "Accounting analysis failed"
+		if accountingErr.Parse {
+			message = "Failed to parse accounting response"
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      message,
+			"details":    accountingErr.Err.Error(),
+			"request_id": reqCtx.RequestID,
+		})
+		return
+	}
+	accountingResponse := analysis.Response
+	vendorMatchResult = analysis.Vendor
+	receipt, _ := accountingResponse["receipt"].(map[string]interface{})
+	if receipt == nil {
+		receipt = map[string]interface{}{}
+	}
+	accountingEntry, _ := accountingResponse["accounting_entry"].(map[string]interface{})
+	if accountingEntry == nil {
+		accountingEntry = map[string]interface{}{}
+		accountingResponse["accounting_entry"] = accountingEntry
+	}
+	sourceImages, _ := accountingResponse["source_images"].([]interface{})
+	foreignDoc := analysis.ForeignDocument
+	templateFormulas := analysis.TemplateFormulas
+	rules := analysisEntryRules(analysis, accountingEntry, receipt, masterCache)
+
+	if vendorMatchResult.Found && vendorMatchResult.Party == processor.PartyDebtor {
+		accountingEntry["debtor_code"] = vendorMatchResult.Code
+		accountingEntry["debtor_name"] = vendorMatchResult.Name
+	} else if vendorMatchResult.Found {
+		accountingEntry["creditor_code"] = vendorMatchResult.Code
+		accountingEntry["creditor_name"] = vendorMatchResult.Name
+	} else if creditorObj, ok := accountingResponse["creditor"].(map[string]interface{}); ok {
+		if code := getStringValue(creditorObj, "creditor_code"); code != "" {
+			accountingEntry["creditor_code"] = code
+			accountingEntry["creditor_name"] = getStringValue(creditorObj, "creditor_name")
+		}
+	}
+
+	documentDirectionCheck := directionCheck(documentDirection, accountingEntry, reqCtx)
+	selfInvoice := selfInvoiceCheck(receipt, accountingEntry, masterCache, reqCtx)
+	if selfInvoice != nil && configs.Get().SelfInvoiceAction == "reject" {
+		respondSelfInvoice(c, reqCtx, selfInvoice)
+		return
+	}
+	vatCheck := vatMathCheck(receipt, accountingEntry, reqCtx.Settings, reqCtx)
+
+	// Same document as the original request - the branch was already counted on the creditor
+	applyCreditorBranch(reqCtx, receipt, accountingEntry, combinedText, masterCache, false)
+
+	var learnedMapping *processor.LearnedMappingResult
+	if mapping, ok := masterCache.VendorAccountMappings[getStringValue(accountingEntry, "creditor_code")]; ok {
+		result := processor.ApplyLearnedMapping(accountingEntry, mapping, templateMatch.Mode == ai.TemplateOnlyMode)
+		learnedMapping = &result
+	}
+	dimensions := assignDimensions(accountingEntry, combinedText, reqCtx.Settings, masterCache, reqCtx)
+
+	confidence := processor.CalculateWeightedConfidence(&templateMatchResult, &vendorMatchResult, accountingEntry, reqCtx)
+	validationData := map[string]interface{}{
+		"confidence": map[string]interface{}{
+			"level": confidence.OverallLevel,
+			"score": confidence.OverallScore,
+		},
+		"requires_review": confidence.RequiresReview || rules.requiresReview(),
+	}
+	if rules.VATEnforcement != nil {
+		validationData["vat_enforcement"] = *rules.VATEnforcement
+	}
+	if rules.VATSplit != nil {
+		validationData["vat_split"] = *rules.VATSplit
+	}
+	if len(rules.AccountChecks) > 0 {
+		validationData["account_checks"] = rules.AccountChecks
+	}
+	if rules.AdjustmentNote != nil {
+		validationData["adjustment_note"] = *rules.AdjustmentNote
+	}
+	if rules.FixedAssets != nil {
+		validationData["fixed_assets"] = *rules.FixedAssets
+	}
+	if rules.BalanceCorrection != nil {
+		validationData["balance_correction"] = *rules.BalanceCorrection
+	}
+	if documentDirectionCheck != nil {
+		validationData["direction_check"] = *documentDirectionCheck
+		if !documentDirectionCheck.Agreed {
+			validationData["requires_review"] = true
+		}
+	}
+	if selfInvoice != nil {
+		validationData["self_invoice"] = *selfInvoice
+		validationData["requires_review"] = true
+	}
+	if vatCheck != nil {
+		validationData["vat_check"] = *vatCheck
+		if !vatCheck.Agreed {
+			validationData["requires_review"] = true
+		}
+	}
+	if foreignDoc != nil {
+		validationData["foreign_document"] = *foreignDoc
+		if foreignDoc.RequiresReview() {
+			validationData["requires_review"] = true
+		}
+	}
+	if dimensions != nil {
+		validationData["dimensions"] = *dimensions
+		if dimensions.RequiresReview() {
+			validationData["requires_review"] = true
+		}
+	}
+	if templateFormulas != nil {
+		validationData["template_formulas"] = *templateFormulas
+		if templateFormulas.RequiresReview() {
+			validationData["requires_review"] = true
+		}
+	}
+
+	templateInfo := processor.ExtractTemplateInfo(accountingResponse, documentTemplates, matchedTemplate, reqCtx)
+	templateInfo["learned_mapping_used"] = learnedMapping != nil && learnedMapping.Used
+	if learnedMapping != nil {
+		templateInfo["learned_mapping"] = *learnedMapping
+	}
+
+	// The re-analysis can itself be re-analyzed (and is found by document search with its own header)
+	documentSummary := ocrDocumentSummary(receipt)
+	requiresReview, _ := validationData["requires_review"].(bool)
+	snapshot := ocrAnalysisSnapshot(receipt, accountingEntry, templateMatchResult.Description, confidence, requiresReview)
+	storeOCRResult(dataStore, reqCtx, record.OCRProvider, originalRequestID, record.Images, &documentSummary, snapshot)
+
+	summary := reqCtx.GetSummary()
+	metadata := gin.H{
+		"request_id":     reqCtx.RequestID,
+		"processed_at":   time.Now().Format(time.RFC3339),
+		"duration_sec":   summary["total_duration_sec"],
+		"token_usage":    summary["token_usage"],
+		"cost_breakdown": reqCtx.GetCostBreakdown(),
+		"steps":          reqCtx.GetSteps(),
+		"settings":       reqCtx.Settings,
+	}
+	if phaseTimeouts := reqCtx.PhaseTimeouts(); len(phaseTimeouts) > 0 {
+		metadata["phase_timeouts"] = phaseTimeouts
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"shopid":           record.ShopID,
+		"status":           "success",
+		"reanalysis_of":    originalRequestID,
+		"overrides":        req,
+		"receipt":          receipt,
+		"accounting_entry": accountingEntry,
+		"source_images":    sourceImages,
+		"template_info":    templateInfo,
+		"validation":       validationData,
+		"metadata":         metadata,
+	})
+}
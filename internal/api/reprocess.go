@@ -0,0 +1,414 @@
+// reprocess.go - Re-processing campaigns: re-analyze a shop's stored documents (e.g. after prompt changes)
+//
+// POST /api/v1/shops/:shopid/reprocess เลือกเอกสารตามวันที่เอกสาร + filter → สร้าง campaign ใน reprocessCampaigns
+// runner (StartReprocessRunner) วิเคราะห์ซ้ำทีละเอกสารด้วย pipeline เดียวกับ POST /results/:request_id/reanalyze
+// (ใช้ข้อความ OCR ที่เก็บไว้ ไม่ OCR ใหม่) แล้วสรุปว่าผลเปลี่ยนอะไรบ้างเทียบกับผลเดิม
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ratelimit"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// reprocessListLimit - campaigns returned by GET /api/v1/shops/:shopid/reprocess
+const reprocessListLimit = 50
+
+// reprocessLeaseMargin is added to REQUEST_TIMEOUT so a campaign is not claimed again while a document is analyzed
+const reprocessLeaseMargin = 60 * time.Second
+
+// reprocessReceiptFields - receipt fields whose change counts as a "receipt" change
+var reprocessReceiptFields = []string{"number", "date", "total", "vat", "vendor_tax_id"}
+
+// ReprocessRequest is the body of POST /api/v1/shops/:shopid/reprocess
+type ReprocessRequest struct {
+	From               string `json:"from" binding:"required"` // Document date YYYY-MM-DD (inclusive)
+	To                 string `json:"to" binding:"required"`   // Document date YYYY-MM-DD (inclusive)
+	CreditorCode       string `json:"creditor_code,omitempty"`
+	RequiresReviewOnly bool   `json:"requires_review_only,omitempty"`
+	IncludeFinal       bool   `json:"include_final,omitempty"` // Also re-analyze approved results (the approval is kept)
+	Model              string `json:"model,omitempty"`         // Accounting model, e.g. "gemini-2.5-pro" (default = configured model)
+	DryRun             bool   `json:"dry_run,omitempty"`       // Only count the matching documents
+}
+
+// ReprocessDryRunResponse is the response of a dry run
+type ReprocessDryRunResponse struct {
+	ShopID    string                   `json:"shopid"`
+	Filters   storage.ReprocessFilters `json:"filters"`
+	Documents int                      `json:"documents"`
+	Limit     int                      `json:"limit"` // REPROCESS_MAX_DOCUMENTS
+}
+
+// ReprocessCampaignsResponse lists the campaigns of a shop (without items)
+type ReprocessCampaignsResponse struct {
+	ShopID    string                      `json:"shopid"`
+	Campaigns []storage.ReprocessCampaign `json:"campaigns"`
+}
+
+// StartReprocessRunner runs queued campaigns one document at a time (REPROCESS_POLL_INTERVAL_SEC, 0 = off)
+func StartReprocessRunner() {
+	if configs.REPROCESS_POLL_INTERVAL_SEC <= 0 {
+		return
+	}
+	hostname, _ := os.Hostname()
+	workerID := fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8])
+
+	go func() {
+		interval := time.Duration(configs.REPROCESS_POLL_INTERVAL_SEC) * time.Second
+		for {
+			campaign, err := storage.ClaimReprocessCampaign(context.Background(), workerID, reprocessLease())
+			if err != nil {
+				log.Printf("⚠️  Failed to claim reprocess campaign: %v", err)
+			}
+			if campaign == nil {
+				time.Sleep(interval)
+				continue
+			}
+			runReprocessCampaign(*campaign)
+		}
+	}()
+}
+
+// reprocessLease - one document must finish within REQUEST_TIMEOUT
+func reprocessLease() time.Duration {
+	return requestTimeout() + reprocessLeaseMargin
+}
+
+// runReprocessCampaign re-analyzes the pending documents of a claimed campaign
+func runReprocessCampaign(campaign storage.ReprocessCampaign) {
+	log.Printf("🔁 Reprocess campaign %s | ShopID: %s | %d/%d documents done", campaign.CampaignID, campaign.ShopID, campaign.Processed, campaign.Total)
+	if campaign.Summary.Changes == nil {
+		campaign.Summary.Changes = map[string]int{}
+	}
+	payload, _ := json.Marshal(ReanalyzeRequest{Model: campaign.Filters.Model})
+
+	for i := range campaign.Items {
+		if campaign.Items[i].Status != storage.ReprocessItemPending {
+			continue
+		}
+		item := reprocessDocument(campaign.Items[i].RequestID, payload)
+		campaign.Items[i] = item
+		campaign.Processed++
+		if item.Status == storage.ReprocessItemFailed {
+			campaign.Summary.Failed++
+		} else {
+			campaign.Summary.Succeeded++
+			if len(item.Changes) > 0 {
+				campaign.Summary.Changed++
+			} else {
+				campaign.Summary.Unchanged++
+			}
+			for _, change := range item.Changes {
+				campaign.Summary.Changes[change]++
+			}
+		}
+
+		if err := storage.RecordReprocessItem(campaign, i, reprocessLease()); err != nil {
+			if errors.Is(err, storage.ErrReprocessCampaignStopped) {
+				log.Printf("⏹️  Reprocess campaign %s stopped (cancelled or claimed by another instance)", campaign.CampaignID)
+				return
+			}
+			log.Printf("⚠️  Reprocess campaign %s: %v", campaign.CampaignID, err)
+		}
+	}
+
+	if err := storage.FinishReprocessCampaign(campaign); err != nil {
+		log.Printf("⚠️  %v", err)
+		return
+	}
+	log.Printf("✅ Reprocess campaign %s done: %d changed, %d unchanged, %d failed",
+		campaign.CampaignID, campaign.Summary.Changed, campaign.Summary.Unchanged, campaign.Summary.Failed)
+}
+
+// reprocessDocument re-analyzes one stored request and lists what changed against its analysis
+func reprocessDocument(requestID string, payload []byte) storage.ReprocessItem {
+	now := time.Now()
+	item := storage.ReprocessItem{RequestID: requestID, Status: storage.ReprocessItemFailed, ProcessedAt: &now}
+
+	original, err := dataStore.GetOCRResult(context.Background(), requestID)
+	if err != nil || original == nil || original.Analysis == nil {
+		item.Error = "stored analysis not found (expired?)"
+		if err != nil {
+			item.Error = err.Error()
+		}
+		return item
+	}
+
+	// Campaigns are bulk work - interactive requests keep their share of the AI rate limit
+	status, body := runLocalReanalysis(ratelimit.WithPriority(context.Background(), common.PriorityBulk), requestID, payload)
+	var response struct {
+		Error           string                 `json:"error"`
+		Details         string                 `json:"details"`
+		Receipt         map[string]interface{} `json:"receipt"`
+		AccountingEntry map[string]interface{} `json:"accounting_entry"`
+		Validation      map[string]interface{} `json:"validation"`
+		Metadata        struct {
+			RequestID string `json:"request_id"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		item.Error = fmt.Sprintf("HTTP %d: invalid response", status)
+		return item
+	}
+	if status != http.StatusOK {
+		item.Error = strings.TrimSpace(fmt.Sprintf("HTTP %d: %s %s", status, response.Error, response.Details))
+		return item
+	}
+
+	requiresReview, _ := response.Validation["requires_review"].(bool)
+	analysis := ocrAnalysisSnapshot(response.Receipt, response.AccountingEntry, "", processor.ConfidenceResult{}, requiresReview)
+	item.Status = storage.ReprocessItemDone
+	item.NewRequestID = response.Metadata.RequestID
+	item.Changes = reprocessChanges(original.Analysis, analysis)
+	return item
+}
+
+// reprocessChanges lists what differs between the original analysis and the re-analysis
+func reprocessChanges(before, after *storage.OCRAnalysisSnapshot) []string {
+	changes := []string{}
+	if before.CreditorCode != after.CreditorCode || before.DebtorCode != after.DebtorCode {
+		changes = append(changes, "creditor")
+	}
+	if before.JournalBookCode != after.JournalBookCode {
+		changes = append(changes, "journal_book")
+	}
+	if len(diffEntries(before.Entries, after.Entries)) > 0 {
+		changes = append(changes, "entries")
+	}
+	for _, field := range reprocessReceiptFields {
+		if !receiptValuesEqual(before.Receipt[field], after.Receipt[field]) {
+			changes = append(changes, "receipt")
+			break
+		}
+	}
+	switch {
+	case before.RequiresReview && !after.RequiresReview:
+		changes = append(changes, "review_cleared")
+	case !before.RequiresReview && after.RequiresReview:
+		changes = append(changes, "review_added")
+	}
+	return changes
+}
+
+// reprocessDocuments selects the latest analysis of every document matching the filters
+func reprocessDocuments(ctx context.Context, shopID string, filters storage.ReprocessFilters) ([]string, error) {
+	to, _ := time.Parse("2006-01-02", filters.To)
+	records, err := dataStore.ListOCRResultsByDocumentDate(ctx, shopID, filters.From, to.AddDate(0, 0, 1).Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	requestIDs := []string{}
+	for _, record := range latestOCRResults(records) {
+		switch {
+		case filters.CreditorCode != "" && record.Analysis.CreditorCode != filters.CreditorCode:
+		case filters.RequiresReviewOnly && !record.Analysis.RequiresReview:
+		case !filters.IncludeFinal && record.Status == storage.OCRResultStatusFinal:
+		default:
+			requestIDs = append(requestIDs, record.RequestID)
+		}
+	}
+	return requestIDs, nil
+}
+
+// CreateReprocessCampaignHandler handles POST /api/v1/shops/:shopid/reprocess
+func CreateReprocessCampaignHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	var req ReprocessRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	from, errFrom := time.Parse("2006-01-02", req.From)
+	to, errTo := time.Parse("2006-01-02", req.To)
+	if errFrom != nil || errTo != nil || to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid date range",
+			"message": "from / to ต้องอยู่ในรูปแบบ YYYY-MM-DD และ from ต้องไม่เกิน to",
+		})
+		return
+	}
+	req.Model = strings.TrimSpace(req.Model)
+	if req.Model != "" && !strings.HasPrefix(req.Model, "gemini-") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid model",
+			"message": fmt.Sprintf("Model '%s' ไม่ถูกต้อง การวิเคราะห์บัญชีใช้ Gemini เท่านั้น (เช่น gemini-2.5-pro)", req.Model),
+		})
+		return
+	}
+	if configs.OCR_RESULT_TTL_DAYS <= 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "reprocess disabled",
+			"message": "OCR result storage is disabled (set OCR_RESULT_TTL_DAYS > 0)",
+		})
+		return
+	}
+
+	filters := storage.ReprocessFilters{
+		From:               req.From,
+		To:                 req.To,
+		CreditorCode:       strings.TrimSpace(req.CreditorCode),
+		RequiresReviewOnly: req.RequiresReviewOnly,
+		IncludeFinal:       req.IncludeFinal,
+		Model:              req.Model,
+	}
+
+	// Step 1: Documents to re-analyze
+	requestIDs, err := reprocessDocuments(c.Request.Context(), shopID, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load documents",
+			"details": err.Error(),
+		})
+		return
+	}
+	limit := configs.Get().ReprocessMaxDocuments
+	if req.DryRun {
+		c.JSON(http.StatusOK, ReprocessDryRunResponse{ShopID: shopID, Filters: filters, Documents: len(requestIDs), Limit: limit})
+		return
+	}
+	if len(requestIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "no documents",
+			"message": "ไม่พบเอกสารที่ตรงกับเงื่อนไข (ข้อความ OCR เก็บไว้ตาม OCR_RESULT_TTL_DAYS)",
+		})
+		return
+	}
+	if len(requestIDs) > limit {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":     "too many documents",
+			"message":   fmt.Sprintf("พบ %d เอกสาร เกินจำนวนสูงสุดต่อ campaign (%d) - แบ่งช่วงวันที่ให้สั้นลง", len(requestIDs), limit),
+			"documents": len(requestIDs),
+		})
+		return
+	}
+
+	// Step 2: One campaign at a time per shop
+	active, err := storage.CountActiveReprocessCampaigns(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to check running campaigns",
+			"details": err.Error(),
+		})
+		return
+	}
+	if active > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "campaign already running",
+			"message": "ร้านนี้มี campaign ที่ยังทำงานอยู่ - รอให้เสร็จหรือยกเลิกก่อน",
+		})
+		return
+	}
+
+	now := time.Now()
+	campaign := storage.ReprocessCampaign{
+		CampaignID: uuid.New().String(),
+		ShopID:     shopID,
+		Filters:    filters,
+		Status:     storage.ReprocessStatusQueued,
+		Total:      len(requestIDs),
+		Summary:    storage.ReprocessSummary{Changes: map[string]int{}},
+		Items:      make([]storage.ReprocessItem, 0, len(requestIDs)),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	for _, requestID := range requestIDs {
+		campaign.Items = append(campaign.Items, storage.ReprocessItem{RequestID: requestID, Status: storage.ReprocessItemPending})
+	}
+	if err := storage.CreateReprocessCampaign(campaign); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to create campaign",
+			"details": err.Error(),
+		})
+		return
+	}
+	log.Printf("🔁 Reprocess campaign %s queued | ShopID: %s | %d documents (%s → %s)", campaign.CampaignID, shopID, campaign.Total, filters.From, filters.To)
+
+	c.JSON(http.StatusAccepted, campaign)
+}
+
+// ListReprocessCampaignsHandler handles GET /api/v1/shops/:shopid/reprocess
+func ListReprocessCampaignsHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	campaigns, err := storage.ListReprocessCampaigns(c.Request.Context(), shopID, reprocessListLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load campaigns",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, ReprocessCampaignsResponse{ShopID: shopID, Campaigns: campaigns})
+}
+
+// GetReprocessCampaignHandler handles GET /api/v1/shops/:shopid/reprocess/:campaign_id
+func GetReprocessCampaignHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	campaign, err := storage.GetReprocessCampaign(c.Request.Context(), shopID, c.Param("campaign_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load campaign",
+			"details": err.Error(),
+		})
+		return
+	}
+	if campaign == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "campaign not found",
+			"message": "ไม่พบ campaign " + c.Param("campaign_id"),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// CancelReprocessCampaignHandler handles POST /api/v1/shops/:shopid/reprocess/:campaign_id/cancel
+func CancelReprocessCampaignHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+	campaignID := c.Param("campaign_id")
+
+	cancelled, err := storage.CancelReprocessCampaign(c.Request.Context(), shopID, campaignID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to cancel campaign",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "campaign not found",
+			"message": "ไม่พบ campaign " + campaignID + " ที่ยังทำงานอยู่",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shopid":      shopID,
+		"campaign_id": campaignID,
+		"status":      storage.ReprocessStatusCancelled,
+	})
+}
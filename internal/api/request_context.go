@@ -135,7 +135,7 @@ func (rc *RequestContext) EndStep(status string, tokens *TokenUsage, err error)
 			logMsg += fmt.Sprintf(" | ขั้นย่อย: %d", len(rc.CurrentSubSteps))
 		}
 
-		log.Printf(logMsg)
+		log.Print(logMsg)
 	}
 
 	rc.Steps = append(rc.Steps, stepLog)
@@ -188,7 +188,7 @@ func (rc *RequestContext) GetSummary() map[string]interface{} {
 		},
 	}
 
-	log.Printf("[%s] \n═══ 🎯 สรุปผล ═══")
+	log.Printf("[%s] \n═══ 🎯 สรุปผล ═══", rc.RequestID)
 	log.Printf("[%s] ⏱️  เวลารวม: %.2fวินาที | 📝 ขั้นตอน: %d | 🪙 Tokens: %s | 💰 ค่าใช้จ่าย: ฿%.2f",
 		rc.RequestID,
 		float64(totalDuration)/1000,
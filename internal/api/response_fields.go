@@ -0,0 +1,151 @@
+// response_fields.go - Sparse fieldsets for the analyze-receipt response (fields= / response_profile=)
+//
+// response เต็มมีขนาดใหญ่ (custom_prompts, คำอธิบายภาษาไทยของ AI, cost breakdown, steps) → mobile client เลือกเฉพาะที่ใช้ได้
+//   response_profile=minimal   receipt + accounting_entry + validation.confidence
+//   response_profile=standard  ทุกอย่างยกเว้นข้อมูล debug (custom_prompts, template_match, ai_explanation, cost breakdown, steps)
+//   response_profile=full      response เดิม (ค่าเริ่มต้น)
+//   fields=receipt,accounting_entry,validation.confidence  เลือกเองด้วย path คั่นด้วยจุด
+// status, shopid และ metadata.request_id ส่งกลับเสมอ (ใช้ตรวจว่า response ตรงกับ request)
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response profiles (response_profile)
+const (
+	ResponseProfileMinimal  = "minimal"
+	ResponseProfileStandard = "standard"
+	ResponseProfileFull     = "full"
+)
+
+// responseAlwaysFields are kept whatever the selection
+var responseAlwaysFields = []string{"status", "shopid", "metadata.request_id"}
+
+// responseProfileFields are the fields kept by the minimal profile
+var responseProfileFields = map[string][]string{
+	ResponseProfileMinimal: {"receipt", "accounting_entry", "accounting_entries", "validation.confidence", "validation.requires_review"},
+}
+
+// responseStandardOmit are the fields removed by the standard profile (debug / explanation data)
+var responseStandardOmit = []string{
+	"custom_prompts",
+	"template_match",
+	"debug_data",
+	"validation.ai_explanation",
+	"metadata.cost_breakdown",
+	"metadata.steps",
+	"metadata.account_prefilter",
+	"metadata.creditor_prefilter",
+}
+
+// responseSelection is the parsed fields= / response_profile= of a request
+type responseSelection struct {
+	include []string // Dotted paths to keep (empty = everything)
+	omit    []string // Dotted paths to remove
+}
+
+// parseResponseSelection reads fields / response_profile (body first, then query)
+func parseResponseSelection(fields, profile string) (responseSelection, error) {
+	var selection responseSelection
+	if fields != "" && profile != "" {
+		return selection, fmt.Errorf("use either fields or response_profile, not both")
+	}
+	if fields != "" {
+		for _, field := range strings.Split(fields, ",") {
+			field = strings.Trim(strings.TrimSpace(field), ".")
+			if field == "" {
+				continue
+			}
+			selection.include = append(selection.include, field)
+		}
+		if len(selection.include) == 0 {
+			return selection, fmt.Errorf("fields is empty")
+		}
+		return selection, nil
+	}
+	switch profile {
+	case "", ResponseProfileFull:
+	case ResponseProfileMinimal:
+		selection.include = responseProfileFields[ResponseProfileMinimal]
+	case ResponseProfileStandard:
+		selection.omit = responseStandardOmit
+	default:
+		return selection, fmt.Errorf("response_profile must be %s, %s or %s", ResponseProfileMinimal, ResponseProfileStandard, ResponseProfileFull)
+	}
+	return selection, nil
+}
+
+// full reports whether the response is sent unchanged
+func (s responseSelection) full() bool {
+	return len(s.include) == 0 && len(s.omit) == 0
+}
+
+// apply returns the selected part of the response
+// The response is converted to plain JSON maps first so paths can reach into typed structs (metadata, template_info)
+func (s responseSelection) apply(response gin.H) (interface{}, error) {
+	if s.full() {
+		return response, nil
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // Keep amounts exactly as serialized (21905.96)
+	var document map[string]interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	if len(s.include) > 0 {
+		selected := map[string]interface{}{}
+		for _, path := range append(append([]string{}, responseAlwaysFields...), s.include...) {
+			copyResponsePath(document, selected, strings.Split(path, "."))
+		}
+		document = selected
+	}
+	for _, path := range s.omit {
+		deleteResponsePath(document, strings.Split(path, "."))
+	}
+	return document, nil
+}
+
+// copyResponsePath copies the value at path from src to dst (missing paths are skipped)
+func copyResponsePath(src, dst map[string]interface{}, path []string) {
+	value, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = value
+		return
+	}
+	child, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	target, ok := dst[path[0]].(map[string]interface{})
+	if !ok {
+		target = map[string]interface{}{}
+		dst[path[0]] = target
+	}
+	copyResponsePath(child, target, path[1:])
+}
+
+// deleteResponsePath removes the value at path
+func deleteResponsePath(document map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(document, path[0])
+		return
+	}
+	if child, ok := document[path[0]].(map[string]interface{}); ok {
+		deleteResponsePath(child, path[1:])
+	}
+}
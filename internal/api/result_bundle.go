@@ -0,0 +1,416 @@
+// result_bundle.go - Snapshot of one analyzed document for auditors (GET /api/v1/results/:request_id/bundle)
+//
+// zip 1 ไฟล์ต่อเอกสาร:
+//   summary.pdf    สรุปรายการบัญชี (ภาษาไทย) - ผลที่ approve แล้วใช้ voucher ที่ freeze ไว้
+//   voucher.pdf    ใบสำคัญลงบัญชี (voucher_pdf.go) - เมื่อมีผลวิเคราะห์
+//   result.json    ผลวิเคราะห์ที่เก็บไว้ (ocrResults) ทั้งก้อน
+//   ocr/image_N.txt ข้อความ OCR ของแต่ละรูป
+//   images/image_N.* รูปต้นฉบับ (ดาวน์โหลดใหม่จาก imageuri - ลิงก์หมดอายุ = ระบุใน manifest)
+//   manifest.json  รายการไฟล์ + SHA-256 สำหรับตรวจว่าไฟล์ไม่ถูกแก้ไข
+
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// thaiTime - times printed on documents (ICT, UTC+7)
+var thaiTime = time.FixedZone("ICT", 7*60*60)
+
+// BundleManifest is manifest.json of a result bundle
+type BundleManifest struct {
+	RequestID     string               `json:"request_id"`
+	ShopID        string               `json:"shopid"`
+	Status        string               `json:"status"` // draft / final
+	GeneratedAt   time.Time            `json:"generated_at"`
+	Files         []BundleManifestFile `json:"files"`
+	MissingImages []BundleMissingImage `json:"missing_images,omitempty"`
+}
+
+// BundleManifestFile is one file of the bundle
+type BundleManifestFile struct {
+	Name   string `json:"name"`
+	Bytes  int    `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// BundleMissingImage is an original image that could not be downloaded again
+type BundleMissingImage struct {
+	ImageIndex        int    `json:"image_index"`
+	DocumentImageGUID string `json:"documentimageguid,omitempty"`
+	ImageURI          string `json:"imageuri"`
+	Error             string `json:"error"`
+}
+
+// bundleFile is a file added to the zip
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+// ResultBundleHandler handles GET /api/v1/results/:request_id/bundle
+func ResultBundleHandler(c *gin.Context) {
+	requestID := c.Param("request_id")
+
+	// Step 1: Load the stored result (404 / 403 written by loadReanalysisRecord)
+	record := loadReanalysisRecord(c, requestID)
+	if record == nil {
+		return
+	}
+	status := record.Status
+	if status == "" {
+		status = storage.OCRResultStatusDraft
+	}
+	shopName := resultShopName(c, record.ShopID)
+
+	// Step 2: Render the summary and the journal voucher
+	summary, err := renderResultSummaryPDF(record, shopName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to render summary PDF",
+			"details": err.Error(),
+		})
+		return
+	}
+	resultJSON, _ := json.MarshalIndent(record, "", "  ")
+	files := []bundleFile{
+		{name: "summary.pdf", data: summary},
+		{name: "result.json", data: resultJSON},
+	}
+	if record.Analysis != nil || record.Approval != nil {
+		voucher, err := renderJournalVoucherPDF(record, shopName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to render journal voucher PDF",
+				"details": err.Error(),
+			})
+			return
+		}
+		files = append(files, bundleFile{name: "voucher.pdf", data: voucher})
+	}
+	for _, img := range record.Images {
+		files = append(files, bundleFile{name: fmt.Sprintf("ocr/image_%d.txt", img.ImageIndex+1), data: []byte(img.RawText)})
+	}
+
+	// Step 3: Download the original images again
+	manifest := BundleManifest{
+		RequestID:   record.RequestID,
+		ShopID:      record.ShopID,
+		Status:      status,
+		GeneratedAt: time.Now(),
+	}
+	for _, img := range record.Images {
+		data, ext, err := downloadBundleImage(c.Request.Context(), img.ImageURI)
+		if err != nil {
+			log.Printf("⚠️  Bundle %s: image %d not available: %v", record.RequestID, img.ImageIndex+1, err)
+			manifest.MissingImages = append(manifest.MissingImages, BundleMissingImage{
+				ImageIndex:        img.ImageIndex,
+				DocumentImageGUID: img.DocumentImageGUID,
+				ImageURI:          img.ImageURI,
+				Error:             err.Error(),
+			})
+			continue
+		}
+		files = append(files, bundleFile{name: fmt.Sprintf("images/image_%d%s", img.ImageIndex+1, ext), data: data})
+	}
+	for _, file := range files {
+		sum := sha256.Sum256(file.data)
+		manifest.Files = append(manifest.Files, BundleManifestFile{Name: file.name, Bytes: len(file.data), SHA256: hex.EncodeToString(sum[:])})
+	}
+	manifestJSON, _ := json.MarshalIndent(manifest, "", "  ")
+	files = append(files, bundleFile{name: "manifest.json", data: manifestJSON})
+
+	// Step 4: Stream the zip
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="result-%s.zip"`, record.RequestID))
+	c.Status(http.StatusOK)
+	zw := zip.NewWriter(c.Writer)
+	for _, file := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: manifest.GeneratedAt})
+		if err != nil {
+			log.Printf("⚠️  Bundle %s: %v", record.RequestID, err)
+			return
+		}
+		if _, err := w.Write(file.data); err != nil {
+			log.Printf("⚠️  Bundle %s: %v", record.RequestID, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("⚠️  Bundle %s: %v", record.RequestID, err)
+	}
+}
+
+// resultShopName returns the company name printed on documents (shopid when the shop profile has none)
+func resultShopName(c *gin.Context, shopID string) string {
+	masterCache, err := dataStore.GetOrLoadMasterData(c.Request.Context(), shopID)
+	if err != nil {
+		return shopID
+	}
+	if name := masterCache.ShopProfile.GetCompanyName(); name != "" {
+		return name
+	}
+	return shopID
+}
+
+// downloadBundleImage downloads an original image (download phase deadline) and returns its content and extension
+func downloadBundleImage(ctx context.Context, uri string) ([]byte, string, error) {
+	if uri == "" {
+		return nil, "", fmt.Errorf("imageuri not stored")
+	}
+	ctx, cancel := phaseContext(ctx, phaseDownload)
+	defer cancel()
+	filename := filepath.Join(configs.UPLOAD_DIR, "bundle_"+uuid.New().String())
+	defer os.Remove(filename)
+	ext, err := httpFileDownloader{}.Download(ctx, uri, filename)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, ext, nil
+}
+
+// renderResultSummaryPDF renders the journal entry of a stored result (Thai layout)
+func renderResultSummaryPDF(record *storage.StoredOCRResult, shopName string) ([]byte, error) {
+	doc, err := newPDFDocument()
+	if err != nil {
+		return nil, err
+	}
+	doc.addPage()
+	right := pdfPageWidth - pdfMargin
+
+	// Header
+	y := pdfMargin + 16
+	doc.text(pdfMargin, y, 16, true, "สรุปผลการวิเคราะห์เอกสาร")
+	statusText := "ร่าง (ยังไม่อนุมัติ)"
+	if record.Status == storage.OCRResultStatusFinal {
+		statusText = "อนุมัติแล้ว"
+	}
+	doc.textRight(right, y, 11, true, "สถานะ: "+statusText)
+	y += 20
+	doc.text(pdfMargin, y, 12, true, shopName)
+	y += 10
+	doc.line(pdfMargin, y, right, y, 0.8)
+	y += 18
+
+	// Document fields (two columns)
+	fields := resultSummaryFields(record)
+	column := (right - pdfMargin) / 2
+	for i := 0; i < len(fields); i += 2 {
+		for j := 0; j < 2 && i+j < len(fields); j++ {
+			x := pdfMargin + float64(j)*column
+			doc.text(x, y, 9, true, fields[i+j][0])
+			doc.text(x+95, y, 9, false, fields[i+j][1])
+		}
+		y += 15
+	}
+	y += 8
+
+	// Entries table
+	lines, totalDebit, totalCredit := resultSummaryLines(record)
+	columns := []float64{pdfMargin, pdfMargin + 28, pdfMargin + 95, right - 180, right - 90, right}
+	drawHeader := func() {
+		doc.rect(pdfMargin, y, right-pdfMargin, 18, 0.5, 0.9)
+		for k, title := range []string{"ลำดับ", "รหัสบัญชี", "ชื่อบัญชี / คำอธิบาย", "เดบิต", "เครดิต"} {
+			if k >= 3 {
+				doc.textRight(columns[k+1]-4, y+13, 9, true, title)
+			} else {
+				doc.text(columns[k]+4, y+13, 9, true, title)
+			}
+		}
+		y += 18
+	}
+	drawHeader()
+	if len(lines) == 0 {
+		y += 14
+		doc.text(pdfMargin+4, y, 9, false, "ยังไม่มีผลวิเคราะห์บัญชี (การวิเคราะห์ไม่สำเร็จ)")
+		y += 8
+	}
+	for i, line := range lines {
+		nameWidth := columns[3] - columns[2] - 8
+		nameLines := doc.wrap(line.AccountName, 9, false, nameWidth)
+		var descriptionLines []string
+		if line.Description != "" {
+			descriptionLines = doc.wrap(line.Description, 8, false, nameWidth)
+		}
+		height := float64(len(nameLines))*12 + float64(len(descriptionLines))*11 + 6
+		if y+height > pdfPageHeight-pdfMargin-40 {
+			doc.addPage()
+			y = pdfMargin
+			drawHeader()
+		}
+		rowTop := y
+		y += 13
+		doc.text(columns[0]+4, y, 9, false, fmt.Sprintf("%d", i+1))
+		doc.text(columns[1]+4, y, 9, false, line.AccountCode)
+		if line.Debit != 0 {
+			doc.textRight(columns[4]-4, y, 9, false, money.FromBaht(line.Debit).Thousands())
+		}
+		if line.Credit != 0 {
+			doc.textRight(columns[5]-4, y, 9, false, money.FromBaht(line.Credit).Thousands())
+		}
+		for k, text := range nameLines {
+			doc.text(columns[2]+4, y+float64(k)*12, 9, false, text)
+		}
+		y += float64(len(nameLines)-1) * 12
+		for _, text := range descriptionLines {
+			y += 11
+			doc.text(columns[2]+4, y, 8, false, text)
+		}
+		y = rowTop + height
+		doc.line(pdfMargin, y, right, y, 0.3)
+	}
+
+	// Totals
+	y += 15
+	doc.text(columns[2]+4, y, 9, true, "รวม")
+	doc.textRight(columns[4]-4, y, 9, true, totalDebit.Thousands())
+	doc.textRight(columns[5]-4, y, 9, true, totalCredit.Thousands())
+	y += 5
+	doc.line(columns[3], y, right, y, 0.5)
+	doc.line(columns[3], y+2, right, y+2, 0.5)
+	if totalDebit != totalCredit {
+		y += 16
+		doc.text(pdfMargin, y, 9, true, fmt.Sprintf("⚠ เดบิตไม่เท่ากับเครดิต (ต่างกัน %s)", (totalDebit-totalCredit).Abs().Thousands()))
+	}
+
+	// Approval
+	if approval := record.Approval; approval != nil {
+		y += 26
+		approvedBy := approval.ApprovedBy
+		if approvedBy == "" {
+			approvedBy = "-"
+		}
+		doc.text(pdfMargin, y, 9, true, "อนุมัติโดย")
+		doc.text(pdfMargin+95, y, 9, false, fmt.Sprintf("%s เมื่อ %s", approvedBy, approval.ApprovedAt.In(thaiTime).Format("02/01/2006 15:04")))
+		if len(approval.Corrections) > 0 {
+			y += 14
+			doc.text(pdfMargin, y, 9, true, "แก้ไขก่อนอนุมัติ")
+			doc.text(pdfMargin+95, y, 9, false, strings.Join(approval.Corrections, ", "))
+		}
+		if approval.Note != "" {
+			for k, text := range doc.wrap(approval.Note, 9, false, right-pdfMargin-95) {
+				y += 14
+				if k == 0 {
+					doc.text(pdfMargin, y, 9, true, "หมายเหตุ")
+				}
+				doc.text(pdfMargin+95, y, 9, false, text)
+			}
+		}
+	}
+
+	// Footer on every page
+	generated := time.Now().In(thaiTime).Format("02/01/2006 15:04")
+	for i, page := range doc.pages {
+		doc.page = page
+		doc.text(pdfMargin, pdfPageHeight-pdfMargin+10, 7, false, fmt.Sprintf("Request ID %s · สร้างเมื่อ %s", record.RequestID, generated))
+		doc.textRight(right, pdfPageHeight-pdfMargin+10, 7, false, fmt.Sprintf("หน้า %d/%d", i+1, len(doc.pages)))
+	}
+	return doc.bytes("สรุปผลการวิเคราะห์ " + record.RequestID), nil
+}
+
+// resultSummaryFields returns the label / value pairs of the summary header
+func resultSummaryFields(record *storage.StoredOCRResult) [][2]string {
+	var summary storage.OCRDocumentSummary
+	if record.Summary != nil {
+		summary = *record.Summary
+	}
+	analysis := record.Analysis
+	if analysis == nil {
+		analysis = &storage.OCRAnalysisSnapshot{}
+	}
+	documentDate := analysis.DocumentDate
+	if documentDate == "" {
+		documentDate = summary.DocumentDate
+	}
+	journalBook := strings.TrimSpace(analysis.JournalBookCode + " " + analysis.JournalBookName)
+	counterparty := strings.TrimSpace(analysis.CreditorCode + " " + analysis.CreditorName)
+	if counterparty == "" {
+		counterparty = strings.TrimSpace(analysis.DebtorCode + " " + analysis.DebtorName)
+	}
+	review := "ไม่ต้อง"
+	if analysis.RequiresReview {
+		review = "ต้องตรวจสอบ"
+	}
+	confidence := "-"
+	if analysis.ConfidenceLevel != "" {
+		confidence = fmt.Sprintf("%.0f%% (%s)", analysis.ConfidenceScore, analysis.ConfidenceLevel)
+	}
+	return [][2]string{
+		{"เลขที่เอกสาร", orDash(summary.DocumentNumber)},
+		{"วันที่เอกสาร", orDash(thaiDate(documentDate))},
+		{"ผู้ขาย / ผู้ให้บริการ", orDash(summary.VendorName)},
+		{"เลขประจำตัวผู้เสียภาษี", orDash(summary.VendorTaxID)},
+		{"ยอดรวมเอกสาร", money.FromBaht(summary.Total).Thousands()},
+		{"สมุดรายวัน", orDash(journalBook)},
+		{"เจ้าหนี้ / ลูกหนี้", orDash(counterparty)},
+		{"Template", orDash(analysis.TemplateName)},
+		{"ความมั่นใจ", confidence},
+		{"การตรวจสอบ", review},
+		{"Request ID", record.RequestID},
+		{"วิเคราะห์เมื่อ", record.CreatedAt.In(thaiTime).Format("02/01/2006 15:04")},
+	}
+}
+
+// resultSummaryLines returns the journal lines (frozen voucher when approved) and their totals
+func resultSummaryLines(record *storage.StoredOCRResult) ([]storage.JournalVoucherLine, money.Amount, money.Amount) {
+	var lines []storage.JournalVoucherLine
+	switch {
+	case record.Approval != nil:
+		lines = record.Approval.Voucher.Lines
+	case record.Analysis != nil:
+		for i, entry := range record.Analysis.Entries {
+			lines = append(lines, storage.JournalVoucherLine{
+				LineNo:      i + 1,
+				AccountCode: entry.AccountCode,
+				AccountName: entry.AccountName,
+				Debit:       entry.Debit,
+				Credit:      entry.Credit,
+				Description: entry.Description,
+			})
+		}
+	}
+	var debit, credit money.Amount
+	for _, line := range lines {
+		debit += money.FromBaht(line.Debit)
+		credit += money.FromBaht(line.Credit)
+	}
+	return lines, debit, credit
+}
+
+// thaiDate formats YYYY-MM-DD as DD/MM/YYYY in the Buddhist era (2024-06-15 → 15/06/2567)
+func thaiDate(date string) string {
+	parsed, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	return fmt.Sprintf("%02d/%02d/%d", parsed.Day(), parsed.Month(), parsed.Year()+543)
+}
+
+// orDash prints "-" for empty values
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,415 @@
+// result_compare.go - Compare two analyses of the same document (e.g. original vs re-analysis with another model)
+//
+// ใช้ผลวิเคราะห์ที่เก็บไว้คู่กับข้อความ OCR (ocrResults, OCR_RESULT_TTL_DAYS) + ค่าใช้จ่ายจาก usageLedger
+// คืนเฉพาะส่วนที่ต่างกัน: ฟิลด์ใบเสร็จ, รายการบัญชี (debit/credit ต่อบัญชี), ปัจจัย confidence และค่าใช้จ่าย
+
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// ResultCompareResponse is the diff of analysis B against analysis A
+type ResultCompareResponse struct {
+	ShopID       string             `json:"shopid"`
+	A            ResultCompareSide  `json:"a"`
+	B            ResultCompareSide  `json:"b"`
+	SameDocument bool               `json:"same_document"` // One is a re-analysis of the other, or both of the same request
+	Identical    bool               `json:"identical"`     // No difference in receipt fields, entries and confidence factors
+	Receipt      []ReceiptFieldDiff `json:"receipt"`       // Changed receipt fields only
+	Entries      []EntryDiff        `json:"entries"`       // Changed accounts only
+	Confidence   ConfidenceCompare  `json:"confidence"`
+	Cost         *CostCompare       `json:"cost,omitempty"` // nil = neither request is in the usage ledger
+}
+
+// ResultCompareSide identifies one of the compared analyses
+type ResultCompareSide struct {
+	RequestID       string    `json:"request_id"`
+	ParentRequestID string    `json:"parent_request_id,omitempty"`
+	OCRProvider     string    `json:"ocr_provider"`
+	TemplateName    string    `json:"template_name,omitempty"`
+	AnalyzedAt      time.Time `json:"analyzed_at"`
+}
+
+// ReceiptFieldDiff is a receipt field with a different value (nil = field missing on that side)
+type ReceiptFieldDiff struct {
+	Field string      `json:"field"`
+	A     interface{} `json:"a"`
+	B     interface{} `json:"b"`
+}
+
+// EntryAmounts is the debit/credit total of one account in an analysis
+type EntryAmounts struct {
+	Debit  money.Amount `json:"debit"`
+	Credit money.Amount `json:"credit"`
+}
+
+// EntryDiff is an account whose amounts differ between the analyses
+type EntryDiff struct {
+	AccountCode string        `json:"account_code"`
+	AccountName string        `json:"account_name"`
+	Change      string        `json:"change"` // "added" (only in B), "removed" (only in A) or "changed"
+	A           *EntryAmounts `json:"a,omitempty"`
+	B           *EntryAmounts `json:"b,omitempty"`
+	DebitDelta  money.Amount  `json:"debit_delta"` // B - A
+	CreditDelta money.Amount  `json:"credit_delta"`
+}
+
+// ConfidenceCompare compares the weighted confidence of both analyses
+type ConfidenceCompare struct {
+	ScoreA          float64      `json:"score_a"`
+	ScoreB          float64      `json:"score_b"`
+	ScoreDelta      float64      `json:"score_delta"` // B - A
+	LevelA          string       `json:"level_a"`
+	LevelB          string       `json:"level_b"`
+	RequiresReviewA bool         `json:"requires_review_a"`
+	RequiresReviewB bool         `json:"requires_review_b"`
+	Factors         []FactorDiff `json:"factors"` // Every factor (template_match, party_match, ...)
+}
+
+// FactorDiff is one confidence factor of both analyses
+type FactorDiff struct {
+	Factor string  `json:"factor"`
+	A      float64 `json:"a"`
+	B      float64 `json:"b"`
+	Delta  float64 `json:"delta"` // B - A
+}
+
+// CostCompare compares the billed cost of both requests (nil side = not in the usage ledger)
+type CostCompare struct {
+	A            *storage.UsageLedgerEntry `json:"a,omitempty"`
+	B            *storage.UsageLedgerEntry `json:"b,omitempty"`
+	TokensDelta  int                       `json:"tokens_delta"` // B - A
+	CostUSDDelta float64                   `json:"cost_usd_delta"`
+	CostTHBDelta float64                   `json:"cost_thb_delta"`
+}
+
+// ocrAnalysisSnapshot keeps the parts of an analysis used by GET /results/compare and POST /results/:request_id/approve
+func ocrAnalysisSnapshot(receipt, accountingEntry map[string]interface{}, templateName string, confidence processor.ConfidenceResult, requiresReview bool) *storage.OCRAnalysisSnapshot {
+	snapshot := &storage.OCRAnalysisSnapshot{
+		Receipt:         map[string]interface{}{},
+		Entries:         []storage.OCRAnalysisEntry{},
+		TemplateName:    templateName,
+		ConfidenceScore: confidence.OverallScore,
+		ConfidenceLevel: confidence.OverallLevel,
+		ConfidenceFactors: map[string]float64{
+			"template_match":     confidence.Factors.TemplateMatch,
+			"party_match":        confidence.Factors.PartyMatch,
+			"data_completeness":  confidence.Factors.DataCompleteness,
+			"field_validation":   confidence.Factors.FieldValidation,
+			"balance_validation": confidence.Factors.BalanceValidation,
+		},
+		RequiresReview:  requiresReview,
+		DocumentDate:    getStringValue(accountingEntry, "document_date"),
+		ReferenceNumber: getStringValue(accountingEntry, "reference_number"),
+		JournalBookCode: getStringValue(accountingEntry, "journal_book_code"),
+		JournalBookName: getStringValue(accountingEntry, "journal_book_name"),
+		CreditorCode:    getStringValue(accountingEntry, "creditor_code"),
+		CreditorName:    getStringValue(accountingEntry, "creditor_name"),
+		CreditorBranch:  getStringValue(accountingEntry, "creditor_branch_code"),
+		DebtorCode:      getStringValue(accountingEntry, "debtor_code"),
+		DebtorName:      getStringValue(accountingEntry, "debtor_name"),
+	}
+	// Scalar fields only - items / nested objects are compared through the entries
+	for key, value := range receipt {
+		switch value.(type) {
+		case string, float64, int, bool:
+			snapshot.Receipt[key] = value
+		}
+	}
+	if customFields, ok := receipt["custom_fields"].(map[string]interface{}); ok && len(customFields) > 0 {
+		snapshot.CustomFields = customFields
+	}
+	entriesRaw, _ := accountingEntry["entries"].([]interface{})
+	for _, e := range entriesRaw {
+		entryMap, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		snapshot.Entries = append(snapshot.Entries, storage.OCRAnalysisEntry{
+			AccountCode: getStringValue(entryMap, "account_code"),
+			AccountName: getStringValue(entryMap, "account_name"),
+			Debit:       getFloatValue(entryMap, "debit"),
+			Credit:      getFloatValue(entryMap, "credit"),
+			Description: getStringValue(entryMap, "description"),
+			Dimensions:  entryDimensions(entryMap),
+		})
+	}
+	return snapshot
+}
+
+// entryDimensions reads entries[].dimensions set by assignDimensions (nil = none)
+func entryDimensions(entryMap map[string]interface{}) map[string]string {
+	raw, _ := entryMap["dimensions"].(map[string]interface{})
+	if len(raw) == 0 {
+		return nil
+	}
+	dimensions := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if code, ok := value.(string); ok && code != "" {
+			dimensions[key] = code
+		}
+	}
+	return dimensions
+}
+
+// diffReceiptFields returns the receipt fields with a different value, sorted by field name
+func diffReceiptFields(a, b map[string]interface{}) []ReceiptFieldDiff {
+	fields := map[string]bool{}
+	for key := range a {
+		fields[key] = true
+	}
+	for key := range b {
+		fields[key] = true
+	}
+	diffs := []ReceiptFieldDiff{}
+	for field := range fields {
+		valueA, valueB := a[field], b[field]
+		if receiptValuesEqual(valueA, valueB) {
+			continue
+		}
+		diffs = append(diffs, ReceiptFieldDiff{Field: field, A: valueA, B: valueB})
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Field < diffs[j].Field })
+	return diffs
+}
+
+// receiptValuesEqual compares numbers to the satang and strings ignoring surrounding spaces
+func receiptValuesEqual(a, b interface{}) bool {
+	numberA, okA := compareNumber(a)
+	numberB, okB := compareNumber(b)
+	if okA && okB {
+		return numberA == numberB
+	}
+	textA, okA := a.(string)
+	textB, okB := b.(string)
+	if okA && okB {
+		return strings.TrimSpace(textA) == strings.TrimSpace(textB)
+	}
+	return a == b
+}
+
+// compareNumber returns the amount of a numeric receipt field (BSON decodes whole numbers as int32/int64)
+// Text is compared as text - "001" and "1" are different document numbers
+func compareNumber(v interface{}) (money.Amount, bool) {
+	switch v.(type) {
+	case float64, int, int32, int64:
+		return money.Parse(v)
+	}
+	return 0, false
+}
+
+// diffEntries totals debit/credit per account on each side and returns the accounts that differ (by account code)
+func diffEntries(a, b []storage.OCRAnalysisEntry) []EntryDiff {
+	totalsA, namesA := entryTotals(a)
+	totalsB, namesB := entryTotals(b)
+
+	codes := map[string]bool{}
+	for code := range totalsA {
+		codes[code] = true
+	}
+	for code := range totalsB {
+		codes[code] = true
+	}
+
+	diffs := []EntryDiff{}
+	for code := range codes {
+		amountsA, inA := totalsA[code]
+		amountsB, inB := totalsB[code]
+		diff := EntryDiff{AccountCode: code, AccountName: namesB[code]}
+		if diff.AccountName == "" {
+			diff.AccountName = namesA[code]
+		}
+		switch {
+		case !inA:
+			diff.Change = "added"
+			diff.B = amountsB
+			diff.DebitDelta, diff.CreditDelta = amountsB.Debit, amountsB.Credit
+		case !inB:
+			diff.Change = "removed"
+			diff.A = amountsA
+			diff.DebitDelta, diff.CreditDelta = 0-amountsA.Debit, 0-amountsA.Credit
+		default:
+			diff.DebitDelta = amountsB.Debit - amountsA.Debit
+			diff.CreditDelta = amountsB.Credit - amountsA.Credit
+			if diff.DebitDelta == 0 && diff.CreditDelta == 0 {
+				continue
+			}
+			diff.Change = "changed"
+			diff.A, diff.B = amountsA, amountsB
+		}
+		diffs = append(diffs, diff)
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].AccountCode < diffs[j].AccountCode })
+	return diffs
+}
+
+// entryTotals sums the entries of an analysis per account code (an account can appear on several lines)
+func entryTotals(entries []storage.OCRAnalysisEntry) (map[string]*EntryAmounts, map[string]string) {
+	totals := map[string]*EntryAmounts{}
+	names := map[string]string{}
+	for _, entry := range entries {
+		amounts, ok := totals[entry.AccountCode]
+		if !ok {
+			amounts = &EntryAmounts{}
+			totals[entry.AccountCode] = amounts
+		}
+		amounts.Debit += money.FromBaht(entry.Debit)
+		amounts.Credit += money.FromBaht(entry.Credit)
+		if names[entry.AccountCode] == "" {
+			names[entry.AccountCode] = entry.AccountName
+		}
+	}
+	return totals, names
+}
+
+// compareConfidence lists every confidence factor of both analyses (sorted by factor name)
+func compareConfidence(a, b *storage.OCRAnalysisSnapshot) ConfidenceCompare {
+	result := ConfidenceCompare{
+		ScoreA:          a.ConfidenceScore,
+		ScoreB:          b.ConfidenceScore,
+		ScoreDelta:      b.ConfidenceScore - a.ConfidenceScore,
+		LevelA:          a.ConfidenceLevel,
+		LevelB:          b.ConfidenceLevel,
+		RequiresReviewA: a.RequiresReview,
+		RequiresReviewB: b.RequiresReview,
+		Factors:         []FactorDiff{},
+	}
+	factors := map[string]bool{}
+	for factor := range a.ConfidenceFactors {
+		factors[factor] = true
+	}
+	for factor := range b.ConfidenceFactors {
+		factors[factor] = true
+	}
+	for factor := range factors {
+		scoreA, scoreB := a.ConfidenceFactors[factor], b.ConfidenceFactors[factor]
+		result.Factors = append(result.Factors, FactorDiff{Factor: factor, A: scoreA, B: scoreB, Delta: scoreB - scoreA})
+	}
+	sort.Slice(result.Factors, func(i, j int) bool { return result.Factors[i].Factor < result.Factors[j].Factor })
+	return result
+}
+
+// compareSide returns the identity of one compared analysis
+func compareSide(record *storage.StoredOCRResult) ResultCompareSide {
+	return ResultCompareSide{
+		RequestID:       record.RequestID,
+		ParentRequestID: record.ParentRequestID,
+		OCRProvider:     record.OCRProvider,
+		TemplateName:    record.Analysis.TemplateName,
+		AnalyzedAt:      record.CreatedAt,
+	}
+}
+
+// CompareResultsHandler handles GET /api/v1/results/compare?a=REQ1&b=REQ2
+// Both requests must belong to the same shop and have a stored analysis (OCR_RESULT_TTL_DAYS > 0)
+func CompareResultsHandler(c *gin.Context) {
+	requestA, requestB := strings.TrimSpace(c.Query("a")), strings.TrimSpace(c.Query("b"))
+	if requestA == "" || requestB == "" || requestA == requestB {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid request ids",
+			"message": "ต้องระบุ a และ b เป็น request_id สองรายการที่ต่างกัน",
+		})
+		return
+	}
+	if configs.OCR_RESULT_TTL_DAYS <= 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "comparison disabled",
+			"message": "OCR result storage is disabled (set OCR_RESULT_TTL_DAYS > 0)",
+		})
+		return
+	}
+
+	// Step 1: Load both stored analyses
+	records := make([]*storage.StoredOCRResult, 0, 2)
+	for _, requestID := range []string{requestA, requestB} {
+		record, err := dataStore.GetOCRResult(c.Request.Context(), requestID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":      "Failed to load analysis",
+				"details":    err.Error(),
+				"request_id": requestID,
+			})
+			return
+		}
+		if record == nil || record.Analysis == nil {
+			message := "ไม่พบผลวิเคราะห์ของ request นี้ (อาจหมดอายุแล้ว, วิเคราะห์ไม่สำเร็จ หรือ request_id ไม่ถูกต้อง)"
+			c.JSON(http.StatusNotFound, gin.H{
+				"error":      "analysis not found",
+				"message":    message,
+				"request_id": requestID,
+			})
+			return
+		}
+		if rejectForeignShop(c, record.ShopID) {
+			return
+		}
+		records = append(records, record)
+	}
+	a, b := records[0], records[1]
+	if a.ShopID != b.ShopID {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "different shops",
+			"message": "เปรียบเทียบได้เฉพาะผลวิเคราะห์ของร้านเดียวกัน",
+		})
+		return
+	}
+
+	// Step 2: Billed cost of each request (usageLedger)
+	var costs [2]*storage.UsageLedgerEntry
+	for i, requestID := range []string{requestA, requestB} {
+		entry, err := storage.GetUsageLedgerEntry(c.Request.Context(), requestID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":      "Failed to load usage ledger",
+				"details":    err.Error(),
+				"request_id": requestID,
+			})
+			return
+		}
+		costs[i] = entry
+	}
+
+	// Step 3: Diff
+	response := ResultCompareResponse{
+		ShopID: a.ShopID,
+		A:      compareSide(a),
+		B:      compareSide(b),
+		SameDocument: a.ParentRequestID == b.RequestID || b.ParentRequestID == a.RequestID ||
+			(a.ParentRequestID != "" && a.ParentRequestID == b.ParentRequestID),
+		Receipt:    diffReceiptFields(a.Analysis.Receipt, b.Analysis.Receipt),
+		Entries:    diffEntries(a.Analysis.Entries, b.Analysis.Entries),
+		Confidence: compareConfidence(a.Analysis, b.Analysis),
+	}
+	response.Identical = len(response.Receipt) == 0 && len(response.Entries) == 0
+	for _, factor := range response.Confidence.Factors {
+		if factor.Delta != 0 {
+			response.Identical = false
+		}
+	}
+	if costs[0] != nil || costs[1] != nil {
+		cost := &CostCompare{A: costs[0], B: costs[1]}
+		if costs[0] != nil {
+			cost.TokensDelta -= costs[0].TotalTokens
+			cost.CostUSDDelta -= costs[0].CostUSD
+			cost.CostTHBDelta -= costs[0].CostTHB
+		}
+		if costs[1] != nil {
+			cost.TokensDelta += costs[1].TotalTokens
+			cost.CostUSDDelta += costs[1].CostUSD
+			cost.CostTHBDelta += costs[1].CostTHB
+		}
+		response.Cost = cost
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,158 @@
+// retention.go - Data retention (PDPA): scheduled purge + per-shop purge API
+//
+// ข้อมูลเอกสารที่เก็บไว้ (OCR text, AI traces, dead-letter, drafts, analytics) ถูกลบเมื่อเก่ากว่า
+// DATA_RETENTION_DAYS (หรือ retention_days ของร้านใน shopSettings)
+// ร้านขอลบข้อมูลทั้งหมดได้ทันทีผ่าน DELETE /api/v1/shops/:shopid/results
+
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// staleUploadAge is how long a file may stay in UPLOAD_DIR - requests remove their own files,
+// anything older was left behind by a crash
+const staleUploadAge = time.Hour
+
+// PurgeShopResultsResponse is the response of DELETE /api/v1/shops/:shopid/results
+type PurgeShopResultsResponse struct {
+	ShopID       string           `json:"shopid"`
+	Before       string           `json:"before"`  // Data created before this time was deleted
+	Deleted      map[string]int64 `json:"deleted"` // Deleted documents per collection
+	TotalDeleted int64            `json:"total_deleted"`
+}
+
+// StartRetentionPurger runs the retention purge now and every RETENTION_PURGE_INTERVAL_MIN
+func StartRetentionPurger() {
+	if configs.RETENTION_PURGE_INTERVAL_MIN <= 0 {
+		return
+	}
+
+	go func() {
+		runRetentionPurge()
+		ticker := time.NewTicker(time.Duration(configs.RETENTION_PURGE_INTERVAL_MIN) * time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			runRetentionPurge()
+		}
+	}()
+}
+
+// runRetentionPurge deletes expired data of every shop (global window + per-shop overrides)
+func runRetentionPurge() {
+	ctx := context.Background()
+	removeStaleUploads()
+
+	// Step 1: Shops with their own window
+	overrides, err := storage.GetShopRetentionOverrides(ctx)
+	if err != nil {
+		log.Printf("⚠️  Retention purge skipped: %v", err)
+		return
+	}
+	now := time.Now()
+	overriddenShops := make([]string, 0, len(overrides))
+	for _, shop := range overrides {
+		overriddenShops = append(overriddenShops, shop.ShopID)
+		deleted, err := storage.PurgeShopData(ctx, documentDatabases(), shop.ShopID, now.AddDate(0, 0, -shop.RetentionDays))
+		if err != nil {
+			log.Printf("⚠️  Retention purge failed for shop %s: %v", shop.ShopID, err)
+			continue
+		}
+		logPurged("shop "+shop.ShopID, deleted)
+	}
+
+	// Step 2: Every other shop with the global window (0 = collection TTLs only)
+	if configs.DATA_RETENTION_DAYS <= 0 {
+		return
+	}
+	deleted, err := storage.PurgeExpiredData(ctx, documentDatabases(), now.AddDate(0, 0, -configs.DATA_RETENTION_DAYS), overriddenShops)
+	if err != nil {
+		log.Printf("⚠️  Retention purge failed: %v", err)
+		return
+	}
+	logPurged("all shops", deleted)
+}
+
+// removeStaleUploads deletes document files left in UPLOAD_DIR by crashed requests
+func removeStaleUploads() {
+	entries, err := os.ReadDir(configs.UPLOAD_DIR)
+	if err != nil {
+		log.Printf("⚠️  Failed to read upload directory: %v", err)
+		return
+	}
+	removed := 0
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || time.Since(info.ModTime()) < staleUploadAge {
+			continue
+		}
+		if os.Remove(filepath.Join(configs.UPLOAD_DIR, entry.Name())) == nil {
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("🧹 Removed %d stale upload file(s) from %s", removed, configs.UPLOAD_DIR)
+	}
+}
+
+// logPurged logs the purge result when anything was deleted
+func logPurged(scope string, deleted map[string]int64) {
+	if total := totalDeleted(deleted); total > 0 {
+		log.Printf("🧹 Retention purge (%s): deleted %d document(s) %v", scope, total, deleted)
+	}
+}
+
+// totalDeleted sums the deleted documents of every collection
+func totalDeleted(deleted map[string]int64) int64 {
+	var total int64
+	for _, count := range deleted {
+		total += count
+	}
+	return total
+}
+
+// PurgeShopResultsHandler handles DELETE /api/v1/shops/:shopid/results
+// ?before=YYYY-MM-DD deletes only data created before that day (default = everything)
+func PurgeShopResultsHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	before := time.Now()
+	if v := c.Query("before"); v != "" {
+		day, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid before",
+				"message": "before ต้องอยู่ในรูปแบบ YYYY-MM-DD",
+			})
+			return
+		}
+		before = day
+	}
+
+	deleted, err := storage.PurgeShopData(c.Request.Context(), documentDatabases(), shopID, before)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to purge shop data",
+			"details": err.Error(),
+			"deleted": deleted,
+		})
+		return
+	}
+	log.Printf("🧹 Purged data of shop %s created before %s: %v", shopID, before.Format(time.RFC3339), deleted)
+
+	c.JSON(http.StatusOK, PurgeShopResultsResponse{
+		ShopID:       shopID,
+		Before:       before.Format(time.RFC3339),
+		Deleted:      deleted,
+		TotalDeleted: totalDeleted(deleted),
+	})
+}
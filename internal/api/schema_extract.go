@@ -0,0 +1,154 @@
+// schema_extract.go - Extract caller-defined fields from a document (general document-extraction API)
+//
+// ผู้เรียกส่งรูป + รายการฟิลด์ (ชื่อ, ชนิด, คำอธิบาย) → Gemini อ่านตาม ResponseSchema ที่สร้างจากฟิลด์
+// ไม่ใช้ master data / accounting - ให้ module อื่นดึงข้อมูลจากเอกสารชนิดใดก็ได้ (สัญญา, ใบขน, ฯลฯ)
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/gin-gonic/gin"
+)
+
+// SchemaExtractRequest is the body of POST /api/v1/extract
+// multipart/form-data: send fields as a JSON string in the "fields" form field
+type SchemaExtractRequest struct {
+	StandaloneDocumentRequest
+	Fields       []ai.ExtractionField `json:"fields" form:"-"`
+	Instructions string               `json:"instructions,omitempty" form:"instructions"` // Extra guidance for the model
+}
+
+// SchemaExtractResponse is the response of POST /api/v1/extract
+type SchemaExtractResponse struct {
+	RequestID         string                     `json:"request_id"`
+	DocumentImageGUID string                     `json:"documentimageguid,omitempty"`
+	Values            map[string]interface{}     `json:"values"` // Every requested field (null = not found on the document)
+	Model             string                     `json:"model"`  // Gemini model that read the document
+	ProcessingTimeMs  int64                      `json:"processing_time_ms"`
+	TokenUsage        common.TokenUsage          `json:"token_usage"`
+	ImageReductions   []common.ImageReduction    `json:"image_reductions,omitempty"`   // Images shrunk before the Gemini call
+	FileScans         []common.FileScan          `json:"file_scans,omitempty"`         // Malware scan of the file (FILE_SCAN_DRIVER)
+	ImageSanitization []common.ImageSanitization `json:"image_sanitization,omitempty"` // Metadata stripped from the image (STRIP_IMAGE_METADATA)
+	Steps             []common.StepLog           `json:"steps"`                        // Per-step timings and tokens / cost
+}
+
+// SchemaExtractHandler handles POST /api/v1/extract
+func SchemaExtractHandler(c *gin.Context) {
+	// Step 1: Parse request (multipart file or JSON imageuri) + the caller's fields
+	var req SchemaExtractRequest
+	if !bindStandaloneRequest(c, &req, &req.StandaloneDocumentRequest) {
+		return
+	}
+	if isMultipartRequest(c) {
+		if raw := c.PostForm("fields"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &req.Fields); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":   "Invalid fields JSON",
+					"details": err.Error(),
+				})
+				return
+			}
+		}
+	}
+	if err := ai.ValidateExtractionFields(req.Fields); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid fields",
+			"details": err.Error(),
+			"example": []gin.H{
+				{"name": "contract_number", "type": "string", "description": "เลขที่สัญญา"},
+				{"name": "amount", "type": "number", "description": "มูลค่าสัญญา"},
+				{"name": "items", "type": "array", "items": gin.H{"type": "object", "fields": []gin.H{{"name": "name", "type": "string"}}}},
+			},
+		})
+		return
+	}
+
+	// ResponseSchema is a Gemini feature - Mistral OCR cannot extract into a schema
+	if req.Model != "gemini" {
+		if ai.IsProviderDisabled("gemini") {
+			c.Header("Retry-After", "60")
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":   "provider_disabled",
+				"message": "Gemini ถูกปิดใช้งานชั่วคราว extract ใช้ provider อื่นแทนไม่ได้",
+				"model":   "gemini",
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":          "invalid model",
+			"message":        "extract ใช้ได้เฉพาะ model 'gemini'",
+			"provided_value": req.Model,
+			"allowed_values": []string{"gemini"},
+		})
+		return
+	}
+
+	reqCtx := common.NewRequestContext(req.ShopID)
+	c.Set(requestIDContextKey, reqCtx.RequestID)
+	reqCtx.LogInfo("🔎 Schema extraction | ShopID: %s | Fields: %d", req.ShopID, len(req.Fields))
+	defer recordUsageLedger(c, reqCtx, "extract", req.Model)
+	defer saveAITraces(reqCtx, "extract")
+
+	// Request deadline (REQUEST_TIMEOUT) - each phase derives its own deadline from it
+	ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout())
+	defer cancel()
+
+	// Step 2: Receive the document
+	path, ok := receiveStandaloneDocument(c, ctx, reqCtx, req.StandaloneDocumentRequest)
+	if !ok {
+		return
+	}
+	defer os.Remove(path)
+
+	// Step 3: Extract the fields (deadline = FULL_OCR_TIMEOUT, it is a single read of the document)
+	reqCtx.StartStep("schema_extraction")
+	extractCtx, cancelExtract := phaseContext(ctx, failurePhaseOCR)
+	defer cancelExtract()
+	values, tokens, err := ai.ExtractWithSchema(extractCtx, path, req.Fields, req.Instructions, reqCtx)
+	if err != nil {
+		reqCtx.LogError("Extraction failed: %v", err)
+		reqCtx.EndStep("failed", tokens, err)
+		if respondStandaloneAborted(c, ctx, reqCtx) {
+			return
+		}
+		if phaseTimedOut(extractCtx, ctx) {
+			respondPhaseTimeout(c, reqCtx, failurePhaseOCR)
+			return
+		}
+		if blockErr, blocked := ai.AsSafetyBlock(err); blocked {
+			respondContentBlocked(c, reqCtx, blockErr, 0)
+			return
+		}
+		if respondImageTooLarge(c, reqCtx, err, -1) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Extraction failed",
+			"details":    err.Error(),
+			"request_id": reqCtx.RequestID,
+		})
+		return
+	}
+	reqCtx.EndStep("success", tokens, nil)
+	reqCtx.LogInfo("✅ Extracted %d field(s)", len(values))
+	summary := reqCtx.GetSummary()
+
+	c.JSON(http.StatusOK, SchemaExtractResponse{
+		RequestID:         reqCtx.RequestID,
+		DocumentImageGUID: req.DocumentImageGUID,
+		Values:            values,
+		Model:             reqCtx.Settings.OCRModel,
+		ProcessingTimeMs:  summary["total_duration_ms"].(int64),
+		TokenUsage:        reqCtx.TotalTokens,
+		ImageReductions:   reqCtx.ImageReductions(),
+		FileScans:         reqCtx.FileScans(),
+		ImageSanitization: reqCtx.ImageSanitizations(),
+		Steps:             reqCtx.GetSteps(),
+	})
+}
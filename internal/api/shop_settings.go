@@ -0,0 +1,225 @@
+// shop_settings.go - Per-shop model / threshold / VAT rate overrides, custom fields and dimensions (resolution + GET / PUT)
+//
+// ค่าที่ใช้จริงต่อ request = config กลาง → ทับด้วย shopSettings ของร้าน (เฉพาะ field ที่ตั้งไว้)
+// ผลลัพธ์รายงานใน metadata.settings (shop_overrides = field ที่มาจากร้าน)
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Custom fields are added to the Phase 3 response schema - keep the list short
+const maxCustomFields = 20
+
+// Dimensions are added to every journal line of the Phase 3 response schema
+const maxDimensions = 5
+
+// customFieldNamePattern - custom field names / dimension keys are JSON keys of the response
+var customFieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// ShopSettingsResponse shows a shop's overrides and the values its next request will use
+type ShopSettingsResponse struct {
+	Settings  storage.ShopSettings   `json:"settings"`
+	Effective common.RequestSettings `json:"effective"` // Global config + overrides
+}
+
+// applyShopSettings overrides reqCtx.Settings with the fields the shop has set
+func applyShopSettings(reqCtx *common.RequestContext, settings *storage.ShopSettings) {
+	if settings == nil {
+		return
+	}
+
+	resolved := &reqCtx.Settings
+	overrideModel := func(name, value string, target *string) {
+		if value != "" {
+			*target = value
+			resolved.ShopOverrides = append(resolved.ShopOverrides, name)
+		}
+	}
+	overrideThreshold := func(name string, value *float64, target *float64) {
+		if value != nil {
+			*target = *value
+			resolved.ShopOverrides = append(resolved.ShopOverrides, name)
+		}
+	}
+
+	overrideModel("ocr_model", settings.OCRModelName, &resolved.OCRModel)
+	overrideModel("template_model", settings.TemplateModelName, &resolved.TemplateModel)
+	overrideModel("template_accounting_model", settings.TemplateAccountingModelName, &resolved.TemplateAccountingModel)
+	overrideModel("accounting_model", settings.AccountingModelName, &resolved.AccountingModel)
+	overrideThreshold("template_confidence_threshold", settings.TemplateConfidenceThreshold, &resolved.TemplateConfidenceThreshold)
+	overrideThreshold("handwritten_template_confidence_threshold", settings.HandwrittenTemplateConfidenceThreshold, &resolved.HandwrittenTemplateConfidenceThreshold)
+	overrideThreshold("vat_rate", settings.VATRate, &resolved.VATRate)
+	if len(settings.CustomFields) > 0 {
+		resolved.CustomFields = settings.CustomFields
+		resolved.ShopOverrides = append(resolved.ShopOverrides, "custom_fields")
+	}
+	if len(settings.Dimensions) > 0 {
+		resolved.Dimensions = settings.Dimensions
+		resolved.ShopOverrides = append(resolved.ShopOverrides, "dimensions")
+	}
+
+	if len(resolved.ShopOverrides) > 0 {
+		reqCtx.LogInfo("⚙️  Shop settings override: %s", strings.Join(resolved.ShopOverrides, ", "))
+	}
+}
+
+// validateShopSettings returns the problems of a settings update (empty = valid)
+func validateShopSettings(settings storage.ShopSettings) []string {
+	var problems []string
+	models := map[string]string{
+		"ocr_model_name":                 settings.OCRModelName,
+		"template_model_name":            settings.TemplateModelName,
+		"template_accounting_model_name": settings.TemplateAccountingModelName,
+		"accounting_model_name":          settings.AccountingModelName,
+	}
+	for field, model := range models {
+		if model != "" && !strings.HasPrefix(model, "gemini-") {
+			problems = append(problems, fmt.Sprintf("%s: %q is not a Gemini model", field, model))
+		}
+	}
+	thresholds := map[string]*float64{
+		"template_confidence_threshold":             settings.TemplateConfidenceThreshold,
+		"handwritten_template_confidence_threshold": settings.HandwrittenTemplateConfidenceThreshold,
+		"vat_rate": settings.VATRate,
+	}
+	for field, threshold := range thresholds {
+		if threshold != nil && (*threshold < 0 || *threshold > 100) {
+			problems = append(problems, fmt.Sprintf("%s: must be between 0 and 100", field))
+		}
+	}
+	if settings.RetentionDays != nil && *settings.RetentionDays < 1 {
+		problems = append(problems, "retention_days: must be >= 1")
+	}
+	problems = append(problems, validateCustomFields(settings.CustomFields)...)
+	problems = append(problems, validateDimensionDefinitions(settings.Dimensions)...)
+	sort.Strings(problems)
+	return problems
+}
+
+// validateCustomFields checks names (receipt.custom_fields keys), types and the field limit
+func validateCustomFields(fields []common.CustomField) []string {
+	var problems []string
+	if len(fields) > maxCustomFields {
+		problems = append(problems, fmt.Sprintf("custom_fields: at most %d fields", maxCustomFields))
+	}
+	seen := map[string]bool{}
+	for i, field := range fields {
+		switch {
+		case !customFieldNamePattern.MatchString(field.Name):
+			problems = append(problems, fmt.Sprintf("custom_fields[%d]: name %q must be lowercase letters, digits and _ (e.g. po_number)", i, field.Name))
+		case seen[field.Name]:
+			problems = append(problems, fmt.Sprintf("custom_fields[%d]: duplicate name %q", i, field.Name))
+		}
+		seen[field.Name] = true
+		switch field.Type {
+		case common.CustomFieldString, common.CustomFieldNumber, common.CustomFieldInteger, common.CustomFieldBoolean, common.CustomFieldDate:
+		default:
+			problems = append(problems, fmt.Sprintf("custom_fields[%d]: invalid type %q (allowed: string, number, integer, boolean, date)", i, field.Type))
+		}
+	}
+	return problems
+}
+
+// validateDimensionDefinitions checks the dimension keys (entries[].dimensions keys) and the limit
+func validateDimensionDefinitions(definitions []common.DimensionDefinition) []string {
+	var problems []string
+	if len(definitions) > maxDimensions {
+		problems = append(problems, fmt.Sprintf("dimensions: at most %d dimensions", maxDimensions))
+	}
+	seen := map[string]bool{}
+	for i, definition := range definitions {
+		switch {
+		case !customFieldNamePattern.MatchString(definition.Key):
+			problems = append(problems, fmt.Sprintf("dimensions[%d]: key %q must be lowercase letters, digits and _ (e.g. cost_center)", i, definition.Key))
+		case seen[definition.Key]:
+			problems = append(problems, fmt.Sprintf("dimensions[%d]: duplicate key %q", i, definition.Key))
+		}
+		seen[definition.Key] = true
+	}
+	return problems
+}
+
+// GetShopSettingsHandler handles GET /api/v1/shops/:shopid/settings
+func GetShopSettingsHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	settings, err := storage.GetShopSettings(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load shop settings",
+			"details": err.Error(),
+		})
+		return
+	}
+	if settings == nil {
+		settings = &storage.ShopSettings{ShopID: shopID}
+	}
+
+	// effective = ค่าที่ request ถัดไปของร้านจะใช้จริง
+	reqCtx := common.NewRequestContext(shopID)
+	applyShopSettings(reqCtx, settings)
+
+	c.JSON(http.StatusOK, ShopSettingsResponse{Settings: *settings, Effective: reqCtx.Settings})
+}
+
+// UpdateShopSettingsHandler handles PUT /api/v1/shops/:shopid/settings
+func UpdateShopSettingsHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	var req storage.ShopSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	req.ShopID = shopID
+	req.OCRModelName = strings.TrimSpace(req.OCRModelName)
+	req.TemplateModelName = strings.TrimSpace(req.TemplateModelName)
+	req.TemplateAccountingModelName = strings.TrimSpace(req.TemplateAccountingModelName)
+	req.AccountingModelName = strings.TrimSpace(req.AccountingModelName)
+	for i := range req.CustomFields {
+		req.CustomFields[i].Name = strings.TrimSpace(req.CustomFields[i].Name)
+		req.CustomFields[i].Type = strings.ToLower(strings.TrimSpace(req.CustomFields[i].Type))
+		req.CustomFields[i].Description = strings.TrimSpace(req.CustomFields[i].Description)
+	}
+	for i := range req.Dimensions {
+		req.Dimensions[i].Key = strings.TrimSpace(req.Dimensions[i].Key)
+		req.Dimensions[i].Name = strings.TrimSpace(req.Dimensions[i].Name)
+		req.Dimensions[i].Default = strings.TrimSpace(req.Dimensions[i].Default)
+	}
+
+	if problems := validateShopSettings(req); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "invalid shop settings",
+			"message":  "ค่าที่ตั้งไม่ถูกต้อง",
+			"problems": problems,
+		})
+		return
+	}
+
+	saved, err := storage.SaveShopSettings(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save shop settings",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Settings are part of the master data cache - reload on the next request
+	dataStore.InvalidateCache(shopID)
+
+	c.JSON(http.StatusOK, saved)
+}
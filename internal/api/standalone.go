@@ -0,0 +1,290 @@
+// standalone.go - Shared input handling of the standalone document endpoints (classify-document, ocr, extract, quality-check)
+//
+// รับเอกสาร 1 ไฟล์ (รูป / PDF) ได้ 2 แบบ:
+//   - multipart/form-data: file + shopid + model
+//   - JSON: {"shopid", "imageuri", "model"} → ดาวน์โหลดเหมือน analyze-receipt
+// shopid ใช้สำหรับ usage ledger และการระงับร้าน (ไม่โหลด master data)
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/ai"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// StandaloneDocumentRequest is the common input of the standalone endpoints
+type StandaloneDocumentRequest struct {
+	ShopID            string `json:"shopid" form:"shopid"`
+	Model             string `json:"model,omitempty" form:"model"` // OCR provider: "gemini" (default) or "mistral"
+	ImageURI          string `json:"imageuri" form:"imageuri"`     // File to download (multipart: send "file" instead)
+	DocumentImageGUID string `json:"documentimageguid,omitempty" form:"documentimageguid"`
+}
+
+// isMultipartRequest reports whether the document is uploaded as a file
+func isMultipartRequest(c *gin.Context) bool {
+	return strings.HasPrefix(c.ContentType(), "multipart/form-data")
+}
+
+// bindStandaloneRequest parses the JSON or multipart body into req (a struct embedding StandaloneDocumentRequest)
+// and validates the shop and model (false = response written)
+func bindStandaloneRequest(c *gin.Context, req interface{}, doc *StandaloneDocumentRequest) bool {
+	if err := c.ShouldBind(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Invalid request format",
+			"details":  err.Error(),
+			"expected": "multipart/form-data with file + shopid, or JSON with shopid + imageuri",
+		})
+		return false
+	}
+
+	if doc.ShopID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "shopid is required",
+		})
+		return false
+	}
+	if !isMultipartRequest(c) && doc.ImageURI == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "imageuri is required",
+			"message": "ส่ง imageuri (JSON) หรืออัปโหลดไฟล์ในฟิลด์ 'file' (multipart/form-data)",
+		})
+		return false
+	}
+
+	if doc.Model == "" {
+		doc.Model = "gemini"
+	}
+	if doc.Model != "gemini" && doc.Model != "mistral" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":          "invalid model",
+			"message":        fmt.Sprintf("Model '%s' ไม่ถูกต้อง กรุณาเลือก 'gemini' หรือ 'mistral'", doc.Model),
+			"provided_value": doc.Model,
+			"allowed_values": []string{"gemini", "mistral"},
+		})
+		return false
+	}
+
+	// Incident response flags (admin API)
+	if rejectForeignShop(c, doc.ShopID) || rejectSuspendedShop(c, doc.ShopID) {
+		return false
+	}
+	model, ok := resolveOCRProvider(c, doc.Model)
+	if !ok {
+		return false
+	}
+	doc.Model = model
+	return true
+}
+
+// receiveStandaloneDocument stores the uploaded file or downloads imageuri into UPLOAD_DIR
+// Returns the local path - the caller removes it (ok = false → response written)
+func receiveStandaloneDocument(c *gin.Context, ctx context.Context, reqCtx *common.RequestContext, doc StandaloneDocumentRequest) (string, bool) {
+	uniqueID := uuid.New().String()
+
+	if isMultipartRequest(c) {
+		file, header, err := c.Request.FormFile("file")
+		if sizeErr, tooLarge := asPayloadTooLarge(err); tooLarge {
+			respondPayloadTooLarge(c, reqCtx.RequestID, sizeErr, -1)
+			return "", false
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "file is required",
+				"details": err.Error(),
+			})
+			return "", false
+		}
+		defer file.Close()
+
+		contentType := header.Header.Get("Content-Type")
+		if contentType != "image/jpeg" && contentType != "image/png" && contentType != "image/jpg" && contentType != "application/pdf" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid file type. Only JPG/PNG images and PDF files are allowed",
+				"details": fmt.Sprintf("Received: %s", contentType),
+			})
+			return "", false
+		}
+
+		path := filepath.Join(configs.UPLOAD_DIR, uniqueID+strings.ToLower(filepath.Ext(header.Filename)))
+		out, err := os.Create(path)
+		if err != nil {
+			reqCtx.LogError("Failed to create temp file: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":      "Failed to save uploaded file",
+				"request_id": reqCtx.RequestID,
+			})
+			return "", false
+		}
+		_, err = io.Copy(out, file)
+		out.Close()
+		if err != nil {
+			os.Remove(path)
+			reqCtx.LogError("Failed to write temp file: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":      "Failed to save uploaded file",
+				"request_id": reqCtx.RequestID,
+			})
+			return "", false
+		}
+		reqCtx.LogInfo("✅ File saved temporarily: %s (%.2f KB)", filepath.Base(path), float64(header.Size)/1024)
+		if !scanStandaloneDocument(c, ctx, reqCtx, path) {
+			return "", false
+		}
+		return path, true
+	}
+
+	downloadCtx, cancelDownload := phaseContext(ctx, phaseDownload)
+	defer cancelDownload()
+
+	tempFilename := filepath.Join(configs.UPLOAD_DIR, uniqueID+".tmp")
+	fileExt, err := downloadImageFromURL(downloadCtx, doc.ImageURI, tempFilename)
+	if err != nil {
+		os.Remove(tempFilename)
+		reqCtx.LogError("Failed to download file: %v", err)
+		if respondStandaloneAborted(c, ctx, reqCtx) {
+			return "", false
+		}
+		if sizeErr, tooLarge := asPayloadTooLarge(err); tooLarge {
+			respondPayloadTooLarge(c, reqCtx.RequestID, sizeErr, -1)
+			return "", false
+		}
+		if phaseTimedOut(downloadCtx, ctx) {
+			respondPhaseTimeout(c, reqCtx, phaseDownload)
+			return "", false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to download file",
+			"details":    err.Error(),
+			"image_uri":  doc.ImageURI,
+			"request_id": reqCtx.RequestID,
+		})
+		return "", false
+	}
+
+	path := filepath.Join(configs.UPLOAD_DIR, uniqueID+fileExt)
+	if err := os.Rename(tempFilename, path); err != nil {
+		os.Remove(tempFilename)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to save downloaded file",
+			"details":    err.Error(),
+			"request_id": reqCtx.RequestID,
+		})
+		return "", false
+	}
+	reqCtx.LogInfo("Downloaded file: %s (type: %s)", filepath.Base(path), fileExt)
+	if !scanStandaloneDocument(c, ctx, reqCtx, path) {
+		return "", false
+	}
+	return path, true
+}
+
+// scanStandaloneDocument runs the malware scan on the received file (false → file removed, response written)
+// A clean file has its metadata stripped (STRIP_IMAGE_METADATA)
+func scanStandaloneDocument(c *gin.Context, ctx context.Context, reqCtx *common.RequestContext, path string) bool {
+	err := scanDocumentFile(ctx, reqCtx, path, 0)
+	if err == nil {
+		sanitizeDocumentFile(reqCtx, path, 0)
+		return true
+	}
+	os.Remove(path)
+	if !respondFileScanRejected(c, reqCtx, err, 0) {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "Failed to scan file",
+			"details":    err.Error(),
+			"request_id": reqCtx.RequestID,
+		})
+	}
+	return false
+}
+
+// runStandaloneOCR runs pure OCR on one document with the requested provider
+// A safety block is retried with the other provider (SAFETY_BLOCK_FALLBACK) like analyze-receipt
+// Returns the result and the provider that produced it (nil → response written)
+func runStandaloneOCR(c *gin.Context, ctx context.Context, reqCtx *common.RequestContext, model, path string) (*ai.SimpleOCRResult, string) {
+	reqCtx.StartStep("pure_ocr_extraction_all")
+
+	ocrProvider, err := ai.CreateOCRProvider(model)
+	if err != nil {
+		reqCtx.LogError("Failed to create OCR provider: %v", err)
+		reqCtx.EndStep("failed", nil, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "OCR provider initialization failed",
+			"details":    err.Error(),
+			"request_id": reqCtx.RequestID,
+		})
+		return nil, ""
+	}
+
+	ocrCtx, cancelOCR := phaseContext(ctx, failurePhaseOCR)
+	defer cancelOCR()
+	provider := ocrProvider.GetProviderName()
+	result, tokens, err := ocrProvider.ProcessPureOCR(ocrCtx, path, reqCtx)
+	if blockErr, blocked := ai.AsSafetyBlock(err); blocked {
+		if alternate := ai.AlternateOCRProvider(provider); alternate != nil {
+			reqCtx.LogWarning("🛡️  Document blocked by %s (%s) → retrying with %s", blockErr.Provider, blockErr.Kind, alternate.GetProviderName())
+			if altResult, altTokens, altErr := alternate.ProcessPureOCR(ocrCtx, path, reqCtx); altErr == nil {
+				result, tokens, err = altResult, altTokens, nil
+				provider = alternate.GetProviderName()
+			}
+		}
+	}
+	if err != nil {
+		reqCtx.LogError("OCR failed: %v", err)
+		reqCtx.EndStep("failed", nil, err)
+		if respondStandaloneAborted(c, ctx, reqCtx) {
+			return nil, ""
+		}
+		if phaseTimedOut(ocrCtx, ctx) {
+			respondPhaseTimeout(c, reqCtx, failurePhaseOCR)
+			return nil, ""
+		}
+		if blockErr, blocked := ai.AsSafetyBlock(err); blocked {
+			respondContentBlocked(c, reqCtx, blockErr, 0)
+			return nil, ""
+		}
+		if respondImageTooLarge(c, reqCtx, err, -1) {
+			return nil, ""
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "OCR processing failed",
+			"details":    err.Error(),
+			"request_id": reqCtx.RequestID,
+		})
+		return nil, ""
+	}
+
+	reqCtx.EndStep("success", tokens, nil)
+	return result, provider
+}
+
+// respondStandaloneAborted writes 499 (client disconnected) or 408 (REQUEST_TIMEOUT) once the request context ended
+// (true = response written)
+func respondStandaloneAborted(c *gin.Context, ctx context.Context, reqCtx *common.RequestContext) bool {
+	switch ctx.Err() {
+	case nil:
+		return false
+	case context.DeadlineExceeded:
+		reqCtx.LogError("⚠️  Request timeout after %v (step: %s)", requestTimeout(), reqCtx.CurrentStep)
+		c.JSON(http.StatusRequestTimeout, gin.H{
+			"error":          "Processing timeout",
+			"message":        fmt.Sprintf("การประมวลผลใช้เวลาเกิน %v กรุณาลองใหม่อีกครั้ง", requestTimeout()),
+			"request_id":     reqCtx.RequestID,
+			"phase_timeouts": reqCtx.PhaseTimeouts(),
+		})
+	default:
+		reqCtx.LogWarning("🔌 Client disconnected during %s - processing stopped", reqCtx.CurrentStep)
+		c.AbortWithStatus(statusClientClosedRequest)
+	}
+	return true
+}
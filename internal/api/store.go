@@ -0,0 +1,14 @@
+// store.go - Storage used by the handlers (injected from main)
+
+package api
+
+import "github.com/bosocmputer/account_ocr_gemini/internal/storage"
+
+// dataStore serves master data, templates and stored OCR results to the handlers
+var dataStore storage.Store
+
+// UseStore sets the storage of the handlers and the analyze service - call before serving requests
+func UseStore(store storage.Store) {
+	dataStore = store
+	analyzeService = NewAnalyzeService(store)
+}
@@ -0,0 +1,135 @@
+// template_coverage.go - Document analytics recording and per-shop template coverage reporting
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// defaultCoverageDays is the report range when from/to are not given
+const defaultCoverageDays = 30
+
+// recordDocumentAnalytics writes the template matching outcome of an analyzed document
+func recordDocumentAnalytics(reqCtx *common.RequestContext, record storage.DocumentAnalyticsRecord) {
+	record.RequestID = reqCtx.RequestID
+	record.ShopID = reqCtx.ShopID
+
+	// Write in background - analytics must not delay the response
+	go func() {
+		if err := storage.RecordDocumentAnalytics(record); err != nil {
+			reqCtx.LogWarning("Failed to record document analytics: %v", err)
+		}
+	}()
+}
+
+// templateIDString formats a documentFormate _id (ObjectID → hex)
+func templateIDString(id interface{}) string {
+	switch v := id.(type) {
+	case nil:
+		return ""
+	case primitive.ObjectID:
+		return v.Hex()
+	case string:
+		return v
+	}
+	return fmt.Sprint(id)
+}
+
+// analyticsVendorKey identifies a vendor across documents: tax ID, else lowercased name ("" = unknown)
+func analyticsVendorKey(vendorName, vendorTaxID string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, vendorTaxID)
+	if len(digits) == 13 {
+		return digits
+	}
+	name := strings.ToLower(strings.TrimSpace(vendorName))
+	if name == "" || name == "n/a" || name == "unknown vendor" {
+		return ""
+	}
+	return name
+}
+
+// analyticsEntries keeps the accounts (not amounts) of accounting_entry.entries
+func analyticsEntries(accountingEntry map[string]interface{}) []storage.AnalyticsEntry {
+	entries := []storage.AnalyticsEntry{}
+	entriesRaw, _ := accountingEntry["entries"].([]interface{})
+	for _, e := range entriesRaw {
+		entryMap, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		side := "debit"
+		if getFloatValue(entryMap, "credit") > getFloatValue(entryMap, "debit") {
+			side = "credit"
+		}
+		entries = append(entries, storage.AnalyticsEntry{
+			AccountCode: getStringValue(entryMap, "account_code"),
+			AccountName: getStringValue(entryMap, "account_name"),
+			Side:        side,
+		})
+	}
+	return entries
+}
+
+// TemplateCoverageHandler handles GET /api/v1/shops/:shopid/template-coverage?from=2024-06-01&to=2024-06-30
+// from/to are inclusive days (default: last 30 days)
+func TemplateCoverageHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	today := time.Now().In(time.Local)
+	to := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, 1)
+	from := to.AddDate(0, 0, -defaultCoverageDays)
+
+	if v := c.Query("from"); v != "" {
+		day, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid from",
+				"message": "from ต้องอยู่ในรูปแบบ YYYY-MM-DD",
+			})
+			return
+		}
+		from = day
+	}
+	if v := c.Query("to"); v != "" {
+		day, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid to",
+				"message": "to ต้องอยู่ในรูปแบบ YYYY-MM-DD",
+			})
+			return
+		}
+		to = day.AddDate(0, 0, 1)
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid date range",
+			"message": "from ต้องไม่เกิน to",
+		})
+		return
+	}
+
+	report, err := storage.GetTemplateCoverageReport(c.Request.Context(), shopID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load template coverage",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,33 @@
+// template_formulas.go - Amounts of template lines computed by details[].formula (see processor/template_formula.go)
+
+package api
+
+import (
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// applyTemplateFormulas computes the formula lines of the matched template (nil = no template or no formula)
+// Runs before VAT enforcement and the balance check so both see the computed amounts
+func applyTemplateFormulas(accountingEntry, receipt map[string]interface{}, matchedTemplate *bson.M, reqCtx *common.RequestContext) *processor.TemplateFormulaResult {
+	if matchedTemplate == nil || accountingEntry == nil {
+		return nil
+	}
+	if receipt == nil {
+		receipt = map[string]interface{}{}
+	}
+	result := processor.ApplyTemplateFormulas(accountingEntry, receipt, *matchedTemplate)
+	if result == nil {
+		return nil
+	}
+	for _, line := range result.Lines {
+		switch {
+		case line.Error != "":
+			reqCtx.LogWarning("🧮 Formula %s (%s) failed: %s - AI amount kept", line.AccountCode, line.Formula, line.Error)
+		case line.Changed:
+			reqCtx.LogInfo("🧮 Formula %s %s = %s: AI %s → %s", line.AccountCode, line.Side, line.Formula, line.AIAmount, line.Amount)
+		}
+	}
+	return result
+}
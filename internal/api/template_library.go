@@ -0,0 +1,498 @@
+// template_library.go - Shared template library and per-shop subscriptions
+//
+// admin ดูแล template มาตรฐานใน library, ร้านสมัครใช้พร้อม map รหัสบัญชีมาตรฐาน → ผังบัญชีของร้าน
+// ไม่ต้องสร้าง template เดิมซ้ำทุกร้าน (ค่าน้ำมัน, ค่าไฟฟ้า, เงินเดือน)
+
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// libraryIDPattern - library ids are used in URLs and template ids ("library:<id>")
+var libraryIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// LibraryTemplateRequest is the body of PUT /api/v1/template-library/:library_id
+type LibraryTemplateRequest struct {
+	Description       string                          `json:"description"`
+	PromptDescription string                          `json:"promptdescription"`
+	Details           []storage.LibraryTemplateDetail `json:"details"`
+}
+
+// LibraryTemplatesResponse lists the template library
+type LibraryTemplatesResponse struct {
+	Templates []storage.LibraryTemplate `json:"templates"`
+}
+
+// TemplateSubscriptionRequest is the body of PUT /api/v1/shops/:shopid/template-subscriptions/:library_id
+type TemplateSubscriptionRequest struct {
+	AccountMap        map[string]string `json:"account_map,omitempty"` // Library account code → shop account code (missing = same code)
+	Description       string            `json:"description,omitempty"`
+	PromptDescription string            `json:"promptdescription,omitempty"`
+	JournalBookCode   string            `json:"journalbookcode,omitempty"`
+}
+
+// TemplateSubscriptionView is a subscription with the template the shop gets from it
+type TemplateSubscriptionView struct {
+	storage.TemplateSubscription
+	Template bson.M `json:"template,omitempty"` // nil = library template was removed
+}
+
+// TemplateSubscriptionsResponse lists the library subscriptions of a shop
+type TemplateSubscriptionsResponse struct {
+	ShopID        string                     `json:"shopid"`
+	Subscriptions []TemplateSubscriptionView `json:"subscriptions"`
+}
+
+// ListLibraryTemplatesHandler handles GET /api/v1/template-library
+func ListLibraryTemplatesHandler(c *gin.Context) {
+	templates, err := storage.ListLibraryTemplates(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load template library",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, LibraryTemplatesResponse{Templates: templates})
+}
+
+// PutLibraryTemplateHandler handles PUT /api/v1/template-library/:library_id
+func PutLibraryTemplateHandler(c *gin.Context) {
+	libraryID := c.Param("library_id")
+	if !libraryIDPattern.MatchString(libraryID) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid library_id",
+			"message": "library_id ใช้ได้เฉพาะ a-z, 0-9, - และ _ (ไม่เกิน 64 ตัวอักษร)",
+		})
+		return
+	}
+
+	var req LibraryTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	req.Description = strings.TrimSpace(req.Description)
+	req.PromptDescription = strings.TrimSpace(req.PromptDescription)
+	if req.Description == "" || req.PromptDescription == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "description and promptdescription are required",
+			"message": "กรุณาระบุ description และ promptdescription",
+		})
+		return
+	}
+
+	// Structure only - account codes are checked against each shop's chart when it subscribes
+	codes := map[string]bool{}
+	for i, detail := range req.Details {
+		req.Details[i].AccountCode = strings.TrimSpace(detail.AccountCode)
+		req.Details[i].Side = strings.ToLower(strings.TrimSpace(detail.Side))
+		if req.Details[i].AccountCode == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid details",
+				"message": fmt.Sprintf("details[%d] ไม่มี accountcode", i),
+			})
+			return
+		}
+		if side := req.Details[i].Side; side != "" && side != "debit" && side != "credit" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid details",
+				"message": fmt.Sprintf("details[%d].side ต้องเป็น debit หรือ credit", i),
+			})
+			return
+		}
+		req.Details[i].Formula = strings.TrimSpace(detail.Formula)
+		if req.Details[i].Formula != "" {
+			if _, err := processor.ParseFormula(req.Details[i].Formula); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":   "invalid details",
+					"message": fmt.Sprintf("details[%d].formula ไม่ถูกต้อง: %v", i, err),
+				})
+				return
+			}
+		}
+		codes[req.Details[i].AccountCode] = true
+	}
+	if len(codes) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid details",
+			"message": "template ต้องมีอย่างน้อย 2 บัญชี (ฝั่ง debit และ credit)",
+		})
+		return
+	}
+
+	saved, err := storage.SaveLibraryTemplate(storage.LibraryTemplate{
+		LibraryID:         libraryID,
+		Description:       req.Description,
+		PromptDescription: req.PromptDescription,
+		Details:           req.Details,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save library template",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, saved)
+}
+
+// DeleteLibraryTemplateHandler handles DELETE /api/v1/template-library/:library_id
+// Subscriptions to the template are removed too
+func DeleteLibraryTemplateHandler(c *gin.Context) {
+	libraryID := c.Param("library_id")
+
+	subscriptions, err := storage.DeleteLibraryTemplate(libraryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to delete library template",
+			"details": err.Error(),
+		})
+		return
+	}
+	if subscriptions < 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "library template not found",
+			"message": "ไม่พบ template " + libraryID + " ใน library",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"library_id": libraryID, "deleted": true, "subscriptions_deleted": subscriptions})
+}
+
+// GetTemplateSubscriptionsHandler handles GET /api/v1/shops/:shopid/template-subscriptions
+func GetTemplateSubscriptionsHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	subscriptions, err := storage.GetTemplateSubscriptions(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load template subscriptions",
+			"details": err.Error(),
+		})
+		return
+	}
+	library, err := storage.ListLibraryTemplates(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load template library",
+			"details": err.Error(),
+		})
+		return
+	}
+	byID := make(map[string]storage.LibraryTemplate, len(library))
+	for _, template := range library {
+		byID[template.LibraryID] = template
+	}
+
+	response := TemplateSubscriptionsResponse{ShopID: shopID, Subscriptions: make([]TemplateSubscriptionView, 0, len(subscriptions))}
+	for _, subscription := range subscriptions {
+		view := TemplateSubscriptionView{TemplateSubscription: subscription}
+		if template, ok := byID[subscription.LibraryID]; ok {
+			view.Template = storage.ResolveLibraryTemplate(template, subscription)
+		}
+		response.Subscriptions = append(response.Subscriptions, view)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// PutTemplateSubscriptionHandler handles PUT /api/v1/shops/:shopid/template-subscriptions/:library_id
+// The resolved template must pass ValidateTemplate against the shop's master data
+func PutTemplateSubscriptionHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+	libraryID := c.Param("library_id")
+
+	var req TemplateSubscriptionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	template, err := storage.GetLibraryTemplate(c.Request.Context(), libraryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load library template",
+			"details": err.Error(),
+		})
+		return
+	}
+	if template == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "library template not found",
+			"message": "ไม่พบ template " + libraryID + " ใน library",
+		})
+		return
+	}
+
+	subscription := storage.TemplateSubscription{
+		ShopID:            shopID,
+		LibraryID:         libraryID,
+		AccountMap:        map[string]string{},
+		Description:       strings.TrimSpace(req.Description),
+		PromptDescription: strings.TrimSpace(req.PromptDescription),
+		JournalBookCode:   strings.TrimSpace(req.JournalBookCode),
+	}
+	for from, to := range req.AccountMap {
+		if from, to = strings.TrimSpace(from), strings.TrimSpace(to); from != "" && to != "" {
+			subscription.AccountMap[from] = to
+		}
+	}
+
+	// Step 2: The template the shop gets must be valid for its chart of accounts
+	masterCache, err := dataStore.GetOrLoadMasterData(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to load master data",
+			"details": err.Error(),
+		})
+		return
+	}
+	resolved := storage.ResolveLibraryTemplate(*template, subscription)
+	if validation := processor.ValidateTemplate(resolved, masterCache.Accounts, masterCache.JournalBooks); !validation.Valid {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":               "invalid template",
+			"message":             "รหัสบัญชีของ template ไม่ตรงกับผังบัญชีของร้าน - ระบุ account_map เพื่อ map รหัสบัญชี",
+			"template":            resolved,
+			"template_validation": validation,
+		})
+		return
+	}
+
+	saved, err := storage.SaveTemplateSubscription(subscription)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save template subscription",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, TemplateSubscriptionView{TemplateSubscription: *saved, Template: resolved})
+}
+
+// DeleteTemplateSubscriptionHandler handles DELETE /api/v1/shops/:shopid/template-subscriptions/:library_id
+func DeleteTemplateSubscriptionHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+	libraryID := c.Param("library_id")
+
+	deleted, err := storage.DeleteTemplateSubscription(shopID, libraryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to delete template subscription",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "template subscription not found",
+			"message": "ร้านไม่ได้สมัครใช้ template " + libraryID,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"shopid": shopID, "library_id": libraryID, "deleted": true})
+}
+
+// AutoMapMinScore - a suggestion must score at least this much to be proposed as the mapping
+const AutoMapMinScore = 60.0
+
+// SuggestMappingRequest is the optional body of POST /api/v1/shops/:shopid/template-subscriptions/:library_id/suggest-mapping
+type SuggestMappingRequest struct {
+	Apply    bool    `json:"apply,omitempty"`     // true = save the proposed account_map as the shop's subscription
+	MinScore float64 `json:"min_score,omitempty"` // 0 = AutoMapMinScore
+}
+
+// AccountMappingSuggestion is the proposed shop account for one library account code
+type AccountMappingSuggestion struct {
+	LibraryAccountCode string                        `json:"library_account_code"`
+	Detail             string                        `json:"detail"`
+	MappedTo           string                        `json:"mapped_to,omitempty"`
+	MappedName         string                        `json:"mapped_name,omitempty"`
+	Status             string                        `json:"status"` // existing (kept from subscription), exact (same code in shop chart), suggested, unmapped
+	Suggestions        []processor.AccountSuggestion `json:"suggestions"`
+}
+
+// SuggestMappingResponse is the proposed account_map with the template it resolves to
+type SuggestMappingResponse struct {
+	ShopID     string                             `json:"shopid"`
+	LibraryID  string                             `json:"library_id"`
+	Mappings   []AccountMappingSuggestion         `json:"mappings"`
+	AccountMap map[string]string                  `json:"account_map"`
+	Unmapped   int                                `json:"unmapped"`
+	Template   bson.M                             `json:"template"`
+	Validation processor.TemplateValidationResult `json:"template_validation"`
+	Applied    bool                               `json:"applied"`
+}
+
+// suggestAccountMap proposes a shop account for every library account code
+// Step 1: mapping already saved in the subscription (still in the chart) is kept
+// Step 2: same code exists in the shop chart → no mapping needed
+// Step 3: best name match from SuggestAccounts (detail text vs account names)
+func suggestAccountMap(template storage.LibraryTemplate, existing map[string]string, masterCache *storage.MasterDataCache, minScore float64) ([]AccountMappingSuggestion, map[string]string) {
+	// Posting accounts only (level 3-5) - header accounts cannot be mapped to
+	postingAccounts, _, _, _ := compactMasterData(masterCache)
+	chart := map[string]string{}
+	for _, acc := range postingAccounts {
+		chart[getStringValue(acc, "accountcode")] = getStringValue(acc, "accountname")
+	}
+
+	mappings := []AccountMappingSuggestion{}
+	accountMap := map[string]string{}
+	seen := map[string]bool{}
+	for _, detail := range template.Details {
+		if seen[detail.AccountCode] {
+			continue
+		}
+		seen[detail.AccountCode] = true
+
+		mapping := AccountMappingSuggestion{LibraryAccountCode: detail.AccountCode, Detail: detail.Detail, Suggestions: []processor.AccountSuggestion{}}
+		if mapped := existing[detail.AccountCode]; mapped != "" {
+			if name, ok := chart[mapped]; ok {
+				mapping.MappedTo, mapping.MappedName, mapping.Status = mapped, name, "existing"
+				accountMap[detail.AccountCode] = mapped
+				mappings = append(mappings, mapping)
+				continue
+			}
+		}
+		if name, ok := chart[detail.AccountCode]; ok {
+			mapping.MappedTo, mapping.MappedName, mapping.Status = detail.AccountCode, name, "exact"
+			mappings = append(mappings, mapping)
+			continue
+		}
+
+		mapping.Suggestions = processor.SuggestAccounts(detail.Detail, masterCache.Accounts, 3)
+		mapping.Status = "unmapped"
+		if len(mapping.Suggestions) > 0 && mapping.Suggestions[0].Score >= minScore {
+			best := mapping.Suggestions[0]
+			mapping.MappedTo, mapping.MappedName, mapping.Status = best.AccountCode, best.AccountName, "suggested"
+			accountMap[detail.AccountCode] = best.AccountCode
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, accountMap
+}
+
+// SuggestTemplateMappingHandler handles POST /api/v1/shops/:shopid/template-subscriptions/:library_id/suggest-mapping
+// Proposes account_map for a library template from the shop's chart of accounts; apply=true saves it
+// as the subscription (only when the resolved template passes ValidateTemplate)
+func SuggestTemplateMappingHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+	libraryID := c.Param("library_id")
+
+	var req SuggestMappingRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+	if req.MinScore <= 0 {
+		req.MinScore = AutoMapMinScore
+	}
+
+	template, err := storage.GetLibraryTemplate(c.Request.Context(), libraryID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load library template",
+			"details": err.Error(),
+		})
+		return
+	}
+	if template == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "library template not found",
+			"message": "ไม่พบ template " + libraryID + " ใน library",
+		})
+		return
+	}
+
+	// Step 1: Existing subscription keeps its overrides and confirmed mappings
+	subscriptions, err := storage.GetTemplateSubscriptions(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load template subscriptions",
+			"details": err.Error(),
+		})
+		return
+	}
+	subscription := storage.TemplateSubscription{ShopID: shopID, LibraryID: libraryID}
+	for _, s := range subscriptions {
+		if s.LibraryID == libraryID {
+			subscription = s
+			subscription.ShopID = shopID
+			break
+		}
+	}
+
+	masterCache, err := dataStore.GetOrLoadMasterData(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to load master data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Step 2: Propose mappings by name similarity and check the resolved template
+	mappings, accountMap := suggestAccountMap(*template, subscription.AccountMap, masterCache, req.MinScore)
+	subscription.AccountMap = accountMap
+	resolved := storage.ResolveLibraryTemplate(*template, subscription)
+	response := SuggestMappingResponse{
+		ShopID:     shopID,
+		LibraryID:  libraryID,
+		Mappings:   mappings,
+		AccountMap: accountMap,
+		Template:   resolved,
+		Validation: processor.ValidateTemplate(resolved, masterCache.Accounts, masterCache.JournalBooks),
+	}
+	for _, mapping := range mappings {
+		if mapping.Status == "unmapped" {
+			response.Unmapped++
+		}
+	}
+	if !req.Apply {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// Step 3: Save as the shop's subscription - analysis uses the mapped template from the next request
+	if !response.Validation.Valid {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":               "invalid template",
+			"message":             "ยัง map รหัสบัญชีไม่ครบ - ระบุ account_map เองผ่าน PUT template-subscriptions",
+			"mappings":            mappings,
+			"template_validation": response.Validation,
+		})
+		return
+	}
+	if _, err := storage.SaveTemplateSubscription(subscription); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save template subscription",
+			"details": err.Error(),
+		})
+		return
+	}
+	response.Applied = true
+	log.Printf("🔗 Template mapping applied: shop=%s library=%s accounts=%d", shopID, libraryID, len(accountMap))
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,158 @@
+// template_suggestions.go - Draft templates from recurring documents that had no template
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// documentTypeLabels - Thai labels for source_images[].type used in draft descriptions
+var documentTypeLabels = map[string]string{
+	"receipt":      "ใบเสร็จรับเงิน",
+	"tax_invoice":  "ใบกำกับภาษี",
+	"invoice":      "ใบแจ้งหนี้",
+	"payment_slip": "สลิปโอนเงิน",
+	"credit_note":  "ใบลดหนี้",
+	"debit_note":   "ใบเพิ่มหนี้",
+}
+
+// DraftTemplateDetail is one account line in documentFormate format
+type DraftTemplateDetail struct {
+	AccountCode string `json:"accountcode"`
+	Detail      string `json:"detail"`
+}
+
+// DraftTemplate uses documentFormate field names so it can be created as-is
+type DraftTemplate struct {
+	Description       string                `json:"description"`
+	PromptDescription string                `json:"promptdescription"`
+	Details           []DraftTemplateDetail `json:"details"`
+}
+
+// TemplateSuggestion is one recurring full-mode pattern and its draft template
+type TemplateSuggestion struct {
+	VendorName       string        `json:"vendor_name"`
+	VendorTaxID      string        `json:"vendor_tax_id,omitempty"`
+	DocumentType     string        `json:"document_type"`
+	AccountSignature string        `json:"account_signature"`
+	Documents        int           `json:"documents"`
+	AvgConfidence    float64       `json:"avg_confidence"`
+	LastSeen         time.Time     `json:"last_seen"`
+	ExampleRequests  []string      `json:"example_request_ids"`
+	Template         DraftTemplate `json:"template"`
+}
+
+// TemplateSuggestionsResponse lists suggestions (most frequent first)
+type TemplateSuggestionsResponse struct {
+	ShopID       string               `json:"shopid"`
+	Since        time.Time            `json:"since"`
+	MinDocuments int                  `json:"min_documents"`
+	Suggestions  []TemplateSuggestion `json:"suggestions"`
+}
+
+// TemplateSuggestionsHandler handles GET /api/v1/shops/:shopid/template-suggestions?min_documents=3&days=90
+func TemplateSuggestionsHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	minDocuments := configs.Get().TemplateSuggestionMinDocuments
+	if v := c.Query("min_documents"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid min_documents",
+				"message": "min_documents ต้องเป็นตัวเลขตั้งแต่ 2 ขึ้นไป",
+			})
+			return
+		}
+		minDocuments = n
+	}
+	days := configs.Get().TemplateSuggestionLookbackDays
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid days",
+				"message": "days ต้องเป็นตัวเลขตั้งแต่ 1 ขึ้นไป",
+			})
+			return
+		}
+		days = n
+	}
+	since := time.Now().AddDate(0, 0, -days)
+
+	groups, err := storage.GetRecurringUnmatchedGroups(c.Request.Context(), shopID, since, minDocuments)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load template suggestions",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	suggestions := make([]TemplateSuggestion, 0, len(groups))
+	for _, g := range groups {
+		suggestions = append(suggestions, TemplateSuggestion{
+			VendorName:       g.VendorName,
+			VendorTaxID:      g.VendorTaxID,
+			DocumentType:     g.DocumentType,
+			AccountSignature: g.AccountSignature,
+			Documents:        g.Documents,
+			AvgConfidence:    g.AvgConfidence,
+			LastSeen:         g.LastSeen,
+			ExampleRequests:  g.RequestIDs,
+			Template:         buildDraftTemplate(g),
+		})
+	}
+
+	c.JSON(http.StatusOK, TemplateSuggestionsResponse{
+		ShopID:       shopID,
+		Since:        since,
+		MinDocuments: minDocuments,
+		Suggestions:  suggestions,
+	})
+}
+
+// buildDraftTemplate turns the AI's entries of a recurring pattern into a documentFormate draft
+func buildDraftTemplate(g storage.RecurringUnmatchedGroup) DraftTemplate {
+	docLabel := documentTypeLabels[g.DocumentType]
+	if docLabel == "" {
+		docLabel = "เอกสาร"
+	}
+
+	draft := DraftTemplate{
+		Description: fmt.Sprintf("%s %s", docLabel, g.VendorName),
+		Details:     []DraftTemplateDetail{},
+	}
+
+	var debits, credits []string
+	seen := map[string]bool{}
+	for _, e := range g.Entries {
+		if e.AccountCode == "" || seen[e.AccountCode] {
+			continue
+		}
+		seen[e.AccountCode] = true
+		draft.Details = append(draft.Details, DraftTemplateDetail{AccountCode: e.AccountCode, Detail: e.AccountName})
+		line := fmt.Sprintf("%s %s", e.AccountCode, e.AccountName)
+		if e.Side == "credit" {
+			credits = append(credits, line)
+		} else {
+			debits = append(debits, line)
+		}
+	}
+
+	vendor := g.VendorName
+	if g.VendorTaxID != "" {
+		vendor = fmt.Sprintf("%s (เลขประจำตัวผู้เสียภาษี %s)", g.VendorName, g.VendorTaxID)
+	}
+	draft.PromptDescription = fmt.Sprintf("%sจาก %s บันทึก เดบิต %s / เครดิต %s",
+		docLabel, vendor, strings.Join(debits, ", "), strings.Join(credits, ", "))
+	return draft
+}
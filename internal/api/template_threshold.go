@@ -0,0 +1,207 @@
+// template_threshold.go - Approval outcome of template decisions + threshold tuning report
+// (see processor/template_threshold.go)
+
+package api
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Report defaults: lookback and how much worse a wrong template is than a missed one
+const (
+	defaultThresholdReportDays = 90
+	defaultThresholdFPWeight   = 2.0
+)
+
+// TemplateThresholdRow is the labeled decisions of one template
+type TemplateThresholdRow struct {
+	TemplateID             string  `json:"template_id"`
+	TemplateName           string  `json:"template_name"`
+	Samples                int     `json:"samples"`
+	Correct                int     `json:"correct"`                  // Approved accounts = template accounts
+	AvgConfidenceCorrect   float64 `json:"avg_confidence_correct"`   // Template match confidence when correct
+	AvgConfidenceIncorrect float64 `json:"avg_confidence_incorrect"` // ... when wrong (should be lower)
+	FalsePositives         int     `json:"false_positives"`          // At the current threshold
+	FalseNegatives         int     `json:"false_negatives"`
+}
+
+// TemplateThresholdReport recommends TEMPLATE_CONFIDENCE_THRESHOLD for a shop
+type TemplateThresholdReport struct {
+	ShopID           string                            `json:"shopid"`
+	Since            time.Time                         `json:"since"`
+	CurrentThreshold float64                           `json:"current_threshold"`
+	FPWeight         float64                           `json:"fp_weight"`
+	SkippedSamples   int                               `json:"skipped_samples"` // Template deleted since the analysis
+	Recommendation   processor.ThresholdRecommendation `json:"recommendation"`
+	Templates        []TemplateThresholdRow            `json:"templates"`
+}
+
+// recordTemplateOutcome labels the template decision of an approved result (and of the request it re-analyzed)
+func recordTemplateOutcome(record *storage.StoredOCRResult, analysis storage.OCRAnalysisSnapshot, corrections []string) {
+	entries := []storage.AnalyticsEntry{}
+	for _, entry := range analysis.Entries {
+		side := "debit"
+		if entry.Credit > entry.Debit {
+			side = "credit"
+		}
+		entries = append(entries, storage.AnalyticsEntry{AccountCode: entry.AccountCode, AccountName: entry.AccountName, Side: side})
+	}
+	outcome := storage.AnalyticsOutcome{
+		Status:            storage.AnalyticsOutcomeApproved,
+		Corrections:       corrections,
+		Entries:           entries,
+		ApprovedRequestID: record.RequestID,
+	}
+	if len(corrections) > 0 {
+		outcome.Status = storage.AnalyticsOutcomeCorrected
+	}
+
+	// Write in background - must not delay the approval
+	go func() {
+		if err := storage.RecordAnalyticsOutcome(record.RequestID, outcome); err != nil {
+			log.Printf("⚠️  Failed to record template outcome of %s: %v", record.RequestID, err)
+		}
+		// Re-analysis approved → the original analysis (with the template decision of analyze-receipt) was not good enough
+		if record.ParentRequestID != "" {
+			parent := outcome
+			parent.Status = storage.AnalyticsOutcomeReanalyzed
+			if err := storage.RecordAnalyticsOutcome(record.ParentRequestID, parent); err != nil {
+				log.Printf("⚠️  Failed to record template outcome of %s: %v", record.ParentRequestID, err)
+			}
+		}
+	}()
+}
+
+// TemplateThresholdReportHandler handles GET /api/v1/shops/:shopid/template-threshold-report?days=90&fp_weight=2
+func TemplateThresholdReportHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	days := defaultThresholdReportDays
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid days",
+				"message": "days ต้องเป็นตัวเลขตั้งแต่ 1 ขึ้นไป",
+			})
+			return
+		}
+		days = n
+	}
+	fpWeight := defaultThresholdFPWeight
+	if v := c.Query("fp_weight"); v != "" {
+		weight, err := strconv.ParseFloat(v, 64)
+		if err != nil || weight <= 0 || weight > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "invalid fp_weight",
+				"message": "fp_weight ต้องเป็นตัวเลขมากกว่า 0 และไม่เกิน 100",
+			})
+			return
+		}
+		fpWeight = weight
+	}
+	since := time.Now().AddDate(0, 0, -days)
+
+	// Step 1: Current threshold (shop override or global) and the shop's templates
+	settings, err := storage.GetShopSettings(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load shop settings",
+			"details": err.Error(),
+		})
+		return
+	}
+	reqCtx := common.NewRequestContext(shopID)
+	applyShopSettings(reqCtx, settings)
+	current := reqCtx.Settings.TemplateConfidenceThreshold
+
+	documentTemplates, err := dataStore.ListDocumentTemplates(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load document templates",
+			"details": err.Error(),
+		})
+		return
+	}
+	templates := map[string]bson.M{}
+	for _, t := range documentTemplates {
+		templates[templateIDString(t["_id"])] = t
+	}
+
+	// Step 2: Approved documents with a template candidate
+	records, err := storage.GetLabeledTemplateDecisions(c.Request.Context(), shopID, since, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load template decisions",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Step 3: Template correct = approved accounts are the template's accounts
+	report := TemplateThresholdReport{ShopID: shopID, Since: since, CurrentThreshold: current, FPWeight: fpWeight, Templates: []TemplateThresholdRow{}}
+	samples := []processor.ThresholdSample{}
+	rows := map[string]*TemplateThresholdRow{}
+	var order []string
+	sums := map[string][2]float64{} // confidence sum when correct / incorrect
+	for _, record := range records {
+		template, ok := templates[record.TemplateID]
+		if !ok || record.Outcome == nil {
+			report.SkippedSamples++
+			continue
+		}
+		var codes []string
+		for _, entry := range record.Outcome.Entries {
+			codes = append(codes, entry.AccountCode)
+		}
+		sample := processor.ThresholdSample{Confidence: record.TemplateConfidence, TemplateCorrect: processor.TemplateFitsAccounts(template, codes)}
+		samples = append(samples, sample)
+
+		row := rows[record.TemplateID]
+		if row == nil {
+			row = &TemplateThresholdRow{TemplateID: record.TemplateID, TemplateName: record.TemplateName}
+			rows[record.TemplateID] = row
+			order = append(order, record.TemplateID)
+		}
+		row.Samples++
+		sum := sums[record.TemplateID]
+		used := sample.Confidence >= current
+		switch {
+		case sample.TemplateCorrect:
+			row.Correct++
+			sum[0] += sample.Confidence
+			if !used {
+				row.FalseNegatives++
+			}
+		default:
+			sum[1] += sample.Confidence
+			if used {
+				row.FalsePositives++
+			}
+		}
+		sums[record.TemplateID] = sum
+	}
+	for _, id := range order {
+		row := rows[id]
+		if row.Correct > 0 {
+			row.AvgConfidenceCorrect = math.Round(sums[id][0]/float64(row.Correct)*10) / 10
+		}
+		if incorrect := row.Samples - row.Correct; incorrect > 0 {
+			row.AvgConfidenceIncorrect = math.Round(sums[id][1]/float64(incorrect)*10) / 10
+		}
+		report.Templates = append(report.Templates, *row)
+	}
+	report.Recommendation = processor.RecommendTemplateThreshold(samples, current, fpWeight)
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,46 @@
+// template_validation.go - Validate a documentFormate template before it is saved or tested
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TemplateValidationResponse is the validation result of a template for a shop
+type TemplateValidationResponse struct {
+	ShopID string `json:"shopid"`
+	processor.TemplateValidationResult
+}
+
+// ValidateTemplateHandler handles POST /api/v1/shops/:shopid/templates/validate
+// Body = template in documentFormate format (description, promptdescription, details[].accountcode/detail/side, journalbookcode)
+func ValidateTemplateHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	var template map[string]interface{}
+	if err := c.ShouldBindJSON(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid template JSON",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	masterCache, err := dataStore.GetOrLoadMasterData(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to load master data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, TemplateValidationResponse{
+		ShopID:                   shopID,
+		TemplateValidationResult: processor.ValidateTemplate(bson.M(template), masterCache.Accounts, masterCache.JournalBooks),
+	})
+}
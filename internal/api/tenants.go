@@ -0,0 +1,103 @@
+// tenants.go - Multi-tenant MongoDB routing (TENANT_ROUTING_ENABLED)
+//
+// shopid → tenant database ตาม routing table (collection tenantRoutes) - reload ทุก TENANT_ROUTES_REFRESH_SEC
+// X-Tenant-ID: ระบุ tenant สำหรับ endpoint ที่ไม่มี shopid (ค้น request_id เฉพาะ tenant นั้น)
+// และยืนยัน tenant ของ shopid (ไม่ตรงกัน = error)
+
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TenantHeader selects the tenant of a request
+const TenantHeader = "X-Tenant-ID"
+
+// tenantRouter is set when TENANT_ROUTING_ENABLED (nil = single database)
+var tenantRouter *storage.TenantRouter
+
+// TenantRoutesResponse is returned by GET /api/v1/admin/tenants
+type TenantRoutesResponse struct {
+	Enabled bool                  `json:"enabled"`
+	Tenants []storage.TenantRoute `json:"tenants"`
+}
+
+// InitStore sets up the handlers' storage on the main database: with TENANT_ROUTING_ENABLED
+// shops are routed to their tenant database (routing table loaded now and kept in sync)
+// Returns the function that closes the tenant connections
+func InitStore(main *storage.MongoStore) func() {
+	if !configs.TENANT_ROUTING_ENABLED {
+		UseStore(main)
+		return func() {}
+	}
+
+	router := storage.NewTenantRouter(main)
+	if err := router.Refresh(context.Background()); err != nil {
+		log.Printf("⚠️  Failed to load tenant routes (every shop uses the main database until the next refresh): %v", err)
+	} else {
+		log.Printf("🏢 Tenant routing enabled: %d tenant(s)", len(router.Routes()))
+	}
+	tenantRouter = router
+	UseStore(router)
+
+	if configs.TENANT_ROUTES_REFRESH_SEC > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Duration(configs.TENANT_ROUTES_REFRESH_SEC) * time.Second)
+			defer ticker.Stop()
+			for range ticker.C {
+				if err := router.Refresh(context.Background()); err != nil {
+					log.Printf("⚠️  Failed to refresh tenant routes (keeping current routes): %v", err)
+				}
+			}
+		}()
+	}
+	return router.Close
+}
+
+// TenantMiddleware puts the X-Tenant-ID of the request into the request context
+// Unknown tenant → 400 (the header is ignored when tenant routing is disabled)
+func TenantMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID := c.GetHeader(TenantHeader)
+		if tenantID == "" || tenantRouter == nil {
+			c.Next()
+			return
+		}
+		if !tenantRouter.HasTenant(tenantID) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "unknown_tenant",
+				Message: "Tenant " + tenantID + " is not in the routing table",
+			})
+			return
+		}
+		c.Request = c.Request.WithContext(storage.WithTenant(c.Request.Context(), tenantID))
+		c.Next()
+	}
+}
+
+// TenantRoutesHandler handles GET /api/v1/admin/tenants (connection URIs are never returned)
+func TenantRoutesHandler(c *gin.Context) {
+	response := TenantRoutesResponse{Enabled: tenantRouter != nil, Tenants: []storage.TenantRoute{}}
+	if tenantRouter != nil {
+		response.Tenants = tenantRouter.Routes()
+		sort.Slice(response.Tenants, func(i, j int) bool { return response.Tenants[i].TenantID < response.Tenants[j].TenantID })
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// documentDatabases returns every database that holds document data (retention purge)
+func documentDatabases() []*mongo.Database {
+	if tenantRouter != nil {
+		return tenantRouter.Databases()
+	}
+	return []*mongo.Database{storage.GetMongoDB()}
+}
@@ -0,0 +1,70 @@
+// traces.go - Persist and serve recorded AI interactions (ENABLE_AI_TRACES=true)
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// saveAITraces stores the AI interactions recorded during a request
+// Called (deferred) at the end of every AI endpoint - failed requests are the ones most worth debugging
+func saveAITraces(reqCtx *common.RequestContext, endpoint string) {
+	traces := reqCtx.GetTraces()
+	if len(traces) == 0 {
+		return
+	}
+
+	// Write in background - debugging data must not delay the response
+	go func() {
+		if err := storage.SaveAITraces(reqCtx.RequestID, reqCtx.ShopID, endpoint, traces); err != nil {
+			reqCtx.LogWarning("Failed to save AI traces: %v", err)
+		}
+	}()
+}
+
+// AITracesHandler handles GET /api/v1/results/:request_id/traces[?phase=accounting]
+// Returns the exact prompt, system instruction, schema and raw response of each AI call
+func AITracesHandler(c *gin.Context) {
+	requestID := c.Param("request_id")
+
+	record, err := storage.GetAITraces(c.Request.Context(), requestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load AI traces",
+			"details": err.Error(),
+		})
+		return
+	}
+	if record == nil {
+		message := "ไม่พบ trace ของ request นี้ (อาจหมดอายุแล้ว หรือ request_id ไม่ถูกต้อง)"
+		if !configs.Get().EnableAITraces {
+			message = "AI trace recording is disabled (set ENABLE_AI_TRACES=true)"
+		}
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":      "traces not found",
+			"message":    message,
+			"request_id": requestID,
+		})
+		return
+	}
+	if rejectForeignShop(c, record.ShopID) {
+		return
+	}
+
+	if phase := c.Query("phase"); phase != "" {
+		filtered := []common.AITrace{}
+		for _, trace := range record.Traces {
+			if trace.Phase == phase {
+				filtered = append(filtered, trace)
+			}
+		}
+		record.Traces = filtered
+	}
+
+	writeLargeJSON(c, http.StatusOK, record)
+}
@@ -0,0 +1,31 @@
+// vat_rate.go - VAT rate of a document and the VAT math check (see processor/vat_check.go)
+
+package api
+
+import (
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+)
+
+// documentVATRate returns the rate in force for the document: by its date, or the shop's own rate for its sales
+func documentVATRate(receipt, accountingEntry map[string]interface{}, settings common.RequestSettings) float64 {
+	sale := processor.DetectDirection(accountingEntry) == processor.DirectionSale
+	return settings.VATRateOn(normalizeDocumentDate(getStringValue(receipt, "date")), sale)
+}
+
+// vatMathCheck validates receipt.vat against the document's VAT rate (nil = no total / VAT to check)
+func vatMathCheck(receipt, accountingEntry map[string]interface{}, settings common.RequestSettings, reqCtx *common.RequestContext) *processor.VATMathCheck {
+	check := processor.CheckVATMath(receipt, documentVATRate(receipt, accountingEntry, settings))
+	if check != nil && !check.Agreed && reqCtx != nil {
+		reqCtx.LogWarning("⚠️  VAT check: %s", check.Message)
+	}
+	return check
+}
+
+// normalizeDocumentDate keeps YYYY-MM-DD (ignores a time part)
+func normalizeDocumentDate(date string) string {
+	if len(date) > 10 {
+		return date[:10]
+	}
+	return date
+}
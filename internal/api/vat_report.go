@@ -0,0 +1,313 @@
+// vat_report.go - Input VAT report (รายงานภาษีซื้อ) for ภ.พ.30 filing
+//
+// รวมเอกสารซื้อที่วิเคราะห์แล้วในเดือนภาษี (ตามวันที่เอกสาร) → ผู้ขาย, เลขผู้เสียภาษี, สาขา, เลขที่ใบกำกับ, มูลค่า, ภาษี
+// ใช้ผลที่เก็บใน ocrResults (ผล approve แล้วใช้ค่าที่แก้ไข) - re-analyze ของเอกสารเดียวกันนับครั้งเดียว
+
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// InputVATRow is one purchase tax invoice of the report
+type InputVATRow struct {
+	No             int          `json:"no"`
+	DocumentDate   string       `json:"document_date"` // YYYY-MM-DD
+	InvoiceNumber  string       `json:"invoice_number"`
+	VendorName     string       `json:"vendor_name"`
+	VendorTaxID    string       `json:"vendor_tax_id"`
+	VendorBranch   string       `json:"vendor_branch"` // 5 digits, 00000 = head office ("" = not on the document)
+	CreditorCode   string       `json:"creditor_code,omitempty"`
+	Base           money.Amount `json:"base"`             // มูลค่าสินค้า/บริการ (total - VAT) - เอกสารหลายฐานภาษีเฉพาะส่วนที่เสีย VAT
+	Exempt         money.Amount `json:"exempt,omitempty"` // มูลค่ายกเว้น VAT / อัตรา 0% (เอกสารหลายฐานภาษี)
+	VAT            money.Amount `json:"vat"`
+	VATRate        float64      `json:"vat_rate"` // Rate in force on the document date (VAT_RATE / VAT_RATE_HISTORY)
+	Total          money.Amount `json:"total"`
+	RequestID      string       `json:"request_id"`
+	Status         string       `json:"status"` // draft / final
+	RequiresReview bool         `json:"requires_review"`
+	MissingFields  []string     `json:"missing_fields,omitempty"` // Required for filing but not found on the document
+}
+
+// InputVATTotals sums the rows of the report
+type InputVATTotals struct {
+	Documents int          `json:"documents"`
+	Base      money.Amount `json:"base"`
+	Exempt    money.Amount `json:"exempt"`
+	VAT       money.Amount `json:"vat"`
+	Total     money.Amount `json:"total"`
+}
+
+// InputVATReport is the response of GET /api/v1/shops/:shopid/reports/input-vat
+type InputVATReport struct {
+	ShopID     string         `json:"shopid"`
+	Period     string         `json:"period"` // YYYY-MM
+	Rows       []InputVATRow  `json:"rows"`
+	Totals     InputVATTotals `json:"totals"`
+	Incomplete int            `json:"incomplete"` // Rows with missing_fields or still draft
+}
+
+// validTaxID - Thai tax IDs have 13 digits (dashes / spaces allowed)
+func validTaxID(taxID string) bool {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, taxID)
+	return len(digits) == 13
+}
+
+// latestOCRResults keeps one result per document: the approved one, else the newest re-analysis
+// records must be ordered oldest first
+func latestOCRResults(records []storage.StoredOCRResult) []storage.StoredOCRResult {
+	byID := make(map[string]storage.StoredOCRResult, len(records))
+	for _, record := range records {
+		byID[record.RequestID] = record
+	}
+	rootOf := func(record storage.StoredOCRResult) string {
+		root := record.RequestID
+		for parent := record.ParentRequestID; parent != ""; {
+			root = parent
+			next, ok := byID[parent]
+			if !ok {
+				break
+			}
+			parent = next.ParentRequestID
+		}
+		return root
+	}
+
+	latest := map[string]storage.StoredOCRResult{}
+	order := []string{}
+	for _, record := range records {
+		root := rootOf(record)
+		current, seen := latest[root]
+		if !seen {
+			order = append(order, root)
+		}
+		if !seen || current.Status != storage.OCRResultStatusFinal {
+			latest[root] = record
+		}
+	}
+	results := make([]storage.StoredOCRResult, 0, len(order))
+	for _, root := range order {
+		results = append(results, latest[root])
+	}
+	return results
+}
+
+// taxReportPeriod reads ?period=YYYY-MM (default: previous month, the month being filed)
+// false = 400 already sent
+func taxReportPeriod(c *gin.Context) (string, bool) {
+	period := c.Query("period")
+	if period == "" {
+		period = time.Now().AddDate(0, -1, 0).Format("2006-01")
+	}
+	if _, err := time.Parse("2006-01", period); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid period",
+			"message": "period ต้องอยู่ในรูปแบบ YYYY-MM",
+		})
+		return "", false
+	}
+	return period, true
+}
+
+// taxReportResults loads one stored result per document dated in the tax month
+// false = error response already sent
+func taxReportResults(c *gin.Context, shopID, period string) ([]storage.StoredOCRResult, bool) {
+	month, _ := time.Parse("2006-01", period)
+	return reportResults(c, shopID, month, month.AddDate(0, 1, 0))
+}
+
+// reportResults loads one stored result per document dated in [from, to)
+// false = error response already sent
+func reportResults(c *gin.Context, shopID string, from, to time.Time) ([]storage.StoredOCRResult, bool) {
+	if configs.OCR_RESULT_TTL_DAYS <= 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "report disabled",
+			"message": "OCR result storage is disabled (set OCR_RESULT_TTL_DAYS > 0)",
+		})
+		return nil, false
+	}
+	records, err := dataStore.ListOCRResultsByDocumentDate(c.Request.Context(), shopID,
+		from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load documents",
+			"details": err.Error(),
+		})
+		return nil, false
+	}
+	return latestOCRResults(records), true
+}
+
+// inputVATRow builds the report row of a result (false = not a purchase with VAT)
+func inputVATRow(record storage.StoredOCRResult) (InputVATRow, bool) {
+	analysis := record.Analysis
+	if analysis == nil || analysis.DebtorCode != "" {
+		return InputVATRow{}, false // Sales documents go to the output VAT report
+	}
+	receipt := analysis.Receipt
+	vat, vatOK := money.Parse(receipt["vat"])
+	if vatOK && vat == 0 {
+		return InputVATRow{}, false
+	}
+	total, totalOK := money.Parse(receipt["total"])
+
+	row := InputVATRow{
+		DocumentDate:   analysis.DocumentDate,
+		InvoiceNumber:  analysis.ReferenceNumber,
+		VendorName:     analysis.CreditorName,
+		VendorTaxID:    getStringValue(receipt, "vendor_tax_id"),
+		VendorBranch:   analysis.CreditorBranch,
+		CreditorCode:   analysis.CreditorCode,
+		VAT:            vat,
+		Total:          total,
+		RequestID:      record.RequestID,
+		Status:         storage.OCRResultStatusDraft,
+		RequiresReview: analysis.RequiresReview || !vatOK || !totalOK, // Unreadable amount (e.g. "12-15") = check the document
+	}
+	if record.Status == storage.OCRResultStatusFinal {
+		row.Status = storage.OCRResultStatusFinal
+	}
+	if record.Summary != nil {
+		if row.DocumentDate == "" {
+			row.DocumentDate = record.Summary.DocumentDate
+		}
+		if row.InvoiceNumber == "" {
+			row.InvoiceNumber = record.Summary.DocumentNumber
+		}
+	}
+	if row.VendorName == "" {
+		row.VendorName = getStringValue(receipt, "vendor_name")
+	}
+	if row.VendorBranch == "" {
+		row.VendorBranch = getStringValue(receipt, "vendor_branch")
+	}
+
+	row.VATRate = configs.Get().VATRateOn(normalizeDocumentDate(row.DocumentDate))
+	if breakdown := processor.ReadVATBreakdown(receipt); breakdown.IsMixed() {
+		row.Base, row.Exempt = breakdown.TaxableBase(), breakdown.NonTaxable()
+		if row.Total == 0 {
+			row.MissingFields = append(row.MissingFields, "total")
+		}
+	} else if row.Total != 0 {
+		row.Base = row.Total - row.VAT
+	} else {
+		if row.VATRate > 0 {
+			row.Base = money.Amount(math.Round(float64(row.VAT) * 100 / row.VATRate))
+		}
+		row.MissingFields = append(row.MissingFields, "total")
+	}
+	if !validTaxID(row.VendorTaxID) {
+		row.MissingFields = append(row.MissingFields, "vendor_tax_id")
+	}
+	if row.InvoiceNumber == "" {
+		row.MissingFields = append(row.MissingFields, "invoice_number")
+	}
+	if row.VendorBranch == "" {
+		row.MissingFields = append(row.MissingFields, "vendor_branch")
+	}
+	return row, true
+}
+
+// writeInputVATCSV writes the report as CSV (UTF-8 with BOM so Excel shows Thai text)
+func writeInputVATCSV(c *gin.Context, report InputVATReport) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="input-vat-%s-%s.csv"`, report.ShopID, report.Period))
+	c.Status(http.StatusOK)
+
+	c.Writer.WriteString("\uFEFF")
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"ลำดับ", "วันที่", "เลขที่ใบกำกับภาษี", "ชื่อผู้ขาย", "เลขประจำตัวผู้เสียภาษี", "สาขา", "มูลค่าสินค้าหรือบริการ", "จำนวนเงินภาษี", "สถานะ", "request_id"})
+	for _, row := range report.Rows {
+		branch := row.VendorBranch
+		if branch == storage.HeadOfficeBranchCode {
+			branch = "สำนักงานใหญ่"
+		}
+		writer.Write([]string{
+			strconv.Itoa(row.No),
+			row.DocumentDate,
+			row.InvoiceNumber,
+			row.VendorName,
+			row.VendorTaxID,
+			branch,
+			row.Base.String(),
+			row.VAT.String(),
+			row.Status,
+			row.RequestID,
+		})
+	}
+	writer.Write([]string{"", "", "", "รวม", "", "", report.Totals.Base.String(), report.Totals.VAT.String(), "", ""})
+	writer.Flush()
+}
+
+// InputVATReportHandler handles GET /api/v1/shops/:shopid/reports/input-vat?period=2024-06[&format=csv]
+func InputVATReportHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+	period, ok := taxReportPeriod(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid format",
+			"message": "format ต้องเป็น json หรือ csv",
+		})
+		return
+	}
+
+	records, ok := taxReportResults(c, shopID, period)
+	if !ok {
+		return
+	}
+
+	report := InputVATReport{ShopID: shopID, Period: period, Rows: []InputVATRow{}}
+	for _, record := range records {
+		row, ok := inputVATRow(record)
+		if !ok {
+			continue
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	sort.SliceStable(report.Rows, func(i, j int) bool {
+		if report.Rows[i].DocumentDate != report.Rows[j].DocumentDate {
+			return report.Rows[i].DocumentDate < report.Rows[j].DocumentDate
+		}
+		return report.Rows[i].InvoiceNumber < report.Rows[j].InvoiceNumber
+	})
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		row.No = i + 1
+		report.Totals.Documents++
+		report.Totals.Base += row.Base
+		report.Totals.Exempt += row.Exempt
+		report.Totals.VAT += row.VAT
+		report.Totals.Total += row.Total
+		if len(row.MissingFields) > 0 || row.Status != storage.OCRResultStatusFinal {
+			report.Incomplete++
+		}
+	}
+
+	if format == "csv" {
+		writeInputVATCSV(c, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,148 @@
+// vendor_mappings.go - Learned creditor → account mappings (approve / list / forget)
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// VendorMappingApproveRequest records that documents from a creditor go to an account
+type VendorMappingApproveRequest struct {
+	CreditorCode string `json:"creditor_code"`
+	AccountCode  string `json:"account_code"`
+	RequestID    string `json:"request_id,omitempty"` // Analysis the user approved (for tracing)
+}
+
+// VendorMappingsResponse lists the learned mappings of a shop
+type VendorMappingsResponse struct {
+	ShopID   string                         `json:"shopid"`
+	Mappings []storage.VendorAccountMapping `json:"mappings"`
+}
+
+// GetVendorMappingsHandler handles GET /api/v1/shops/:shopid/vendor-mappings
+func GetVendorMappingsHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	mappings, err := storage.GetVendorAccountMappings(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to load vendor mappings",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, VendorMappingsResponse{ShopID: shopID, Mappings: mappings})
+}
+
+// ApproveVendorMappingHandler handles POST /api/v1/shops/:shopid/vendor-mappings
+func ApproveVendorMappingHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	var req VendorMappingApproveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	req.CreditorCode = strings.TrimSpace(req.CreditorCode)
+	req.AccountCode = strings.TrimSpace(req.AccountCode)
+	if req.CreditorCode == "" || req.AccountCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "creditor_code and account_code are required",
+			"message": "กรุณาระบุ creditor_code และ account_code",
+		})
+		return
+	}
+
+	masterCache, err := dataStore.GetOrLoadMasterData(c.Request.Context(), shopID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Failed to load master data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	mapping := storage.VendorAccountMapping{
+		ShopID:        shopID,
+		CreditorCode:  req.CreditorCode,
+		AccountCode:   req.AccountCode,
+		LastRequestID: req.RequestID,
+	}
+	creditorFound := false
+	for _, creditor := range masterCache.Creditors {
+		if code, ok := creditor["code"].(string); ok && code == req.CreditorCode {
+			mapping.CreditorName = extractNameFromNamesArray(creditor)
+			creditorFound = true
+			break
+		}
+	}
+	if !creditorFound {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "creditor not found",
+			"message": "ไม่พบเจ้าหนี้ " + req.CreditorCode + " ในร้าน",
+		})
+		return
+	}
+	accountFound := false
+	for _, acc := range masterCache.Accounts {
+		if code, ok := acc["accountcode"].(string); ok && code == req.AccountCode {
+			mapping.AccountName, _ = acc["accountname"].(string)
+			accountFound = true
+			break
+		}
+	}
+	if !accountFound {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "account not found",
+			"message": "ไม่พบบัญชี " + req.AccountCode + " ในผังบัญชี",
+		})
+		return
+	}
+
+	saved, err := storage.ApproveVendorAccountMapping(mapping)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to save vendor mapping",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Mappings are part of the master data cache - reload on the next request
+	dataStore.InvalidateCache(shopID)
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// DeleteVendorMappingHandler handles DELETE /api/v1/shops/:shopid/vendor-mappings/:creditor_code
+func DeleteVendorMappingHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+	creditorCode := c.Param("creditor_code")
+
+	deleted, err := storage.DeleteVendorAccountMapping(shopID, creditorCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to delete vendor mapping",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "vendor mapping not found",
+			"message": "ไม่พบการจับคู่บัญชีของเจ้าหนี้ " + creditorCode,
+		})
+		return
+	}
+
+	dataStore.InvalidateCache(shopID)
+	c.JSON(http.StatusOK, gin.H{"shopid": shopID, "creditor_code": creditorCode, "deleted": true})
+}
@@ -0,0 +1,212 @@
+// voucher_pdf.go - Printable journal voucher (ใบสำคัญลงบัญชี) of a result (GET /api/v1/results/:request_id/voucher)
+//
+// ผลที่ approve แล้ว → พิมพ์จาก voucher ที่ freeze ไว้ + ชื่อผู้อนุมัติ
+// ผลที่ยังเป็น draft → สร้าง voucher จากผลวิเคราะห์ล่าสุด และพิมพ์ "ร่าง - ยังไม่อนุมัติ" บนหัวเอกสาร
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// voucherSignatures are the signature boxes at the bottom of the voucher
+var voucherSignatures = []string{"ผู้จัดทำ", "ผู้ตรวจสอบ", "ผู้อนุมัติ", "ผู้บันทึกบัญชี"}
+
+// JournalVoucherPDFHandler handles GET /api/v1/results/:request_id/voucher
+func JournalVoucherPDFHandler(c *gin.Context) {
+	requestID := c.Param("request_id")
+
+	// Step 1: Load the stored result (404 / 403 written by loadReanalysisRecord)
+	record := loadReanalysisRecord(c, requestID)
+	if record == nil {
+		return
+	}
+	if record.Analysis == nil && record.Approval == nil {
+		message := "ไม่พบผลวิเคราะห์ของ request นี้ (อาจหมดอายุแล้ว, วิเคราะห์ไม่สำเร็จ หรือ request_id ไม่ถูกต้อง)"
+		if configs.OCR_RESULT_TTL_DAYS <= 0 {
+			message = "OCR result storage is disabled (set OCR_RESULT_TTL_DAYS > 0)"
+		}
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":      "result not found",
+			"message":    message,
+			"request_id": requestID,
+		})
+		return
+	}
+
+	// Step 2: Render
+	pdf, err := renderJournalVoucherPDF(record, resultShopName(c, record.ShopID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to render journal voucher PDF",
+			"details": err.Error(),
+		})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="voucher-%s.pdf"`, record.RequestID))
+	c.Data(http.StatusOK, pdfContentType, pdf)
+}
+
+// resultVoucher returns the frozen voucher of an approved result, or the voucher built from the draft analysis
+func resultVoucher(record *storage.StoredOCRResult) storage.JournalVoucher {
+	if record.Approval != nil {
+		return record.Approval.Voucher
+	}
+	if record.Analysis == nil {
+		return storage.JournalVoucher{RequestID: record.RequestID, ShopID: record.ShopID}
+	}
+	return buildJournalVoucher(record.ShopID, record.RequestID, *record.Analysis)
+}
+
+// renderJournalVoucherPDF renders the ใบสำคัญลงบัญชี of a result (A4, Thai layout)
+func renderJournalVoucherPDF(record *storage.StoredOCRResult, shopName string) ([]byte, error) {
+	doc, err := newPDFDocument()
+	if err != nil {
+		return nil, err
+	}
+	voucher := resultVoucher(record)
+	right := pdfPageWidth - pdfMargin
+	center := pdfPageWidth / 2
+	columns := []float64{pdfMargin, pdfMargin + 80, right - 180, right - 90, right}
+
+	// Step 1: Page header (shop, title, voucher fields) - repeated on every page
+	var y float64
+	drawPageHeader := func() {
+		doc.addPage()
+		y = pdfMargin + 14
+		doc.textCenter(center, y, 14, true, shopName)
+		y += 24
+		doc.textCenter(center, y, 18, true, "ใบสำคัญลงบัญชี")
+		y += 14
+		doc.textCenter(center, y, 9, false, "JOURNAL VOUCHER")
+		if record.Approval == nil {
+			doc.textRight(right, pdfMargin+14, 10, true, "ร่าง - ยังไม่อนุมัติ")
+		}
+		y += 22
+
+		journalBook := strings.TrimSpace(voucher.JournalBookCode + " " + voucher.JournalBookName)
+		partyLabel, party := "เจ้าหนี้", strings.TrimSpace(voucher.CreditorCode+" "+voucher.CreditorName)
+		if party == "" && voucher.DebtorCode != "" {
+			partyLabel, party = "ลูกหนี้", strings.TrimSpace(voucher.DebtorCode+" "+voucher.DebtorName)
+		}
+		vendor := voucher.VendorName
+		if voucher.VendorTaxID != "" {
+			vendor = strings.TrimSpace(vendor + " (เลขผู้เสียภาษี " + voucher.VendorTaxID + ")")
+		}
+		rows := [][4]string{
+			{"สมุดรายวัน", orDash(journalBook), "เลขที่", orDash(voucher.DocumentNumber)},
+			{partyLabel, orDash(party), "วันที่", orDash(thaiDate(voucher.DocumentDate))},
+			{"ผู้ขาย / ผู้ให้บริการ", orDash(vendor), "อ้างอิง", record.RequestID},
+		}
+		for _, row := range rows {
+			doc.text(pdfMargin, y, 9, true, row[0])
+			doc.text(pdfMargin+85, y, 9, false, row[1])
+			doc.text(right-170, y, 9, true, row[2])
+			doc.text(right-125, y, 9, false, row[3])
+			y += 15
+		}
+		y += 4
+
+		doc.rect(pdfMargin, y, right-pdfMargin, 20, 0.6, 0.9)
+		doc.text(columns[0]+4, y+14, 9, true, "รหัสบัญชี")
+		doc.text(columns[1]+4, y+14, 9, true, "ชื่อบัญชี / คำอธิบาย")
+		doc.textRight(columns[3]-4, y+14, 9, true, "เดบิต")
+		doc.textRight(columns[4]-4, y+14, 9, true, "เครดิต")
+		y += 20
+	}
+	drawPageHeader()
+
+	// Step 2: Entries (a new page repeats the header; the signature block needs ~150pt at the end)
+	tableTop := y
+	drawColumns := func(bottom float64) {
+		for _, x := range columns {
+			doc.line(x, tableTop, x, bottom, 0.6)
+		}
+		doc.line(pdfMargin, bottom, right, bottom, 0.6)
+	}
+	for _, line := range voucher.Lines {
+		nameWidth := columns[2] - columns[1] - 8
+		nameLines := doc.wrap(line.AccountName, 9, false, nameWidth)
+		var descriptionLines []string
+		if line.Description != "" {
+			descriptionLines = doc.wrap(line.Description, 8, false, nameWidth)
+		}
+		height := float64(len(nameLines))*12 + float64(len(descriptionLines))*11 + 6
+		if y+height > pdfPageHeight-pdfMargin-20 {
+			drawColumns(y)
+			drawPageHeader()
+			tableTop = y
+		}
+		rowTop := y
+		doc.text(columns[0]+4, y+13, 9, false, line.AccountCode)
+		if line.Debit != 0 {
+			doc.textRight(columns[3]-4, y+13, 9, false, money.FromBaht(line.Debit).Thousands())
+		}
+		if line.Credit != 0 {
+			doc.textRight(columns[4]-4, y+13, 9, false, money.FromBaht(line.Credit).Thousands())
+		}
+		for k, text := range nameLines {
+			doc.text(columns[1]+4, y+13+float64(k)*12, 9, false, text)
+		}
+		for k, text := range descriptionLines {
+			doc.text(columns[1]+10, y+13+float64(len(nameLines))*12+float64(k)*11, 8, false, text)
+		}
+		y = rowTop + height
+	}
+
+	// Step 3: Totals (amount in Thai words)
+	if y+20+150 > pdfPageHeight-pdfMargin {
+		drawColumns(y)
+		drawPageHeader()
+		tableTop = y
+	}
+	totalDebit, totalCredit := money.FromBaht(voucher.TotalDebit), money.FromBaht(voucher.TotalCredit)
+	doc.line(pdfMargin, y, right, y, 0.6)
+	doc.rect(pdfMargin, y, right-pdfMargin, 20, 0, 0.95)
+	doc.text(columns[0]+4, y+14, 9, true, "รวม")
+	doc.text(columns[1]+4, y+14, 9, true, "("+totalDebit.ThaiText()+")")
+	doc.textRight(columns[3]-4, y+14, 9, true, totalDebit.Thousands())
+	doc.textRight(columns[4]-4, y+14, 9, true, totalCredit.Thousands())
+	y += 20
+	drawColumns(y)
+	doc.line(columns[2], y+2, right, y+2, 0.6)
+	if totalDebit != totalCredit {
+		y += 16
+		doc.text(pdfMargin, y, 9, true, fmt.Sprintf("⚠ เดบิตไม่เท่ากับเครดิต (ต่างกัน %s)", (totalDebit-totalCredit).Abs().Thousands()))
+	}
+
+	// Step 4: Signature lines (approver filled in from the approval)
+	y += 60
+	width := (right - pdfMargin) / float64(len(voucherSignatures))
+	for i, title := range voucherSignatures {
+		middle := pdfMargin + width*(float64(i)+0.5)
+		doc.line(middle-width/2+12, y, middle+width/2-12, y, 0.5)
+		name, date := "(.......................................)", "วันที่ ....../....../......"
+		if title == "ผู้อนุมัติ" && record.Approval != nil {
+			if record.Approval.ApprovedBy != "" {
+				name = "(" + record.Approval.ApprovedBy + ")"
+			}
+			date = "วันที่ " + thaiDate(record.Approval.ApprovedAt.In(thaiTime).Format("2006-01-02"))
+		}
+		doc.textCenter(middle, y+14, 9, false, name)
+		doc.textCenter(middle, y+28, 9, true, title)
+		doc.textCenter(middle, y+42, 8, false, date)
+	}
+
+	// Footer on every page
+	printed := time.Now().In(thaiTime).Format("02/01/2006 15:04")
+	for i, page := range doc.pages {
+		doc.page = page
+		doc.text(pdfMargin, pdfPageHeight-pdfMargin+10, 7, false, "พิมพ์เมื่อ "+printed)
+		doc.textRight(right, pdfPageHeight-pdfMargin+10, 7, false, fmt.Sprintf("หน้า %d/%d", i+1, len(doc.pages)))
+	}
+	return doc.bytes("ใบสำคัญลงบัญชี " + record.RequestID), nil
+}
@@ -0,0 +1,221 @@
+// wht_report.go - Withholding tax report (ภ.ง.ด.3 / ภ.ง.ด.53) from stored accounting entries
+//
+// เอกสารที่มีรายการเครดิต "ภาษีหัก ณ ที่จ่าย" ในเดือนภาษี → ผู้มีเงินได้, เลขผู้เสียภาษี, ประเภทเงินได้, ยอดจ่าย, ภาษีที่หัก
+// ผู้มีเงินได้เป็นนิติบุคคล (เลขผู้เสียภาษีขึ้นต้นด้วย 0) → ภ.ง.ด.53, บุคคลธรรมดา → ภ.ง.ด.3
+
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// WithholdingTaxRow is one payment with tax withheld
+type WithholdingTaxRow struct {
+	No             int          `json:"no"`
+	Form           string       `json:"form"` // pnd3 / pnd53 ("" = payee tax ID unknown)
+	PaymentDate    string       `json:"payment_date"`
+	PayeeName      string       `json:"payee_name"`
+	PayeeTaxID     string       `json:"payee_tax_id"`
+	PayeeBranch    string       `json:"payee_branch,omitempty"`
+	IncomeType     string       `json:"income_type"` // Expense account of the payment
+	Rate           float64      `json:"rate"`        // Percent
+	Amount         money.Amount `json:"amount"`      // Amount paid before tax (VAT excluded)
+	Withheld       money.Amount `json:"withheld"`
+	DocumentNumber string       `json:"document_number"`
+	CreditorCode   string       `json:"creditor_code,omitempty"`
+	RequestID      string       `json:"request_id"`
+	Status         string       `json:"status"` // draft / final
+	MissingFields  []string     `json:"missing_fields,omitempty"`
+}
+
+// WithholdingTaxTotals sums the rows of one form
+type WithholdingTaxTotals struct {
+	Documents int          `json:"documents"`
+	Amount    money.Amount `json:"amount"`
+	Withheld  money.Amount `json:"withheld"`
+}
+
+// WithholdingTaxReport is the response of GET /api/v1/shops/:shopid/reports/withholding-tax
+type WithholdingTaxReport struct {
+	ShopID     string                          `json:"shopid"`
+	Period     string                          `json:"period"`
+	Form       string                          `json:"form,omitempty"` // Filter (empty = all forms)
+	Rows       []WithholdingTaxRow             `json:"rows"`
+	Totals     map[string]WithholdingTaxTotals `json:"totals"` // Per form (unknown = payee tax ID missing)
+	Incomplete int                             `json:"incomplete"`
+}
+
+// withholdingTaxRow builds the report row of a result (false = no tax withheld by the shop)
+func withholdingTaxRow(record storage.StoredOCRResult) (WithholdingTaxRow, bool) {
+	analysis := record.Analysis
+	if analysis == nil || analysis.DebtorCode != "" {
+		return WithholdingTaxRow{}, false // Tax withheld by customers is prepaid tax, not filed by the shop
+	}
+	wht, ok := processor.ExtractWithholdingTax(analysis.Entries)
+	if !ok {
+		return WithholdingTaxRow{}, false
+	}
+
+	receipt := analysis.Receipt
+	row := WithholdingTaxRow{
+		PaymentDate:    analysis.DocumentDate,
+		PayeeName:      analysis.CreditorName,
+		PayeeTaxID:     getStringValue(receipt, "vendor_tax_id"),
+		PayeeBranch:    analysis.CreditorBranch,
+		IncomeType:     wht.IncomeType,
+		Rate:           wht.Rate,
+		Amount:         wht.Base,
+		Withheld:       wht.Withheld,
+		DocumentNumber: analysis.ReferenceNumber,
+		CreditorCode:   analysis.CreditorCode,
+		RequestID:      record.RequestID,
+		Status:         storage.OCRResultStatusDraft,
+	}
+	if record.Status == storage.OCRResultStatusFinal {
+		row.Status = storage.OCRResultStatusFinal
+	}
+	if record.Summary != nil {
+		if row.PaymentDate == "" {
+			row.PaymentDate = record.Summary.DocumentDate
+		}
+		if row.DocumentNumber == "" {
+			row.DocumentNumber = record.Summary.DocumentNumber
+		}
+	}
+	if row.PayeeName == "" {
+		row.PayeeName = getStringValue(receipt, "vendor_name")
+	}
+	if row.PayeeBranch == "" {
+		row.PayeeBranch = getStringValue(receipt, "vendor_branch")
+	}
+	row.Form = processor.WithholdingTaxForm(row.PayeeTaxID, row.PayeeName)
+
+	if !validTaxID(row.PayeeTaxID) {
+		row.MissingFields = append(row.MissingFields, "payee_tax_id")
+	}
+	if row.Amount <= 0 {
+		row.MissingFields = append(row.MissingFields, "amount")
+	}
+	return row, true
+}
+
+// withholdingTaxTable is the export layout (header + rows + total line)
+func withholdingTaxTable(report WithholdingTaxReport) [][]interface{} {
+	table := [][]interface{}{{"ลำดับ", "แบบ", "เลขประจำตัวผู้เสียภาษี", "สาขา", "ชื่อผู้มีเงินได้", "วันที่จ่าย", "ประเภทเงินได้", "อัตราภาษี (%)", "จำนวนเงินที่จ่าย", "ภาษีที่หักและนำส่ง", "เลขที่เอกสาร", "สถานะ", "request_id"}}
+	formNames := map[string]string{processor.WithholdingFormPND3: "ภ.ง.ด.3", processor.WithholdingFormPND53: "ภ.ง.ด.53"}
+	var amount, withheld money.Amount
+	for _, row := range report.Rows {
+		table = append(table, []interface{}{row.No, formNames[row.Form], row.PayeeTaxID, row.PayeeBranch, row.PayeeName, row.PaymentDate,
+			row.IncomeType, row.Rate, row.Amount, row.Withheld, row.DocumentNumber, row.Status, row.RequestID})
+		amount, withheld = amount+row.Amount, withheld+row.Withheld
+	}
+	return append(table, []interface{}{"", "", "", "", "รวม", "", "", "", amount, withheld, "", "", ""})
+}
+
+// WithholdingTaxReportHandler handles GET /api/v1/shops/:shopid/reports/withholding-tax?period=2024-06[&form=pnd53][&format=csv|xlsx]
+func WithholdingTaxReportHandler(c *gin.Context) {
+	shopID := c.Param("shopid")
+
+	period, ok := taxReportPeriod(c)
+	if !ok {
+		return
+	}
+	form := c.Query("form")
+	if form != "" && form != processor.WithholdingFormPND3 && form != processor.WithholdingFormPND53 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid form",
+			"message": "form ต้องเป็น pnd3 หรือ pnd53",
+		})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" && format != "xlsx" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid format",
+			"message": "format ต้องเป็น json, csv หรือ xlsx",
+		})
+		return
+	}
+
+	records, ok := taxReportResults(c, shopID, period)
+	if !ok {
+		return
+	}
+
+	report := WithholdingTaxReport{ShopID: shopID, Period: period, Form: form, Rows: []WithholdingTaxRow{}, Totals: map[string]WithholdingTaxTotals{}}
+	for _, record := range records {
+		row, ok := withholdingTaxRow(record)
+		if !ok || (form != "" && row.Form != form) {
+			continue
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	sort.SliceStable(report.Rows, func(i, j int) bool {
+		if report.Rows[i].PaymentDate != report.Rows[j].PaymentDate {
+			return report.Rows[i].PaymentDate < report.Rows[j].PaymentDate
+		}
+		return report.Rows[i].DocumentNumber < report.Rows[j].DocumentNumber
+	})
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		row.No = i + 1
+		key := row.Form
+		if key == "" {
+			key = "unknown"
+		}
+		totals := report.Totals[key]
+		totals.Documents++
+		totals.Amount += row.Amount
+		totals.Withheld += row.Withheld
+		report.Totals[key] = totals
+		if len(row.MissingFields) > 0 || row.Form == "" || row.Status != storage.OCRResultStatusFinal {
+			report.Incomplete++
+		}
+	}
+
+	filename := fmt.Sprintf("withholding-tax-%s-%s", shopID, period)
+	if form != "" {
+		filename += "-" + form
+	}
+	switch format {
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+		c.Status(http.StatusOK)
+		c.Writer.WriteString("\uFEFF")
+		writer := csv.NewWriter(c.Writer)
+		for _, line := range withholdingTaxTable(report) {
+			record := make([]string, len(line))
+			for i, value := range line {
+				switch v := value.(type) {
+				case money.Amount:
+					record[i] = v.String()
+				case float64:
+					record[i] = strconv.FormatFloat(v, 'f', 2, 64)
+				default:
+					record[i] = fmt.Sprint(v)
+				}
+			}
+			writer.Write(record)
+		}
+		writer.Flush()
+	case "xlsx":
+		c.Header("Content-Type", xlsxContentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, filename))
+		c.Status(http.StatusOK)
+		if err := writeXLSX(c.Writer, "withholding-tax", withholdingTaxTable(report)); err != nil {
+			c.Error(err)
+		}
+	default:
+		c.JSON(http.StatusOK, report)
+	}
+}
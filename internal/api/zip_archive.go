@@ -0,0 +1,484 @@
+// zip_archive.go - Analyze every document of a zip archive (monthly scans from the accountant)
+//
+// POST /api/v1/analyze-zip รับ zip ได้ 2 แบบ:
+//   - multipart/form-data: file (zip) + shopid + model
+//   - JSON: {"shopid", "model", "zip_url"} → ดาวน์โหลด zip
+// แตกไฟล์ jpg / png / pdf ทุกไฟล์ (ข้ามโฟลเดอร์ระบบ / ไฟล์ที่ไม่รองรับ) แล้ววิเคราะห์ทีละไฟล์ด้วย analyze-receipt
+// (1 ไฟล์ = 1 เอกสาร, documentimageguid = ชื่อไฟล์ใน zip) และสรุปผลรวมทั้ง zip
+
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// archiveURIScheme - imageuri of a file extracted from a zip (resolved in-process, never downloaded)
+const archiveURIScheme = "archive://"
+
+// archiveFiles maps archive://<batch>/<index> to the extracted file while the batch is analyzed
+var archiveFiles sync.Map
+
+// zipSupportedExtensions are the files analyzed inside an archive (same as analyze-receipt)
+var zipSupportedExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".pdf": true}
+
+// ZipAnalysisRequest is the JSON body of POST /api/v1/analyze-zip (multipart: "file" instead of zip_url)
+type ZipAnalysisRequest struct {
+	ShopID     string  `json:"shopid" form:"shopid"`
+	Model      string  `json:"model,omitempty" form:"model"` // OCR provider: "gemini" (default) or "mistral"
+	ZipURL     string  `json:"zip_url" form:"zip_url"`
+	MaxCostTHB float64 `json:"max_cost_thb,omitempty" form:"max_cost_thb"` // Budget per document (analyze-receipt max_cost_thb)
+}
+
+// ZipDocumentResult is the outcome of one file of the archive
+type ZipDocumentResult struct {
+	File           string  `json:"file"` // Path inside the zip (documentimageguid of the analysis)
+	Status         string  `json:"status"`
+	StatusCode     int     `json:"status_code"`
+	RequestID      string  `json:"request_id,omitempty"`
+	DocumentNumber string  `json:"document_number,omitempty"`
+	DocumentDate   string  `json:"document_date,omitempty"`
+	VendorName     string  `json:"vendor_name,omitempty"`
+	Total          float64 `json:"total,omitempty"`
+	RequiresReview bool    `json:"requires_review,omitempty"`
+	CostTHB        float64 `json:"cost_thb"`
+	DurationSec    float64 `json:"duration_sec"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// ZipSkippedEntry is a zip entry that was not analyzed
+type ZipSkippedEntry struct {
+	File   string `json:"file"`
+	Reason string `json:"reason"`
+}
+
+// ZipAnalysisResponse is the consolidated summary of an archive
+type ZipAnalysisResponse struct {
+	BatchID        string              `json:"batch_id"`
+	ShopID         string              `json:"shopid"`
+	Archive        string              `json:"archive"` // Uploaded file name or zip_url
+	Files          int                 `json:"files"`   // Documents analyzed
+	Succeeded      int                 `json:"succeeded"`
+	Failed         int                 `json:"failed"`
+	RequiresReview int                 `json:"requires_review"`
+	TotalAmount    float64             `json:"total_amount"` // Sum of receipt.total of the succeeded documents
+	CostTHB        float64             `json:"cost_thb"`
+	DurationSec    float64             `json:"duration_sec"`
+	Stopped        string              `json:"stopped,omitempty"` // Why the remaining files were not analyzed
+	Documents      []ZipDocumentResult `json:"documents"`
+	Skipped        []ZipSkippedEntry   `json:"skipped"`
+}
+
+// extractedArchiveFile is a supported file written to UPLOAD_DIR
+type extractedArchiveFile struct {
+	name string // Path inside the zip
+	path string // Local file
+}
+
+// AnalyzeZipHandler handles POST /api/v1/analyze-zip
+func AnalyzeZipHandler(c *gin.Context) {
+	start := time.Now()
+
+	// Not behind DrainMiddleware (it buffers the whole body) - refuse while draining, stop between files below
+	if IsDraining() {
+		c.Header("Retry-After", "30")
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "server_draining",
+			"message": "เซิร์ฟเวอร์กำลังปิดเพื่อ deploy กรุณาส่งคำขอใหม่อีกครั้ง",
+		})
+		return
+	}
+
+	// Step 1: Validate the request
+	var req ZipAnalysisRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "Invalid request format",
+			"details":  err.Error(),
+			"expected": "multipart/form-data with file (zip) + shopid, or JSON with shopid + zip_url",
+		})
+		return
+	}
+	if req.ShopID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "shopid is required"})
+		return
+	}
+	if !isMultipartRequest(c) && req.ZipURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "zip_url is required",
+			"message": "ส่ง zip_url (JSON) หรืออัปโหลด zip ในฟิลด์ 'file' (multipart/form-data)",
+		})
+		return
+	}
+	if req.Model == "" {
+		req.Model = "gemini"
+	}
+	if req.Model != "gemini" && req.Model != "mistral" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":          "invalid model",
+			"message":        fmt.Sprintf("Model '%s' ไม่ถูกต้อง กรุณาเลือก 'gemini' หรือ 'mistral'", req.Model),
+			"provided_value": req.Model,
+			"allowed_values": []string{"gemini", "mistral"},
+		})
+		return
+	}
+	if rejectForeignShop(c, req.ShopID) || rejectSuspendedShop(c, req.ShopID) {
+		return
+	}
+
+	// Step 2: Receive the archive
+	batchID := uuid.New().String()
+	archivePath, archiveName, ok := receiveZipArchive(c, batchID, req)
+	if !ok {
+		return
+	}
+	defer os.Remove(archivePath)
+
+	// Step 3: Extract the supported files
+	files, skipped, err := extractZipArchive(archivePath, batchID)
+	defer func() {
+		for _, file := range files {
+			os.Remove(file.path)
+		}
+	}()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid zip archive",
+			"details": err.Error(),
+		})
+		return
+	}
+	if len(files) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":   "no supported files",
+			"message": "ไม่พบไฟล์ jpg / png / pdf ใน zip",
+			"skipped": skipped,
+		})
+		return
+	}
+	log.Printf("🗜️  Zip %s (%s): %d document(s), %d skipped - shop %s", batchID, archiveName, len(files), len(skipped), req.ShopID)
+
+	// Step 4: Analyze every file as its own document (one at a time, same pipeline as analyze-receipt)
+	response := ZipAnalysisResponse{
+		BatchID:   batchID,
+		ShopID:    req.ShopID,
+		Archive:   archiveName,
+		Files:     len(files),
+		Documents: []ZipDocumentResult{},
+		Skipped:   skipped,
+	}
+	var totalAmount money.Amount
+	for i, file := range files {
+		if c.Request.Context().Err() != nil {
+			response.Stopped = "client disconnected"
+			log.Printf("🔌 Zip %s: client disconnected after %d of %d document(s)", batchID, i, len(files))
+			break
+		}
+		if IsDraining() {
+			response.Stopped = "server shutting down"
+			break
+		}
+		result := analyzeArchiveFile(c.Request.Context(), req, batchID, i, file)
+		response.Documents = append(response.Documents, result)
+		response.CostTHB += result.CostTHB
+		if result.Status != "success" {
+			response.Failed++
+			continue
+		}
+		response.Succeeded++
+		totalAmount += money.FromBaht(result.Total)
+		if result.RequiresReview {
+			response.RequiresReview++
+		}
+	}
+	response.TotalAmount = totalAmount.Baht()
+	response.CostTHB = math.Round(response.CostTHB*100) / 100
+	response.DurationSec = time.Since(start).Seconds()
+	log.Printf("🗜️  Zip %s done: %d succeeded, %d failed, %d need review (%.1fs)", batchID, response.Succeeded, response.Failed, response.RequiresReview, response.DurationSec)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// receiveZipArchive stores the uploaded zip or downloads zip_url (ok = false → response written)
+func receiveZipArchive(c *gin.Context, batchID string, req ZipAnalysisRequest) (string, string, bool) {
+	maxBytes := int64(configs.Get().ZipMaxBytes)
+	archivePath := filepath.Join(configs.UPLOAD_DIR, batchID+".zip")
+
+	var source io.Reader
+	archiveName := req.ZipURL
+	if isMultipartRequest(c) {
+		file, header, err := c.Request.FormFile("file")
+		if _, tooLarge := asPayloadTooLarge(err); tooLarge {
+			respondZipTooLarge(c, maxBytes)
+			return "", "", false
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "file is required",
+				"details": err.Error(),
+			})
+			return "", "", false
+		}
+		defer file.Close()
+		if header.Size > maxBytes {
+			respondZipTooLarge(c, maxBytes)
+			return "", "", false
+		}
+		source, archiveName = file, header.Filename
+	} else {
+		ctx, cancel := phaseContext(c.Request.Context(), phaseDownload)
+		defer cancel()
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, req.ZipURL, nil)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid zip_url", "details": err.Error()})
+			return "", "", false
+		}
+		resp, err := http.DefaultClient.Do(httpReq)
+		if err == nil && resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			err = fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":   "Failed to download zip",
+				"details": err.Error(),
+				"zip_url": req.ZipURL,
+			})
+			return "", "", false
+		}
+		defer resp.Body.Close()
+		source = resp.Body
+	}
+
+	out, err := os.Create(archivePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save zip", "details": err.Error()})
+		return "", "", false
+	}
+	written, err := io.Copy(out, io.LimitReader(source, maxBytes+1))
+	out.Close()
+	switch {
+	case err != nil:
+		os.Remove(archivePath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save zip", "details": err.Error()})
+		return "", "", false
+	case written > maxBytes:
+		os.Remove(archivePath)
+		respondZipTooLarge(c, maxBytes)
+		return "", "", false
+	}
+	return archivePath, archiveName, true
+}
+
+// respondZipTooLarge writes 413 for an archive above ZIP_MAX_BYTES
+func respondZipTooLarge(c *gin.Context, maxBytes int64) {
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+		"error":     "zip too large",
+		"message":   fmt.Sprintf("zip ต้องมีขนาดไม่เกิน %.0f MB - แบ่งเป็นหลาย zip", float64(maxBytes)/1024/1024),
+		"max_bytes": maxBytes,
+	})
+}
+
+// zipMaxEntryBytes - size cap of one extracted file when IMAGE_REJECT_MAX_BYTES is 0 (a zip bomb must never be unbounded)
+const zipMaxEntryBytes = 100 * 1024 * 1024
+
+// zipEntryLimit returns the size cap of one extracted file (IMAGE_REJECT_MAX_BYTES, zipMaxEntryBytes when that is off)
+func zipEntryLimit(cfg *configs.Config) (int64, string) {
+	if cfg.ImageRejectMaxBytes > 0 {
+		return int64(cfg.ImageRejectMaxBytes), "IMAGE_REJECT_MAX_BYTES"
+	}
+	return zipMaxEntryBytes, "per-file limit"
+}
+
+// extractZipArchive writes the supported files (name order) to UPLOAD_DIR; the rest is reported as skipped
+// Files above ZIP_MAX_FILES are skipped too - the client sends them in another archive
+// Each file is capped (zipEntryLimit) and all files together at ZIP_MAX_EXTRACTED_BYTES - sizes in the zip header are not trusted
+func extractZipArchive(archivePath, batchID string) ([]extractedArchiveFile, []ZipSkippedEntry, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	entries := make([]*zip.File, 0, len(reader.File))
+	for _, entry := range reader.File {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	cfg := configs.Get()
+	entryLimit, entryLimitName := zipEntryLimit(cfg)
+	remaining := int64(cfg.ZipMaxExtractedBytes)
+	totalLimitReason := fmt.Sprintf("archive extracts to more than %d bytes (ZIP_MAX_EXTRACTED_BYTES)", cfg.ZipMaxExtractedBytes)
+	files := []extractedArchiveFile{}
+	skipped := []ZipSkippedEntry{}
+	for _, entry := range entries {
+		name := entry.Name
+		base := path.Base(name)
+		ext := strings.ToLower(path.Ext(name))
+		switch {
+		case entry.FileInfo().IsDir():
+			continue
+		case strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, ".") || strings.EqualFold(base, "Thumbs.db"):
+			continue // OS metadata, not documents
+		case !zipSupportedExtensions[ext]:
+			skipped = append(skipped, ZipSkippedEntry{File: name, Reason: "unsupported file type (jpg, png, pdf)"})
+			continue
+		case entry.UncompressedSize64 > uint64(entryLimit):
+			skipped = append(skipped, ZipSkippedEntry{File: name, Reason: fmt.Sprintf("file larger than %d bytes (%s)", entryLimit, entryLimitName)})
+			continue
+		case entry.UncompressedSize64 > uint64(remaining):
+			skipped = append(skipped, ZipSkippedEntry{File: name, Reason: totalLimitReason})
+			continue
+		case len(files) >= cfg.ZipMaxFiles:
+			skipped = append(skipped, ZipSkippedEntry{File: name, Reason: fmt.Sprintf("more than %d documents (ZIP_MAX_FILES)", cfg.ZipMaxFiles)})
+			continue
+		}
+
+		// Local name never uses the zip path (no path traversal)
+		localPath := filepath.Join(configs.UPLOAD_DIR, fmt.Sprintf("%s_%d%s", batchID, len(files), ext))
+		limit := min(entryLimit, remaining)
+		written, err := extractZipEntry(entry, localPath, limit)
+		remaining -= written // Skipped files count too - the decompression work is what the cap bounds
+		if err != nil {
+			os.Remove(localPath)
+			reason := err.Error()
+			switch {
+			case errors.Is(err, errZipEntryTooLarge) && limit < entryLimit:
+				reason = totalLimitReason
+			case errors.Is(err, errZipEntryTooLarge):
+				reason = fmt.Sprintf("file larger than %d bytes (%s)", entryLimit, entryLimitName)
+			}
+			skipped = append(skipped, ZipSkippedEntry{File: name, Reason: reason})
+			continue
+		}
+		files = append(files, extractedArchiveFile{name: name, path: localPath})
+	}
+	return files, skipped, nil
+}
+
+// errZipEntryTooLarge - the entry decompressed past its cap
+var errZipEntryTooLarge = errors.New("zip entry larger than its limit")
+
+// extractZipEntry copies one entry, decompressing at most maxBytes + 1 (the header may lie) - returns the bytes written
+func extractZipEntry(entry *zip.File, localPath string, maxBytes int64) (int64, error) {
+	in, err := entry.Open()
+	if err != nil {
+		return 0, fmt.Errorf("cannot read file: %w", err)
+	}
+	defer in.Close()
+	out, err := os.Create(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("cannot extract file: %w", err)
+	}
+	defer out.Close()
+
+	written, err := io.Copy(out, io.LimitReader(in, maxBytes+1))
+	if err != nil {
+		return written, fmt.Errorf("cannot extract file: %w", err)
+	}
+	if written > maxBytes {
+		return written, errZipEntryTooLarge
+	}
+	return written, nil
+}
+
+// analyzeArchiveFile runs analyze-receipt on one local file (zip entry or folder watch download)
+func analyzeArchiveFile(ctx context.Context, req ZipAnalysisRequest, batchID string, index int, file extractedArchiveFile) ZipDocumentResult {
+	start := time.Now()
+	result := ZipDocumentResult{File: file.name, Status: "failed"}
+
+	uri := fmt.Sprintf("%s%s/%d%s", archiveURIScheme, batchID, index, strings.ToLower(filepath.Ext(file.path)))
+	archiveFiles.Store(uri, file.path)
+	defer archiveFiles.Delete(uri)
+
+	payload, _ := json.Marshal(ExtractRequest{
+		ShopID:          req.ShopID,
+		Model:           req.Model,
+		MaxCostTHB:      req.MaxCostTHB,
+		ImageReferences: []ImageReference{{DocumentImageGUID: file.name, ImageURI: uri}},
+	})
+	status, body := RunAnalyzeReceipt(ctx, payload)
+	result.StatusCode = status
+	result.DurationSec = time.Since(start).Seconds()
+
+	var response struct {
+		Error      string                 `json:"error"`
+		Message    string                 `json:"message"`
+		RequestID  string                 `json:"request_id"`
+		Receipt    ReceiptData            `json:"receipt"`
+		Validation map[string]interface{} `json:"validation"`
+		Metadata   struct {
+			RequestID     string `json:"request_id"`
+			CostBreakdown struct {
+				ActualTotalTHB float64 `json:"actual_total_thb"`
+			} `json:"cost_breakdown"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		result.Error = fmt.Sprintf("HTTP %d: invalid response", status)
+		return result
+	}
+	result.RequestID = response.Metadata.RequestID
+	if result.RequestID == "" {
+		result.RequestID = response.RequestID
+	}
+	result.CostTHB = response.Metadata.CostBreakdown.ActualTotalTHB
+	if status != http.StatusOK {
+		result.Error = strings.TrimSpace(fmt.Sprintf("HTTP %d: %s %s", status, response.Error, response.Message))
+		return result
+	}
+
+	result.Status = "success"
+	result.DocumentNumber = response.Receipt.Number
+	result.DocumentDate = response.Receipt.Date
+	result.VendorName = response.Receipt.VendorName
+	result.Total = response.Receipt.Total
+	result.RequiresReview, _ = response.Validation["requires_review"].(bool)
+	return result
+}
+
+// copyArchiveFile resolves an archive:// imageuri to the extracted file of a running zip batch
+func copyArchiveFile(uri, filename string) (string, error) {
+	value, ok := archiveFiles.Load(uri)
+	if !ok {
+		return "", fmt.Errorf("archive file %s is not available (zip batch finished)", uri)
+	}
+	in, err := os.Open(value.(string))
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer in.Close()
+	out, err := os.Create(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return "", fmt.Errorf("failed to save file: %w", err)
+	}
+	ext := strings.ToLower(filepath.Ext(uri))
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	}
+	return ext, nil
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+)
+
+// writeTestZip builds a zip with the given file sizes (zeros compress well - a small zip bomb)
+func writeTestZip(t *testing.T, sizes map[string]int) string {
+	t.Helper()
+	archivePath := filepath.Join(t.TempDir(), "test.zip")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for name, size := range sizes {
+		entry, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write(make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+func TestExtractZipArchiveCaps(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		sizes       map[string]int
+		wantFiles   []string
+		wantSkipped map[string]string
+	}{
+		{
+			name:        "per-file cap from IMAGE_REJECT_MAX_BYTES",
+			env:         map[string]string{"IMAGE_REJECT_MAX_BYTES": "100"},
+			sizes:       map[string]int{"a.jpg": 100, "b.jpg": 101},
+			wantFiles:   []string{"a.jpg"},
+			wantSkipped: map[string]string{"b.jpg": "IMAGE_REJECT_MAX_BYTES"},
+		},
+		{
+			name:        "total cap with IMAGE_REJECT_MAX_BYTES off",
+			env:         map[string]string{"IMAGE_REJECT_MAX_BYTES": "0", "ZIP_MAX_EXTRACTED_BYTES": "250"},
+			sizes:       map[string]int{"a.jpg": 100, "b.jpg": 100, "c.jpg": 100},
+			wantFiles:   []string{"a.jpg", "b.jpg"},
+			wantSkipped: map[string]string{"c.jpg": "ZIP_MAX_EXTRACTED_BYTES"},
+		},
+		{
+			name:        "per-file fallback with IMAGE_REJECT_MAX_BYTES off",
+			env:         map[string]string{"IMAGE_REJECT_MAX_BYTES": "0"},
+			sizes:       map[string]int{"a.jpg": 10, "bomb.jpg": zipMaxEntryBytes + 1},
+			wantFiles:   []string{"a.jpg"},
+			wantSkipped: map[string]string{"bomb.jpg": "per-file limit"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() { configs.ReloadConfig() }) // runs after the env is restored
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			if err := configs.ReloadConfig(); err != nil {
+				t.Fatalf("ReloadConfig: %v", err)
+			}
+			uploadDir := configs.UPLOAD_DIR
+			configs.UPLOAD_DIR = t.TempDir()
+			t.Cleanup(func() { configs.UPLOAD_DIR = uploadDir })
+
+			files, skipped, err := extractZipArchive(writeTestZip(t, tt.sizes), "batch")
+			if err != nil {
+				t.Fatalf("extractZipArchive: %v", err)
+			}
+			var names []string
+			for _, file := range files {
+				names = append(names, file.name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantFiles, ",") {
+				t.Errorf("files = %v, want %v", names, tt.wantFiles)
+			}
+			if len(skipped) != len(tt.wantSkipped) {
+				t.Fatalf("skipped = %+v, want %v", skipped, tt.wantSkipped)
+			}
+			for _, entry := range skipped {
+				if want := tt.wantSkipped[entry.File]; want == "" || !strings.Contains(entry.Reason, want) {
+					t.Errorf("skipped %q: %q, want reason with %q", entry.File, entry.Reason, want)
+				}
+			}
+		})
+	}
+}
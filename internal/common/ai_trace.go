@@ -0,0 +1,126 @@
+// ai_trace.go - Record exact AI interactions per phase (ENABLE_AI_TRACES=true)
+//
+// เก็บ prompt, system instruction, schema และ raw response ที่ส่ง/รับจริง
+// ใช้ replay/debug ว่าทำไม AI ถึงเลือกบัญชีนั้น (ดูได้ที่ GET /api/v1/results/:request_id/traces)
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// AITrace is one AI call exactly as sent to / received from the provider
+type AITrace struct {
+	Phase             string          `json:"phase"`    // CostPhaseOCR, CostPhaseTemplateMatch, CostPhaseAccounting
+	Provider          string          `json:"provider"` // "gemini" or "mistral"
+	Model             string          `json:"model"`
+	SystemInstruction string          `json:"system_instruction,omitempty"`
+	Prompt            string          `json:"prompt,omitempty"`
+	ResponseSchema    json.RawMessage `json:"response_schema,omitempty"`
+	Input             string          `json:"input,omitempty"` // Non-text input (e.g. "image/jpeg, 183422 bytes")
+	RawResponse       string          `json:"raw_response"`
+	FinishReason      string          `json:"finish_reason,omitempty"`
+	BlockReason       string          `json:"block_reason,omitempty"`
+	Error             string          `json:"error,omitempty"`
+	PromptTokens      int32           `json:"prompt_tokens"`
+	OutputTokens      int32           `json:"output_tokens"`
+	DurationMs        int64           `json:"duration_ms"`
+	CreatedAt         time.Time       `json:"created_at"`
+}
+
+// TracingEnabled reports whether AI interactions should be recorded
+func TracingEnabled() bool {
+	return configs.Get().EnableAITraces
+}
+
+// RecordTrace appends an AI interaction to the request (no-op when tracing is disabled)
+func (rc *RequestContext) RecordTrace(trace AITrace) {
+	if !configs.Get().EnableAITraces {
+		return
+	}
+	if trace.CreatedAt.IsZero() {
+		trace.CreatedAt = time.Now()
+	}
+	rc = rc.root()
+	rc.traceMu.Lock()
+	rc.traces = append(rc.traces, trace)
+	rc.traceMu.Unlock()
+}
+
+// GetTraces returns a copy of the recorded AI interactions (in call order)
+func (rc *RequestContext) GetTraces() []AITrace {
+	rc = rc.root()
+	rc.traceMu.Lock()
+	defer rc.traceMu.Unlock()
+	return append([]AITrace(nil), rc.traces...)
+}
+
+// NewGeminiTrace builds a trace from a Gemini model config + response
+// resp/err may be nil - failed and blocked calls are traced too
+func NewGeminiTrace(phase, modelName string, model *genai.GenerativeModel, prompt, input string, resp *genai.GenerateContentResponse, err error, started time.Time) AITrace {
+	trace := AITrace{
+		Phase:      phase,
+		Provider:   "gemini",
+		Model:      modelName,
+		Prompt:     prompt,
+		Input:      input,
+		DurationMs: time.Since(started).Milliseconds(),
+	}
+
+	if model != nil {
+		if model.SystemInstruction != nil {
+			for _, part := range model.SystemInstruction.Parts {
+				if text, ok := part.(genai.Text); ok {
+					trace.SystemInstruction += string(text)
+				}
+			}
+		}
+		if model.ResponseSchema != nil {
+			if schemaJSON, jsonErr := json.Marshal(model.ResponseSchema); jsonErr == nil {
+				trace.ResponseSchema = schemaJSON
+			}
+		}
+	}
+
+	if err != nil {
+		trace.Error = err.Error()
+
+		var blocked *genai.BlockedError
+		if errors.As(err, &blocked) {
+			if blocked.PromptFeedback != nil {
+				trace.BlockReason = blocked.PromptFeedback.BlockReason.String()
+			}
+			if blocked.Candidate != nil {
+				trace.FinishReason = blocked.Candidate.FinishReason.String()
+			}
+		}
+	}
+	if resp == nil {
+		return trace
+	}
+
+	if resp.UsageMetadata != nil {
+		trace.PromptTokens = resp.UsageMetadata.PromptTokenCount
+		trace.OutputTokens = resp.UsageMetadata.CandidatesTokenCount
+	}
+	if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != genai.BlockReasonUnspecified {
+		trace.BlockReason = resp.PromptFeedback.BlockReason.String()
+	}
+	if len(resp.Candidates) > 0 {
+		candidate := resp.Candidates[0]
+		trace.FinishReason = candidate.FinishReason.String()
+		if candidate.Content != nil {
+			for _, part := range candidate.Content.Parts {
+				if text, ok := part.(genai.Text); ok {
+					trace.RawResponse += string(text)
+				}
+			}
+		}
+	}
+	return trace
+}
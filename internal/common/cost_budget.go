@@ -0,0 +1,236 @@
+// cost_budget.go - Per-request cost budget and cost pre-estimation
+//
+// ก่อนเรียก AI แต่ละครั้ง จะประเมินค่าใช้จ่ายจากขนาด prompt + จำนวนรูป
+// ถ้ายอดที่ใช้ไปแล้ว + ยอดประเมิน เกิน max_cost_thb → ยกเลิกการเรียกพร้อม error ที่ชัดเจน
+
+package common
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+)
+
+// Cost phases (keys used in metadata.cost_breakdown)
+const (
+	CostPhaseOCR           = "ocr"
+	CostPhaseTemplateMatch = "template_match"
+	CostPhaseAccounting    = "accounting"
+	CostPhaseVerification  = "verification"   // Optional second read of critical fields
+	CostPhaseExtraction    = "extraction"     // Caller-defined fields (POST /api/v1/extract)
+	CostPhaseFieldLocation = "field_location" // Bounding boxes of receipt values (include_preview)
+)
+
+// Estimation constants (used only for projections - actual cost always comes from the API)
+const (
+	// EstimatedImageTokens - Gemini bills 258 tokens per 768x768 tile
+	// Images are resized to MAX_IMAGE_DIMENSION (2000px) → up to ~3x2 tiles
+	EstimatedImageTokens = 258 * 6
+
+	EstimatedOCROutputTokens        = 2000 // Typical receipt raw text
+	EstimatedTemplateOutputTokens   = 300  // Small JSON (template name + reasoning)
+	EstimatedAccountingOutputTokens = 4000 // Full accounting JSON with explanations
+
+	// MistralCostPerPageUSD - Mistral OCR 3: $2 per 1,000 pages
+	MistralCostPerPageUSD = 0.002
+)
+
+// PhaseCost holds projected vs actual cost for one phase
+type PhaseCost struct {
+	Phase           string  `json:"phase"`
+	Calls           int     `json:"calls"`
+	ProjectedTokens int     `json:"projected_tokens"`
+	ProjectedTHB    float64 `json:"projected_cost_thb"`
+	ActualTokens    int     `json:"actual_tokens"`
+	ActualTHB       float64 `json:"actual_cost_thb"`
+
+	Actual TokenUsage `json:"-"` // Full actual usage (input/output/USD) for the usage ledger
+}
+
+// CostBudget tracks projected/actual cost per phase and enforces MaxCostTHB (0 = unlimited)
+type CostBudget struct {
+	MaxCostTHB float64
+	phases     []*PhaseCost
+	exceeded   *BudgetExceededError
+}
+
+// BudgetExceededError is returned when the projected cost of the next AI call exceeds the budget
+type BudgetExceededError struct {
+	Phase        string
+	SpentTHB     float64
+	ProjectedTHB float64
+	MaxCostTHB   float64
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("cost budget exceeded before %s: spent ฿%.4f + projected ฿%.4f > max ฿%.4f",
+		e.Phase, e.SpentTHB, e.ProjectedTHB, e.MaxCostTHB)
+}
+
+// EstimateTextTokens roughly estimates Gemini tokens for a prompt
+// ASCII ≈ 4 chars/token, Thai and other non-ASCII ≈ 2 chars/token (conservative)
+func EstimateTextTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + (other+1)/2
+}
+
+// EstimateMistralOCRCost projects Mistral OCR cost (pages stored as tokens, same as actual usage)
+func EstimateMistralOCRCost(pages int) TokenUsage {
+	costUSD := float64(pages) * MistralCostPerPageUSD
+	return TokenUsage{
+		InputTokens: pages,
+		TotalTokens: pages,
+		CostUSD:     costUSD,
+		CostTHB:     costUSD * configs.Get().USDToTHB,
+	}
+}
+
+// SetMaxCostTHB enables budget enforcement for this request
+func (rc *RequestContext) SetMaxCostTHB(maxCostTHB float64) {
+	rc = rc.root()
+	rc.costMu.Lock()
+	defer rc.costMu.Unlock()
+	rc.budget().MaxCostTHB = maxCostTHB
+}
+
+// ReserveCost records the projected cost of the next AI call and checks it against the budget
+// Must be called BEFORE the AI call - returns *BudgetExceededError if the call would exceed the budget
+func (rc *RequestContext) ReserveCost(phase string, projected TokenUsage) error {
+	root := rc.root()
+	root.costMu.Lock()
+	defer root.costMu.Unlock()
+
+	b := root.budget()
+	if b.exceeded != nil {
+		return b.exceeded
+	}
+
+	spent := b.actualTotal()
+	if b.MaxCostTHB > 0 && spent+projected.CostTHB > b.MaxCostTHB {
+		b.exceeded = &BudgetExceededError{
+			Phase:        phase,
+			SpentTHB:     spent,
+			ProjectedTHB: projected.CostTHB,
+			MaxCostTHB:   b.MaxCostTHB,
+		}
+		rc.LogWarning("💸 %v", b.exceeded)
+		return b.exceeded
+	}
+
+	pc := b.phase(phase)
+	pc.Calls++
+	pc.ProjectedTokens += projected.TotalTokens
+	pc.ProjectedTHB += projected.CostTHB
+	return nil
+}
+
+// RecordCost records the actual cost of an AI call (from API usage metadata)
+func (rc *RequestContext) RecordCost(phase string, actual *TokenUsage) {
+	if actual == nil {
+		return
+	}
+	rc = rc.root()
+	rc.costMu.Lock()
+	defer rc.costMu.Unlock()
+
+	pc := rc.budget().phase(phase)
+	pc.ActualTokens += actual.TotalTokens
+	pc.ActualTHB += actual.CostTHB
+
+	pc.Actual.InputTokens += actual.InputTokens
+	pc.Actual.OutputTokens += actual.OutputTokens
+	pc.Actual.TotalTokens += actual.TotalTokens
+	pc.Actual.CostUSD += actual.CostUSD
+	pc.Actual.CostTHB += actual.CostTHB
+}
+
+// GetPhaseCosts returns a copy of the per-phase cost records (in call order)
+func (rc *RequestContext) GetPhaseCosts() []PhaseCost {
+	rc = rc.root()
+	rc.costMu.Lock()
+	defer rc.costMu.Unlock()
+	return rc.budget().phaseCopies()
+}
+
+// BudgetError returns the budget error if any AI call was blocked (nil otherwise)
+// Used after phases that swallow AI errors (OCR per image, template matching)
+func (rc *RequestContext) BudgetError() error {
+	rc = rc.root()
+	rc.costMu.Lock()
+	defer rc.costMu.Unlock()
+	if rc.costBudget == nil || rc.costBudget.exceeded == nil {
+		return nil
+	}
+	return rc.costBudget.exceeded
+}
+
+// GetCostBreakdown returns projected vs actual cost per phase (for response metadata)
+func (rc *RequestContext) GetCostBreakdown() map[string]interface{} {
+	rc = rc.root()
+	rc.costMu.Lock()
+	defer rc.costMu.Unlock()
+	b := rc.budget()
+
+	phases := b.phaseCopies()
+	projectedTotal := 0.0
+	for _, pc := range phases {
+		projectedTotal += pc.ProjectedTHB
+	}
+
+	breakdown := map[string]interface{}{
+		"phases":              phases,
+		"projected_total_thb": projectedTotal,
+		"actual_total_thb":    b.actualTotal(),
+	}
+	if b.MaxCostTHB > 0 {
+		breakdown["max_cost_thb"] = b.MaxCostTHB
+	}
+	if b.exceeded != nil {
+		breakdown["budget_exceeded_at"] = b.exceeded.Phase
+	}
+	return breakdown
+}
+
+// budget must be called on the root request with costMu held
+func (rc *RequestContext) budget() *CostBudget {
+	if rc.costBudget == nil {
+		rc.costBudget = &CostBudget{}
+	}
+	return rc.costBudget
+}
+
+// phaseCopies returns a copy of the per-phase cost records (in call order)
+func (b *CostBudget) phaseCopies() []PhaseCost {
+	phases := make([]PhaseCost, 0, len(b.phases))
+	for _, pc := range b.phases {
+		phases = append(phases, *pc)
+	}
+	return phases
+}
+
+func (b *CostBudget) phase(name string) *PhaseCost {
+	for _, pc := range b.phases {
+		if pc.Phase == name {
+			return pc
+		}
+	}
+	pc := &PhaseCost{Phase: name}
+	b.phases = append(b.phases, pc)
+	return pc
+}
+
+func (b *CostBudget) actualTotal() float64 {
+	total := 0.0
+	for _, pc := range b.phases {
+		total += pc.ActualTHB
+	}
+	return total
+}
@@ -0,0 +1,35 @@
+// file_scan.go - Malware scans of the request's files (FILE_SCAN_DRIVER)
+//
+// ทุกไฟล์ที่ดาวน์โหลด/อัปโหลดถูกสแกนก่อน OCR → รายงานใน metadata.file_scans
+
+package common
+
+import "strings"
+
+// FileScan is the scan result of one file
+type FileScan struct {
+	ImageIndex int    `json:"image_index"`
+	Scanner    string `json:"scanner"` // "clamav" or "http"
+	Status     string `json:"status"`  // "clean", "infected" or "error" (scanner unavailable, FILE_SCAN_FAIL_OPEN)
+	Signature  string `json:"signature,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Bytes      int64  `json:"bytes"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// RecordFileScan records the scan of a file (safe from concurrent goroutines)
+func (rc *RequestContext) RecordFileScan(scan FileScan) {
+	rc.LogInfo("🛡️  File scan image %d (%s): %s", scan.ImageIndex, scan.Scanner, strings.TrimSpace(scan.Status+" "+scan.Signature))
+	rc = rc.root()
+	rc.fileScanMu.Lock()
+	rc.fileScans = append(rc.fileScans, scan)
+	rc.fileScanMu.Unlock()
+}
+
+// FileScans returns a copy of the recorded file scans (in order)
+func (rc *RequestContext) FileScans() []FileScan {
+	rc = rc.root()
+	rc.fileScanMu.Lock()
+	defer rc.fileScanMu.Unlock()
+	return append([]FileScan(nil), rc.fileScans...)
+}
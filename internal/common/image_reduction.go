@@ -0,0 +1,42 @@
+// image_reduction.go - Images shrunk by the payload guard before a Gemini call
+//
+// รูปที่ใหญ่เกิน IMAGE_PAYLOAD_MAX_BYTES / IMAGE_PAYLOAD_MAX_TOKENS ถูกบีบอัดหรือย่อก่อนส่ง
+// → รายงานใน metadata.image_reductions ว่ารูปไหนถูกลดขนาดไปเท่าไร
+
+package common
+
+// ImageReduction describes how one image was shrunk before being sent to Gemini
+type ImageReduction struct {
+	Image           string `json:"image"`  // File name
+	Action          string `json:"action"` // "recompressed" (JPEG quality only) or "downscaled"
+	OriginalBytes   int    `json:"original_bytes"`
+	FinalBytes      int    `json:"final_bytes"`
+	OriginalWidth   int    `json:"original_width"`
+	OriginalHeight  int    `json:"original_height"`
+	FinalWidth      int    `json:"final_width"`
+	FinalHeight     int    `json:"final_height"`
+	OriginalTokens  int    `json:"original_tokens"` // Estimated image input tokens
+	FinalTokens     int    `json:"final_tokens"`
+	JPEGQuality     int    `json:"jpeg_quality"`
+	ReductionFactor string `json:"reduction_factor"` // e.g. "3.2x" (bytes)
+}
+
+// RecordImageReduction records an image shrunk by the payload guard (safe from OCR worker goroutines)
+func (rc *RequestContext) RecordImageReduction(reduction ImageReduction) {
+	rc.LogInfo("🗜️  %s %s: %d → %d bytes, %dx%d → %dx%d (~%d → ~%d tokens)",
+		reduction.Image, reduction.Action, reduction.OriginalBytes, reduction.FinalBytes,
+		reduction.OriginalWidth, reduction.OriginalHeight, reduction.FinalWidth, reduction.FinalHeight,
+		reduction.OriginalTokens, reduction.FinalTokens)
+	rc = rc.root()
+	rc.imageReductionMu.Lock()
+	rc.imageReductions = append(rc.imageReductions, reduction)
+	rc.imageReductionMu.Unlock()
+}
+
+// ImageReductions returns a copy of the recorded image reductions (in order)
+func (rc *RequestContext) ImageReductions() []ImageReduction {
+	rc = rc.root()
+	rc.imageReductionMu.Lock()
+	defer rc.imageReductionMu.Unlock()
+	return append([]ImageReduction(nil), rc.imageReductions...)
+}
@@ -0,0 +1,37 @@
+// image_sanitization.go - Metadata stripped from received images (STRIP_IMAGE_METADATA)
+//
+// รูปถ่ายมี EXIF (พิกัด GPS, ยี่ห้อ/รุ่นมือถือ, เวลา) → ลบออกก่อนเก็บหรือส่งให้ OCR provider
+// orientation ถูกหมุนลงในพิกเซลจริงก่อนลบ → รายงานใน metadata.image_sanitization
+
+package common
+
+import "strings"
+
+// ImageSanitization describes the metadata removed from one image
+type ImageSanitization struct {
+	ImageIndex    int      `json:"image_index"`
+	Format        string   `json:"format"`                // "jpeg" or "png"
+	Removed       []string `json:"removed"`               // e.g. "exif", "gps", "device", "xmp", "iptc", "comment", "text"
+	Orientation   int      `json:"orientation,omitempty"` // EXIF orientation applied to the pixels (2-8)
+	ReEncoded     bool     `json:"re_encoded"`            // true = decoded and re-encoded (orientation applied), false = segments dropped losslessly
+	OriginalBytes int64    `json:"original_bytes"`
+	FinalBytes    int64    `json:"final_bytes"`
+}
+
+// RecordImageSanitization records the metadata stripped from an image (safe from concurrent goroutines)
+func (rc *RequestContext) RecordImageSanitization(sanitization ImageSanitization) {
+	rc.LogInfo("🧹 Image %d metadata stripped (%s): %d → %d bytes", sanitization.ImageIndex,
+		strings.Join(sanitization.Removed, ", "), sanitization.OriginalBytes, sanitization.FinalBytes)
+	rc = rc.root()
+	rc.imageSanitizationMu.Lock()
+	rc.imageSanitizations = append(rc.imageSanitizations, sanitization)
+	rc.imageSanitizationMu.Unlock()
+}
+
+// ImageSanitizations returns a copy of the recorded sanitizations (in order)
+func (rc *RequestContext) ImageSanitizations() []ImageSanitization {
+	rc = rc.root()
+	rc.imageSanitizationMu.Lock()
+	defer rc.imageSanitizationMu.Unlock()
+	return append([]ImageSanitization(nil), rc.imageSanitizations...)
+}
@@ -0,0 +1,36 @@
+// phase_timeout.go - Phases (download, OCR, template match, accounting) that exceeded their own deadline
+//
+// แต่ละ phase มี deadline ของตัวเอง (DOWNLOAD_TIMEOUT, FULL_OCR_TIMEOUT, TEMPLATE_MATCH_TIMEOUT, ACCOUNTING_TIMEOUT)
+// ที่แตกมาจาก context ของ request → รายงานใน metadata.phase_timeouts ว่า phase ไหนหมดเวลา
+
+package common
+
+import "time"
+
+// PhaseTimeout is a phase that ran out of time
+type PhaseTimeout struct {
+	Phase      string `json:"phase"` // download, ocr, template_match, accounting
+	TimeoutSec int    `json:"timeout_sec"`
+	Detail     string `json:"detail,omitempty"` // e.g. "image 2", "document images [3 4]"
+}
+
+// RecordPhaseTimeout records that a phase exceeded its deadline (safe from OCR worker goroutines)
+func (rc *RequestContext) RecordPhaseTimeout(phase string, timeout time.Duration, detail string) {
+	rc.LogWarning("⏱️  Phase %s timed out after %v %s", phase, timeout, detail)
+	rc = rc.root()
+	rc.phaseTimeoutMu.Lock()
+	rc.phaseTimeouts = append(rc.phaseTimeouts, PhaseTimeout{
+		Phase:      phase,
+		TimeoutSec: int(timeout / time.Second),
+		Detail:     detail,
+	})
+	rc.phaseTimeoutMu.Unlock()
+}
+
+// PhaseTimeouts returns a copy of the recorded phase timeouts (in order)
+func (rc *RequestContext) PhaseTimeouts() []PhaseTimeout {
+	rc = rc.root()
+	rc.phaseTimeoutMu.Lock()
+	defer rc.phaseTimeoutMu.Unlock()
+	return append([]PhaseTimeout(nil), rc.phaseTimeouts...)
+}
@@ -0,0 +1,17 @@
+// priority.go - Priority classes of analyses (request field "priority", analyzeJobs.priority)
+//
+// interactive = ผู้ใช้รอผลอยู่ (ค่าเริ่มต้น), bulk = reprocess / งานปริมาณมากผ่าน queue
+// bulk มี worker pool แยก (WORKER_BULK_CONCURRENCY) และใช้ rate limit ของ Gemini ได้ไม่เกิน BULK_RATE_SHARE
+
+package common
+
+// Priority classes
+const (
+	PriorityInteractive = "interactive"
+	PriorityBulk        = "bulk"
+)
+
+// ValidPriority reports whether priority is a known class ("" = interactive)
+func ValidPriority(priority string) bool {
+	return priority == "" || priority == PriorityInteractive || priority == PriorityBulk
+}
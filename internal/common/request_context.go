@@ -5,6 +5,9 @@ package common
 import (
 	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bosocmputer/account_ocr_gemini/configs"
@@ -12,6 +15,7 @@ import (
 )
 
 // RequestContext tracks the entire request lifecycle with timing and costs
+// Safe for concurrent use - worker goroutines should record their sub-steps through Track()
 type RequestContext struct {
 	RequestID           string
 	ShopID              string
@@ -23,6 +27,26 @@ type RequestContext struct {
 	CurrentSubSteps     []SubStepLog
 	CurrentSubStep      string
 	CurrentSubStepStart time.Time
+	AccountingModel     string              // Phase 3 model override (reanalyze) - empty = chosen by template mode
+	Settings            RequestSettings     // Models / thresholds of this request (see request_settings.go)
+	DocumentLanguage    string              // Language of the OCR text (processor.Language*) - "" = not detected yet (Thai)
+	Priority            string              // Priority class the analysis runs with (see priority.go)
+	DimensionValues     map[string][]string // Active dimension values per key ("P001 โครงการบ้านสวน") listed in the Phase 3 prompt
+	costBudget          *CostBudget         // Projected vs actual cost per phase (see cost_budget.go)
+	traces              []AITrace           // Recorded AI interactions (see ai_trace.go)
+	traceMu             sync.Mutex
+	phaseTimeouts       []PhaseTimeout // Phases that ran past their own deadline (see phase_timeout.go)
+	phaseTimeoutMu      sync.Mutex
+	imageReductions     []ImageReduction // Images shrunk before Gemini calls (see image_reduction.go)
+	imageReductionMu    sync.Mutex
+	fileScans           []FileScan // Malware scans of downloaded / uploaded files (see file_scan.go)
+	fileScanMu          sync.Mutex
+	imageSanitizations  []ImageSanitization // Metadata stripped from received images (see image_sanitization.go)
+	imageSanitizationMu sync.Mutex
+	stepMu              sync.Mutex      // Guards Steps, TotalTokens and the Current* fields
+	costMu              sync.Mutex      // Guards costBudget
+	parent              *RequestContext // Set on tracks (see Track) - shared state lives on the parent
+	track               string          // Track name, e.g. "image 2"
 }
 
 // StepLog represents a single processing step
@@ -42,6 +66,7 @@ type SubStepLog struct {
 	StartTime time.Time `json:"start_time"`
 	Duration  int64     `json:"duration_ms"`
 	Details   string    `json:"details,omitempty"`
+	Track     string    `json:"track,omitempty"` // Parallel track of the sub-step (e.g. "image 2"), empty = the request itself
 }
 
 // TokenUsage tracks API token consumption
@@ -70,13 +95,52 @@ func NewRequestContext(shopID string) *RequestContext {
 		StartTime:   now,
 		Steps:       []StepLog{},
 		TotalTokens: TokenUsage{},
+		Settings:    DefaultRequestSettings(),
 	}
 }
 
+// Track returns a view of the request for one unit of work that may run in parallel (e.g. one image)
+// Sub-steps of the track are timed separately and recorded under the current step with the track name;
+// steps, tokens, costs, traces and phase timeouts still go to the request itself
+func (rc *RequestContext) Track(name string) *RequestContext {
+	root := rc.root()
+	if rc.track != "" {
+		name = rc.track + "/" + name
+	}
+	return &RequestContext{
+		RequestID:       root.RequestID,
+		ShopID:          root.ShopID,
+		StartTime:       root.StartTime,
+		AccountingModel: root.AccountingModel,
+		Settings:        root.Settings,
+		parent:          root,
+		track:           name,
+	}
+}
+
+// root returns the request that owns the shared state (rc itself unless rc is a track)
+func (rc *RequestContext) root() *RequestContext {
+	if rc.parent != nil {
+		return rc.parent
+	}
+	return rc
+}
+
+// trackLabel is the log prefix of a track ("" for the request itself)
+func (rc *RequestContext) trackLabel() string {
+	if rc.track == "" {
+		return ""
+	}
+	return "[" + rc.track + "] "
+}
+
 // StartStep begins tracking a new processing step
 func (rc *RequestContext) StartStep(stepName string) {
+	rc = rc.root()
+	rc.stepMu.Lock()
 	rc.CurrentStep = stepName
 	rc.CurrentStepStart = time.Now()
+	rc.stepMu.Unlock()
 
 	// Map step names to Thai descriptions
 	stepDescriptions := map[string]string{
@@ -96,6 +160,10 @@ func (rc *RequestContext) StartStep(stepName string) {
 
 // EndStep completes the current step and records timing
 func (rc *RequestContext) EndStep(status string, tokens *TokenUsage, err error) {
+	rc = rc.root()
+	rc.stepMu.Lock()
+	defer rc.stepMu.Unlock()
+
 	duration := time.Since(rc.CurrentStepStart).Milliseconds()
 
 	stepLog := StepLog{
@@ -130,8 +198,11 @@ func (rc *RequestContext) EndStep(status string, tokens *TokenUsage, err error)
 		if len(rc.CurrentSubSteps) > 0 {
 			logMsg += fmt.Sprintf(" | ขั้นย่อย: %d", len(rc.CurrentSubSteps))
 		}
+		if tracks := trackDurations(rc.CurrentSubSteps); len(tracks) > 0 {
+			logMsg += fmt.Sprintf(" | งานขนาน: %d", len(tracks))
+		}
 
-		log.Printf(logMsg)
+		log.Print(logMsg)
 	}
 
 	rc.Steps = append(rc.Steps, stepLog)
@@ -154,7 +225,7 @@ func CalculateOCRTokenCost(inputTokens, outputTokens int) TokenUsage {
 	inputCost := float64(inputTokens) * configs.OCR_INPUT_PRICE_PER_MILLION / 1_000_000
 	outputCost := float64(outputTokens) * configs.OCR_OUTPUT_PRICE_PER_MILLION / 1_000_000
 	costUSD := inputCost + outputCost
-	costTHB := costUSD * configs.USD_TO_THB
+	costTHB := costUSD * configs.Get().USDToTHB
 
 	return TokenUsage{
 		InputTokens:  inputTokens,
@@ -172,7 +243,7 @@ func CalculateTemplateTokenCost(inputTokens, outputTokens int) TokenUsage {
 	inputCost := float64(inputTokens) * configs.TEMPLATE_INPUT_PRICE_PER_MILLION / 1_000_000
 	outputCost := float64(outputTokens) * configs.TEMPLATE_OUTPUT_PRICE_PER_MILLION / 1_000_000
 	costUSD := inputCost + outputCost
-	costTHB := costUSD * configs.USD_TO_THB
+	costTHB := costUSD * configs.Get().USDToTHB
 
 	return TokenUsage{
 		InputTokens:  inputTokens,
@@ -191,7 +262,7 @@ func CalculateTemplateAccountingTokenCost(inputTokens, outputTokens int) TokenUs
 	inputCost := float64(inputTokens) * configs.TEMPLATE_ACCOUNTING_INPUT_PRICE_PER_MILLION / 1_000_000
 	outputCost := float64(outputTokens) * configs.TEMPLATE_ACCOUNTING_OUTPUT_PRICE_PER_MILLION / 1_000_000
 	costUSD := inputCost + outputCost
-	costTHB := costUSD * configs.USD_TO_THB
+	costTHB := costUSD * configs.Get().USDToTHB
 
 	return TokenUsage{
 		InputTokens:  inputTokens,
@@ -210,7 +281,7 @@ func CalculateAccountingTokenCost(inputTokens, outputTokens int) TokenUsage {
 	inputCost := float64(inputTokens) * configs.ACCOUNTING_INPUT_PRICE_PER_MILLION / 1_000_000
 	outputCost := float64(outputTokens) * configs.ACCOUNTING_OUTPUT_PRICE_PER_MILLION / 1_000_000
 	costUSD := inputCost + outputCost
-	costTHB := costUSD * configs.USD_TO_THB
+	costTHB := costUSD * configs.Get().USDToTHB
 
 	return TokenUsage{
 		InputTokens:  inputTokens,
@@ -221,14 +292,50 @@ func CalculateAccountingTokenCost(inputTokens, outputTokens int) TokenUsage {
 	}
 }
 
+// GetSteps returns a copy of the finished steps (with sub-steps and tokens / cost) for metadata.steps
+func (rc *RequestContext) GetSteps() []StepLog {
+	rc = rc.root()
+	rc.stepMu.Lock()
+	defer rc.stepMu.Unlock()
+
+	steps := make([]StepLog, len(rc.Steps))
+	for i, step := range rc.Steps {
+		steps[i] = step
+		steps[i].SubSteps = append([]SubStepLog(nil), step.SubSteps...)
+		if step.Tokens != nil {
+			tokens := *step.Tokens
+			steps[i].Tokens = &tokens
+		}
+	}
+	return steps
+}
+
 // GetSummary returns a final summary of the entire request
 func (rc *RequestContext) GetSummary() map[string]interface{} {
+	rc = rc.root()
+	rc.stepMu.Lock()
+	defer rc.stepMu.Unlock()
+
 	totalDuration := time.Since(rc.StartTime).Milliseconds()
 
-	// Build step breakdown
+	// Build step breakdown (+ per-track wall time of steps that ran tracks, e.g. OCR per image)
 	stepBreakdown := make(map[string]int64)
+	trackBreakdown := make(map[string]map[string]int64)
+	var trackLogs []string
 	for _, step := range rc.Steps {
 		stepBreakdown[step.Name] = step.Duration
+		tracks := trackDurations(step.SubSteps)
+		if len(tracks) == 0 {
+			continue
+		}
+		durations := make(map[string]int64, len(tracks))
+		parts := make([]string, 0, len(tracks))
+		for _, track := range tracks {
+			durations[track.Name] = track.Duration
+			parts = append(parts, fmt.Sprintf("%s %.2fวิ", track.Name, float64(track.Duration)/1000))
+		}
+		trackBreakdown[step.Name] = durations
+		trackLogs = append(trackLogs, fmt.Sprintf("%s: %s", step.Name, strings.Join(parts, ", ")))
 	}
 
 	summary := map[string]interface{}{
@@ -247,7 +354,7 @@ func (rc *RequestContext) GetSummary() map[string]interface{} {
 		},
 	}
 
-	log.Printf("[%s] \n═══ 🎯 สรุปผล ═══")
+	log.Printf("[%s] \n═══ 🎯 สรุปผล ═══", rc.RequestID)
 	log.Printf("[%s] ⏱️  เวลารวม: %.2fวินาที | 📝 ขั้นตอน: %d | 🪙 Tokens: %s | 💰 ค่าใช้จ่าย: ฿%.2f",
 		rc.RequestID,
 		float64(totalDuration)/1000,
@@ -257,15 +364,24 @@ func (rc *RequestContext) GetSummary() map[string]interface{} {
 			formatNumber(rc.TotalTokens.OutputTokens),
 			formatNumber(rc.TotalTokens.TotalTokens)),
 		rc.TotalTokens.CostTHB)
+	for _, trackLog := range trackLogs {
+		log.Printf("[%s] 🧵 %s", rc.RequestID, trackLog)
+	}
 	log.Printf("[%s] ═══════════════════════════\n", rc.RequestID)
 
+	if len(trackBreakdown) > 0 {
+		summary["track_breakdown"] = trackBreakdown
+	}
 	return summary
 }
 
 // StartSubStep begins tracking a detailed sub-operation
+// On a track the sub-step is timed independently of other tracks running at the same time
 func (rc *RequestContext) StartSubStep(subStepName string) {
+	rc.stepMu.Lock()
 	rc.CurrentSubStep = subStepName
 	rc.CurrentSubStepStart = time.Now()
+	rc.stepMu.Unlock()
 
 	// Map sub-step names to Thai
 	subStepDesc := map[string]string{
@@ -284,12 +400,14 @@ func (rc *RequestContext) StartSubStep(subStepName string) {
 		desc = subStepName
 	}
 
-	log.Printf("[%s]    ├─ %s...", rc.RequestID, desc)
+	log.Printf("[%s]    ├─ %s%s...", rc.RequestID, rc.trackLabel(), desc)
 }
 
 // EndSubStep completes the current sub-step and records timing
 func (rc *RequestContext) EndSubStep(details string) {
+	rc.stepMu.Lock()
 	if rc.CurrentSubStep == "" {
+		rc.stepMu.Unlock()
 		return
 	}
 
@@ -300,40 +418,87 @@ func (rc *RequestContext) EndSubStep(details string) {
 		StartTime: rc.CurrentSubStepStart,
 		Duration:  duration,
 		Details:   details,
+		Track:     rc.track,
 	}
+	rc.CurrentSubStep = ""
+	rc.stepMu.Unlock()
 
-	rc.CurrentSubSteps = append(rc.CurrentSubSteps, subStepLog)
+	// Sub-steps of every track are collected on the request (under its current step)
+	root := rc.root()
+	root.stepMu.Lock()
+	root.CurrentSubSteps = append(root.CurrentSubSteps, subStepLog)
+	root.stepMu.Unlock()
 
 	detailsMsg := ""
 	if details != "" {
 		detailsMsg = " | " + details
 	}
-	log.Printf("[%s]    └─ ✅ %.2fวิ%s",
-		rc.RequestID, float64(duration)/1000, detailsMsg)
+	log.Printf("[%s]    └─ ✅ %s%.2fวิ%s",
+		rc.RequestID, rc.trackLabel(), float64(duration)/1000, detailsMsg)
+}
 
-	rc.CurrentSubStep = ""
+// trackDuration is the wall time of one track within a step
+type trackDuration struct {
+	Name     string
+	Duration int64 // ms from the start of its first sub-step to the end of its last
+}
+
+// trackDurations returns the tracks of the sub-steps in order of their first sub-step
+func trackDurations(subSteps []SubStepLog) []trackDuration {
+	type span struct{ start, end time.Time }
+	var names []string
+	spans := make(map[string]*span)
+	for _, subStep := range subSteps {
+		if subStep.Track == "" {
+			continue
+		}
+		end := subStep.StartTime.Add(time.Duration(subStep.Duration) * time.Millisecond)
+		s, ok := spans[subStep.Track]
+		if !ok {
+			names = append(names, subStep.Track)
+			spans[subStep.Track] = &span{start: subStep.StartTime, end: end}
+			continue
+		}
+		if subStep.StartTime.Before(s.start) {
+			s.start = subStep.StartTime
+		}
+		if end.After(s.end) {
+			s.end = end
+		}
+	}
+
+	sort.SliceStable(names, func(i, j int) bool { return spans[names[i]].start.Before(spans[names[j]].start) })
+	tracks := make([]trackDuration, 0, len(names))
+	for _, name := range names {
+		tracks = append(tracks, trackDuration{Name: name, Duration: spans[name].end.Sub(spans[name].start).Milliseconds()})
+	}
+	return tracks
 }
 
 // LogInfo logs info-level message with request ID prefix
 func (rc *RequestContext) LogInfo(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
-	log.Printf("[%s] ℹ️  %s", rc.RequestID, msg)
+	log.Printf("[%s] ℹ️  %s%s", rc.RequestID, rc.trackLabel(), msg)
 }
 
 // LogWarning logs warning-level message with request ID prefix
 func (rc *RequestContext) LogWarning(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
-	log.Printf("[%s] ⚠️  %s", rc.RequestID, msg)
+	log.Printf("[%s] ⚠️  %s%s", rc.RequestID, rc.trackLabel(), msg)
 }
 
 // LogError logs error-level message with request ID prefix
 func (rc *RequestContext) LogError(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
-	log.Printf("[%s] ❌ %s", rc.RequestID, msg)
+	log.Printf("[%s] ❌ %s%s", rc.RequestID, rc.trackLabel(), msg)
 }
 
 // GetPartialSummary returns a summary of completed steps (for timeout scenarios)
 func (rc *RequestContext) GetPartialSummary() map[string]interface{} {
+	rc = rc.root()
+	rc.stepMu.Lock()
+	defer rc.stepMu.Unlock()
+
 	completedSteps := []string{}
 	for _, step := range rc.Steps {
 		if step.Status == "success" {
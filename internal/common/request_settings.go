@@ -0,0 +1,70 @@
+// request_settings.go - Models, thresholds, custom fields and dimensions used by one request (global config + per-shop overrides)
+
+package common
+
+import "github.com/bosocmputer/account_ocr_gemini/configs"
+
+// RequestSettings is reported as metadata.settings
+type RequestSettings struct {
+	OCRModel                               string                `json:"ocr_model"`
+	TemplateModel                          string                `json:"template_model"`
+	TemplateAccountingModel                string                `json:"template_accounting_model"`
+	AccountingModel                        string                `json:"accounting_model"`
+	TemplateConfidenceThreshold            float64               `json:"template_confidence_threshold"`
+	HandwrittenTemplateConfidenceThreshold float64               `json:"handwritten_template_confidence_threshold"`
+	VATRate                                float64               `json:"vat_rate"`                 // Current VAT rate (percent) of the shop's own sales
+	CustomFields                           []CustomField         `json:"custom_fields,omitempty"`  // Extra receipt fields the shop wants read from its documents
+	Dimensions                             []DimensionDefinition `json:"dimensions,omitempty"`     // Dimensions booked on journal lines (project, cost center)
+	ShopOverrides                          []string              `json:"shop_overrides,omitempty"` // Fields taken from shopSettings
+}
+
+// Custom field types (receipt.custom_fields)
+const (
+	CustomFieldString  = "string"
+	CustomFieldNumber  = "number"
+	CustomFieldInteger = "integer"
+	CustomFieldBoolean = "boolean"
+	CustomFieldDate    = "date" // YYYY-MM-DD (ค.ศ.)
+)
+
+// CustomField is one shop-defined field read into receipt.custom_fields (e.g. PO number, project code)
+type CustomField struct {
+	Name        string `bson:"name" json:"name"`
+	Type        string `bson:"type" json:"type"`
+	Description string `bson:"description,omitempty" json:"description,omitempty"` // What to look for on the document
+}
+
+// DimensionDefinition is one dimension the shop books on journal lines (values in the dimensions collection)
+type DimensionDefinition struct {
+	Key      string `bson:"key" json:"key"` // entries[].dimensions key (project, cost_center, ...)
+	Name     string `bson:"name,omitempty" json:"name,omitempty"`
+	Required bool   `bson:"required,omitempty" json:"required,omitempty"` // No value found = requires review
+	Default  string `bson:"default,omitempty" json:"default,omitempty"`   // Code used when no rule or AI value applies
+}
+
+// DefaultRequestSettings returns the global configuration (no shop overrides)
+func DefaultRequestSettings() RequestSettings {
+	cfg := configs.Get()
+	return RequestSettings{
+		OCRModel:                               configs.OCR_MODEL_NAME,
+		TemplateModel:                          configs.TEMPLATE_MODEL_NAME,
+		TemplateAccountingModel:                configs.TEMPLATE_ACCOUNTING_MODEL_NAME,
+		AccountingModel:                        configs.ACCOUNTING_MODEL_NAME,
+		TemplateConfidenceThreshold:            cfg.TemplateConfidenceThreshold,
+		HandwrittenTemplateConfidenceThreshold: cfg.HandwrittenTemplateConfidenceThreshold,
+		VATRate:                                cfg.VATRate,
+	}
+}
+
+// VATRateOn returns the VAT rate of a document dated documentDate (YYYY-MM-DD)
+// Sales of a shop with its own vat_rate (e.g. 0% exporter) use that rate, everything else the rate in force on the date
+func (s RequestSettings) VATRateOn(documentDate string, sale bool) float64 {
+	if sale {
+		for _, field := range s.ShopOverrides {
+			if field == "vat_rate" {
+				return s.VATRate
+			}
+		}
+	}
+	return configs.Get().VATRateOn(documentDate)
+}
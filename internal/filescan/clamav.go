@@ -0,0 +1,98 @@
+// clamav.go - FILE_SCAN_DRIVER=clamav: clamd INSTREAM over TCP or a unix socket (CLAMAV_ADDRESS)
+
+package filescan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// clamAVChunkSize - INSTREAM sends the file in length-prefixed chunks
+const clamAVChunkSize = 64 * 1024
+
+// clamAVScanner connects to clamd for every scan (clamd closes INSTREAM connections after the reply)
+type clamAVScanner struct {
+	network string // "tcp" or "unix"
+	address string
+}
+
+func newClamAVScanner(address string) (*clamAVScanner, error) {
+	network, addr, found := strings.Cut(address, "://")
+	if !found || (network != "tcp" && network != "unix") {
+		return nil, fmt.Errorf("CLAMAV_ADDRESS must be tcp://host:port or unix:///path (got %q)", address)
+	}
+	return &clamAVScanner{network: network, address: addr}, nil
+}
+
+func (s *clamAVScanner) Name() string {
+	return DriverClamAV
+}
+
+// Scan streams content with zINSTREAM and parses "stream: OK" / "stream: <signature> FOUND"
+func (s *clamAVScanner) Scan(ctx context.Context, content io.Reader) (Verdict, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout())
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Step 1: Command + chunks ([4-byte big-endian length][data]), zero-length chunk ends the stream
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Verdict{}, fmt.Errorf("clamd: %w", err)
+	}
+	buffer := make([]byte, clamAVChunkSize)
+	header := make([]byte, 4)
+	for {
+		n, readErr := content.Read(buffer)
+		if n > 0 {
+			binary.BigEndian.PutUint32(header, uint32(n))
+			if _, err := conn.Write(header); err != nil {
+				return Verdict{}, fmt.Errorf("clamd: %w", err)
+			}
+			if _, err := conn.Write(buffer[:n]); err != nil {
+				// clamd closes the connection when StreamMaxLength is exceeded - its reply says so
+				break
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return Verdict{}, fmt.Errorf("failed to read file: %w", readErr)
+		}
+	}
+	binary.BigEndian.PutUint32(header, 0)
+	conn.Write(header)
+
+	// Step 2: Reply (null-terminated)
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return Verdict{}, fmt.Errorf("clamd: %w", err)
+	}
+	return parseClamAVReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamAVReply reads "stream: OK", "stream: Eicar-Signature FOUND" or "INSTREAM size limit exceeded. ERROR"
+func parseClamAVReply(reply string) (Verdict, error) {
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case result == "OK":
+		return Verdict{}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return Verdict{Infected: true, Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	default:
+		return Verdict{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
@@ -0,0 +1,63 @@
+// filescan.go - Malware scanning of documents before processing (FILE_SCAN_DRIVER)
+//
+// ไฟล์มาจาก URL ใดก็ได้และจากการอัปโหลด → สแกนก่อนส่งต่อให้ OCR / เก็บ
+// driver แต่ละตัว implement Scanner:
+//   clamav  ส่งไฟล์ให้ clamd ด้วยคำสั่ง INSTREAM (CLAMAV_ADDRESS)
+//   http    POST ไฟล์ไปยัง scanning API ของ deployment นั้น (FILE_SCAN_API_URL)
+// FILE_SCAN_DRIVER ว่าง = ไม่สแกน
+
+package filescan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+)
+
+// Drivers (FILE_SCAN_DRIVER)
+const (
+	DriverClamAV = "clamav"
+	DriverHTTP   = "http"
+)
+
+// Scan statuses (metadata.file_scans[].status)
+const (
+	StatusClean    = "clean"
+	StatusInfected = "infected"
+	StatusError    = "error" // Scanner unavailable - only recorded with FILE_SCAN_FAIL_OPEN=true
+)
+
+// Verdict is the outcome of one scan
+type Verdict struct {
+	Infected  bool
+	Signature string // Threat name reported by the scanner (infected only)
+}
+
+// Scanner scans the content of one file
+type Scanner interface {
+	// Name is the scanner recorded in metadata (e.g. "clamav")
+	Name() string
+	// Scan reads content to the end; an error means the file could not be judged
+	Scan(ctx context.Context, content io.Reader) (Verdict, error)
+}
+
+// New returns the scanner of FILE_SCAN_DRIVER (nil = scanning off)
+func New() (Scanner, error) {
+	switch configs.FILE_SCAN_DRIVER {
+	case "":
+		return nil, nil
+	case DriverClamAV:
+		return newClamAVScanner(configs.CLAMAV_ADDRESS)
+	case DriverHTTP:
+		return newHTTPScanner(configs.FILE_SCAN_API_URL, configs.FILE_SCAN_API_KEY), nil
+	}
+	return nil, fmt.Errorf("unknown FILE_SCAN_DRIVER %q", configs.FILE_SCAN_DRIVER)
+}
+
+// timeout is the deadline of one scan (FILE_SCAN_TIMEOUT_SEC)
+func timeout() time.Duration {
+	return time.Duration(configs.Get().FileScanTimeoutSec) * time.Second
+}
@@ -0,0 +1,67 @@
+// http.go - FILE_SCAN_DRIVER=http: POST the file to a scanning API (FILE_SCAN_API_URL)
+//
+// request:  POST <FILE_SCAN_API_URL>, body = ไฟล์ (application/octet-stream), Authorization: Bearer <FILE_SCAN_API_KEY>
+// response: 200 {"infected": false} หรือ {"infected": true, "signature": "Win.Trojan.X"}
+// status อื่น = สแกนไม่ได้ (ไม่ถือว่าไฟล์ติดไวรัส)
+
+package filescan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// httpScanner calls the scanning API of the deployment
+type httpScanner struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// scanResponse is the expected API response
+type scanResponse struct {
+	Infected  *bool  `json:"infected"`
+	Signature string `json:"signature"`
+}
+
+func newHTTPScanner(url, apiKey string) *httpScanner {
+	return &httpScanner{url: url, apiKey: apiKey, client: &http.Client{}}
+}
+
+func (s *httpScanner) Name() string {
+	return DriverHTTP
+}
+
+func (s *httpScanner) Scan(ctx context.Context, content io.Reader) (Verdict, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, content)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("scan API: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("scan API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Verdict{}, fmt.Errorf("scan API: HTTP %d", resp.StatusCode)
+	}
+
+	var result scanResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&result); err != nil {
+		return Verdict{}, fmt.Errorf("scan API: invalid response: %w", err)
+	}
+	if result.Infected == nil {
+		return Verdict{}, fmt.Errorf("scan API: response has no infected field")
+	}
+	return Verdict{Infected: *result.Infected, Signature: result.Signature}, nil
+}
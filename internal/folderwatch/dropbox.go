@@ -0,0 +1,163 @@
+// dropbox.go - Dropbox folder (HTTP API v2)
+//
+// folder = path ของโฟลเดอร์ เช่น /Receipts ("" = root ของแอป)
+// ดาวน์โหลดด้วย file ID ("id:...") - ชื่อไฟล์ภาษาไทยใส่ใน header Dropbox-API-Arg ตรง ๆ ไม่ได้
+
+package folderwatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+const (
+	dropboxAPIURL     = "https://api.dropboxapi.com/2"
+	dropboxContentURL = "https://content.dropboxapi.com/2"
+)
+
+// dropboxFolder lists / moves with the shop's Dropbox account
+type dropboxFolder struct {
+	folder string
+	client *http.Client
+}
+
+// dropboxEntry is an entry of files/list_folder
+type dropboxEntry struct {
+	Tag            string    `json:".tag"` // file, folder, deleted
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	PathDisplay    string    `json:"path_display"`
+	Size           int64     `json:"size"`
+	ServerModified time.Time `json:"server_modified"`
+}
+
+// dropboxListResponse is the response of files/list_folder(/continue)
+type dropboxListResponse struct {
+	Entries []dropboxEntry `json:"entries"`
+	Cursor  string         `json:"cursor"`
+	HasMore bool           `json:"has_more"`
+}
+
+func newDropboxFolder(folder, refreshToken string) *dropboxFolder {
+	config := &oauth2.Config{
+		ClientID:     configs.DROPBOX_APP_KEY,
+		ClientSecret: configs.DROPBOX_APP_SECRET,
+		Endpoint:     endpoints.Dropbox,
+	}
+	if folder == "/" {
+		folder = ""
+	}
+	return &dropboxFolder{
+		folder: folder,
+		client: config.Client(context.Background(), &oauth2.Token{RefreshToken: refreshToken}),
+	}
+}
+
+// List returns the files of the folder (every page)
+func (d *dropboxFolder) List(ctx context.Context) ([]File, error) {
+	var page dropboxListResponse
+	if err := d.call(ctx, "/files/list_folder", map[string]interface{}{"path": d.folder, "recursive": false}, &page); err != nil {
+		return nil, err
+	}
+	files := []File{}
+	for {
+		for _, entry := range page.Entries {
+			if entry.Tag != "file" {
+				continue
+			}
+			files = append(files, File{
+				ID:         entry.ID,
+				Name:       entry.Name,
+				Path:       entry.PathDisplay,
+				Size:       entry.Size,
+				ModifiedAt: entry.ServerModified,
+			})
+		}
+		if !page.HasMore {
+			return files, nil
+		}
+		cursor := page.Cursor
+		page = dropboxListResponse{}
+		if err := d.call(ctx, "/files/list_folder/continue", map[string]string{"cursor": cursor}, &page); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Download writes the file content to w (at most IMAGE_REJECT_MAX_BYTES - the listed size is not checked)
+func (d *dropboxFolder) Download(ctx context.Context, file File, w io.Writer) error {
+	arg, _ := json.Marshal(map[string]string{"path": file.ID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dropboxContentURL+"/files/download", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Dropbox-API-Arg", string(arg))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("dropbox download failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return dropboxError("files/download", resp)
+	}
+	if err := copyLimited(w, resp.Body); err != nil {
+		return fmt.Errorf("dropbox download failed: %w", err)
+	}
+	return nil
+}
+
+// Move moves the file to <folder>/<subfolder>/<name> (Dropbox creates the subfolder, autorename on conflicts)
+func (d *dropboxFolder) Move(ctx context.Context, file File, subfolder string) error {
+	return d.call(ctx, "/files/move_v2", map[string]interface{}{
+		"from_path":  file.ID,
+		"to_path":    path.Join("/", d.folder, subfolder, file.Name),
+		"autorename": true,
+	}, nil)
+}
+
+// call posts a JSON RPC to the Dropbox API (out = nil ignores the response)
+func (d *dropboxFolder) call(ctx context.Context, endpoint string, body interface{}, out interface{}) error {
+	payload, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dropboxAPIURL+endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("dropbox %s failed: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return dropboxError(endpoint, resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("dropbox %s: invalid response: %w", endpoint, err)
+	}
+	return nil
+}
+
+// dropboxError reads error_summary of a failed call
+func dropboxError(endpoint string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var apiErr struct {
+		ErrorSummary string `json:"error_summary"`
+	}
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.ErrorSummary != "" {
+		return fmt.Errorf("dropbox %s: HTTP %d: %s", endpoint, resp.StatusCode, apiErr.ErrorSummary)
+	}
+	return fmt.Errorf("dropbox %s: HTTP %d: %s", endpoint, resp.StatusCode, bytes.TrimSpace(body))
+}
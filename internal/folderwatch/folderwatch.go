@@ -0,0 +1,122 @@
+// folderwatch.go - Folders watched per shop (drop-folder ingestion)
+//
+// ร้านวางรูปใบเสร็จลงโฟลเดอร์ Google Drive / Dropbox หรือสแกนเนอร์ส่งไฟล์ขึ้น SFTP → runner (api.StartFolderWatcher)
+// ดึงไฟล์ใหม่มาวิเคราะห์ แล้วย้ายไปโฟลเดอร์ย่อย processed/ หรือ failed/ ในโฟลเดอร์เดิม (ร้านเห็นผลในโฟลเดอร์ของตัวเอง)
+// Google Drive / Dropbox ใช้ OAuth refresh token ของบัญชีร้าน + app credentials ของเรา (DROPBOX_APP_* / GOOGLE_DRIVE_*)
+// SFTP ใช้ login ของเซิร์ฟเวอร์ร้าน (storage.SFTPLogin)
+// ขนาดไฟล์ที่ provider แจ้งไม่ไว้ใจ: ทุก provider ดาวน์โหลดผ่าน copyLimited (IMAGE_REJECT_MAX_BYTES หรือ 100MB)
+
+package folderwatch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+)
+
+// Providers (folder_watch.provider)
+const (
+	ProviderDropbox     = "dropbox"
+	ProviderGoogleDrive = "google_drive"
+	ProviderSFTP        = "sftp"
+)
+
+// Subfolders of the watched folder the files are moved to
+const (
+	ProcessedFolder = "processed"
+	FailedFolder    = "failed"
+)
+
+// maxDownloadSize is the download cap when IMAGE_REJECT_MAX_BYTES is 0
+const maxDownloadSize = 100 * 1024 * 1024
+
+// supportedExtensions are the files analyzed (same as analyze-receipt)
+var supportedExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".pdf": true}
+
+// File is a file directly inside the watched folder
+type File struct {
+	ID         string // Provider file ID (SFTP: path@mtime)
+	Name       string // Original file name
+	Path       string // Dropbox / SFTP path (empty for Google Drive)
+	Size       int64
+	ModifiedAt time.Time
+}
+
+// Folder is a watched folder (SFTP folders also implement io.Closer - close after the poll)
+type Folder interface {
+	// List returns the files directly inside the folder (subfolders such as processed/ are not listed)
+	List(ctx context.Context) ([]File, error)
+	// Download writes the file content to w (FileTooLargeError past IMAGE_REJECT_MAX_BYTES, whatever size was listed)
+	Download(ctx context.Context, file File, w io.Writer) error
+	// Move moves the file into a subfolder of the watched folder (created when missing)
+	Move(ctx context.Context, file File, subfolder string) error
+}
+
+// Open connects to the folder of a watch with the shop's refresh token / SFTP login
+func Open(watch storage.FolderWatch) (Folder, error) {
+	if !Configured(watch.Provider) {
+		return nil, fmt.Errorf("provider %q is not configured on this server", watch.Provider)
+	}
+	if watch.Provider == ProviderSFTP {
+		return newSFTPFolder(watch.Folder, watch.SFTP)
+	}
+	if watch.RefreshToken == "" {
+		return nil, fmt.Errorf("refresh_token is missing")
+	}
+	switch watch.Provider {
+	case ProviderDropbox:
+		return newDropboxFolder(watch.Folder, watch.RefreshToken), nil
+	case ProviderGoogleDrive:
+		return newDriveFolder(watch.Folder, watch.RefreshToken)
+	}
+	return nil, fmt.Errorf("unknown provider %q", watch.Provider)
+}
+
+// Configured reports whether the app credentials of the provider are set
+func Configured(provider string) bool {
+	switch provider {
+	case ProviderDropbox:
+		return configs.DROPBOX_APP_KEY != "" && configs.DROPBOX_APP_SECRET != ""
+	case ProviderGoogleDrive:
+		return configs.GOOGLE_DRIVE_CLIENT_ID != "" && configs.GOOGLE_DRIVE_CLIENT_SECRET != ""
+	case ProviderSFTP:
+		return true // Login is per shop
+	}
+	return false
+}
+
+// SupportedFile reports whether the file is a receipt image / PDF
+func SupportedFile(name string) bool {
+	return supportedExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// FileTooLargeError - the downloaded content passed the download cap
+type FileTooLargeError struct {
+	Limit int64
+}
+
+func (e *FileTooLargeError) Error() string {
+	return fmt.Sprintf("file larger than %d bytes (IMAGE_REJECT_MAX_BYTES)", e.Limit)
+}
+
+// copyLimited copies r to w, stopping with FileTooLargeError after IMAGE_REJECT_MAX_BYTES (0 = maxDownloadSize)
+func copyLimited(w io.Writer, r io.Reader) error {
+	limit := int64(configs.Get().ImageRejectMaxBytes)
+	if limit <= 0 {
+		limit = maxDownloadSize
+	}
+	n, err := io.Copy(w, io.LimitReader(r, limit+1))
+	if err != nil {
+		return err
+	}
+	if n > limit {
+		return &FileTooLargeError{Limit: limit}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package folderwatch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+// redirectTransport sends every request to the test server (keeps the path)
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// TestProviderDownload - Dropbox and Google Drive downloads stop at IMAGE_REJECT_MAX_BYTES whatever size was listed
+func TestProviderDownload(t *testing.T) {
+	providers := map[string]func(t *testing.T, serverURL string) Folder{
+		ProviderDropbox: func(t *testing.T, serverURL string) Folder {
+			target, _ := url.Parse(serverURL)
+			return &dropboxFolder{client: &http.Client{Transport: redirectTransport{target}}}
+		},
+		ProviderGoogleDrive: func(t *testing.T, serverURL string) Folder {
+			service, err := drive.NewService(context.Background(), option.WithHTTPClient(http.DefaultClient), option.WithEndpoint(serverURL+"/"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return &driveFolder{service: service, subfolders: map[string]string{}}
+		},
+	}
+	tests := []struct {
+		name     string
+		content  string
+		maxBytes string
+		wantErr  string
+	}{
+		{name: "whole file", content: "hello world"},
+		{name: "larger than limit", content: "0123456789012345", maxBytes: "15", wantErr: "larger than 15 bytes"},
+		{name: "exactly the limit", content: "0123456789", maxBytes: "10"},
+	}
+	for provider, open := range providers {
+		for _, tt := range tests {
+			t.Run(provider+"/"+tt.name, func(t *testing.T) {
+				if tt.maxBytes != "" {
+					t.Cleanup(func() { configs.ReloadConfig() }) // runs after the env is restored
+					t.Setenv("IMAGE_REJECT_MAX_BYTES", tt.maxBytes)
+					if err := configs.ReloadConfig(); err != nil {
+						t.Fatalf("ReloadConfig: %v", err)
+					}
+				}
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(tt.content))
+				}))
+				defer server.Close()
+
+				var out bytes.Buffer
+				// Listed size is ignored - the cap applies to the bytes actually read
+				err := open(t, server.URL).Download(context.Background(), File{ID: "f1", Path: "/a.jpg", Size: 1}, &out)
+				if tt.wantErr != "" {
+					var tooLarge *FileTooLargeError
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !errors.As(err, &tooLarge) {
+						t.Fatalf("Download error = %v, want FileTooLargeError %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Download: %v", err)
+				}
+				if out.String() != tt.content {
+					t.Errorf("content = %q, want %q", out.String(), tt.content)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// gdrive.go - Google Drive folder (Drive API v3)
+//
+// folder = folder ID (ท้าย URL ของโฟลเดอร์ https://drive.google.com/drive/folders/<ID>) - รองรับ shared drive
+// ย้ายไฟล์ = เปลี่ยน parent จากโฟลเดอร์ที่เฝ้าเป็นโฟลเดอร์ย่อย processed / failed
+
+package folderwatch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+const driveFolderMimeType = "application/vnd.google-apps.folder"
+
+// driveFolder lists / moves with the shop's Google account
+type driveFolder struct {
+	folderID   string
+	service    *drive.Service
+	mu         sync.Mutex
+	subfolders map[string]string // processed / failed → folder ID
+}
+
+func newDriveFolder(folderID, refreshToken string) (*driveFolder, error) {
+	config := &oauth2.Config{
+		ClientID:     configs.GOOGLE_DRIVE_CLIENT_ID,
+		ClientSecret: configs.GOOGLE_DRIVE_CLIENT_SECRET,
+		Endpoint:     endpoints.Google,
+		Scopes:       []string{drive.DriveScope},
+	}
+	client := config.Client(context.Background(), &oauth2.Token{RefreshToken: refreshToken})
+	service, err := drive.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Google Drive client: %w", err)
+	}
+	return &driveFolder{folderID: folderID, service: service, subfolders: map[string]string{}}, nil
+}
+
+// List returns the files of the folder (every page, folders excluded)
+func (d *driveFolder) List(ctx context.Context) ([]File, error) {
+	query := fmt.Sprintf("'%s' in parents and trashed = false and mimeType != '%s'", driveQuote(d.folderID), driveFolderMimeType)
+	files := []File{}
+	err := d.service.Files.List().
+		Q(query).
+		Fields("nextPageToken, files(id, name, size, modifiedTime)").
+		SupportsAllDrives(true).
+		IncludeItemsFromAllDrives(true).
+		PageSize(100).
+		Pages(ctx, func(page *drive.FileList) error {
+			for _, f := range page.Files {
+				modifiedAt, _ := time.Parse(time.RFC3339, f.ModifiedTime)
+				files = append(files, File{ID: f.Id, Name: f.Name, Size: f.Size, ModifiedAt: modifiedAt})
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("google drive list failed: %w", err)
+	}
+	return files, nil
+}
+
+// Download writes the file content to w (at most IMAGE_REJECT_MAX_BYTES - the listed size is not checked)
+func (d *driveFolder) Download(ctx context.Context, file File, w io.Writer) error {
+	resp, err := d.service.Files.Get(file.ID).SupportsAllDrives(true).Context(ctx).Download()
+	if err != nil {
+		return fmt.Errorf("google drive download failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := copyLimited(w, resp.Body); err != nil {
+		return fmt.Errorf("google drive download failed: %w", err)
+	}
+	return nil
+}
+
+// Move re-parents the file from the watched folder to the subfolder
+func (d *driveFolder) Move(ctx context.Context, file File, subfolder string) error {
+	subfolderID, err := d.subfolderID(ctx, subfolder)
+	if err != nil {
+		return err
+	}
+	_, err = d.service.Files.Update(file.ID, &drive.File{}).
+		AddParents(subfolderID).
+		RemoveParents(d.folderID).
+		SupportsAllDrives(true).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return fmt.Errorf("google drive move failed: %w", err)
+	}
+	return nil
+}
+
+// subfolderID finds (or creates) the subfolder inside the watched folder
+func (d *driveFolder) subfolderID(ctx context.Context, name string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if id, ok := d.subfolders[name]; ok {
+		return id, nil
+	}
+
+	query := fmt.Sprintf("'%s' in parents and name = '%s' and mimeType = '%s' and trashed = false",
+		driveQuote(d.folderID), driveQuote(name), driveFolderMimeType)
+	list, err := d.service.Files.List().
+		Q(query).
+		Fields("files(id)").
+		SupportsAllDrives(true).
+		IncludeItemsFromAllDrives(true).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return "", fmt.Errorf("google drive list failed: %w", err)
+	}
+	if len(list.Files) > 0 {
+		d.subfolders[name] = list.Files[0].Id
+		return list.Files[0].Id, nil
+	}
+
+	created, err := d.service.Files.Create(&drive.File{
+		Name:     name,
+		MimeType: driveFolderMimeType,
+		Parents:  []string{d.folderID},
+	}).SupportsAllDrives(true).Fields("id").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("google drive create folder failed: %w", err)
+	}
+	d.subfolders[name] = created.Id
+	return created.Id, nil
+}
+
+// driveQuote escapes a value inside a single-quoted Drive query string
+func driveQuote(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
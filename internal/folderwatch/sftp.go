@@ -0,0 +1,175 @@
+// sftp.go - SFTP drop folder (office scanners that push scans to an SFTP server)
+//
+// ใช้ github.com/pkg/sftp บน golang.org/x/crypto/ssh
+// ข้อมูลจากเซิร์ฟเวอร์ไม่ไว้ใจ: directory ที่มีเกิน sftpMaxListEntries entry ไม่ถูกหยิบ, ไฟล์ที่ดาวน์โหลดจำกัดที่ IMAGE_REJECT_MAX_BYTES
+// ยืนยันเซิร์ฟเวอร์ด้วย host key fingerprint ที่ตั้งไว้ต่อร้าน (ไม่ยอมรับ host key อื่น)
+// ไฟล์ที่เพิ่งแก้ไขภายใน sftpSettleTime ยังไม่ถูกหยิบ - สแกนเนอร์อาจยังเขียนไม่เสร็จ
+
+package folderwatch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sftpDialTimeout = 15 * time.Second
+	sftpSettleTime  = 30 * time.Second // Files modified more recently may still be uploading
+)
+
+// sftpMaxListEntries - a directory with more entries is not polled (processed files belong in processed/)
+var sftpMaxListEntries = 10000
+
+// sftpFolder is a directory on the shop's SFTP server (one connection per poll - Close when done)
+type sftpFolder struct {
+	dir    string
+	client *sftp.Client
+	conn   io.Closer // SSH connection under client
+}
+
+func newSFTPFolder(dir string, login *storage.SFTPLogin) (*sftpFolder, error) {
+	if login == nil || login.Host == "" || login.Username == "" {
+		return nil, fmt.Errorf("sftp host and username are required")
+	}
+	var auth []ssh.AuthMethod
+	if login.PrivateKey != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(login.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sftp private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if login.Password != "" {
+		auth = append(auth, ssh.Password(login.Password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("sftp password or private key is required")
+	}
+	port := login.Port
+	if port == 0 {
+		port = 22
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(login.Host, strconv.Itoa(port)), &ssh.ClientConfig{
+		User: login.Username,
+		Auth: auth,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			if fingerprint := ssh.FingerprintSHA256(key); fingerprint != login.HostKeyFingerprint {
+				return fmt.Errorf("host key %s does not match host_key_fingerprint", fingerprint)
+			}
+			return nil
+		},
+		Timeout: sftpDialTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sftp connect failed: %w", err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sftp subsystem not available: %w", err)
+	}
+	if dir == "" {
+		dir = "." // Login directory
+	}
+	return &sftpFolder{dir: dir, client: client, conn: conn}, nil
+}
+
+// Close ends the connection
+func (f *sftpFolder) Close() error {
+	f.client.Close()
+	return f.conn.Close()
+}
+
+// abortOnDone closes the connection when ctx ends so a blocked call returns (call the result when done)
+func (f *sftpFolder) abortOnDone(ctx context.Context) func() bool {
+	return context.AfterFunc(ctx, func() { f.conn.Close() })
+}
+
+// List returns the regular files of the directory that are not being uploaded any more
+func (f *sftpFolder) List(ctx context.Context) ([]File, error) {
+	defer f.abortOnDone(ctx)()
+
+	entries, err := f.client.ReadDirContext(ctx, f.dir)
+	if err != nil {
+		return nil, fmt.Errorf("sftp readdir %s failed: %w", f.dir, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err // Listing cut short - entries are incomplete
+	}
+	if len(entries) > sftpMaxListEntries {
+		return nil, fmt.Errorf("sftp directory %s has %d entries (limit %d) - move old files out of the folder", f.dir, len(entries), sftpMaxListEntries)
+	}
+
+	files := []File{}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || strings.HasPrefix(entry.Name(), ".") || time.Since(entry.ModTime()) < sftpSettleTime {
+			continue
+		}
+		filePath := path.Join(f.dir, entry.Name())
+		files = append(files, File{
+			// Path + mtime: a new scan uploaded later under the same name is a new file
+			ID:         fmt.Sprintf("%s@%d", filePath, entry.ModTime().Unix()),
+			Name:       entry.Name(),
+			Path:       filePath,
+			Size:       entry.Size(),
+			ModifiedAt: entry.ModTime(),
+		})
+	}
+	return files, nil
+}
+
+// Download writes the file content to w (at most IMAGE_REJECT_MAX_BYTES - the listed size is only what the server claims)
+func (f *sftpFolder) Download(ctx context.Context, file File, w io.Writer) error {
+	defer f.abortOnDone(ctx)()
+
+	remote, err := f.client.Open(file.Path)
+	if err != nil {
+		return fmt.Errorf("sftp open %s failed: %w", file.Path, err)
+	}
+	defer remote.Close()
+
+	if err := copyLimited(w, remote); err != nil {
+		return fmt.Errorf("sftp read %s failed: %w", file.Path, err)
+	}
+	return nil
+}
+
+// Move renames the file into <dir>/<subfolder>/ (created when missing, a timestamp is added on name conflicts)
+func (f *sftpFolder) Move(ctx context.Context, file File, subfolder string) error {
+	defer f.abortOnDone(ctx)()
+
+	target := path.Join(f.dir, subfolder)
+	// Fails when the directory exists - the rename below reports a real problem
+	f.client.Mkdir(target)
+
+	newPath := path.Join(target, file.Name)
+	err := f.rename(file.Path, newPath)
+	if err != nil {
+		ext := path.Ext(file.Name)
+		newPath = path.Join(target, fmt.Sprintf("%s_%d%s", strings.TrimSuffix(file.Name, ext), time.Now().Unix(), ext))
+		err = f.rename(file.Path, newPath)
+	}
+	if err != nil {
+		return fmt.Errorf("sftp rename %s failed: %w", file.Path, err)
+	}
+	return nil
+}
+
+// rename moves oldPath to newPath, failing when newPath exists (some servers overwrite on rename)
+func (f *sftpFolder) rename(oldPath, newPath string) error {
+	if _, err := f.client.Lstat(newPath); err == nil {
+		return fmt.Errorf("%s already exists", newPath)
+	}
+	return f.client.Rename(oldPath, newPath)
+}
@@ -0,0 +1,164 @@
+package folderwatch
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/pkg/sftp"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv("GEMINI_API_KEY") == "" {
+		os.Setenv("GEMINI_API_KEY", "test")
+	}
+	configs.LoadConfig()
+	os.Exit(m.Run())
+}
+
+// pipeConn joins the two pipe ends of the in-process SFTP server
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// testSFTPFolder serves dir over an in-process SFTP server (no SSH)
+func testSFTPFolder(t *testing.T, dir string) *sftpFolder {
+	t.Helper()
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	server, err := sftp.NewServer(pipeConn{serverIn, serverOut})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	client, err := sftp.NewClientPipe(clientIn, clientOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Close() // Ends the client's receive loop first
+		client.Close()
+	})
+	return &sftpFolder{dir: dir, client: client, conn: client}
+}
+
+// writeTestFile creates a file modified age ago
+func writeTestFile(t *testing.T, name, content string, age time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(-age)
+	if err := os.Chtimes(name, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSFTPList(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.jpg"), "scan", time.Hour)
+	writeTestFile(t, filepath.Join(dir, "uploading.jpg"), "scan", 0) // Still within sftpSettleTime
+	writeTestFile(t, filepath.Join(dir, ".hidden.jpg"), "scan", time.Hour)
+	if err := os.Mkdir(filepath.Join(dir, ProcessedFolder), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := testSFTPFolder(t, dir).List(context.Background())
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "a.jpg" || files[0].Path != filepath.Join(dir, "a.jpg") || files[0].Size != 4 {
+		t.Fatalf("files = %+v, want a.jpg only", files)
+	}
+	if want := files[0].Path + "@"; !strings.HasPrefix(files[0].ID, want) {
+		t.Errorf("ID = %q, want path@mtime", files[0].ID)
+	}
+}
+
+func TestSFTPListEntryLimit(t *testing.T) {
+	limit := sftpMaxListEntries
+	sftpMaxListEntries = 2
+	t.Cleanup(func() { sftpMaxListEntries = limit })
+	dir := t.TempDir()
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		writeTestFile(t, filepath.Join(dir, name), "scan", time.Hour)
+	}
+
+	_, err := testSFTPFolder(t, dir).List(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "limit 2") {
+		t.Fatalf("List error = %v, want entry limit", err)
+	}
+}
+
+func TestSFTPDownload(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		maxBytes string
+		wantErr  string
+	}{
+		{name: "whole file", content: "hello world"},
+		{name: "larger than limit", content: "0123456789012345", maxBytes: "15", wantErr: "larger than 15 bytes"},
+		{name: "exactly the limit", content: "0123456789", maxBytes: "10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.maxBytes != "" {
+				t.Cleanup(func() { configs.ReloadConfig() }) // runs after the env is restored
+				t.Setenv("IMAGE_REJECT_MAX_BYTES", tt.maxBytes)
+				if err := configs.ReloadConfig(); err != nil {
+					t.Fatalf("ReloadConfig: %v", err)
+				}
+			}
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, "a.jpg")
+			writeTestFile(t, filePath, tt.content, time.Hour)
+
+			var out bytes.Buffer
+			// Listed size is ignored - the cap applies to the bytes actually read
+			err := testSFTPFolder(t, dir).Download(context.Background(), File{Path: filePath, Size: 1}, &out)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Download error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			if out.String() != tt.content {
+				t.Errorf("content = %q, want %q", out.String(), tt.content)
+			}
+		})
+	}
+}
+
+func TestSFTPMove(t *testing.T) {
+	dir := t.TempDir()
+	folder := testSFTPFolder(t, dir)
+	for i := 0; i < 2; i++ { // The second file with the same name gets a timestamp
+		filePath := filepath.Join(dir, "a.jpg")
+		writeTestFile(t, filePath, "scan", time.Hour)
+		if err := folder.Move(context.Background(), File{Name: "a.jpg", Path: filePath}, ProcessedFolder); err != nil {
+			t.Fatalf("Move %d: %v", i, err)
+		}
+	}
+
+	moved, err := os.ReadDir(filepath.Join(dir, ProcessedFolder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved) != 2 || moved[0].Name() != "a.jpg" || !strings.HasPrefix(moved[1].Name(), "a_") {
+		t.Errorf("processed/ = %v, want a.jpg and a_<time>.jpg", moved)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.jpg")); !os.IsNotExist(err) {
+		t.Errorf("a.jpg still in the watched folder (%v)", err)
+	}
+}
@@ -0,0 +1,53 @@
+// mockai.go - Canned AI responses for MOCK_AI=true (local development and CI)
+//
+// Fixtures are recorded model responses (same JSON the real models return)
+// Embedded defaults live in fixtures/ - set MOCK_AI_FIXTURES_DIR to use your own recordings
+// (files: ocr.json, template_match.json, accounting.json, verification.json, field_locations.json)
+
+package mockai
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+)
+
+// Fixture names
+const (
+	FixtureOCR           = "ocr.json"
+	FixtureTemplateMatch = "template_match.json"
+	FixtureAccounting    = "accounting.json"
+	FixtureVerification  = "verification.json"
+	FixtureFieldLocation = "field_locations.json"
+)
+
+//go:embed fixtures/*.json
+var embeddedFixtures embed.FS
+
+// Enabled reports whether AI calls should be replaced with fixtures
+func Enabled() bool {
+	return configs.MOCK_AI
+}
+
+// Fixture returns a recorded response by name
+// MOCK_AI_FIXTURES_DIR takes priority over the embedded defaults
+func Fixture(name string) ([]byte, error) {
+	if configs.MOCK_AI_FIXTURES_DIR != "" {
+		data, err := os.ReadFile(filepath.Join(configs.MOCK_AI_FIXTURES_DIR, name))
+		if err == nil {
+			return data, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read mock fixture %s: %w", name, err)
+		}
+	}
+
+	data, err := embeddedFixtures.ReadFile("fixtures/" + name)
+	if err != nil {
+		return nil, fmt.Errorf("mock fixture %s not found: %w", name, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,199 @@
+// Package money represents baht amounts as integer satang
+//
+// ยอดเงินเดิมเป็น float64 → บวกหลายรายการแล้วได้ 21905.959999999999 ใน response และต้องเทียบ Debit = Credit ด้วย tolerance 0.01
+// Amount เก็บเป็นสตางค์ (int64) → บวก/ลบ/เทียบได้ตรงทุกครั้ง
+// แปลงเฉพาะที่ขอบ: อ่านจาก JSON/AI (FromBaht, Parse - ค่าที่อ่านไม่ได้ → ok=false ไม่ใช่ 0) และเขียนออก (MarshalJSON = ตัวเลขทศนิยม 2 ตำแหน่ง, Baht สำหรับ map / BSON)
+package money
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Amount is a baht amount in satang (1 baht = 100)
+type Amount int64
+
+// FromBaht converts a baht value, rounding to the nearest satang
+func FromBaht(baht float64) Amount {
+	return Amount(math.Round(baht * 100))
+}
+
+// Parse reads an amount decoded from JSON / BSON: a number or a formatted string ("1,234.50", "฿ 99", "US$ 12.50", "¥12,000")
+// Accounting negatives in parentheses are negative ("(1,234.50)" = -1234.50)
+// nil and "" are an absent amount (0, true); anything else that is not an amount ("12-15", "N/A", a bool) is (0, false)
+func Parse(value interface{}) (Amount, bool) {
+	switch v := value.(type) {
+	case nil:
+		return 0, true
+	case Amount:
+		return v, true
+	case float64:
+		return FromBaht(v), true
+	case float32:
+		return FromBaht(float64(v)), true
+	case int:
+		return Amount(v) * 100, true
+	case int32:
+		return Amount(v) * 100, true
+	case int64:
+		return Amount(v) * 100, true
+	case string:
+		return parseText(v)
+	}
+	return 0, false
+}
+
+// parseText parses a formatted amount - separators, spaces and currency symbols / names are dropped
+func parseText(text string) (Amount, bool) {
+	if strings.TrimSpace(text) == "" {
+		return 0, true
+	}
+	// Keep digits, the decimal point, the sign and parentheses
+	cleaned := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '(' || r == ')' {
+			return r
+		}
+		return -1
+	}, text)
+	negative := false
+	if strings.HasPrefix(cleaned, "(") && strings.HasSuffix(cleaned, ")") {
+		negative, cleaned = true, cleaned[1:len(cleaned)-1]
+		if strings.HasPrefix(cleaned, "-") {
+			return 0, false // "(-100)" is ambiguous
+		}
+	}
+	baht, err := strconv.ParseFloat(cleaned, 64)
+	if err != nil || math.IsInf(baht, 0) {
+		return 0, false
+	}
+	if negative {
+		baht = -baht
+	}
+	return FromBaht(baht), true
+}
+
+// Baht returns the amount as a float64 with at most 2 decimals (JSON maps, BSON documents, ratios)
+func (a Amount) Baht() float64 {
+	return float64(a) / 100
+}
+
+// Abs returns the absolute amount
+func (a Amount) Abs() Amount {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// String formats the amount with 2 decimals ("-1234.50")
+func (a Amount) String() string {
+	sign := ""
+	satang := int64(a)
+	if satang < 0 {
+		sign, satang = "-", -satang
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, satang/100, satang%100)
+}
+
+// Thousands formats the amount with thousands separators for printed documents ("-1,234.50")
+func (a Amount) Thousands() string {
+	text := a.String()
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+	whole, decimals := text[:len(text)-3], text[len(text)-3:]
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+	return sign + whole + decimals
+}
+
+// thaiDigits / thaiPlaces spell numbers in Thai (ThaiText)
+var (
+	thaiDigits = []string{"ศูนย์", "หนึ่ง", "สอง", "สาม", "สี่", "ห้า", "หก", "เจ็ด", "แปด", "เก้า"}
+	thaiPlaces = []string{"", "สิบ", "ร้อย", "พัน", "หมื่น", "แสน"}
+)
+
+// ThaiText spells the amount in Thai for printed vouchers ("หนึ่งพันสองร้อยบาทห้าสิบสตางค์", "หนึ่งร้อยเอ็ดบาทถ้วน")
+func (a Amount) ThaiText() string {
+	satang := int64(a.Abs())
+	text := ""
+	if a < 0 {
+		text = "ลบ"
+	}
+	if baht := satang / 100; baht > 0 {
+		text += thaiNumber(baht) + "บาท"
+	} else if satang == 0 {
+		return "ศูนย์บาทถ้วน"
+	}
+	if satang%100 == 0 {
+		return text + "ถ้วน"
+	}
+	return text + thaiNumber(satang%100) + "สตางค์"
+}
+
+// thaiNumber spells a positive number (ล้าน groups of 6 digits; 1 in the units = เอ็ด, 2 in the tens = ยี่)
+func thaiNumber(n int64) string {
+	text := ""
+	if n >= 1000000 {
+		text = thaiNumber(n/1000000) + "ล้าน"
+		n %= 1000000
+	}
+	if n == 0 {
+		return text
+	}
+	digits := strconv.FormatInt(n, 10)
+	for i, d := range digits {
+		digit := int(d - '0')
+		place := len(digits) - 1 - i
+		switch {
+		case digit == 0:
+			continue
+		case place == 1 && digit == 1:
+			text += "สิบ"
+		case place == 1 && digit == 2:
+			text += "ยี่สิบ"
+		case place == 0 && digit == 1 && (len(digits) > 1 || text != ""):
+			text += "เอ็ด"
+		default:
+			text += thaiDigits[digit] + thaiPlaces[place]
+		}
+	}
+	return text
+}
+
+// MarshalJSON writes the amount as a JSON number with 2 decimals (21905.96)
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a number, a formatted string or null (= 0)
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*a = 0
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		text, err := strconv.Unquote(string(data))
+		if err != nil {
+			return fmt.Errorf("money: invalid amount %s", data)
+		}
+		amount, ok := Parse(text)
+		if !ok {
+			return fmt.Errorf("money: invalid amount %s", data)
+		}
+		*a = amount
+		return nil
+	}
+	baht, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return fmt.Errorf("money: invalid amount %s", data)
+	}
+	*a = FromBaht(baht)
+	return nil
+}
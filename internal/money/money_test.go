@@ -0,0 +1,87 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  interface{}
+		want   Amount
+		wantOK bool
+	}{
+		{name: "nil", value: nil, want: 0, wantOK: true},
+		{name: "empty", value: "  ", want: 0, wantOK: true},
+		{name: "float", value: 21905.959999999999, want: 2190596, wantOK: true},
+		{name: "int", value: 95, want: 9500, wantOK: true},
+		{name: "int64", value: int64(-3), want: -300, wantOK: true},
+		{name: "thousands", value: "1,234.50", want: 123450, wantOK: true},
+		{name: "baht sign", value: "฿ 99", want: 9900, wantOK: true},
+		{name: "currency name", value: "US$ 12.50", want: 1250, wantOK: true},
+		{name: "thai unit", value: "1,070.00 บาท", want: 107000, wantOK: true},
+		{name: "minus", value: "-100", want: -10000, wantOK: true},
+		{name: "parentheses", value: "(100)", want: -10000, wantOK: true},
+		{name: "parentheses with separators", value: "(1,234.50)", want: -123450, wantOK: true},
+		{name: "parentheses with currency", value: "฿(99.50)", want: -9950, wantOK: true},
+		{name: "range", value: "12-15", wantOK: false},
+		{name: "text", value: "N/A", wantOK: false},
+		{name: "two decimal points", value: "1.2.3", wantOK: false},
+		{name: "parentheses around a negative", value: "(-100)", wantOK: false},
+		{name: "trailing note in parentheses", value: "1,070.00 (7%)", wantOK: false},
+		{name: "bool", value: true, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Parse(tt.value)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("Parse(%#v) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	var amounts struct {
+		Number Amount `json:"number"`
+		Text   Amount `json:"text"`
+		Null   Amount `json:"null"`
+	}
+	if err := json.Unmarshal([]byte(`{"number": 10.005, "text": "(1,000)", "null": null}`), &amounts); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if amounts.Number != 1001 || amounts.Text != -100000 || amounts.Null != 0 {
+		t.Errorf("amounts = %+v", amounts)
+	}
+
+	var bad Amount
+	if err := json.Unmarshal([]byte(`"12-15"`), &bad); err == nil {
+		t.Errorf("Unmarshal(\"12-15\") = %v, want an error", bad)
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	tests := []struct {
+		amount    Amount
+		text      string
+		thousands string
+		thai      string
+	}{
+		{amount: 123450, text: "1234.50", thousands: "1,234.50", thai: "หนึ่งพันสองร้อยสามสิบสี่บาทห้าสิบสตางค์"},
+		{amount: -10000, text: "-100.00", thousands: "-100.00", thai: "ลบหนึ่งร้อยบาทถ้วน"},
+		{amount: 10100, text: "101.00", thousands: "101.00", thai: "หนึ่งร้อยเอ็ดบาทถ้วน"},
+		{amount: 0, text: "0.00", thousands: "0.00", thai: "ศูนย์บาทถ้วน"},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.String(); got != tt.text {
+			t.Errorf("String(%d) = %q, want %q", tt.amount, got, tt.text)
+		}
+		if got := tt.amount.Thousands(); got != tt.thousands {
+			t.Errorf("Thousands(%d) = %q, want %q", tt.amount, got, tt.thousands)
+		}
+		if got := tt.amount.ThaiText(); got != tt.thai {
+			t.Errorf("ThaiText(%d) = %q, want %q", tt.amount, got, tt.thai)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// account_prefilter.go - Relevance pre-filter of the chart of accounts sent to Phase 3 (full mode)
+//
+// ร้านที่มีผังบัญชีหลายร้อยบัญชีเคยส่งทุกบัญชี level 3+ ให้ AI ทุกครั้ง → input tokens ของ Phase 3 บวม
+// เลือกเฉพาะ top-N บัญชีที่คล้ายข้อความ OCR (SuggestAccounts) + บัญชีที่ต้องใช้เสมอ (เงินสด/ธนาคาร/VAT/หัก ณ ที่จ่าย/เจ้าหนี้/ลูกหนี้)
+// ผังบัญชีเล็ก หรือไม่มีบัญชีไหนคล้ายข้อความเลย → ส่งทั้งหมดเหมือนเดิม
+
+package processor
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// essentialAccountKeywords - accounts needed by almost every entry regardless of the document text
+var essentialAccountKeywords = []string{
+	"เงินสด", "เงินฝาก", "ธนาคาร", "เงินทดรอง",
+	"ภาษีซื้อ", "ภาษีขาย", "ภาษีมูลค่าเพิ่ม", "หัก ณ ที่จ่าย",
+	"เจ้าหนี้การค้า", "ลูกหนี้การค้า", "ส่วนลด", "ปัดเศษ",
+	"cash", "bank", "vat", "withholding",
+}
+
+// AccountPrefilterResult is the chart of accounts kept for the Phase 3 prompt
+type AccountPrefilterResult struct {
+	Accounts      []bson.M `json:"-"`
+	Applied       bool     `json:"applied"` // false = sent in full (chart small enough / no relevant account found)
+	TotalAccounts int      `json:"total_accounts"`
+	SentAccounts  int      `json:"sent_accounts"`
+	Relevant      int      `json:"relevant"`  // Top-N by similarity to the OCR text
+	Essential     int      `json:"essential"` // Always-needed accounts + keepCodes not already relevant
+}
+
+// FilterRelevantAccounts keeps the topN accounts most similar to the OCR text plus the essential accounts
+// Charts with at most minAccounts accounts (or topN ≤ 0) are returned unchanged
+// keepCodes are always kept (e.g. the learned account of the matched creditor). Kept accounts stay in chart order
+func FilterRelevantAccounts(ocrText string, accounts []bson.M, topN, minAccounts int, keepCodes ...string) AccountPrefilterResult {
+	result := AccountPrefilterResult{Accounts: accounts, TotalAccounts: len(accounts), SentAccounts: len(accounts)}
+	if topN <= 0 || len(accounts) <= minAccounts || len(accounts) <= topN {
+		return result
+	}
+
+	// Step 1: Top-N by similarity to the OCR text
+	keep := map[string]bool{}
+	for _, suggestion := range SuggestAccounts(ocrText, accounts, topN) {
+		keep[suggestion.AccountCode] = true
+	}
+	relevant := len(keep)
+	if relevant == 0 {
+		return result
+	}
+
+	// Step 2: Essential accounts + caller's codes
+	for _, code := range keepCodes {
+		if code != "" {
+			keep[code] = true
+		}
+	}
+	for _, acc := range accounts {
+		name := strings.ToLower(getStringFromInterface(acc["accountname"]))
+		for _, keyword := range essentialAccountKeywords {
+			if strings.Contains(name, keyword) {
+				keep[getStringFromInterface(acc["accountcode"])] = true
+				break
+			}
+		}
+	}
+
+	filtered := make([]bson.M, 0, len(keep))
+	for _, acc := range accounts {
+		if keep[getStringFromInterface(acc["accountcode"])] {
+			filtered = append(filtered, acc)
+		}
+	}
+	result.Accounts = filtered
+	result.Applied = true
+	result.SentAccounts = len(filtered)
+	result.Relevant = relevant
+	result.Essential = len(filtered) - relevant
+	return result
+}
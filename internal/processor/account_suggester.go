@@ -0,0 +1,193 @@
+// account_suggester.go - Rank chart-of-accounts entries for a free-text description
+//
+// ใช้ทั้ง endpoint แนะนำบัญชี (frontend บันทึกรายการเอง) และตรวจบัญชีที่ AI เลือกหลัง Phase 3
+// ภาษาไทยไม่เว้นวรรคระหว่างคำ → ใช้ character bigram แทนการตัดคำ + คำที่มีความหมายเกี่ยวข้อง (semanticPairs)
+
+package processor
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MinAccountSuggestionScore - suggestions below this score are dropped
+const MinAccountSuggestionScore = 30.0
+
+// AccountSuggestion is one ranked account for a description
+type AccountSuggestion struct {
+	AccountCode string  `json:"account_code"`
+	AccountName string  `json:"account_name"`
+	Score       float64 `json:"score"`      // 0-100
+	MatchedBy   string  `json:"matched_by"` // code, name, semantic, fuzzy
+}
+
+// AccountCheck flags a journal entry whose account is not in the chart of accounts
+type AccountCheck struct {
+	EntryIndex  int                 `json:"entry_index"`
+	AccountCode string              `json:"account_code"`
+	AccountName string              `json:"account_name"`
+	Issue       string              `json:"issue"` // unknown_account_code
+	Suggestions []AccountSuggestion `json:"suggestions"`
+}
+
+// SuggestAccounts returns up to limit posting accounts (level ≥ 3) ranked by similarity to description
+func SuggestAccounts(description string, accounts []bson.M, limit int) []AccountSuggestion {
+	text := normalizeSuggestText(description)
+	suggestions := []AccountSuggestion{}
+	if text == "" {
+		return suggestions
+	}
+	textBigrams := runeBigrams(stripExpensePrefix(text))
+
+	for _, acc := range accounts {
+		if !isPostingAccount(acc) {
+			continue
+		}
+		code := getStringFromInterface(acc["accountcode"])
+		name := getStringFromInterface(acc["accountname"])
+		normalizedName := normalizeSuggestText(name)
+		if code == "" || normalizedName == "" {
+			continue
+		}
+
+		suggestion := AccountSuggestion{AccountCode: code, AccountName: name}
+		switch {
+		case contains(strings.Fields(text), code):
+			suggestion.Score, suggestion.MatchedBy = 100, "code"
+		case strings.Contains(text, stripExpensePrefix(normalizedName)):
+			suggestion.Score, suggestion.MatchedBy = 95, "name"
+		default:
+			// How much of the account name appears in the text (texts are usually longer than names)
+			nameBigrams := runeBigrams(stripExpensePrefix(normalizedName))
+			common := commonBigramCount(textBigrams, nameBigrams)
+			coverage, dice := 0.0, 0.0
+			if len(nameBigrams) > 0 {
+				coverage = float64(common) / float64(len(nameBigrams))
+				dice = 2 * float64(common) / float64(len(nameBigrams)+len(textBigrams))
+			}
+			suggestion.Score, suggestion.MatchedBy = 100*(0.7*coverage+0.3*dice), "fuzzy"
+
+			if semanticRelated(text, normalizedName) {
+				suggestion.Score, suggestion.MatchedBy = math.Min(100, suggestion.Score+40), "semantic"
+			}
+		}
+
+		suggestion.Score = math.Round(suggestion.Score*10) / 10
+		if suggestion.Score >= MinAccountSuggestionScore {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Score > suggestions[j].Score
+	})
+	if limit > 0 && len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+// CheckEntryAccounts flags entries whose account_code is not in the chart of accounts
+// and suggests replacements from the entry's account name / description
+func CheckEntryAccounts(accountingEntry map[string]interface{}, accounts []bson.M) []AccountCheck {
+	known := map[string]bool{}
+	for _, acc := range accounts {
+		known[getStringFromInterface(acc["accountcode"])] = true
+	}
+
+	checks := []AccountCheck{}
+	entries, _ := accountingEntry["entries"].([]interface{})
+	for i, e := range entries {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		code := getStringFromInterface(entry["account_code"])
+		if known[code] {
+			continue
+		}
+		name := getStringFromInterface(entry["account_name"])
+		checks = append(checks, AccountCheck{
+			EntryIndex:  i,
+			AccountCode: code,
+			AccountName: name,
+			Issue:       "unknown_account_code",
+			Suggestions: SuggestAccounts(name+" "+getStringFromInterface(entry["description"]), accounts, 3),
+		})
+	}
+	return checks
+}
+
+// isPostingAccount - level 3-5 accounts are used in journal entries (level 1-2 are headers)
+// Accounts without accountlevel are treated as posting accounts (callers may pass the compressed list)
+func isPostingAccount(acc bson.M) bool {
+	switch level := acc["accountlevel"].(type) {
+	case int32:
+		return level >= 3
+	case int64:
+		return level >= 3
+	case float64:
+		return level >= 3
+	case int:
+		return level >= 3
+	}
+	return true
+}
+
+// normalizeSuggestText is normalizeText that keeps Thai vowel/tone marks (unicode.Mn)
+// normalizeText drops them ("น้ำมัน" → "นำมน") which breaks substring and semantic matching
+func normalizeSuggestText(text string) string {
+	result := strings.Builder{}
+	for _, r := range strings.Join(strings.Fields(strings.ToLower(text)), " ") {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.Is(unicode.Mn, r) {
+			result.WriteRune(r)
+		}
+	}
+	return result.String()
+}
+
+// stripExpensePrefix removes the generic "ค่า" prefix (ค่าน้ำมัน → น้ำมัน) so it doesn't dominate the score
+func stripExpensePrefix(text string) string {
+	return strings.TrimPrefix(text, "ค่า")
+}
+
+// semanticRelated reports whether text and account name share a semanticPairs group
+func semanticRelated(text, name string) bool {
+	for key, related := range semanticPairs {
+		group := append([]string{key}, related...)
+		inText, inName := false, false
+		for _, word := range group {
+			inText = inText || strings.Contains(text, word)
+			inName = inName || strings.Contains(name, word)
+		}
+		if inText && inName {
+			return true
+		}
+	}
+	return false
+}
+
+// runeBigrams returns the set of character bigrams (spaces ignored)
+func runeBigrams(text string) map[string]bool {
+	runes := []rune(strings.ReplaceAll(text, " ", ""))
+	bigrams := map[string]bool{}
+	for i := 0; i+1 < len(runes); i++ {
+		bigrams[string(runes[i:i+2])] = true
+	}
+	return bigrams
+}
+
+// commonBigramCount counts bigrams present in both sets
+func commonBigramCount(a, b map[string]bool) int {
+	count := 0
+	for bigram := range b {
+		if a[bigram] {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,150 @@
+// adjustment_note.go - Credit note / debit note detection and reversal entries
+//
+// ใบลดหนี้ (credit note) เดิมถูกบันทึกเหมือนใบกำกับภาษีปกติ (เดบิตค่าใช้จ่าย / เครดิตเจ้าหนี้) → ยอดค่าใช้จ่ายเพิ่มแทนที่จะลด
+// ตรวจจากข้อความ OCR + source_images[].type:
+//   - ใบลดหนี้ที่ถูกบันทึกแบบใบกำกับภาษี → กลับด้านเดบิต/เครดิตทุกรายการ (ลดยอดบัญชีเดิม)
+//   - ใบเพิ่มหนี้ (debit note) → บันทึกแบบใบกำกับภาษีปกติอยู่แล้ว ไม่กลับด้าน
+// อ้างอิงเลขที่ใบกำกับภาษีเดิมใน accounting_entry.original_document_number (ไม่พบ = ต้องตรวจสอบ)
+
+package processor
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Adjustment note document types (source_images[].type)
+const (
+	DocumentTypeCreditNote = "credit_note" // ใบลดหนี้
+	DocumentTypeDebitNote  = "debit_note"  // ใบเพิ่มหนี้
+)
+
+// AdjustmentNoteResult is surfaced as accounting_entry.adjustment_note
+type AdjustmentNoteResult struct {
+	DocumentType           string `json:"document_type"`
+	DetectedBy             string `json:"detected_by"` // ai / ocr_text
+	OriginalDocumentNumber string `json:"original_document_number,omitempty"`
+	Reversed               bool   `json:"reversed"`
+	NeedsReview            bool   `json:"needs_review"`
+	Note                   string `json:"note"`
+}
+
+var (
+	creditNoteKeywords = []string{"ใบลดหนี้", "credit note", "ใบแจ้งลดหนี้"}
+	debitNoteKeywords  = []string{"ใบเพิ่มหนี้", "debit note", "ใบแจ้งเพิ่มหนี้"}
+
+	// อ้างอิงใบกำกับภาษีเลขที่ / ใบกำกับภาษีเดิมเลขที่ / Ref. Invoice No. followed by the original document number
+	originalDocumentPattern = regexp.MustCompile(`(?i)(?:อ้างอิง\s*(?:ใบกำกับภาษี|ใบแจ้งหนี้|ใบเสร็จ\p{Thai}*)?\s*(?:เดิม)?\s*(?:เลขที่)?|ใบกำกับภาษีเดิม\s*(?:เลขที่)?|ref(?:erence)?\.?\s*(?:invoice|inv|tax\s*invoice)?\s*(?:no\.?)?|original\s*invoice\s*(?:no\.?)?)\s*[:：#]?\s*([A-Z0-9][A-Z0-9\-/]{3,})`)
+)
+
+// DetectAdjustmentNote returns credit_note / debit_note ("" = regular document)
+// The AI's source_images[].type wins; OCR text keywords are the fallback
+func DetectAdjustmentNote(accountingResponse map[string]interface{}, ocrText string) (string, string) {
+	if sourceImages, ok := accountingResponse["source_images"].([]interface{}); ok {
+		for _, si := range sourceImages {
+			image, ok := si.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch strings.ToLower(strings.TrimSpace(getStringFromInterface(image["type"]))) {
+			case DocumentTypeCreditNote:
+				return DocumentTypeCreditNote, "ai"
+			case DocumentTypeDebitNote:
+				return DocumentTypeDebitNote, "ai"
+			}
+		}
+	}
+
+	lower := strings.ToLower(ocrText)
+	for _, keyword := range creditNoteKeywords {
+		if strings.Contains(lower, keyword) {
+			return DocumentTypeCreditNote, "ocr_text"
+		}
+	}
+	for _, keyword := range debitNoteKeywords {
+		if strings.Contains(lower, keyword) {
+			return DocumentTypeDebitNote, "ocr_text"
+		}
+	}
+	return "", ""
+}
+
+// FindOriginalDocumentNumber extracts the referenced original invoice number from OCR text
+func FindOriginalDocumentNumber(ocrText string) string {
+	for _, m := range originalDocumentPattern.FindAllStringSubmatch(ocrText, -1) {
+		number := strings.ToUpper(m[1])
+		if strings.ContainsAny(number, "0123456789") && !(len(number) == 13 && taxIDPattern.MatchString(number)) {
+			return number
+		}
+	}
+	return ""
+}
+
+// ApplyAdjustmentNote reverses a credit note that was booked like an invoice and annotates the entry
+// accountingEntry is the response accounting_entry (entries are modified in place)
+// originalNumber is the AI's reference (receipt.original_document_number) - OCR text is searched when empty
+func ApplyAdjustmentNote(accountingEntry map[string]interface{}, documentType, detectedBy, originalNumber, ocrText string) AdjustmentNoteResult {
+	result := AdjustmentNoteResult{DocumentType: documentType, DetectedBy: detectedBy}
+
+	if originalNumber == "" {
+		originalNumber = FindOriginalDocumentNumber(ocrText)
+	}
+	result.OriginalDocumentNumber = strings.TrimSpace(originalNumber)
+
+	entriesRaw, _ := accountingEntry["entries"].([]interface{})
+	direction := DetectDirection(accountingEntry)
+
+	switch documentType {
+	case DocumentTypeCreditNote:
+		if !bookedLikeInvoice(entriesRaw, direction) {
+			result.Note = "ใบลดหนี้บันทึกเป็นรายการกลับด้านแล้ว"
+			break
+		}
+		for _, e := range entriesRaw {
+			entry, ok := e.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			debit, credit := entry["debit"], entry["credit"]
+			entry["debit"], entry["credit"] = credit, debit
+		}
+		result.Reversed = true
+		result.Note = "ใบลดหนี้ถูกบันทึกแบบใบกำกับภาษี → กลับด้านเดบิต/เครดิตเพื่อลดยอดบัญชีเดิม"
+	case DocumentTypeDebitNote:
+		result.Note = "ใบเพิ่มหนี้บันทึกเพิ่มยอดบัญชีเดิม (เหมือนใบกำกับภาษี)"
+	}
+
+	if result.OriginalDocumentNumber == "" {
+		result.NeedsReview = true
+		result.Note += " - ไม่พบเลขที่ใบกำกับภาษีเดิม"
+	} else {
+		accountingEntry["original_document_number"] = result.OriginalDocumentNumber
+	}
+	if len(entriesRaw) == 0 {
+		result.NeedsReview = true
+	}
+
+	accountingEntry["adjustment_note"] = result
+	return result
+}
+
+// bookedLikeInvoice reports whether the entry increases the original accounts:
+// purchase = expense/cost (5xxx) on the debit side, sale = revenue (4xxx) on the credit side
+func bookedLikeInvoice(entriesRaw []interface{}, direction string) bool {
+	for _, e := range entriesRaw {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		code := strings.TrimSpace(getStringFromInterface(entry["account_code"]))
+		debit := amountFromInterface(entry["debit"])
+		credit := amountFromInterface(entry["credit"])
+		if direction == DirectionSale && strings.HasPrefix(code, "4") && credit > debit {
+			return true
+		}
+		if direction == DirectionPurchase && strings.HasPrefix(code, "5") && debit > credit {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+// balance_correction.go - Suggested fix for journal entries off by a few satang
+//
+// AI คำนวณ VAT / ยอดแยกรายการแล้วปัดเศษไม่ตรงกัน → Debit กับ Credit ต่างกัน 0.01-0.05 บาท (balanced=false)
+// แทนที่จะบอกแค่ว่าไม่สมดุล เสนอว่าควรแก้รายการไหนเท่าไร:
+//   1. รายการภาษีซื้อ/ภาษีขาย (ปัดเศษ VAT - พบบ่อยที่สุด)
+//   2. ไม่มี VAT → รายการใหญ่สุดของฝั่งที่ไม่ใช่ยอดรวมเอกสาร (ยอดรวมที่จ่าย/รับตามเอกสารถือว่าถูก)
+// ส่วนต่างเกิน maxDifference = ผิดจริง ไม่ใช่เศษ → ไม่เสนอ (ให้ผู้ใช้แก้เอง)
+
+package processor
+
+import (
+	"fmt"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Balance correction reasons (validation.balance_correction.reason)
+const (
+	BalanceCorrectionVATRounding = "vat_rounding" // VAT line adjusted
+	BalanceCorrectionLargestLine = "largest_line" // No VAT line - largest line of the non-total side adjusted
+)
+
+// BalanceCorrection is surfaced as validation.balance_correction
+type BalanceCorrection struct {
+	Difference      money.Amount `json:"difference"` // Total debit - total credit
+	LineIndex       int          `json:"line_index"` // Index in accounting_entry.entries
+	AccountCode     string       `json:"account_code"`
+	AccountName     string       `json:"account_name"`
+	Side            string       `json:"side"` // "debit" or "credit"
+	CurrentAmount   money.Amount `json:"current_amount"`
+	SuggestedAmount money.Amount `json:"suggested_amount"`
+	Reason          string       `json:"reason"`
+	Applied         bool         `json:"applied"` // true = entry already corrected (shop auto-apply)
+	Message         string       `json:"message"`
+}
+
+// SuggestBalanceCorrection returns the line to adjust so debits equal credits
+// Returns nil when the entry is balanced, the difference exceeds maxDifference (0 = off), an amount is unreadable or no line can absorb it
+func SuggestBalanceCorrection(accountingEntry, receipt map[string]interface{}, accounts []bson.M, maxDifference float64) *BalanceCorrection {
+	entriesRaw, ok := accountingEntry["entries"].([]interface{})
+	if !ok || len(entriesRaw) == 0 || maxDifference <= 0 {
+		return nil
+	}
+
+	// Step 1: Difference in satang
+	var totalDebit, totalCredit money.Amount
+	lines := make([]map[string]interface{}, len(entriesRaw))
+	for i, e := range entriesRaw {
+		line, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		lines[i] = line
+		debit, debitOK := money.Parse(line["debit"])
+		credit, creditOK := money.Parse(line["credit"])
+		if !debitOK || !creditOK {
+			return nil // An unreadable amount is not a rounding difference - leave it for review
+		}
+		totalDebit += debit
+		totalCredit += credit
+	}
+	difference := totalDebit - totalCredit
+	if difference == 0 || difference.Abs() > money.FromBaht(maxDifference) {
+		return nil
+	}
+
+	// Step 2: Line to adjust - VAT first, then the largest line of the side without the document total
+	vatAccounts := map[string]bool{}
+	for _, acc := range accounts {
+		if isVATAccountName(getStringFromInterface(acc["accountname"])) {
+			vatAccounts[getStringFromInterface(acc["accountcode"])] = true
+		}
+	}
+	index, side, reason := -1, "", ""
+	for i, line := range lines {
+		if line == nil {
+			continue
+		}
+		if vatAccounts[getStringFromInterface(line["account_code"])] || isVATAccountName(getStringFromInterface(line["account_name"])) {
+			index, side, reason = i, lineSide(line), BalanceCorrectionVATRounding
+			break
+		}
+	}
+	if index < 0 {
+		side = "debit"
+		if total, _ := money.Parse(receipt["total"]); total > 0 && sideHasAmount(lines, "debit", total) && !sideHasAmount(lines, "credit", total) {
+			side = "credit"
+		}
+		index, reason = largestLineIndex(lines, side), BalanceCorrectionLargestLine
+	}
+	if index < 0 {
+		return nil
+	}
+
+	// Step 3: Debit side absorbs the difference by decreasing (debit > credit), credit side by increasing
+	current, _ := money.Parse(lines[index][side]) // Every line parsed in Step 1
+	suggested := current - difference
+	if side == "credit" {
+		suggested = current + difference
+	}
+	if suggested <= 0 {
+		return nil
+	}
+
+	correction := &BalanceCorrection{
+		Difference:      difference,
+		LineIndex:       index,
+		AccountCode:     getStringFromInterface(lines[index]["account_code"]),
+		AccountName:     getStringFromInterface(lines[index]["account_name"]),
+		Side:            side,
+		CurrentAmount:   current,
+		SuggestedAmount: suggested,
+		Reason:          reason,
+	}
+	correction.Message = fmt.Sprintf("ยอดไม่สมดุล %s บาท - แนะนำแก้ %s %s (%s) จาก %s เป็น %s",
+		difference.Abs(), correction.AccountCode, correction.AccountName, side, current, suggested)
+	return correction
+}
+
+// ApplyBalanceCorrection sets the suggested amount on the entry line (modified in place)
+func ApplyBalanceCorrection(accountingEntry map[string]interface{}, correction *BalanceCorrection) {
+	entriesRaw, _ := accountingEntry["entries"].([]interface{})
+	if correction.LineIndex >= len(entriesRaw) {
+		return
+	}
+	line, ok := entriesRaw[correction.LineIndex].(map[string]interface{})
+	if !ok {
+		return
+	}
+	line[correction.Side] = correction.SuggestedAmount.Baht()
+	correction.Applied = true
+	correction.Message = fmt.Sprintf("ปรับเศษ %s บาทอัตโนมัติ: %s %s (%s) %s → %s",
+		correction.Difference.Abs(), correction.AccountCode, correction.AccountName, correction.Side, correction.CurrentAmount, correction.SuggestedAmount)
+}
+
+// lineSide returns the side carrying the line amount
+func lineSide(line map[string]interface{}) string {
+	if amountFromInterface(line["credit"]) > amountFromInterface(line["debit"]) {
+		return "credit"
+	}
+	return "debit"
+}
+
+// sideHasAmount reports whether a line on side carries exactly amount
+func sideHasAmount(lines []map[string]interface{}, side string, amount money.Amount) bool {
+	for _, line := range lines {
+		if line == nil {
+			continue
+		}
+		if lineAmount, ok := money.Parse(line[side]); ok && lineAmount == amount {
+			return true
+		}
+	}
+	return false
+}
+
+// largestLineIndex returns the index of the largest line on side (-1 = no line on that side)
+func largestLineIndex(lines []map[string]interface{}, side string) int {
+	index := -1
+	for i, line := range lines {
+		if line == nil || amountFromInterface(line[side]) <= 0 {
+			continue
+		}
+		if index < 0 || amountFromInterface(line[side]) > amountFromInterface(lines[index][side]) {
+			index = i
+		}
+	}
+	return index
+}
@@ -0,0 +1,68 @@
+// branch.go - Branch (สาขา) of the document issuer from OCR text
+//
+// ใบกำกับภาษีเต็มรูปต้องระบุสาขาของผู้ออก: "สาขาที่ 00123" หรือ "สำนักงานใหญ่" (= 00000)
+// ใช้ข้อความที่พบก่อน - ส่วนหัวของผู้ขายอยู่ก่อนข้อมูลผู้ซื้อ (ผู้ซื้อก็มีสาขาของตัวเองในเอกสารเดียวกัน)
+
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+)
+
+// BranchInfo is the branch found in the OCR text
+type BranchInfo struct {
+	BranchCode string `json:"branch_code"` // 5 digits, 00000 = head office
+	HeadOffice bool   `json:"head_office"`
+	Text       string `json:"text"` // OCR text the branch was read from
+}
+
+// CreditorBranchMatch is surfaced as accounting_entry.creditor_branch
+type CreditorBranchMatch struct {
+	CreditorCode string `json:"creditor_code"`
+	BranchInfo
+	BranchName string `json:"branch_name,omitempty"`
+	Registered bool   `json:"registered"` // Branch already known for this creditor (creditorBranches)
+}
+
+var (
+	// สาขาที่ 00123 / สาขาเลขที่ 1 / สาขา 00001 / Branch No. 00123 / Branch: 2
+	branchNumberPattern = regexp.MustCompile(`(?i)(?:สาขา\s*(?:ที่|เลขที่)?|branch\s*(?:no\.?|number|code)?)\s*[:：#.]?\s*(\d{1,5})\b`)
+	headOfficePattern   = regexp.MustCompile(`(?i)สำนักงานใหญ่|head\s*office`)
+)
+
+// ExtractBranch returns the first branch mentioned in the OCR text (nil = no branch)
+func ExtractBranch(text string) *BranchInfo {
+	numbered := branchNumberPattern.FindStringSubmatchIndex(text)
+	headOffice := headOfficePattern.FindStringIndex(text)
+
+	switch {
+	case numbered == nil && headOffice == nil:
+		return nil
+	case numbered == nil || (headOffice != nil && headOffice[0] < numbered[0]):
+		return &BranchInfo{BranchCode: storage.HeadOfficeBranchCode, HeadOffice: true, Text: text[headOffice[0]:headOffice[1]]}
+	}
+
+	number, err := strconv.Atoi(text[numbered[2]:numbered[3]])
+	if err != nil {
+		return nil
+	}
+	code := fmt.Sprintf("%05d", number)
+	return &BranchInfo{BranchCode: code, HeadOffice: code == storage.HeadOfficeBranchCode, Text: text[numbered[0]:numbered[1]]}
+}
+
+// MatchCreditorBranch attaches the extracted branch to the creditor (name from the registered branch, if any)
+func MatchCreditorBranch(creditorCode string, branch BranchInfo, known []storage.CreditorBranch) CreditorBranchMatch {
+	match := CreditorBranchMatch{CreditorCode: creditorCode, BranchInfo: branch}
+	for _, b := range known {
+		if b.BranchCode == branch.BranchCode {
+			match.BranchName = b.BranchName
+			match.Registered = true
+			break
+		}
+	}
+	return match
+}
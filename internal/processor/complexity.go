@@ -0,0 +1,127 @@
+// complexity.go - Estimate the processing time of an analysis before it runs out of time
+//
+// ประเมินจากจำนวนรูป (ก่อน OCR) และความยาวข้อความ OCR (หลัง OCR):
+//   ก่อน OCR:  base + รูป × seconds_per_image
+//   หลัง OCR:  เวลาที่ใช้ไปแล้ว + base + ตัวอักษร / 1,000 × seconds_per_1k_chars
+// เกินเวลาที่อนุญาต → แนะนำให้แบ่งรูปเป็นหลาย request (แต่ละชุดไม่เกิน warn ratio ของเวลา)
+
+package processor
+
+import (
+	"fmt"
+	"math"
+)
+
+// Complexity levels (metadata.complexity.level)
+const (
+	ComplexityOK       = "ok"
+	ComplexityWarning  = "warning"  // Above warn ratio of the time limit
+	ComplexityExceeded = "exceeded" // Estimated time above the limit - split the request
+)
+
+// ComplexityHeuristics are the per-request cost factors (seconds)
+type ComplexityHeuristics struct {
+	BaseSeconds       float64 // Template match + accounting + validation of a document
+	SecondsPerImage   float64 // Download + OCR of one image
+	SecondsPer1KChars float64 // Accounting time per 1,000 OCR characters
+	WarnRatio         float64 // Warn above this share of the limit (0-1)
+}
+
+// ComplexityImage is one image of the request (Characters < 0 = not read yet)
+type ComplexityImage struct {
+	Index      int
+	Characters int
+}
+
+// ComplexityEstimate is surfaced as metadata.complexity / the too_complex error
+type ComplexityEstimate struct {
+	Stage            string  `json:"stage"` // "pre_ocr" or "post_ocr"
+	Images           int     `json:"images"`
+	OCRCharacters    int     `json:"ocr_characters,omitempty"`
+	ElapsedSeconds   float64 `json:"elapsed_seconds,omitempty"` // Already spent (post_ocr)
+	EstimatedSeconds float64 `json:"estimated_seconds"`         // Whole analysis
+	LimitSeconds     float64 `json:"limit_seconds"`
+	Level            string  `json:"level"`
+	SuggestedBatches [][]int `json:"suggested_batches,omitempty"` // Image indexes per request when splitting
+	Message          string  `json:"message,omitempty"`
+}
+
+// EstimateComplexity estimates the analysis time of the images against the limit
+// elapsedSeconds = time already spent (0 before OCR)
+func EstimateComplexity(images []ComplexityImage, elapsedSeconds, limitSeconds float64, h ComplexityHeuristics) ComplexityEstimate {
+	estimate := ComplexityEstimate{Stage: "pre_ocr", Images: len(images), LimitSeconds: limitSeconds, Level: ComplexityOK}
+	estimated := h.BaseSeconds
+	for _, img := range images {
+		if img.Characters >= 0 {
+			estimate.Stage = "post_ocr"
+			estimate.OCRCharacters += img.Characters
+		}
+	}
+	if estimate.Stage == "post_ocr" {
+		// OCR done - the elapsed time already contains it
+		estimate.ElapsedSeconds = math.Round(elapsedSeconds*10) / 10
+		estimated += elapsedSeconds + float64(estimate.OCRCharacters)/1000*h.SecondsPer1KChars
+	} else {
+		estimated += elapsedSeconds + float64(len(images))*h.SecondsPerImage
+	}
+	estimate.EstimatedSeconds = math.Round(estimated*10) / 10
+
+	switch {
+	case limitSeconds <= 0:
+		return estimate
+	case estimated > limitSeconds:
+		estimate.Level = ComplexityExceeded
+	case h.WarnRatio > 0 && estimated > limitSeconds*h.WarnRatio:
+		estimate.Level = ComplexityWarning
+	default:
+		return estimate
+	}
+
+	estimate.SuggestedBatches = splitComplexityBatches(images, limitSeconds, h)
+	estimate.Message = complexityMessage(estimate)
+	return estimate
+}
+
+// splitComplexityBatches groups the images in order so each batch is estimated within the warn ratio of the limit
+// (nil when splitting cannot help - a single image, or every batch would hold one image anyway)
+func splitComplexityBatches(images []ComplexityImage, limitSeconds float64, h ComplexityHeuristics) [][]int {
+	if len(images) < 2 {
+		return nil
+	}
+	budget := limitSeconds
+	if h.WarnRatio > 0 && h.WarnRatio < 1 {
+		budget = limitSeconds * h.WarnRatio
+	}
+	var batches [][]int
+	var batch []int
+	used := h.BaseSeconds
+	for _, img := range images {
+		cost := h.SecondsPerImage
+		if img.Characters > 0 {
+			cost += float64(img.Characters) / 1000 * h.SecondsPer1KChars
+		}
+		if len(batch) > 0 && used+cost > budget {
+			batches = append(batches, batch)
+			batch, used = nil, h.BaseSeconds
+		}
+		batch = append(batch, img.Index)
+		used += cost
+	}
+	batches = append(batches, batch)
+	if len(batches) < 2 {
+		return nil
+	}
+	return batches
+}
+
+// complexityMessage explains the estimate to the client
+func complexityMessage(estimate ComplexityEstimate) string {
+	text := fmt.Sprintf("ประเมินเวลาประมวลผล %.0f วินาที (จำกัด %.0f วินาที)", estimate.EstimatedSeconds, estimate.LimitSeconds)
+	if estimate.Level == ComplexityWarning {
+		text = "ใกล้เวลาที่กำหนด - " + text
+	}
+	if len(estimate.SuggestedBatches) > 0 {
+		return fmt.Sprintf("%s แนะนำให้แบ่งเป็น %d request: %v", text, len(estimate.SuggestedBatches), estimate.SuggestedBatches)
+	}
+	return text + " เอกสารยาวมาก แนะนำให้ถ่ายรูปแยกเป็นส่วน ๆ หรือเพิ่ม max_processing_seconds"
+}
@@ -9,6 +9,7 @@ import (
 	"math"
 
 	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
 )
 
 // ConfidenceFactors เก็บคะแนนของแต่ละปัจจัย
@@ -121,8 +122,11 @@ func getPartyConfidenceScore(vendorResult *VendorMatchResult, accountingEntry ma
 
 	// ถ้าเป็นเอกสารขาย (มี debtor)
 	if debtorCode != "" && debtorCode != "null" {
-		// ใช้คะแนนจาก debtor matching
-		// ถ้า debtor_code มีค่า แสดงว่าจับคู่สำเร็จ ให้คะแนน 80
+		// ใช้คะแนนจาก debtor pre-matching ถ้าเป็นลูกหนี้รายเดียวกัน
+		if vendorResult != nil && vendorResult.Found && vendorResult.Party == PartyDebtor && vendorResult.Code == debtorCode {
+			return vendorResult.Similarity
+		}
+		// ไม่งั้น debtor_code มาจาก AI Phase 3 ให้คะแนน 80
 		return 80.0
 	}
 
@@ -130,7 +134,7 @@ func getPartyConfidenceScore(vendorResult *VendorMatchResult, accountingEntry ma
 	if creditorCode != "" && creditorCode != "null" {
 		// ถ้ามี creditor_code แล้ว แสดงว่าจับคู่สำเร็จ (จาก vendor_pre_matching หรือ AI Phase 3)
 		// ใช้คะแนนจาก vendorResult ถ้ามี ไม่งั้นให้คะแนน 80 (matched)
-		if vendorResult != nil && vendorResult.Found {
+		if vendorResult != nil && vendorResult.Found && vendorResult.Party != PartyDebtor {
 			return vendorResult.Similarity // ใช้คะแนนจาก vendor_pre_matching
 		}
 		return 80.0 // AI Phase 3 matched successfully
@@ -242,9 +246,10 @@ func calculateFieldValidationScore(accountingEntry map[string]interface{}) float
 		}
 
 		// ตรวจสอบว่ามี debit หรือ credit อย่างน้อย 1 อย่าง
-		debit := getFloatFromInterface(entryMap["debit"])
-		credit := getFloatFromInterface(entryMap["credit"])
-		if debit == 0 && credit == 0 {
+		// ยอดที่อ่านไม่ได้ (เช่น "12-15") นับเป็น entry ที่ผิด ไม่ใช่ 0
+		debit, debitOK := money.Parse(entryMap["debit"])
+		credit, creditOK := money.Parse(entryMap["credit"])
+		if !debitOK || !creditOK || (debit == 0 && credit == 0) {
 			invalidCount++
 		}
 	}
@@ -409,7 +414,7 @@ func generateBreakdown(
 	return breakdown
 }
 
-// getFloatFromInterface แปลงค่าจาก interface{} เป็น float64
+// getFloatFromInterface แปลงค่าจาก interface{} เป็น float64 (คะแนน / อัตรา - ยอดเงินใช้ amountFromInterface หรือ money.Parse)
 func getFloatFromInterface(val interface{}) float64 {
 	if val == nil {
 		return 0.0
@@ -430,3 +435,10 @@ func getFloatFromInterface(val interface{}) float64 {
 		return 0.0
 	}
 }
+
+// amountFromInterface อ่านยอดเงิน (ตัวเลข หรือข้อความเช่น "(1,234.50)") - อ่านไม่ได้ = 0
+// ที่ต้องรายงานยอดที่อ่านไม่ได้ใช้ money.Parse โดยตรง
+func amountFromInterface(val interface{}) money.Amount {
+	amount, _ := money.Parse(val)
+	return amount
+}
@@ -0,0 +1,95 @@
+// creditor_prefilter.go - Candidate pre-filter of the creditor list sent to Phase 3
+//
+// ร้านที่มีเจ้าหนี้หลายพันรายเคยส่งรายชื่อทั้งหมดให้ AI ทุกครั้ง → prompt บวม
+// ให้คะแนนชื่อเจ้าหนี้แต่ละรายกับข้อความ OCR (มีชื่ออยู่ในข้อความ = 100, ไม่งั้นใช้สัดส่วน bigram ของชื่อที่พบในข้อความ)
+// แล้วส่งเฉพาะ top-K ที่คะแนนถึงเกณฑ์ + เจ้าหนี้ที่ vendor pre-matching เจอ
+// ไม่มีรายไหนถึงเกณฑ์เลย → ส่งทั้งหมดเหมือนเดิม (อาจเป็นเจ้าหนี้ที่ชื่อใน OCR อ่านผิดมาก ให้ AI ตัดสินเอง)
+
+package processor
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// creditorPrefilterMinNameRunes - shorter normalized names match almost any text and are never scored
+const creditorPrefilterMinNameRunes = 3
+
+// CreditorPrefilterResult is the creditor list kept for the Phase 3 prompt
+type CreditorPrefilterResult struct {
+	Creditors      []bson.M `json:"-"`
+	Applied        bool     `json:"applied"` // false = sent in full (list small enough / no candidate above the threshold)
+	TotalCreditors int      `json:"total_creditors"`
+	SentCreditors  int      `json:"sent_creditors"`
+	Candidates     int      `json:"candidates"` // Top-K scoring at least the threshold
+	TopScore       float64  `json:"top_score"`
+}
+
+// FilterCandidateCreditors keeps the topK creditors whose name best matches the OCR text (score ≥ minScore)
+// creditors are the compressed {"code", "name"} entries. Lists with at most topK creditors (or topK ≤ 0) are returned unchanged
+// keepCodes are always kept (e.g. the creditor found by vendor pre-matching). Kept creditors stay in list order
+func FilterCandidateCreditors(ocrText string, creditors []bson.M, topK int, minScore float64, keepCodes ...string) CreditorPrefilterResult {
+	result := CreditorPrefilterResult{Creditors: creditors, TotalCreditors: len(creditors), SentCreditors: len(creditors)}
+	if topK <= 0 || len(creditors) <= topK {
+		return result
+	}
+
+	// Step 1: Score every creditor name against the OCR text (normalized once)
+	normalizedText := normalizeVendorName(ocrText)
+	compactText := strings.ReplaceAll(normalizedText, " ", "")
+	textBigrams := runeBigrams(normalizedText)
+
+	type scoredCreditor struct {
+		code  string
+		score float64
+	}
+	var scored []scoredCreditor
+	for _, creditor := range creditors {
+		name := strings.ReplaceAll(normalizeVendorName(getStringFromInterface(creditor["name"])), " ", "")
+		if utf8.RuneCountInString(name) < creditorPrefilterMinNameRunes {
+			continue
+		}
+		score := 100.0
+		if !strings.Contains(compactText, name) {
+			nameBigrams := runeBigrams(name)
+			score = float64(commonBigramCount(textBigrams, nameBigrams)) / float64(len(nameBigrams)) * 100
+		}
+		if score >= minScore {
+			scored = append(scored, scoredCreditor{code: getStringFromInterface(creditor["code"]), score: score})
+		}
+	}
+	if len(scored) == 0 {
+		return result
+	}
+
+	// Step 2: Top-K candidates + caller's codes
+	sort.SliceStable(scored, func(i, j int) bool { return scored[i].score > scored[j].score })
+	if len(scored) > topK {
+		scored = scored[:topK]
+	}
+	keep := map[string]bool{}
+	for _, candidate := range scored {
+		keep[candidate.code] = true
+	}
+	for _, code := range keepCodes {
+		if code != "" {
+			keep[code] = true
+		}
+	}
+
+	filtered := make([]bson.M, 0, len(keep))
+	for _, creditor := range creditors {
+		if keep[getStringFromInterface(creditor["code"])] {
+			filtered = append(filtered, creditor)
+		}
+	}
+	result.Creditors = filtered
+	result.Applied = true
+	result.SentCreditors = len(filtered)
+	result.Candidates = len(scored)
+	result.TopScore = scored[0].score
+	return result
+}
@@ -0,0 +1,255 @@
+// dimensions.go - Dimension (project / cost center) of each journal line
+//
+// ร้านกำหนดประเภท dimension ใน shopSettings.dimensions และค่าที่ใช้ได้ใน collection dimensions
+// ลำดับการเลือกค่าต่อ dimension:
+//   1. กฎ: คำค้น (keywords) ของค่าใดพบในข้อความ OCR → ใช้กับทุกรายการ (คำค้นยาวสุดชนะ)
+//   2. AI: entries[].dimensions ที่ AI อ่านจากเอกสาร (ต่อรายการ - รายการที่ AI ไม่ระบุใช้ค่าที่ AI ใช้มากที่สุดในเอกสาร)
+//   3. ค่า default ของ dimension
+// ค่าที่ไม่อยู่ใน master / ปิดใช้แล้ว ถูกตัดออก และ dimension ที่ required แต่ไม่มีค่า → ต้องตรวจสอบ
+
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+)
+
+// Dimension value sources (validation.dimensions.assigned[].source)
+const (
+	DimensionSourceRule    = "rule"    // Keyword of the value found in the OCR text
+	DimensionSourceAI      = "ai"      // Read by the AI (entries[].dimensions)
+	DimensionSourceDefault = "default" // shopSettings.dimensions[].default
+)
+
+// Dimension problems (validation.dimensions.issues[].problem)
+const (
+	DimensionProblemUnknown  = "unknown"  // Code not in the dimensions collection
+	DimensionProblemInactive = "inactive" // Value closed (e.g. finished project)
+	DimensionProblemMissing  = "missing"  // Required dimension without a value
+)
+
+// DimensionAssignment is the value of one dimension (most lines of the entry)
+type DimensionAssignment struct {
+	Key     string `json:"key"`
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	Keyword string `json:"keyword,omitempty"` // Keyword that matched (rule)
+}
+
+// DimensionIssue is one value removed from / missing on the entry lines
+type DimensionIssue struct {
+	Key     string `json:"key"`
+	Code    string `json:"code,omitempty"`
+	Problem string `json:"problem"`
+	Lines   []int  `json:"lines"` // Indexes in accounting_entry.entries
+	Message string `json:"message"`
+}
+
+// DimensionResult is surfaced as validation.dimensions
+type DimensionResult struct {
+	Assigned []DimensionAssignment `json:"assigned"`
+	Issues   []DimensionIssue      `json:"issues,omitempty"`
+}
+
+// RequiresReview reports whether a value was rejected or a required dimension is missing
+func (r DimensionResult) RequiresReview() bool {
+	return len(r.Issues) > 0
+}
+
+// AssignDimensions sets entries[].dimensions of the entry (modified in place) and validates the values
+// values = the shop's dimension values keyed by dimension key; returns nil when the shop has no dimensions
+func AssignDimensions(accountingEntry map[string]interface{}, definitions []common.DimensionDefinition, values map[string][]storage.Dimension, ocrText string) *DimensionResult {
+	entriesRaw, ok := accountingEntry["entries"].([]interface{})
+	if len(definitions) == 0 || !ok {
+		return nil
+	}
+	result := &DimensionResult{Assigned: []DimensionAssignment{}}
+	normalizedText := normalizeKeywordText(ocrText)
+
+	lines := make([]map[string]interface{}, len(entriesRaw))
+	aiValues := make([]map[string]interface{}, len(entriesRaw))
+	for i, e := range entriesRaw {
+		if line, ok := e.(map[string]interface{}); ok {
+			lines[i] = line
+			aiValues[i], _ = line["dimensions"].(map[string]interface{})
+			delete(line, "dimensions")
+		}
+	}
+
+	for _, definition := range definitions {
+		// Step 1: Document-wide value from the keywords (wins over the AI)
+		rule, keyword := matchDimensionKeyword(values[definition.Key], normalizedText)
+
+		issues := map[string]*DimensionIssue{}
+		var issueOrder []string // First occurrence first - stable output
+		addIssue := func(code, problem string, line int) {
+			id := problem + "|" + code
+			if issues[id] == nil {
+				issues[id] = &DimensionIssue{Key: definition.Key, Code: code, Problem: problem}
+				issueOrder = append(issueOrder, id)
+			}
+			issues[id].Lines = append(issues[id].Lines, line)
+		}
+		// validCode resolves a code / name against the master values (issue + "" when rejected)
+		validCode := func(code string, line int) string {
+			value := findDimension(values[definition.Key], code)
+			switch {
+			case value == nil:
+				addIssue(code, DimensionProblemUnknown, line)
+				return ""
+			case value.Inactive:
+				addIssue(value.Code, DimensionProblemInactive, line)
+				return ""
+			}
+			return value.Code
+		}
+
+		// Step 2: AI values per line (unless a rule matched) - lines without one take the AI's value of the document (lines of one document share it)
+		lineAI := make([]string, len(lines))
+		aiCounts := map[string]int{}
+		for i, line := range lines {
+			if line == nil || rule != nil {
+				continue
+			}
+			if code := dimensionText(aiValues[i][definition.Key]); code != "" {
+				if lineAI[i] = validCode(code, i); lineAI[i] != "" {
+					aiCounts[lineAI[i]]++
+				}
+			}
+		}
+		documentAI := mostUsedDimension(aiCounts)
+
+		// Step 3: Per line - rule → AI → default
+		counts := map[string]int{}
+		sources := map[string]string{}
+		for i, line := range lines {
+			if line == nil {
+				continue
+			}
+			code, source := "", ""
+			switch {
+			case rule != nil:
+				code, source = rule.Code, DimensionSourceRule
+			case lineAI[i] != "":
+				code, source = lineAI[i], DimensionSourceAI
+			case documentAI != "":
+				code, source = documentAI, DimensionSourceAI
+			case definition.Default != "":
+				code, source = validCode(definition.Default, i), DimensionSourceDefault
+			}
+			if code == "" {
+				if definition.Required {
+					addIssue("", DimensionProblemMissing, i)
+				}
+				continue
+			}
+			lineDimensions, _ := line["dimensions"].(map[string]interface{})
+			if lineDimensions == nil {
+				lineDimensions = map[string]interface{}{}
+				line["dimensions"] = lineDimensions
+			}
+			lineDimensions[definition.Key] = code
+			counts[code]++
+			if sources[code] == "" {
+				sources[code] = source
+			}
+		}
+
+		// Step 4: Report the most used value and the problems of this dimension
+		if best := mostUsedDimension(counts); best != "" {
+			assignment := DimensionAssignment{Key: definition.Key, Code: best, Source: sources[best]}
+			if value := findDimension(values[definition.Key], best); value != nil {
+				assignment.Name = value.Name
+			}
+			if assignment.Source == DimensionSourceRule {
+				assignment.Keyword = keyword
+			}
+			result.Assigned = append(result.Assigned, assignment)
+		}
+		for _, id := range issueOrder {
+			issue := issues[id]
+			issue.Message = dimensionIssueMessage(definition, *issue)
+			result.Issues = append(result.Issues, *issue)
+		}
+	}
+	return result
+}
+
+// matchDimensionKeyword returns the active value whose keyword appears in the text (longest keyword wins)
+func matchDimensionKeyword(values []storage.Dimension, normalizedText string) (*storage.Dimension, string) {
+	var match *storage.Dimension
+	matched := ""
+	for i := range values {
+		if values[i].Inactive {
+			continue
+		}
+		for _, keyword := range values[i].Keywords {
+			normalized := normalizeKeywordText(keyword)
+			if normalized != "" && len(normalized) > len(normalizeKeywordText(matched)) && strings.Contains(normalizedText, normalized) {
+				match, matched = &values[i], keyword
+			}
+		}
+	}
+	return match, matched
+}
+
+// findDimension looks a value up by code, then by name (the AI may answer with the name)
+func findDimension(values []storage.Dimension, code string) *storage.Dimension {
+	for i := range values {
+		if strings.EqualFold(values[i].Code, code) {
+			return &values[i]
+		}
+	}
+	for i := range values {
+		if values[i].Name != "" && normalizeKeywordText(values[i].Name) == normalizeKeywordText(code) {
+			return &values[i]
+		}
+	}
+	return nil
+}
+
+// mostUsedDimension returns the code set on most lines (ties: smallest code, for stable output)
+func mostUsedDimension(counts map[string]int) string {
+	best := ""
+	for code, count := range counts {
+		if best == "" || count > counts[best] || (count == counts[best] && code < best) {
+			best = code
+		}
+	}
+	return best
+}
+
+// dimensionIssueMessage describes an issue for the accountant
+func dimensionIssueMessage(definition common.DimensionDefinition, issue DimensionIssue) string {
+	label := definition.Name
+	if label == "" {
+		label = definition.Key
+	}
+	switch issue.Problem {
+	case DimensionProblemUnknown:
+		return fmt.Sprintf("%s %q ไม่มีในรายการ dimension ของร้าน - ไม่ใช้ค่านี้ (%d รายการ)", label, issue.Code, len(issue.Lines))
+	case DimensionProblemInactive:
+		return fmt.Sprintf("%s %s ปิดใช้งานแล้ว - ไม่ใช้ค่านี้ (%d รายการ)", label, issue.Code, len(issue.Lines))
+	}
+	return fmt.Sprintf("ต้องระบุ %s แต่ไม่พบในเอกสาร (%d รายการ)", label, len(issue.Lines))
+}
+
+// dimensionText reads a dimension value returned by the AI ("" = none)
+func dimensionText(value interface{}) string {
+	return strings.TrimSpace(getStringFromInterface(value))
+}
+
+// normalizeKeywordText lowercases and removes spaces (OCR splits / joins words unpredictably)
+func normalizeKeywordText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, text)
+}
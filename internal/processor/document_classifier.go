@@ -0,0 +1,152 @@
+// document_classifier.go - Classify a document from its OCR text (no accounting analysis)
+//
+// ใช้กับ POST /api/v1/classify-document ให้ frontend แยกประเภทเอกสารก่อนส่งวิเคราะห์เต็ม
+// ให้คะแนนจาก keyword ในข้อความ OCR แล้วเลือกประเภทที่เฉพาะเจาะจงที่สุดที่ผ่านเกณฑ์ก่อน
+// (บิลค่าไฟมักพิมพ์ "ใบเสร็จรับเงิน/ใบกำกับภาษี" ด้วย → ต้องตรวจ utility_bill ก่อน tax_invoice)
+
+package processor
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Document classes (classify-document)
+const (
+	DocumentClassReceipt        = "receipt"         // ใบเสร็จรับเงิน / บิลเงินสด
+	DocumentClassTaxInvoice     = "tax_invoice"     // ใบกำกับภาษี
+	DocumentClassWHTCertificate = "wht_certificate" // หนังสือรับรองการหักภาษี ณ ที่จ่าย (50 ทวิ)
+	DocumentClassUtilityBill    = "utility_bill"    // ค่าไฟ / ค่าน้ำ / โทรศัพท์ / อินเทอร์เน็ต
+	DocumentClassPaymentSlip    = "payment_slip"    // สลิปโอนเงิน
+	DocumentClassOther          = "other"
+)
+
+// DocumentClassThreshold - minimum score (0-1) for a class to be chosen
+const DocumentClassThreshold = 0.5
+
+// DocumentClassLabels - Thai labels shown to users
+var DocumentClassLabels = map[string]string{
+	DocumentClassReceipt:        "ใบเสร็จรับเงิน",
+	DocumentClassTaxInvoice:     "ใบกำกับภาษี",
+	DocumentClassWHTCertificate: "หนังสือรับรองการหักภาษี ณ ที่จ่าย",
+	DocumentClassUtilityBill:    "บิลค่าสาธารณูปโภค",
+	DocumentClassPaymentSlip:    "สลิปโอนเงิน",
+	DocumentClassOther:          "เอกสารอื่น ๆ",
+}
+
+// DocumentClassification is the result of ClassifyDocument
+type DocumentClassification struct {
+	DocumentType string             `json:"document_type"`
+	Label        string             `json:"label"`
+	Confidence   float64            `json:"confidence"` // Score of the chosen class (0-1)
+	Signals      []string           `json:"signals"`    // Keywords that decided the class
+	Scores       map[string]float64 `json:"scores"`     // Score of every class
+}
+
+// classKeyword is a keyword and how strongly it points to its class
+type classKeyword struct {
+	keyword string
+	weight  float64
+}
+
+// documentClassOrder - most specific first; the first class reaching the threshold wins
+var documentClassOrder = []string{
+	DocumentClassWHTCertificate,
+	DocumentClassUtilityBill,
+	DocumentClassPaymentSlip,
+	DocumentClassTaxInvoice,
+	DocumentClassReceipt,
+}
+
+// documentClassKeywords - matched against the lower-cased OCR text
+var documentClassKeywords = map[string][]classKeyword{
+	DocumentClassWHTCertificate: {
+		{"หนังสือรับรองการหักภาษี", 0.8},
+		{"50 ทวิ", 0.6},
+		{"withholding tax certificate", 0.8},
+		{"ภาษีที่หักและนำส่ง", 0.4},
+		{"ภ.ง.ด.53", 0.2},
+		{"ภ.ง.ด.3", 0.2},
+		{"ภ.ง.ด.1ก", 0.2},
+	},
+	DocumentClassUtilityBill: {
+		{"การไฟฟ้า", 0.4},
+		{"การประปา", 0.4},
+		{"ค่าไฟฟ้า", 0.4},
+		{"ค่าน้ำประปา", 0.4},
+		{"หน่วยที่ใช้", 0.3},
+		{"ผู้ใช้ไฟ", 0.3},
+		{"ผู้ใช้น้ำ", 0.3},
+		{"ค่าบริการโทรศัพท์", 0.4},
+		{"ค่าบริการอินเทอร์เน็ต", 0.4},
+		{"electricity", 0.4},
+		{"kwh", 0.3},
+		{"ใบแจ้งค่า", 0.2},
+	},
+	DocumentClassPaymentSlip: {
+		{"โอนเงินสำเร็จ", 0.6},
+		{"ชำระเงินสำเร็จ", 0.5},
+		{"รายการสำเร็จ", 0.4},
+		{"transfer successful", 0.6},
+		{"พร้อมเพย์", 0.3},
+		{"promptpay", 0.3},
+		{"เลขที่รายการ", 0.3},
+		{"รหัสอ้างอิง", 0.2},
+		{"ค่าธรรมเนียม", 0.1},
+	},
+	DocumentClassTaxInvoice: {
+		{"ใบกำกับภาษี", 0.6},
+		{"tax invoice", 0.6},
+		{"เลขประจำตัวผู้เสียภาษี", 0.2},
+		{"ภาษีมูลค่าเพิ่ม", 0.2},
+		{"vat", 0.1},
+	},
+	DocumentClassReceipt: {
+		{"ใบเสร็จรับเงิน", 0.6},
+		{"receipt", 0.5},
+		{"บิลเงินสด", 0.6},
+		{"cash sale", 0.5},
+		{"ได้รับเงิน", 0.2},
+		{"รวมเงิน", 0.1},
+		{"ยอดรวม", 0.1},
+		{"total", 0.1},
+	},
+}
+
+// ClassifyDocument decides the document class from OCR text
+// Returns "other" when no class reaches DocumentClassThreshold
+func ClassifyDocument(ocrText string) DocumentClassification {
+	result := DocumentClassification{
+		DocumentType: DocumentClassOther,
+		Signals:      []string{},
+		Scores:       map[string]float64{},
+	}
+
+	lower := strings.ToLower(ocrText)
+	signals := map[string][]string{}
+	for _, class := range documentClassOrder {
+		score := 0.0
+		for _, kw := range documentClassKeywords[class] {
+			if strings.Contains(lower, kw.keyword) {
+				score += kw.weight
+				signals[class] = append(signals[class], fmt.Sprintf("keyword %q", kw.keyword))
+			}
+		}
+		if score > 1 {
+			score = 1
+		}
+		result.Scores[class] = math.Round(score*100) / 100
+	}
+
+	for _, class := range documentClassOrder {
+		if result.Scores[class] >= DocumentClassThreshold {
+			result.DocumentType = class
+			result.Confidence = result.Scores[class]
+			result.Signals = signals[class]
+			break
+		}
+	}
+	result.Label = DocumentClassLabels[result.DocumentType]
+	return result
+}
@@ -0,0 +1,224 @@
+// document_clustering.go - Group images of unrelated documents before Phase 3
+//
+// 6 รูป = ใบแจ้งหนี้ 3 ใบ + สลิปโอนเงิน 3 ใบ → เดิมส่งทั้งหมดให้ AI วิเคราะห์ครั้งเดียว (ได้ entry เดียว)
+// จัดกลุ่มจากข้อความ OCR ก่อน: เลขที่เอกสาร (หลายหน้า = เอกสารเดียว), ยอดเงิน (จับคู่สลิปกับเอกสาร),
+// เลขผู้เสียภาษี และวันที่ → แต่ละกลุ่มวิเคราะห์ Phase 3 แยกกัน
+// แยกกลุ่มเฉพาะเมื่อมั่นใจ (ทุกกลุ่มมีเลขที่เอกสารของตัวเอง) - ไม่แน่ใจ = กลุ่มเดียวเหมือนเดิม
+
+package processor
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DocumentFingerprint holds the identifying values found in one image's OCR text
+type DocumentFingerprint struct {
+	ImageIndex      int      `json:"image_index"`
+	DocumentNumbers []string `json:"document_numbers"`
+	Total           float64  `json:"total"` // Largest amount in the text (0 = none)
+	TaxIDs          []string `json:"tax_ids"`
+	Dates           []string `json:"dates"`
+	IsPaymentSlip   bool     `json:"is_payment_slip"`
+}
+
+// DocumentCluster is one document (its pages + payment slips) - surfaced as document_analysis.clusters
+type DocumentCluster struct {
+	ImageIndices   []int    `json:"image_indices"`
+	DocumentNumber string   `json:"document_number,omitempty"`
+	Total          float64  `json:"total,omitempty"`
+	Reasons        []string `json:"reasons"`
+}
+
+var (
+	// เลขที่ / เลขที่ใบกำกับภาษี / Invoice No. followed by an alphanumeric document number
+	documentNumberPattern = regexp.MustCompile(`(?i)(?:เลขที่(?:ใบ\p{Thai}*|เอกสาร)?|invoice\s*no\.?|receipt\s*no\.?|doc(?:ument)?\s*no\.?)\s*[:：#]?\s*([A-Z0-9][A-Z0-9\-/]{3,})`)
+	// Address house numbers ("เลขที่ 99/1 หมู่ 2") are not document numbers
+	addressFollowPattern = regexp.MustCompile(`^\s*(?:หมู่|ม\.|ถนน|ถ\.|ซอย|ซ\.|ตำบล|ต\.|แขวง)`)
+	amountPattern        = regexp.MustCompile(`\d{1,3}(?:,\d{3})+\.\d{2}|\d+\.\d{2}`)
+	taxIDPattern         = regexp.MustCompile(`\b\d{13}\b`)
+	datePattern          = regexp.MustCompile(`\b\d{1,2}[/\-.]\d{1,2}[/\-.]\d{2,4}\b`)
+)
+
+// paymentSlipKeywords - texts found on bank transfer slips / PromptPay receipts
+var paymentSlipKeywords = []string{
+	"โอนเงินสำเร็จ",
+	"ทำรายการสำเร็จ",
+	"รายการสำเร็จ",
+	"transfer successful",
+	"เลขที่รายการ",
+	"kind=" + CodeKindSlipVerification,
+}
+
+// FingerprintDocument extracts document numbers, amounts, tax IDs and dates from OCR text
+func FingerprintDocument(imageIndex int, text string) DocumentFingerprint {
+	fp := DocumentFingerprint{ImageIndex: imageIndex, DocumentNumbers: []string{}, TaxIDs: []string{}, Dates: []string{}}
+	lower := strings.ToLower(text)
+
+	for _, keyword := range paymentSlipKeywords {
+		if strings.Contains(lower, strings.ToLower(keyword)) {
+			fp.IsPaymentSlip = true
+			break
+		}
+	}
+
+	for _, m := range documentNumberPattern.FindAllStringSubmatchIndex(text, -1) {
+		number := strings.ToUpper(text[m[2]:m[3]])
+		if addressFollowPattern.MatchString(text[m[3]:]) || !strings.ContainsAny(number, "0123456789") {
+			continue
+		}
+		if taxIDPattern.MatchString(number) && len(number) == 13 {
+			continue // Tax ID, not a document number
+		}
+		if !contains(fp.DocumentNumbers, number) {
+			fp.DocumentNumbers = append(fp.DocumentNumbers, number)
+		}
+	}
+
+	for _, m := range amountPattern.FindAllString(text, -1) {
+		if amount, err := strconv.ParseFloat(strings.ReplaceAll(m, ",", ""), 64); err == nil && amount > fp.Total {
+			fp.Total = amount
+		}
+	}
+	for _, m := range taxIDPattern.FindAllString(text, -1) {
+		if !contains(fp.TaxIDs, m) {
+			fp.TaxIDs = append(fp.TaxIDs, m)
+		}
+	}
+	for _, m := range datePattern.FindAllString(text, -1) {
+		if !contains(fp.Dates, m) {
+			fp.Dates = append(fp.Dates, m)
+		}
+	}
+	return fp
+}
+
+// ClusterDocuments groups images into documents:
+//  1. Documents sharing a document number are one document (multi-page)
+//  2. Pages without a number or amount continue the previous document
+//  3. Payment slips join the document with the same total (nearest unpaired one first)
+//
+// Returns a single cluster when any non-slip document has no document number (not safe to split)
+func ClusterDocuments(fingerprints []DocumentFingerprint) []DocumentCluster {
+	sorted := append([]DocumentFingerprint(nil), fingerprints...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ImageIndex < sorted[j].ImageIndex })
+
+	single := func(reason string) []DocumentCluster {
+		cluster := DocumentCluster{Reasons: []string{reason}}
+		for _, fp := range sorted {
+			cluster.ImageIndices = append(cluster.ImageIndices, fp.ImageIndex)
+		}
+		return []DocumentCluster{cluster}
+	}
+	if len(sorted) < 2 {
+		return single("single image")
+	}
+
+	type docGroup struct {
+		cluster DocumentCluster
+		numbers []string
+		paid    bool
+	}
+	var docs []*docGroup
+	var slips []DocumentFingerprint
+
+	for _, fp := range sorted {
+		if fp.IsPaymentSlip {
+			slips = append(slips, fp)
+			continue
+		}
+
+		// Rule 1: same document number → same document
+		var target *docGroup
+		for _, d := range docs {
+			for _, n := range fp.DocumentNumbers {
+				if contains(d.numbers, n) {
+					target = d
+				}
+			}
+		}
+		if target != nil {
+			target.cluster.ImageIndices = append(target.cluster.ImageIndices, fp.ImageIndex)
+			target.cluster.Reasons = append(target.cluster.Reasons, "same document number")
+			target.cluster.Total = math.Max(target.cluster.Total, fp.Total)
+			continue
+		}
+
+		// Rule 2: continuation page (no number, no amount)
+		if len(fp.DocumentNumbers) == 0 && fp.Total == 0 && len(docs) > 0 {
+			last := docs[len(docs)-1]
+			last.cluster.ImageIndices = append(last.cluster.ImageIndices, fp.ImageIndex)
+			last.cluster.Reasons = append(last.cluster.Reasons, "continuation page")
+			continue
+		}
+		if len(fp.DocumentNumbers) == 0 {
+			return single("document without number - not split")
+		}
+
+		docs = append(docs, &docGroup{
+			cluster: DocumentCluster{
+				ImageIndices:   []int{fp.ImageIndex},
+				DocumentNumber: fp.DocumentNumbers[0],
+				Total:          fp.Total,
+				Reasons:        []string{"document number " + fp.DocumentNumbers[0]},
+			},
+			numbers: fp.DocumentNumbers,
+		})
+	}
+
+	if len(docs) < 2 {
+		return single("one document")
+	}
+
+	// Rule 3: pair payment slips by amount
+	for _, slip := range slips {
+		var best *docGroup
+		for _, d := range docs {
+			if slip.Total == 0 || math.Abs(d.cluster.Total-slip.Total) > 0.01 {
+				continue
+			}
+			if best == nil || (best.paid && !d.paid) ||
+				(best.paid == d.paid && indexDistance(d.cluster.ImageIndices, slip.ImageIndex) < indexDistance(best.cluster.ImageIndices, slip.ImageIndex)) {
+				best = d
+			}
+		}
+		if best == nil {
+			// Slip for an amount no document has - keep it on its own
+			docs = append(docs, &docGroup{cluster: DocumentCluster{
+				ImageIndices: []int{slip.ImageIndex},
+				Total:        slip.Total,
+				Reasons:      []string{"unpaired payment slip"},
+			}})
+			continue
+		}
+		best.paid = true
+		best.cluster.ImageIndices = append(best.cluster.ImageIndices, slip.ImageIndex)
+		best.cluster.Reasons = append(best.cluster.Reasons, "payment slip with same amount")
+	}
+
+	clusters := make([]DocumentCluster, 0, len(docs))
+	for _, d := range docs {
+		sort.Ints(d.cluster.ImageIndices)
+		clusters = append(clusters, d.cluster)
+	}
+	sort.SliceStable(clusters, func(i, j int) bool { return clusters[i].ImageIndices[0] < clusters[j].ImageIndices[0] })
+	return clusters
+}
+
+// indexDistance - distance from index to the nearest image of the cluster
+func indexDistance(indices []int, index int) int {
+	best := math.MaxInt32
+	for _, i := range indices {
+		d := i - index
+		if d < 0 {
+			d = -d
+		}
+		if d < best {
+			best = d
+		}
+	}
+	return best
+}
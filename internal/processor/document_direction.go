@@ -0,0 +1,164 @@
+// document_direction.go - Detects the document direction (purchase vs sale) before Phase 3
+//
+// เดิม AI เป็นผู้ตัดสินว่าเอกสารเป็นซื้อหรือขายเอง → บางครั้งใบกำกับภาษีที่ร้านออกเองถูกลงเป็นเจ้าหนี้
+// เทียบชื่อ/เลขผู้เสียภาษีของร้าน (ShopProfile) กับส่วนหัวเอกสาร (ผู้ออก) และส่วนข้อมูลลูกค้า:
+// - อยู่ในส่วนหัว (ก่อนบรรทัด "ลูกค้า / ผู้ซื้อ / Customer / Bill to") = เอกสารขาย → อ่านชื่อ/เลขผู้เสียภาษีลูกค้า แล้วจับคู่กับลูกหนี้ (MatchDebtor)
+// - อยู่ส่วนอื่นของเอกสาร (ร้านเป็นลูกค้า) = เอกสารซื้อ
+// - ไม่พบร้านเลย = unknown (ให้ AI ตัดสินเหมือนเดิม)
+// ผลถูกส่งให้ AI ใน prompt และใช้ตรวจ creditor/debtor ที่ AI เลือก (CheckDirectionChoice)
+
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	saleHeaderMaxLines       = 10   // Lines searched for the issuer when no buyer marker is found before them
+	saleHeaderNameSimilarity = 90.0 // Document line vs shop name
+)
+
+// buyerMarkerPattern - label of the customer section ("ชื่อลูกค้า: ...", "Bill to")
+var buyerMarkerPattern = regexp.MustCompile(`(?i)(ชื่อลูกค้า|นามลูกค้า|ลูกค้า|ชื่อผู้ซื้อ|นามผู้ซื้อ|ผู้ซื้อ|ผู้รับบริการ|ได้รับเงินจาก|customer(\s*name)?|sold\s*to|bill\s*to|received\s*from|buyer)\s*[:：]?`)
+
+// copyLabelPattern - copy labels in the header ("ต้นฉบับ (สำหรับลูกค้า)") are not the buyer section
+var copyLabelPattern = regexp.MustCompile(`(?i)ต้นฉบับ|สำเนา|สำหรับลูกค้า|customer\s*copy|original`)
+
+// DocumentDirection is the result of DetectDocumentDirection
+type DocumentDirection struct {
+	Direction     string `json:"direction"`        // DirectionSale, DirectionPurchase or DirectionUnknown
+	Reason        string `json:"reason,omitempty"` // e.g. "shop_tax_id_in_header", "shop_name_in_customer_section"
+	CustomerName  string `json:"customer_name,omitempty"`
+	CustomerTaxID string `json:"customer_tax_id,omitempty"`
+}
+
+// IsSale reports whether the shop issued the document
+func (d DocumentDirection) IsSale() bool {
+	return d.Direction == DirectionSale
+}
+
+// DetectDocumentDirection compares the shop's name / tax ID with the issuer header and the rest of the document
+// and reads the customer from the buyer section of a sales document
+func DetectDocumentDirection(ocrText, shopName, shopTaxID string) DocumentDirection {
+	lines := strings.Split(ocrText, "\n")
+
+	// Step 1: Header = lines before the first buyer marker (at most saleHeaderMaxLines)
+	buyerLine := -1
+	for i, line := range lines {
+		if buyerMarkerPattern.MatchString(line) && !copyLabelPattern.MatchString(line) {
+			buyerLine = i
+			break
+		}
+	}
+	headerEnd := len(lines)
+	if buyerLine >= 0 {
+		headerEnd = buyerLine
+	}
+	if headerEnd > saleHeaderMaxLines {
+		headerEnd = saleHeaderMaxLines
+	}
+
+	// Step 2: Where the shop appears - header = issuer (sale), elsewhere = customer (purchase)
+	shopTaxID = normalizeTaxID(shopTaxID)
+	if found := findShop(lines[:headerEnd], shopName, shopTaxID); found != "" {
+		detection := DocumentDirection{Direction: DirectionSale, Reason: found + "_in_header"}
+		if buyerLine >= 0 {
+			detection.CustomerName, detection.CustomerTaxID = readCustomer(lines[buyerLine:], shopTaxID)
+		}
+		return detection
+	}
+	if found := findShop(lines[headerEnd:], shopName, shopTaxID); found != "" {
+		return DocumentDirection{Direction: DirectionPurchase, Reason: found + "_in_customer_section"}
+	}
+	return DocumentDirection{Direction: DirectionUnknown}
+}
+
+// findShop returns "shop_tax_id" / "shop_name" when the shop's tax ID / name is in lines ("" = not found)
+func findShop(lines []string, shopName, normalizedTaxID string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	if normalizedTaxID != "" && strings.Contains(normalizeTaxID(strings.Join(lines, "\n")), normalizedTaxID) {
+		return "shop_tax_id"
+	}
+	normalizedShop := normalizeVendorName(shopName)
+	if utf8.RuneCountInString(normalizedShop) < creditorPrefilterMinNameRunes {
+		return ""
+	}
+	compactShop := strings.ReplaceAll(normalizedShop, " ", "")
+	for _, line := range lines {
+		normalizedLine := normalizeVendorName(line)
+		if normalizedLine == "" {
+			continue
+		}
+		if strings.Contains(strings.ReplaceAll(normalizedLine, " ", ""), compactShop) ||
+			calculateNameSimilarity(normalizedLine, normalizedShop) >= saleHeaderNameSimilarity {
+			return "shop_name"
+		}
+	}
+	return ""
+}
+
+// readCustomer reads the customer of the buyer section (lines[0] = the marker line)
+// Name = text after the marker (or the next non-empty line), tax ID = first 13-digit number that is not the shop's
+func readCustomer(lines []string, shopTaxID string) (name, taxID string) {
+	if rest := strings.TrimSpace(buyerMarkerPattern.ReplaceAllString(lines[0], "")); utf8.RuneCountInString(rest) >= 3 {
+		name = rest
+	} else {
+		for _, line := range lines[1:] {
+			if trimmed := strings.TrimSpace(line); trimmed != "" {
+				name = trimmed
+				break
+			}
+		}
+	}
+	for _, candidate := range uniqueTaxIDs(normalizeTaxID(strings.Join(lines, "\n"))) {
+		if candidate != shopTaxID {
+			taxID = candidate
+			break
+		}
+	}
+	return name, taxID
+}
+
+// DirectionCheck compares the party the AI booked with the detected direction
+type DirectionCheck struct {
+	Expected string `json:"expected"` // DirectionSale / DirectionPurchase
+	Reason   string `json:"reason"`
+	AIParty  string `json:"ai_party"` // "creditor", "debtor", "both" or "none"
+	Agreed   bool   `json:"agreed"`
+	Message  string `json:"message,omitempty"`
+}
+
+// CheckDirectionChoice validates the creditor/debtor of the entry against the detected direction
+// Returns nil when the direction is unknown
+func CheckDirectionChoice(direction DocumentDirection, accountingEntry map[string]interface{}) *DirectionCheck {
+	if direction.Direction != DirectionSale && direction.Direction != DirectionPurchase {
+		return nil
+	}
+	hasCreditor := getStringFromInterface(accountingEntry["creditor_code"]) != ""
+	hasDebtor := getStringFromInterface(accountingEntry["debtor_code"]) != ""
+
+	check := &DirectionCheck{Expected: direction.Direction, Reason: direction.Reason, AIParty: "none", Agreed: true}
+	switch {
+	case hasCreditor && hasDebtor:
+		check.AIParty = "both"
+	case hasCreditor:
+		check.AIParty = PartyCreditor
+	case hasDebtor:
+		check.AIParty = PartyDebtor
+	}
+
+	if direction.Direction == DirectionSale && check.AIParty == PartyCreditor {
+		check.Agreed = false
+		check.Message = fmt.Sprintf("ร้านเป็นผู้ออกเอกสาร (%s) แต่บันทึกเป็นเจ้าหนี้ - ควรเป็นเอกสารขาย (ลูกหนี้)", direction.Reason)
+	}
+	if direction.Direction == DirectionPurchase && check.AIParty == PartyDebtor {
+		check.Agreed = false
+		check.Message = fmt.Sprintf("ร้านเป็นลูกค้าในเอกสาร (%s) แต่บันทึกเป็นลูกหนี้ - ควรเป็นเอกสารซื้อ (เจ้าหนี้)", direction.Reason)
+	}
+	return check
+}
@@ -0,0 +1,183 @@
+// document_sequence.go - Duplicate / missing invoice numbers in a creditor's document sequence
+//
+// ใบกำกับภาษีจากผู้ขายรายเดียวกันมีเลขที่เรียงกัน (INV-2024-0015, INV-2024-0016, ...):
+//   - เลขที่ซ้ำกับเอกสารที่วิเคราะห์แล้ว → อาจบันทึกเอกสารเดียวกันซ้ำ (ต้องตรวจสอบ)
+//   - เลขที่ข้ามไปไม่กี่เลข → อาจมีเอกสารหายไม่ได้บันทึก (เตือน)
+// เลขที่กระโดดมาก = ผู้ขายออกเอกสารให้ลูกค้ารายอื่นระหว่างนั้น ไม่นับเป็นความผิดปกติ
+
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Sequence anomaly types
+const (
+	SequenceAnomalyDuplicate = "duplicate"
+	SequenceAnomalyGap       = "gap"
+)
+
+// documentSequencePattern splits a document number into its prefix and trailing running number
+var documentSequencePattern = regexp.MustCompile(`^(.*?)(\d+)$`)
+
+// SequenceDocument is one analyzed document of a creditor
+type SequenceDocument struct {
+	RequestID    string `json:"request_id"`
+	Number       string `json:"number"`
+	DocumentDate string `json:"document_date,omitempty"`
+}
+
+// SequenceAnomaly is a duplicate number or a gap in the creditor's sequence
+type SequenceAnomaly struct {
+	Type      string             `json:"type"`             // duplicate / gap
+	Number    string             `json:"number,omitempty"` // duplicate: the repeated number
+	After     string             `json:"after,omitempty"`  // gap: last number before the gap
+	Before    string             `json:"before,omitempty"` // gap: first number after the gap
+	Missing   []string           `json:"missing,omitempty"`
+	Documents []SequenceDocument `json:"documents"`
+	Message   string             `json:"message"`
+}
+
+// parsedDocumentNumber is a document number split for comparison
+type parsedDocumentNumber struct {
+	key      string // Letters and digits only, upper case ("INV-001" = "INV 001" = "inv001")
+	prefix   string // As printed, before the running number
+	digits   int    // Width of the running number (leading zeros kept when listing missing numbers)
+	sequence int64
+	numeric  bool // false = no running number (or too long) - duplicates only
+}
+
+// sequenceKey keeps letters and digits of s in upper case
+func sequenceKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, s)
+}
+
+// parseDocumentNumber returns false for numbers that cannot be compared ("", N/A)
+func parseDocumentNumber(number string) (parsedDocumentNumber, bool) {
+	number = strings.TrimSpace(number)
+	key := sequenceKey(number)
+	if key == "" || key == "NA" {
+		return parsedDocumentNumber{}, false
+	}
+
+	parsed := parsedDocumentNumber{key: key}
+	if m := documentSequencePattern.FindStringSubmatch(number); m != nil {
+		if sequence, err := strconv.ParseInt(m[2], 10, 64); err == nil {
+			parsed.prefix = m[1]
+			parsed.digits = len(m[2])
+			parsed.sequence = sequence
+			parsed.numeric = true
+		}
+	}
+	return parsed, true
+}
+
+// DetectSequenceAnomalies finds repeated numbers and gaps of 1..maxGap missing numbers (maxGap 0 = duplicates only)
+// documents are one creditor's documents, each request counted once (re-analyses already merged)
+func DetectSequenceAnomalies(documents []SequenceDocument, maxGap int) []SequenceAnomaly {
+	anomalies := []SequenceAnomaly{}
+
+	type numberedDocument struct {
+		document SequenceDocument
+		number   parsedDocumentNumber
+	}
+	byKey := map[string][]numberedDocument{}
+	keys := []string{}
+	for _, document := range documents {
+		number, ok := parseDocumentNumber(document.Number)
+		if !ok {
+			continue
+		}
+		if _, seen := byKey[number.key]; !seen {
+			keys = append(keys, number.key)
+		}
+		byKey[number.key] = append(byKey[number.key], numberedDocument{document: document, number: number})
+	}
+	sort.Strings(keys)
+
+	// Step 1: Same number on more than one document
+	for _, key := range keys {
+		group := byKey[key]
+		if len(group) < 2 {
+			continue
+		}
+		anomaly := SequenceAnomaly{
+			Type:    SequenceAnomalyDuplicate,
+			Number:  group[0].document.Number,
+			Message: fmt.Sprintf("เลขที่เอกสาร %s ซ้ำ %d ครั้ง (อาจบันทึกเอกสารเดียวกันซ้ำ)", group[0].document.Number, len(group)),
+		}
+		for _, d := range group {
+			anomaly.Documents = append(anomaly.Documents, d.document)
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+	if maxGap <= 0 {
+		return anomalies
+	}
+
+	// Step 2: Running numbers of the same prefix with a few numbers missing in between
+	byPrefix := map[string][]numberedDocument{}
+	prefixes := []string{}
+	for _, key := range keys {
+		first := byKey[key][0]
+		if !first.number.numeric {
+			continue
+		}
+		prefix := sequenceKey(first.number.prefix)
+		if _, seen := byPrefix[prefix]; !seen {
+			prefixes = append(prefixes, prefix)
+		}
+		byPrefix[prefix] = append(byPrefix[prefix], first)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		series := byPrefix[prefix]
+		sort.Slice(series, func(i, j int) bool { return series[i].number.sequence < series[j].number.sequence })
+		for i := 1; i < len(series); i++ {
+			low, high := series[i-1], series[i]
+			missing := high.number.sequence - low.number.sequence - 1
+			if missing < 1 || missing > int64(maxGap) {
+				continue
+			}
+			anomaly := SequenceAnomaly{
+				Type:      SequenceAnomalyGap,
+				After:     low.document.Number,
+				Before:    high.document.Number,
+				Documents: []SequenceDocument{low.document, high.document},
+				Message:   fmt.Sprintf("เลขที่เอกสารข้ามจาก %s ไป %s (ขาด %d ใบ - อาจมีเอกสารที่ยังไม่ได้บันทึก)", low.document.Number, high.document.Number, missing),
+			}
+			for sequence := low.number.sequence + 1; sequence < high.number.sequence; sequence++ {
+				anomaly.Missing = append(anomaly.Missing, fmt.Sprintf("%s%0*d", low.number.prefix, low.number.digits, sequence))
+			}
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+	return anomalies
+}
+
+// CheckDocumentSequence returns the anomalies a new document causes against the creditor's history
+func CheckDocumentSequence(document SequenceDocument, history []SequenceDocument, maxGap int) []SequenceAnomaly {
+	documents := append(append([]SequenceDocument{}, history...), document)
+
+	anomalies := []SequenceAnomaly{}
+	for _, anomaly := range DetectSequenceAnomalies(documents, maxGap) {
+		for _, d := range anomaly.Documents {
+			if d.RequestID == document.RequestID {
+				anomalies = append(anomalies, anomaly)
+				break
+			}
+		}
+	}
+	return anomalies
+}
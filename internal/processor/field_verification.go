@@ -0,0 +1,147 @@
+// field_verification.go - Compare critical fields between two independent reads
+//
+// ยอดรวม / VAT / วันที่ / เลขผู้เสียภาษี อ่านผิดได้บ้าง → อ่านซ้ำด้วย prompt เฉพาะฟิลด์ (pass 2)
+// แล้วเทียบกับผลจาก accounting (pass 1) ถ้าไม่ตรงกัน → ลด confidence และบังคับ review
+
+package processor
+
+import (
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+)
+
+// FieldDisagreementPenalty - confidence points removed per field where the two passes disagree
+const FieldDisagreementPenalty = 15.0
+
+// CriticalFields are the fields re-read by the verification pass (nil/empty = not readable)
+type CriticalFields struct {
+	Total       *float64 `json:"total"`
+	VAT         *float64 `json:"vat"`
+	Date        string   `json:"date"` // YYYY-MM-DD (ค.ศ.)
+	VendorTaxID string   `json:"vendor_tax_id"`
+}
+
+// FieldDisagreement is one field where the two passes read different values
+type FieldDisagreement struct {
+	Field      string      `json:"field"`
+	FirstPass  interface{} `json:"first_pass"`
+	SecondPass interface{} `json:"second_pass"`
+}
+
+// FieldVerificationResult is surfaced as validation.field_verification
+type FieldVerificationResult struct {
+	Status            string              `json:"status"` // "agreed", "disagreed", "failed"
+	CheckedFields     []string            `json:"checked_fields"`
+	Disagreements     []FieldDisagreement `json:"disagreements"`
+	ConfidencePenalty float64             `json:"confidence_penalty"`
+	Error             string              `json:"error,omitempty"`
+}
+
+// CompareCriticalFields compares the receipt section of the accounting result with the verification pass
+// Fields the verification pass could not read are not checked
+func CompareCriticalFields(receipt map[string]interface{}, verified CriticalFields) FieldVerificationResult {
+	result := FieldVerificationResult{
+		Status:        "agreed",
+		CheckedFields: []string{},
+		Disagreements: []FieldDisagreement{},
+	}
+
+	checkAmount := func(field string, second *float64) {
+		if second == nil {
+			return
+		}
+		result.CheckedFields = append(result.CheckedFields, field)
+		// An unreadable first-pass amount disagrees with any amount the verification pass read
+		first, ok := money.Parse(receipt[field])
+		if !ok {
+			result.Disagreements = append(result.Disagreements, FieldDisagreement{Field: field, FirstPass: receipt[field], SecondPass: *second})
+		} else if first != money.FromBaht(*second) {
+			result.Disagreements = append(result.Disagreements, FieldDisagreement{Field: field, FirstPass: first.Baht(), SecondPass: *second})
+		}
+	}
+	checkText := func(field, second string, normalize func(string) string) {
+		if normalize(second) == "" {
+			return
+		}
+		result.CheckedFields = append(result.CheckedFields, field)
+		first := getStringFromInterface(receipt[field])
+		if normalize(first) != normalize(second) {
+			result.Disagreements = append(result.Disagreements, FieldDisagreement{Field: field, FirstPass: first, SecondPass: second})
+		}
+	}
+
+	checkAmount("total", verified.Total)
+	checkAmount("vat", verified.VAT)
+	checkText("date", verified.Date, normalizeVerificationDate)
+	checkText("vendor_tax_id", verified.VendorTaxID, digitsOnly)
+
+	if len(result.Disagreements) > 0 {
+		result.Status = "disagreed"
+		result.ConfidencePenalty = FieldDisagreementPenalty * float64(len(result.Disagreements))
+	}
+	return result
+}
+
+// ApplyFieldVerification lowers the confidence score and forces review when the passes disagree
+// validation is the response "validation" section (confidence, requires_review, fields_requiring_review)
+func ApplyFieldVerification(validation map[string]interface{}, result FieldVerificationResult) {
+	validation["field_verification"] = result
+	if len(result.Disagreements) == 0 {
+		return
+	}
+
+	if confidence, ok := validation["confidence"].(map[string]interface{}); ok {
+		score := math.Max(0, getFloatFromInterface(confidence["score"])-result.ConfidencePenalty)
+		confidence["score"] = math.Round(score*100) / 100
+		confidence["level"] = determineConfidenceLevel(score)
+	}
+	validation["requires_review"] = true
+
+	var fields []string
+	switch existing := validation["fields_requiring_review"].(type) {
+	case []string:
+		fields = existing
+	case []interface{}:
+		for _, f := range existing {
+			if s, ok := f.(string); ok {
+				fields = append(fields, s)
+			}
+		}
+	}
+	for _, d := range result.Disagreements {
+		found := false
+		for _, f := range fields {
+			if f == d.Field {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fields = append(fields, d.Field)
+		}
+	}
+	validation["fields_requiring_review"] = fields
+}
+
+// normalizeVerificationDate keeps YYYY-MM-DD (ignores time part and surrounding spaces)
+func normalizeVerificationDate(date string) string {
+	date = strings.TrimSpace(date)
+	if len(date) > 10 {
+		date = date[:10]
+	}
+	return date
+}
+
+// digitsOnly strips dashes/spaces from tax IDs (0-1055-61234-56-7 == 0105561234567)
+func digitsOnly(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
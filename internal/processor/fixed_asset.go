@@ -0,0 +1,199 @@
+// fixed_asset.go - Fixed asset detection and capitalization
+//
+// ซื้อคอมพิวเตอร์ 25,000 บาท → AI มักบันทึกเป็นค่าใช้จ่าย (5xxx) แต่ทางบัญชีต้องบันทึกเป็นสินทรัพย์ถาวร (1xxx)
+// กฎหลัง Phase 3: รายการเดบิตค่าใช้จ่ายที่ยอด ≥ threshold ของร้าน และมีคำที่บ่งบอกสินทรัพย์ (คอมพิวเตอร์, เครื่องจักร, ...)
+// → ย้ายไปบัญชีสินทรัพย์ที่ตรงหมวดในผังบัญชี และต้องตรวจสอบเสมอ (อายุการใช้งาน/ค่าเสื่อมราคาไม่ได้คำนวณให้)
+
+package processor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// assetCategory maps item keywords to the asset account names to look for in the chart
+type assetCategory struct {
+	Name         string
+	Keywords     []string
+	AccountNames []string
+}
+
+var assetCategories = []assetCategory{
+	{
+		Name:         "computer",
+		Keywords:     []string{"คอมพิวเตอร์", "computer", "notebook", "โน้ตบุ๊ก", "โน๊ตบุ๊ค", "laptop", "แล็ปท็อป", "server", "เซิร์ฟเวอร์", "printer", "เครื่องพิมพ์", "macbook", "imac"},
+		AccountNames: []string{"คอมพิวเตอร์", "computer"},
+	},
+	{
+		Name:         "machinery",
+		Keywords:     []string{"เครื่องจักร", "machine", "machinery", "เครื่องกลึง", "เครื่องปั่นไฟ", "generator", "compressor", "คอมเพรสเซอร์"},
+		AccountNames: []string{"เครื่องจักร", "machinery"},
+	},
+	{
+		Name:         "vehicle",
+		Keywords:     []string{"รถยนต์", "รถกระบะ", "รถจักรยานยนต์", "มอเตอร์ไซค์", "vehicle", "รถบรรทุก"},
+		AccountNames: []string{"ยานพาหนะ", "รถยนต์", "vehicle"},
+	},
+	{
+		Name:         "office_equipment",
+		Keywords:     []string{"เครื่องปรับอากาศ", "แอร์", "air conditioner", "ตู้เย็น", "โทรทัศน์", "ทีวี", "กล้อง", "camera", "projector", "โปรเจคเตอร์", "เครื่องถ่ายเอกสาร", "copier"},
+		AccountNames: []string{"เครื่องใช้สำนักงาน", "อุปกรณ์สำนักงาน", "office equipment"},
+	},
+	{
+		Name:         "furniture",
+		Keywords:     []string{"เฟอร์นิเจอร์", "furniture", "โต๊ะ", "ตู้", "เก้าอี้", "ชั้นวาง"},
+		AccountNames: []string{"เครื่องตกแต่ง", "เฟอร์นิเจอร์", "furniture"},
+	},
+}
+
+// assetServiceKeywords - repairs, cleaning and rentals of assets are expenses
+var assetServiceKeywords = []string{"ซ่อม", "ล้าง", "บำรุง", "เช่า", "ค่าบริการ", "repair", "service", "maintenance", "rental"}
+
+// genericAssetAccountNames - fallback asset accounts when the category has none in the chart
+var genericAssetAccountNames = []string{"อุปกรณ์", "equipment", "สินทรัพย์ถาวร"}
+
+// FixedAssetRule is the shop's capitalization policy
+type FixedAssetRule struct {
+	Threshold float64  // Minimum line amount (THB)
+	Keywords  []string // Extra asset keywords (category "other")
+}
+
+// CapitalizedLine is one expense line moved to an asset account
+type CapitalizedLine struct {
+	EntryIndex      int          `json:"entry_index"`
+	Category        string       `json:"category"`
+	MatchedKeyword  string       `json:"matched_keyword"`
+	Amount          money.Amount `json:"amount"`
+	FromAccountCode string       `json:"from_account_code"`
+	FromAccountName string       `json:"from_account_name"`
+	ToAccountCode   string       `json:"to_account_code,omitempty"` // empty = no asset account in the chart (not rewritten)
+	ToAccountName   string       `json:"to_account_name,omitempty"`
+}
+
+// FixedAssetResult is surfaced as validation.fixed_assets
+type FixedAssetResult struct {
+	Threshold float64           `json:"threshold"`
+	Lines     []CapitalizedLine `json:"lines"`
+	Note      string            `json:"note,omitempty"`
+}
+
+// RequiresReview - every capitalized (or capitalizable) line needs an accountant's decision
+func (r FixedAssetResult) RequiresReview() bool {
+	return len(r.Lines) > 0
+}
+
+// ApplyFixedAssetRules rewrites asset-like expense lines (debit, 5xxx, amount ≥ threshold) to asset accounts
+// accountingEntry is the response accounting_entry (entries are modified in place)
+func ApplyFixedAssetRules(accountingEntry map[string]interface{}, accounts []bson.M, rule FixedAssetRule) FixedAssetResult {
+	result := FixedAssetResult{Threshold: rule.Threshold, Lines: []CapitalizedLine{}}
+	entriesRaw, ok := accountingEntry["entries"].([]interface{})
+	if !ok || rule.Threshold <= 0 {
+		return result
+	}
+
+	for i, e := range entriesRaw {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		code := strings.TrimSpace(getStringFromInterface(entry["account_code"]))
+		debit, ok := money.Parse(entry["debit"])
+		if !ok || !strings.HasPrefix(code, "5") || debit < money.FromBaht(rule.Threshold) {
+			continue
+		}
+
+		text := strings.ToLower(getStringFromInterface(entry["description"]) + " " + getStringFromInterface(entry["account_name"]))
+		if containsAny(text, assetServiceKeywords) {
+			continue
+		}
+		category, keyword := matchAssetCategory(text, rule.Keywords)
+		if category == nil {
+			continue
+		}
+
+		line := CapitalizedLine{
+			EntryIndex:      i,
+			Category:        category.Name,
+			MatchedKeyword:  keyword,
+			Amount:          debit,
+			FromAccountCode: code,
+			FromAccountName: getStringFromInterface(entry["account_name"]),
+		}
+		if assetCode, assetName := findAssetAccount(accounts, category.AccountNames); assetCode != "" {
+			line.ToAccountCode, line.ToAccountName = assetCode, assetName
+			entry["account_code"] = assetCode
+			entry["account_name"] = assetName
+			entry["selection_reason"] = fmt.Sprintf("ระบบย้ายเป็นสินทรัพย์ถาวร: '%s' ยอด %s ≥ %.2f บาท (เดิม %s %s)",
+				keyword, debit, rule.Threshold, line.FromAccountCode, line.FromAccountName)
+		}
+		result.Lines = append(result.Lines, line)
+	}
+
+	if len(result.Lines) > 0 {
+		result.Note = "รายการสินทรัพย์ถาวร - ตรวจสอบบัญชีสินทรัพย์ อายุการใช้งาน และค่าเสื่อมราคา"
+		for _, line := range result.Lines {
+			if line.ToAccountCode == "" {
+				result.Note = "พบรายการที่ควรเป็นสินทรัพย์ถาวรแต่ไม่มีบัญชีสินทรัพย์ที่ตรงในผังบัญชี - กรุณาเลือกบัญชีเอง"
+			}
+		}
+	}
+	return result
+}
+
+// containsAny reports whether text contains one of keywords
+func containsAny(text string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAssetCategory returns the first category whose keyword appears in text
+// Shop keywords match first (category "other" → generic asset account)
+func matchAssetCategory(text string, shopKeywords []string) (*assetCategory, string) {
+	for _, keyword := range shopKeywords {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword != "" && strings.Contains(text, keyword) {
+			for i := range assetCategories {
+				if contains(assetCategories[i].Keywords, keyword) {
+					return &assetCategories[i], keyword
+				}
+			}
+			return &assetCategory{Name: "other", AccountNames: genericAssetAccountNames}, keyword
+		}
+	}
+	for i := range assetCategories {
+		for _, keyword := range assetCategories[i].Keywords {
+			if strings.Contains(text, keyword) {
+				return &assetCategories[i], keyword
+			}
+		}
+	}
+	return nil, ""
+}
+
+// findAssetAccount returns the first posting asset account (1xxx, not accumulated depreciation)
+// whose name contains one of names, else a generic equipment account
+func findAssetAccount(accounts []bson.M, names []string) (string, string) {
+	for _, candidates := range [][]string{names, genericAssetAccountNames} {
+		for _, acc := range accounts {
+			code := getStringFromInterface(acc["accountcode"])
+			name := getStringFromInterface(acc["accountname"])
+			lower := strings.ToLower(name)
+			if !strings.HasPrefix(code, "1") || !isPostingAccount(acc) || strings.Contains(lower, "ค่าเสื่อม") || strings.Contains(lower, "depreciation") {
+				continue
+			}
+			for _, n := range candidates {
+				if strings.Contains(lower, n) {
+					return code, name
+				}
+			}
+		}
+	}
+	return "", ""
+}
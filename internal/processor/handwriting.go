@@ -0,0 +1,117 @@
+// handwriting.go - Detect handwritten receipts (บิลเงินสด) from the OCR text
+//
+// บิลเงินสดเขียนด้วยมือ OCR ปกติอ่านได้แย่ (ตัวเลขเพี้ยน, "???" เยอะ)
+// ใช้ heuristic จากข้อความ OCR รอบแรก → ถ้าน่าจะเป็นลายมือ ให้ OCR ใหม่ด้วย profile ลายมือ
+// และบังคับให้มีคนตรวจสอบ (requires_review)
+
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// HandwritingDetectionThreshold - minimum score (0-1) to treat a document as handwritten
+const HandwritingDetectionThreshold = 0.5
+
+// HandwritingSignal is the result of DetectHandwriting (surfaced as metadata.handwriting)
+type HandwritingSignal struct {
+	Detected bool     `json:"detected"`
+	Score    float64  `json:"score"`
+	Reasons  []string `json:"reasons"`
+}
+
+// handwrittenKeywords - printed headings of receipt books that are filled in by hand
+var handwrittenKeywords = []string{
+	"บิลเงินสด",
+	"ใบส่งของชั่วคราว",
+	"cash bill",
+	"เล่มที่",
+	"ลายมือ",
+	"handwritten",
+}
+
+// printedTaxIDPattern matches a 13-digit tax ID (with or without dashes/spaces)
+var printedTaxIDPattern = regexp.MustCompile(`\d[\d\- ]{11,18}\d`)
+
+// DetectHandwriting estimates whether the OCR text came from a handwritten document
+// Signals: unreadable markers ("???"), receipt-book keywords, no printed tax ID, very little text
+func DetectHandwriting(text string) HandwritingSignal {
+	signal := HandwritingSignal{Reasons: []string{}}
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return signal
+	}
+
+	// Signal 1: OCR prompt asks to write "???" for unreadable text - handwriting produces many
+	lines := strings.Split(trimmed, "\n")
+	unreadable := strings.Count(trimmed, "???")
+	if unreadable > 0 {
+		ratio := float64(unreadable) / float64(len(lines))
+		switch {
+		case ratio >= 0.2 || unreadable >= 5:
+			signal.Score += 0.4
+		case unreadable >= 2:
+			signal.Score += 0.2
+		}
+		signal.Reasons = append(signal.Reasons, fmt.Sprintf("%d unreadable marker(s)", unreadable))
+	}
+
+	// Signal 2: Receipt-book keywords (printed header, body filled in by hand)
+	lower := strings.ToLower(trimmed)
+	for _, keyword := range handwrittenKeywords {
+		if strings.Contains(lower, keyword) {
+			signal.Score += 0.4
+			signal.Reasons = append(signal.Reasons, fmt.Sprintf("keyword %q", keyword))
+			break
+		}
+	}
+
+	// Signal 3: Printed tax invoices almost always carry a 13-digit tax ID
+	hasTaxID := false
+	for _, candidate := range printedTaxIDPattern.FindAllString(trimmed, -1) {
+		if len(digitsOnly(candidate)) == 13 {
+			hasTaxID = true
+			break
+		}
+	}
+	if !hasTaxID {
+		signal.Score += 0.1
+		signal.Reasons = append(signal.Reasons, "no printed tax ID")
+	}
+
+	// Signal 4: Handwritten bills are short (a few lines of items + total)
+	if len(lines) <= 15 {
+		signal.Score += 0.1
+		signal.Reasons = append(signal.Reasons, fmt.Sprintf("short document (%d lines)", len(lines)))
+	}
+
+	if signal.Score > 1 {
+		signal.Score = 1
+	}
+	signal.Detected = signal.Score >= HandwritingDetectionThreshold
+	return signal
+}
+
+// ApplyHandwritingReview forces review for handwritten documents
+// validation is the response "validation" section (requires_review, processing_notes)
+func ApplyHandwritingReview(validation map[string]interface{}) {
+	validation["requires_review"] = true
+	validation["handwritten"] = true
+
+	note := "เอกสารเขียนด้วยลายมือ - กรุณาตรวจสอบยอดเงิน วันที่ และชื่อผู้ขายกับต้นฉบับ"
+	switch existing := validation["processing_notes"].(type) {
+	case string:
+		if existing != "" {
+			note = existing + " | " + note
+		}
+		validation["processing_notes"] = note
+	case []interface{}:
+		validation["processing_notes"] = append(existing, note)
+	case []string:
+		validation["processing_notes"] = append(existing, note)
+	default:
+		validation["processing_notes"] = note
+	}
+}
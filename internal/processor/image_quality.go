@@ -0,0 +1,327 @@
+// image_quality.go - Capture quality checks of a photo before analysis (no AI call)
+//
+// วัดจาก pixel อย่างเดียว: ความสว่าง, contrast, ความคม (variance of Laplacian), แสงสะท้อน,
+// เงา / แสงไม่สม่ำเสมอ, ความหนาแน่นของตัวอักษร (ink) และตัวอักษรที่ชิดขอบภาพ (ถ่ายไม่ครบ)
+// ให้แอปมือถือแจ้งผู้ใช้ถ่ายใหม่ก่อนเรียก analyze-receipt ซึ่งมีค่าใช้จ่ายสูง
+
+package processor
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	qualityMaxDimension = 1000 // Measurements are taken on the photo scaled to this size
+	qualityGridCells    = 8    // Grid (per side) used for lighting / glare
+	qualityInkRadius    = 7    // Local window (pixels) an ink pixel must be darker than
+	qualityInkOffset    = 25.0 // Brightness below the local mean that counts as ink
+	qualityBorderShare  = 0.03 // Border band checked for text cut by the frame
+)
+
+// Quality thresholds
+const (
+	QualityMinBrightness   = 70.0
+	QualityMaxBrightness   = 225.0
+	QualityMinContrast     = 60.0
+	QualityMinSharpness    = 40.0
+	QualityMaxGlare        = 0.05 // Share of blown-out grid cells
+	QualityMaxUnevenness   = 30.0 // Std dev of the paper brightness across the grid
+	QualityMinTextDensity  = 0.003
+	QualityMaxEdgeText     = 0.6 // Ink density of the densest border band relative to the whole photo
+	QualityMinLongSidePx   = 800
+	qualityEdgeMinDensity  = 0.01 // Edge check only when the photo has enough text
+	qualityGlareCellMean   = 250.0
+	qualityGlarePaperLevel = 245.0 // Paper this bright overall = scan / white paper, not glare
+)
+
+// ImageQualityMetrics are the measurements of one photo (brightness on the 0-255 scale)
+type ImageQualityMetrics struct {
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	Score       float64 `json:"score"`        // 0-100 (brightness + contrast, same as preprocessing)
+	Brightness  float64 `json:"brightness"`   // Mean
+	Contrast    float64 `json:"contrast"`     // 95th - 5th percentile
+	Sharpness   float64 `json:"sharpness"`    // Variance of the Laplacian
+	Glare       float64 `json:"glare"`        // Share of blown-out grid cells
+	Unevenness  float64 `json:"unevenness"`   // Std dev of the paper brightness across the grid
+	TextDensity float64 `json:"text_density"` // Share of ink pixels
+	EdgeText    float64 `json:"edge_text"`    // Ink density of the densest border band ÷ overall ink density
+}
+
+// ImageQualityFinding is one capture problem with advice for the user
+type ImageQualityFinding struct {
+	Check    string  // brightness, contrast, sharpness, glare, lighting, text_density, framing, resolution
+	Issue    string  // too_dark, too_bright, low_contrast, blurry, glare, uneven_lighting, no_text_detected, cropped, low_resolution
+	Value    float64 // Measured value
+	Limit    float64 // Threshold the value crossed
+	Max      bool    // Limit is a maximum (else a minimum)
+	Blocking bool    // OCR is likely to fail - retake the photo
+	Advice   string
+}
+
+// grayPixels returns the photo scaled to qualityMaxDimension as 0-255 brightness values
+func grayPixels(img image.Image) ([]float64, int, int) {
+	bounds := img.Bounds()
+	if bounds.Dx() > qualityMaxDimension || bounds.Dy() > qualityMaxDimension {
+		if bounds.Dx() > bounds.Dy() {
+			img = imaging.Resize(img, qualityMaxDimension, 0, imaging.Box)
+		} else {
+			img = imaging.Resize(img, 0, qualityMaxDimension, imaging.Box)
+		}
+	}
+	gray := imaging.Grayscale(img)
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+	pixels := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			pixels[y*w+x] = float64(gray.Pix[y*gray.Stride+x*4])
+		}
+	}
+	return pixels, w, h
+}
+
+// percentile of sorted values (p = 0..1)
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+// inkMask marks pixels clearly darker than their neighbourhood (text strokes, not dark backgrounds)
+func inkMask(pixels []float64, w, h int) []bool {
+	integral := make([]float64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		row := 0.0
+		for x := 0; x < w; x++ {
+			row += pixels[y*w+x]
+			integral[(y+1)*(w+1)+x+1] = integral[y*(w+1)+x+1] + row
+		}
+	}
+
+	ink := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		y0, y1 := max(0, y-qualityInkRadius), y+qualityInkRadius+1
+		if y1 > h {
+			y1 = h
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := max(0, x-qualityInkRadius), x+qualityInkRadius+1
+			if x1 > w {
+				x1 = w
+			}
+			sum := integral[y1*(w+1)+x1] - integral[y0*(w+1)+x1] - integral[y1*(w+1)+x0] + integral[y0*(w+1)+x0]
+			mean := sum / float64((y1-y0)*(x1-x0))
+			ink[y*w+x] = pixels[y*w+x] < mean-qualityInkOffset
+		}
+	}
+	return ink
+}
+
+// laplacianVariance measures focus - blurred photos have few strong second derivatives
+func laplacianVariance(pixels []float64, w, h int) float64 {
+	var sum, sumSq float64
+	n := 0
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			l := pixels[i-w] + pixels[i+w] + pixels[i-1] + pixels[i+1] - 4*pixels[i]
+			sum += l
+			sumSq += l * l
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	mean := sum / float64(n)
+	return sumSq/float64(n) - mean*mean
+}
+
+// paperLevels returns the paper brightness (90th percentile) and mean of every grid cell
+func paperLevels(pixels []float64, w, h int) (levels, means []float64) {
+	for gy := 0; gy < qualityGridCells; gy++ {
+		for gx := 0; gx < qualityGridCells; gx++ {
+			x0, x1 := gx*w/qualityGridCells, (gx+1)*w/qualityGridCells
+			y0, y1 := gy*h/qualityGridCells, (gy+1)*h/qualityGridCells
+			cell := make([]float64, 0, (x1-x0)*(y1-y0))
+			for y := y0; y < y1; y++ {
+				cell = append(cell, pixels[y*w+x0:y*w+x1]...)
+			}
+			if len(cell) == 0 {
+				continue
+			}
+			sum := 0.0
+			for _, v := range cell {
+				sum += v
+			}
+			sort.Float64s(cell)
+			levels = append(levels, percentile(cell, 0.9))
+			means = append(means, sum/float64(len(cell)))
+		}
+	}
+	return levels, means
+}
+
+// illuminationUnevenness is the std dev of the paper brightness across the grid (shadows, light falloff)
+func illuminationUnevenness(levels []float64) float64 {
+	if len(levels) == 0 {
+		return 0
+	}
+	mean := 0.0
+	for _, l := range levels {
+		mean += l
+	}
+	mean /= float64(len(levels))
+	variance := 0.0
+	for _, l := range levels {
+		variance += (l - mean) * (l - mean)
+	}
+	return math.Sqrt(variance / float64(len(levels)))
+}
+
+// measureLighting returns the unevenness and the glare share (blown-out cells on paper that is otherwise not white-clipped)
+func measureLighting(pixels []float64, w, h int) (unevenness, glare float64) {
+	levels, means := paperLevels(pixels, w, h)
+	unevenness = illuminationUnevenness(levels)
+	sortedLevels := append([]float64(nil), levels...)
+	sort.Float64s(sortedLevels)
+	if percentile(sortedLevels, 0.5) < qualityGlarePaperLevel {
+		blown := 0
+		for _, m := range means {
+			if m >= qualityGlareCellMean {
+				blown++
+			}
+		}
+		glare = float64(blown) / float64(len(means))
+	}
+	return unevenness, glare
+}
+
+// hasUnevenLighting reports shadows / light falloff / glare that preprocessing should flatten before OCR
+func hasUnevenLighting(img image.Image) bool {
+	pixels, w, h := grayPixels(img)
+	if w < 3 || h < 3 {
+		return false
+	}
+	unevenness, glare := measureLighting(pixels, w, h)
+	return unevenness > QualityMaxUnevenness || glare > QualityMaxGlare
+}
+
+// AssessImageQuality measures the capture quality of a photo
+func AssessImageQuality(img image.Image) ImageQualityMetrics {
+	bounds := img.Bounds()
+	metrics := ImageQualityMetrics{Width: bounds.Dx(), Height: bounds.Dy(), Score: analyzeImageQuality(img)}
+
+	pixels, w, h := grayPixels(img)
+	if w < 3 || h < 3 {
+		return metrics
+	}
+
+	// Step 1: Exposure
+	sorted := append([]float64(nil), pixels...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, v := range pixels {
+		sum += v
+	}
+	metrics.Brightness = sum / float64(len(pixels))
+	metrics.Contrast = percentile(sorted, 0.95) - percentile(sorted, 0.05)
+
+	// Step 2: Focus
+	metrics.Sharpness = laplacianVariance(pixels, w, h)
+
+	// Step 3: Lighting
+	metrics.Unevenness, metrics.Glare = measureLighting(pixels, w, h)
+
+	// Step 4: Text - overall ink density and the densest border band (text cut by the frame)
+	ink := inkMask(pixels, w, h)
+	border := int(math.Max(2, qualityBorderShare*math.Min(float64(w), float64(h))))
+	inkCount := 0
+	var sideInk [4]int // top, bottom, left, right
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !ink[y*w+x] {
+				continue
+			}
+			inkCount++
+			if y < border {
+				sideInk[0]++
+			}
+			if y >= h-border {
+				sideInk[1]++
+			}
+			if x < border {
+				sideInk[2]++
+			}
+			if x >= w-border {
+				sideInk[3]++
+			}
+		}
+	}
+	metrics.TextDensity = float64(inkCount) / float64(len(ink))
+	if metrics.TextDensity > 0 {
+		sideArea := [4]int{border * w, border * w, border * h, border * h}
+		for i, count := range sideInk {
+			metrics.EdgeText = math.Max(metrics.EdgeText, float64(count)/float64(sideArea[i])/metrics.TextDensity)
+		}
+	}
+	return metrics
+}
+
+// Findings lists the capture problems of the measurements (empty = good photo)
+func (m ImageQualityMetrics) Findings() []ImageQualityFinding {
+	findings := []ImageQualityFinding{}
+	add := func(f ImageQualityFinding) { findings = append(findings, f) }
+
+	if long := max(m.Width, m.Height); long < QualityMinLongSidePx {
+		add(ImageQualityFinding{Check: "resolution", Issue: "low_resolution", Value: float64(long), Limit: QualityMinLongSidePx, Blocking: true,
+			Advice: "ภาพความละเอียดต่ำเกินไป - ถ่ายใกล้ขึ้นหรือใช้ความละเอียดกล้องสูงขึ้น"})
+	}
+	switch {
+	case m.Brightness < QualityMinBrightness:
+		add(ImageQualityFinding{Check: "brightness", Issue: "too_dark", Value: m.Brightness, Limit: QualityMinBrightness, Blocking: true,
+			Advice: "ภาพมืดเกินไป - ถ่ายในที่สว่างขึ้นหรือเปิดแฟลช"})
+	case m.Brightness > QualityMaxBrightness && m.Contrast < QualityMinContrast*2:
+		add(ImageQualityFinding{Check: "brightness", Issue: "too_bright", Value: m.Brightness, Limit: QualityMaxBrightness, Max: true, Blocking: true,
+			Advice: "ภาพสว่างจ้าเกินไป ตัวอักษรซีด - ลดแสงหรือปิดแฟลช"})
+	}
+	if m.Contrast < QualityMinContrast {
+		add(ImageQualityFinding{Check: "contrast", Issue: "low_contrast", Value: m.Contrast, Limit: QualityMinContrast,
+			Advice: "ตัวอักษรกับพื้นกระดาษสีใกล้กัน - วางเอกสารบนพื้นสีเข้มและถ่ายในที่แสงพอ"})
+	}
+	if m.Sharpness < QualityMinSharpness {
+		add(ImageQualityFinding{Check: "sharpness", Issue: "blurry", Value: m.Sharpness, Limit: QualityMinSharpness, Blocking: true,
+			Advice: "ภาพเบลอ - ถือกล้องให้นิ่ง แตะหน้าจอเพื่อโฟกัสที่ตัวอักษรก่อนถ่าย"})
+	}
+	if m.Glare > QualityMaxGlare {
+		add(ImageQualityFinding{Check: "glare", Issue: "glare", Value: m.Glare, Limit: QualityMaxGlare, Max: true, Blocking: true,
+			Advice: "มีแสงสะท้อนบนเอกสาร - เปลี่ยนมุมถ่ายหรือหลบแสงไฟ/แฟลชที่ส่องตรง"})
+	}
+	if m.Unevenness > QualityMaxUnevenness {
+		add(ImageQualityFinding{Check: "lighting", Issue: "uneven_lighting", Value: m.Unevenness, Limit: QualityMaxUnevenness, Max: true,
+			Advice: "แสงไม่สม่ำเสมอหรือมีเงาทับเอกสาร - หลีกเลี่ยงเงามือ/โทรศัพท์ และถ่ายในที่แสงกระจายทั่ว"})
+	}
+	if m.TextDensity < QualityMinTextDensity {
+		add(ImageQualityFinding{Check: "text_density", Issue: "no_text_detected", Value: m.TextDensity, Limit: QualityMinTextDensity, Blocking: true,
+			Advice: "แทบไม่พบตัวอักษรในภาพ - ถ่ายให้เอกสารเต็มกรอบและอยู่ใกล้ขึ้น"})
+	} else if m.TextDensity >= qualityEdgeMinDensity && m.EdgeText > QualityMaxEdgeText {
+		add(ImageQualityFinding{Check: "framing", Issue: "cropped", Value: m.EdgeText, Limit: QualityMaxEdgeText, Max: true,
+			Advice: "ตัวอักษรชิดหรือเกินขอบภาพ เอกสารอาจถ่ายไม่ครบ - ถอยออกให้เห็นขอบกระดาษทั้ง 4 ด้าน"})
+	}
+	return findings
+}
+
+// AssessImageFile opens an image file (EXIF orientation applied) and measures its capture quality
+func AssessImageFile(imagePath string) (ImageQualityMetrics, error) {
+	img, err := imaging.Open(imagePath, imaging.AutoOrientation(true))
+	if err != nil {
+		return ImageQualityMetrics{}, fmt.Errorf("failed to open image: %w", err)
+	}
+	return AssessImageQuality(img), nil
+}
@@ -0,0 +1,334 @@
+// image_sanitize.go - Strip EXIF / text metadata from received images before storage or OCR
+//
+// JPEG: ลบ APP1 (EXIF, XMP), APP3-APP13 / APP15 (IPTC, maker data), COM และข้อมูลหลัง EOI (MPF preview)
+//       เก็บ APP0 (JFIF), APP2 ICC_PROFILE และ APP14 (Adobe - สีของ CMYK)
+// PNG:  ลบ chunk eXIf, tEXt, zTXt, iTXt, tIME และข้อมูลหลัง IEND
+// EXIF orientation 2-8 → decode, หมุน/กลับพิกเซลตาม orientation แล้ว encode ใหม่
+// ไม่มี orientation → ตัดเฉพาะ segment/chunk ออก ไม่ encode ใหม่ (ไม่เสียคุณภาพ)
+// รูปแบบอื่น (PDF ฯลฯ) ไม่แตะ
+
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/disintegration/imaging"
+)
+
+// sanitizeJPEGQuality - quality of a JPEG re-encoded to apply its orientation
+const sanitizeJPEGQuality = 95
+
+var (
+	jpegSOI      = []byte{0xFF, 0xD8}
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// pngMetadataChunks maps the dropped PNG chunks to the name reported in ImageSanitization.Removed
+var pngMetadataChunks = map[string]string{
+	"eXIf": "exif",
+	"tEXt": "text",
+	"zTXt": "text",
+	"iTXt": "text",
+	"tIME": "timestamp",
+}
+
+// SanitizeImageFile strips metadata from the JPEG / PNG at path in place
+// Returns nil when the file is another format or carries no metadata
+func SanitizeImageFile(path string) (*common.ImageSanitization, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	var cleaned []byte
+	var result *common.ImageSanitization
+	switch {
+	case bytes.HasPrefix(data, jpegSOI):
+		cleaned, result, err = sanitizeJPEG(data)
+	case bytes.HasPrefix(data, pngSignature):
+		cleaned, result, err = sanitizePNG(data)
+	default:
+		return nil, nil
+	}
+	if err != nil || result == nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(path, cleaned, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write sanitized image: %w", err)
+	}
+	result.OriginalBytes = int64(len(data))
+	result.FinalBytes = int64(len(cleaned))
+	return result, nil
+}
+
+// sanitizeJPEG copies the JPEG without its metadata segments (nil result = nothing to strip)
+func sanitizeJPEG(data []byte) ([]byte, *common.ImageSanitization, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(jpegSOI)
+	var removed []string
+	orientation := 1
+
+	pos := len(jpegSOI)
+	for {
+		// Step 1: Marker (0xFF fill bytes are allowed before it)
+		if pos >= len(data) || data[pos] != 0xFF {
+			return nil, nil, fmt.Errorf("invalid JPEG: expected marker at offset %d", pos)
+		}
+		for pos < len(data) && data[pos] == 0xFF {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, nil, fmt.Errorf("invalid JPEG: truncated marker")
+		}
+		marker := data[pos]
+		pos++
+		if marker == 0xD9 {
+			out.Write([]byte{0xFF, 0xD9})
+			break
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write([]byte{0xFF, marker})
+			continue
+		}
+
+		// Step 2: Segment (length includes its own 2 bytes)
+		if pos+2 > len(data) {
+			return nil, nil, fmt.Errorf("invalid JPEG: truncated segment")
+		}
+		length := int(binary.BigEndian.Uint16(data[pos:]))
+		if length < 2 || pos+length > len(data) {
+			return nil, nil, fmt.Errorf("invalid JPEG: segment 0x%02X overruns the file", marker)
+		}
+		payload := data[pos+2 : pos+length]
+
+		// Step 3: Start of scan - entropy-coded data (and any later scans) are copied up to EOI
+		if marker == 0xDA {
+			end := jpegEnd(data, pos+length)
+			out.Write([]byte{0xFF, marker})
+			out.Write(data[pos:end])
+			if end < len(data) {
+				removed = appendUnique(removed, "trailer")
+			}
+			break
+		}
+
+		name, drop := jpegMetadataSegment(marker, payload)
+		if drop {
+			removed = appendUnique(removed, name)
+			if name == "exif" {
+				var hasGPS, hasDevice bool
+				orientation, hasGPS, hasDevice = readEXIFTags(payload[len("Exif\x00\x00"):])
+				if hasGPS {
+					removed = appendUnique(removed, "gps")
+				}
+				if hasDevice {
+					removed = appendUnique(removed, "device")
+				}
+			}
+		} else {
+			out.Write([]byte{0xFF, marker})
+			out.Write(data[pos : pos+length])
+		}
+		pos += length
+	}
+
+	if len(removed) == 0 {
+		return nil, nil, nil
+	}
+	result := &common.ImageSanitization{Format: "jpeg", Removed: removed}
+	cleaned := out.Bytes()
+
+	// Step 4: Orientation is applied to the pixels (it is gone with the EXIF segment)
+	if orientation >= 2 && orientation <= 8 {
+		img, err := jpeg.Decode(bytes.NewReader(cleaned))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode JPEG to apply orientation: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, applyOrientation(img, orientation), &jpeg.Options{Quality: sanitizeJPEGQuality}); err != nil {
+			return nil, nil, fmt.Errorf("failed to encode JPEG: %w", err)
+		}
+		cleaned = buf.Bytes()
+		result.Orientation = orientation
+		result.ReEncoded = true
+	}
+	return cleaned, result, nil
+}
+
+// jpegMetadataSegment reports whether a segment carries metadata (and its name in Removed)
+func jpegMetadataSegment(marker byte, payload []byte) (string, bool) {
+	switch {
+	case marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+		return "exif", true
+	case marker == 0xE1 && bytes.HasPrefix(payload, []byte("http://ns.adobe.com/")):
+		return "xmp", true
+	case marker == 0xE1:
+		return "app1", true
+	case marker == 0xE2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")):
+		return "", false
+	case marker == 0xE2 && bytes.HasPrefix(payload, []byte("MPF\x00")):
+		return "mpf", true
+	case marker == 0xE2:
+		return "app2", true
+	case marker == 0xED:
+		return "iptc", true
+	case marker == 0xFE:
+		return "comment", true
+	case marker == 0xE0 || marker == 0xEE:
+		return "", false // JFIF / Adobe color transform
+	case marker >= 0xE3 && marker <= 0xEF:
+		return "maker_data", true
+	}
+	return "", false
+}
+
+// jpegEnd returns the offset just past the first EOI at or after from (len(data) when missing)
+// Inside entropy-coded data 0xFF is always stuffed (0xFF00) or a marker, so the first 0xFFD9 is the real end
+func jpegEnd(data []byte, from int) int {
+	for i := from; i+1 < len(data); i++ {
+		if data[i] == 0xFF && data[i+1] == 0xD9 {
+			return i + 2
+		}
+	}
+	return len(data)
+}
+
+// sanitizePNG copies the PNG without its metadata chunks (nil result = nothing to strip)
+func sanitizePNG(data []byte) ([]byte, *common.ImageSanitization, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+	var removed []string
+	orientation := 1
+
+	pos := len(pngSignature)
+	for pos < len(data) {
+		// length(4) + type(4) + data + crc(4)
+		if pos+12 > len(data) {
+			return nil, nil, fmt.Errorf("invalid PNG: truncated chunk")
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, nil, fmt.Errorf("invalid PNG: chunk overruns the file")
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		if name, ok := pngMetadataChunks[chunkType]; ok {
+			removed = appendUnique(removed, name)
+			if chunkType == "eXIf" {
+				var hasGPS, hasDevice bool
+				orientation, hasGPS, hasDevice = readEXIFTags(data[pos+8 : pos+8+length])
+				if hasGPS {
+					removed = appendUnique(removed, "gps")
+				}
+				if hasDevice {
+					removed = appendUnique(removed, "device")
+				}
+			}
+		} else {
+			out.Write(data[pos:end])
+		}
+		pos = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	if pos < len(data) {
+		removed = appendUnique(removed, "trailer")
+	}
+
+	if len(removed) == 0 {
+		return nil, nil, nil
+	}
+	result := &common.ImageSanitization{Format: "png", Removed: removed}
+	cleaned := out.Bytes()
+
+	if orientation >= 2 && orientation <= 8 {
+		img, err := png.Decode(bytes.NewReader(cleaned))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode PNG to apply orientation: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, applyOrientation(img, orientation)); err != nil {
+			return nil, nil, fmt.Errorf("failed to encode PNG: %w", err)
+		}
+		cleaned = buf.Bytes()
+		result.Orientation = orientation
+		result.ReEncoded = true
+	}
+	return cleaned, result, nil
+}
+
+// readEXIFTags reads orientation (1 when absent) and whether GPS / camera make-model tags exist in IFD0
+func readEXIFTags(tiff []byte) (orientation int, hasGPS, hasDevice bool) {
+	orientation = 1
+	if len(tiff) < 8 {
+		return
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		switch order.Uint16(tiff[entry:]) {
+		case 0x0112: // Orientation (SHORT, left-justified in the value field)
+			orientation = int(order.Uint16(tiff[entry+8:]))
+		case 0x8825: // GPS IFD pointer
+			hasGPS = true
+		case 0x010F, 0x0110: // Make, Model
+			hasDevice = true
+		}
+	}
+	return
+}
+
+// applyOrientation transforms img so it displays upright without the EXIF orientation tag
+func applyOrientation(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+	return img
+}
+
+func appendUnique(list []string, name string) []string {
+	for _, existing := range list {
+		if existing == name {
+			return list
+		}
+	}
+	return append(list, name)
+}
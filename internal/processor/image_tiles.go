@@ -0,0 +1,92 @@
+// image_tiles.go - Split long documents into tiles for chunked OCR
+//
+// ใช้เมื่อ OCR ถูกตัด (FinishReasonMaxTokens) เช่น statement หลายหน้า / ใบเสร็จยาว
+// แบ่งรูปตามด้านที่ยาวกว่าเป็นแถบ (มี overlap กันข้อความขาดตรงรอยต่อ) แล้ว OCR ทีละแถบ
+
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"regexp"
+
+	"github.com/disintegration/imaging"
+)
+
+// TileOverlapRatio - fraction of a tile repeated in the next tile (lines cut at the border are read twice)
+const TileOverlapRatio = 0.05
+
+// SplitImageIntoTiles splits an image into count strips along its longer side
+// Each tile gets the same adaptive enhancement as PreprocessImageHighQuality and is encoded as JPEG
+func SplitImageIntoTiles(imagePath string, count int) ([][]byte, error) {
+	if count < 2 {
+		return nil, fmt.Errorf("tile count must be at least 2, got %d", count)
+	}
+
+	img, err := imaging.Open(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+
+	bounds := img.Bounds()
+	vertical := bounds.Dy() >= bounds.Dx() // Tall document → horizontal strips (top to bottom)
+	length := bounds.Dx()
+	if vertical {
+		length = bounds.Dy()
+	}
+
+	step := length / count
+	overlap := int(float64(step) * TileOverlapRatio)
+
+	tiles := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		start := i*step - overlap
+		end := (i+1)*step + overlap
+		if i == 0 {
+			start = 0
+		}
+		if i == count-1 || end > length {
+			end = length
+		}
+
+		var rect image.Rectangle
+		if vertical {
+			rect = image.Rect(bounds.Min.X, bounds.Min.Y+start, bounds.Max.X, bounds.Min.Y+end)
+		} else {
+			rect = image.Rect(bounds.Min.X+start, bounds.Min.Y, bounds.Min.X+end, bounds.Max.Y)
+		}
+
+		tile := imaging.Crop(img, rect)
+		qualityScore := analyzeImageQuality(tile)
+		var enhanced image.Image
+		if hasUnevenLighting(tile) {
+			enhanced = applyLightEnhancement(compensateIllumination(tile))
+		} else if qualityScore < 50 {
+			enhanced = applyAggressiveEnhancement(tile)
+		} else if qualityScore < 75 {
+			enhanced = applyStandardEnhancement(tile)
+		} else {
+			enhanced = applyLightEnhancement(tile)
+		}
+		enhanced = imaging.Sharpen(enhanced, 1.0)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, enhanced, &jpeg.Options{Quality: 98}); err != nil {
+			return nil, fmt.Errorf("failed to encode tile %d: %w", i+1, err)
+		}
+		tiles = append(tiles, buf.Bytes())
+	}
+
+	return tiles, nil
+}
+
+// pdfPageObject matches page objects (/Type /Page) but not the page tree (/Type /Pages)
+var pdfPageObject = regexp.MustCompile(`/Type\s*/Page[^s]`)
+
+// CountPDFPages estimates the number of pages in a PDF (0 if unknown)
+// Heuristic: counts uncompressed page objects - good enough to plan page-by-page OCR
+func CountPDFPages(data []byte) int {
+	return len(pdfPageObject.FindAll(data, -1))
+}
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"fmt"
 	"image"
+	"image/color"
 	"image/jpeg"
 	"image/png"
 	"math"
@@ -161,7 +162,10 @@ func PreprocessImageHighQuality(imagePath string) ([]byte, string, error) {
 	}
 
 	// Step 3: Apply adaptive processing based on quality score
-	if qualityScore < 50 {
+	if hasUnevenLighting(img) {
+		// Glare bands / shadows - flatten the lighting; the result is already stretched, so only light enhancement
+		img = applyLightEnhancement(compensateIllumination(img))
+	} else if qualityScore < 50 {
 		// Poor quality image - use aggressive enhancement
 		img = applyAggressiveEnhancement(img)
 	} else if qualityScore < 75 {
@@ -196,6 +200,56 @@ func PreprocessImageHighQuality(imagePath string) ([]byte, string, error) {
 	return buf.Bytes(), mimeType, nil
 }
 
+// PreprocessImageHandwriting prepares handwritten documents (บิลเงินสด) for OCR
+// Unlike HighQuality mode, avoids heavy sharpening/thresholding that breaks thin pen strokes
+func PreprocessImageHandwriting(imagePath string) ([]byte, string, error) {
+	// PDF - skip preprocessing and return raw bytes
+	ext := strings.ToLower(filepath.Ext(imagePath))
+	if ext == ".pdf" {
+		pdfData, err := os.ReadFile(imagePath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read PDF: %w", err)
+		}
+		return pdfData, "application/pdf", nil
+	}
+
+	img, err := imaging.Open(imagePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open image: %w", err)
+	}
+
+	// Step 1: Keep more pixels - pen strokes are thin and digits are written small
+	bounds := img.Bounds()
+	maxDimension := 3000
+	if bounds.Dx() > maxDimension || bounds.Dy() > maxDimension {
+		if bounds.Dx() > bounds.Dy() {
+			img = imaging.Resize(img, maxDimension, 0, imaging.Lanczos)
+		} else {
+			img = imaging.Resize(img, 0, maxDimension, imaging.Lanczos)
+		}
+	}
+
+	// Step 2: Remove paper texture (carbon copies, lined paper) before boosting contrast
+	img = imaging.Blur(img, 0.6)
+
+	// Step 3: Grayscale + contrast - ink stands out from the paper without thresholding
+	img = imaging.Grayscale(img)
+	img = imaging.AdjustContrast(img, 35)
+
+	// Step 4: Darken faint ink (ballpoint/pencil)
+	img = imaging.AdjustGamma(img, 0.85)
+
+	// Step 5: Gentle sharpening only - strong sharpening creates halos around strokes
+	img = imaging.Sharpen(img, 1.2)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 98}); err != nil {
+		return nil, "", fmt.Errorf("failed to encode processed image: %w", err)
+	}
+
+	return buf.Bytes(), "image/jpeg", nil
+}
+
 // analyzeImageQuality analyzes image and returns quality score (0-100)
 func analyzeImageQuality(img image.Image) float64 {
 	bounds := img.Bounds()
@@ -238,6 +292,88 @@ func analyzeImageQuality(img image.Image) float64 {
 	return qualityScore
 }
 
+// Illumination compensation (glare bands / shadows under store lighting)
+const (
+	illuminationCells   = 16   // Grid cells along the longer side
+	illuminationMinSpan = 60.0 // Minimum paper-to-ink range per cell (limits noise boost on empty paper)
+	illuminationPaper   = 245.0
+	illuminationInk     = 20.0
+)
+
+// compensateIllumination flattens uneven lighting with adaptive local contrast:
+// the paper level (95th percentile) and ink level (5th percentile) of each grid cell are interpolated
+// between cell centres and every pixel is stretched so local paper → white and local ink → black
+// Returns a grayscale image (glare areas get their faded text back, shadows are lifted)
+func compensateIllumination(img image.Image) image.Image {
+	gray := imaging.Grayscale(img)
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+	cell := int(math.Max(32, float64(max(w, h))/illuminationCells))
+	cols, rows := (w+cell-1)/cell, (h+cell-1)/cell
+	if cols < 2 && rows < 2 {
+		return gray
+	}
+
+	// Step 1: Paper and ink level of every cell (histogram percentiles)
+	paper := make([]float64, cols*rows)
+	ink := make([]float64, cols*rows)
+	for cy := 0; cy < rows; cy++ {
+		for cx := 0; cx < cols; cx++ {
+			var histogram [256]int
+			count := 0
+			for y := cy * cell; y < h && y < (cy+1)*cell; y++ {
+				for x := cx * cell; x < w && x < (cx+1)*cell; x++ {
+					histogram[gray.Pix[y*gray.Stride+x*4]]++
+					count++
+				}
+			}
+			low, high, seen := -1, -1, 0
+			for v := 0; v < 256; v++ {
+				seen += histogram[v]
+				if low < 0 && seen >= count*5/100 {
+					low = v
+				}
+				if high < 0 && seen >= count*95/100 {
+					high = v
+				}
+			}
+			paper[cy*cols+cx] = float64(high)
+			ink[cy*cols+cx] = math.Min(float64(low), float64(high)-illuminationMinSpan)
+		}
+	}
+
+	// Step 2: Bilinear interpolation between cell centres, then stretch each pixel
+	level := func(levels []float64, fx, fy float64) float64 {
+		fx = math.Max(0, math.Min(fx, float64(cols-1)))
+		fy = math.Max(0, math.Min(fy, float64(rows-1)))
+		x0, y0 := int(fx), int(fy)
+		x1, y1 := x0+1, y0+1
+		if x1 >= cols {
+			x1 = cols - 1
+		}
+		if y1 >= rows {
+			y1 = rows - 1
+		}
+		ax, ay := fx-float64(x0), fy-float64(y0)
+		top := levels[y0*cols+x0]*(1-ax) + levels[y0*cols+x1]*ax
+		bottom := levels[y1*cols+x0]*(1-ax) + levels[y1*cols+x1]*ax
+		return top*(1-ay) + bottom*ay
+	}
+	result := imaging.New(w, h, color.NRGBA{255, 255, 255, 255})
+	for y := 0; y < h; y++ {
+		fy := (float64(y)+0.5)/float64(cell) - 0.5
+		for x := 0; x < w; x++ {
+			fx := (float64(x)+0.5)/float64(cell) - 0.5
+			localPaper, localInk := level(paper, fx, fy), level(ink, fx, fy)
+			v := float64(gray.Pix[y*gray.Stride+x*4])
+			v = illuminationInk + (v-localInk)*(illuminationPaper-illuminationInk)/(localPaper-localInk)
+			out := uint8(math.Max(0, math.Min(255, v)))
+			i := y*result.Stride + x*4
+			result.Pix[i], result.Pix[i+1], result.Pix[i+2] = out, out, out
+		}
+	}
+	return result
+}
+
 // applyLightEnhancement for good quality images
 func applyLightEnhancement(img image.Image) image.Image {
 	result := img
@@ -0,0 +1,153 @@
+// journal_book_selector.go - Deterministic journal book selection from per-shop rules
+//
+// เดิม AI เลือกสมุดรายวันเองจากกฎที่เขียนเป็นข้อความใน prompt → ผลไม่คงที่
+// ร้านตั้งกฎได้ (ประเภทเอกสาร + มี/ไม่มี VAT + ซื้อ/ขาย → รหัสสมุด) ระบบประเมินหลัง Phase 3
+// ถ้ามีกฎที่ตรง → ใช้สมุดตามกฎแทนที่ AI เลือก และบันทึกว่ากฎไหนถูกใช้
+
+package processor
+
+import (
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Document directions (who we are in the transaction)
+const (
+	DirectionPurchase = "purchase" // เราเป็นผู้ซื้อ (มีเจ้าหนี้)
+	DirectionSale     = "sale"     // เราเป็นผู้ขาย (มีลูกหนี้)
+	DirectionUnknown  = "unknown"  // DetectDocumentDirection: shop not found in the document
+)
+
+// JournalBookFacts are the document properties rules are evaluated against
+type JournalBookFacts struct {
+	DocumentType string `json:"document_type"`
+	HasVAT       bool   `json:"has_vat"`
+	Direction    string `json:"direction"`
+}
+
+// JournalBookSelection is surfaced as accounting_entry.journal_book_selection
+type JournalBookSelection struct {
+	Source          string           `json:"source"` // "rule" or "ai"
+	RuleID          string           `json:"rule_id,omitempty"`
+	RuleName        string           `json:"rule_name,omitempty"`
+	AIJournalBook   string           `json:"ai_journal_book_code"`
+	JournalBookCode string           `json:"journal_book_code"`
+	Facts           JournalBookFacts `json:"facts"`
+}
+
+// DetectDirection returns DirectionSale when only a debtor is set, otherwise DirectionPurchase
+func DetectDirection(accountingEntry map[string]interface{}) string {
+	if getStringFromInterface(accountingEntry["debtor_code"]) != "" && getStringFromInterface(accountingEntry["creditor_code"]) == "" {
+		return DirectionSale
+	}
+	return DirectionPurchase
+}
+
+// BuildJournalBookFacts derives the facts from the accounting response
+// Document type = first source image that is not a payment slip (the slip only proves payment)
+func BuildJournalBookFacts(accountingResponse map[string]interface{}) JournalBookFacts {
+	facts := JournalBookFacts{DocumentType: "unknown", Direction: DirectionPurchase}
+
+	if sourceImages, ok := accountingResponse["source_images"].([]interface{}); ok {
+		for _, si := range sourceImages {
+			image, ok := si.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			docType := strings.ToLower(strings.TrimSpace(getStringFromInterface(image["type"])))
+			if docType == "" {
+				continue
+			}
+			if facts.DocumentType == "unknown" || facts.DocumentType == "payment_slip" {
+				facts.DocumentType = docType
+			}
+		}
+	}
+
+	if receipt, ok := accountingResponse["receipt"].(map[string]interface{}); ok {
+		facts.HasVAT = amountFromInterface(receipt["vat"]) > 0
+	}
+	if accountingEntry, ok := accountingResponse["accounting_entry"].(map[string]interface{}); ok {
+		facts.Direction = DetectDirection(accountingEntry)
+		if entries, ok := accountingEntry["entries"].([]interface{}); ok {
+			for _, e := range entries {
+				if entry, ok := e.(map[string]interface{}); ok && isVATAccountName(getStringFromInterface(entry["account_name"])) {
+					facts.HasVAT = true
+				}
+			}
+		}
+	}
+	return facts
+}
+
+// MatchJournalBookRule returns the first enabled rule (rules are ordered by priority) matching facts
+// Rules pointing to a journal book that doesn't exist in the shop are skipped
+func MatchJournalBookRule(rules []storage.JournalBookRule, facts JournalBookFacts, journalBooks []bson.M) *storage.JournalBookRule {
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.Enabled || findJournalBookName(journalBooks, rule.JournalBookCode) == "" {
+			continue
+		}
+		if !matchesCondition(rule.DocumentType, facts.DocumentType) || !matchesCondition(rule.Direction, facts.Direction) {
+			continue
+		}
+		switch strings.ToLower(rule.VAT) {
+		case "with":
+			if !facts.HasVAT {
+				continue
+			}
+		case "without":
+			if facts.HasVAT {
+				continue
+			}
+		}
+		return rule
+	}
+	return nil
+}
+
+// ApplyJournalBookRules overrides the AI's journal book when a rule matches
+func ApplyJournalBookRules(accountingResponse map[string]interface{}, rules []storage.JournalBookRule, journalBooks []bson.M) JournalBookSelection {
+	accountingEntry, _ := accountingResponse["accounting_entry"].(map[string]interface{})
+	aiCode := ""
+	if accountingEntry != nil {
+		aiCode = getStringFromInterface(accountingEntry["journal_book_code"])
+	}
+
+	facts := BuildJournalBookFacts(accountingResponse)
+	selection := JournalBookSelection{Source: "ai", AIJournalBook: aiCode, JournalBookCode: aiCode, Facts: facts}
+
+	rule := MatchJournalBookRule(rules, facts, journalBooks)
+	if rule == nil || accountingEntry == nil {
+		return selection
+	}
+
+	accountingEntry["journal_book_code"] = rule.JournalBookCode
+	accountingEntry["journal_book_name"] = findJournalBookName(journalBooks, rule.JournalBookCode)
+	selection.Source = "rule"
+	selection.RuleID = rule.RuleID
+	selection.RuleName = rule.Name
+	selection.JournalBookCode = rule.JournalBookCode
+	return selection
+}
+
+// matchesCondition - empty / "any" matches everything
+func matchesCondition(condition, value string) bool {
+	condition = strings.ToLower(strings.TrimSpace(condition))
+	return condition == "" || condition == "any" || condition == value
+}
+
+// findJournalBookName returns name1 of the journal book with code ("" if not found)
+func findJournalBookName(journalBooks []bson.M, code string) string {
+	for _, jb := range journalBooks {
+		if getStringFromInterface(jb["code"]) == code {
+			if name := getStringFromInterface(jb["name1"]); name != "" {
+				return name
+			}
+			return code
+		}
+	}
+	return ""
+}
@@ -0,0 +1,188 @@
+// language.go - Language, currency and date format of foreign documents
+//
+// prompt เดิมออกแบบสำหรับเอกสารภาษาไทย → ใบกำกับจากต่างประเทศ (ธุรกิจนำเข้า) อ่านวันที่/สกุลเงินผิด
+// ตรวจภาษาจากตัวอักษรในข้อความ OCR (ไทย / อังกฤษ / จีน / ญี่ปุ่น) ก่อน Phase 3 → เลือก prompt + คำศัพท์ของภาษานั้น
+// หลัง Phase 3: แปลงวันที่รูปแบบต่างประเทศ (2024年3月5日, 令和6年3月5日, March 5, 2024) เป็น YYYY-MM-DD และหาสกุลเงินของเอกสาร
+
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Document languages (ISO 639-1)
+const (
+	LanguageThai     = "th"
+	LanguageEnglish  = "en"
+	LanguageChinese  = "zh"
+	LanguageJapanese = "ja"
+)
+
+// CurrencyTHB is the currency of Thai documents (and the default)
+const CurrencyTHB = "THB"
+
+const languageMinLetters = 20 // Fewer letters = not enough text to tell (Thai)
+
+// DetectLanguage counts the letters of each script in the OCR text
+// Any Thai text = Thai (Thai documents often have English product names), kana = Japanese, Han = Chinese, else English
+func DetectLanguage(text string) string {
+	var thai, kana, han, latin int
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Thai, r):
+			thai++
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case r < unicode.MaxASCII && unicode.IsLetter(r):
+			latin++
+		}
+	}
+	letters := thai + kana + han + latin
+
+	switch {
+	case letters < languageMinLetters || thai*10 >= letters: // ≥ 10% Thai
+		return LanguageThai
+	case kana > 0 && kana*20 >= kana+han: // Japanese mixes kanji with ≥ 5% kana
+		return LanguageJapanese
+	case han*5 >= letters: // ≥ 20% Han
+		return LanguageChinese
+	}
+	return LanguageEnglish
+}
+
+// currencyPatterns - currency markers, checked in order (codes before symbols)
+var currencyPatterns = []struct {
+	code    string
+	pattern *regexp.Regexp
+}{
+	{CurrencyTHB, regexp.MustCompile(`(?i)฿|บาท|\bTHB\b|\bbaht\b`)},
+	{"USD", regexp.MustCompile(`(?i)\bUSD\b|US\s?\$|U\.S\.\s?dollars?`)},
+	{"CNY", regexp.MustCompile(`(?i)\bCNY\b|\bRMB\b|人民币|元`)},
+	{"JPY", regexp.MustCompile(`(?i)\bJPY\b|円`)},
+	{"EUR", regexp.MustCompile(`(?i)\bEUR\b|€`)},
+	{"SGD", regexp.MustCompile(`(?i)\bSGD\b|S\$`)},
+	{"HKD", regexp.MustCompile(`(?i)\bHKD\b|HK\$`)},
+}
+
+// DetectCurrency returns the ISO 4217 currency of the document ("" = no marker found)
+// A bare ¥ is yuan on Chinese documents and yen otherwise, a bare $ is USD
+func DetectCurrency(text, language string) string {
+	for _, c := range currencyPatterns {
+		if c.pattern.MatchString(text) {
+			return c.code
+		}
+	}
+	switch {
+	case strings.ContainsAny(text, "¥￥") && language == LanguageChinese:
+		return "CNY"
+	case strings.ContainsAny(text, "¥￥"):
+		return "JPY"
+	case strings.Contains(text, "$"):
+		return "USD"
+	}
+	return ""
+}
+
+// japaneseEras - first year of each era (令和1年 = 2019)
+var japaneseEras = map[string]int{"令和": 2019, "平成": 1989, "R": 2019, "H": 1989}
+
+var (
+	cjkDatePattern     = regexp.MustCompile(`^(令和|平成)?\s*(\d{1,4})\s*[年./-]\s*(\d{1,2})\s*[月./-]\s*(\d{1,2})\s*日?$`)
+	eraShortPattern    = regexp.MustCompile(`^([RH])(\d{1,2})\.(\d{1,2})\.(\d{1,2})$`)
+	englishDateLayouts = []string{"January 2, 2006", "Jan 2, 2006", "2 January 2006", "2 Jan 2006", "Jan. 2, 2006", "02-Jan-2006", "2-Jan-2006"}
+)
+
+// NormalizeForeignDate converts a date written in the document's format to YYYY-MM-DD ("" = not recognized)
+// Numeric English dates (03/05/2024) are read month first - the order of US invoices
+func NormalizeForeignDate(date, language string) string {
+	date = strings.TrimSpace(date)
+	if _, err := time.Parse("2006-01-02", date); err == nil {
+		return date
+	}
+
+	// 2024年3月5日 / 令和6年3月5日 / 2024/03/05 / R6.3.5
+	era, year, month, day := "", "", "", ""
+	if m := cjkDatePattern.FindStringSubmatch(date); m != nil {
+		era, year, month, day = m[1], m[2], m[3], m[4]
+	} else if m := eraShortPattern.FindStringSubmatch(date); m != nil {
+		era, year, month, day = m[1], m[2], m[3], m[4]
+	}
+	if year != "" {
+		y, _ := strconv.Atoi(year)
+		if start, ok := japaneseEras[era]; ok {
+			y += start - 1
+		}
+		m, _ := strconv.Atoi(month)
+		d, _ := strconv.Atoi(day)
+		return validDate(y, m, d)
+	}
+
+	if language == LanguageEnglish {
+		for _, layout := range englishDateLayouts {
+			if t, err := time.Parse(layout, date); err == nil {
+				return t.Format("2006-01-02")
+			}
+		}
+		if t, err := time.Parse("1/2/2006", date); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	return ""
+}
+
+// validDate formats y-m-d when it is a real calendar date ("" otherwise)
+func validDate(y, m, d int) string {
+	formatted := fmt.Sprintf("%04d-%02d-%02d", y, m, d)
+	if _, err := time.Parse("2006-01-02", formatted); err != nil {
+		return ""
+	}
+	return formatted
+}
+
+// ForeignDocument is surfaced as validation.foreign_document
+type ForeignDocument struct {
+	Language     string `json:"language"`
+	Currency     string `json:"currency"`                // ISO 4217 ("" = not stated)
+	DateOriginal string `json:"date_original,omitempty"` // receipt.date before it was normalized
+	Message      string `json:"message,omitempty"`
+}
+
+// RequiresReview reports whether the amounts are not in baht (journal lines must be converted)
+func (f ForeignDocument) RequiresReview() bool {
+	return f.Currency != "" && f.Currency != CurrencyTHB
+}
+
+// ApplyForeignDocument normalizes receipt.date and sets receipt.currency (AI value, else detected from ocrText)
+// Returns nil for Thai documents
+func ApplyForeignDocument(receipt map[string]interface{}, language, ocrText string) *ForeignDocument {
+	if language == "" || language == LanguageThai || receipt == nil {
+		return nil
+	}
+	result := &ForeignDocument{Language: language}
+
+	if date := getStringFromInterface(receipt["date"]); date != "" {
+		if normalized := NormalizeForeignDate(date, language); normalized != "" && normalized != date {
+			result.DateOriginal = date
+			receipt["date"] = normalized
+		}
+	}
+
+	result.Currency = strings.ToUpper(strings.TrimSpace(getStringFromInterface(receipt["currency"])))
+	if result.Currency == "" {
+		result.Currency = DetectCurrency(ocrText, language)
+	}
+	if result.Currency != "" {
+		receipt["currency"] = result.Currency
+	}
+	if result.RequiresReview() {
+		result.Message = fmt.Sprintf("เอกสารเป็นสกุลเงิน %s - ยอดในรายการบัญชียังไม่ได้แปลงเป็นบาท กรุณาตรวจสอบอัตราแลกเปลี่ยน", result.Currency)
+	}
+	return result
+}
@@ -0,0 +1,75 @@
+// learned_mapping.go - Apply the account a user approved for a creditor
+//
+// ผู้ใช้อนุมัติว่าเอกสารจากเจ้าหนี้ X ลงบัญชี 531220 → เอกสารครั้งถัดไปจากเจ้าหนี้เดียวกันใช้บัญชีนี้
+// (บอก AI ก่อนวิเคราะห์ผ่าน vendor pre-matching และแก้รายการค่าใช้จ่ายหลักหลังวิเคราะห์ถ้า AI เลือกบัญชีอื่น)
+// template ที่จับคู่ได้ (template-only mode) มีลำดับความสำคัญสูงกว่า → ไม่แก้รายการ
+
+package processor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+)
+
+// LearnedMappingResult is surfaced as template_info.learned_mapping
+type LearnedMappingResult struct {
+	Used                bool   `json:"used"`
+	CreditorCode        string `json:"creditor_code"`
+	AccountCode         string `json:"account_code"`
+	AccountName         string `json:"account_name"`
+	Approvals           int    `json:"approvals"`
+	ReplacedAccountCode string `json:"replaced_account_code,omitempty"` // AI's account before the mapping was applied
+	ReplacedAccountName string `json:"replaced_account_name,omitempty"`
+	Reason              string `json:"reason"`
+}
+
+// ApplyLearnedMapping moves the main expense line (largest non-VAT debit) to the learned account
+// accountingEntry is the response accounting_entry (entries are modified in place)
+// templateUsed = a template decided the accounts (mapping is reported but not applied)
+func ApplyLearnedMapping(accountingEntry map[string]interface{}, mapping storage.VendorAccountMapping, templateUsed bool) LearnedMappingResult {
+	result := LearnedMappingResult{
+		CreditorCode: mapping.CreditorCode,
+		AccountCode:  mapping.AccountCode,
+		AccountName:  mapping.AccountName,
+		Approvals:    mapping.Approvals,
+	}
+	if templateUsed {
+		result.Reason = "ใช้บัญชีจาก template ที่จับคู่ได้ (template มีลำดับความสำคัญสูงกว่า)"
+		return result
+	}
+
+	entriesRaw, _ := accountingEntry["entries"].([]interface{})
+	var main map[string]interface{}
+	var mainDebit money.Amount
+	for _, e := range entriesRaw {
+		entry, ok := e.(map[string]interface{})
+		if !ok || isVATAccountName(getStringFromInterface(entry["account_name"])) {
+			continue
+		}
+		if debit := amountFromInterface(entry["debit"]); debit > mainDebit {
+			main, mainDebit = entry, debit
+		}
+	}
+	if main == nil {
+		result.Reason = "ไม่พบรายการค่าใช้จ่ายฝั่งเดบิต"
+		return result
+	}
+
+	result.Used = true
+	currentCode := strings.TrimSpace(getStringFromInterface(main["account_code"]))
+	if currentCode == mapping.AccountCode {
+		result.Reason = "AI เลือกบัญชีตรงกับที่ผู้ใช้อนุมัติไว้แล้ว"
+		return result
+	}
+
+	result.ReplacedAccountCode = currentCode
+	result.ReplacedAccountName = getStringFromInterface(main["account_name"])
+	main["account_code"] = mapping.AccountCode
+	main["account_name"] = mapping.AccountName
+	main["selection_reason"] = fmt.Sprintf("ผู้ใช้อนุมัติบัญชีนี้สำหรับเจ้าหนี้ %s แล้ว %d ครั้ง", mapping.CreditorCode, mapping.Approvals)
+	result.Reason = fmt.Sprintf("เปลี่ยนจาก %s %s ตามบัญชีที่ผู้ใช้อนุมัติ", result.ReplacedAccountCode, result.ReplacedAccountName)
+	return result
+}
@@ -0,0 +1,178 @@
+// payload_guard.go - Pre-flight size / token check of images before they are sent to Gemini
+//
+// รูปจากกล้องมือถือบางรูปหลาย MB แม้ผ่าน preprocessing แล้ว → กิน input tokens และเวลา upload
+// - เกิน budget: บีบอัด JPEG ก่อน ถ้ายังเกินค่อยย่อขนาด (ไม่ต่ำกว่า payloadMinLongSide เพื่อให้ตัวอักษรยังอ่านได้)
+// - ใหญ่ผิดปกติ (IMAGE_REJECT_*): ปฏิเสธจาก header ของไฟล์ก่อน decode ทั้งรูป
+
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/disintegration/imaging"
+)
+
+// ImagePayloadLimits are the budgets of one image (0 = no limit)
+type ImagePayloadLimits struct {
+	MaxBytes         int // Payload budget after preprocessing
+	MaxTokens        int // Estimated input token budget (see EstimateImageTokens)
+	RejectBytes      int // Original files above this size are rejected
+	RejectMegapixels int // Original images above this resolution are rejected
+}
+
+// ImageTooLargeError is returned for images the payload guard refuses to send
+type ImageTooLargeError struct {
+	Image  string `json:"image"`
+	Reason string `json:"reason"` // "file_size", "resolution" or "payload" (still over budget at the minimum size)
+	Value  int    `json:"value"`
+	Limit  int    `json:"limit"`
+}
+
+func (e *ImageTooLargeError) Error() string {
+	switch e.Reason {
+	case "file_size":
+		return fmt.Sprintf("image %s is too large: %d bytes (limit %d)", e.Image, e.Value, e.Limit)
+	case "resolution":
+		return fmt.Sprintf("image %s is too large: %d megapixels (limit %d)", e.Image, e.Value, e.Limit)
+	default:
+		return fmt.Sprintf("image %s is still %d bytes after reduction (limit %d)", e.Image, e.Value, e.Limit)
+	}
+}
+
+const (
+	geminiImageTileSize = 768 // Gemini bills 258 tokens per 768x768 tile
+	geminiTokensPerTile = 258
+	payloadMinLongSide  = 1200 // Never downscale below this (small Thai text becomes unreadable)
+	payloadScaleStep    = 0.8
+)
+
+// payloadJPEGQualities are tried in order before downscaling
+var payloadJPEGQualities = []int{90, 80, 70, 60}
+
+// EstimateImageTokens estimates the Gemini input tokens of an image
+// Images up to 384px on both sides count as one tile, larger ones are billed per 768x768 tile
+func EstimateImageTokens(width, height int) int {
+	if width <= 0 || height <= 0 {
+		return 0
+	}
+	if width <= geminiImageTileSize/2 && height <= geminiImageTileSize/2 {
+		return geminiTokensPerTile
+	}
+	tilesX := (width + geminiImageTileSize - 1) / geminiImageTileSize
+	tilesY := (height + geminiImageTileSize - 1) / geminiImageTileSize
+	return tilesX * tilesY * geminiTokensPerTile
+}
+
+// CheckImageFile rejects absurd files before they are decoded (size from stat, resolution from the header only)
+func CheckImageFile(imagePath string, limits ImagePayloadLimits) error {
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat image: %w", err)
+	}
+	name := filepath.Base(imagePath)
+	if limits.RejectBytes > 0 && info.Size() > int64(limits.RejectBytes) {
+		return &ImageTooLargeError{Image: name, Reason: "file_size", Value: int(info.Size()), Limit: limits.RejectBytes}
+	}
+	if limits.RejectMegapixels <= 0 || strings.ToLower(filepath.Ext(imagePath)) == ".pdf" {
+		return nil
+	}
+
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to open image: %w", err)
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil // Unknown format - let preprocessing report it
+	}
+	if megapixels := config.Width * config.Height / 1_000_000; megapixels > limits.RejectMegapixels {
+		return &ImageTooLargeError{Image: name, Reason: "resolution", Value: megapixels, Limit: limits.RejectMegapixels}
+	}
+	return nil
+}
+
+// FitImagePayload shrinks a preprocessed image until it fits MaxBytes / MaxTokens
+// Returns the data unchanged (nil reduction) when it already fits. PDFs are passed through (only CheckImageFile applies)
+func FitImagePayload(imagePath string, data []byte, mimeType string, limits ImagePayloadLimits) ([]byte, string, *common.ImageReduction, error) {
+	if mimeType == "application/pdf" {
+		return data, mimeType, nil, nil
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return data, mimeType, nil, nil // Unknown format - sent as is
+	}
+	tokens := EstimateImageTokens(config.Width, config.Height)
+	overBytes := limits.MaxBytes > 0 && len(data) > limits.MaxBytes
+	overTokens := limits.MaxTokens > 0 && tokens > limits.MaxTokens
+	if !overBytes && !overTokens {
+		return data, mimeType, nil, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to decode image for payload check: %w", err)
+	}
+
+	// Step 1: Downscale until the estimated tokens fit (soft limit - stops at payloadMinLongSide)
+	width, height := config.Width, config.Height
+	for limits.MaxTokens > 0 && EstimateImageTokens(width, height) > limits.MaxTokens && canDownscale(width, height) {
+		width, height = int(float64(width)*payloadScaleStep), int(float64(height)*payloadScaleStep)
+	}
+
+	// Step 2: Re-encode as JPEG with decreasing quality, downscale further while still over MaxBytes
+	for {
+		resized := img
+		if width != config.Width || height != config.Height {
+			resized = imaging.Resize(img, width, height, imaging.Lanczos)
+		}
+
+		var encoded []byte
+		for _, quality := range payloadJPEGQualities {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, resized, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, "", nil, fmt.Errorf("failed to encode reduced image: %w", err)
+			}
+			encoded = buf.Bytes()
+			if limits.MaxBytes > 0 && len(encoded) > limits.MaxBytes {
+				continue
+			}
+
+			reduction := &common.ImageReduction{
+				Image:           filepath.Base(imagePath),
+				Action:          "recompressed",
+				OriginalBytes:   len(data),
+				FinalBytes:      len(encoded),
+				OriginalWidth:   config.Width,
+				OriginalHeight:  config.Height,
+				FinalWidth:      width,
+				FinalHeight:     height,
+				OriginalTokens:  tokens,
+				FinalTokens:     EstimateImageTokens(width, height),
+				JPEGQuality:     quality,
+				ReductionFactor: fmt.Sprintf("%.1fx", float64(len(data))/float64(len(encoded))),
+			}
+			if width != config.Width || height != config.Height {
+				reduction.Action = "downscaled"
+			}
+			return encoded, "image/jpeg", reduction, nil
+		}
+
+		if !canDownscale(width, height) {
+			return nil, "", nil, &ImageTooLargeError{Image: filepath.Base(imagePath), Reason: "payload", Value: len(encoded), Limit: limits.MaxBytes}
+		}
+		width, height = int(float64(width)*payloadScaleStep), int(float64(height)*payloadScaleStep)
+	}
+}
+
+// canDownscale reports whether one more payloadScaleStep keeps the long side at payloadMinLongSide or above
+func canDownscale(width, height int) bool {
+	return float64(max(width, height))*payloadScaleStep >= payloadMinLongSide
+}
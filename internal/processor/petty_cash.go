@@ -0,0 +1,215 @@
+// petty_cash.go - Petty cash / expense claim batch mode
+//
+// request เดียวมีใบเสร็จย่อยหลายใบ (ค่าน้ำมัน ค่าจอดรถ ค่าเครื่องเขียน ...) ที่ต้องบันทึกเป็นใบสำคัญจ่ายเงินสดย่อยใบเดียว
+//   - แยกรูปเป็นใบเสร็จละกลุ่ม (ไม่ต้องมีเลขที่เอกสาร) → วิเคราะห์บัญชีทีละใบ
+//   - รวมเป็น voucher: รายการเดบิตของทุกใบเสร็จ + เครดิตเงินสดย่อยบรรทัดเดียว (ยอดรวม)
+//   - แสดงรายละเอียดรายใบใน petty_cash.items
+
+package processor
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// pettyCashAccountNames - chart of accounts names used when the shop has no petty cash account set
+var pettyCashAccountNames = []string{"เงินสดย่อย", "petty cash"}
+
+// PettyCashReceipt is one analyzed receipt of the batch
+type PettyCashReceipt struct {
+	ImageIndices    []int
+	Receipt         map[string]interface{}
+	AccountingEntry map[string]interface{}
+	RequiresReview  bool
+}
+
+// PettyCashItem is the itemized breakdown of one receipt (response petty_cash.items[])
+type PettyCashItem struct {
+	ImageIndices   []int                    `json:"image_indices"`
+	DocumentNumber string                   `json:"document_number,omitempty"`
+	Date           string                   `json:"date,omitempty"`
+	VendorName     string                   `json:"vendor_name,omitempty"`
+	Amount         money.Amount             `json:"amount"`
+	Entries        []map[string]interface{} `json:"entries"`
+	RequiresReview bool                     `json:"requires_review"`
+	Note           string                   `json:"note,omitempty"`
+}
+
+// PettyCashVoucher is the consolidated journal voucher (response petty_cash)
+type PettyCashVoucher struct {
+	AccountCode    string          `json:"account_code"`
+	AccountName    string          `json:"account_name"`
+	ReceiptCount   int             `json:"receipt_count"`
+	TotalAmount    money.Amount    `json:"total_amount"`
+	Items          []PettyCashItem `json:"items"`
+	RequiresReview bool            `json:"requires_review"`
+	Note           string          `json:"note,omitempty"`
+}
+
+// ClusterReceipts splits a petty cash batch: every image is its own receipt except
+// pages sharing a document number and continuation pages (no number, no amount)
+func ClusterReceipts(fingerprints []DocumentFingerprint) []DocumentCluster {
+	sorted := append([]DocumentFingerprint(nil), fingerprints...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ImageIndex < sorted[j].ImageIndex })
+
+	var clusters []DocumentCluster
+	numberCluster := map[string]int{}
+	for _, fp := range sorted {
+		target := -1
+		for _, n := range fp.DocumentNumbers {
+			if ci, ok := numberCluster[n]; ok {
+				target = ci
+			}
+		}
+		switch {
+		case target >= 0:
+			clusters[target].ImageIndices = append(clusters[target].ImageIndices, fp.ImageIndex)
+			clusters[target].Reasons = append(clusters[target].Reasons, "same document number")
+		case len(fp.DocumentNumbers) == 0 && fp.Total == 0 && len(clusters) > 0:
+			target = len(clusters) - 1
+			clusters[target].ImageIndices = append(clusters[target].ImageIndices, fp.ImageIndex)
+			clusters[target].Reasons = append(clusters[target].Reasons, "continuation page")
+		default:
+			cluster := DocumentCluster{ImageIndices: []int{fp.ImageIndex}, Total: fp.Total, Reasons: []string{"petty cash receipt"}}
+			if len(fp.DocumentNumbers) > 0 {
+				cluster.DocumentNumber = fp.DocumentNumbers[0]
+			}
+			clusters = append(clusters, cluster)
+			target = len(clusters) - 1
+		}
+		for _, n := range fp.DocumentNumbers {
+			numberCluster[n] = target
+		}
+		clusters[target].Total = math.Max(clusters[target].Total, fp.Total)
+	}
+	return clusters
+}
+
+// FindPettyCashAccount returns the petty cash account: the shop's configured code, else the first
+// posting account named เงินสดย่อย / petty cash ("" = not found)
+func FindPettyCashAccount(accounts []bson.M, configuredCode string) (string, string) {
+	for _, acc := range accounts {
+		code := getStringFromInterface(acc["accountcode"])
+		if configuredCode != "" && code == configuredCode {
+			return code, getStringFromInterface(acc["accountname"])
+		}
+	}
+	if configuredCode != "" {
+		return "", ""
+	}
+	for _, acc := range accounts {
+		name := strings.ToLower(getStringFromInterface(acc["accountname"]))
+		if !isPostingAccount(acc) {
+			continue
+		}
+		for _, keyword := range pettyCashAccountNames {
+			if strings.Contains(name, keyword) {
+				return getStringFromInterface(acc["accountcode"]), getStringFromInterface(acc["accountname"])
+			}
+		}
+	}
+	return "", ""
+}
+
+// BuildPettyCashVoucher consolidates the receipts into one voucher:
+// the debit lines of every receipt + one credit to petty cash for the total
+// Each receipt's own credit lines (cash / payable) are replaced by the petty cash credit
+func BuildPettyCashVoucher(receipts []PettyCashReceipt, accountCode, accountName string) (PettyCashVoucher, []interface{}) {
+	voucher := PettyCashVoucher{AccountCode: accountCode, AccountName: accountName, ReceiptCount: len(receipts), Items: []PettyCashItem{}}
+	var lines []interface{}
+
+	for _, r := range receipts {
+		item := PettyCashItem{
+			ImageIndices:   r.ImageIndices,
+			DocumentNumber: getStringFromInterface(r.Receipt["number"]),
+			Date:           getStringFromInterface(r.Receipt["date"]),
+			VendorName:     getStringFromInterface(r.Receipt["vendor_name"]),
+			Entries:        []map[string]interface{}{},
+			RequiresReview: r.RequiresReview,
+		}
+
+		creditLines := 0
+		unreadableLines := 0
+		entriesRaw, _ := r.AccountingEntry["entries"].([]interface{})
+		for _, e := range entriesRaw {
+			entry, ok := e.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			debit, debitOK := money.Parse(entry["debit"])
+			credit, creditOK := money.Parse(entry["credit"])
+			if !debitOK || !creditOK {
+				unreadableLines++ // Not 0 - the voucher total would silently miss this amount
+				continue
+			}
+			if debit <= 0 {
+				if credit > 0 {
+					creditLines++
+				}
+				continue
+			}
+			description := getStringFromInterface(entry["description"])
+			if ref := pettyCashReference(item); ref != "" {
+				description = strings.TrimSpace(fmt.Sprintf("%s (%s)", description, ref))
+			}
+			line := map[string]interface{}{
+				"account_code": getStringFromInterface(entry["account_code"]),
+				"account_name": getStringFromInterface(entry["account_name"]),
+				"debit":        debit.Baht(),
+				"credit":       0.0,
+				"description":  description,
+			}
+			item.Entries = append(item.Entries, line)
+			item.Amount += debit
+			lines = append(lines, line)
+		}
+
+		// Withholding tax / discounts on the credit side cannot be paid from petty cash as-is
+		if creditLines > 1 {
+			item.RequiresReview = true
+			item.Note = "ใบเสร็จมีรายการฝั่งเครดิตมากกว่า 1 รายการ (เช่น ภาษีหัก ณ ที่จ่าย) - ตรวจสอบยอดจ่ายจากเงินสดย่อย"
+		}
+		if len(item.Entries) == 0 {
+			item.RequiresReview = true
+			item.Note = "ไม่พบรายการค่าใช้จ่ายของใบเสร็จนี้"
+		}
+		if unreadableLines > 0 {
+			item.RequiresReview = true
+			item.Note = fmt.Sprintf("อ่านยอดเงินไม่ได้ %d รายการ (ไม่ได้รวมในใบสำคัญจ่าย) - ตรวจสอบยอดของใบเสร็จนี้", unreadableLines)
+		}
+		if item.RequiresReview {
+			voucher.RequiresReview = true
+		}
+		voucher.TotalAmount += item.Amount
+		voucher.Items = append(voucher.Items, item)
+	}
+
+	if accountCode == "" {
+		voucher.RequiresReview = true
+		voucher.Note = "ไม่พบบัญชีเงินสดย่อยในผังบัญชี - กรุณาระบุบัญชีเครดิต"
+	}
+	lines = append(lines, map[string]interface{}{
+		"account_code": accountCode,
+		"account_name": accountName,
+		"debit":        0.0,
+		"credit":       voucher.TotalAmount.Baht(),
+		"description":  fmt.Sprintf("จ่ายเงินสดย่อย %d รายการ", len(receipts)),
+	})
+	return voucher, lines
+}
+
+// pettyCashReference - receipt number, else vendor name, appended to each voucher line
+func pettyCashReference(item PettyCashItem) string {
+	if item.DocumentNumber != "" && item.DocumentNumber != "N/A" {
+		return item.DocumentNumber
+	}
+	if item.VendorName != "" && item.VendorName != "N/A" && item.VendorName != "Unknown Vendor" {
+		return item.VendorName
+	}
+	return ""
+}
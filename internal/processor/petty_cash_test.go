@@ -0,0 +1,47 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+)
+
+func testEntry(code string, debit, credit interface{}) map[string]interface{} {
+	return map[string]interface{}{"account_code": code, "account_name": code, "debit": debit, "credit": credit}
+}
+
+func TestBuildPettyCashVoucherAmounts(t *testing.T) {
+	receipts := []PettyCashReceipt{
+		{ // Formatted strings are read like numbers
+			Receipt: map[string]interface{}{"number": "R1"},
+			AccountingEntry: map[string]interface{}{"entries": []interface{}{
+				testEntry("5100", "1,234.50", 0.0),
+				testEntry("1000", 0.0, "1,234.50"),
+			}},
+		},
+		{ // Unreadable debit - reported, not counted as 0
+			Receipt: map[string]interface{}{"number": "R2"},
+			AccountingEntry: map[string]interface{}{"entries": []interface{}{
+				testEntry("5200", 100.0, 0.0),
+				testEntry("5300", "12-15", 0.0),
+				testEntry("1000", 0.0, 100.0),
+			}},
+		},
+	}
+
+	voucher, lines := BuildPettyCashVoucher(receipts, "1010", "เงินสดย่อย")
+	if voucher.Items[0].Amount != money.FromBaht(1234.50) || voucher.Items[0].RequiresReview {
+		t.Errorf("item R1 = %s (review %v), want 1234.50 without review", voucher.Items[0].Amount, voucher.Items[0].RequiresReview)
+	}
+	item := voucher.Items[1]
+	if item.Amount != money.FromBaht(100) || !item.RequiresReview || !strings.Contains(item.Note, "อ่านยอดเงินไม่ได้ 1 รายการ") {
+		t.Errorf("item R2 = %s (review %v, note %q), want 100.00 with review", item.Amount, item.RequiresReview, item.Note)
+	}
+	if !voucher.RequiresReview || voucher.TotalAmount != money.FromBaht(1334.50) {
+		t.Errorf("voucher total = %s (review %v), want 1334.50 with review", voucher.TotalAmount, voucher.RequiresReview)
+	}
+	if len(lines) != 3 {
+		t.Errorf("voucher lines = %d, want 2 debits + 1 credit", len(lines))
+	}
+}
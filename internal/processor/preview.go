@@ -0,0 +1,76 @@
+// preview.go - Thumbnails and field locations for the frontend preview (include_preview)
+//
+// หน้าบ้านแสดงรูปย่อพร้อมกรอบตำแหน่งที่อ่านค่ามา (ยอดรวม, วันที่, ผู้ขาย ...)
+// ตำแหน่งเป็นสัดส่วน 0-1 ของรูป (ไม่ขึ้นกับขนาด) → วาดบน thumbnail หรือรูปเต็มได้เหมือนกัน
+
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+
+	"github.com/disintegration/imaging"
+)
+
+// Receipt fields located on the image (receipt{} keys)
+var PreviewFields = []string{"vendor_name", "vendor_tax_id", "number", "date", "total", "vat"}
+
+// FieldBox is a rectangle as a fraction (0-1) of the image width / height, origin top-left
+type FieldBox struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// FieldLocation is where a receipt value is printed
+type FieldLocation struct {
+	Field      string   `json:"field"`
+	Value      string   `json:"value"`
+	ImageIndex int      `json:"image_index"`
+	Box        FieldBox `json:"box"`
+}
+
+// FieldBoxFromBox2D converts a Gemini box_2d ([ymin, xmin, ymax, xmax] on a 0-1000 scale)
+// Returns false for malformed or empty boxes
+func FieldBoxFromBox2D(box []float64) (FieldBox, bool) {
+	if len(box) != 4 {
+		return FieldBox{}, false
+	}
+	for i, v := range box {
+		if v < 0 {
+			box[i] = 0
+		} else if v > 1000 {
+			box[i] = 1000
+		}
+	}
+	yMin, xMin, yMax, xMax := box[0], box[1], box[2], box[3]
+	if yMax <= yMin || xMax <= xMin {
+		return FieldBox{}, false
+	}
+	return FieldBox{X: xMin / 1000, Y: yMin / 1000, Width: (xMax - xMin) / 1000, Height: (yMax - yMin) / 1000}, true
+}
+
+// GenerateThumbnail returns the image scaled to fit maxDimension as JPEG (with its size)
+// Orientation is kept as stored (same pixels the OCR saw, so field boxes line up)
+func GenerateThumbnail(imagePath string, maxDimension int) ([]byte, int, int, error) {
+	img, err := imaging.Open(imagePath)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to open image: %w", err)
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() > maxDimension || bounds.Dy() > maxDimension {
+		if bounds.Dx() > bounds.Dy() {
+			img = imaging.Resize(img, maxDimension, 0, imaging.Lanczos)
+		} else {
+			img = imaging.Resize(img, 0, maxDimension, imaging.Lanczos)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), img.Bounds().Dx(), img.Bounds().Dy(), nil
+}
@@ -0,0 +1,290 @@
+// qrcode.go - Decode QR codes / barcodes on receipts and payment slips
+//
+// ใบเสร็จ e-Tax, QR PromptPay และสลิปโอนเงินมี QR ที่เก็บเลขผู้เสียภาษี ยอดเงิน และเลขอ้างอิงไว้
+// ข้อมูลจาก QR แม่นยำกว่า OCR เสมอ → ถอดรหัสจากรูปต้นฉบับแล้วใช้แทนค่าที่ AI อ่านได้
+
+package processor
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"github.com/disintegration/imaging"
+	"github.com/makiuchi-d/gozxing"
+	multiqr "github.com/makiuchi-d/gozxing/multi/qrcode"
+	"github.com/makiuchi-d/gozxing/oned"
+)
+
+// Kinds of decoded payloads
+const (
+	CodeKindPromptPay        = "promptpay"         // Thai QR Payment - credit transfer (tag 29)
+	CodeKindBillPayment      = "bill_payment"      // Thai QR Payment / barcode - bill payment (tag 30)
+	CodeKindSlipVerification = "slip_verification" // Mini QR on bank transfer slips (sending bank + transaction ref)
+	CodeKindTaxInvoice       = "tax_invoice"       // Other payloads containing a 13-digit tax ID (e-Tax invoice / e-Receipt)
+	CodeKindUnknown          = "unknown"
+)
+
+// qrDecodeMaxDimension - large photos are scaled down before decoding (QR modules stay readable, decoding is faster)
+const qrDecodeMaxDimension = 2000
+
+// DecodedCode is one QR code / barcode found on an image (surfaced in document_analysis.qr_codes)
+type DecodedCode struct {
+	ImageIndex     int      `json:"image_index"`
+	Format         string   `json:"format"` // QR_CODE, CODE_128, ...
+	Kind           string   `json:"kind"`
+	Raw            string   `json:"raw"`
+	TaxID          string   `json:"tax_id,omitempty"`
+	Amount         *float64 `json:"amount,omitempty"`
+	MerchantName   string   `json:"merchant_name,omitempty"`
+	Reference1     string   `json:"reference_1,omitempty"`
+	Reference2     string   `json:"reference_2,omitempty"`
+	SendingBank    string   `json:"sending_bank,omitempty"`
+	TransactionRef string   `json:"transaction_ref,omitempty"`
+}
+
+// QRFieldOverride records a receipt field replaced by a QR-decoded value
+type QRFieldOverride struct {
+	Field      string      `json:"field"`
+	OCRValue   interface{} `json:"ocr_value"`
+	QRValue    interface{} `json:"qr_value"`
+	ImageIndex int         `json:"image_index"`
+}
+
+// DecodeImageCodes finds all QR codes and Code 128 barcodes on an image
+// PDFs are skipped (no rasterizer) - returns nil without error
+func DecodeImageCodes(imagePath string, imageIndex int) ([]DecodedCode, error) {
+	if strings.ToLower(filepath.Ext(imagePath)) == ".pdf" {
+		return nil, nil
+	}
+
+	img, err := imaging.Open(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() > qrDecodeMaxDimension || bounds.Dy() > qrDecodeMaxDimension {
+		if bounds.Dx() > bounds.Dy() {
+			img = imaging.Resize(img, qrDecodeMaxDimension, 0, imaging.Lanczos)
+		} else {
+			img = imaging.Resize(img, 0, qrDecodeMaxDimension, imaging.Lanczos)
+		}
+	}
+
+	bitmap, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare image for decoding: %w", err)
+	}
+	hints := map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_TRY_HARDER: true,
+	}
+
+	var codes []DecodedCode
+	seen := map[string]bool{}
+	add := func(result *gozxing.Result) {
+		text := result.GetText()
+		if text == "" || seen[text] {
+			return
+		}
+		seen[text] = true
+		code := ParseCodePayload(text)
+		code.ImageIndex = imageIndex
+		code.Format = result.GetBarcodeFormat().String()
+		codes = append(codes, code)
+	}
+
+	// Step 1: QR codes (a receipt may have several - e.g. e-Tax QR + PromptPay QR)
+	if results, err := multiqr.NewQRCodeMultiReader().DecodeMultiple(bitmap, hints); err == nil {
+		for _, result := range results {
+			add(result)
+		}
+	}
+
+	// Step 2: Code 128 barcode (Thai bill payment barcode on utility bills / invoices)
+	if result, err := oned.NewCode128Reader().Decode(bitmap, hints); err == nil {
+		add(result)
+	}
+
+	return codes, nil
+}
+
+// ParseCodePayload recognizes Thai payment payloads and extracts tax ID, amount and references
+func ParseCodePayload(raw string) DecodedCode {
+	code := DecodedCode{Kind: CodeKindUnknown, Raw: raw}
+	payload := strings.TrimSpace(raw)
+
+	// Thai bill payment barcode: |<tax ID 13 + suffix 2>\r<ref1>\r<ref2>\r<amount in satang>
+	if strings.HasPrefix(payload, "|") {
+		parts := strings.Split(strings.TrimPrefix(payload, "|"), "\r")
+		if len(parts) == 1 {
+			parts = strings.Split(parts[0], "\n")
+		}
+		if len(parts) >= 4 && len(parts[0]) >= 13 {
+			code.Kind = CodeKindBillPayment
+			code.TaxID = parts[0][:13]
+			code.Reference1 = strings.TrimSpace(parts[1])
+			code.Reference2 = strings.TrimSpace(parts[2])
+			if satang, err := strconv.ParseInt(strings.TrimSpace(parts[3]), 10, 64); err == nil && satang > 0 {
+				amount := float64(satang) / 100
+				code.Amount = &amount
+			}
+			return code
+		}
+	}
+
+	// EMVCo TLV payloads (Thai QR Payment / slip verification mini QR)
+	if fields, ok := parseEMVTLV(payload); ok {
+		// Slip verification: tag 00 = nested (API ID, sending bank, transaction ref), tag 51 = country
+		if fields["51"] == "TH" && len(fields["00"]) > 6 {
+			if sub, ok := parseEMVTLV(fields["00"]); ok && sub["02"] != "" {
+				code.Kind = CodeKindSlipVerification
+				code.SendingBank = sub["01"]
+				code.TransactionRef = sub["02"]
+				return code
+			}
+		}
+
+		if fields["00"] == "01" {
+			if amount, err := strconv.ParseFloat(fields["54"], 64); err == nil && amount > 0 {
+				code.Amount = &amount
+			}
+			code.MerchantName = fields["59"]
+
+			if sub, ok := parseEMVTLV(fields["30"]); ok {
+				// Bill payment: 01 = biller ID (tax ID + 2-digit suffix), 02/03 = references
+				code.Kind = CodeKindBillPayment
+				if len(sub["01"]) >= 13 {
+					code.TaxID = sub["01"][:13]
+				}
+				code.Reference1 = sub["02"]
+				code.Reference2 = sub["03"]
+				return code
+			}
+			if sub, ok := parseEMVTLV(fields["29"]); ok {
+				// Credit transfer: 01 = mobile, 02 = tax ID / national ID, 03 = e-wallet
+				code.Kind = CodeKindPromptPay
+				if len(sub["02"]) == 13 {
+					code.TaxID = sub["02"]
+				}
+				return code
+			}
+		}
+	}
+
+	// Anything else (e-Tax invoice / e-Receipt QR): use a single 13-digit tax ID if present
+	if ids := uniqueTaxIDs(payload); len(ids) == 1 {
+		code.Kind = CodeKindTaxInvoice
+		code.TaxID = ids[0]
+	}
+	return code
+}
+
+// parseEMVTLV parses tag(2) + length(2) + value records, ok=false if the payload is not valid TLV
+func parseEMVTLV(payload string) (map[string]string, bool) {
+	fields := map[string]string{}
+	for i := 0; i < len(payload); {
+		if i+4 > len(payload) {
+			return nil, false
+		}
+		tag := payload[i : i+2]
+		length, err := strconv.Atoi(payload[i+2 : i+4])
+		if err != nil || i+4+length > len(payload) {
+			return nil, false
+		}
+		fields[tag] = payload[i+4 : i+4+length]
+		i += 4 + length
+	}
+	return fields, len(fields) > 0
+}
+
+// taxIDCandidate matches 13 digits not surrounded by other digits
+var taxIDCandidate = regexp.MustCompile(`(?:^|\D)(\d{13})(?:\D|$)`)
+
+// uniqueTaxIDs returns distinct 13-digit numbers in the payload
+func uniqueTaxIDs(payload string) []string {
+	var ids []string
+	seen := map[string]bool{}
+	for _, match := range taxIDCandidate.FindAllStringSubmatch(payload, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			ids = append(ids, match[1])
+		}
+	}
+	return ids
+}
+
+// MergeDecodedCodes replaces receipt fields with QR-decoded values (QR wins over OCR)
+// Only unambiguous values are used: a field is skipped when codes disagree with each other
+// Returns the fields that were changed
+func MergeDecodedCodes(receipt map[string]interface{}, codes []DecodedCode) []QRFieldOverride {
+	if receipt == nil || len(codes) == 0 {
+		return nil
+	}
+
+	var taxIDCode, amountCode *DecodedCode
+	taxIDConflict, amountConflict := false, false
+	for i := range codes {
+		code := &codes[i]
+		if code.TaxID != "" {
+			if taxIDCode != nil && taxIDCode.TaxID != code.TaxID {
+				taxIDConflict = true
+			}
+			taxIDCode = code
+		}
+		if code.Amount != nil {
+			if amountCode != nil && math.Abs(*amountCode.Amount-*code.Amount) > 0.01 {
+				amountConflict = true
+			}
+			amountCode = code
+		}
+	}
+
+	var overrides []QRFieldOverride
+	if taxIDCode != nil && !taxIDConflict {
+		current := getStringFromInterface(receipt["vendor_tax_id"])
+		if digitsOnly(current) != taxIDCode.TaxID {
+			overrides = append(overrides, QRFieldOverride{Field: "vendor_tax_id", OCRValue: current, QRValue: taxIDCode.TaxID, ImageIndex: taxIDCode.ImageIndex})
+			receipt["vendor_tax_id"] = taxIDCode.TaxID
+		}
+	}
+	if amountCode != nil && !amountConflict {
+		// An unreadable OCR total is replaced by the QR amount too (OCRValue keeps what was read)
+		if current, ok := money.Parse(receipt["total"]); !ok || current != money.FromBaht(*amountCode.Amount) {
+			var ocrValue interface{} = receipt["total"]
+			if ok {
+				ocrValue = current.Baht()
+			}
+			overrides = append(overrides, QRFieldOverride{Field: "total", OCRValue: ocrValue, QRValue: *amountCode.Amount, ImageIndex: amountCode.ImageIndex})
+			receipt["total"] = *amountCode.Amount
+		}
+	}
+	return overrides
+}
+
+// Summary is a one-line description appended to the OCR text so the accounting AI sees QR data too
+func (c DecodedCode) Summary() string {
+	parts := []string{"kind=" + c.Kind}
+	if c.TaxID != "" {
+		parts = append(parts, "tax_id="+c.TaxID)
+	}
+	if c.Amount != nil {
+		parts = append(parts, fmt.Sprintf("amount=%.2f", *c.Amount))
+	}
+	if c.MerchantName != "" {
+		parts = append(parts, "merchant="+c.MerchantName)
+	}
+	if c.Reference1 != "" {
+		parts = append(parts, "ref1="+c.Reference1)
+	}
+	if c.Reference2 != "" {
+		parts = append(parts, "ref2="+c.Reference2)
+	}
+	if c.TransactionRef != "" {
+		parts = append(parts, "transaction_ref="+c.TransactionRef)
+	}
+	return fmt.Sprintf("[QR %s] %s", c.Format, strings.Join(parts, " "))
+}
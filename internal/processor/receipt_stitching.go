@@ -0,0 +1,217 @@
+// receipt_stitching.go - Merge the OCR text of overlapping photos of one long receipt
+//
+// ใบเสร็จซุปเปอร์มาร์เก็ตยาวต้องถ่าย 2-3 รูปที่ซ้อนกัน → เดิมวิเคราะห์เป็นเอกสารแยกกัน (รายการซ้ำ / ยอดรวมหาย)
+// หาบรรทัดที่ซ้ำกันระหว่างท้ายรูปหนึ่งกับต้นอีกรูป → เรียงลำดับรูปจากบนลงล่าง แล้วรวมข้อความโดยตัดส่วนที่ซ้ำออก
+// บรรทัดที่ขอบรูป (ถูกตัดครึ่ง) ข้ามได้ไม่เกิน stitchEdgeLines บรรทัด
+
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	stitchMinOverlapLines = 2    // Consecutive matching lines needed to link two photos
+	stitchMinAnchorLength = 8    // At least one overlapping line must be this long (not just "1 x" / "35.00")
+	stitchEdgeLines       = 2    // Half-cut lines allowed at the bottom of the upper / top of the lower photo
+	stitchLineSimilarity  = 0.85 // OCR of the same line in two photos differs by a few characters
+	stitchMaxSearchLines  = 40   // Lines of each photo searched for the overlap
+)
+
+// StitchSegment is the OCR text of one photo
+type StitchSegment struct {
+	ImageIndex int
+	Text       string
+}
+
+// StitchOverlap links the bottom of the upper photo to the top of the lower photo
+type StitchOverlap struct {
+	Upper int `json:"upper"` // Image index
+	Lower int `json:"lower"` // Image index
+	Lines int `json:"lines"` // Overlapping lines removed from the merged text
+
+	upperStart int // Line of the upper photo where the overlap starts
+	lowerStart int // Line of the lower photo where the overlap starts
+}
+
+// ReceiptStitch is the result of stitching - surfaced as document_analysis.stitching
+type ReceiptStitch struct {
+	Stitched bool            `json:"stitched"`
+	Order    []int           `json:"order,omitempty"` // Image indices top to bottom
+	Overlaps []StitchOverlap `json:"overlaps,omitempty"`
+	Reason   string          `json:"reason"`
+	Text     string          `json:"-"` // Merged OCR text (Stitched only)
+}
+
+// stitchLine is a non-empty OCR line with its comparison key
+type stitchLine struct {
+	text string
+	key  string // Lower case, single spaces
+}
+
+// stitchLines splits OCR text into non-empty lines
+func stitchLines(text string) []stitchLine {
+	lines := []stitchLine{}
+	for _, line := range strings.Split(text, "\n") {
+		key := strings.ToLower(strings.Join(strings.Fields(line), " "))
+		if key == "" {
+			continue
+		}
+		lines = append(lines, stitchLine{text: strings.TrimSpace(line), key: key})
+	}
+	return lines
+}
+
+// stitchDigits keeps the digits of a line (quantities / prices / codes must match exactly)
+func stitchDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// sameStitchLine compares two OCR lines allowing a few misread letters (digits must be identical)
+func sameStitchLine(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if stitchDigits(a) != stitchDigits(b) {
+		return false // Item lines of a receipt differ mostly in their numbers
+	}
+	longer := len(a)
+	if len(b) > longer {
+		longer = len(b)
+	}
+	if longer < stitchMinAnchorLength {
+		return false // Short lines must match exactly
+	}
+	return 1-float64(levenshteinDistance(a, b))/float64(longer) >= stitchLineSimilarity
+}
+
+// findStitchOverlap finds the longest run of lines at the end of upper repeated at the start of lower
+// The run must reach the last lines of upper and begin in the first lines of lower (edge lines may be cut)
+func findStitchOverlap(upper, lower []stitchLine) (upperStart, lowerStart, length int, ok bool) {
+	firstUpper := len(upper) - stitchMaxSearchLines
+	if firstUpper < 0 {
+		firstUpper = 0
+	}
+	for j := 0; j <= stitchEdgeLines && j < len(lower); j++ {
+		for i := firstUpper; i < len(upper); i++ {
+			run, anchored := 0, false
+			for i+run < len(upper) && j+run < len(lower) && sameStitchLine(upper[i+run].key, lower[j+run].key) {
+				if len(upper[i+run].key) >= stitchMinAnchorLength {
+					anchored = true
+				}
+				run++
+			}
+			if run < stitchMinOverlapLines || !anchored || len(upper)-(i+run) > stitchEdgeLines {
+				continue
+			}
+			if run > length {
+				upperStart, lowerStart, length, ok = i, j, run, true
+			}
+		}
+	}
+	return upperStart, lowerStart, length, ok
+}
+
+// StitchReceiptText orders overlapping photos of one receipt and merges their text
+// Every photo must link into a single chain - otherwise Stitched = false and the photos stay separate
+func StitchReceiptText(segments []StitchSegment) ReceiptStitch {
+	if len(segments) < 2 {
+		return ReceiptStitch{Reason: "single image"}
+	}
+	lines := make([][]stitchLine, len(segments))
+	for i, segment := range segments {
+		lines[i] = stitchLines(segment.Text)
+	}
+
+	// Step 1: Overlap of every ordered pair (upper → lower)
+	type candidate struct {
+		upper, lower int // Positions in segments
+		overlap      StitchOverlap
+	}
+	candidates := []candidate{}
+	for a := range segments {
+		for b := range segments {
+			if a == b {
+				continue
+			}
+			upperStart, lowerStart, length, ok := findStitchOverlap(lines[a], lines[b])
+			if !ok {
+				continue
+			}
+			candidates = append(candidates, candidate{upper: a, lower: b, overlap: StitchOverlap{
+				Upper:      segments[a].ImageIndex,
+				Lower:      segments[b].ImageIndex,
+				Lines:      length,
+				upperStart: upperStart,
+				lowerStart: lowerStart,
+			}})
+		}
+	}
+
+	// Step 2: Chain the strongest links (each photo has at most one photo above and one below, no cycles)
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].overlap.Lines > candidates[j].overlap.Lines })
+	next := map[int]candidate{}
+	hasPrevious := map[int]bool{}
+	for _, c := range candidates {
+		if _, taken := next[c.upper]; taken || hasPrevious[c.lower] {
+			continue
+		}
+		cycle := false
+		for at, ok := c.lower, true; ok; {
+			if at == c.upper {
+				cycle = true
+				break
+			}
+			var link candidate
+			link, ok = next[at]
+			at = link.lower
+		}
+		if cycle {
+			continue
+		}
+		next[c.upper] = c
+		hasPrevious[c.lower] = true
+	}
+	if len(next) != len(segments)-1 {
+		return ReceiptStitch{Reason: fmt.Sprintf("overlap found for %d of %d photo joins", len(next), len(segments)-1)}
+	}
+
+	// Step 3: Merge top to bottom - drop the cut lines at the top of each lower photo and the repeated lines
+	top := 0
+	for top < len(segments) && hasPrevious[top] {
+		top++
+	}
+	stitch := ReceiptStitch{Stitched: true}
+	var merged []string
+	start := 0
+	for at := top; ; {
+		stitch.Order = append(stitch.Order, segments[at].ImageIndex)
+		link, ok := next[at]
+		end := len(lines[at])
+		if ok {
+			end = link.overlap.upperStart
+		}
+		if end < start {
+			end = start
+		}
+		for _, line := range lines[at][start:end] {
+			merged = append(merged, line.text)
+		}
+		if !ok {
+			break
+		}
+		stitch.Overlaps = append(stitch.Overlaps, link.overlap)
+		start = link.overlap.lowerStart
+		at = link.lower
+	}
+	stitch.Text = strings.Join(merged, "\n")
+	stitch.Reason = fmt.Sprintf("%d photos joined by repeated lines", len(segments))
+	return stitch
+}
@@ -0,0 +1,40 @@
+// self_invoice.go - Guard against the shop's own tax invoice booked as a purchase
+//
+// AI อ่าน vendor_tax_id จากส่วนหัวเอกสาร (ผู้ออก) → ถ้าตรงกับเลขผู้เสียภาษีของร้าน (ShopProfile.Settings.TaxID)
+// แปลว่าร้านเป็นผู้ออกเอกสารเอง = เอกสารขาย ไม่ใช่เอกสารซื้อ
+// ร้านเป็นเจ้าหนี้ของตัวเองไม่ได้ → ล้าง creditor ออกจาก entry และให้ผู้ใช้ตรวจ (หรือปฏิเสธทั้งเอกสารตาม SELF_INVOICE_ACTION)
+// ต่างจาก DetectDocumentDirection ที่ดูจากข้อความ OCR ก่อน Phase 3 - ตัวนี้ตรวจผลของ AI หลัง Phase 3
+
+package processor
+
+import "fmt"
+
+// SelfInvoiceResult is returned by CheckSelfInvoice when the vendor is the shop itself
+type SelfInvoiceResult struct {
+	VendorTaxID     string `json:"vendor_tax_id"`
+	Direction       string `json:"direction"`                  // Always DirectionSale
+	CreditorRemoved string `json:"creditor_removed,omitempty"` // Creditor code the AI booked (removed from the entry)
+	Message         string `json:"message"`
+}
+
+// CheckSelfInvoice compares receipt.vendor_tax_id with the shop's tax ID (dashes/spaces ignored)
+// On match the creditor is removed from accountingEntry (modified in place). Returns nil when the vendor is not the shop
+func CheckSelfInvoice(receipt, accountingEntry map[string]interface{}, shopTaxID string) *SelfInvoiceResult {
+	shopTaxID = normalizeTaxID(shopTaxID)
+	vendorTaxID := normalizeTaxID(getStringFromInterface(receipt["vendor_tax_id"]))
+	if shopTaxID == "" || vendorTaxID != shopTaxID {
+		return nil
+	}
+
+	result := &SelfInvoiceResult{VendorTaxID: vendorTaxID, Direction: DirectionSale}
+	if code := getStringFromInterface(accountingEntry["creditor_code"]); code != "" {
+		result.CreditorRemoved = code
+		delete(accountingEntry, "creditor_code")
+		delete(accountingEntry, "creditor_name")
+	}
+	result.Message = fmt.Sprintf("เลขผู้เสียภาษีผู้ขาย (%s) เป็นของร้านเอง - เป็นเอกสารขายที่ร้านออก ไม่ใช่เอกสารซื้อ", vendorTaxID)
+	if result.CreditorRemoved != "" {
+		result.Message += fmt.Sprintf(" (ลบเจ้าหนี้ %s ออกแล้ว)", result.CreditorRemoved)
+	}
+	return result
+}
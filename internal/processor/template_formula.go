@@ -0,0 +1,467 @@
+// template_formula.go - Amounts of template lines computed from the extracted fields
+//
+// เดิม promptdescription เขียนสูตรเป็นข้อความ ("เงินสด = ยอดรวม - ภงด.53") ให้ AI คำนวณเอง → คำนวณผิด / ปัดเศษไม่ตรง
+// details[].formula = นิพจน์เหนือฟิลด์ที่อ่านจากเอกสาร (เช่น "total - withholding_tax", "subtotal * 0.03")
+// คำนวณใน Go หลัง Phase 3 แล้วเขียนทับยอดของรายการบัญชีนั้น (ยอดจาก AI เก็บไว้เทียบใน validation.template_formulas)
+//
+// รองรับ: ตัวเลข, + - * /, วงเล็บ, round(x[, decimals]), min(a, b, ...), max(a, b, ...), abs(x)
+// ตัวแปร: total, vat, subtotal (= total - vat), exempt_amount, withholding_tax, custom_fields.<name> (ฟิลด์ number ของร้าน)
+
+package processor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FormulaFields are the receipt fields a formula can use (custom_fields.<name> in addition)
+var FormulaFields = []string{"total", "vat", "subtotal", "exempt_amount", "withholding_tax"}
+
+const customFieldPrefix = "custom_fields."
+
+// Formula is a parsed details[].formula
+type Formula struct {
+	Source string
+	root   formulaNode
+}
+
+// formulaNode is one node of the parsed expression
+type formulaNode struct {
+	op       byte // 'n' number, 'v' variable, 'f' function call, '+', '-', '*', '/', '~' negation
+	value    float64
+	name     string // Variable or function name
+	children []formulaNode
+}
+
+// ParseFormula parses an expression and checks its variables and functions
+func ParseFormula(source string) (*Formula, error) {
+	p := &formulaParser{input: []rune(source)}
+	root, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", string(p.input[p.pos]), p.pos+1)
+	}
+	return &Formula{Source: source, root: root}, nil
+}
+
+// Variables returns the fields used by the formula
+func (f *Formula) Variables() []string {
+	var names []string
+	var walk func(node formulaNode)
+	walk = func(node formulaNode) {
+		if node.op == 'v' {
+			names = append(names, node.name)
+		}
+		for _, child := range node.children {
+			walk(child)
+		}
+	}
+	walk(f.root)
+	return names
+}
+
+// Evaluate computes the formula (missing lists the fields the document does not have - counted as 0)
+func (f *Formula) Evaluate(receipt map[string]interface{}) (result money.Amount, missing []string, err error) {
+	value, err := evaluateFormulaNode(f.root, receipt, &missing)
+	if err != nil {
+		return 0, missing, err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, missing, fmt.Errorf("result is not a number")
+	}
+	return money.FromBaht(value), missing, nil
+}
+
+func evaluateFormulaNode(node formulaNode, receipt map[string]interface{}, missing *[]string) (float64, error) {
+	switch node.op {
+	case 'n':
+		return node.value, nil
+	case 'v':
+		value, ok := formulaFieldValue(receipt, node.name)
+		if !ok {
+			*missing = append(*missing, node.name)
+		}
+		return value, nil
+	case '~':
+		value, err := evaluateFormulaNode(node.children[0], receipt, missing)
+		return -value, err
+	}
+
+	args := make([]float64, 0, len(node.children))
+	for _, child := range node.children {
+		value, err := evaluateFormulaNode(child, receipt, missing)
+		if err != nil {
+			return 0, err
+		}
+		args = append(args, value)
+	}
+	switch node.op {
+	case '+':
+		return args[0] + args[1], nil
+	case '-':
+		return args[0] - args[1], nil
+	case '*':
+		return args[0] * args[1], nil
+	case '/':
+		if args[1] == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return args[0] / args[1], nil
+	}
+
+	// Function call (argument counts checked by the parser)
+	switch node.name {
+	case "round":
+		decimals := 2.0
+		if len(args) == 2 {
+			decimals = args[1]
+		}
+		scale := math.Pow(10, decimals)
+		return math.Round(args[0]*scale) / scale, nil
+	case "abs":
+		return math.Abs(args[0]), nil
+	case "min", "max":
+		result := args[0]
+		for _, arg := range args[1:] {
+			if (node.name == "min" && arg < result) || (node.name == "max" && arg > result) {
+				result = arg
+			}
+		}
+		return result, nil
+	}
+	return 0, fmt.Errorf("unknown function %s", node.name)
+}
+
+// formulaFieldValue reads a variable from the receipt (false = not on the document or not a readable amount)
+func formulaFieldValue(receipt map[string]interface{}, name string) (float64, bool) {
+	if strings.HasPrefix(name, customFieldPrefix) {
+		customFields, _ := receipt["custom_fields"].(map[string]interface{})
+		value, ok := customFields[strings.TrimPrefix(name, customFieldPrefix)]
+		if !ok || value == nil {
+			return 0, false
+		}
+		return formulaAmount(value)
+	}
+	if name == "subtotal" {
+		if value, ok := receipt["subtotal"]; ok && value != nil {
+			return formulaAmount(value)
+		}
+		total, ok := receipt["total"]
+		if !ok || total == nil {
+			return 0, false
+		}
+		totalAmount, totalOK := money.Parse(total)
+		vat, vatOK := money.Parse(receipt["vat"])
+		return (totalAmount - vat).Baht(), totalOK && vatOK
+	}
+	value, ok := receipt[name]
+	if !ok || value == nil {
+		return 0, false
+	}
+	return formulaAmount(value)
+}
+
+// formulaAmount converts a receipt value to baht (false = not an amount, e.g. "12-15")
+func formulaAmount(value interface{}) (float64, bool) {
+	amount, ok := money.Parse(value)
+	return amount.Baht(), ok
+}
+
+// formulaFunctions - allowed functions and their argument counts (max -1 = any)
+var formulaFunctions = map[string][2]int{
+	"round": {1, 2},
+	"abs":   {1, 1},
+	"min":   {2, -1},
+	"max":   {2, -1},
+}
+
+// formulaParser is a recursive descent parser: expression = term {(+|-) term}, term = factor {(*|/) factor}
+type formulaParser struct {
+	input []rune
+	pos   int
+}
+
+func (p *formulaParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *formulaParser) parseExpression() (formulaNode, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return left, err
+	}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.input) || (p.input[p.pos] != '+' && p.input[p.pos] != '-') {
+			return left, nil
+		}
+		op := byte(p.input[p.pos])
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return left, err
+		}
+		left = formulaNode{op: op, children: []formulaNode{left, right}}
+	}
+}
+
+func (p *formulaParser) parseTerm() (formulaNode, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return left, err
+	}
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.input) || (p.input[p.pos] != '*' && p.input[p.pos] != '/') {
+			return left, nil
+		}
+		op := byte(p.input[p.pos])
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return left, err
+		}
+		left = formulaNode{op: op, children: []formulaNode{left, right}}
+	}
+}
+
+func (p *formulaParser) parseFactor() (formulaNode, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return formulaNode{}, fmt.Errorf("unexpected end of formula")
+	}
+	r := p.input[p.pos]
+	switch {
+	case r == '-':
+		p.pos++
+		operand, err := p.parseFactor()
+		return formulaNode{op: '~', children: []formulaNode{operand}}, err
+	case r == '(':
+		p.pos++
+		node, err := p.parseExpression()
+		if err != nil {
+			return node, err
+		}
+		p.skipSpaces()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return node, fmt.Errorf("missing ) at position %d", p.pos+1)
+		}
+		p.pos++
+		return node, nil
+	case unicode.IsDigit(r) || r == '.':
+		start := p.pos
+		for p.pos < len(p.input) && (unicode.IsDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		value, err := strconv.ParseFloat(string(p.input[start:p.pos]), 64)
+		if err != nil {
+			return formulaNode{}, fmt.Errorf("invalid number %q", string(p.input[start:p.pos]))
+		}
+		return formulaNode{op: 'n', value: value}, nil
+	case unicode.IsLetter(r) || r == '_':
+		start := p.pos
+		for p.pos < len(p.input) && (unicode.IsLetter(p.input[p.pos]) || unicode.IsDigit(p.input[p.pos]) || p.input[p.pos] == '_' || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		name := strings.ToLower(string(p.input[start:p.pos]))
+		p.skipSpaces()
+		if p.pos < len(p.input) && p.input[p.pos] == '(' {
+			return p.parseCall(name)
+		}
+		if !isFormulaField(name) {
+			return formulaNode{}, fmt.Errorf("unknown field %q (allowed: %s, custom_fields.<name>)", name, strings.Join(FormulaFields, ", "))
+		}
+		return formulaNode{op: 'v', name: name}, nil
+	}
+	return formulaNode{}, fmt.Errorf("unexpected %q at position %d", string(r), p.pos+1)
+}
+
+// parseCall parses the arguments of name(...) - the position is on "("
+func (p *formulaParser) parseCall(name string) (formulaNode, error) {
+	arity, ok := formulaFunctions[name]
+	if !ok {
+		return formulaNode{}, fmt.Errorf("unknown function %q (allowed: round, abs, min, max)", name)
+	}
+	p.pos++ // (
+	node := formulaNode{op: 'f', name: name}
+	for {
+		arg, err := p.parseExpression()
+		if err != nil {
+			return node, err
+		}
+		node.children = append(node.children, arg)
+		p.skipSpaces()
+		if p.pos >= len(p.input) {
+			return node, fmt.Errorf("missing ) after %s(", name)
+		}
+		if p.input[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		if p.input[p.pos] != ')' {
+			return node, fmt.Errorf("unexpected %q in %s()", string(p.input[p.pos]), name)
+		}
+		p.pos++
+		break
+	}
+	if len(node.children) < arity[0] || (arity[1] >= 0 && len(node.children) > arity[1]) {
+		return node, fmt.Errorf("%s() takes %d-%d arguments", name, arity[0], arity[1])
+	}
+	return node, nil
+}
+
+// isFormulaField reports whether name is a field a formula can use
+func isFormulaField(name string) bool {
+	if strings.HasPrefix(name, customFieldPrefix) {
+		return len(name) > len(customFieldPrefix)
+	}
+	for _, field := range FormulaFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// TemplateFormulaLine is one template line whose amount was computed
+type TemplateFormulaLine struct {
+	DetailIndex   int          `json:"detail_index"`
+	AccountCode   string       `json:"account_code"`
+	Side          string       `json:"side"`
+	Formula       string       `json:"formula"`
+	AIAmount      money.Amount `json:"ai_amount"` // Amount the AI put on the line (0 = line was missing)
+	Amount        money.Amount `json:"amount"`
+	Changed       bool         `json:"changed"`
+	Added         bool         `json:"added,omitempty"`          // The AI left the line out - added by the formula
+	MissingFields []string     `json:"missing_fields,omitempty"` // Fields not on the document (counted as 0)
+	Error         string       `json:"error,omitempty"`          // Formula could not be applied - AI amount kept
+}
+
+// TemplateFormulaResult is surfaced as validation.template_formulas
+type TemplateFormulaResult struct {
+	Lines []TemplateFormulaLine `json:"lines"`
+}
+
+// RequiresReview reports whether a formula failed or used a field the document does not have
+func (r TemplateFormulaResult) RequiresReview() bool {
+	for _, line := range r.Lines {
+		if line.Error != "" || len(line.MissingFields) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// HasTemplateFormula reports whether any detail of the template has a formula
+func HasTemplateFormula(template bson.M) bool {
+	details, _ := templateDetails(template)
+	for _, detail := range details {
+		if strings.TrimSpace(getStringFromInterface(detail["formula"])) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyTemplateFormulas sets the amounts of the template lines that have details[].formula (entry modified in place)
+// Side = details[].side, else the side of the AI's line; returns nil when the template has no formula
+func ApplyTemplateFormulas(accountingEntry, receipt map[string]interface{}, template bson.M) *TemplateFormulaResult {
+	details, ok := templateDetails(template)
+	if !ok || accountingEntry == nil {
+		return nil
+	}
+	entriesRaw, _ := accountingEntry["entries"].([]interface{})
+	used := map[int]bool{}
+	var result TemplateFormulaResult
+
+	for i, detail := range details {
+		source := strings.TrimSpace(getStringFromInterface(detail["formula"]))
+		if source == "" {
+			continue
+		}
+		line := TemplateFormulaLine{
+			DetailIndex: i,
+			AccountCode: strings.TrimSpace(getStringFromInterface(detail["accountcode"])),
+			Formula:     source,
+		}
+
+		// Step 1: Entry line of the account (first one not taken by an earlier formula)
+		var target map[string]interface{}
+		for j, e := range entriesRaw {
+			if entry, ok := e.(map[string]interface{}); ok && !used[j] && getStringFromInterface(entry["account_code"]) == line.AccountCode {
+				target = entry
+				used[j] = true
+				break
+			}
+		}
+		line.Side = templateDetailSide(detail)
+		if line.Side == "" && target != nil {
+			line.Side = lineSide(target)
+		}
+		if target != nil {
+			line.AIAmount, _ = money.Parse(target[line.Side])
+		}
+
+		// Step 2: Evaluate - errors keep the AI amount
+		formula, err := ParseFormula(source)
+		var amount money.Amount
+		if err == nil {
+			amount, line.MissingFields, err = formula.Evaluate(receipt)
+		}
+		switch {
+		case err != nil:
+			line.Error = err.Error()
+		case line.Side == "" || line.Side == "invalid":
+			line.Error = "details[].side ไม่ได้ระบุ และ AI ไม่ได้ลงบัญชีนี้ - ไม่ทราบฝั่ง debit/credit"
+		case amount < 0:
+			line.Error = fmt.Sprintf("ผลลัพธ์ติดลบ (%s)", amount)
+		}
+		if line.Error != "" {
+			line.Amount = line.AIAmount
+			result.Lines = append(result.Lines, line)
+			continue
+		}
+
+		// Step 3: Write the amount (line added when the AI left it out and the amount is not 0)
+		line.Amount = amount
+		line.Changed = amount != line.AIAmount
+		if target == nil && amount > 0 {
+			target = map[string]interface{}{
+				"account_code":     line.AccountCode,
+				"account_name":     getStringFromInterface(detail["detail"]),
+				"debit":            0.0,
+				"credit":           0.0,
+				"description":      getStringFromInterface(detail["detail"]),
+				"selection_reason": "template formula: " + source,
+				"side_reason":      "template formula",
+			}
+			entriesRaw = append(entriesRaw, target)
+			line.Added = true
+		}
+		if target != nil {
+			other := "credit"
+			if line.Side == "credit" {
+				other = "debit"
+			}
+			target[line.Side] = amount.Baht()
+			target[other] = 0.0
+		}
+		result.Lines = append(result.Lines, line)
+	}
+	if len(result.Lines) == 0 {
+		return nil
+	}
+	accountingEntry["entries"] = entriesRaw
+	return &result
+}
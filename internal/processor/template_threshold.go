@@ -0,0 +1,141 @@
+// template_threshold.go - Recommend TEMPLATE_CONFIDENCE_THRESHOLD from labeled template decisions
+//
+// ทุกเอกสารที่ approve แล้วบอกได้ว่า template ที่ดีที่สุด "ถูก" หรือไม่ (บัญชีใน voucher ที่อนุมัติ = บัญชีของ template)
+//   - ใช้ template แต่ไม่ถูก = false positive (ลงบัญชีผิด ต้องแก้)
+//   - ไม่ใช้ template (คะแนนต่ำกว่า threshold) แต่ template ถูก = false negative (เสีย token full mode โดยไม่จำเป็น)
+// ลองทุก threshold ที่เป็นไปได้แล้วเลือกค่าที่ FP × น้ำหนัก + FN ต่ำสุด (เท่ากัน → threshold สูงกว่า ปลอดภัยกว่า)
+
+package processor
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Minimum labeled documents before a threshold is recommended
+const MinThresholdSamples = 20
+
+// ThresholdSample is one approved document: the best template's confidence and whether it was right
+type ThresholdSample struct {
+	Confidence      float64
+	TemplateCorrect bool
+}
+
+// ThresholdCandidate is the outcome of one threshold on the samples
+type ThresholdCandidate struct {
+	Threshold      float64 `json:"threshold"`
+	TruePositives  int     `json:"true_positives"`  // Template used and correct
+	FalsePositives int     `json:"false_positives"` // Template used but wrong
+	FalseNegatives int     `json:"false_negatives"` // Full mode although the template was correct
+	TrueNegatives  int     `json:"true_negatives"`  // Full mode and the template was wrong
+	TemplateRate   float64 `json:"template_rate"`   // Documents in template-only mode (%)
+	Cost           float64 `json:"cost"`            // false_positives × fp_weight + false_negatives
+}
+
+// ThresholdRecommendation is the best threshold for a set of samples
+type ThresholdRecommendation struct {
+	Samples     int                  `json:"samples"`
+	Enough      bool                 `json:"enough"` // samples ≥ MinThresholdSamples
+	Current     ThresholdCandidate   `json:"current"`
+	Recommended *ThresholdCandidate  `json:"recommended,omitempty"` // nil = not enough samples
+	Candidates  []ThresholdCandidate `json:"candidates"`
+}
+
+// RecommendTemplateThreshold evaluates every threshold that changes a decision (plus the current one)
+// fpWeight = how much worse a wrong template is than a missed one (≤ 0 → 1)
+func RecommendTemplateThreshold(samples []ThresholdSample, current, fpWeight float64) ThresholdRecommendation {
+	if fpWeight <= 0 {
+		fpWeight = 1
+	}
+	// Thresholds: each sample confidence (template used from that score up), current, and 100.01 (never use templates)
+	seen := map[float64]bool{}
+	thresholds := []float64{}
+	add := func(threshold float64) {
+		threshold = math.Round(threshold*100) / 100
+		if !seen[threshold] {
+			seen[threshold] = true
+			thresholds = append(thresholds, threshold)
+		}
+	}
+	add(current)
+	add(100.01)
+	for _, sample := range samples {
+		add(sample.Confidence)
+	}
+	sort.Float64s(thresholds)
+
+	result := ThresholdRecommendation{Samples: len(samples), Enough: len(samples) >= MinThresholdSamples, Candidates: []ThresholdCandidate{}}
+	var best *ThresholdCandidate
+	for _, threshold := range thresholds {
+		candidate := evaluateThreshold(samples, threshold, fpWeight)
+		result.Candidates = append(result.Candidates, candidate)
+		if threshold == math.Round(current*100)/100 {
+			result.Current = candidate
+		}
+		// Ties → higher threshold (thresholds ascend)
+		if best == nil || candidate.Cost <= best.Cost {
+			c := candidate
+			best = &c
+		}
+	}
+	if result.Enough {
+		result.Recommended = best
+	}
+	return result
+}
+
+// evaluateThreshold counts the decisions a threshold would have made
+func evaluateThreshold(samples []ThresholdSample, threshold, fpWeight float64) ThresholdCandidate {
+	candidate := ThresholdCandidate{Threshold: threshold}
+	for _, sample := range samples {
+		used := sample.Confidence >= threshold
+		switch {
+		case used && sample.TemplateCorrect:
+			candidate.TruePositives++
+		case used:
+			candidate.FalsePositives++
+		case sample.TemplateCorrect:
+			candidate.FalseNegatives++
+		default:
+			candidate.TrueNegatives++
+		}
+	}
+	if len(samples) > 0 {
+		candidate.TemplateRate = math.Round(float64(candidate.TruePositives+candidate.FalsePositives)/float64(len(samples))*1000) / 10
+	}
+	candidate.Cost = float64(candidate.FalsePositives)*fpWeight + float64(candidate.FalseNegatives)
+	return candidate
+}
+
+// TemplateFitsAccounts reports whether the approved accounts are exactly the accounts of the template
+// (sides and amounts are ignored - a wrong amount is not a wrong template)
+func TemplateFitsAccounts(template bson.M, accountCodes []string) bool {
+	details, ok := templateDetails(template)
+	if !ok || len(details) == 0 {
+		return false
+	}
+	templateCodes := map[string]bool{}
+	for _, detail := range details {
+		if code := strings.TrimSpace(getStringFromInterface(detail["accountcode"])); code != "" {
+			templateCodes[code] = true
+		}
+	}
+	approvedCodes := map[string]bool{}
+	for _, code := range accountCodes {
+		if code = strings.TrimSpace(code); code != "" {
+			approvedCodes[code] = true
+		}
+	}
+	if len(templateCodes) != len(approvedCodes) {
+		return false
+	}
+	for code := range approvedCodes {
+		if !templateCodes[code] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,197 @@
+// template_validator.go - Validate documentFormate template details against the shop's master data
+//
+// ตรวจก่อนบันทึก / ทดสอบ template: รหัสบัญชีต้องมีในผังบัญชี (และเป็นบัญชีย่อย level 3-5),
+// ฝั่ง debit/credit ต้องลงบัญชีคู่ได้ และรหัสสมุดรายวัน (ถ้าระบุ) ต้องมีอยู่จริง
+
+package processor
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TemplateIssue is one problem found in a template (DetailIndex = -1 for template-level issues)
+type TemplateIssue struct {
+	DetailIndex int                 `json:"detail_index"`
+	AccountCode string              `json:"account_code,omitempty"`
+	Issue       string              `json:"issue"` // missing_details, missing_account_code, unknown_account_code, header_account, duplicate_account_code, invalid_side, invalid_formula, missing_debit_side, missing_credit_side, single_account, unknown_journal_book
+	Message     string              `json:"message"`
+	Suggestions []AccountSuggestion `json:"suggestions,omitempty"` // Replacement accounts for unknown / header accounts
+}
+
+// TemplateValidationResult lists errors (template cannot be used) and warnings (usable but suspicious)
+type TemplateValidationResult struct {
+	Valid    bool            `json:"valid"`
+	Errors   []TemplateIssue `json:"errors"`
+	Warnings []TemplateIssue `json:"warnings"`
+}
+
+// templateDetails returns details[] of a template as maps (bson.A from MongoDB or []interface{} from JSON)
+func templateDetails(template bson.M) ([]map[string]interface{}, bool) {
+	var raw []interface{}
+	switch details := template["details"].(type) {
+	case bson.A:
+		raw = details
+	case []interface{}:
+		raw = details
+	default:
+		return nil, false
+	}
+	details := make([]map[string]interface{}, 0, len(raw))
+	for _, d := range raw {
+		switch detail := d.(type) {
+		case bson.M:
+			details = append(details, detail)
+		case map[string]interface{}:
+			details = append(details, detail)
+		default:
+			details = append(details, map[string]interface{}{})
+		}
+	}
+	return details, true
+}
+
+// templateDetailSide normalizes the optional side of a detail ("" = not set, "invalid" = unknown value)
+func templateDetailSide(detail map[string]interface{}) string {
+	side := strings.ToLower(strings.TrimSpace(getStringFromInterface(detail["side"])))
+	switch side {
+	case "":
+		return ""
+	case "debit", "dr":
+		return "debit"
+	case "credit", "cr":
+		return "credit"
+	}
+	return "invalid"
+}
+
+// ValidateTemplate checks every detail of a template against the chart of accounts and journal books
+// details[].side ("debit"/"credit", optional) - when any detail sets it, both sides must be present
+// journalbookcode (optional) must exist in journalBooks
+func ValidateTemplate(template bson.M, accounts, journalBooks []bson.M) TemplateValidationResult {
+	result := TemplateValidationResult{Errors: []TemplateIssue{}, Warnings: []TemplateIssue{}}
+
+	chart := map[string]bson.M{}
+	for _, acc := range accounts {
+		chart[getStringFromInterface(acc["accountcode"])] = acc
+	}
+
+	details, ok := templateDetails(template)
+	if !ok || len(details) == 0 {
+		result.Errors = append(result.Errors, TemplateIssue{
+			DetailIndex: -1,
+			Issue:       "missing_details",
+			Message:     "template ต้องมี details อย่างน้อย 1 บัญชี",
+		})
+		return result
+	}
+
+	seen := map[string]int{}
+	sides := map[string]int{}
+	for i, detail := range details {
+		code := strings.TrimSpace(getStringFromInterface(detail["accountcode"]))
+		name := getStringFromInterface(detail["detail"])
+		if code == "" {
+			result.Errors = append(result.Errors, TemplateIssue{
+				DetailIndex: i,
+				Issue:       "missing_account_code",
+				Message:     fmt.Sprintf("details[%d] ไม่มี accountcode", i),
+			})
+			continue
+		}
+
+		acc, known := chart[code]
+		switch {
+		case !known:
+			result.Errors = append(result.Errors, TemplateIssue{
+				DetailIndex: i,
+				AccountCode: code,
+				Issue:       "unknown_account_code",
+				Message:     fmt.Sprintf("ไม่พบรหัสบัญชี %s ในผังบัญชีของร้าน", code),
+				Suggestions: SuggestAccounts(name, accounts, 3),
+			})
+		case !isPostingAccount(acc):
+			result.Errors = append(result.Errors, TemplateIssue{
+				DetailIndex: i,
+				AccountCode: code,
+				Issue:       "header_account",
+				Message:     fmt.Sprintf("บัญชี %s เป็นบัญชีหมวด (level 1-2) ลงรายการไม่ได้", code),
+				Suggestions: SuggestAccounts(name, accounts, 3),
+			})
+		}
+
+		if first, duplicate := seen[code]; duplicate {
+			result.Warnings = append(result.Warnings, TemplateIssue{
+				DetailIndex: i,
+				AccountCode: code,
+				Issue:       "duplicate_account_code",
+				Message:     fmt.Sprintf("บัญชี %s ซ้ำกับ details[%d]", code, first),
+			})
+		} else {
+			seen[code] = i
+		}
+
+		if source := strings.TrimSpace(getStringFromInterface(detail["formula"])); source != "" {
+			if _, err := ParseFormula(source); err != nil {
+				result.Errors = append(result.Errors, TemplateIssue{
+					DetailIndex: i,
+					AccountCode: code,
+					Issue:       "invalid_formula",
+					Message:     fmt.Sprintf("details[%d].formula ไม่ถูกต้อง: %v", i, err),
+				})
+			}
+		}
+
+		switch side := templateDetailSide(detail); side {
+		case "":
+		case "invalid":
+			result.Errors = append(result.Errors, TemplateIssue{
+				DetailIndex: i,
+				AccountCode: code,
+				Issue:       "invalid_side",
+				Message:     fmt.Sprintf("details[%d].side ต้องเป็น debit หรือ credit", i),
+			})
+		default:
+			sides[side]++
+		}
+	}
+
+	// Double entry: at least two accounts, and both sides when sides are given
+	if len(seen) < 2 {
+		result.Errors = append(result.Errors, TemplateIssue{
+			DetailIndex: -1,
+			Issue:       "single_account",
+			Message:     "template ต้องมีอย่างน้อย 2 บัญชี (ฝั่ง debit และ credit)",
+		})
+	}
+	if sides["debit"]+sides["credit"] > 0 {
+		if sides["debit"] == 0 {
+			result.Errors = append(result.Errors, TemplateIssue{DetailIndex: -1, Issue: "missing_debit_side", Message: "ไม่มีบัญชีฝั่ง debit"})
+		}
+		if sides["credit"] == 0 {
+			result.Errors = append(result.Errors, TemplateIssue{DetailIndex: -1, Issue: "missing_credit_side", Message: "ไม่มีบัญชีฝั่ง credit"})
+		}
+	}
+
+	if bookCode := strings.TrimSpace(getStringFromInterface(template["journalbookcode"])); bookCode != "" {
+		found := false
+		for _, jb := range journalBooks {
+			if getStringFromInterface(jb["code"]) == bookCode {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result.Errors = append(result.Errors, TemplateIssue{
+				DetailIndex: -1,
+				Issue:       "unknown_journal_book",
+				Message:     fmt.Sprintf("ไม่พบสมุดรายวัน %s", bookCode),
+			})
+		}
+	}
+
+	result.Valid = len(result.Errors) == 0
+	return result
+}
@@ -0,0 +1,263 @@
+// thai_text.go - Thai-aware normalization, word segmentation and token-set similarity for name matching
+//
+// ภาษาไทยไม่เว้นวรรคระหว่างคำ → Levenshtein บน string ทั้งก้อนพลาดชื่อที่ชัดเจน
+// (เช่น "แม็คโครสยาม" กับ "สยามแม็คโคร", หรือชื่อที่มีคำต่อท้ายเพิ่ม "ป้าแดงการค้า สาขา 2")
+// - normalizeJuristicName: ตัดคำบอกประเภทนิติบุคคล (บจก./บริษัท…จำกัด, หจก./ห้างหุ้นส่วนจำกัด, Co., Ltd.) และเลขสาขา
+// - segmentThaiWords: ตัดคำแบบ longest matching กับคำที่พบบ่อยในชื่อกิจการ ส่วนที่ไม่รู้จักรวมเป็นคำเดียว (ตัดได้เฉพาะขอบ cluster)
+// - tokenSetSimilarity: token-set ratio (ไม่สนลำดับคำ, คำเกินในฝั่งหนึ่งลดคะแนนตามสัดส่วน) เทียบกับ ratio ทั้งก้อน เลือกค่าที่สูงกว่า
+
+package processor
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// juristicNamePatterns - juristic person prefixes/suffixes and branch markers removed before comparing names
+// Abbreviations accept dots and spaces in any position (บ.จ.ก. / บจก / บ จ ก)
+var juristicNamePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`ห้างหุ้นส่วนจำกัด|ห้างหุ้นส่วนสามัญ(นิติบุคคล)?|ห้างหุ้นส่วน|ห\.?\s*จ\.?\s*ก\.?|ห\.?\s*ส\.?\s*น\.?`),
+	regexp.MustCompile(`บริษัทมหาชนจำกัด|บริษัท|บ\.?\s*จ\.?\s*ก\.?|บ\.?\s*ม\.?\s*จ\.?`),
+	regexp.MustCompile(`\(?\s*มหาชน\s*\)?|จำกัด`),
+	regexp.MustCompile(`\(?\s*สำนักงานใหญ่\s*\)?|สาขา(ที่)?\s*\d+|\b(head\s*office|branch(\s*no\.?)?\s*\d+)\b`),
+	regexp.MustCompile(`\b(public\s+)?(company|co)\.?,?\s*(limited|ltd)\.?|\b(limited|ltd|company|corporation|corp|inc|plc)\b\.?|\bpart\.?,?\s*ltd\.?`),
+}
+
+// normalizeJuristicName lowercases the name, fixes common Thai typing variants and removes juristic markers
+func normalizeJuristicName(name string) string {
+	name = strings.ToLower(name)
+	// Typing variants: นิคหิต + สระอา → สระอำ (จํากัด), เ + เ → แ
+	name = strings.ReplaceAll(name, "ํา", "ำ")
+	name = strings.ReplaceAll(name, "เเ", "แ")
+	for _, pattern := range juristicNamePatterns {
+		name = pattern.ReplaceAllString(name, " ")
+	}
+	return name
+}
+
+// thaiBusinessWords - dictionary of segmentThaiWords (words common in Thai trade names)
+var thaiBusinessWords = []string{
+	"ร้าน", "การค้า", "ค้า", "พาณิชย์", "ขายส่ง", "ขายปลีก", "จำหน่าย", "ตัวแทน", "บริการ", "ขนส่ง",
+	"วัสดุ", "ก่อสร้าง", "อุปกรณ์", "ไฟฟ้า", "ประปา", "ฮาร์ดแวร์", "เหล็ก", "ไม้", "เคมี", "ภัณฑ์",
+	"น้ำมัน", "เชื้อเพลิง", "ปิโตรเลียม", "แก๊ส", "อาหาร", "เครื่องดื่ม", "เครื่องเขียน", "เครื่อง", "การพิมพ์", "เภสัช",
+	"ยานยนต์", "รถยนต์", "อะไหล่", "การเกษตร", "เกษตร", "ฟาร์ม", "โรงงาน", "อุตสาหกรรม", "ผลิตภัณฑ์", "โภคภัณฑ์",
+	"โรงพยาบาล", "คลินิก", "สำนักงาน", "ศูนย์", "โรงแรม", "รีสอร์ท", "คอมพิวเตอร์", "อิเล็กทรอนิกส์", "เทคโนโลยี", "ประกันภัย",
+	"เทรดดิ้ง", "เอ็นเตอร์ไพรส์", "อินเตอร์เนชั่นแนล", "อินเตอร์", "กรุ๊ป", "ซัพพลาย", "เซอร์วิส", "เอ็นจิเนียริ่ง", "โฮลดิ้ง", "คอร์ปอเรชั่น",
+	"โฮม", "มาร์ท", "ซุปเปอร์", "มาร์เก็ต", "พลาซ่า", "เซ็นเตอร์", "โปรดักส์", "ดีเวลลอปเมนท์", "แอนด์", "โซลูชั่น",
+	"ประเทศไทย", "ไทย", "สยาม", "กรุงเทพ", "มหานคร", "ทอง", "เจริญ", "รุ่งเรือง", "พัฒนา", "มั่นคง",
+	"ทรัพย์", "ยนต์", "กิจ", "ชัย", "ศรี", "สุข", "มงคล", "โชค", "ดี", "ใหม่",
+	"แม็คโคร", "เซเว่น", "อีเลฟเว่น", "โลตัส", "บิ๊กซี", "ปตท", "บางจาก", "เชลล์", "คาลเท็กซ์", "ซีพี",
+}
+
+var (
+	thaiWordSet      = map[string]bool{}
+	thaiWordMaxRunes = 0
+)
+
+func init() {
+	for _, word := range thaiBusinessWords {
+		thaiWordSet[word] = true
+		if n := len([]rune(word)); n > thaiWordMaxRunes {
+			thaiWordMaxRunes = n
+		}
+	}
+}
+
+// isThaiRune reports whether r is in the Thai block
+func isThaiRune(r rune) bool {
+	return r >= 0x0E00 && r <= 0x0E7F
+}
+
+// isThaiLeadingVowel - เ แ โ ใ ไ are written before the consonant they belong to
+func isThaiLeadingVowel(r rune) bool {
+	return r >= 'เ' && r <= 'ไ'
+}
+
+// isThaiFollowing - vowels/tone marks that cannot start a cluster (ะ า ำ, above/below vowels, tone marks, ๅ)
+func isThaiFollowing(r rune) bool {
+	return r == 'ะ' || r == 'า' || r == 'ำ' || r == 'ๅ' || unicode.Is(unicode.Mn, r)
+}
+
+// thaiClusterBoundary reports whether a word may start/end at position i of runes
+func thaiClusterBoundary(runes []rune, i int) bool {
+	if i <= 0 || i >= len(runes) {
+		return true
+	}
+	return !isThaiFollowing(runes[i]) && !isThaiLeadingVowel(runes[i-1])
+}
+
+// segmentThaiWords splits text into words: spaces and script changes first, then longest dictionary
+// matching inside Thai runs. Unknown Thai text between dictionary words is kept as one word
+func segmentThaiWords(text string) []string {
+	var words []string
+	for _, field := range strings.Fields(text) {
+		runes := []rune(field)
+		start := 0
+		for start < len(runes) {
+			end := start + 1
+			for end < len(runes) && isThaiRune(runes[end]) == isThaiRune(runes[start]) {
+				end++
+			}
+			if isThaiRune(runes[start]) {
+				words = append(words, segmentThaiRun(runes[start:end])...)
+			} else {
+				words = append(words, string(runes[start:end]))
+			}
+			start = end
+		}
+	}
+	return words
+}
+
+// segmentThaiRun segments one run of Thai characters (no spaces)
+func segmentThaiRun(runes []rune) []string {
+	var words []string
+	unknownStart := -1
+	flushUnknown := func(end int) {
+		if unknownStart >= 0 {
+			words = append(words, string(runes[unknownStart:end]))
+			unknownStart = -1
+		}
+	}
+
+	for i := 0; i < len(runes); {
+		if length := longestThaiWord(runes, i); length > 0 {
+			flushUnknown(i)
+			words = append(words, string(runes[i:i+length]))
+			i += length
+			continue
+		}
+		if unknownStart < 0 {
+			unknownStart = i
+		}
+		i++
+	}
+	flushUnknown(len(runes))
+	return words
+}
+
+// longestThaiWord returns the rune length of the longest dictionary word at position i (0 = none)
+// Words must start and end on cluster boundaries ("สุข" is not cut out of "สุขา")
+func longestThaiWord(runes []rune, i int) int {
+	if !thaiClusterBoundary(runes, i) {
+		return 0
+	}
+	for length := minInt(thaiWordMaxRunes, len(runes)-i); length >= 2; length-- {
+		if thaiWordSet[string(runes[i:i+length])] && thaiClusterBoundary(runes, i+length) {
+			return length
+		}
+	}
+	return 0
+}
+
+// tokenSetSimilarity scores two normalized names 0-100, ignoring word order
+// Token-set ratio: shared words vs shared words + the remaining words of each side. A name fully contained in the other
+// scores 100 minus a penalty for the uncovered share (up to 20) so "สยาม" ranks below "สยามแม็คโคร" for "สยามแม็คโคร สาขา 2"
+// The plain ratio of both names without spaces is the floor (segmentation can only help)
+func tokenSetSimilarity(name1, name2 string) float64 {
+	plain := runeRatio(strings.ReplaceAll(name1, " ", ""), strings.ReplaceAll(name2, " ", ""))
+	words1, words2 := uniqueSortedWords(segmentThaiWords(name1)), uniqueSortedWords(segmentThaiWords(name2))
+	if len(words1) == 0 || len(words2) == 0 {
+		return plain
+	}
+
+	var shared, only1, only2 []string
+	in2 := map[string]bool{}
+	for _, word := range words2 {
+		in2[word] = true
+	}
+	in1 := map[string]bool{}
+	for _, word := range words1 {
+		in1[word] = true
+		if in2[word] {
+			shared = append(shared, word)
+		} else {
+			only1 = append(only1, word)
+		}
+	}
+	for _, word := range words2 {
+		if !in1[word] {
+			only2 = append(only2, word)
+		}
+	}
+
+	t0 := strings.Join(shared, "")
+	t1 := t0 + strings.Join(only1, "")
+	t2 := t0 + strings.Join(only2, "")
+	score := runeRatio(t1, t2)
+	if t0 != "" {
+		// Coverage of the shared words over the longer side
+		longer := len([]rune(t1))
+		if n := len([]rune(t2)); n > longer {
+			longer = n
+		}
+		penalty := (1 - float64(len([]rune(t0)))/float64(longer)) * 20
+		for _, subset := range []float64{runeRatio(t0, t1), runeRatio(t0, t2)} {
+			if subset-penalty > score {
+				score = subset - penalty
+			}
+		}
+	}
+	if plain > score {
+		return plain
+	}
+	return score
+}
+
+// uniqueSortedWords removes duplicates and sorts the words
+func uniqueSortedWords(words []string) []string {
+	seen := map[string]bool{}
+	unique := make([]string, 0, len(words))
+	for _, word := range words {
+		if word != "" && !seen[word] {
+			seen[word] = true
+			unique = append(unique, word)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// runeRatio is 100 * (1 - edit distance / longer length), counted in characters (not UTF-8 bytes)
+func runeRatio(s1, s2 string) float64 {
+	r1, r2 := []rune(s1), []rune(s2)
+	longer := len(r1)
+	if len(r2) > longer {
+		longer = len(r2)
+	}
+	if longer == 0 {
+		return 0
+	}
+	return (1 - float64(runeLevenshtein(r1, r2))/float64(longer)) * 100
+}
+
+// runeLevenshtein is the edit distance between two rune slices (two-row DP)
+func runeLevenshtein(r1, r2 []rune) int {
+	previous := make([]int, len(r2)+1)
+	current := make([]int, len(r2)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(r1); i++ {
+		current[0] = i
+		for j := 1; j <= len(r2); j++ {
+			cost := 1
+			if r1[i-1] == r2[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(r2)]
+}
+
+// minInt returns the minimum of two integers
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,91 @@
+package processor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeJuristicName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "บจก.", in: "บจก. สยามแม็คโคร", want: "สยามแม็คโคร"},
+		{name: "บ.จ.ก. with dots", in: "บ.จ.ก.สยามแม็คโคร", want: "สยามแม็คโคร"},
+		{name: "บริษัท…จำกัด", in: "บริษัท สยามแม็คโคร จำกัด", want: "สยามแม็คโคร"},
+		{name: "บริษัท…จำกัด no spaces", in: "บริษัทสยามแม็คโครจำกัด", want: "สยามแม็คโคร"},
+		{name: "บริษัท…จำกัด (มหาชน)", in: "บริษัท สยามแม็คโคร จำกัด (มหาชน)", want: "สยามแม็คโคร"},
+		{name: "บมจ.", in: "บมจ. ปตท", want: "ปตท"},
+		{name: "บ.ม.จ.", in: "บ.ม.จ. ปตท", want: "ปตท"},
+		{name: "หจก.", in: "หจก.ป้าแดงการค้า", want: "ป้าแดงการค้า"},
+		{name: "ห้างหุ้นส่วนจำกัด", in: "ห้างหุ้นส่วนจำกัด ป้าแดงการค้า", want: "ป้าแดงการค้า"},
+		{name: "จํากัด typed with นิคหิต", in: "บริษัท เอบีซี จํากัด", want: "เอบีซี"},
+		{name: "สำนักงานใหญ่", in: "บริษัท เอบีซี จำกัด (สำนักงานใหญ่)", want: "เอบีซี"},
+		{name: "สาขา number", in: "ป้าแดงการค้า สาขา 2", want: "ป้าแดงการค้า"},
+		{name: "Co., Ltd.", in: "Siam Makro Co., Ltd.", want: "siam makro"},
+		{name: "Co.,Ltd no space", in: "Siam Makro Co.,Ltd", want: "siam makro"},
+		{name: "COMPANY LIMITED", in: "SIAM MAKRO COMPANY LIMITED", want: "siam makro"},
+		{name: "Public Company Limited", in: "Siam Makro Public Company Limited", want: "siam makro"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(strings.Fields(normalizeJuristicName(tt.in)), " ")
+			if got != tt.want {
+				t.Errorf("normalizeJuristicName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSegmentThaiWords(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{in: "สยามแม็คโคร", want: []string{"สยาม", "แม็คโคร"}},
+		{in: "แม็คโครสยาม", want: []string{"แม็คโคร", "สยาม"}},
+		{in: "ป้าแดงการค้า", want: []string{"ป้าแดง", "การค้า"}}, // Unknown text kept as one word
+		{in: "เจริญทรัพย์ฮาร์ดแวร์", want: []string{"เจริญ", "ทรัพย์", "ฮาร์ดแวร์"}},
+		{in: "ร้านสุขา", want: []string{"ร้าน", "สุขา"}}, // "สุข" ends before a following vowel
+		{in: "abcไทย", want: []string{"abc", "ไทย"}},
+		{in: "ไทยเจริญ 2", want: []string{"ไทย", "เจริญ", "2"}},
+		{in: "", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := segmentThaiWords(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("segmentThaiWords(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenSetSimilarity(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		min, max float64
+	}{
+		{name: "same", a: "สยามแม็คโคร", b: "สยามแม็คโคร", min: 100, max: 100},
+		{name: "Thai word order swapped", a: "สยามแม็คโคร", b: "แม็คโครสยาม", min: 100, max: 100},
+		{name: "English word order swapped", a: "siam makro", b: "makro siam", min: 100, max: 100},
+		{name: "extra word", a: "สยามแม็คโคร 2", b: "สยามแม็คโคร", min: 95, max: 99.9},
+		{name: "contained short name", a: "สยาม", b: "สยามแม็คโคร", min: 70, max: 95},
+		{name: "shared suffix only", a: "ป้าแดงการค้า", b: "ลุงดำการค้า", max: 69.9},
+		{name: "unrelated", a: "abc", b: "xyz", max: 0},
+		{name: "empty", a: "", b: "", max: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tokenSetSimilarity(tt.a, tt.b)
+			if got < tt.min || got > tt.max {
+				t.Errorf("tokenSetSimilarity(%q, %q) = %.2f, want %.1f-%.1f", tt.a, tt.b, got, tt.min, tt.max)
+			}
+			if reverse := tokenSetSimilarity(tt.b, tt.a); reverse != got {
+				t.Errorf("tokenSetSimilarity is not symmetric: %.2f vs %.2f", got, reverse)
+			}
+		})
+	}
+}
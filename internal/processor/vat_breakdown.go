@@ -0,0 +1,206 @@
+// vat_breakdown.go - Documents with several VAT rates or VAT-exempt items
+//
+// ใบกำกับบางใบมีทั้งรายการที่เสีย VAT และรายการยกเว้น VAT (เช่น ผักสด + สินค้าทั่วไป) หรือหลายอัตรา (7% + 0% ส่งออก)
+// AI อ่าน receipt.vat_breakdown[] (มูลค่าฐานภาษีแยกตามอัตรา) และ receipt.exempt_amount (มูลค่ายกเว้น VAT) จากเอกสาร
+// - ตรวจ VAT แยกตามอัตรา (CheckVATMath)
+// - แยกรายการรายได้/ค่าใช้จ่ายหลักเป็นฐานภาษีแต่ละอัตรา + ยกเว้น (SplitMixedVATEntries) → รายงานภาษีแยกมูลค่าได้
+
+package processor
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// VATBase is one rate of receipt.vat_breakdown
+type VATBase struct {
+	Rate float64      `json:"rate"` // Percent (0 = zero-rated)
+	Base money.Amount `json:"base"` // Value before VAT
+	VAT  money.Amount `json:"vat"`
+}
+
+// VATBreakdown is the VAT base of a document split by rate
+type VATBreakdown struct {
+	Bases  []VATBase    `json:"bases"`
+	Exempt money.Amount `json:"exempt,omitempty"` // Value of VAT-exempt items
+}
+
+// ReadVATBreakdown reads receipt.vat_breakdown / receipt.exempt_amount (bases sorted by rate, same rates merged)
+// Lines with an unreadable base or VAT are skipped
+func ReadVATBreakdown(receipt map[string]interface{}) VATBreakdown {
+	var breakdown VATBreakdown
+	byRate := map[float64]*VATBase{}
+	items, _ := receipt["vat_breakdown"].([]interface{})
+	for _, item := range items {
+		line, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		base, baseOK := money.Parse(line["base"])
+		vat, vatOK := money.Parse(line["vat"])
+		if !baseOK || !vatOK || base <= 0 {
+			continue
+		}
+		rate := getFloatFromInterface(line["rate"])
+		if existing, ok := byRate[rate]; ok {
+			existing.Base += base
+			existing.VAT += vat
+			continue
+		}
+		byRate[rate] = &VATBase{Rate: rate, Base: base, VAT: vat}
+	}
+	for _, base := range byRate {
+		breakdown.Bases = append(breakdown.Bases, *base)
+	}
+	sort.Slice(breakdown.Bases, func(i, j int) bool { return breakdown.Bases[i].Rate > breakdown.Bases[j].Rate })
+	breakdown.Exempt, _ = money.Parse(receipt["exempt_amount"])
+	return breakdown
+}
+
+// IsMixed reports whether the document has more than one VAT base (several rates, or VAT-able + exempt items)
+func (b VATBreakdown) IsMixed() bool {
+	return len(b.Bases) > 1 || (len(b.Bases) == 1 && b.Exempt > 0)
+}
+
+// TaxableBase sums the bases with a rate above 0
+func (b VATBreakdown) TaxableBase() money.Amount {
+	var total money.Amount
+	for _, base := range b.Bases {
+		if base.Rate > 0 {
+			total += base.Base
+		}
+	}
+	return total
+}
+
+// NonTaxable sums the exempt value and the zero-rated bases
+func (b VATBreakdown) NonTaxable() money.Amount {
+	total := b.Exempt
+	for _, base := range b.Bases {
+		if base.Rate == 0 {
+			total += base.Base
+		}
+	}
+	return total
+}
+
+// TotalVAT sums the VAT of all rates
+func (b VATBreakdown) TotalVAT() money.Amount {
+	var total money.Amount
+	for _, base := range b.Bases {
+		total += base.VAT
+	}
+	return total
+}
+
+// VATSplitResult is surfaced as validation.vat_split
+type VATSplitResult struct {
+	AccountCode string         `json:"account_code"`
+	Side        string         `json:"side"`
+	Original    money.Amount   `json:"original"` // Amount of the line before the split
+	Lines       []VATSplitLine `json:"lines"`
+	Note        string         `json:"note"`
+}
+
+// VATSplitLine is one base of the split line
+type VATSplitLine struct {
+	Rate   float64      `json:"rate"`
+	Exempt bool         `json:"exempt,omitempty"`
+	Amount money.Amount `json:"amount"`
+}
+
+// SplitMixedVATEntries splits the main revenue (sale) / expense (purchase) line of a mixed-VAT document
+// into one line per VAT base - each line gets vat_rate (and vat_exempt) for the VAT reports
+// The line must carry the bases (VAT booked separately) or the document total (VAT included, non-registered shop)
+// Returns nil when the document is not mixed or no line matches (already split by the AI / template)
+func SplitMixedVATEntries(accountingEntry, receipt map[string]interface{}, accounts []bson.M) *VATSplitResult {
+	breakdown := ReadVATBreakdown(receipt)
+	entriesRaw, ok := accountingEntry["entries"].([]interface{})
+	if !breakdown.IsMixed() || !ok {
+		return nil
+	}
+
+	vatAccounts := map[string]bool{}
+	for _, acc := range accounts {
+		if isVATAccountName(getStringFromInterface(acc["accountname"])) {
+			vatAccounts[getStringFromInterface(acc["accountcode"])] = true
+		}
+	}
+
+	// Step 1: Main line = largest non-VAT line of the revenue (sale) / expense (purchase) side
+	side := "debit"
+	if DetectDirection(accountingEntry) == DirectionSale {
+		side = "credit"
+	}
+	targetIndex := -1
+	var target map[string]interface{}
+	for i, e := range entriesRaw {
+		line, ok := e.(map[string]interface{})
+		if !ok || vatAccounts[getStringFromInterface(line["account_code"])] || isVATAccountName(getStringFromInterface(line["account_name"])) {
+			continue
+		}
+		if amount := amountFromInterface(line[side]); amount > 0 && (target == nil || amount > amountFromInterface(target[side])) {
+			targetIndex, target = i, line
+		}
+	}
+	if target == nil {
+		return nil
+	}
+
+	// Step 2: Amounts of the new lines - bases only, or bases + VAT when the VAT was not booked separately
+	original, _ := money.Parse(target[side]) // Picked as a positive amount in Step 1
+	bases := breakdown.TaxableBase() + breakdown.NonTaxable()
+	includeVAT := false
+	switch original {
+	case bases:
+	case bases + breakdown.TotalVAT():
+		includeVAT = true
+	default:
+		return nil
+	}
+
+	result := &VATSplitResult{
+		AccountCode: getStringFromInterface(target["account_code"]),
+		Side:        side,
+		Original:    original,
+	}
+	for _, base := range breakdown.Bases {
+		amount := base.Base
+		if includeVAT {
+			amount += base.VAT
+		}
+		result.Lines = append(result.Lines, VATSplitLine{Rate: base.Rate, Amount: amount})
+	}
+	if breakdown.Exempt > 0 {
+		result.Lines = append(result.Lines, VATSplitLine{Exempt: true, Amount: breakdown.Exempt})
+	}
+
+	// Step 3: Replace the main line by one line per base (same account, description marks the base)
+	description := getStringFromInterface(target["description"])
+	split := make([]interface{}, 0, len(entriesRaw)+len(result.Lines)-1)
+	split = append(split, entriesRaw[:targetIndex]...)
+	for _, part := range result.Lines {
+		line := make(map[string]interface{}, len(target)+2)
+		for key, value := range target {
+			line[key] = value
+		}
+		line[side] = part.Amount.Baht()
+		line["vat_rate"] = part.Rate
+		label := fmt.Sprintf("VAT %g%%", part.Rate)
+		if part.Exempt {
+			line["vat_exempt"] = true
+			label = "ยกเว้น VAT"
+		}
+		line["description"] = fmt.Sprintf("%s (%s)", description, label)
+		split = append(split, line)
+	}
+	split = append(split, entriesRaw[targetIndex+1:]...)
+	accountingEntry["entries"] = split
+
+	result.Note = fmt.Sprintf("เอกสารมีหลายฐานภาษี - แยก %s %s (%s บาท) เป็น %d รายการตามอัตรา VAT / ยกเว้น VAT",
+		result.AccountCode, getStringFromInterface(target["account_name"]), original, len(result.Lines))
+	return result
+}
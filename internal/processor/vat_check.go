@@ -0,0 +1,126 @@
+// vat_check.go - Checks the VAT read from the document against the VAT rate in force
+//
+// ห้าม AI คำนวณ VAT เอง (ใช้ตัวเลขจากเอกสาร) → ตรวจหลัง Phase 3 ว่า receipt.vat สอดคล้องกับ receipt.total ตามอัตราที่ใช้จริง
+// อัตรามาจาก config (VAT_RATE / VAT_RATE_HISTORY ตามวันที่เอกสาร) หรือ shopSettings.vat_rate สำหรับเอกสารขายของร้าน
+// เอกสารหลายอัตรา / มีรายการยกเว้น VAT (receipt.vat_breakdown) → ตรวจแต่ละอัตรา: VAT = ฐาน × อัตรา และ ฐานรวม + ยกเว้น + VAT = ยอดรวม
+// ไม่ตรง = อ่านตัวเลขผิด หรือเอกสารใช้อัตราอื่น (เช่น เอกสารเก่าก่อนเปลี่ยนอัตรา, ร้านอัตรา 0%) → ให้ผู้ใช้ตรวจ
+
+package processor
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+)
+
+const (
+	vatCheckMinTolerance = 0.05  // Baht - rounding of VAT per line on the document
+	vatCheckTolerance    = 0.002 // Fraction of the total
+)
+
+// VATMathCheck is surfaced as validation.vat_check
+type VATMathCheck struct {
+	Rate        float64      `json:"rate"` // Percent
+	Total       money.Amount `json:"total"`
+	VAT         money.Amount `json:"vat"`
+	ExpectedVAT money.Amount `json:"expected_vat"` // total × rate / (100 + rate)
+	Agreed      bool         `json:"agreed"`
+	Message     string       `json:"message,omitempty"`
+
+	// Documents with receipt.vat_breakdown (several rates / exempt items)
+	Rates  []VATRateCheck `json:"rates,omitempty"`
+	Exempt money.Amount   `json:"exempt,omitempty"`
+}
+
+// VATRateCheck is the VAT check of one rate of the breakdown
+type VATRateCheck struct {
+	Rate        float64      `json:"rate"`
+	Base        money.Amount `json:"base"`
+	VAT         money.Amount `json:"vat"`
+	ExpectedVAT money.Amount `json:"expected_vat"` // base × rate / 100
+	Agreed      bool         `json:"agreed"`
+}
+
+// CheckVATMath compares receipt.vat with the VAT included in receipt.total at rate
+// Documents with a mixed VAT breakdown are checked per rate (rate is not used)
+// Returns nil when the document has no total or states no VAT (nothing to check) or either is unreadable
+func CheckVATMath(receipt map[string]interface{}, rate float64) *VATMathCheck {
+	total, totalOK := money.Parse(receipt["total"])
+	vat, vatOK := money.Parse(receipt["vat"])
+	if !totalOK || !vatOK {
+		return nil
+	}
+	if breakdown := ReadVATBreakdown(receipt); breakdown.IsMixed() && total > 0 {
+		return checkVATBreakdown(breakdown, total, vat, rate)
+	}
+	if total <= 0 || vat <= 0 {
+		return nil
+	}
+
+	expected := money.FromBaht(total.Baht() * rate / (100 + rate))
+	tolerance := vatTolerance(total)
+	check := &VATMathCheck{
+		Rate:        rate,
+		Total:       total,
+		VAT:         vat,
+		ExpectedVAT: expected,
+		Agreed:      (vat - expected).Abs() <= tolerance,
+	}
+	if !check.Agreed {
+		if rate == 0 {
+			check.Message = fmt.Sprintf("อัตรา VAT 0%% แต่เอกสารมี VAT %s บาท - ตรวจสอบอัตราภาษีของเอกสาร", vat)
+		} else {
+			check.Message = fmt.Sprintf("VAT %s บาท ไม่ตรงกับอัตรา %g%% ของยอดรวม %s บาท (ควรเป็นประมาณ %s) - ตรวจสอบตัวเลขหรืออัตราภาษี",
+				vat, rate, total, expected)
+		}
+	}
+	return check
+}
+
+// checkVATBreakdown checks each rate of a mixed document and that bases + exempt + VAT add up to the total
+func checkVATBreakdown(breakdown VATBreakdown, total, vat money.Amount, rate float64) *VATMathCheck {
+	if vat == 0 {
+		vat = breakdown.TotalVAT()
+	}
+	check := &VATMathCheck{
+		Rate:        rate,
+		Total:       total,
+		VAT:         vat,
+		ExpectedVAT: breakdown.TotalVAT(),
+		Exempt:      breakdown.Exempt,
+		Agreed:      true,
+	}
+
+	var problems []string
+	for _, base := range breakdown.Bases {
+		rateCheck := VATRateCheck{
+			Rate:        base.Rate,
+			Base:        base.Base,
+			VAT:         base.VAT,
+			ExpectedVAT: money.FromBaht(base.Base.Baht() * base.Rate / 100),
+		}
+		rateCheck.Agreed = (base.VAT - rateCheck.ExpectedVAT).Abs() <= vatTolerance(base.Base)
+		if !rateCheck.Agreed {
+			problems = append(problems, fmt.Sprintf("อัตรา %g%%: VAT %s บาท ไม่ตรงกับฐาน %s บาท (ควรเป็นประมาณ %s)", base.Rate, base.VAT, base.Base, rateCheck.ExpectedVAT))
+		}
+		check.Rates = append(check.Rates, rateCheck)
+	}
+	if (vat - breakdown.TotalVAT()).Abs() > vatTolerance(total) {
+		problems = append(problems, fmt.Sprintf("VAT รวม %s บาท ไม่เท่ากับผลรวม VAT แยกอัตรา %s บาท", vat, breakdown.TotalVAT()))
+	}
+	if sum := breakdown.TaxableBase() + breakdown.NonTaxable() + breakdown.TotalVAT(); (sum - total).Abs() > vatTolerance(total) {
+		problems = append(problems, fmt.Sprintf("ฐานภาษี + ยกเว้น + VAT = %s บาท ไม่เท่ากับยอดรวม %s บาท", sum, total))
+	}
+	if len(problems) > 0 {
+		check.Agreed = false
+		check.Message = strings.Join(problems, ", ") + " - ตรวจสอบตัวเลขแยกอัตราภาษี"
+	}
+	return check
+}
+
+// vatTolerance - per-line VAT rounding on the document (at least vatCheckMinTolerance)
+func vatTolerance(amount money.Amount) money.Amount {
+	return money.FromBaht(math.Max(vatCheckMinTolerance, amount.Baht()*vatCheckTolerance))
+}
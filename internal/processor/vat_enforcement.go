@@ -0,0 +1,200 @@
+// vat_enforcement.go - Enforce the shop's VAT registration on journal entries
+//
+// สถานะจดทะเบียน VAT เดิมบอก AI ผ่าน promptshopinfo (ข้อความอิสระ) เท่านั้น → AI แยก/ไม่แยก VAT ผิดได้
+// ใช้ flag vat_registered ของร้านตรวจหลัง Phase 3:
+//   - ไม่จด VAT แต่มีรายการภาษีซื้อ/ขาย → รวมยอด VAT กลับเข้ารายการหลักฝั่งเดียวกัน
+//   - จด VAT แต่ไม่มีรายการภาษีซื้อ/ขาย (และเอกสารมี VAT) → แยก VAT ออกจากรายการหลัก
+
+package processor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// VAT enforcement actions (validation.vat_enforcement.action)
+const (
+	VATActionNone              = "none"                // Entries already follow the shop's VAT status
+	VATActionStripped          = "stripped"            // Non-registered shop: VAT entries merged into the main entry
+	VATActionSplit             = "split"               // Registered shop: VAT entry added from the document VAT
+	VATActionMissingVATAccount = "missing_vat_account" // Registered shop but no ภาษีซื้อ/ภาษีขาย account in the chart
+	VATActionUnreadableAmount  = "unreadable_amount"   // An entry / document VAT amount could not be read - entries left as they are
+)
+
+// VATEnforcementResult is surfaced as validation.vat_enforcement
+type VATEnforcementResult struct {
+	VATRegistered bool         `json:"vat_registered"`
+	Action        string       `json:"action"`
+	AccountCode   string       `json:"account_code,omitempty"`
+	Amount        money.Amount `json:"amount,omitempty"`
+	Note          string       `json:"note,omitempty"`
+}
+
+// RequiresReview reports whether the entry could not be fixed automatically
+func (r VATEnforcementResult) RequiresReview() bool {
+	return r.Action == VATActionMissingVATAccount || r.Action == VATActionUnreadableAmount
+}
+
+// EnforceVATRegistration strips or adds VAT split entries according to vatRegistered
+// accountingEntry is the response accounting_entry (entries are modified in place)
+// receipt provides the document VAT (receipt.vat) - VAT is never calculated here
+func EnforceVATRegistration(accountingEntry, receipt map[string]interface{}, accounts []bson.M, vatRegistered bool) VATEnforcementResult {
+	result := VATEnforcementResult{VATRegistered: vatRegistered, Action: VATActionNone}
+	entriesRaw, ok := accountingEntry["entries"].([]interface{})
+	if !ok || len(entriesRaw) == 0 {
+		return result
+	}
+
+	vatAccounts := map[string]bool{}
+	for _, acc := range accounts {
+		if isVATAccountName(getStringFromInterface(acc["accountname"])) {
+			vatAccounts[getStringFromInterface(acc["accountcode"])] = true
+		}
+	}
+	isVATEntry := func(entry map[string]interface{}) bool {
+		return vatAccounts[getStringFromInterface(entry["account_code"])] ||
+			isVATAccountName(getStringFromInterface(entry["account_name"]))
+	}
+
+	var entries []map[string]interface{}
+	var vatEntries []map[string]interface{}
+	for _, e := range entriesRaw {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// Amounts are moved between entries below - an unreadable one must not count as 0
+		_, debitOK := money.Parse(entry["debit"])
+		_, creditOK := money.Parse(entry["credit"])
+		if !debitOK || !creditOK {
+			result.Action = VATActionUnreadableAmount
+			result.Note = fmt.Sprintf("อ่านยอดเงินของรายการ %s ไม่ได้ - กรุณาตรวจสอบ VAT เอง", getStringFromInterface(entry["account_code"]))
+			return result
+		}
+		if isVATEntry(entry) {
+			vatEntries = append(vatEntries, entry)
+		} else {
+			entries = append(entries, entry)
+		}
+	}
+
+	// Case 1: Not VAT-registered → VAT is part of the cost / revenue
+	if !vatRegistered {
+		if len(vatEntries) == 0 {
+			return result
+		}
+		for _, vat := range vatEntries {
+			side := "debit"
+			if amountFromInterface(vat["credit"]) > amountFromInterface(vat["debit"]) {
+				side = "credit"
+			}
+			amount := amountFromInterface(vat[side])
+			target := largestEntry(entries, side)
+			if target == nil {
+				// Nothing to merge into - keep the entry rather than unbalance the journal
+				entries = append(entries, vat)
+				continue
+			}
+			target[side] = (amountFromInterface(target[side]) + amount).Baht()
+			result.Amount += amount
+			result.AccountCode = getStringFromInterface(vat["account_code"])
+		}
+		if result.Amount > 0 {
+			result.Action = VATActionStripped
+			result.Note = fmt.Sprintf("ร้านไม่ได้จดทะเบียน VAT - รวมภาษี %s บาทเข้ารายการหลัก", result.Amount)
+		}
+		accountingEntry["entries"] = toInterfaceSlice(entries)
+		return result
+	}
+
+	// Case 2: VAT-registered → document VAT must be split into ภาษีซื้อ / ภาษีขาย
+	if len(vatEntries) > 0 {
+		return result
+	}
+	vat, ok := money.Parse(receipt["vat"])
+	if !ok {
+		result.Action = VATActionUnreadableAmount
+		result.Note = "อ่านยอด VAT ของเอกสารไม่ได้ - กรุณาแยก VAT เอง"
+		return result
+	}
+	if vat <= 0 {
+		return result // Document has no VAT (or it isn't stated) - nothing to split
+	}
+
+	// Purchase (creditor) → Dr. ภาษีซื้อ, sale (debtor) → Cr. ภาษีขาย
+	side, keyword := "debit", "ภาษีซื้อ"
+	if DetectDirection(accountingEntry) == DirectionSale {
+		side, keyword = "credit", "ภาษีขาย"
+	}
+
+	var vatCode, vatName string
+	for _, acc := range accounts {
+		name := getStringFromInterface(acc["accountname"])
+		if strings.Contains(name, keyword) && !strings.Contains(name, "ยังไม่ถึงกำหนด") {
+			vatCode, vatName = getStringFromInterface(acc["accountcode"]), name
+			break
+		}
+	}
+	if vatCode == "" {
+		result.Action = VATActionMissingVATAccount
+		result.Note = fmt.Sprintf("ร้านจดทะเบียน VAT แต่ไม่พบบัญชี%sในผังบัญชี - กรุณาแยก VAT เอง", keyword)
+		return result
+	}
+
+	target := largestEntry(entries, side)
+	if target == nil || amountFromInterface(target[side]) <= vat {
+		result.Action = VATActionMissingVATAccount
+		result.Note = "ไม่พบรายการหลักที่จะแยก VAT ออกได้ - กรุณาแยก VAT เอง"
+		return result
+	}
+	target[side] = (amountFromInterface(target[side]) - vat).Baht()
+
+	vatEntry := map[string]interface{}{
+		"account_code":     vatCode,
+		"account_name":     vatName,
+		"debit":            0.0,
+		"credit":           0.0,
+		"description":      keyword,
+		"selection_reason": fmt.Sprintf("ร้านจดทะเบียน VAT - แยก%sตามยอด VAT ในเอกสาร (ระบบเพิ่มอัตโนมัติ)", keyword),
+	}
+	vatEntry[side] = vat.Baht()
+	entries = append(entries, vatEntry)
+	accountingEntry["entries"] = toInterfaceSlice(entries)
+
+	result.Action = VATActionSplit
+	result.AccountCode = vatCode
+	result.Amount = vat
+	result.Note = fmt.Sprintf("ร้านจดทะเบียน VAT - แยก%s %s บาทออกจาก %s", keyword, vat, getStringFromInterface(target["account_name"]))
+	return result
+}
+
+// isVATAccountName matches input/output VAT accounts (ภาษีซื้อ, ภาษีขาย incl. ยังไม่ถึงกำหนด)
+func isVATAccountName(name string) bool {
+	return strings.Contains(name, "ภาษีซื้อ") || strings.Contains(name, "ภาษีขาย")
+}
+
+// largestEntry returns the entry with the largest amount on side ("debit" or "credit")
+func largestEntry(entries []map[string]interface{}, side string) map[string]interface{} {
+	var largest map[string]interface{}
+	for _, entry := range entries {
+		if amountFromInterface(entry[side]) <= 0 {
+			continue
+		}
+		if largest == nil || amountFromInterface(entry[side]) > amountFromInterface(largest[side]) {
+			largest = entry
+		}
+	}
+	return largest
+}
+
+// toInterfaceSlice converts entries back to the JSON-decoded representation
+func toInterfaceSlice(entries []map[string]interface{}) []interface{} {
+	out := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		out[i] = entry
+	}
+	return out
+}
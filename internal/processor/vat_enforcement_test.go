@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestEnforceVATRegistrationAmounts(t *testing.T) {
+	tests := []struct {
+		name       string
+		registered bool
+		entries    []interface{}
+		vat        interface{}
+		wantAction string
+		wantMain   float64
+	}{
+		{
+			name:       "strip formatted VAT",
+			entries:    []interface{}{testEntry("5100", "1,000.00", 0.0), testEntry("1150", "70.00", 0.0), testEntry("1000", 0.0, 1070.0)},
+			wantAction: VATActionStripped,
+			wantMain:   1070,
+		},
+		{
+			name:       "unreadable entry amount",
+			entries:    []interface{}{testEntry("5100", "1,000.00", 0.0), testEntry("1150", "N/A", 0.0), testEntry("1000", 0.0, 1070.0)},
+			wantAction: VATActionUnreadableAmount,
+			wantMain:   1000,
+		},
+		{
+			name:       "split formatted document VAT",
+			registered: true,
+			entries:    []interface{}{testEntry("5100", 1070.0, 0.0), testEntry("1000", 0.0, 1070.0)},
+			vat:        "฿ 70.00",
+			wantAction: VATActionSplit,
+			wantMain:   1000,
+		},
+		{
+			name:       "unreadable document VAT",
+			registered: true,
+			entries:    []interface{}{testEntry("5100", 1070.0, 0.0), testEntry("1000", 0.0, 1070.0)},
+			vat:        "N/A",
+			wantAction: VATActionUnreadableAmount,
+			wantMain:   1070,
+		},
+	}
+	accounts := []bson.M{
+		{"accountcode": "1000", "accountname": "เงินสด"},
+		{"accountcode": "1150", "accountname": "ภาษีซื้อ"},
+		{"accountcode": "5100", "accountname": "ค่าวัสดุสิ้นเปลือง"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := map[string]interface{}{"entries": tt.entries}
+			result := EnforceVATRegistration(entry, map[string]interface{}{"vat": tt.vat}, accounts, tt.registered)
+			if result.Action != tt.wantAction {
+				t.Fatalf("action = %q (%s), want %q", result.Action, result.Note, tt.wantAction)
+			}
+			main, _ := money.Parse(entry["entries"].([]interface{})[0].(map[string]interface{})["debit"])
+			if main != money.FromBaht(tt.wantMain) {
+				t.Errorf("main debit = %s, want %.2f", main, tt.wantMain)
+			}
+			if (tt.wantAction == VATActionUnreadableAmount) != result.RequiresReview() {
+				t.Errorf("RequiresReview = %v", result.RequiresReview())
+			}
+		})
+	}
+}
@@ -2,10 +2,12 @@
 package processor
 
 import (
-	"math"
 	"regexp"
+	"sort"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
@@ -15,124 +17,226 @@ type VendorMatchResult struct {
 	Code       string  `json:"code"`
 	Name       string  `json:"name"`
 	Similarity float64 `json:"similarity"`
-	Method     string  `json:"method"` // exact, fuzzy, tax_id, not_found
+	Method     string  `json:"method"`          // exact, fuzzy, tax_id, alias, not_found
+	Alias      string  `json:"alias,omitempty"` // Registered alias that matched (method = alias)
+	Party      string  `json:"party,omitempty"` // PartyCreditor (MatchVendor) or PartyDebtor (MatchDebtor)
+	// Account approved by a user for this creditor (vendorAccountMappings, empty = none)
+	LearnedAccountCode string `json:"learned_account_code,omitempty"`
+	LearnedAccountName string `json:"learned_account_name,omitempty"`
+	// Ranked creditors (best first, the match included) - also filled when not found so near-misses can be reviewed
+	Candidates []VendorCandidate `json:"candidates,omitempty"`
 }
 
+// VendorCandidate is one ranked creditor (or debtor) considered by MatchVendor / MatchDebtor
+type VendorCandidate struct {
+	Code       string  `json:"code"`
+	Name       string  `json:"name"`
+	Similarity float64 `json:"similarity"`
+	Method     string  `json:"method"` // exact, fuzzy, tax_id, alias
+	Alias      string  `json:"alias,omitempty"`
+}
+
+const (
+	vendorMatchMinSimilarity     = 70.0 // Best candidate below this = not found
+	vendorCandidateMinSimilarity = 60.0 // Weaker fuzzy scores are not kept as alternatives
+	maxVendorCandidates          = 5
+)
+
+// TopCandidates returns the n best candidates (n ≤ 0 = all)
+func (r VendorMatchResult) TopCandidates(n int) []VendorCandidate {
+	if n <= 0 || len(r.Candidates) <= n {
+		return r.Candidates
+	}
+	return r.Candidates[:n]
+}
+
+// Party of a VendorMatchResult
+const (
+	PartyCreditor = "creditor"
+	PartyDebtor   = "debtor"
+)
+
 // MatchVendor finds the best matching vendor from master data
-// Uses fuzzy matching with Thai text normalization
-func MatchVendor(vendorNameFromOCR string, creditors []bson.M, taxIDFromOCR string) VendorMatchResult {
+// Candidates come from tax ID → registered aliases (creditorAliases) → fuzzy matching with Thai text normalization
+// Ties keep that order (a tax ID match wins over an equally scored name match)
+func MatchVendor(vendorNameFromOCR string, creditors []bson.M, taxIDFromOCR string, aliases []storage.CreditorAlias) VendorMatchResult {
+	result := matchParty(vendorNameFromOCR, creditors, taxIDFromOCR, aliases)
+	result.Party = PartyCreditor
+	return result
+}
+
+// MatchDebtor finds the customer of a sales document in the debtor list (same flow as MatchVendor, no aliases)
+func MatchDebtor(customerNameFromOCR string, debtors []bson.M, taxIDFromOCR string) VendorMatchResult {
+	result := matchParty(customerNameFromOCR, debtors, taxIDFromOCR, nil)
+	result.Party = PartyDebtor
+	return result
+}
+
+// matchParty matches a name / tax ID from the OCR text against creditors or debtors (both use code / taxid / names)
+func matchParty(vendorNameFromOCR string, creditors []bson.M, taxIDFromOCR string, aliases []storage.CreditorAlias) VendorMatchResult {
 	if vendorNameFromOCR == "" && taxIDFromOCR == "" {
 		return VendorMatchResult{Found: false, Method: "not_found"}
 	}
 
-	// Try Tax ID matching first (100% reliable)
+	var candidates []VendorCandidate
+
+	// Step 1: Tax ID matching (100% reliable)
 	if taxIDFromOCR != "" {
 		taxIDNormalized := normalizeTaxID(taxIDFromOCR)
 		for _, creditor := range creditors {
 			creditorTaxID, _ := creditor["taxid"].(string)
 			if creditorTaxID != "" && normalizeTaxID(creditorTaxID) == taxIDNormalized {
 				code, _ := creditor["code"].(string)
-				name := extractNameFromCreditor(creditor)
-				return VendorMatchResult{
-					Found:      true,
+				candidates = append(candidates, VendorCandidate{
 					Code:       code,
-					Name:       name,
+					Name:       extractNameFromCreditor(creditor),
 					Similarity: 100.0,
 					Method:     "tax_id",
-				}
+				})
 			}
 		}
 	}
 
-	// Fuzzy name matching
-	if vendorNameFromOCR == "" {
-		return VendorMatchResult{Found: false, Method: "not_found"}
-	}
-
-	normalizedOCR := normalizeVendorName(vendorNameFromOCR)
-	if normalizedOCR == "" {
-		return VendorMatchResult{Found: false, Method: "not_found"}
-	}
-
-	bestMatch := VendorMatchResult{Found: false, Similarity: 0.0, Method: "not_found"}
-
-	for _, creditor := range creditors {
-		creditorName := extractNameFromCreditor(creditor)
-		if creditorName == "" {
-			continue
-		}
-
-		normalizedMaster := normalizeVendorName(creditorName)
-		if normalizedMaster == "" {
-			continue
+	if normalizedOCR := normalizeVendorName(vendorNameFromOCR); normalizedOCR != "" {
+		// Step 2: Aliases registered by users (brand name → legal name)
+		if aliasMatch := matchCreditorAlias(normalizedOCR, creditors, aliases); aliasMatch.Found {
+			candidates = append(candidates, VendorCandidate{
+				Code:       aliasMatch.Code,
+				Name:       aliasMatch.Name,
+				Similarity: aliasMatch.Similarity,
+				Method:     aliasMatch.Method,
+				Alias:      aliasMatch.Alias,
+			})
 		}
 
-		// Calculate similarity
-		similarity := calculateNameSimilarity(normalizedOCR, normalizedMaster)
+		// Step 3: Fuzzy name matching against every creditor
+		for _, creditor := range creditors {
+			creditorName := extractNameFromCreditor(creditor)
+			if creditorName == "" {
+				continue
+			}
+			normalizedMaster := normalizeVendorName(creditorName)
+			if normalizedMaster == "" {
+				continue
+			}
 
-		// Update best match
-		if similarity > bestMatch.Similarity {
+			similarity := calculateNameSimilarity(normalizedOCR, normalizedMaster)
+			if similarity < vendorCandidateMinSimilarity {
+				continue
+			}
+			method := "fuzzy"
+			if normalizedOCR == normalizedMaster {
+				method = "exact" // Only identical names - a reordered or re-spelled name can also score 100
+			}
 			code, _ := creditor["code"].(string)
-			bestMatch = VendorMatchResult{
-				Found:      true,
+			candidates = append(candidates, VendorCandidate{
 				Code:       code,
 				Name:       creditorName, // Use original name from Master
 				Similarity: similarity,
-				Method:     "fuzzy",
-			}
-		}
-
-		// If exact match found, stop searching
-		if similarity >= 99.0 {
-			bestMatch.Method = "exact"
-			break
+				Method:     method,
+			})
 		}
 	}
 
-	// Only return if similarity >= 70%
-	if bestMatch.Similarity < 70.0 {
-		return VendorMatchResult{Found: false, Method: "not_found"}
+	// Step 4: Rank - the best candidate is the match when it reaches vendorMatchMinSimilarity
+	candidates = rankVendorCandidates(candidates)
+	if len(candidates) == 0 || candidates[0].Similarity < vendorMatchMinSimilarity {
+		return VendorMatchResult{Found: false, Method: "not_found", Candidates: candidates}
+	}
+	best := candidates[0]
+	return VendorMatchResult{
+		Found:      true,
+		Code:       best.Code,
+		Name:       best.Name,
+		Similarity: best.Similarity,
+		Method:     best.Method,
+		Alias:      best.Alias,
+		Candidates: candidates,
 	}
-
-	return bestMatch
 }
 
-// normalizeVendorName normalizes Thai company names for matching
-func normalizeVendorName(name string) string {
-	// Convert to lowercase
-	name = strings.ToLower(name)
-
-	// Remove common prefixes/suffixes
-	prefixes := []string{
-		"บริษัท", "บจก.", "บมจ.", "บจำกัด", "บมหาชน",
-		"ห้างหุ้นส่วนจำกัด", "หจก.", "ห.จำกัด",
-		"company", "co.", "ltd.", "limited", "corp.", "corporation",
+// rankVendorCandidates keeps the best candidate of each creditor, sorted by similarity (stable) and capped at maxVendorCandidates
+func rankVendorCandidates(candidates []VendorCandidate) []VendorCandidate {
+	ranked := make([]VendorCandidate, 0, len(candidates))
+	index := map[string]int{}
+	for _, candidate := range candidates {
+		if i, ok := index[candidate.Code]; ok {
+			if candidate.Similarity > ranked[i].Similarity {
+				ranked[i] = candidate
+			}
+			continue
+		}
+		index[candidate.Code] = len(ranked)
+		ranked = append(ranked, candidate)
 	}
-	for _, prefix := range prefixes {
-		name = strings.Replace(name, strings.ToLower(prefix), "", -1)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Similarity > ranked[j].Similarity })
+	if len(ranked) > maxVendorCandidates {
+		ranked = ranked[:maxVendorCandidates]
 	}
+	return ranked
+}
 
-	// Remove common suffixes
-	suffixes := []string{
-		"จำกัด", "มหาชน", "(มหาชน)", "จํากัด",
-		"สำนักงานใหญ่", "(สำนักงานใหญ่)", "head office",
-		"limited", "ltd", "corp", "inc",
-	}
-	for _, suffix := range suffixes {
-		name = strings.Replace(name, strings.ToLower(suffix), "", -1)
+// minAliasLength - shorter normalized aliases would match too many unrelated names
+const minAliasLength = 3
+
+// matchCreditorAlias returns the creditor of the longest alias contained in the OCR name
+// (the vendor line often carries extra text, e.g. "7-ELEVEN สาขา 01234")
+func matchCreditorAlias(normalizedOCR string, creditors []bson.M, aliases []storage.CreditorAlias) VendorMatchResult {
+	best := VendorMatchResult{Found: false, Method: "not_found"}
+	bestLength := 0
+	for _, alias := range aliases {
+		normalizedAlias := normalizeVendorName(alias.Alias)
+		length := utf8.RuneCountInString(normalizedAlias)
+		if length < minAliasLength || length <= bestLength || !strings.Contains(normalizedOCR, normalizedAlias) {
+			continue
+		}
+		for _, creditor := range creditors {
+			if code, _ := creditor["code"].(string); code == alias.CreditorCode {
+				best = VendorMatchResult{
+					Found:      true,
+					Code:       code,
+					Name:       extractNameFromCreditor(creditor),
+					Similarity: 100.0,
+					Method:     "alias",
+					Alias:      alias.Alias,
+				}
+				bestLength = length
+				break
+			}
+		}
 	}
+	return best
+}
+
+// Compiled once - normalizeVendorName runs for every creditor of the shop
+var (
+	vendorNameLoRegex          = regexp.MustCompile(`ลล์|ล์`)
+	vendorNameRoRegex          = regexp.MustCompile(`รร์|ร์`)
+	vendorNameNoRegex          = regexp.MustCompile(`นน์|น์`)
+	vendorNameMaiEkRegex       = regexp.MustCompile(`่+`)
+	vendorNameMaiThoRegex      = regexp.MustCompile(`้+`)
+	vendorNameMaiTriRegex      = regexp.MustCompile(`๊+`)
+	vendorNameMaiChattawaRegex = regexp.MustCompile(`๋+`)
+	vendorNameSymbolRegex      = regexp.MustCompile(`[^\p{L}\p{M}\p{N}]+`) // Keeps Thai vowel/tone marks (\p{M})
+	vendorNameSpaceRegex       = regexp.MustCompile(`\s+`)
+)
+
+// normalizeVendorName normalizes Thai company names for matching
+func normalizeVendorName(name string) string {
+	// Lowercase + juristic prefixes/suffixes (บจก./บริษัท…จำกัด, หจก./ห้างหุ้นส่วนจำกัด, Co., Ltd.) and branch markers
+	name = normalizeJuristicName(name)
 
 	// Normalize Thai special characters
 	// Handle duplicated consonants: ลล์ → ล, ล์ → ล
-	name = regexp.MustCompile(`ลล์|ล์`).ReplaceAllString(name, "ล")
-	name = regexp.MustCompile(`รร์|ร์`).ReplaceAllString(name, "ร")
-	name = regexp.MustCompile(`นน์|น์`).ReplaceAllString(name, "น")
+	name = vendorNameLoRegex.ReplaceAllString(name, "ล")
+	name = vendorNameRoRegex.ReplaceAllString(name, "ร")
+	name = vendorNameNoRegex.ReplaceAllString(name, "น")
 
 	// Handle duplicated tone marks: ่่ → ่, ้้ → ้
-	name = regexp.MustCompile(`่+`).ReplaceAllString(name, "่")
-	name = regexp.MustCompile(`้+`).ReplaceAllString(name, "้")
-	name = regexp.MustCompile(`๊+`).ReplaceAllString(name, "๊")
-	name = regexp.MustCompile(`๋+`).ReplaceAllString(name, "๋")
+	name = vendorNameMaiEkRegex.ReplaceAllString(name, "่")
+	name = vendorNameMaiThoRegex.ReplaceAllString(name, "้")
+	name = vendorNameMaiTriRegex.ReplaceAllString(name, "๊")
+	name = vendorNameMaiChattawaRegex.ReplaceAllString(name, "๋")
 
 	// Normalize connectors: และ, &, แอนด์ → and
 	connectors := map[string]string{
@@ -145,11 +249,11 @@ func normalizeVendorName(name string) string {
 	}
 
 	// Remove extra spaces and special characters
-	name = regexp.MustCompile(`[^\p{L}\p{N}]+`).ReplaceAllString(name, " ")
+	name = vendorNameSymbolRegex.ReplaceAllString(name, " ")
 	name = strings.TrimSpace(name)
 
 	// Remove multiple spaces
-	name = regexp.MustCompile(`\s+`).ReplaceAllString(name, " ")
+	name = vendorNameSpaceRegex.ReplaceAllString(name, " ")
 
 	return name
 }
@@ -213,30 +317,11 @@ func extractNameFromCreditor(creditor bson.M) string {
 	return ""
 }
 
-// calculateNameSimilarity calculates similarity between two normalized names
-// Uses existing Levenshtein distance function from template_matcher.go
+// calculateNameSimilarity calculates similarity between two normalized names (0-100)
+// Thai word segmentation + token-set ratio (see thai_text.go) - word order and extra words (สาขา, ประเภทกิจการ) matter less
 func calculateNameSimilarity(name1, name2 string) float64 {
-	// If identical, return 100%
 	if name1 == name2 {
 		return 100.0
 	}
-
-	// Calculate Levenshtein distance (function from template_matcher.go)
-	distance := levenshteinDistance(name1, name2)
-	maxLen := float64(maxInt(len(name1), len(name2)))
-
-	if maxLen == 0 {
-		return 0.0
-	}
-
-	similarity := (1.0 - (float64(distance) / maxLen)) * 100.0
-	return math.Max(0, similarity)
-}
-
-// maxInt returns the maximum of two integers
-func maxInt(a, b int) int {
-	if a > b {
-		return a
-	}
-	return b
+	return tokenSetSimilarity(name1, name2)
 }
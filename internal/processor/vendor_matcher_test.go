@@ -0,0 +1,75 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func testCreditor(code, name, taxID string) bson.M {
+	return bson.M{"code": code, "taxid": taxID, "names": bson.A{bson.M{"code": "th", "name": name}}}
+}
+
+func TestMatchVendor(t *testing.T) {
+	creditors := []bson.M{
+		testCreditor("C001", "บริษัท สยามแม็คโคร จำกัด (มหาชน)", "0-1055-36000-02-9"),
+		testCreditor("C002", "ห้างหุ้นส่วนจำกัด ป้าแดงการค้า", ""),
+		testCreditor("C003", "Thai Beverage Public Company Limited", ""),
+		testCreditor("C004", "บริษัท ปตท จำกัด (มหาชน)", ""),
+	}
+	aliases := []storage.CreditorAlias{{Alias: "Makro", CreditorCode: "C001"}}
+
+	tests := []struct {
+		name       string
+		vendor     string
+		taxID      string
+		aliases    []storage.CreditorAlias
+		wantFound  bool
+		wantCode   string
+		wantMethod string
+	}{
+		{name: "บจก. vs บริษัท…จำกัด (มหาชน)", vendor: "บจก. สยามแม็คโคร", wantFound: true, wantCode: "C001", wantMethod: "exact"},
+		{name: "บมจ.", vendor: "บมจ.สยามแม็คโคร", wantFound: true, wantCode: "C001", wantMethod: "exact"},
+		{name: "หจก. vs ห้างหุ้นส่วนจำกัด", vendor: "หจก.ป้าแดงการค้า", wantFound: true, wantCode: "C002", wantMethod: "exact"},
+		{name: "branch suffix", vendor: "หจก. ป้าแดงการค้า สาขา 3", wantFound: true, wantCode: "C002", wantMethod: "exact"},
+		{name: "Co., Ltd. vs Public Company Limited", vendor: "Thai Beverage Co., Ltd.", wantFound: true, wantCode: "C003", wantMethod: "exact"},
+		{name: "word order swapped scores 100 but is fuzzy", vendor: "บริษัท แม็คโครสยาม จำกัด", wantFound: true, wantCode: "C001", wantMethod: "fuzzy"},
+		{name: "English word order swapped", vendor: "Beverage Thai Co.,Ltd", wantFound: true, wantCode: "C003", wantMethod: "fuzzy"},
+		{name: "typo", vendor: "บริษัท ปตทฺ จำกัด", wantFound: true, wantCode: "C004", wantMethod: "fuzzy"},
+		{name: "tax ID wins", vendor: "ร้านอื่น", taxID: "0105536000029", wantFound: true, wantCode: "C001", wantMethod: "tax_id"},
+		{name: "alias", vendor: "MAKRO สาขา 12", aliases: aliases, wantFound: true, wantCode: "C001", wantMethod: "alias"},
+		{name: "unknown vendor", vendor: "ลุงดำการค้า", wantMethod: "not_found"},
+		{name: "nothing to match", wantMethod: "not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := MatchVendor(tt.vendor, creditors, tt.taxID, tt.aliases)
+			if result.Found != tt.wantFound || result.Code != tt.wantCode || result.Method != tt.wantMethod {
+				t.Errorf("MatchVendor(%q) = found %v, code %q, method %q (%.1f), want %v, %q, %q",
+					tt.vendor, result.Found, result.Code, result.Method, result.Similarity, tt.wantFound, tt.wantCode, tt.wantMethod)
+			}
+			if result.Party != PartyCreditor {
+				t.Errorf("party = %q, want %q", result.Party, PartyCreditor)
+			}
+			if result.Method == "exact" && result.Similarity != 100 {
+				t.Errorf("exact match similarity = %.2f, want 100", result.Similarity)
+			}
+		})
+	}
+}
+
+func TestMatchVendorCandidatesRanked(t *testing.T) {
+	creditors := []bson.M{
+		testCreditor("C010", "บริษัท สยาม จำกัด", ""),
+		testCreditor("C011", "บริษัท สยามแม็คโคร จำกัด", ""),
+	}
+	result := MatchVendor("สยามแม็คโคร สาขา 2", creditors, "", nil)
+	if !result.Found || result.Code != "C011" {
+		t.Fatalf("match = %q (%s), want C011", result.Code, result.Method)
+	}
+	if len(result.Candidates) != 2 || result.Candidates[1].Code != "C010" ||
+		result.Candidates[1].Similarity >= result.Candidates[0].Similarity {
+		t.Errorf("candidates = %+v, want C011 ranked above C010", result.Candidates)
+	}
+}
@@ -0,0 +1,90 @@
+// withholding_tax.go - Withholding tax (ภาษีหัก ณ ที่จ่าย) lines of an accounting result
+//
+// ร้านจ่ายเงินแล้วหักภาษีไว้ → เครดิตบัญชี "ภาษีหัก ณ ที่จ่ายค้างจ่าย" (ต้องนำส่งตาม ภ.ง.ด.3 / ภ.ง.ด.53)
+// "ภาษีถูกหัก ณ ที่จ่าย" (เดบิต - ลูกค้าหักภาษีร้าน) ไม่ใช่ภาษีที่ร้านต้องนำส่ง
+
+package processor
+
+import (
+	"math"
+	"strings"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/money"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+)
+
+// Withholding tax forms
+const (
+	WithholdingFormPND3  = "pnd3"  // ภ.ง.ด.3 - payee is an individual
+	WithholdingFormPND53 = "pnd53" // ภ.ง.ด.53 - payee is a juristic person
+)
+
+// standardWithholdingRates - rates of the common income types (snapped to when within 0.1%)
+var standardWithholdingRates = []float64{1, 1.5, 2, 3, 5, 10, 15}
+
+// WithholdingTaxSummary is the tax withheld on one document
+type WithholdingTaxSummary struct {
+	Withheld   money.Amount `json:"withheld"`    // Credits of withholding tax payable accounts
+	Base       money.Amount `json:"base"`        // Debits of expense lines (VAT and WHT lines excluded)
+	Rate       float64      `json:"rate"`        // Percent of base
+	IncomeType string       `json:"income_type"` // Account name of the largest expense line
+}
+
+// IsWithholdingTaxAccountName - withholding tax payable (not the prepaid "ถูกหัก" account)
+func IsWithholdingTaxAccountName(name string) bool {
+	compact := strings.ReplaceAll(strings.ToLower(name), " ", "")
+	if strings.Contains(compact, "ถูกหัก") || strings.Contains(compact, "prepaid") {
+		return false
+	}
+	return strings.Contains(compact, "หักณที่จ่าย") || strings.Contains(compact, "withholding")
+}
+
+// ExtractWithholdingTax sums the withholding tax of entries (false = no withholding tax credited)
+func ExtractWithholdingTax(entries []storage.OCRAnalysisEntry) (WithholdingTaxSummary, bool) {
+	var summary WithholdingTaxSummary
+	var largest money.Amount
+	for _, entry := range entries {
+		debit, credit := money.FromBaht(entry.Debit), money.FromBaht(entry.Credit)
+		switch {
+		case IsWithholdingTaxAccountName(entry.AccountName):
+			summary.Withheld += credit - debit
+		case isVATAccountName(entry.AccountName):
+		case debit > 0:
+			summary.Base += debit
+			if debit > largest {
+				largest, summary.IncomeType = debit, entry.AccountName
+			}
+		}
+	}
+	if summary.Withheld <= 0 {
+		return WithholdingTaxSummary{}, false
+	}
+	if summary.Base > 0 {
+		summary.Rate = math.Round(float64(summary.Withheld)/float64(summary.Base)*10000) / 100
+		for _, rate := range standardWithholdingRates {
+			if math.Abs(summary.Rate-rate) <= 0.1 {
+				summary.Rate = rate
+				break
+			}
+		}
+	}
+	return summary, true
+}
+
+// WithholdingTaxForm returns pnd53 for juristic payees (tax ID starts with 0 or the name is a company),
+// pnd3 for individuals ("" = unknown payee)
+func WithholdingTaxForm(taxID, payeeName string) string {
+	for _, prefix := range []string{"บริษัท", "บจก", "บมจ", "ห้างหุ้นส่วน", "หจก", "มูลนิธิ", "สมาคม"} {
+		if strings.Contains(payeeName, prefix) {
+			return WithholdingFormPND53
+		}
+	}
+	digits := normalizeTaxID(taxID)
+	if len(digits) != 13 {
+		return ""
+	}
+	if digits[0] == '0' {
+		return WithholdingFormPND53
+	}
+	return WithholdingFormPND3
+}
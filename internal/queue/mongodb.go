@@ -0,0 +1,88 @@
+// mongodb.go - QUEUE_DRIVER=mongodb: jobs in analyzeJobs, completion events in analyzeJobEvents
+
+package queue
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+)
+
+// maxDemotionsPerReceive bounds the jobs one Receive moves to bulk before giving up (the next poll continues)
+const maxDemotionsPerReceive = 10
+
+// mongoQueue claims jobs of one priority class with a lease so a crashed worker's jobs are delivered again
+type mongoQueue struct {
+	workerID string
+	priority string
+}
+
+func newMongoQueue(workerID, priority string) *mongoQueue {
+	return &mongoQueue{workerID: workerID, priority: priority}
+}
+
+// Receive claims the oldest queued job of the class
+// An interactive job of a shop already at INTERACTIVE_MAX_PER_SHOP goes back on the queue as bulk
+func (q *mongoQueue) Receive(ctx context.Context, lease time.Duration) (*Job, error) {
+	for i := 0; i < maxDemotionsPerReceive; i++ {
+		record, err := storage.ClaimAnalyzeJob(ctx, q.workerID, q.priority, lease)
+		if err != nil || record == nil {
+			return nil, err
+		}
+		if q.priority == common.PriorityBulk || !q.overShopLimit(ctx, record) {
+			return &Job{
+				ID:       record.JobID,
+				ShopID:   record.ShopID,
+				Priority: q.priority,
+				Payload:  []byte(record.Payload),
+				Attempt:  record.Attempts,
+				handle:   record,
+			}, nil
+		}
+		if err := storage.DemoteAnalyzeJob(ctx, *record); err != nil {
+			return nil, err
+		}
+		log.Printf("⏬ Job %s moved to bulk (shop %s is at INTERACTIVE_MAX_PER_SHOP)", record.JobID, record.ShopID)
+	}
+	return nil, nil
+}
+
+// overShopLimit reports whether the shop has more interactive jobs in progress than allowed (including this one)
+func (q *mongoQueue) overShopLimit(ctx context.Context, record *storage.AnalyzeJob) bool {
+	limit := configs.Get().InteractiveMaxPerShop
+	if limit <= 0 {
+		return false
+	}
+	processing, err := storage.CountProcessingAnalyzeJobs(ctx, record.ShopID, common.PriorityInteractive)
+	if err != nil {
+		log.Printf("⚠️  Interactive limit check skipped (job %s): %v", record.JobID, err)
+		return false
+	}
+	return processing > int64(limit)
+}
+
+// Complete stores the result in the job and inserts the completion event
+func (q *mongoQueue) Complete(ctx context.Context, job *Job, result Result) error {
+	record := *job.handle.(*storage.AnalyzeJob)
+	record.Status = storage.AnalyzeJobStatusDone
+	switch {
+	case result.Retry:
+		record.Status = storage.AnalyzeJobStatusQueued
+	case result.StatusCode != 200:
+		record.Status = storage.AnalyzeJobStatusFailed
+	}
+	record.RequestID = result.RequestID
+	record.StatusCode = result.StatusCode
+	record.Result = string(result.Body)
+	record.Error = result.Error
+	return storage.FinishAnalyzeJob(record)
+}
+
+// Close is a no-op - the MongoDB connection belongs to the storage package
+func (q *mongoQueue) Close() error {
+	return nil
+}
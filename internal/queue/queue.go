@@ -0,0 +1,58 @@
+// queue.go - Analyze job queue used by cmd/worker (driver selected by QUEUE_DRIVER)
+//
+// worker ไม่ผูกกับ broker ตัวใด: driver แต่ละตัว implement Queue (รับงาน, บันทึกผล, publish completion event)
+// ตอนนี้มี driver "mongodb" (collection analyzeJobs / analyzeJobEvents)
+// แต่ละ Queue ส่งเฉพาะงานของ priority class เดียว (interactive / bulk) → worker เปิดแยก pool ละ Queue
+// driver ของ RabbitMQ / Kafka / SQS เพิ่มได้ใน Open โดยไม่ต้องแก้ worker
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Supported drivers
+const (
+	DriverMongoDB = "mongodb"
+)
+
+// Job is one analyze-receipt request taken from the queue
+type Job struct {
+	ID       string
+	ShopID   string
+	Priority string // common.PriorityInteractive or PriorityBulk (the class of the Queue it came from)
+	Payload  []byte // analyze-receipt request body (JSON)
+	Attempt  int    // 1 = first delivery
+	handle   interface{}
+}
+
+// Result is the outcome of one attempt
+type Result struct {
+	Retry      bool   // Put the job back on the queue (transient failure)
+	RequestID  string // Analysis request (metadata.request_id)
+	StatusCode int    // HTTP status analyze-receipt returned
+	Body       []byte // analyze-receipt response body
+	Error      string // Error message of a failed attempt
+}
+
+// Queue delivers jobs to the worker and records their outcome
+type Queue interface {
+	// Receive returns the next job (nil = queue is empty); lease = how long the job stays reserved
+	Receive(ctx context.Context, lease time.Duration) (*Job, error)
+	// Complete stores the result and publishes the completion event
+	Complete(ctx context.Context, job *Job, result Result) error
+	// Close releases the driver's connections
+	Close() error
+}
+
+// Open creates the queue of a driver delivering the jobs of one priority class
+func Open(driver, workerID, priority string) (Queue, error) {
+	switch driver {
+	case DriverMongoDB:
+		return newMongoQueue(workerID, priority), nil
+	default:
+		return nil, fmt.Errorf("unsupported QUEUE_DRIVER %q (supported: %s)", driver, DriverMongoDB)
+	}
+}
@@ -0,0 +1,42 @@
+// priority.go - Share of the Gemini rate limiter per priority class (common.PriorityInteractive / PriorityBulk)
+//
+// งาน bulk ต้องไม่แย่ง quota ของผู้ใช้ที่รอผลอยู่:
+// bulk ใช้ token ของ rate limiter ได้ไม่เกิน BULK_RATE_SHARE ของ burst - ส่วนที่เหลือกันไว้ให้ interactive
+// class ของงานส่งต่อผ่าน context (WithPriority) ไปถึงทุก Gemini call
+
+package ratelimit
+
+import (
+	"context"
+	"math"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+)
+
+type priorityKey struct{}
+
+// WithPriority returns ctx carrying the priority class of the analysis
+func WithPriority(ctx context.Context, priority string) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFrom returns the priority class carried by ctx (interactive when unset)
+func PriorityFrom(ctx context.Context) string {
+	if priority, _ := ctx.Value(priorityKey{}).(string); priority == common.PriorityBulk {
+		return common.PriorityBulk
+	}
+	return common.PriorityInteractive
+}
+
+// reservedTokens is the part of the burst bulk calls may not take (BULK_RATE_SHARE)
+func reservedTokens(priority string, maxTokens int) int {
+	if priority != common.PriorityBulk {
+		return 0
+	}
+	share := configs.Get().BulkRateShare
+	if share <= 0 || share >= 1 {
+		return 0
+	}
+	return maxTokens - int(math.Ceil(float64(maxTokens)*share))
+}
@@ -3,6 +3,7 @@
 package ratelimit
 
 import (
+	"context"
 	"sync"
 	"time"
 )
@@ -28,46 +29,57 @@ func NewRateLimiter(maxTokens int, refillRate time.Duration) *RateLimiter {
 	}
 }
 
-// Wait blocks until a token is available
-func (rl *RateLimiter) Wait() {
+// Wait blocks until a token is available or ctx is done (returns ctx.Err())
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	return rl.waitAbove(ctx, 0)
+}
+
+// rateLimitPollInterval - how often a waiting caller checks for a refilled token
+const rateLimitPollInterval = 100 * time.Millisecond
+
+// waitAbove blocks until more than reserve tokens are available, then consumes one
+// (reserve > 0 keeps part of the burst for other callers - see priority.go)
+// A cancelled / expired ctx stops the wait without consuming a token
+func (rl *RateLimiter) waitAbove(ctx context.Context, reserve int) error {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
 	// Refill tokens based on time elapsed
-	now := time.Now()
-	elapsed := now.Sub(rl.lastRefillTime)
-	tokensToAdd := int(elapsed / rl.refillRate)
-
-	if tokensToAdd > 0 {
-		rl.tokens += tokensToAdd
-		if rl.tokens > rl.maxTokens {
-			rl.tokens = rl.maxTokens
-		}
-		rl.lastRefillTime = now
-	}
+	rl.refill()
 
 	// Wait until we have a token
-	for rl.tokens <= 0 {
+	for rl.tokens <= reserve {
 		rl.mu.Unlock()
-		time.Sleep(100 * time.Millisecond)
+		timer := time.NewTimer(rateLimitPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			rl.mu.Lock()
+			return ctx.Err()
+		case <-timer.C:
+		}
 		rl.mu.Lock()
 
 		// Refill again after waiting
-		now = time.Now()
-		elapsed = now.Sub(rl.lastRefillTime)
-		tokensToAdd = int(elapsed / rl.refillRate)
-
-		if tokensToAdd > 0 {
-			rl.tokens += tokensToAdd
-			if rl.tokens > rl.maxTokens {
-				rl.tokens = rl.maxTokens
-			}
-			rl.lastRefillTime = now
-		}
+		rl.refill()
 	}
 
 	// Consume one token
 	rl.tokens--
+	return nil
+}
+
+// refill adds the tokens earned since the last refill (caller holds mu)
+func (rl *RateLimiter) refill() {
+	now := time.Now()
+	tokensToAdd := int(now.Sub(rl.lastRefillTime) / rl.refillRate)
+	if tokensToAdd > 0 {
+		rl.tokens += tokensToAdd
+		if rl.tokens > rl.maxTokens {
+			rl.tokens = rl.maxTokens
+		}
+		rl.lastRefillTime = now
+	}
 }
 
 // Global rate limiter for Gemini API
@@ -78,7 +90,13 @@ func (rl *RateLimiter) Wait() {
 // This gives ~20% safety margin to handle network latency and burst traffic
 var globalRateLimiter = NewRateLimiter(12, 5*time.Second)
 
-// WaitForRateLimit waits if we're hitting rate limits
-func WaitForRateLimit() {
-	globalRateLimiter.Wait()
+// WaitForRateLimit waits if we're hitting rate limits - returns ctx.Err() when the request is cancelled or times out first
+// Bulk calls (ctx from WithPriority) only take tokens above the part reserved for interactive calls
+func WaitForRateLimit(ctx context.Context) error {
+	return globalRateLimiter.waitAbove(ctx, reservedTokens(PriorityFrom(ctx), globalRateLimiter.maxTokens))
+}
+
+// Limits returns the burst size and refill interval of the Gemini rate limiter (GET /api/v1/providers)
+func Limits() (maxRequests int, refillInterval time.Duration) {
+	return globalRateLimiter.maxTokens, globalRateLimiter.refillRate
 }
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWaitHonoursContext(t *testing.T) {
+	rl := NewRateLimiter(1, time.Hour)
+	if err := rl.Wait(context.Background()); err != nil {
+		t.Fatalf("first Wait: %v", err)
+	}
+
+	// Bucket empty for an hour - the wait must end with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := rl.Wait(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Wait returned after %v, want about 150ms", elapsed)
+	}
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if err := rl.Wait(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait error = %v, want context.Canceled", err)
+	}
+}
+
+func TestRateLimiterWaitRefills(t *testing.T) {
+	rl := NewRateLimiter(1, 50*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if err := rl.Wait(ctx); err != nil {
+			t.Fatalf("Wait %d: %v", i, err)
+		}
+	}
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	rl := NewRateLimiter(2, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	if err := rl.waitAbove(ctx, 1); err != nil {
+		t.Fatalf("waitAbove with 2 tokens: %v", err)
+	}
+	// 1 token left = the reserve - a bulk caller waits, an interactive caller takes it
+	if err := rl.waitAbove(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waitAbove on the reserve = %v, want context.DeadlineExceeded", err)
+	}
+	if err := rl.Wait(context.Background()); err != nil {
+		t.Errorf("Wait on the reserve: %v", err)
+	}
+}
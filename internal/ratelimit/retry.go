@@ -0,0 +1,316 @@
+// retry.go - Shared retry with backoff for every AI call site (Gemini / Mistral)
+//
+// ทุกจุดที่เรียก AI ใช้ Retry ตัวเดียวกัน แทน retry loop ที่เคยเขียนซ้ำกันหลายที่ (backoff ไม่เท่ากัน):
+// - exponential backoff + jitter (หลาย request ที่โดน 429 พร้อมกันจะไม่ยิงกลับพร้อมกัน)
+// - รอตาม Retry-After header / google.rpc.RetryInfo ของ server ถ้ามี
+// - จำกัดเวลารวม MaxElapsed และไม่รอเกิน deadline ของ context (phase timeout)
+// - เก็บสถิติแยกตามจุดเรียก (RetryStatsSnapshot → GET /api/v1/admin/flags)
+
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// RetryPolicy defines the attempts and waits of a call site
+type RetryPolicy struct {
+	MaxAttempts    int           // Attempts in total (1 = no retry - still counted in the stats)
+	InitialDelay   time.Duration // Wait before the 2nd attempt, multiplied by Multiplier for each further attempt
+	MaxDelay       time.Duration // Cap of the computed backoff (Retry-After from the server is not capped)
+	Multiplier     float64
+	RateLimitDelay time.Duration // First wait after a 429 without Retry-After (Gemini keeps rejecting for a while after a 429)
+	MaxElapsed     time.Duration // Budget for all attempts + waits (0 = limited by attempts / context only)
+	Jitter         float64       // Random ± share of every computed wait (0.2 = ±20%)
+}
+
+// DefaultRetryPolicy is used by every AI call that the request depends on
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialDelay:   2 * time.Second,
+	MaxDelay:       60 * time.Second,
+	Multiplier:     2.0,
+	RateLimitDelay: 20 * time.Second,
+	MaxElapsed:     2 * time.Minute,
+	Jitter:         0.2,
+}
+
+// SingleAttemptPolicy is for optional passes (verification, field locations) - never hold the request on 429
+var SingleAttemptPolicy = RetryPolicy{MaxAttempts: 1}
+
+// ErrRetryBudgetExceeded is wrapped when the next wait would pass MaxElapsed or the context deadline
+var ErrRetryBudgetExceeded = errors.New("retry budget exceeded")
+
+// RetryClass is how a failed attempt is treated
+type RetryClass struct {
+	Retryable   bool
+	RateLimited bool // 429 / resource exhausted
+}
+
+// HTTPStatusError is implemented by provider errors that carry the HTTP status code
+type HTTPStatusError interface {
+	HTTPStatus() int
+}
+
+// RetryAfterError is implemented by provider errors that carry the server's Retry-After
+type RetryAfterError interface {
+	RetryAfter() time.Duration
+}
+
+// RetryLogger receives retry warnings (*common.RequestContext implements it)
+type RetryLogger interface {
+	LogWarning(format string, args ...interface{})
+}
+
+// RetryCall describes one call site
+type RetryCall struct {
+	Site     string                 // Stats key, e.g. "gemini.accounting"
+	Policy   RetryPolicy            // Zero value = DefaultRetryPolicy
+	Classify func(error) RetryClass // nil = ClassifyError
+	Logger   RetryLogger            // nil = standard log
+}
+
+// ClassifyError is the default classification: HTTP status when known, otherwise the error text
+func ClassifyError(err error) RetryClass {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return RetryClass{}
+	}
+
+	status := 0
+	var apiErr *googleapi.Error
+	var statusErr HTTPStatusError
+	if errors.As(err, &apiErr) {
+		status = apiErr.Code
+	} else if errors.As(err, &statusErr) {
+		status = statusErr.HTTPStatus()
+	}
+	switch {
+	case status == http.StatusTooManyRequests:
+		return RetryClass{Retryable: true, RateLimited: true}
+	case status == http.StatusRequestTimeout || status >= 500:
+		return RetryClass{Retryable: true}
+	case status != 0:
+		return RetryClass{}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return RetryClass{Retryable: true}
+	}
+	errMsg := strings.ToLower(err.Error())
+	if strings.Contains(errMsg, "429") || strings.Contains(errMsg, "resource exhausted") {
+		return RetryClass{Retryable: true, RateLimited: true}
+	}
+	for _, pattern := range []string{"timeout", "deadline", "connection", "network", "unavailable"} {
+		if strings.Contains(errMsg, pattern) {
+			return RetryClass{Retryable: true}
+		}
+	}
+	return RetryClass{}
+}
+
+// ParseRetryAfter parses a Retry-After header value (delay in seconds or an HTTP date)
+func ParseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// retryAfter returns the wait requested by the server (0 = none)
+// Gemini sends it as google.rpc.RetryInfo {"retryDelay": "37s"} in the error details
+func retryAfter(err error) time.Duration {
+	var raErr RetryAfterError
+	if errors.As(err, &raErr) {
+		return raErr.RetryAfter()
+	}
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return 0
+	}
+	if wait := ParseRetryAfter(apiErr.Header.Get("Retry-After")); wait > 0 {
+		return wait
+	}
+	for _, detail := range apiErr.Details {
+		info, ok := detail.(map[string]interface{})
+		if !ok || !strings.HasSuffix(fmt.Sprint(info["@type"]), "google.rpc.RetryInfo") {
+			continue
+		}
+		if delay, ok := info["retryDelay"].(string); ok {
+			if wait, err := time.ParseDuration(delay); err == nil && wait > 0 {
+				return wait
+			}
+		}
+	}
+	return 0
+}
+
+// backoff computes the wait before the attempt after `attempt` (with jitter)
+func (p RetryPolicy) backoff(attempt int, class RetryClass) time.Duration {
+	base := p.InitialDelay
+	if class.RateLimited && p.RateLimitDelay > 0 {
+		base = p.RateLimitDelay
+	}
+	delay := float64(base)
+	for i := 1; i < attempt; i++ {
+		delay *= p.Multiplier
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
+
+// Retry runs fn until it succeeds, fails with a non-retryable error, or the attempts / budget run out
+// Returns the number of attempts made and the last error of fn
+// (wrapped with ErrRetryBudgetExceeded or the context error when waiting was cut short)
+func Retry(ctx context.Context, call RetryCall, fn func(attempt int) error) (int, error) {
+	policy := call.Policy
+	if policy.MaxAttempts <= 0 {
+		policy = DefaultRetryPolicy
+	}
+	classify := call.Classify
+	if classify == nil {
+		classify = ClassifyError
+	}
+	warn := log.Printf
+	if call.Logger != nil {
+		warn = call.Logger.LogWarning
+	}
+
+	stats := siteStats(call.Site)
+	stats.add(func(s *RetryStats) { s.Calls++ })
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		stats.add(func(s *RetryStats) { s.Attempts++ })
+		err := fn(attempt)
+		if err == nil {
+			stats.add(func(s *RetryStats) { s.Succeeded++ })
+			return attempt, nil
+		}
+
+		class := classify(err)
+		if class.RateLimited {
+			stats.add(func(s *RetryStats) { s.RateLimited++ })
+		}
+		if !class.Retryable || attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			stats.add(func(s *RetryStats) { s.Failed++ })
+			return attempt, err
+		}
+
+		// Step 1: Wait requested by the server, otherwise exponential backoff
+		wait := policy.backoff(attempt, class)
+		serverWait := retryAfter(err)
+		if serverWait > 0 {
+			wait = serverWait + time.Duration(float64(serverWait)*policy.Jitter*rand.Float64())
+		}
+
+		// Step 2: Give up now if the wait would pass the budget or the context deadline
+		exceeded := policy.MaxElapsed > 0 && time.Since(start)+wait > policy.MaxElapsed
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			exceeded = true
+		}
+		if exceeded {
+			stats.add(func(s *RetryStats) { s.Failed++; s.BudgetExceeded++ })
+			return attempt, fmt.Errorf("%w (%s: next wait %v after %d attempt(s)): %w", ErrRetryBudgetExceeded, call.Site, wait.Round(100*time.Millisecond), attempt, err)
+		}
+
+		if class.RateLimited {
+			warn("⚠️  [%s] Rate limit (429), waiting %v before retry (attempt %d/%d)", call.Site, wait.Round(100*time.Millisecond), attempt, policy.MaxAttempts)
+		} else {
+			warn("⚠️  [%s] %v - waiting %v before retry (attempt %d/%d)", call.Site, err, wait.Round(100*time.Millisecond), attempt, policy.MaxAttempts)
+		}
+		stats.add(func(s *RetryStats) {
+			s.Retries++
+			s.WaitMs += wait.Milliseconds()
+			if serverWait > 0 {
+				s.RetryAfterUsed++
+			}
+		})
+
+		select {
+		case <-ctx.Done():
+			stats.add(func(s *RetryStats) { s.Failed++ })
+			return attempt, fmt.Errorf("context canceled during retry wait: %w", ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RetryStats counts the retries of one call site since the process started
+type RetryStats struct {
+	Calls          int64 `json:"calls"`
+	Attempts       int64 `json:"attempts"`
+	Retries        int64 `json:"retries"`
+	RateLimited    int64 `json:"rate_limited"`     // Attempts answered with 429
+	RetryAfterUsed int64 `json:"retry_after_used"` // Waits taken from the server's Retry-After / RetryInfo
+	Succeeded      int64 `json:"succeeded"`
+	Failed         int64 `json:"failed"`
+	BudgetExceeded int64 `json:"budget_exceeded"` // Failed because the next wait passed MaxElapsed / the context deadline
+	WaitMs         int64 `json:"wait_ms"`         // Total time spent waiting between attempts
+}
+
+type siteRetryStats struct {
+	mu    sync.Mutex
+	stats RetryStats
+}
+
+func (s *siteRetryStats) add(update func(*RetryStats)) {
+	s.mu.Lock()
+	update(&s.stats)
+	s.mu.Unlock()
+}
+
+var (
+	retryStats   = map[string]*siteRetryStats{}
+	retryStatsMu sync.Mutex
+)
+
+func siteStats(site string) *siteRetryStats {
+	retryStatsMu.Lock()
+	defer retryStatsMu.Unlock()
+	stats, ok := retryStats[site]
+	if !ok {
+		stats = &siteRetryStats{}
+		retryStats[site] = stats
+	}
+	return stats
+}
+
+// RetryStatsSnapshot returns the stats of every call site (site → stats)
+func RetryStatsSnapshot() map[string]RetryStats {
+	retryStatsMu.Lock()
+	defer retryStatsMu.Unlock()
+	snapshot := make(map[string]RetryStats, len(retryStats))
+	for site, stats := range retryStats {
+		stats.mu.Lock()
+		snapshot[site] = stats.stats
+		stats.mu.Unlock()
+	}
+	return snapshot
+}
@@ -0,0 +1,184 @@
+// slipverify.go - Verify bank transfer slips with a slip-verification API
+//
+// สลิปโอนเงินปลอม/แก้ไขยอดเป็นปัญหาที่พบบ่อย → ส่ง QR บนสลิป (mini QR ของธนาคาร) ไปตรวจกับ
+// ผู้ให้บริการตรวจสลิป (ต่อกับ API ธนาคาร) เพื่อยืนยันว่ารายการโอนมีอยู่จริงและยอดตรงกับเอกสาร
+// เปิดใช้เป็นรายร้าน: shops.settings.slipverification.enabled + SLIP_VERIFY_API_URL
+
+package slipverify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/processor"
+	"github.com/bosocmputer/account_ocr_gemini/internal/storage"
+)
+
+// Verification statuses (document_analysis.slip_verification[].status)
+const (
+	StatusVerified       = "verified"        // Transfer exists and the amount matches the document
+	StatusAmountMismatch = "amount_mismatch" // Transfer exists but the amount differs from the document total
+	StatusNotFound       = "not_found"       // Bank has no such transfer - slip may be forged
+	StatusError          = "error"           // Verification API unavailable / invalid response (slip not judged)
+)
+
+// Result is the verification outcome for one slip QR
+type Result struct {
+	ImageIndex     int       `json:"image_index"`
+	Status         string    `json:"status"`
+	TransactionRef string    `json:"transaction_ref"`
+	SendingBank    string    `json:"sending_bank,omitempty"`
+	Amount         *float64  `json:"amount,omitempty"`          // Amount reported by the bank
+	ExpectedAmount float64   `json:"expected_amount,omitempty"` // Document total
+	TransferredAt  string    `json:"transferred_at,omitempty"`
+	SenderName     string    `json:"sender_name,omitempty"`
+	ReceiverName   string    `json:"receiver_name,omitempty"`
+	Message        string    `json:"message,omitempty"`
+	CheckedAt      time.Time `json:"checked_at"`
+}
+
+// NeedsReview reports whether the result should force a manual review
+func (r Result) NeedsReview() bool {
+	return r.Status == StatusAmountMismatch || r.Status == StatusNotFound
+}
+
+// verifyRequest is sent to SLIP_VERIFY_API_URL
+type verifyRequest struct {
+	Payload        string  `json:"payload"` // Raw QR text from the slip
+	TransactionRef string  `json:"trans_ref"`
+	SendingBank    string  `json:"sending_bank,omitempty"`
+	Amount         float64 `json:"amount,omitempty"`
+}
+
+// verifyResponse is the expected API response
+type verifyResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Data    *struct {
+		TransRef  string  `json:"transRef"`
+		Amount    float64 `json:"amount"`
+		TransDate string  `json:"transDate"`
+		Sender    struct {
+			Name string `json:"name"`
+		} `json:"sender"`
+		Receiver struct {
+			Name string `json:"name"`
+		} `json:"receiver"`
+	} `json:"data"`
+}
+
+// Enabled reports whether slip verification is on for the shop
+func Enabled(profile *storage.ShopProfile) bool {
+	return configs.SLIP_VERIFY_API_URL != "" && profile != nil && profile.Settings.SlipVerification.Enabled
+}
+
+// VerifySlips verifies every slip-verification QR in codes against the document total
+func VerifySlips(profile *storage.ShopProfile, codes []processor.DecodedCode, expectedAmount float64) []Result {
+	apiKey := configs.SLIP_VERIFY_API_KEY
+	if profile != nil && profile.Settings.SlipVerification.APIKey != "" {
+		apiKey = profile.Settings.SlipVerification.APIKey
+	}
+	client := &http.Client{Timeout: time.Duration(configs.SLIP_VERIFY_TIMEOUT_SEC) * time.Second}
+
+	var results []Result
+	for _, code := range codes {
+		if code.Kind != processor.CodeKindSlipVerification {
+			continue
+		}
+		results = append(results, verifySlip(client, apiKey, code, expectedAmount))
+	}
+	return results
+}
+
+// verifySlip calls the slip-verification API for one slip
+func verifySlip(client *http.Client, apiKey string, code processor.DecodedCode, expectedAmount float64) Result {
+	result := Result{
+		ImageIndex:     code.ImageIndex,
+		TransactionRef: code.TransactionRef,
+		SendingBank:    code.SendingBank,
+		ExpectedAmount: expectedAmount,
+		CheckedAt:      time.Now(),
+	}
+
+	body, err := json.Marshal(verifyRequest{
+		Payload:        code.Raw,
+		TransactionRef: code.TransactionRef,
+		SendingBank:    code.SendingBank,
+		Amount:         expectedAmount,
+	})
+	if err != nil {
+		result.Status = StatusError
+		result.Message = fmt.Sprintf("failed to build request: %v", err)
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(configs.SLIP_VERIFY_TIMEOUT_SEC)*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, configs.SLIP_VERIFY_API_URL, bytes.NewReader(body))
+	if err != nil {
+		result.Status = StatusError
+		result.Message = fmt.Sprintf("failed to build request: %v", err)
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Status = StatusError
+		result.Message = fmt.Sprintf("slip verification API unavailable: %v", err)
+		return result
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		result.Status = StatusError
+		result.Message = fmt.Sprintf("failed to read response: %v", err)
+		return result
+	}
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		result.Status = StatusError
+		result.Message = fmt.Sprintf("slip verification API returned HTTP %d", resp.StatusCode)
+		return result
+	}
+
+	var parsed verifyResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		result.Status = StatusError
+		result.Message = fmt.Sprintf("invalid response: %v", err)
+		return result
+	}
+	result.Message = parsed.Message
+
+	// Bank has no such transaction (API answered, but not success)
+	if !parsed.Success || parsed.Data == nil {
+		result.Status = StatusNotFound
+		return result
+	}
+
+	amount := parsed.Data.Amount
+	result.Amount = &amount
+	result.TransferredAt = parsed.Data.TransDate
+	result.SenderName = parsed.Data.Sender.Name
+	result.ReceiverName = parsed.Data.Receiver.Name
+	if parsed.Data.TransRef != "" {
+		result.TransactionRef = parsed.Data.TransRef
+	}
+
+	if expectedAmount > 0 && math.Abs(amount-expectedAmount) > 0.01 {
+		result.Status = StatusAmountMismatch
+		return result
+	}
+	result.Status = StatusVerified
+	return result
+}
@@ -0,0 +1,145 @@
+// ai_traces.go - Gzip-compressed AI interaction traces per request (ENABLE_AI_TRACES=true)
+
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const aiTracesCollection = "aiTraces"
+
+// aiTraceDocument is the stored form - traces are gzip-compressed JSON
+// (prompts with master data are large: ~100-300 KB per request before compression)
+type aiTraceDocument struct {
+	RequestID string    `bson:"request_id"`
+	ShopID    string    `bson:"shopid"`
+	Endpoint  string    `bson:"endpoint"`
+	Phases    []string  `bson:"phases"` // Phase of each trace (queryable without decompressing)
+	Payload   []byte    `bson:"payload"`
+	RawBytes  int       `bson:"raw_bytes"`
+	CreatedAt time.Time `bson:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// AITraceRecord is a decompressed trace set returned by the API
+type AITraceRecord struct {
+	RequestID string           `json:"request_id"`
+	ShopID    string           `json:"shopid"`
+	Endpoint  string           `json:"endpoint"`
+	CreatedAt time.Time        `json:"created_at"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	Traces    []common.AITrace `json:"traces"`
+}
+
+// ensureAITraceIndexes creates lookup + TTL indexes for AI traces
+func ensureAITraceIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(aiTracesCollection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "request_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", aiTracesCollection, err)
+	}
+	return nil
+}
+
+// SaveAITraces compresses and stores all AI interactions of a request
+func SaveAITraces(requestID, shopID, endpoint string, traces []common.AITrace) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	raw, err := json.Marshal(traces)
+	if err != nil {
+		return fmt.Errorf("failed to marshal AI traces: %w", err)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(raw); err != nil {
+		return fmt.Errorf("failed to compress AI traces: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress AI traces: %w", err)
+	}
+
+	phases := make([]string, 0, len(traces))
+	for _, t := range traces {
+		phases = append(phases, t.Phase)
+	}
+
+	now := time.Now()
+	doc := aiTraceDocument{
+		RequestID: requestID,
+		ShopID:    shopID,
+		Endpoint:  endpoint,
+		Phases:    phases,
+		Payload:   buf.Bytes(),
+		RawBytes:  len(raw),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(configs.AI_TRACE_TTL_DAYS) * 24 * time.Hour),
+	}
+
+	collection := mongoDB.Collection(aiTracesCollection)
+	if _, err := collection.InsertOne(ctx, doc); err != nil {
+		return fmt.Errorf("failed to insert AI traces: %w", err)
+	}
+	return nil
+}
+
+// GetAITraces loads and decompresses the traces of a request
+// Returns (nil, nil) if no traces were recorded (tracing disabled or expired)
+func GetAITraces(ctx context.Context, requestID string) (*AITraceRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var doc aiTraceDocument
+	collection := mongoDB.Collection(aiTracesCollection)
+	err := collection.FindOne(ctx, bson.M{"request_id": requestID}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find AI traces: %w", err)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(doc.Payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress AI traces: %w", err)
+	}
+	defer gz.Close()
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress AI traces: %w", err)
+	}
+
+	record := &AITraceRecord{
+		RequestID: doc.RequestID,
+		ShopID:    doc.ShopID,
+		Endpoint:  doc.Endpoint,
+		CreatedAt: doc.CreatedAt,
+		ExpiresAt: doc.ExpiresAt,
+	}
+	if err := json.Unmarshal(raw, &record.Traces); err != nil {
+		return nil, fmt.Errorf("failed to decode AI traces: %w", err)
+	}
+	return record, nil
+}
@@ -0,0 +1,212 @@
+// analyze_jobs.go - MongoDB-backed analyze job queue (QUEUE_DRIVER=mongodb) consumed by cmd/worker
+//
+// producer insert เอกสารลง analyzeJobs (status queued, payload = body ของ analyze-receipt)
+// priority (interactive / bulk, ว่าง = interactive) แยก worker pool: แต่ละ pool claim เฉพาะงานของ class ตัวเอง
+// worker claim งานแบบ lease (locked_until) → งานของ worker ที่ตายกลางทางถูก claim ใหม่เมื่อ lease หมด
+// เมื่อเสร็จ: เก็บผลใน job + เพิ่ม event ใน analyzeJobEvents (consumer อ่านต่อด้วย change stream / polling)
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	analyzeJobsCollection      = "analyzeJobs"
+	analyzeJobEventsCollection = "analyzeJobEvents"
+)
+
+// Analyze job statuses
+const (
+	AnalyzeJobStatusQueued     = "queued"
+	AnalyzeJobStatusProcessing = "processing"
+	AnalyzeJobStatusDone       = "done"
+	AnalyzeJobStatusFailed     = "failed"
+)
+
+// AnalyzeJob is one queued analyze-receipt request
+type AnalyzeJob struct {
+	JobID       string    `bson:"job_id" json:"job_id"`
+	ShopID      string    `bson:"shopid" json:"shopid"`
+	Priority    string    `bson:"priority,omitempty" json:"priority,omitempty"` // common.PriorityInteractive (default) or PriorityBulk
+	Payload     string    `bson:"payload" json:"payload"`                       // analyze-receipt request body (JSON)
+	Status      string    `bson:"status" json:"status"`                         // queued, processing, done, failed
+	Attempts    int       `bson:"attempts" json:"attempts"`
+	WorkerID    string    `bson:"worker_id,omitempty" json:"worker_id,omitempty"`
+	LockedUntil time.Time `bson:"locked_until,omitempty" json:"locked_until,omitempty"` // Lease of the worker processing the job
+	RequestID   string    `bson:"request_id,omitempty" json:"request_id,omitempty"`     // Analysis request of the last attempt
+	StatusCode  int       `bson:"status_code,omitempty" json:"status_code,omitempty"`   // HTTP status analyze-receipt returned
+	Result      string    `bson:"result,omitempty" json:"result,omitempty"`             // analyze-receipt response body (JSON)
+	Error       string    `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// AnalyzeJobEvent is published when a job finishes (done / failed) or is queued again for a retry
+type AnalyzeJobEvent struct {
+	JobID      string    `bson:"job_id" json:"job_id"`
+	ShopID     string    `bson:"shopid" json:"shopid"`
+	Status     string    `bson:"status" json:"status"`
+	Attempts   int       `bson:"attempts" json:"attempts"`
+	RequestID  string    `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	StatusCode int       `bson:"status_code" json:"status_code"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+}
+
+// ensureAnalyzeJobIndexes creates the unique job_id index and the claim index
+func ensureAnalyzeJobIndexes(ctx context.Context) error {
+	_, err := mongoDB.Collection(analyzeJobsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "job_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "priority", Value: 1}, {Key: "created_at", Value: 1}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", analyzeJobsCollection, err)
+	}
+	_, err = mongoDB.Collection(analyzeJobEventsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "job_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", analyzeJobEventsCollection, err)
+	}
+	return nil
+}
+
+// ClaimAnalyzeJob takes the oldest queued job of a priority class (or one whose lease expired) for workerID
+// Returns nil when the queue is empty
+func ClaimAnalyzeJob(ctx context.Context, workerID, priority string, lease time.Duration) (*AnalyzeJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"$or": []bson.M{
+			{"status": AnalyzeJobStatusQueued},
+			{"status": AnalyzeJobStatusProcessing, "locked_until": bson.M{"$lt": now}},
+		},
+		"priority": analyzeJobPriorityFilter(priority),
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":       AnalyzeJobStatusProcessing,
+			"worker_id":    workerID,
+			"locked_until": now.Add(lease),
+			"updated_at":   now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job AnalyzeJob
+	err := mongoDB.Collection(analyzeJobsCollection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim analyze job: %w", err)
+	}
+	return &job, nil
+}
+
+// analyzeJobPriorityFilter matches the jobs of a class (jobs without priority are interactive)
+func analyzeJobPriorityFilter(priority string) interface{} {
+	if priority == common.PriorityBulk {
+		return common.PriorityBulk
+	}
+	return bson.M{"$ne": common.PriorityBulk}
+}
+
+// CountProcessingAnalyzeJobs counts the jobs of a shop and class being processed under a valid lease
+func CountProcessingAnalyzeJobs(ctx context.Context, shopID, priority string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	count, err := mongoDB.Collection(analyzeJobsCollection).CountDocuments(ctx, bson.M{
+		"shopid":       shopID,
+		"status":       AnalyzeJobStatusProcessing,
+		"locked_until": bson.M{"$gte": time.Now()},
+		"priority":     analyzeJobPriorityFilter(priority),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count processing analyze jobs: %w", err)
+	}
+	return count, nil
+}
+
+// DemoteAnalyzeJob puts a claimed job back on the queue as bulk (the claim does not count as an attempt)
+func DemoteAnalyzeJob(ctx context.Context, job AnalyzeJob) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := mongoDB.Collection(analyzeJobsCollection).UpdateOne(ctx,
+		bson.M{"job_id": job.JobID, "worker_id": job.WorkerID},
+		bson.M{
+			"$set":   bson.M{"status": AnalyzeJobStatusQueued, "priority": common.PriorityBulk, "updated_at": time.Now()},
+			"$unset": bson.M{"locked_until": ""},
+			"$inc":   bson.M{"attempts": -1},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to demote analyze job: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("analyze job %s was claimed by another worker (lease expired)", job.JobID)
+	}
+	return nil
+}
+
+// FinishAnalyzeJob stores the outcome of an attempt (status queued = retry later) and publishes the event
+func FinishAnalyzeJob(job AnalyzeJob) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{
+		"status":      job.Status,
+		"request_id":  job.RequestID,
+		"status_code": job.StatusCode,
+		"result":      job.Result,
+		"error":       job.Error,
+		"updated_at":  now,
+	}
+	result, err := mongoDB.Collection(analyzeJobsCollection).UpdateOne(ctx,
+		bson.M{"job_id": job.JobID, "worker_id": job.WorkerID}, // Lost lease → another worker owns the job now
+		bson.M{"$set": set, "$unset": bson.M{"locked_until": ""}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update analyze job: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("analyze job %s was claimed by another worker (lease expired)", job.JobID)
+	}
+
+	event := AnalyzeJobEvent{
+		JobID:      job.JobID,
+		ShopID:     job.ShopID,
+		Status:     job.Status,
+		Attempts:   job.Attempts,
+		RequestID:  job.RequestID,
+		StatusCode: job.StatusCode,
+		Error:      job.Error,
+		CreatedAt:  now,
+	}
+	if _, err := mongoDB.Collection(analyzeJobEventsCollection).InsertOne(ctx, event); err != nil {
+		return fmt.Errorf("failed to publish analyze job event: %w", err)
+	}
+	return nil
+}
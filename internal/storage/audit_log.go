@@ -0,0 +1,145 @@
+// audit_log.go - Who did what: one record per API call (actor, endpoint, request_id, outcome, cost)
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const auditLogCollection = "auditLog"
+
+// Audit actors
+const (
+	AuditActorAdmin     = "admin"     // Authenticated with ADMIN_API_KEY
+	AuditActorShop      = "shop"      // Acted on a shop (shopid in the path or body)
+	AuditActorAnonymous = "anonymous" // No shop and no admin key
+)
+
+// Audit outcomes
+const (
+	AuditOutcomeSuccess  = "success"  // 2xx / 3xx
+	AuditOutcomeRejected = "rejected" // 4xx
+	AuditOutcomeAborted  = "aborted"  // 499 client closed the request
+	AuditOutcomeError    = "error"    // 5xx
+)
+
+// AuditEntry is one API call
+type AuditEntry struct {
+	Actor      string    `bson:"actor" json:"actor"`                               // admin, shop, anonymous
+	APIKey     string    `bson:"api_key,omitempty" json:"api_key,omitempty"`       // Fingerprint of the bearer key (never the key itself)
+	ShopID     string    `bson:"shopid,omitempty" json:"shopid,omitempty"`         // Shop the call acted on
+	Method     string    `bson:"method" json:"method"`                             // HTTP method
+	Endpoint   string    `bson:"endpoint" json:"endpoint"`                         // Route template, e.g. /api/v1/shops/:shopid/settings
+	Path       string    `bson:"path" json:"path"`                                 // Actual URL path
+	RequestID  string    `bson:"request_id,omitempty" json:"request_id,omitempty"` // Analysis request (analyze-receipt, ocr, ...)
+	StatusCode int       `bson:"status_code" json:"status_code"`                   // HTTP status of the response
+	Outcome    string    `bson:"outcome" json:"outcome"`                           // success, rejected, aborted, error
+	CostTHB    float64   `bson:"cost_thb" json:"cost_thb"`                         // AI cost of the call (0 = no AI call)
+	CostUSD    float64   `bson:"cost_usd" json:"cost_usd"`                         // AI cost of the call in USD
+	DurationMs int64     `bson:"duration_ms" json:"duration_ms"`                   // Time to respond
+	ClientIP   string    `bson:"client_ip" json:"client_ip"`                       // Caller address
+	UserAgent  string    `bson:"user_agent,omitempty" json:"user_agent,omitempty"` // Caller user agent
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`                     // When the call finished
+	ExpiresAt  time.Time `bson:"expires_at" json:"expires_at"`                     // TTL index removes the record after this time
+}
+
+// AuditQuery filters the audit log (empty / zero = any)
+type AuditQuery struct {
+	ShopID    string
+	Actor     string
+	RequestID string
+	Endpoint  string
+	From      time.Time // Inclusive
+	To        time.Time // Exclusive
+	Limit     int
+}
+
+// ensureAuditLogIndexes creates the query indexes and the TTL index
+func ensureAuditLogIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(auditLogCollection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "shopid", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "request_id", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", auditLogCollection, err)
+	}
+	return nil
+}
+
+// RecordAudit stores one API call for AUDIT_LOG_TTL_DAYS
+func RecordAudit(entry AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	entry.CreatedAt = time.Now()
+	entry.ExpiresAt = entry.CreatedAt.Add(time.Duration(configs.AUDIT_LOG_TTL_DAYS) * 24 * time.Hour)
+
+	collection := mongoDB.Collection(auditLogCollection)
+	if _, err := collection.InsertOne(ctx, entry); err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// ListAuditEntries returns the newest audit entries matching the query
+func ListAuditEntries(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if query.ShopID != "" {
+		filter["shopid"] = query.ShopID
+	}
+	if query.Actor != "" {
+		filter["actor"] = query.Actor
+	}
+	if query.RequestID != "" {
+		filter["request_id"] = query.RequestID
+	}
+	if query.Endpoint != "" {
+		filter["endpoint"] = query.Endpoint
+	}
+	createdAt := bson.M{}
+	if !query.From.IsZero() {
+		createdAt["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		createdAt["$lt"] = query.To
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	collection := mongoDB.Collection(auditLogCollection)
+	cursor, err := collection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(query.Limit)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query auditLog: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	entries := []AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode auditLog: %w", err)
+	}
+	return entries, nil
+}
@@ -3,10 +3,13 @@
 package storage
 
 import (
+	"context"
+	"log"
 	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // MasterDataCache stores frequently accessed master data
@@ -16,22 +19,41 @@ type MasterDataCache struct {
 	Creditors    []bson.M
 	Debtors      []bson.M     // เพิ่มลูกหนี้
 	ShopProfile  *ShopProfile // เพิ่มข้อมูลบริษัท
+	// JournalBookRules - กฎเลือกสมุดรายวันของร้าน (เรียงตาม priority)
+	JournalBookRules []JournalBookRule
+	// VendorAccountMappings - บัญชีที่ผู้ใช้อนุมัติแล้วต่อเจ้าหนี้ (key = creditor code)
+	VendorAccountMappings map[string]VendorAccountMapping
+	// CreditorAliases - ชื่อเรียกอื่นของเจ้าหนี้ (ชื่อแบรนด์) ที่ vendor matching ตรวจก่อน fuzzy matching
+	CreditorAliases []CreditorAlias
+	// CreditorBranches - สาขาของเจ้าหนี้ที่เคยพบ / ลงทะเบียนไว้ (key = creditor code)
+	CreditorBranches map[string][]CreditorBranch
+	// Dimensions - ค่า dimension (โครงการ / ศูนย์ต้นทุน) ของร้าน (key = dimension key)
+	Dimensions map[string][]Dimension
+	// ShopSettings - model / threshold ที่ร้านกำหนดเอง (nil = ใช้ค่า config)
+	ShopSettings *ShopSettings
 	LoadedAt     time.Time
 	ShopID       string
 	mu           sync.RWMutex
 }
 
-// Global cache map: shopID -> cache
-var masterDataCacheMap = make(map[string]*MasterDataCache)
-var cacheMutex sync.RWMutex
+// masterDataCacheSet caches master data per shop (one set per Store → per database)
+type masterDataCacheSet struct {
+	mu     sync.RWMutex
+	byShop map[string]*MasterDataCache
+}
+
+func newMasterDataCacheSet() *masterDataCacheSet {
+	return &masterDataCacheSet{byShop: make(map[string]*MasterDataCache)}
+}
 
 const CACHE_TTL = 5 * time.Minute // Cache expires after 5 minutes
 
-// GetOrLoadMasterData retrieves master data from cache or loads from DB
-func GetOrLoadMasterData(shopID string) (*MasterDataCache, error) {
-	cacheMutex.RLock()
-	cache, exists := masterDataCacheMap[shopID]
-	cacheMutex.RUnlock()
+// getOrLoad retrieves master data from cache or loads it from db (service settings from serviceDB)
+// ctx = request context: a cancelled request stops the load (nothing is cached, the next request loads again)
+func (set *masterDataCacheSet) getOrLoad(ctx context.Context, db, serviceDB *mongo.Database, shopID string) (*MasterDataCache, error) {
+	set.mu.RLock()
+	cache, exists := set.byShop[shopID]
+	set.mu.RUnlock()
 
 	// Check if cache exists and is still valid
 	if exists && time.Since(cache.LoadedAt) < CACHE_TTL {
@@ -39,66 +61,122 @@ func GetOrLoadMasterData(shopID string) (*MasterDataCache, error) {
 	}
 
 	// Cache expired or doesn't exist - load from DB
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
+	set.mu.Lock()
+	defer set.mu.Unlock()
 
 	// Double-check after acquiring write lock
-	cache, exists = masterDataCacheMap[shopID]
+	cache, exists = set.byShop[shopID]
 	if exists && time.Since(cache.LoadedAt) < CACHE_TTL {
 		return cache, nil
 	}
 
 	// Load fresh data from MongoDB
-	accounts, err := GetChartOfAccounts(shopID, bson.M{})
+	accounts, err := getChartOfAccounts(ctx, db, shopID, bson.M{})
 	if err != nil {
 		return nil, err
 	}
 
-	journalBooks, err := GetJournalBooks(shopID, bson.M{})
+	journalBooks, err := getJournalBooks(ctx, db, shopID, bson.M{})
 	if err != nil {
 		return nil, err
 	}
 
-	creditors, err := GetCreditors(shopID, bson.M{})
+	creditors, err := getCreditors(ctx, db, shopID, bson.M{})
 	if err != nil {
 		return nil, err
 	}
 
-	debtors, err := GetDebtors(shopID, bson.M{})
+	debtors, err := getDebtors(ctx, db, shopID, bson.M{})
 	if err != nil {
 		return nil, err
 	}
 
-	shopProfile, err := GetShopProfile(shopID)
+	shopProfile, err := getShopProfile(ctx, db, shopID)
 	if err != nil {
 		return nil, err
 	}
 
+	// Journal book rules are optional - without them the AI's choice is kept
+	journalBookRules, err := getJournalBookRules(ctx, serviceDB, shopID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load journal book rules for shop %s: %v", shopID, err)
+		journalBookRules = []JournalBookRule{}
+	}
+
+	// Learned vendor mappings are optional - without them the AI / template decides
+	vendorAccountMappings := map[string]VendorAccountMapping{}
+	if mappings, err := getVendorAccountMappings(ctx, serviceDB, shopID); err != nil {
+		log.Printf("⚠️  Failed to load vendor account mappings for shop %s: %v", shopID, err)
+	} else {
+		for _, m := range mappings {
+			vendorAccountMappings[m.CreditorCode] = m
+		}
+	}
+
+	// Creditor aliases are optional - without them only tax ID / fuzzy name matching is used
+	creditorAliases, err := getCreditorAliases(ctx, serviceDB, shopID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load creditor aliases for shop %s: %v", shopID, err)
+		creditorAliases = []CreditorAlias{}
+	}
+
+	// Creditor branches are optional - without them matched branches are reported as not registered
+	creditorBranches := map[string][]CreditorBranch{}
+	if branches, err := getCreditorBranches(ctx, serviceDB, shopID, ""); err != nil {
+		log.Printf("⚠️  Failed to load creditor branches for shop %s: %v", shopID, err)
+	} else {
+		for _, b := range branches {
+			creditorBranches[b.CreditorCode] = append(creditorBranches[b.CreditorCode], b)
+		}
+	}
+
+	// Dimensions are optional - without them dimension values cannot be validated (reported as unknown)
+	dimensions := map[string][]Dimension{}
+	if values, err := getDimensions(ctx, serviceDB, shopID, ""); err != nil {
+		log.Printf("⚠️  Failed to load dimensions for shop %s: %v", shopID, err)
+	} else {
+		for _, d := range values {
+			dimensions[d.Key] = append(dimensions[d.Key], d)
+		}
+	}
+
+	// Shop settings are optional - without them the global config is used
+	shopSettings, err := getShopSettings(ctx, serviceDB, shopID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load shop settings for shop %s: %v", shopID, err)
+	}
+
 	// Create new cache
 	newCache := &MasterDataCache{
-		Accounts:     accounts,
-		JournalBooks: journalBooks,
-		Creditors:    creditors,
-		Debtors:      debtors,
-		ShopProfile:  shopProfile,
-		LoadedAt:     time.Now(),
-		ShopID:       shopID,
+		Accounts:              accounts,
+		JournalBooks:          journalBooks,
+		Creditors:             creditors,
+		Debtors:               debtors,
+		ShopProfile:           shopProfile,
+		JournalBookRules:      journalBookRules,
+		VendorAccountMappings: vendorAccountMappings,
+		CreditorAliases:       creditorAliases,
+		CreditorBranches:      creditorBranches,
+		Dimensions:            dimensions,
+		ShopSettings:          shopSettings,
+		LoadedAt:              time.Now(),
+		ShopID:                shopID,
 	}
 
-	masterDataCacheMap[shopID] = newCache
+	set.byShop[shopID] = newCache
 	return newCache, nil
 }
 
-// InvalidateCache removes cache for a specific shop
-func InvalidateCache(shopID string) {
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
-	delete(masterDataCacheMap, shopID)
+// invalidate removes cache for a specific shop
+func (set *masterDataCacheSet) invalidate(shopID string) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	delete(set.byShop, shopID)
 }
 
-// ClearAllCache removes all cached data
-func ClearAllCache() {
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
-	masterDataCacheMap = make(map[string]*MasterDataCache)
+// clear removes all cached data
+func (set *masterDataCacheSet) clear() {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.byShop = make(map[string]*MasterDataCache)
 }
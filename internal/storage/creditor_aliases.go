@@ -0,0 +1,99 @@
+// creditor_aliases.go - Per-shop alternative names of creditors (brand / short name → creditor)
+//
+// ชื่อบนใบเสร็จมักเป็นชื่อแบรนด์ ("7-Eleven") ไม่ใช่ชื่อนิติบุคคลในทะเบียนเจ้าหนี้
+// ("บริษัท ซีพี ออลล์ จำกัด (มหาชน)") → fuzzy matching จับคู่ไม่ได้ ผู้ใช้จึงลงทะเบียนชื่อเรียกไว้
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const creditorAliasesCollection = "creditorAliases"
+
+// CreditorAlias - documents showing Alias belong to CreditorCode
+type CreditorAlias struct {
+	ShopID       string    `bson:"shopid" json:"-"`
+	Alias        string    `bson:"alias" json:"alias"`
+	CreditorCode string    `bson:"creditor_code" json:"creditor_code"`
+	CreditorName string    `bson:"creditor_name" json:"creditor_name"`
+	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// ensureCreditorAliasIndexes creates the unique (shop, alias) index
+func ensureCreditorAliasIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(creditorAliasesCollection)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "shopid", Value: 1}, {Key: "alias", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", creditorAliasesCollection, err)
+	}
+	return nil
+}
+
+// GetCreditorAliases returns all aliases of a shop ordered by creditor code
+func GetCreditorAliases(ctx context.Context, shopID string) ([]CreditorAlias, error) {
+	return getCreditorAliases(ctx, mongoDB, shopID)
+}
+
+func getCreditorAliases(ctx context.Context, db *mongo.Database, shopID string) ([]CreditorAlias, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := db.Collection(creditorAliasesCollection).Find(ctx, bson.M{"shopid": shopID},
+		options.Find().SetSort(bson.D{{Key: "creditor_code", Value: 1}, {Key: "alias", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query creditorAliases: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	aliases := []CreditorAlias{}
+	if err := cursor.All(ctx, &aliases); err != nil {
+		return nil, fmt.Errorf("failed to decode creditorAliases: %w", err)
+	}
+	return aliases, nil
+}
+
+// SaveCreditorAlias creates an alias or moves an existing alias to another creditor
+func SaveCreditorAlias(alias CreditorAlias) (*CreditorAlias, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"creditor_code": alias.CreditorCode,
+			"creditor_name": alias.CreditorName,
+			"updated_at":    now,
+		},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	filter := bson.M{"shopid": alias.ShopID, "alias": alias.Alias}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var saved CreditorAlias
+	if err := mongoDB.Collection(creditorAliasesCollection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("failed to save creditor alias: %w", err)
+	}
+	return &saved, nil
+}
+
+// DeleteCreditorAlias removes an alias (false = not found)
+func DeleteCreditorAlias(shopID, alias string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := mongoDB.Collection(creditorAliasesCollection).DeleteOne(ctx, bson.M{"shopid": shopID, "alias": alias})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete creditor alias: %w", err)
+	}
+	return result.DeletedCount > 0, nil
+}
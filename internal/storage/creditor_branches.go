@@ -0,0 +1,122 @@
+// creditor_branches.go - Branches (สาขา) seen / registered per creditor
+//
+// ใบกำกับภาษีระบุสาขาของผู้ออก (สาขาที่ 00123, สำนักงานใหญ่ = 00000) - รายงานภาษีซื้อต้องแยกตามสาขา
+// ทะเบียนเจ้าหนี้มาจากระบบบัญชีของร้าน จึงเก็บสาขาไว้ใน collection ของ service แทน
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const creditorBranchesCollection = "creditorBranches"
+
+// HeadOfficeBranchCode is the branch code of a head office (สำนักงานใหญ่) on tax invoices
+const HeadOfficeBranchCode = "00000"
+
+// CreditorBranch is one branch of a creditor (recorded from analyzed documents or registered by a user)
+type CreditorBranch struct {
+	ShopID        string    `bson:"shopid" json:"-"`
+	CreditorCode  string    `bson:"creditor_code" json:"creditor_code"`
+	BranchCode    string    `bson:"branch_code" json:"branch_code"` // 5 digits, 00000 = head office
+	BranchName    string    `bson:"branch_name,omitempty" json:"branch_name,omitempty"`
+	Documents     int       `bson:"documents" json:"documents"` // Analyzed documents from this branch
+	LastRequestID string    `bson:"last_request_id,omitempty" json:"last_request_id,omitempty"`
+	CreatedAt     time.Time `bson:"created_at" json:"created_at"`
+	LastSeenAt    time.Time `bson:"last_seen_at,omitempty" json:"last_seen_at,omitempty"`
+}
+
+// ensureCreditorBranchIndexes creates the unique (shop, creditor, branch) index
+func ensureCreditorBranchIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(creditorBranchesCollection)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "shopid", Value: 1}, {Key: "creditor_code", Value: 1}, {Key: "branch_code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", creditorBranchesCollection, err)
+	}
+	return nil
+}
+
+// GetCreditorBranches returns the branches of a shop's creditors ("" creditorCode = all creditors)
+func GetCreditorBranches(ctx context.Context, shopID, creditorCode string) ([]CreditorBranch, error) {
+	return getCreditorBranches(ctx, mongoDB, shopID, creditorCode)
+}
+
+func getCreditorBranches(ctx context.Context, db *mongo.Database, shopID, creditorCode string) ([]CreditorBranch, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"shopid": shopID}
+	if creditorCode != "" {
+		filter["creditor_code"] = creditorCode
+	}
+	cursor, err := db.Collection(creditorBranchesCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "creditor_code", Value: 1}, {Key: "branch_code", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query creditorBranches: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	branches := []CreditorBranch{}
+	if err := cursor.All(ctx, &branches); err != nil {
+		return nil, fmt.Errorf("failed to decode creditorBranches: %w", err)
+	}
+	return branches, nil
+}
+
+// RecordCreditorBranch counts a document from a creditor's branch (the branch is created on first sight)
+func RecordCreditorBranch(shopID, creditorCode, branchCode, requestID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"shopid": shopID, "creditor_code": creditorCode, "branch_code": branchCode}
+	update := bson.M{
+		"$inc":         bson.M{"documents": 1},
+		"$set":         bson.M{"last_request_id": requestID, "last_seen_at": now},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	if _, err := mongoDB.Collection(creditorBranchesCollection).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+		return fmt.Errorf("failed to record creditor branch: %w", err)
+	}
+	return nil
+}
+
+// SaveCreditorBranch registers a branch of a creditor or renames it (documents count is kept)
+func SaveCreditorBranch(branch CreditorBranch) (*CreditorBranch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"shopid": branch.ShopID, "creditor_code": branch.CreditorCode, "branch_code": branch.BranchCode}
+	update := bson.M{
+		"$set":         bson.M{"branch_name": branch.BranchName},
+		"$setOnInsert": bson.M{"documents": 0, "created_at": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var saved CreditorBranch
+	if err := mongoDB.Collection(creditorBranchesCollection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("failed to save creditor branch: %w", err)
+	}
+	return &saved, nil
+}
+
+// DeleteCreditorBranch removes a branch of a creditor (false = not found)
+func DeleteCreditorBranch(shopID, creditorCode, branchCode string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := mongoDB.Collection(creditorBranchesCollection).DeleteOne(ctx,
+		bson.M{"shopid": shopID, "creditor_code": creditorCode, "branch_code": branchCode})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete creditor branch: %w", err)
+	}
+	return result.DeletedCount > 0, nil
+}
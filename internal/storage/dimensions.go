@@ -0,0 +1,107 @@
+// dimensions.go - Dimension values (project / cost center / ...) booked on journal lines
+//
+// ผังบัญชีบอกแค่ "บัญชีอะไร" - ร้านที่ต้องแยกต้นทุนตามโครงการ / ศูนย์ต้นทุน ต้องระบุ dimension ต่อรายการบัญชี
+// ประเภท dimension ที่ร้านใช้กำหนดใน shopSettings.dimensions, ค่าที่ใช้ได้ (รหัส + ชื่อ + คำค้น) เก็บใน collection นี้
+// ระบบบัญชีของร้านไม่มีข้อมูลนี้ จึงเก็บใน database ของ service (เหมือน creditorBranches)
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const dimensionsCollection = "dimensions"
+
+// Dimension is one value of a dimension (e.g. project P001 "โครงการบ้านสวน")
+type Dimension struct {
+	ShopID    string    `bson:"shopid" json:"-"`
+	Key       string    `bson:"key" json:"key"` // shopSettings.dimensions[].key (project, cost_center, ...)
+	Code      string    `bson:"code" json:"code"`
+	Name      string    `bson:"name" json:"name"`
+	Keywords  []string  `bson:"keywords,omitempty" json:"keywords,omitempty"` // Text on the document that selects this value (rule)
+	Inactive  bool      `bson:"inactive,omitempty" json:"inactive,omitempty"` // Closed project - no longer accepted on new entries
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// ensureDimensionIndexes creates the unique (shop, key, code) index
+func ensureDimensionIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(dimensionsCollection)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "shopid", Value: 1}, {Key: "key", Value: 1}, {Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", dimensionsCollection, err)
+	}
+	return nil
+}
+
+// GetDimensions returns the dimension values of a shop ("" key = all dimensions)
+func GetDimensions(ctx context.Context, shopID, key string) ([]Dimension, error) {
+	return getDimensions(ctx, mongoDB, shopID, key)
+}
+
+func getDimensions(ctx context.Context, db *mongo.Database, shopID, key string) ([]Dimension, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"shopid": shopID}
+	if key != "" {
+		filter["key"] = key
+	}
+	cursor, err := db.Collection(dimensionsCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "key", Value: 1}, {Key: "code", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dimensions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	dimensions := []Dimension{}
+	if err := cursor.All(ctx, &dimensions); err != nil {
+		return nil, fmt.Errorf("failed to decode dimensions: %w", err)
+	}
+	return dimensions, nil
+}
+
+// SaveDimension creates or replaces a dimension value (created_at is kept)
+func SaveDimension(dimension Dimension) (*Dimension, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"shopid": dimension.ShopID, "key": dimension.Key, "code": dimension.Code}
+	update := bson.M{
+		"$set": bson.M{
+			"name":       dimension.Name,
+			"keywords":   dimension.Keywords,
+			"inactive":   dimension.Inactive,
+			"updated_at": now,
+		},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var saved Dimension
+	if err := mongoDB.Collection(dimensionsCollection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("failed to save dimension: %w", err)
+	}
+	return &saved, nil
+}
+
+// DeleteDimension removes a dimension value (false = not found)
+func DeleteDimension(shopID, key, code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := mongoDB.Collection(dimensionsCollection).DeleteOne(ctx, bson.M{"shopid": shopID, "key": key, "code": code})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete dimension: %w", err)
+	}
+	return result.DeletedCount > 0, nil
+}
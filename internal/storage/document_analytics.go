@@ -0,0 +1,391 @@
+// document_analytics.go - Per-document template matching outcome for coverage reports and threshold tuning
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const documentAnalyticsCollection = "documentAnalytics"
+
+// Analysis modes (which master data was sent to Phase 3)
+const (
+	AnalysisModeTemplateOnly = "template_only"
+	AnalysisModeFull         = "full"
+)
+
+// AnalyticsEntry is one journal entry line of an analyzed document (amounts are not kept)
+type AnalyticsEntry struct {
+	AccountCode string `bson:"account_code" json:"account_code"`
+	AccountName string `bson:"account_name" json:"account_name"`
+	Side        string `bson:"side" json:"side"` // debit or credit
+}
+
+// DocumentAnalyticsRecord is the outcome of one analyzed document
+// Template fields hold the best candidate of the template matcher - also in full mode (near miss)
+type DocumentAnalyticsRecord struct {
+	RequestID          string  `bson:"request_id" json:"request_id"`
+	ShopID             string  `bson:"shopid" json:"shopid"`
+	Mode               string  `bson:"mode" json:"mode"` // template_only or full
+	TemplateID         string  `bson:"template_id" json:"template_id"`
+	TemplateName       string  `bson:"template_name" json:"template_name"`
+	TemplateConfidence float64 `bson:"template_confidence" json:"template_confidence"`
+	OverallConfidence  float64 `bson:"overall_confidence" json:"overall_confidence"`
+	DocumentType       string  `bson:"document_type" json:"document_type"`
+	VendorName         string  `bson:"vendor_name" json:"vendor_name"`
+	VendorTaxID        string  `bson:"vendor_tax_id" json:"vendor_tax_id"`
+	VendorKey          string  `bson:"vendor_key" json:"vendor_key"` // Tax ID, or lowercased vendor name ("" = unknown)
+	RequiresReview     bool    `bson:"requires_review" json:"requires_review"`
+	// Entries + AccountSignature ("C:211101|D:531201") identify recurring patterns for template suggestions
+	Entries          []AnalyticsEntry `bson:"entries" json:"entries"`
+	AccountSignature string           `bson:"account_signature" json:"account_signature"`
+	Day              string           `bson:"day" json:"day"` // YYYY-MM-DD (server local time)
+	CreatedAt        time.Time        `bson:"created_at" json:"created_at"`
+	// Outcome is set once the result (or a re-analysis of it) is approved - labels the template decision
+	Outcome *AnalyticsOutcome `bson:"outcome,omitempty" json:"outcome,omitempty"`
+}
+
+// Analytics outcome statuses
+const (
+	AnalyticsOutcomeApproved   = "approved"   // Approved without corrections
+	AnalyticsOutcomeCorrected  = "corrected"  // Approved with corrections
+	AnalyticsOutcomeReanalyzed = "reanalyzed" // A re-analysis of the document was approved instead
+)
+
+// AnalyticsOutcome is the accountant's final decision on an analyzed document
+type AnalyticsOutcome struct {
+	Status            string           `bson:"status" json:"status"`
+	Corrections       []string         `bson:"corrections,omitempty" json:"corrections,omitempty"` // Corrected fields, e.g. "entries"
+	Entries           []AnalyticsEntry `bson:"entries" json:"entries"`                             // Accounts of the approved voucher
+	AccountSignature  string           `bson:"account_signature" json:"account_signature"`
+	ApprovedRequestID string           `bson:"approved_request_id" json:"approved_request_id"` // Differs from request_id when a re-analysis was approved
+	RecordedAt        time.Time        `bson:"recorded_at" json:"recorded_at"`
+}
+
+// TemplateCoverageRow aggregates documents whose best template candidate was one template
+type TemplateCoverageRow struct {
+	TemplateID            string  `bson:"template_id" json:"template_id"`
+	TemplateName          string  `bson:"template_name" json:"template_name"`
+	Documents             int     `bson:"documents" json:"documents"`
+	TemplateOnly          int     `bson:"template_only" json:"template_only"` // Matched ≥ threshold
+	FullMode              int     `bson:"full_mode" json:"full_mode"`         // Best candidate but below threshold
+	AvgTemplateConfidence float64 `bson:"avg_template_confidence" json:"avg_template_confidence"`
+	AvgConfidence         float64 `bson:"avg_confidence" json:"avg_confidence"`
+}
+
+// UncoveredCategory aggregates full-mode documents of one document type
+type UncoveredCategory struct {
+	DocumentType  string   `bson:"document_type" json:"document_type"`
+	Documents     int      `bson:"documents" json:"documents"`
+	AvgConfidence float64  `bson:"avg_confidence" json:"avg_confidence"`
+	Vendors       []string `bson:"vendors" json:"vendors"` // Up to 10 vendors (candidates for new templates)
+}
+
+// TemplateCoverageReport shows which documents are covered by templates in [from, to)
+type TemplateCoverageReport struct {
+	ShopID              string                `json:"shopid"`
+	From                time.Time             `json:"from"`
+	To                  time.Time             `json:"to"`
+	Documents           int                   `json:"documents"`
+	TemplateOnly        int                   `json:"template_only"`
+	FullMode            int                   `json:"full_mode"`
+	CoverageRate        float64               `json:"coverage_rate"` // template_only / documents (%)
+	Templates           []TemplateCoverageRow `json:"templates"`
+	UncoveredCategories []UncoveredCategory   `json:"uncovered_categories"`
+}
+
+// ensureDocumentAnalyticsIndexes creates indexes used by coverage reports
+func ensureDocumentAnalyticsIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(documentAnalyticsCollection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "shopid", Value: 1}, {Key: "created_at", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "request_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", documentAnalyticsCollection, err)
+	}
+	return nil
+}
+
+// RecordDocumentAnalytics inserts the outcome of one analyzed document
+func RecordDocumentAnalytics(record DocumentAnalyticsRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	record.Day = record.CreatedAt.Format("2006-01-02")
+	record.AccountSignature = accountSignature(record.Entries)
+
+	collection := mongoDB.Collection(documentAnalyticsCollection)
+	if _, err := collection.InsertOne(ctx, record); err != nil {
+		return fmt.Errorf("failed to insert document analytics: %w", err)
+	}
+	return nil
+}
+
+// RecordAnalyticsOutcome labels the analytics of requestID with the approval outcome (no-op when the
+// request has no analytics, e.g. re-analyses and document groups)
+func RecordAnalyticsOutcome(requestID string, outcome AnalyticsOutcome) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if outcome.RecordedAt.IsZero() {
+		outcome.RecordedAt = time.Now()
+	}
+	outcome.AccountSignature = accountSignature(outcome.Entries)
+
+	collection := mongoDB.Collection(documentAnalyticsCollection)
+	if _, err := collection.UpdateOne(ctx, bson.M{"request_id": requestID}, bson.M{"$set": bson.M{"outcome": outcome}}); err != nil {
+		return fmt.Errorf("failed to record analytics outcome: %w", err)
+	}
+	return nil
+}
+
+// GetLabeledTemplateDecisions returns the analytics of [from, to) with an outcome and a template candidate
+func GetLabeledTemplateDecisions(ctx context.Context, shopID string, from, to time.Time) ([]DocumentAnalyticsRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(documentAnalyticsCollection)
+	cursor, err := collection.Find(ctx, bson.M{
+		"shopid":      shopID,
+		"created_at":  bson.M{"$gte": from, "$lt": to},
+		"template_id": bson.M{"$ne": ""},
+		"outcome":     bson.M{"$exists": true},
+	}, options.Find().SetProjection(bson.M{"entries": 0}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load labeled template decisions: %w", err)
+	}
+	records := []DocumentAnalyticsRecord{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode labeled template decisions: %w", err)
+	}
+	return records, nil
+}
+
+// GetTemplateCoverageReport aggregates document analytics of a shop for [from, to)
+func GetTemplateCoverageReport(ctx context.Context, shopID string, from, to time.Time) (*TemplateCoverageReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(documentAnalyticsCollection)
+	match := bson.D{{Key: "$match", Value: bson.M{
+		"shopid":     shopID,
+		"created_at": bson.M{"$gte": from, "$lt": to},
+	}}}
+	countMode := func(mode string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$mode", mode}}, 1, 0}}}
+	}
+
+	report := &TemplateCoverageReport{
+		ShopID:              shopID,
+		From:                from,
+		To:                  to,
+		Templates:           []TemplateCoverageRow{},
+		UncoveredCategories: []UncoveredCategory{},
+	}
+
+	// Per template (best candidate)
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		match,
+		{{Key: "$match", Value: bson.M{"template_id": bson.M{"$ne": ""}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":                     "$template_id",
+			"template_name":           bson.M{"$last": "$template_name"},
+			"documents":               bson.M{"$sum": 1},
+			"template_only":           countMode(AnalysisModeTemplateOnly),
+			"full_mode":               countMode(AnalysisModeFull),
+			"avg_template_confidence": bson.M{"$avg": "$template_confidence"},
+			"avg_confidence":          bson.M{"$avg": "$overall_confidence"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":                     0,
+			"template_id":             "$_id",
+			"template_name":           1,
+			"documents":               1,
+			"template_only":           1,
+			"full_mode":               1,
+			"avg_template_confidence": 1,
+			"avg_confidence":          1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "documents", Value: -1}, {Key: "template_id", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate template coverage: %w", err)
+	}
+	if err := cursor.All(ctx, &report.Templates); err != nil {
+		return nil, fmt.Errorf("failed to decode template coverage: %w", err)
+	}
+
+	// Full-mode documents by document type (what templates to create next)
+	cursor, err = collection.Aggregate(ctx, mongo.Pipeline{
+		match,
+		{{Key: "$match", Value: bson.M{"mode": AnalysisModeFull}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":            "$document_type",
+			"documents":      bson.M{"$sum": 1},
+			"avg_confidence": bson.M{"$avg": "$overall_confidence"},
+			"vendors":        bson.M{"$addToSet": "$vendor_name"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":            0,
+			"document_type":  "$_id",
+			"documents":      1,
+			"avg_confidence": 1,
+			"vendors":        bson.M{"$slice": bson.A{"$vendors", 10}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "documents", Value: -1}, {Key: "document_type", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate uncovered categories: %w", err)
+	}
+	if err := cursor.All(ctx, &report.UncoveredCategories); err != nil {
+		return nil, fmt.Errorf("failed to decode uncovered categories: %w", err)
+	}
+
+	// Totals
+	cursor, err = collection.Aggregate(ctx, mongo.Pipeline{
+		match,
+		{{Key: "$group", Value: bson.M{
+			"_id":           nil,
+			"documents":     bson.M{"$sum": 1},
+			"template_only": countMode(AnalysisModeTemplateOnly),
+			"full_mode":     countMode(AnalysisModeFull),
+		}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate document totals: %w", err)
+	}
+	var totals []struct {
+		Documents    int `bson:"documents"`
+		TemplateOnly int `bson:"template_only"`
+		FullMode     int `bson:"full_mode"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, fmt.Errorf("failed to decode document totals: %w", err)
+	}
+	if len(totals) > 0 {
+		report.Documents = totals[0].Documents
+		report.TemplateOnly = totals[0].TemplateOnly
+		report.FullMode = totals[0].FullMode
+	}
+	if report.Documents > 0 {
+		report.CoverageRate = math.Round(float64(report.TemplateOnly)/float64(report.Documents)*1000) / 10
+	}
+
+	for i := range report.Templates {
+		report.Templates[i].AvgTemplateConfidence = math.Round(report.Templates[i].AvgTemplateConfidence*10) / 10
+		report.Templates[i].AvgConfidence = math.Round(report.Templates[i].AvgConfidence*10) / 10
+	}
+	for i := range report.UncoveredCategories {
+		report.UncoveredCategories[i].AvgConfidence = math.Round(report.UncoveredCategories[i].AvgConfidence*10) / 10
+	}
+
+	return report, nil
+}
+
+// accountSignature returns the sorted unique "D:code" / "C:code" list of entries
+func accountSignature(entries []AnalyticsEntry) string {
+	seen := map[string]bool{}
+	var parts []string
+	for _, e := range entries {
+		if e.AccountCode == "" {
+			continue
+		}
+		part := "D:" + e.AccountCode
+		if e.Side == "credit" {
+			part = "C:" + e.AccountCode
+		}
+		if !seen[part] {
+			seen[part] = true
+			parts = append(parts, part)
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "|")
+}
+
+// RecurringUnmatchedGroup is a vendor + account pattern that repeatedly fell back to full mode
+type RecurringUnmatchedGroup struct {
+	VendorKey        string           `bson:"vendor_key" json:"vendor_key"`
+	VendorName       string           `bson:"vendor_name" json:"vendor_name"`
+	VendorTaxID      string           `bson:"vendor_tax_id" json:"vendor_tax_id"`
+	DocumentType     string           `bson:"document_type" json:"document_type"`
+	AccountSignature string           `bson:"account_signature" json:"account_signature"`
+	Entries          []AnalyticsEntry `bson:"entries" json:"entries"`
+	Documents        int              `bson:"documents" json:"documents"`
+	AvgConfidence    float64          `bson:"avg_confidence" json:"avg_confidence"`
+	RequestIDs       []string         `bson:"request_ids" json:"request_ids"` // Latest 5 requests (examples)
+	LastSeen         time.Time        `bson:"last_seen" json:"last_seen"`
+}
+
+// GetRecurringUnmatchedGroups returns full-mode patterns (same vendor + same accounts) seen at least
+// minDocuments times since from, most frequent first
+func GetRecurringUnmatchedGroups(ctx context.Context, shopID string, from time.Time, minDocuments int) ([]RecurringUnmatchedGroup, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(documentAnalyticsCollection)
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"shopid":            shopID,
+			"mode":              AnalysisModeFull,
+			"vendor_key":        bson.M{"$ne": ""},
+			"account_signature": bson.M{"$ne": ""},
+			"created_at":        bson.M{"$gte": from},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":            bson.M{"vendor_key": "$vendor_key", "account_signature": "$account_signature"},
+			"vendor_name":    bson.M{"$first": "$vendor_name"},
+			"vendor_tax_id":  bson.M{"$first": "$vendor_tax_id"},
+			"document_type":  bson.M{"$first": "$document_type"},
+			"entries":        bson.M{"$first": "$entries"},
+			"documents":      bson.M{"$sum": 1},
+			"avg_confidence": bson.M{"$avg": "$overall_confidence"},
+			"request_ids":    bson.M{"$push": "$request_id"},
+			"last_seen":      bson.M{"$first": "$created_at"},
+		}}},
+		{{Key: "$match", Value: bson.M{"documents": bson.M{"$gte": minDocuments}}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":               0,
+			"vendor_key":        "$_id.vendor_key",
+			"account_signature": "$_id.account_signature",
+			"vendor_name":       1,
+			"vendor_tax_id":     1,
+			"document_type":     1,
+			"entries":           1,
+			"documents":         1,
+			"avg_confidence":    1,
+			"request_ids":       bson.M{"$slice": bson.A{"$request_ids", 5}},
+			"last_seen":         1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "documents", Value: -1}, {Key: "last_seen", Value: -1}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate unmatched documents: %w", err)
+	}
+
+	groups := []RecurringUnmatchedGroup{}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("failed to decode unmatched documents: %w", err)
+	}
+	for i := range groups {
+		groups[i].AvgConfidence = math.Round(groups[i].AvgConfidence*10) / 10
+	}
+	return groups, nil
+}
@@ -0,0 +1,169 @@
+// failed_requests.go - Dead-letter store for analyses that failed (retry via POST /api/v1/failed/:id/retry)
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const failedRequestsCollection = "failedRequests"
+
+// Failed request statuses
+const (
+	FailedRequestStatusFailed   = "failed"
+	FailedRequestStatusResolved = "resolved"
+)
+
+// FailedRequest is an analysis that failed, with what is needed to run it again
+type FailedRequest struct {
+	FailureID         string                 `bson:"failure_id" json:"failure_id"`
+	RequestID         string                 `bson:"request_id" json:"request_id"` // Request of the last failed attempt
+	ShopID            string                 `bson:"shopid" json:"shopid"`
+	Endpoint          string                 `bson:"endpoint" json:"endpoint"` // analyze-receipt, reanalyze
+	Phase             string                 `bson:"phase" json:"phase"`       // ocr, template_match, accounting
+	Error             string                 `bson:"error" json:"error"`
+	Payload           string                 `bson:"payload" json:"payload"`                                             // Original request body (JSON)
+	OCRRequestID      string                 `bson:"ocr_request_id,omitempty" json:"ocr_request_id,omitempty"`           // Stored OCR text (ocrResults) the retry starts from
+	Artifacts         map[string]interface{} `bson:"artifacts,omitempty" json:"artifacts,omitempty"`                     // Partial results (template / vendor match, raw AI response, ...)
+	Status            string                 `bson:"status" json:"status"`                                               // failed, resolved
+	Attempts          int                    `bson:"attempts" json:"attempts"`                                           // Retries so far
+	ResolvedRequestID string                 `bson:"resolved_request_id,omitempty" json:"resolved_request_id,omitempty"` // Request of the successful retry
+	CreatedAt         time.Time              `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time              `bson:"updated_at" json:"updated_at"`
+	ExpiresAt         time.Time              `bson:"expires_at" json:"expires_at"` // TTL index removes the record after this time
+}
+
+// ensureFailedRequestIndexes creates the unique failure_id index, the listing index and the TTL index
+func ensureFailedRequestIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(failedRequestsCollection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "failure_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "shopid", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", failedRequestsCollection, err)
+	}
+	return nil
+}
+
+// SaveFailedRequest stores a new failure for ttl
+func SaveFailedRequest(record FailedRequest, ttl time.Duration) (*FailedRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	record.FailureID = uuid.New().String()
+	record.Status = FailedRequestStatusFailed
+	record.CreatedAt = now
+	record.UpdatedAt = now
+	record.ExpiresAt = now.Add(ttl)
+
+	collection := mongoDB.Collection(failedRequestsCollection)
+	if _, err := collection.InsertOne(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to save failed request: %w", err)
+	}
+	return &record, nil
+}
+
+// UpdateFailedRequestFailure records that a retry failed again (new request, phase and error)
+func UpdateFailedRequestFailure(failureID, requestID, phase, errMsg string, artifacts map[string]interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(failedRequestsCollection)
+	_, err := collection.UpdateOne(ctx, bson.M{"failure_id": failureID}, bson.M{"$set": bson.M{
+		"request_id": requestID,
+		"phase":      phase,
+		"error":      errMsg,
+		"artifacts":  artifacts,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to update failed request: %w", err)
+	}
+	return nil
+}
+
+// RecordFailedRequestRetry counts a retry attempt and marks the failure resolved when it succeeded
+func RecordFailedRequestRetry(failureID, retryRequestID string, resolved bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	set := bson.M{"updated_at": time.Now()}
+	if resolved {
+		set["status"] = FailedRequestStatusResolved
+		set["resolved_request_id"] = retryRequestID
+	}
+
+	collection := mongoDB.Collection(failedRequestsCollection)
+	_, err := collection.UpdateOne(ctx, bson.M{"failure_id": failureID}, bson.M{
+		"$set": set,
+		"$inc": bson.M{"attempts": 1},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record retry: %w", err)
+	}
+	return nil
+}
+
+// GetFailedRequest returns a failure (nil = unknown or expired)
+func GetFailedRequest(ctx context.Context, failureID string) (*FailedRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(failedRequestsCollection)
+	var record FailedRequest
+	err := collection.FindOne(ctx, bson.M{"failure_id": failureID}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query failed request: %w", err)
+	}
+	return &record, nil
+}
+
+// ListFailedRequests returns the newest failures (empty shopID / status = any)
+func ListFailedRequests(ctx context.Context, shopID, status string, limit int) ([]FailedRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if shopID != "" {
+		filter["shopid"] = shopID
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	collection := mongoDB.Collection(failedRequestsCollection)
+	cursor, err := collection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query failedRequests: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	records := []FailedRequest{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode failedRequests: %w", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,304 @@
+// folder_watches.go - Folders watched per shop (Google Drive / Dropbox / SFTP) and the files picked up from them
+//
+// folderWatches: 1 โฟลเดอร์ต่อร้าน - runner ของ API / worker claim แบบ lease (locked_until) เมื่อถึง next_poll_at
+// folderWatchFiles: ไฟล์ที่หยิบมาวิเคราะห์แล้ว (unique ต่อ shopid + provider + file_id) → ไม่วิเคราะห์ซ้ำแม้ย้ายไฟล์ไม่สำเร็จ
+// เก็บชื่อไฟล์เดิมคู่กับ request_id เพื่อย้อนกลับไปหาไฟล์ต้นฉบับได้
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	folderWatchesCollection    = "folderWatches"
+	folderWatchFilesCollection = "folderWatchFiles"
+)
+
+// Picked-up file statuses
+const (
+	FolderWatchFileProcessing = "processing"
+	FolderWatchFileProcessed  = "processed"
+	FolderWatchFileFailed     = "failed"
+)
+
+// ErrFolderWatchFileSeen - the file was already picked up (another poll / instance)
+var ErrFolderWatchFileSeen = errors.New("folder watch file already picked up")
+
+// FolderWatch is the watched folder of a shop
+type FolderWatch struct {
+	ShopID       string     `bson:"shopid" json:"shopid"`
+	Provider     string     `bson:"provider" json:"provider"` // dropbox, google_drive, sftp
+	Folder       string     `bson:"folder" json:"folder"`     // Dropbox path (/Receipts), Google Drive folder ID or SFTP directory
+	Model        string     `bson:"model" json:"model"`       // OCR provider of the analyses
+	RefreshToken string     `bson:"refresh_token" json:"-"`   // OAuth refresh token of the account - never returned by the API
+	SFTP         *SFTPLogin `bson:"sftp,omitempty" json:"sftp,omitempty"`
+	Enabled      bool       `bson:"enabled" json:"enabled"`
+	NextPollAt   time.Time  `bson:"next_poll_at" json:"next_poll_at"`
+	LastPolledAt *time.Time `bson:"last_polled_at,omitempty" json:"last_polled_at,omitempty"`
+	LastError    string     `bson:"last_error,omitempty" json:"last_error,omitempty"` // Listing / authorization problem of the last poll
+	Processed    int        `bson:"processed" json:"processed"`                       // Files moved to processed/ so far
+	Failed       int        `bson:"failed" json:"failed"`                             // Files moved to failed/ so far
+	WorkerID     string     `bson:"worker_id,omitempty" json:"-"`
+	LockedUntil  time.Time  `bson:"locked_until,omitempty" json:"-"` // Lease of the instance polling the folder
+	CreatedAt    time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time  `bson:"updated_at" json:"updated_at"`
+}
+
+// SFTPLogin is the SFTP server of a shop's scanners (secrets are never returned by the API)
+type SFTPLogin struct {
+	Host               string `bson:"host" json:"host"`
+	Port               int    `bson:"port" json:"port"`
+	Username           string `bson:"username" json:"username"`
+	Password           string `bson:"password,omitempty" json:"-"`
+	PrivateKey         string `bson:"private_key,omitempty" json:"-"`                   // PEM (OpenSSH / PKCS#8), unencrypted
+	HostKeyFingerprint string `bson:"host_key_fingerprint" json:"host_key_fingerprint"` // SHA256:... (ssh-keygen -lf) - other host keys are refused
+}
+
+// FolderWatchFile is one file picked up from a watched folder
+type FolderWatchFile struct {
+	ShopID      string     `bson:"shopid" json:"shopid"`
+	Provider    string     `bson:"provider" json:"provider"`
+	FileID      string     `bson:"file_id" json:"file_id"`
+	FileName    string     `bson:"file_name" json:"file_name"` // Original file name (documentimageguid of the analysis)
+	Status      string     `bson:"status" json:"status"`       // processing, processed, failed
+	RequestID   string     `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	StatusCode  int        `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	Error       string     `bson:"error,omitempty" json:"error,omitempty"`
+	MovedTo     string     `bson:"moved_to,omitempty" json:"moved_to,omitempty"` // processed / failed ("" = move failed, retried next poll)
+	CreatedAt   time.Time  `bson:"created_at" json:"created_at"`
+	ProcessedAt *time.Time `bson:"processed_at,omitempty" json:"processed_at,omitempty"`
+}
+
+// ensureFolderWatchIndexes creates the unique shop index, the claim index and the picked-up file indexes
+func ensureFolderWatchIndexes(ctx context.Context) error {
+	_, err := mongoDB.Collection(folderWatchesCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "shopid", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "enabled", Value: 1}, {Key: "next_poll_at", Value: 1}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", folderWatchesCollection, err)
+	}
+	_, err = mongoDB.Collection(folderWatchFilesCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "shopid", Value: 1}, {Key: "provider", Value: 1}, {Key: "file_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "shopid", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", folderWatchFilesCollection, err)
+	}
+	return nil
+}
+
+// GetFolderWatch returns the watched folder of a shop (nil = none)
+func GetFolderWatch(ctx context.Context, shopID string) (*FolderWatch, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var watch FolderWatch
+	err := mongoDB.Collection(folderWatchesCollection).FindOne(ctx, bson.M{"shopid": shopID}).Decode(&watch)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query folder watch: %w", err)
+	}
+	return &watch, nil
+}
+
+// SaveFolderWatch creates or replaces the configuration of a shop's watched folder (counters and lease are kept)
+func SaveFolderWatch(watch FolderWatch) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := mongoDB.Collection(folderWatchesCollection).UpdateOne(ctx,
+		bson.M{"shopid": watch.ShopID},
+		bson.M{
+			"$set": bson.M{
+				"provider":      watch.Provider,
+				"folder":        watch.Folder,
+				"model":         watch.Model,
+				"refresh_token": watch.RefreshToken,
+				"sftp":          watch.SFTP,
+				"enabled":       watch.Enabled,
+				"next_poll_at":  now, // Poll the (new) folder right away
+				"updated_at":    now,
+			},
+			"$setOnInsert": bson.M{"created_at": now, "processed": 0, "failed": 0},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save folder watch: %w", err)
+	}
+	return nil
+}
+
+// DeleteFolderWatch stops watching the shop's folder (picked-up file history is kept)
+func DeleteFolderWatch(ctx context.Context, shopID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := mongoDB.Collection(folderWatchesCollection).DeleteOne(ctx, bson.M{"shopid": shopID})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete folder watch: %w", err)
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// ClaimFolderWatch takes an enabled folder that is due for a poll and not leased by another instance
+// Returns nil when no folder is due
+func ClaimFolderWatch(ctx context.Context, workerID string, lease time.Duration) (*FolderWatch, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"enabled":      true,
+		"next_poll_at": bson.M{"$lte": now},
+		"$or": []bson.M{
+			{"locked_until": bson.M{"$exists": false}},
+			{"locked_until": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"worker_id": workerID, "locked_until": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_poll_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var watch FolderWatch
+	err := mongoDB.Collection(folderWatchesCollection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&watch)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim folder watch: %w", err)
+	}
+	return &watch, nil
+}
+
+// RenewFolderWatchLease extends the lease while files are analyzed (false = the lease was lost)
+func RenewFolderWatchLease(shopID, workerID string, lease time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := mongoDB.Collection(folderWatchesCollection).UpdateOne(ctx,
+		bson.M{"shopid": shopID, "worker_id": workerID},
+		bson.M{"$set": bson.M{"locked_until": time.Now().Add(lease)}},
+	)
+	return err == nil && result.MatchedCount > 0
+}
+
+// FinishFolderWatchPoll releases the lease, schedules the next poll and adds the moved files to the counters
+func FinishFolderWatchPoll(shopID, workerID string, nextPoll time.Duration, processed, failed int, pollErr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := mongoDB.Collection(folderWatchesCollection).UpdateOne(ctx,
+		bson.M{"shopid": shopID, "worker_id": workerID},
+		bson.M{
+			"$set":   bson.M{"next_poll_at": now.Add(nextPoll), "last_polled_at": now, "last_error": pollErr},
+			"$unset": bson.M{"locked_until": "", "worker_id": ""},
+			"$inc":   bson.M{"processed": processed, "failed": failed},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to finish folder watch poll: %w", err)
+	}
+	return nil
+}
+
+// StartFolderWatchFile records a picked-up file before it is analyzed
+// Returns the existing record and ErrFolderWatchFileSeen when the file was picked up before
+func StartFolderWatchFile(file FolderWatchFile) (*FolderWatchFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(folderWatchFilesCollection)
+	file.Status = FolderWatchFileProcessing
+	file.CreatedAt = time.Now()
+	_, err := collection.InsertOne(ctx, file)
+	if err == nil {
+		return &file, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("failed to record folder watch file: %w", err)
+	}
+	var existing FolderWatchFile
+	if err := collection.FindOne(ctx, bson.M{"shopid": file.ShopID, "provider": file.Provider, "file_id": file.FileID}).Decode(&existing); err != nil {
+		return nil, fmt.Errorf("failed to query folder watch file: %w", err)
+	}
+	return &existing, ErrFolderWatchFileSeen
+}
+
+// FinishFolderWatchFile stores the outcome of a picked-up file
+func FinishFolderWatchFile(file FolderWatchFile) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := mongoDB.Collection(folderWatchFilesCollection).UpdateOne(ctx,
+		bson.M{"shopid": file.ShopID, "provider": file.Provider, "file_id": file.FileID},
+		bson.M{"$set": bson.M{
+			"status":       file.Status,
+			"request_id":   file.RequestID,
+			"status_code":  file.StatusCode,
+			"error":        file.Error,
+			"moved_to":     file.MovedTo,
+			"processed_at": now,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update folder watch file: %w", err)
+	}
+	return nil
+}
+
+// ForgetFolderWatchFile removes the record of a file so the next poll picks it up again (interrupted analysis)
+func ForgetFolderWatchFile(shopID, provider, fileID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := mongoDB.Collection(folderWatchFilesCollection).DeleteOne(ctx, bson.M{"shopid": shopID, "provider": provider, "file_id": fileID})
+	if err != nil {
+		return fmt.Errorf("failed to delete folder watch file: %w", err)
+	}
+	return nil
+}
+
+// ListFolderWatchFiles returns the latest picked-up files of a shop, newest first
+func ListFolderWatchFiles(ctx context.Context, shopID string, limit int) ([]FolderWatchFile, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := mongoDB.Collection(folderWatchFilesCollection).Find(ctx, bson.M{"shopid": shopID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query folder watch files: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	files := []FolderWatchFile{}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, fmt.Errorf("failed to decode folder watch files: %w", err)
+	}
+	return files, nil
+}
@@ -0,0 +1,156 @@
+// idempotency.go - Idempotency key storage (prevents duplicate processing on client retries)
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const idempotencyCollection = "idempotencyKeys"
+
+// Idempotency record statuses
+const (
+	IdempotencyStatusProcessing = "processing"
+	IdempotencyStatusCompleted  = "completed"
+)
+
+// IdempotencyRecord maps an idempotency key to the request/result it produced
+type IdempotencyRecord struct {
+	ShopID       string    `bson:"shopid" json:"shopid"`
+	Key          string    `bson:"key" json:"key"`
+	PayloadHash  string    `bson:"payload_hash" json:"payload_hash"`
+	Status       string    `bson:"status" json:"status"`
+	RequestID    string    `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	StatusCode   int       `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	ResponseBody string    `bson:"response_body,omitempty" json:"-"`
+	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
+	CompletedAt  time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	ExpiresAt    time.Time `bson:"expires_at" json:"expires_at"` // TTL index removes the record after this time
+}
+
+// ensureIdempotencyIndexes creates the unique (shopid, key) index and the TTL index
+func ensureIdempotencyIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(idempotencyCollection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "shopid", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", idempotencyCollection, err)
+	}
+	return nil
+}
+
+// ReserveIdempotencyKey atomically claims an idempotency key for a new request
+// Returns (nil, nil) when the key was claimed by this call, or the existing record when
+// the key was already used (caller decides whether to replay, reject or wait)
+func ReserveIdempotencyKey(shopID, key, payloadHash string, ttl time.Duration) (*IdempotencyRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	record := IdempotencyRecord{
+		ShopID:      shopID,
+		Key:         key,
+		PayloadHash: payloadHash,
+		Status:      IdempotencyStatusProcessing,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+
+	collection := mongoDB.Collection(idempotencyCollection)
+	_, err := collection.InsertOne(ctx, record)
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	var existing IdempotencyRecord
+	if err := collection.FindOne(ctx, bson.M{"shopid": shopID, "key": key}).Decode(&existing); err != nil {
+		if err == mongo.ErrNoDocuments {
+			// Expired between insert and read - try once more
+			if _, err := collection.InsertOne(ctx, record); err == nil {
+				return nil, nil
+			}
+		}
+		return nil, fmt.Errorf("failed to query idempotency key: %w", err)
+	}
+
+	return &existing, nil
+}
+
+// TakeOverIdempotencyKey re-claims a key whose previous request was abandoned (e.g. server restart)
+// Only succeeds if the record is still in processing state and older than staleBefore
+func TakeOverIdempotencyKey(shopID, key string, staleBefore time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(idempotencyCollection)
+	result, err := collection.UpdateOne(ctx,
+		bson.M{
+			"shopid":     shopID,
+			"key":        key,
+			"status":     IdempotencyStatusProcessing,
+			"created_at": bson.M{"$lt": staleBefore},
+		},
+		bson.M{"$set": bson.M{"created_at": time.Now()}},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to take over idempotency key: %w", err)
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// CompleteIdempotencyKey stores the final response for an idempotency key
+func CompleteIdempotencyKey(shopID, key, requestID string, statusCode int, responseBody string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(idempotencyCollection)
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"shopid": shopID, "key": key},
+		bson.M{"$set": bson.M{
+			"status":        IdempotencyStatusCompleted,
+			"request_id":    requestID,
+			"status_code":   statusCode,
+			"response_body": responseBody,
+			"completed_at":  time.Now(),
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey removes a reservation so the client can retry with the same key
+// Used when the original request failed (errors are not cached)
+func ReleaseIdempotencyKey(shopID, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(idempotencyCollection)
+	_, err := collection.DeleteOne(ctx, bson.M{
+		"shopid": shopID,
+		"key":    key,
+		"status": IdempotencyStatusProcessing,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
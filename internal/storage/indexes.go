@@ -0,0 +1,78 @@
+// indexes.go - MongoDB index setup for collections owned by this service
+
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// EnsureIndexes creates indexes (unique keys, TTL) for collections owned by this service
+// Safe to call on every startup - MongoDB ignores indexes that already exist
+func EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := ensureIdempotencyIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureUsageLedgerIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureAITraceIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureJournalBookRuleIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureDocumentAnalyticsIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureVendorAccountMappingIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureOCRResultIndexes(ctx, mongoDB); err != nil {
+		return err
+	}
+	if err := ensureInterruptedAnalysisIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureShopSettingsIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureRuntimeFlagIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureFailedRequestIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureAuditLogIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureAnalyzeJobIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureTenantRouteIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureTemplateLibraryIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureCreditorAliasIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureCreditorBranchIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureDimensionIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureReprocessCampaignIndexes(ctx); err != nil {
+		return err
+	}
+	if err := ensureFolderWatchIndexes(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+// interrupted_analyses.go - Analyses still running when the server shut down (filed as failed requests on the next start)
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const interruptedAnalysesCollection = "interruptedAnalyses"
+
+// interruptedAnalysisTTL - records are only useful while the images are still downloadable
+const interruptedAnalysisTTL = 7 * 24 * time.Hour
+
+// InterruptedAnalysis is a request that did not finish within the shutdown drain window
+type InterruptedAnalysis struct {
+	ShopID        string    `bson:"shopid" json:"shopid"`
+	Method        string    `bson:"method" json:"method"`
+	Path          string    `bson:"path" json:"path"` // Request URI incl. query (e.g. /api/v1/analyze-receipt?debug=true)
+	Payload       string    `bson:"payload" json:"payload"`
+	StartedAt     time.Time `bson:"started_at" json:"started_at"`
+	InterruptedAt time.Time `bson:"interrupted_at" json:"interrupted_at"`
+	ExpiresAt     time.Time `bson:"expires_at" json:"-"`
+}
+
+// ensureInterruptedAnalysisIndexes creates the shop lookup and TTL indexes
+func ensureInterruptedAnalysisIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(interruptedAnalysesCollection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "shopid", Value: 1}, {Key: "interrupted_at", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", interruptedAnalysesCollection, err)
+	}
+	return nil
+}
+
+// SaveInterruptedAnalyses stores the requests that were cut off by a shutdown
+func SaveInterruptedAnalyses(records []InterruptedAnalysis) error {
+	if len(records) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	docs := make([]interface{}, len(records))
+	for i, record := range records {
+		record.InterruptedAt = now
+		record.ExpiresAt = now.Add(interruptedAnalysisTTL)
+		docs[i] = record
+	}
+
+	collection := mongoDB.Collection(interruptedAnalysesCollection)
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("failed to save interrupted analyses: %w", err)
+	}
+	return nil
+}
+
+// TakeInterruptedAnalysis removes and returns the oldest interrupted analysis (nil = none left)
+// Each record is taken by exactly one replica when several start at once
+func TakeInterruptedAnalysis() (*InterruptedAnalysis, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var record InterruptedAnalysis
+	err := mongoDB.Collection(interruptedAnalysesCollection).FindOneAndDelete(ctx, bson.M{},
+		options.FindOneAndDelete().SetSort(bson.D{{Key: "interrupted_at", Value: 1}})).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to take interrupted analysis: %w", err)
+	}
+	return &record, nil
+}
@@ -0,0 +1,92 @@
+// journal_book_rules.go - Per-shop rules for deterministic journal book selection
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const journalBookRulesCollection = "journalBookRules"
+
+// JournalBookRule maps document type + VAT presence + direction to a journal book code
+// Empty / "any" conditions match everything; lower priority is evaluated first
+type JournalBookRule struct {
+	RuleID          string    `bson:"rule_id" json:"rule_id"`
+	ShopID          string    `bson:"shopid" json:"-"`
+	Name            string    `bson:"name" json:"name"`
+	Priority        int       `bson:"priority" json:"priority"`
+	DocumentType    string    `bson:"document_type" json:"document_type"` // receipt, invoice, tax_invoice, payment_slip, any
+	VAT             string    `bson:"vat" json:"vat"`                     // with, without, any
+	Direction       string    `bson:"direction" json:"direction"`         // purchase, sale, any
+	JournalBookCode string    `bson:"journal_book_code" json:"journal_book_code"`
+	Enabled         bool      `bson:"enabled" json:"enabled"`
+	UpdatedAt       time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// ensureJournalBookRuleIndexes creates the index used to load a shop's rules
+func ensureJournalBookRuleIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(journalBookRulesCollection)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "shopid", Value: 1}, {Key: "priority", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", journalBookRulesCollection, err)
+	}
+	return nil
+}
+
+// GetJournalBookRules returns a shop's rules ordered by priority
+func GetJournalBookRules(ctx context.Context, shopID string) ([]JournalBookRule, error) {
+	return getJournalBookRules(ctx, mongoDB, shopID)
+}
+
+func getJournalBookRules(ctx context.Context, db *mongo.Database, shopID string) ([]JournalBookRule, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	collection := db.Collection(journalBookRulesCollection)
+	cursor, err := collection.Find(ctx, bson.M{"shopid": shopID},
+		options.Find().SetSort(bson.D{{Key: "priority", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query journalBookRules: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	rules := []JournalBookRule{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, fmt.Errorf("failed to decode journalBookRules: %w", err)
+	}
+	return rules, nil
+}
+
+// ReplaceJournalBookRules replaces all rules of a shop (the rule set is edited as a whole)
+func ReplaceJournalBookRules(shopID string, rules []JournalBookRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(journalBookRulesCollection)
+	if _, err := collection.DeleteMany(ctx, bson.M{"shopid": shopID}); err != nil {
+		return fmt.Errorf("failed to delete journal book rules: %w", err)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, 0, len(rules))
+	now := time.Now()
+	for _, rule := range rules {
+		rule.ShopID = shopID
+		rule.UpdatedAt = now
+		docs = append(docs, rule)
+	}
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("failed to insert journal book rules: %w", err)
+	}
+	return nil
+}
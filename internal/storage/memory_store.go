@@ -0,0 +1,287 @@
+// memory_store.go - In-memory Store (tests, local tools without MongoDB)
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore implements Store with maps - master data and templates are set up by the caller
+type MemoryStore struct {
+	mu         sync.RWMutex
+	masterData map[string]*MasterDataCache
+	templates  map[string][]bson.M
+	results    map[string]StoredOCRResult
+}
+
+// NewMemoryStore returns an empty in-memory Store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		masterData: make(map[string]*MasterDataCache),
+		templates:  make(map[string][]bson.M),
+		results:    make(map[string]StoredOCRResult),
+	}
+}
+
+// SetMasterData sets the master data returned for a shop
+func (s *MemoryStore) SetMasterData(shopID string, data *MasterDataCache) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data.ShopID = shopID
+	data.LoadedAt = time.Now()
+	s.masterData[shopID] = data
+}
+
+// SetDocumentTemplates sets the documentFormate templates of a shop
+func (s *MemoryStore) SetDocumentTemplates(shopID string, templates []bson.M) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.templates[shopID] = templates
+}
+
+// GetOrLoadMasterData returns the master data set for the shop (empty when none was set)
+func (s *MemoryStore) GetOrLoadMasterData(ctx context.Context, shopID string) (*MasterDataCache, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if data, ok := s.masterData[shopID]; ok {
+		return data, nil
+	}
+	return &MasterDataCache{ShopID: shopID, LoadedAt: time.Now()}, nil
+}
+
+// InvalidateCache is a no-op - the data set with SetMasterData is the source
+func (s *MemoryStore) InvalidateCache(shopID string) {}
+
+// ClearAllCache is a no-op - the data set with SetMasterData is the source
+func (s *MemoryStore) ClearAllCache() {}
+
+// ListDocumentTemplates returns the templates of the shop that have details
+func (s *MemoryStore) ListDocumentTemplates(ctx context.Context, shopID string) ([]bson.M, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	templates := []bson.M{}
+	for _, template := range s.templates[shopID] {
+		switch details := template["details"].(type) {
+		case bson.A:
+			if len(details) > 0 {
+				templates = append(templates, template)
+			}
+		case []interface{}:
+			if len(details) > 0 {
+				templates = append(templates, template)
+			}
+		}
+	}
+	return templates, nil
+}
+
+// GetDocumentTemplate returns the shop's template whose guidfixed (or _id hex) is templateID
+func (s *MemoryStore) GetDocumentTemplate(ctx context.Context, shopID, templateID string) (bson.M, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, template := range s.templates[shopID] {
+		if guid, _ := template["guidfixed"].(string); guid == templateID {
+			return template, nil
+		}
+		if id, ok := template["_id"].(primitive.ObjectID); ok && id.Hex() == templateID {
+			return template, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, templateID)
+}
+
+// SaveOCRResult stores the OCR text of a request (ttl only sets ExpiresAt)
+func (s *MemoryStore) SaveOCRResult(record StoredOCRResult, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.results[record.RequestID]; exists {
+		return fmt.Errorf("failed to save OCR result: duplicate request_id %s", record.RequestID)
+	}
+	record.CreatedAt = time.Now()
+	record.ExpiresAt = record.CreatedAt.Add(ttl)
+	s.results[record.RequestID] = record
+	return nil
+}
+
+// GetOCRResult returns the stored OCR text of a request (nil = unknown or expired)
+func (s *MemoryStore) GetOCRResult(ctx context.Context, requestID string) (*StoredOCRResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	record, ok := s.results[requestID]
+	if !ok || (!record.ExpiresAt.IsZero() && time.Now().After(record.ExpiresAt)) {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+// SetOCRResultSummary records the document header and accounting result of a stored request
+func (s *MemoryStore) SetOCRResultSummary(shopID, requestID string, summary OCRDocumentSummary, analysis *OCRAnalysisSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.results[requestID]
+	if !ok || record.ShopID != shopID {
+		return nil
+	}
+	record.Summary = &summary
+	record.Analysis = analysis
+	s.results[requestID] = record
+	return nil
+}
+
+// ApproveOCRResult freezes a draft result as final (ErrResultFinal when already final or unknown)
+func (s *MemoryStore) ApproveOCRResult(shopID, requestID string, summary OCRDocumentSummary, analysis OCRAnalysisSnapshot, approval OCRResultApproval) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.results[requestID]
+	if !ok || record.ShopID != shopID || record.Status == OCRResultStatusFinal {
+		return ErrResultFinal
+	}
+	record.Status = OCRResultStatusFinal
+	record.Summary = &summary
+	record.Analysis = &analysis
+	record.Approval = &approval
+	record.ExpiresAt = time.Time{}
+	s.results[requestID] = record
+	return nil
+}
+
+// ListOCRResultsByDocumentDate applies the same filter as MongoStore.ListOCRResultsByDocumentDate, oldest first
+func (s *MemoryStore) ListOCRResultsByDocumentDate(ctx context.Context, shopID, fromDate, toDate string) ([]StoredOCRResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	s.mu.RLock()
+	records := []StoredOCRResult{}
+	for _, record := range s.results {
+		if record.ShopID != shopID || record.Analysis == nil || (!record.ExpiresAt.IsZero() && now.After(record.ExpiresAt)) {
+			continue
+		}
+		date := record.Analysis.DocumentDate
+		if date == "" && record.Summary != nil {
+			date = record.Summary.DocumentDate
+		}
+		if date < fromDate || date >= toDate {
+			continue
+		}
+		record.Images = nil
+		records = append(records, record)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+	return records, nil
+}
+
+// ListOCRResultsByCreditor applies the same filter as MongoStore.ListOCRResultsByCreditor, oldest first
+func (s *MemoryStore) ListOCRResultsByCreditor(ctx context.Context, shopID, creditorCode string, limit int) ([]StoredOCRResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	s.mu.RLock()
+	records := []StoredOCRResult{}
+	for _, record := range s.results {
+		if record.ShopID != shopID || record.Analysis == nil || record.Analysis.CreditorCode != creditorCode ||
+			(!record.ExpiresAt.IsZero() && now.After(record.ExpiresAt)) {
+			continue
+		}
+		record.Images = nil
+		records = append(records, record)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+	if len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return records, nil
+}
+
+// SearchOCRResults applies the same filters as MongoStore.SearchOCRResults, newest first
+func (s *MemoryStore) SearchOCRResults(ctx context.Context, query OCRSearchQuery) ([]StoredOCRResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var amountRe *regexp.Regexp
+	if query.Amount != nil {
+		amountRe = regexp.MustCompile(amountPattern(*query.Amount))
+	}
+	text := strings.ToLower(strings.TrimSpace(query.Text))
+
+	s.mu.RLock()
+	records := []StoredOCRResult{}
+	for _, record := range s.results {
+		if record.ShopID != query.ShopID ||
+			(!query.From.IsZero() && record.CreatedAt.Before(query.From)) ||
+			(!query.To.IsZero() && !record.CreatedAt.Before(query.To)) {
+			continue
+		}
+		if text != "" && !memoryResultContains(record, text) {
+			continue
+		}
+		if amountRe != nil && !memoryResultHasAmount(record, *query.Amount, amountRe) {
+			continue
+		}
+		records = append(records, record)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.After(records[j].CreatedAt) })
+	if query.Limit > 0 && len(records) > query.Limit {
+		records = records[:query.Limit]
+	}
+	return records, nil
+}
+
+// memoryResultContains matches text (lowercase) in the OCR text or the document header
+func memoryResultContains(record StoredOCRResult, text string) bool {
+	fields := []string{}
+	for _, image := range record.Images {
+		fields = append(fields, image.RawText)
+	}
+	if record.Summary != nil {
+		fields = append(fields, record.Summary.VendorName, record.Summary.VendorTaxID, record.Summary.DocumentNumber)
+	}
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// memoryResultHasAmount matches the document total (±0.01) or the amount written in the OCR text
+func memoryResultHasAmount(record StoredOCRResult, amount float64, amountRe *regexp.Regexp) bool {
+	if record.Summary != nil && math.Abs(record.Summary.Total-amount) <= 0.01 {
+		return true
+	}
+	for _, image := range record.Images {
+		if amountRe.MatchString(image.RawText) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMemoryStoreTemplates(t *testing.T) {
+	objectID := primitive.NewObjectID()
+	store := NewMemoryStore()
+	store.SetDocumentTemplates("shop-a", []bson.M{
+		{"guidfixed": "tpl-1", "details": bson.A{bson.M{"accountcode": "5100"}}},
+		{"_id": objectID, "details": bson.A{}}, // No details - not listed, still readable by ID
+	})
+	store.SetDocumentTemplates("shop-b", []bson.M{{"guidfixed": "tpl-b", "details": bson.A{bson.M{}}}})
+	ctx := context.Background()
+
+	templates, err := store.ListDocumentTemplates(ctx, "shop-a")
+	if err != nil || len(templates) != 1 || templates[0]["guidfixed"] != "tpl-1" {
+		t.Fatalf("ListDocumentTemplates = %v, %v", templates, err)
+	}
+
+	tests := []struct {
+		name       string
+		shopID     string
+		templateID string
+		wantErr    error
+	}{
+		{name: "guidfixed", shopID: "shop-a", templateID: "tpl-1"},
+		{name: "object id", shopID: "shop-a", templateID: objectID.Hex()},
+		{name: "other shop", shopID: "shop-a", templateID: "tpl-b", wantErr: ErrTemplateNotFound},
+		{name: "unknown", shopID: "shop-a", templateID: "missing", wantErr: ErrTemplateNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := store.GetDocumentTemplate(ctx, tt.shopID, tt.templateID)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || template == nil {
+				t.Fatalf("GetDocumentTemplate = %v, %v", template, err)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreOCRResultsByCreditor(t *testing.T) {
+	store := NewMemoryStore()
+	for _, record := range []StoredOCRResult{
+		{RequestID: "r1", ShopID: "shop-a", Analysis: &OCRAnalysisSnapshot{CreditorCode: "C001"}},
+		{RequestID: "r2", ShopID: "shop-a", Analysis: &OCRAnalysisSnapshot{CreditorCode: "C002"}},
+		{RequestID: "r3", ShopID: "shop-b", Analysis: &OCRAnalysisSnapshot{CreditorCode: "C001"}},
+	} {
+		if err := store.SaveOCRResult(record, time.Hour); err != nil {
+			t.Fatalf("SaveOCRResult: %v", err)
+		}
+	}
+
+	results, err := store.ListOCRResultsByCreditor(context.Background(), "shop-a", "C001", 10)
+	if err != nil || len(results) != 1 || results[0].RequestID != "r1" {
+		t.Fatalf("ListOCRResultsByCreditor = %+v, %v", results, err)
+	}
+	if record, err := store.GetOCRResult(context.Background(), "missing"); record != nil || err != nil {
+		t.Errorf("GetOCRResult(missing) = %+v, %v, want nil, nil", record, err)
+	}
+}
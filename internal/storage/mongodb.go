@@ -8,16 +8,24 @@ import (
 
 	"github.com/bosocmputer/account_ocr_gemini/configs"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Context convention:
+// reads on the request path take the request context → a disconnected client cancels the query;
+// writes that record an outcome (usage ledger, traces, failures, OCR text, idempotency) keep
+// context.Background() + their own timeout so they complete after the client has gone
+//
+// mongoClient / mongoDB = the main database (MONGO_DB_NAME) - only for collections this service owns
+// (usage ledger, audit log, traces, jobs, idempotency, folder watches, shop settings, ...): they stay in the
+// main database for every tenant (see tenants.go), so they need no routing and are not behind Store
+// Customer data (master data, documentFormate, ocrResults) is read through Store only - never through mongoDB
 var mongoClient *mongo.Client
 var mongoDB *mongo.Database
 
-// InitMongoDB initializes MongoDB connection
-func InitMongoDB() error {
+// InitMongoDB connects to MONGO_URI / MONGO_DB_NAME and returns the Store handlers and services use
+func InitMongoDB() (*MongoStore, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -28,20 +36,20 @@ func InitMongoDB() error {
 	clientOptions := options.Client().ApplyURI(connectionURI)
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
 	}
 
 	// Ping to verify connection
 	err = client.Ping(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to ping MongoDB: %w", err)
+		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
 	}
 
 	mongoClient = client
 	mongoDB = client.Database(configs.MONGO_DB_NAME)
 
 	log.Println("✅ Connected to MongoDB successfully!")
-	return nil
+	return NewMongoStore(mongoDB), nil
 }
 
 // GetMongoDB returns the MongoDB database instance
@@ -49,6 +57,17 @@ func GetMongoDB() *mongo.Database {
 	return mongoDB
 }
 
+// PingMongoDB verifies the MongoDB connection is usable (readiness probe)
+func PingMongoDB(ctx context.Context) error {
+	if mongoClient == nil {
+		return fmt.Errorf("MongoDB is not initialized")
+	}
+	if err := mongoClient.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+	return nil
+}
+
 // CloseMongoDB closes MongoDB connection
 func CloseMongoDB() {
 	if mongoClient != nil {
@@ -71,12 +90,36 @@ type ShopName struct {
 type ShopProfile struct {
 	GuidFixed      string     `bson:"guidfixed" json:"guidfixed"`
 	Names          []ShopName `bson:"names" json:"names"`
-	PromptShopInfo string     `bson:"promptshopinfo" json:"promptshopinfo"` // Custom prompt describing business type and context
+	PromptShopInfo string     `bson:"promptshopinfo" json:"promptshopinfo"`          // Custom prompt describing business type and context
+	VATRegistered  *bool      `bson:"vatregistered" json:"vat_registered,omitempty"` // nil = unknown (VAT entries are left to the AI)
 	Settings       struct {
-		TaxID string `bson:"taxid" json:"taxid"`
+		TaxID                string                    `bson:"taxid" json:"taxid"`
+		SlipVerification     SlipVerificationSettings  `bson:"slipverification" json:"-"`                                  // Not sent to AI prompts (contains API key)
+		PettyCashAccountCode string                    `bson:"pettycashaccountcode" json:"pettycashaccountcode,omitempty"` // Credit account of petty cash vouchers (default: account named เงินสดย่อย)
+		FixedAsset           FixedAssetSettings        `bson:"fixedasset" json:"-"`
+		BalanceCorrection    BalanceCorrectionSettings `bson:"balancecorrection" json:"-"`
 	} `bson:"settings" json:"settings"`
 }
 
+// SlipVerificationSettings enables bank slip verification for a shop (settings.slipverification)
+type SlipVerificationSettings struct {
+	Enabled bool   `bson:"enabled"`
+	APIKey  string `bson:"apikey"` // Optional: shop's own slip-verification API key (default: SLIP_VERIFY_API_KEY)
+}
+
+// FixedAssetSettings customizes fixed asset capitalization for a shop (settings.fixedasset)
+type FixedAssetSettings struct {
+	Disabled  bool     `bson:"disabled"`
+	Threshold float64  `bson:"threshold"` // Minimum line amount (0 = FIXED_ASSET_THRESHOLD)
+	Keywords  []string `bson:"keywords"`  // Extra asset keywords (added to the built-in list)
+}
+
+// BalanceCorrectionSettings lets a shop auto-apply rounding fixes of unbalanced entries (settings.balancecorrection)
+type BalanceCorrectionSettings struct {
+	AutoApply bool    `bson:"autoapply"`
+	MaxAmount float64 `bson:"maxamount"` // Largest difference applied automatically (0 or ≥ 1 = below 1 baht)
+}
+
 // GetCompanyName returns the Thai name (code="th") or first active name from Names array
 func (s *ShopProfile) GetCompanyName() string {
 	if s == nil || len(s.Names) == 0 {
@@ -100,12 +143,12 @@ func (s *ShopProfile) GetCompanyName() string {
 	return ""
 }
 
-// GetShopProfile retrieves shop profile by shopid (guidfixed)
-func GetShopProfile(shopID string) (*ShopProfile, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// getShopProfile retrieves shop profile by shopid (guidfixed)
+func getShopProfile(ctx context.Context, db *mongo.Database, shopID string) (*ShopProfile, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	collection := mongoDB.Collection("shops")
+	collection := db.Collection("shops")
 	filter := bson.M{"guidfixed": shopID}
 
 	var profile ShopProfile
@@ -120,9 +163,9 @@ func GetShopProfile(shopID string) (*ShopProfile, error) {
 	return &profile, nil
 }
 
-// GetChartOfAccounts retrieves chart of accounts from MongoDB filtered by shopid
-func GetChartOfAccounts(shopID string, additionalFilter bson.M) ([]bson.M, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// getChartOfAccounts retrieves chart of accounts from MongoDB filtered by shopid
+func getChartOfAccounts(ctx context.Context, db *mongo.Database, shopID string, additionalFilter bson.M) ([]bson.M, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Build filter with shopid
@@ -133,7 +176,7 @@ func GetChartOfAccounts(shopID string, additionalFilter bson.M) ([]bson.M, error
 		filter[k] = v
 	}
 
-	collection := mongoDB.Collection("chartofaccounts")
+	collection := db.Collection("chartofaccounts")
 	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query chartofaccounts: %w", err)
@@ -148,9 +191,9 @@ func GetChartOfAccounts(shopID string, additionalFilter bson.M) ([]bson.M, error
 	return results, nil
 }
 
-// GetJournalBooks retrieves journal books from MongoDB filtered by shopid
-func GetJournalBooks(shopID string, additionalFilter bson.M) ([]bson.M, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// getJournalBooks retrieves journal books from MongoDB filtered by shopid
+func getJournalBooks(ctx context.Context, db *mongo.Database, shopID string, additionalFilter bson.M) ([]bson.M, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Build filter with shopid
@@ -161,7 +204,7 @@ func GetJournalBooks(shopID string, additionalFilter bson.M) ([]bson.M, error) {
 		filter[k] = v
 	}
 
-	collection := mongoDB.Collection("journalBooks")
+	collection := db.Collection("journalBooks")
 	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query journalBooks: %w", err)
@@ -176,9 +219,9 @@ func GetJournalBooks(shopID string, additionalFilter bson.M) ([]bson.M, error) {
 	return results, nil
 }
 
-// GetCreditors retrieves creditors from MongoDB filtered by shopid
-func GetCreditors(shopID string, additionalFilter bson.M) ([]bson.M, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// getCreditors retrieves creditors from MongoDB filtered by shopid
+func getCreditors(ctx context.Context, db *mongo.Database, shopID string, additionalFilter bson.M) ([]bson.M, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Build filter with shopid
@@ -189,7 +232,7 @@ func GetCreditors(shopID string, additionalFilter bson.M) ([]bson.M, error) {
 		filter[k] = v
 	}
 
-	collection := mongoDB.Collection("creditors")
+	collection := db.Collection("creditors")
 	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query creditors: %w", err)
@@ -204,9 +247,9 @@ func GetCreditors(shopID string, additionalFilter bson.M) ([]bson.M, error) {
 	return results, nil
 }
 
-// GetDebtors retrieves debtors from MongoDB filtered by shopid
-func GetDebtors(shopID string, additionalFilter bson.M) ([]bson.M, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// getDebtors retrieves debtors from MongoDB filtered by shopid
+func getDebtors(ctx context.Context, db *mongo.Database, shopID string, additionalFilter bson.M) ([]bson.M, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	// Build filter with shopid
@@ -217,7 +260,7 @@ func GetDebtors(shopID string, additionalFilter bson.M) ([]bson.M, error) {
 		filter[k] = v
 	}
 
-	collection := mongoDB.Collection("debtors")
+	collection := db.Collection("debtors")
 	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
 		// Empty debtors is OK - some shops may not have debtors yet
@@ -232,82 +275,3 @@ func GetDebtors(shopID string, additionalFilter bson.M) ([]bson.M, error) {
 
 	return results, nil
 }
-
-// --- Draft Management Functions ---
-
-// ReceiptDraft represents a draft entry in MongoDB
-type ReceiptDraft struct {
-	DraftID         string                 `bson:"draft_id" json:"draft_id"`
-	ShopID          string                 `bson:"shopid" json:"shopid"`
-	ReceiptData     map[string]interface{} `bson:"receipt_data" json:"receipt_data"`
-	AccountingEntry map[string]interface{} `bson:"accounting_entry" json:"accounting_entry"`
-	AIAnalysis      map[string]interface{} `bson:"ai_analysis" json:"ai_analysis"`
-	Validation      map[string]interface{} `bson:"validation" json:"validation"`
-	Status          string                 `bson:"status" json:"status"`
-	CreatedAt       time.Time              `bson:"created_at" json:"created_at"`
-	ApprovedAt      *time.Time             `bson:"approved_at,omitempty" json:"approved_at,omitempty"`
-	ApprovedBy      string                 `bson:"approved_by,omitempty" json:"approved_by,omitempty"`
-	Modified        bool                   `bson:"modified" json:"modified"`
-	ImageReference  map[string]interface{} `bson:"image_reference" json:"image_reference"`
-}
-
-// CreateDraft creates a new draft entry in MongoDB
-func CreateDraft(draft ReceiptDraft) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	collection := mongoDB.Collection("receipt_drafts")
-	_, err := collection.InsertOne(ctx, draft)
-	if err != nil {
-		return fmt.Errorf("failed to create draft: %w", err)
-	}
-
-	fmt.Printf("✓ Draft created: %s\n", draft.DraftID)
-	return nil
-}
-
-// GetTemplateByID retrieves a single document template by guidfixed or ObjectID
-func GetTemplateByID(shopID string, templateID string) (bson.M, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	collection := mongoDB.Collection("documentFormate")
-
-	// Try to use as guidfixed first (more common use case)
-	filter := bson.M{
-		"guidfixed": templateID,
-		"shopid":    shopID,
-	}
-
-	log.Printf("🔍 Querying template - Database: %s, Collection: %s, Filter: %+v", mongoDB.Name(), collection.Name(), filter)
-
-	// Debug: Count total documents
-	totalCount, _ := collection.CountDocuments(ctx, bson.M{})
-	shopCount, _ := collection.CountDocuments(ctx, bson.M{"shopid": shopID})
-	log.Printf("📊 Collection stats - Total: %d, For shopid '%s': %d", totalCount, shopID, shopCount)
-
-	var template bson.M
-	err := collection.FindOne(ctx, filter).Decode(&template)
-
-	// If not found by guidfixed, try ObjectID
-	if err == mongo.ErrNoDocuments {
-		log.Printf("⚠️  Not found by guidfixed, trying ObjectID...")
-		objectID, objErr := primitive.ObjectIDFromHex(templateID)
-		if objErr == nil {
-			filter = bson.M{
-				"_id":    objectID,
-				"shopid": shopID,
-			}
-			err = collection.FindOne(ctx, filter).Decode(&template)
-		}
-	}
-
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("template not found with ID: %s", templateID)
-		}
-		return nil, fmt.Errorf("failed to query template: %w", err)
-	}
-
-	return template, nil
-}
@@ -0,0 +1,361 @@
+// ocr_results.go - Raw OCR text per request (re-analysis without re-OCR, document search)
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const ocrResultsCollection = "ocrResults"
+
+// StoredOCRImage is the OCR text of one image (QR / barcode data already appended)
+type StoredOCRImage struct {
+	ImageIndex        int    `bson:"image_index" json:"image_index"`
+	DocumentImageGUID string `bson:"documentimageguid,omitempty" json:"documentimageguid,omitempty"`
+	ImageURI          string `bson:"imageuri,omitempty" json:"imageuri,omitempty"`
+	RawText           string `bson:"raw_text" json:"raw_text"`
+}
+
+// OCRDocumentSummary is the header of the analyzed document (set once the accounting analysis finished)
+type OCRDocumentSummary struct {
+	VendorName     string  `bson:"vendor_name,omitempty" json:"vendor_name,omitempty"`
+	VendorTaxID    string  `bson:"vendor_tax_id,omitempty" json:"vendor_tax_id,omitempty"`
+	DocumentNumber string  `bson:"document_number,omitempty" json:"document_number,omitempty"`
+	DocumentDate   string  `bson:"document_date,omitempty" json:"document_date,omitempty"`
+	Total          float64 `bson:"total" json:"total"`
+}
+
+// OCRAnalysisEntry is one journal line of the analysis (amounts kept for GET /results/compare)
+type OCRAnalysisEntry struct {
+	AccountCode string            `bson:"account_code" json:"account_code"`
+	AccountName string            `bson:"account_name" json:"account_name"`
+	Debit       float64           `bson:"debit" json:"debit"`
+	Credit      float64           `bson:"credit" json:"credit"`
+	Description string            `bson:"description,omitempty" json:"description,omitempty"`
+	Dimensions  map[string]string `bson:"dimensions,omitempty" json:"dimensions,omitempty"` // Dimension key → code (project, cost center)
+}
+
+// OCRAnalysisSnapshot is the accounting result of the request (set together with the summary)
+type OCRAnalysisSnapshot struct {
+	Receipt           map[string]interface{} `bson:"receipt" json:"receipt"` // Scalar receipt fields only (no items)
+	Entries           []OCRAnalysisEntry     `bson:"entries" json:"entries"`
+	DocumentDate      string                 `bson:"document_date,omitempty" json:"document_date,omitempty"` // accounting_entry header (journal voucher on approve)
+	ReferenceNumber   string                 `bson:"reference_number,omitempty" json:"reference_number,omitempty"`
+	JournalBookCode   string                 `bson:"journal_book_code,omitempty" json:"journal_book_code,omitempty"`
+	JournalBookName   string                 `bson:"journal_book_name,omitempty" json:"journal_book_name,omitempty"`
+	CreditorCode      string                 `bson:"creditor_code,omitempty" json:"creditor_code,omitempty"`
+	CreditorName      string                 `bson:"creditor_name,omitempty" json:"creditor_name,omitempty"`
+	CreditorBranch    string                 `bson:"creditor_branch,omitempty" json:"creditor_branch,omitempty"` // Branch code of the creditor (00000 = head office)
+	DebtorCode        string                 `bson:"debtor_code,omitempty" json:"debtor_code,omitempty"`
+	DebtorName        string                 `bson:"debtor_name,omitempty" json:"debtor_name,omitempty"`
+	TemplateName      string                 `bson:"template_name,omitempty" json:"template_name,omitempty"`
+	ConfidenceScore   float64                `bson:"confidence_score" json:"confidence_score"`
+	ConfidenceLevel   string                 `bson:"confidence_level" json:"confidence_level"`
+	ConfidenceFactors map[string]float64     `bson:"confidence_factors" json:"confidence_factors"`
+	RequiresReview    bool                   `bson:"requires_review" json:"requires_review"`
+	CustomFields      map[string]interface{} `bson:"custom_fields,omitempty" json:"custom_fields,omitempty"` // Shop-defined fields (receipt.custom_fields)
+}
+
+// Result lifecycle: analyzed results are drafts until approved (POST /results/:request_id/approve)
+const (
+	OCRResultStatusDraft = "draft"
+	OCRResultStatusFinal = "final"
+)
+
+// ErrResultFinal is returned when approving a result that is already final
+var ErrResultFinal = errors.New("result is already final")
+
+// JournalVoucherLine is one posting line of an approved voucher
+type JournalVoucherLine struct {
+	LineNo      int               `bson:"line_no" json:"line_no"`
+	AccountCode string            `bson:"account_code" json:"account_code"`
+	AccountName string            `bson:"account_name" json:"account_name"`
+	Debit       float64           `bson:"debit" json:"debit"`
+	Credit      float64           `bson:"credit" json:"credit"`
+	Description string            `bson:"description,omitempty" json:"description,omitempty"`
+	Dimensions  map[string]string `bson:"dimensions,omitempty" json:"dimensions,omitempty"`
+}
+
+// JournalVoucher is the canonical payload of an approved result (ready for export / posting)
+type JournalVoucher struct {
+	RequestID       string               `bson:"request_id" json:"request_id"`
+	ShopID          string               `bson:"shopid" json:"shopid"`
+	DocumentDate    string               `bson:"document_date" json:"document_date"` // YYYY-MM-DD
+	DocumentNumber  string               `bson:"document_number" json:"document_number"`
+	JournalBookCode string               `bson:"journal_book_code" json:"journal_book_code"`
+	JournalBookName string               `bson:"journal_book_name,omitempty" json:"journal_book_name,omitempty"`
+	CreditorCode    string               `bson:"creditor_code,omitempty" json:"creditor_code,omitempty"`
+	CreditorName    string               `bson:"creditor_name,omitempty" json:"creditor_name,omitempty"`
+	DebtorCode      string               `bson:"debtor_code,omitempty" json:"debtor_code,omitempty"`
+	DebtorName      string               `bson:"debtor_name,omitempty" json:"debtor_name,omitempty"`
+	VendorName      string               `bson:"vendor_name,omitempty" json:"vendor_name,omitempty"`
+	VendorTaxID     string               `bson:"vendor_tax_id,omitempty" json:"vendor_tax_id,omitempty"`
+	Lines           []JournalVoucherLine `bson:"lines" json:"lines"`
+	TotalDebit      float64              `bson:"total_debit" json:"total_debit"`
+	TotalCredit     float64              `bson:"total_credit" json:"total_credit"`
+}
+
+// OCRResultApproval records who approved a result, what was corrected and the frozen voucher
+type OCRResultApproval struct {
+	Voucher     JournalVoucher `bson:"voucher" json:"voucher"`
+	Corrections []string       `bson:"corrections" json:"corrections"` // Corrected fields, e.g. "receipt.total", "entries"
+	ApprovedBy  string         `bson:"approved_by,omitempty" json:"approved_by,omitempty"`
+	Note        string         `bson:"note,omitempty" json:"note,omitempty"`
+	ApprovedAt  time.Time      `bson:"approved_at" json:"approved_at"`
+}
+
+// StoredOCRResult is the OCR output of an analyze-receipt request (or of a re-analysis of one)
+type StoredOCRResult struct {
+	RequestID       string               `bson:"request_id" json:"request_id"`
+	ShopID          string               `bson:"shopid" json:"shopid"`
+	OCRProvider     string               `bson:"ocr_provider" json:"ocr_provider"`
+	ParentRequestID string               `bson:"parent_request_id,omitempty" json:"parent_request_id,omitempty"` // Set for re-analyses
+	Images          []StoredOCRImage     `bson:"images" json:"images"`
+	Summary         *OCRDocumentSummary  `bson:"summary,omitempty" json:"summary,omitempty"`   // nil until the analysis succeeded
+	Analysis        *OCRAnalysisSnapshot `bson:"analysis,omitempty" json:"analysis,omitempty"` // nil until the analysis succeeded
+	Status          string               `bson:"status,omitempty" json:"status,omitempty"`     // "" / draft, or final once approved
+	Approval        *OCRResultApproval   `bson:"approval,omitempty" json:"approval,omitempty"` // Set with status final
+	CreatedAt       time.Time            `bson:"created_at" json:"created_at"`
+	ExpiresAt       time.Time            `bson:"expires_at" json:"expires_at"` // TTL index removes the record after this time
+}
+
+// OCRSearchQuery filters the stored OCR results of a shop (Text and/or Amount required)
+type OCRSearchQuery struct {
+	ShopID string
+	Text   string   // Substring of the OCR text, vendor name, tax ID or document number (case-insensitive)
+	Amount *float64 // Document total (±0.01), or the amount written in the OCR text
+	From   time.Time
+	To     time.Time // Exclusive
+	Limit  int
+}
+
+// ensureOCRResultIndexes creates the unique request_id index, the search index and the TTL index
+func ensureOCRResultIndexes(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection(ocrResultsCollection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "request_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "shopid", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "shopid", Value: 1}, {Key: "analysis.creditor_code", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", ocrResultsCollection, err)
+	}
+	return nil
+}
+
+// SaveOCRResult stores the OCR text of a request for ttl
+func (s *MongoStore) SaveOCRResult(record StoredOCRResult, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	record.CreatedAt = time.Now()
+	record.ExpiresAt = record.CreatedAt.Add(ttl)
+
+	collection := s.db.Collection(ocrResultsCollection)
+	if _, err := collection.InsertOne(ctx, record); err != nil {
+		return fmt.Errorf("failed to save OCR result: %w", err)
+	}
+	return nil
+}
+
+// GetOCRResult returns the stored OCR text of a request (nil = unknown or expired)
+func (s *MongoStore) GetOCRResult(ctx context.Context, requestID string) (*StoredOCRResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	collection := s.db.Collection(ocrResultsCollection)
+	var record StoredOCRResult
+	err := collection.FindOne(ctx, bson.M{"request_id": requestID}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query OCR result: %w", err)
+	}
+	return &record, nil
+}
+
+// SetOCRResultSummary records the document header and the accounting result once the analysis of the request finished
+func (s *MongoStore) SetOCRResultSummary(shopID, requestID string, summary OCRDocumentSummary, analysis *OCRAnalysisSnapshot) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := s.db.Collection(ocrResultsCollection)
+	_, err := collection.UpdateOne(ctx, bson.M{"request_id": requestID, "shopid": shopID}, bson.M{"$set": bson.M{"summary": summary, "analysis": analysis}})
+	if err != nil {
+		return fmt.Errorf("failed to update OCR result summary: %w", err)
+	}
+	return nil
+}
+
+// ApproveOCRResult freezes a draft result as final with the corrected summary / analysis and the voucher
+// expires_at is removed so the TTL index keeps the approved result (retention purges still apply)
+func (s *MongoStore) ApproveOCRResult(shopID, requestID string, summary OCRDocumentSummary, analysis OCRAnalysisSnapshot, approval OCRResultApproval) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := s.db.Collection(ocrResultsCollection)
+	filter := bson.M{"request_id": requestID, "shopid": shopID, "status": bson.M{"$ne": OCRResultStatusFinal}}
+	update := bson.M{
+		"$set": bson.M{
+			"status":   OCRResultStatusFinal,
+			"summary":  summary,
+			"analysis": analysis,
+			"approval": approval,
+		},
+		"$unset": bson.M{"expires_at": ""},
+	}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to approve OCR result: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrResultFinal
+	}
+	return nil
+}
+
+// SearchOCRResults returns the newest stored OCR results of a shop matching the query
+// Substring (regex) match instead of a text index: Thai text has no spaces between words,
+// so a MongoDB text index cannot tokenize it (the search is bounded by shopid + the TTL window)
+func (s *MongoStore) SearchOCRResults(ctx context.Context, query OCRSearchQuery) ([]StoredOCRResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"shopid": query.ShopID}
+	createdAt := bson.M{}
+	if !query.From.IsZero() {
+		createdAt["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		createdAt["$lt"] = query.To
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	var conditions []bson.M
+	if text := strings.TrimSpace(query.Text); text != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(text), Options: "i"}
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"images.raw_text": pattern},
+			{"summary.vendor_name": pattern},
+			{"summary.vendor_tax_id": pattern},
+			{"summary.document_number": pattern},
+		}})
+	}
+	if query.Amount != nil {
+		amount := *query.Amount
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"summary.total": bson.M{"$gte": amount - 0.01, "$lte": amount + 0.01}},
+			{"images.raw_text": primitive.Regex{Pattern: amountPattern(amount)}},
+		}})
+	}
+	if len(conditions) > 0 {
+		filter["$and"] = conditions
+	}
+
+	collection := s.db.Collection(ocrResultsCollection)
+	cursor, err := collection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(query.Limit)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search ocrResults: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	records := []StoredOCRResult{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode ocrResults: %w", err)
+	}
+	return records, nil
+}
+
+// ListOCRResultsByDocumentDate returns the analyzed results of a shop whose document date is in [fromDate, toDate)
+// Dates are YYYY-MM-DD: accounting_entry.document_date, else the receipt date (OCR text is not loaded)
+func (s *MongoStore) ListOCRResultsByDocumentDate(ctx context.Context, shopID, fromDate, toDate string) ([]StoredOCRResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	dateRange := bson.M{"$gte": fromDate, "$lt": toDate}
+	filter := bson.M{
+		"shopid":   shopID,
+		"analysis": bson.M{"$exists": true},
+		"$or": []bson.M{
+			{"analysis.document_date": dateRange},
+			{"analysis.document_date": bson.M{"$in": bson.A{nil, ""}}, "summary.document_date": dateRange},
+		},
+	}
+	cursor, err := s.db.Collection(ocrResultsCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetProjection(bson.M{"images": 0}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ocrResults: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	records := []StoredOCRResult{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode ocrResults: %w", err)
+	}
+	return records, nil
+}
+
+// ListOCRResultsByCreditor returns the newest limit analyzed results of a creditor, oldest first (OCR text is not loaded)
+func (s *MongoStore) ListOCRResultsByCreditor(ctx context.Context, shopID, creditorCode string, limit int) ([]StoredOCRResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"shopid": shopID, "analysis.creditor_code": creditorCode}
+	cursor, err := s.db.Collection(ocrResultsCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)).SetProjection(bson.M{"images": 0}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ocrResults: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	records := []StoredOCRResult{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode ocrResults: %w", err)
+	}
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records, nil
+}
+
+// amountPattern matches an amount as printed on documents: 1234.5 → "1,234.50" or "1234.50" (not part of a longer number)
+func amountPattern(amount float64) string {
+	cents := int64(math.Round(math.Abs(amount) * 100))
+	whole, fraction := cents/100, cents%100
+
+	digits := fmt.Sprint(whole)
+	var grouped strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped.WriteString(",?")
+		}
+		grouped.WriteRune(d)
+	}
+	return fmt.Sprintf(`(^|[^0-9.,])%s\.%02d([^0-9]|$)`, grouped.String(), fraction)
+}
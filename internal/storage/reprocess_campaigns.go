@@ -0,0 +1,266 @@
+// reprocess_campaigns.go - Re-processing campaigns: re-analysis of a shop's stored documents after prompt changes
+//
+// POST /api/v1/shops/:shopid/reprocess สร้าง campaign (status queued) พร้อมรายการเอกสารที่จะวิเคราะห์ซ้ำ
+// runner ของ API / worker claim campaign แบบ lease (locked_until) แล้ววิเคราะห์ทีละเอกสาร
+// ผลแต่ละเอกสาร (request ใหม่ + สิ่งที่เปลี่ยน) บันทึกกลับใน items[] → instance ที่ตายกลางทาง ทำต่อจากเอกสารถัดไปได้
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const reprocessCampaignsCollection = "reprocessCampaigns"
+
+// Campaign statuses
+const (
+	ReprocessStatusQueued    = "queued"
+	ReprocessStatusRunning   = "running"
+	ReprocessStatusDone      = "done"
+	ReprocessStatusCancelled = "cancelled"
+)
+
+// Item statuses
+const (
+	ReprocessItemPending = "pending"
+	ReprocessItemDone    = "done"
+	ReprocessItemFailed  = "failed"
+)
+
+// ErrReprocessCampaignStopped is returned when a campaign was cancelled or claimed by another instance
+var ErrReprocessCampaignStopped = errors.New("reprocess campaign was cancelled or claimed by another instance")
+
+// ReprocessFilters selects the documents of a campaign
+type ReprocessFilters struct {
+	From               string `bson:"from" json:"from"` // Document date YYYY-MM-DD (inclusive)
+	To                 string `bson:"to" json:"to"`     // Document date YYYY-MM-DD (inclusive)
+	CreditorCode       string `bson:"creditor_code,omitempty" json:"creditor_code,omitempty"`
+	RequiresReviewOnly bool   `bson:"requires_review_only,omitempty" json:"requires_review_only,omitempty"`
+	IncludeFinal       bool   `bson:"include_final,omitempty" json:"include_final,omitempty"` // Also re-analyze approved results (the approval is kept)
+	Model              string `bson:"model,omitempty" json:"model,omitempty"`                 // Accounting model of the re-analyses (default = configured model)
+}
+
+// ReprocessItem is one document of a campaign
+type ReprocessItem struct {
+	RequestID    string     `bson:"request_id" json:"request_id"` // Analysis that is re-analyzed (latest of the document)
+	Status       string     `bson:"status" json:"status"`         // pending, done, failed
+	NewRequestID string     `bson:"new_request_id,omitempty" json:"new_request_id,omitempty"`
+	Changes      []string   `bson:"changes,omitempty" json:"changes,omitempty"` // creditor, journal_book, entries, receipt, review_added, review_cleared
+	Error        string     `bson:"error,omitempty" json:"error,omitempty"`
+	ProcessedAt  *time.Time `bson:"processed_at,omitempty" json:"processed_at,omitempty"`
+}
+
+// ReprocessSummary counts the outcomes of the processed documents
+type ReprocessSummary struct {
+	Succeeded int            `bson:"succeeded" json:"succeeded"`
+	Failed    int            `bson:"failed" json:"failed"`
+	Changed   int            `bson:"changed" json:"changed"` // Succeeded with at least one change
+	Unchanged int            `bson:"unchanged" json:"unchanged"`
+	Changes   map[string]int `bson:"changes" json:"changes"` // Documents per change type
+}
+
+// ReprocessCampaign is a batch of re-analyses of one shop
+type ReprocessCampaign struct {
+	CampaignID  string           `bson:"campaign_id" json:"campaign_id"`
+	ShopID      string           `bson:"shopid" json:"shopid"`
+	Filters     ReprocessFilters `bson:"filters" json:"filters"`
+	Status      string           `bson:"status" json:"status"` // queued, running, done, cancelled
+	Total       int              `bson:"total" json:"total"`
+	Processed   int              `bson:"processed" json:"processed"`
+	Summary     ReprocessSummary `bson:"summary" json:"summary"`
+	Items       []ReprocessItem  `bson:"items,omitempty" json:"items,omitempty"` // Not loaded by ListReprocessCampaigns
+	WorkerID    string           `bson:"worker_id,omitempty" json:"-"`
+	LockedUntil time.Time        `bson:"locked_until,omitempty" json:"-"` // Lease of the instance running the campaign
+	CreatedAt   time.Time        `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time        `bson:"updated_at" json:"updated_at"`
+	FinishedAt  *time.Time       `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
+
+// ensureReprocessCampaignIndexes creates the unique campaign_id index, the claim index and the listing index
+func ensureReprocessCampaignIndexes(ctx context.Context) error {
+	_, err := mongoDB.Collection(reprocessCampaignsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "campaign_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "shopid", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", reprocessCampaignsCollection, err)
+	}
+	return nil
+}
+
+// CreateReprocessCampaign stores a new queued campaign
+func CreateReprocessCampaign(campaign ReprocessCampaign) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := mongoDB.Collection(reprocessCampaignsCollection).InsertOne(ctx, campaign); err != nil {
+		return fmt.Errorf("failed to create reprocess campaign: %w", err)
+	}
+	return nil
+}
+
+// GetReprocessCampaign returns a campaign of the shop with its items (nil = not found)
+func GetReprocessCampaign(ctx context.Context, shopID, campaignID string) (*ReprocessCampaign, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var campaign ReprocessCampaign
+	err := mongoDB.Collection(reprocessCampaignsCollection).FindOne(ctx, bson.M{"campaign_id": campaignID, "shopid": shopID}).Decode(&campaign)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reprocess campaign: %w", err)
+	}
+	return &campaign, nil
+}
+
+// ListReprocessCampaigns returns the latest campaigns of a shop without their items, newest first
+func ListReprocessCampaigns(ctx context.Context, shopID string, limit int) ([]ReprocessCampaign, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := mongoDB.Collection(reprocessCampaignsCollection).Find(ctx, bson.M{"shopid": shopID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)).SetProjection(bson.M{"items": 0}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reprocess campaigns: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	campaigns := []ReprocessCampaign{}
+	if err := cursor.All(ctx, &campaigns); err != nil {
+		return nil, fmt.Errorf("failed to decode reprocess campaigns: %w", err)
+	}
+	return campaigns, nil
+}
+
+// ClaimReprocessCampaign takes the oldest queued campaign (or a running one whose lease expired) for workerID
+// Returns nil when there is nothing to run
+func ClaimReprocessCampaign(ctx context.Context, workerID string, lease time.Duration) (*ReprocessCampaign, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"$or": []bson.M{
+		{"status": ReprocessStatusQueued},
+		{"status": ReprocessStatusRunning, "locked_until": bson.M{"$lt": now}},
+	}}
+	update := bson.M{"$set": bson.M{
+		"status":       ReprocessStatusRunning,
+		"worker_id":    workerID,
+		"locked_until": now.Add(lease),
+		"updated_at":   now,
+	}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var campaign ReprocessCampaign
+	err := mongoDB.Collection(reprocessCampaignsCollection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&campaign)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim reprocess campaign: %w", err)
+	}
+	return &campaign, nil
+}
+
+// RecordReprocessItem stores the outcome of items[index] and extends the lease
+// ErrReprocessCampaignStopped = the campaign was cancelled or the lease was lost
+func RecordReprocessItem(campaign ReprocessCampaign, index int, lease time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := mongoDB.Collection(reprocessCampaignsCollection).UpdateOne(ctx,
+		bson.M{"campaign_id": campaign.CampaignID, "worker_id": campaign.WorkerID, "status": ReprocessStatusRunning},
+		bson.M{"$set": bson.M{
+			fmt.Sprintf("items.%d", index): campaign.Items[index],
+			"processed":                    campaign.Processed,
+			"summary":                      campaign.Summary,
+			"locked_until":                 now.Add(lease),
+			"updated_at":                   now,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update reprocess campaign: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrReprocessCampaignStopped
+	}
+	return nil
+}
+
+// FinishReprocessCampaign marks a running campaign done
+func FinishReprocessCampaign(campaign ReprocessCampaign) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := mongoDB.Collection(reprocessCampaignsCollection).UpdateOne(ctx,
+		bson.M{"campaign_id": campaign.CampaignID, "worker_id": campaign.WorkerID, "status": ReprocessStatusRunning},
+		bson.M{
+			"$set":   bson.M{"status": ReprocessStatusDone, "finished_at": now, "updated_at": now},
+			"$unset": bson.M{"locked_until": ""},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to finish reprocess campaign: %w", err)
+	}
+	return nil
+}
+
+// CancelReprocessCampaign stops a queued or running campaign (false = not found or already finished)
+// The document being analyzed when the campaign is cancelled still finishes
+func CancelReprocessCampaign(ctx context.Context, shopID, campaignID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	result, err := mongoDB.Collection(reprocessCampaignsCollection).UpdateOne(ctx,
+		bson.M{
+			"campaign_id": campaignID,
+			"shopid":      shopID,
+			"status":      bson.M{"$in": bson.A{ReprocessStatusQueued, ReprocessStatusRunning}},
+		},
+		bson.M{
+			"$set":   bson.M{"status": ReprocessStatusCancelled, "finished_at": now, "updated_at": now},
+			"$unset": bson.M{"locked_until": ""},
+		},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel reprocess campaign: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// CountActiveReprocessCampaigns counts the queued / running campaigns of a shop
+func CountActiveReprocessCampaigns(ctx context.Context, shopID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	count, err := mongoDB.Collection(reprocessCampaignsCollection).CountDocuments(ctx, bson.M{
+		"shopid": shopID,
+		"status": bson.M{"$in": bson.A{ReprocessStatusQueued, ReprocessStatusRunning}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count reprocess campaigns: %w", err)
+	}
+	return count, nil
+}
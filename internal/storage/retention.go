@@ -0,0 +1,102 @@
+// retention.go - Data retention (PDPA): delete stored document data older than the retention window
+//
+// ลบเฉพาะ collection ที่เก็บเนื้อหาเอกสาร (OCR text, AI prompt/response, ผลวิเคราะห์)
+// usageLedger ไม่ถูกลบ - เป็นข้อมูลค่าใช้จ่าย ไม่มีเนื้อหาเอกสาร
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// retentionTarget is a collection holding document data and the field its age is measured by
+type retentionTarget struct {
+	Collection string
+	TimeField  string
+}
+
+// retentionTargets lists every collection the purge deletes from
+var retentionTargets = []retentionTarget{
+	{Collection: ocrResultsCollection, TimeField: "created_at"},
+	{Collection: aiTracesCollection, TimeField: "created_at"},
+	{Collection: failedRequestsCollection, TimeField: "created_at"},
+	{Collection: idempotencyCollection, TimeField: "created_at"},
+	{Collection: interruptedAnalysesCollection, TimeField: "started_at"},
+	{Collection: documentAnalyticsCollection, TimeField: "created_at"},
+	{Collection: "receipt_drafts", TimeField: "created_at"},
+	{Collection: analyzeJobsCollection, TimeField: "created_at"},
+	{Collection: folderWatchFilesCollection, TimeField: "created_at"},
+}
+
+// ShopRetention is a shop with its own retention window (shopSettings.retention_days)
+type ShopRetention struct {
+	ShopID        string `bson:"shopid"`
+	RetentionDays int    `bson:"retention_days"`
+}
+
+// GetShopRetentionOverrides returns the shops that set retention_days in their settings
+func GetShopRetentionOverrides(ctx context.Context) ([]ShopRetention, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(shopSettingsCollection)
+	cursor, err := collection.Find(ctx,
+		bson.M{"retention_days": bson.M{"$gt": 0}},
+		options.Find().SetProjection(bson.M{"shopid": 1, "retention_days": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shop retention settings: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var shops []ShopRetention
+	if err := cursor.All(ctx, &shops); err != nil {
+		return nil, fmt.Errorf("failed to decode shop retention settings: %w", err)
+	}
+	return shops, nil
+}
+
+// PurgeShopData deletes a shop's document data created before the given time from dbs
+// (main database + tenant databases) - returns the number of deleted documents per collection
+func PurgeShopData(ctx context.Context, dbs []*mongo.Database, shopID string, before time.Time) (map[string]int64, error) {
+	return purgeDocuments(ctx, dbs, func(target retentionTarget) bson.M {
+		return bson.M{"shopid": shopID, target.TimeField: bson.M{"$lt": before}}
+	})
+}
+
+// PurgeExpiredData deletes the document data of every shop older than the global retention window
+// Shops in excludeShops have their own window and are purged separately with PurgeShopData
+func PurgeExpiredData(ctx context.Context, dbs []*mongo.Database, before time.Time, excludeShops []string) (map[string]int64, error) {
+	return purgeDocuments(ctx, dbs, func(target retentionTarget) bson.M {
+		filter := bson.M{target.TimeField: bson.M{"$lt": before}}
+		if len(excludeShops) > 0 {
+			filter["shopid"] = bson.M{"$nin": excludeShops}
+		}
+		return filter
+	})
+}
+
+// purgeDocuments runs DeleteMany on every retention target of every database with the filter built for it
+func purgeDocuments(ctx context.Context, dbs []*mongo.Database, filterFor func(target retentionTarget) bson.M) (map[string]int64, error) {
+	// DeleteMany over a large window can take longer than a single-document write
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	deleted := map[string]int64{}
+	for _, db := range dbs {
+		for _, target := range retentionTargets {
+			result, err := db.Collection(target.Collection).DeleteMany(ctx, filterFor(target))
+			if err != nil {
+				return deleted, fmt.Errorf("failed to purge %s.%s: %w", db.Name(), target.Collection, err)
+			}
+			deleted[target.Collection] += result.DeletedCount
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,89 @@
+// runtime_flags.go - Incident response flags set through the admin API (suspended shops, disabled providers)
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const runtimeFlagsCollection = "runtimeFlags"
+
+// Runtime flag kinds
+const (
+	RuntimeFlagShopSuspended    = "shop_suspended"
+	RuntimeFlagProviderDisabled = "provider_disabled"
+)
+
+// RuntimeFlag - a shop is suspended / a provider is disabled while its flag exists
+type RuntimeFlag struct {
+	Kind      string    `bson:"kind" json:"kind"`
+	Key       string    `bson:"key" json:"key"` // shopid or provider name
+	Reason    string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// ensureRuntimeFlagIndexes creates the unique (kind, key) index
+func ensureRuntimeFlagIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(runtimeFlagsCollection)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "kind", Value: 1}, {Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", runtimeFlagsCollection, err)
+	}
+	return nil
+}
+
+// GetRuntimeFlags returns all active flags
+func GetRuntimeFlags() ([]RuntimeFlag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(runtimeFlagsCollection)
+	cursor, err := collection.Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "kind", Value: 1}, {Key: "key", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query runtimeFlags: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	flags := []RuntimeFlag{}
+	if err := cursor.All(ctx, &flags); err != nil {
+		return nil, fmt.Errorf("failed to decode runtimeFlags: %w", err)
+	}
+	return flags, nil
+}
+
+// SetRuntimeFlag creates the flag (or updates its reason)
+func SetRuntimeFlag(flag RuntimeFlag) (*RuntimeFlag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	flag.CreatedAt = time.Now()
+	collection := mongoDB.Collection(runtimeFlagsCollection)
+	filter := bson.M{"kind": flag.Kind, "key": flag.Key}
+	if _, err := collection.ReplaceOne(ctx, filter, flag, options.Replace().SetUpsert(true)); err != nil {
+		return nil, fmt.Errorf("failed to save runtime flag: %w", err)
+	}
+	return &flag, nil
+}
+
+// DeleteRuntimeFlag clears a flag (false = it was not set)
+func DeleteRuntimeFlag(kind, key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(runtimeFlagsCollection)
+	result, err := collection.DeleteOne(ctx, bson.M{"kind": kind, "key": key})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete runtime flag: %w", err)
+	}
+	return result.DeletedCount > 0, nil
+}
@@ -0,0 +1,81 @@
+// shop_settings.go - Per-shop overrides of model names, confidence thresholds, the VAT rate, custom fields and dimensions
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/internal/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const shopSettingsCollection = "shopSettings"
+
+// ShopSettings overrides the global config for one shop (empty / nil = use the global value)
+type ShopSettings struct {
+	ShopID                                 string                       `bson:"shopid" json:"shopid"`
+	OCRModelName                           string                       `bson:"ocr_model_name,omitempty" json:"ocr_model_name,omitempty"`
+	TemplateModelName                      string                       `bson:"template_model_name,omitempty" json:"template_model_name,omitempty"`
+	TemplateAccountingModelName            string                       `bson:"template_accounting_model_name,omitempty" json:"template_accounting_model_name,omitempty"`
+	AccountingModelName                    string                       `bson:"accounting_model_name,omitempty" json:"accounting_model_name,omitempty"`
+	TemplateConfidenceThreshold            *float64                     `bson:"template_confidence_threshold,omitempty" json:"template_confidence_threshold,omitempty"`
+	HandwrittenTemplateConfidenceThreshold *float64                     `bson:"handwritten_template_confidence_threshold,omitempty" json:"handwritten_template_confidence_threshold,omitempty"`
+	RetentionDays                          *int                         `bson:"retention_days,omitempty" json:"retention_days,omitempty"` // Overrides DATA_RETENTION_DAYS
+	VATRate                                *float64                     `bson:"vat_rate,omitempty" json:"vat_rate,omitempty"`             // VAT rate of the shop's own sales (0 = zero-rated), overrides VAT_RATE
+	CustomFields                           []common.CustomField         `bson:"custom_fields,omitempty" json:"custom_fields,omitempty"`   // Extra fields read into receipt.custom_fields
+	Dimensions                             []common.DimensionDefinition `bson:"dimensions,omitempty" json:"dimensions,omitempty"`         // Dimensions booked on journal lines (values: dimensions collection)
+	UpdatedAt                              time.Time                    `bson:"updated_at" json:"updated_at"`
+}
+
+// ensureShopSettingsIndexes creates the unique shopid index
+func ensureShopSettingsIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(shopSettingsCollection)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "shopid", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", shopSettingsCollection, err)
+	}
+	return nil
+}
+
+// GetShopSettings returns the overrides of a shop (nil = no overrides)
+func GetShopSettings(ctx context.Context, shopID string) (*ShopSettings, error) {
+	return getShopSettings(ctx, mongoDB, shopID)
+}
+
+func getShopSettings(ctx context.Context, db *mongo.Database, shopID string) (*ShopSettings, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	collection := db.Collection(shopSettingsCollection)
+	var settings ShopSettings
+	err := collection.FindOne(ctx, bson.M{"shopid": shopID}).Decode(&settings)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shop settings: %w", err)
+	}
+	return &settings, nil
+}
+
+// SaveShopSettings replaces the overrides of a shop (the settings are edited as a whole)
+func SaveShopSettings(settings ShopSettings) (*ShopSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	settings.UpdatedAt = time.Now()
+	collection := mongoDB.Collection(shopSettingsCollection)
+	_, err := collection.ReplaceOne(ctx, bson.M{"shopid": settings.ShopID}, settings, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save shop settings: %w", err)
+	}
+	return &settings, nil
+}
@@ -0,0 +1,139 @@
+// store.go - Storage interface the API handlers and services depend on (constructed in main)
+//
+// MongoStore = production (one MongoDB database + its own master data cache)
+// TenantRouter = MongoStore ต่อ tenant เลือกตาม shopid (TENANT_ROUTING_ENABLED)
+// MemoryStore = in-memory fake สำหรับ test / เครื่องมือที่ไม่มี MongoDB
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MasterDataRepo loads (and caches) the master data of a shop
+type MasterDataRepo interface {
+	GetOrLoadMasterData(ctx context.Context, shopID string) (*MasterDataCache, error)
+	InvalidateCache(shopID string)
+	ClearAllCache()
+}
+
+// TemplateRepo reads the accounting templates (documentFormate) of a shop
+type TemplateRepo interface {
+	ListDocumentTemplates(ctx context.Context, shopID string) ([]bson.M, error)
+	GetDocumentTemplate(ctx context.Context, shopID, templateID string) (bson.M, error)
+}
+
+// ErrTemplateNotFound - no template of the shop has the requested guidfixed / _id
+var ErrTemplateNotFound = errors.New("template not found")
+
+// ResultRepo stores the OCR text and outcome of analyzed requests (re-analysis, document search, comparison)
+type ResultRepo interface {
+	SaveOCRResult(record StoredOCRResult, ttl time.Duration) error
+	GetOCRResult(ctx context.Context, requestID string) (*StoredOCRResult, error)
+	SetOCRResultSummary(shopID, requestID string, summary OCRDocumentSummary, analysis *OCRAnalysisSnapshot) error
+	SearchOCRResults(ctx context.Context, query OCRSearchQuery) ([]StoredOCRResult, error)
+	ApproveOCRResult(shopID, requestID string, summary OCRDocumentSummary, analysis OCRAnalysisSnapshot, approval OCRResultApproval) error
+	ListOCRResultsByDocumentDate(ctx context.Context, shopID, fromDate, toDate string) ([]StoredOCRResult, error)
+	ListOCRResultsByCreditor(ctx context.Context, shopID, creditorCode string, limit int) ([]StoredOCRResult, error)
+}
+
+// Store is every repository the API uses
+type Store interface {
+	MasterDataRepo
+	TemplateRepo
+	ResultRepo
+}
+
+var (
+	_ Store = (*MongoStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*TenantRouter)(nil)
+)
+
+// MongoStore implements Store on one MongoDB database
+type MongoStore struct {
+	db        *mongo.Database // Customer data: master data, documentFormate, ocrResults
+	serviceDB *mongo.Database // Settings owned by this service: journalBookRules, vendorAccountMappings, shopSettings, templateLibrary
+	cache     *masterDataCacheSet
+}
+
+// NewMongoStore returns a Store on db with an empty master data cache
+func NewMongoStore(db *mongo.Database) *MongoStore {
+	return &MongoStore{db: db, serviceDB: db, cache: newMasterDataCacheSet()}
+}
+
+// GetOrLoadMasterData retrieves master data from cache or loads from DB
+// ctx = request context: a cancelled request stops the load (nothing is cached, the next request loads again)
+func (s *MongoStore) GetOrLoadMasterData(ctx context.Context, shopID string) (*MasterDataCache, error) {
+	return s.cache.getOrLoad(ctx, s.db, s.serviceDB, shopID)
+}
+
+// InvalidateCache removes cache for a specific shop
+func (s *MongoStore) InvalidateCache(shopID string) {
+	s.cache.invalidate(shopID)
+}
+
+// ClearAllCache removes all cached data
+func (s *MongoStore) ClearAllCache() {
+	s.cache.clear()
+}
+
+// ListDocumentTemplates retrieves accounting templates from documentFormate collection
+// Returns only templates that have details (not empty templates) + subscribed library templates
+func (s *MongoStore) ListDocumentTemplates(ctx context.Context, shopID string) ([]bson.M, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// Query by shopid and filter out empty templates
+	filter := bson.M{
+		"shopid":  shopID,
+		"details": bson.M{"$exists": true, "$ne": []interface{}{}},
+	}
+
+	cursor, err := s.db.Collection("documentFormate").Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documentFormate: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	templates := []bson.M{}
+	if err = cursor.All(ctx, &templates); err != nil {
+		return nil, fmt.Errorf("failed to decode documentFormate: %w", err)
+	}
+
+	// Library templates the shop subscribed to (templateLibrary is owned by this service)
+	library, err := subscribedLibraryTemplates(ctx, s.serviceDB, shopID)
+	if err != nil {
+		return nil, err
+	}
+	return mergeLibraryTemplates(templates, library), nil
+}
+
+// GetDocumentTemplate reads one documentFormate template of the shop by guidfixed, falling back to its ObjectID
+func (s *MongoStore) GetDocumentTemplate(ctx context.Context, shopID, templateID string) (bson.M, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	collection := s.db.Collection("documentFormate")
+	var template bson.M
+	err := collection.FindOne(ctx, bson.M{"guidfixed": templateID, "shopid": shopID}).Decode(&template)
+	if err == mongo.ErrNoDocuments {
+		if objectID, objErr := primitive.ObjectIDFromHex(templateID); objErr == nil {
+			err = collection.FindOne(ctx, bson.M{"_id": objectID, "shopid": shopID}).Decode(&template)
+		}
+	}
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, templateID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query template: %w", err)
+	}
+	return template, nil
+}
@@ -0,0 +1,313 @@
+// template_library.go - Shared template library + per-shop subscriptions
+//
+// templateLibrary = template มาตรฐานที่ใช้ร่วมกันทุกร้าน (ค่าน้ำมัน, ค่าไฟฟ้า, เงินเดือน)
+// templateSubscriptions = ร้านที่สมัครใช้ template จาก library พร้อม map รหัสบัญชีมาตรฐาน → ผังบัญชีของร้าน
+// ListDocumentTemplates รวม template ที่สมัครไว้เข้ากับ documentFormate ของร้าน (template ของร้านชื่อเดียวกันมาก่อน)
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	templateLibraryCollection       = "templateLibrary"
+	templateSubscriptionsCollection = "templateSubscriptions"
+)
+
+// LibraryTemplateIDPrefix marks library templates in the template list ("_id": "library:<library_id>")
+const LibraryTemplateIDPrefix = "library:"
+
+// LibraryTemplateDetail is one account line of a library template (standard account code)
+type LibraryTemplateDetail struct {
+	AccountCode string `bson:"accountcode" json:"accountcode"`
+	Detail      string `bson:"detail" json:"detail"`
+	Side        string `bson:"side,omitempty" json:"side,omitempty"`       // debit / credit (optional)
+	Formula     string `bson:"formula,omitempty" json:"formula,omitempty"` // Amount computed from the extracted fields (optional, see processor/template_formula.go)
+}
+
+// LibraryTemplate is a standard template shops can subscribe to (documentFormate field names)
+type LibraryTemplate struct {
+	LibraryID         string                  `bson:"library_id" json:"library_id"`
+	Description       string                  `bson:"description" json:"description"`
+	PromptDescription string                  `bson:"promptdescription" json:"promptdescription"`
+	Details           []LibraryTemplateDetail `bson:"details" json:"details"`
+	CreatedAt         time.Time               `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time               `bson:"updated_at" json:"updated_at"`
+}
+
+// TemplateSubscription is a shop's use of a library template
+type TemplateSubscription struct {
+	ShopID            string            `bson:"shopid" json:"-"`
+	LibraryID         string            `bson:"library_id" json:"library_id"`
+	AccountMap        map[string]string `bson:"account_map" json:"account_map"`                                 // Library account code → shop account code (missing = same code)
+	Description       string            `bson:"description,omitempty" json:"description,omitempty"`             // Override of the library description
+	PromptDescription string            `bson:"promptdescription,omitempty" json:"promptdescription,omitempty"` // Override of the library prompt description
+	JournalBookCode   string            `bson:"journalbookcode,omitempty" json:"journalbookcode,omitempty"`
+	CreatedAt         time.Time         `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time         `bson:"updated_at" json:"updated_at"`
+}
+
+// ensureTemplateLibraryIndexes creates the unique library_id and (shop, library_id) indexes
+func ensureTemplateLibraryIndexes(ctx context.Context) error {
+	_, err := mongoDB.Collection(templateLibraryCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "library_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", templateLibraryCollection, err)
+	}
+	_, err = mongoDB.Collection(templateSubscriptionsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "shopid", Value: 1}, {Key: "library_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "library_id", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", templateSubscriptionsCollection, err)
+	}
+	return nil
+}
+
+// ListLibraryTemplates returns every library template ordered by library_id
+func ListLibraryTemplates(ctx context.Context) ([]LibraryTemplate, error) {
+	return findLibraryTemplates(ctx, mongoDB, bson.M{})
+}
+
+// GetLibraryTemplate returns one library template (nil = unknown library_id)
+func GetLibraryTemplate(ctx context.Context, libraryID string) (*LibraryTemplate, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var template LibraryTemplate
+	err := mongoDB.Collection(templateLibraryCollection).FindOne(ctx, bson.M{"library_id": libraryID}).Decode(&template)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query templateLibrary: %w", err)
+	}
+	return &template, nil
+}
+
+func findLibraryTemplates(ctx context.Context, db *mongo.Database, filter bson.M) ([]LibraryTemplate, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := db.Collection(templateLibraryCollection).Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "library_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query templateLibrary: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	templates := []LibraryTemplate{}
+	if err := cursor.All(ctx, &templates); err != nil {
+		return nil, fmt.Errorf("failed to decode templateLibrary: %w", err)
+	}
+	return templates, nil
+}
+
+// SaveLibraryTemplate creates or replaces a library template (created_at is kept)
+func SaveLibraryTemplate(template LibraryTemplate) (*LibraryTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	template.UpdatedAt = now
+	update := bson.M{
+		"$set": bson.M{
+			"description":       template.Description,
+			"promptdescription": template.PromptDescription,
+			"details":           template.Details,
+			"updated_at":        now,
+		},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	collection := mongoDB.Collection(templateLibraryCollection)
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var saved LibraryTemplate
+	if err := collection.FindOneAndUpdate(ctx, bson.M{"library_id": template.LibraryID}, update, opts).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("failed to save library template: %w", err)
+	}
+	return &saved, nil
+}
+
+// DeleteLibraryTemplate removes a library template and every subscription to it
+// Returns the number of subscriptions removed (-1 = unknown library_id)
+func DeleteLibraryTemplate(libraryID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := mongoDB.Collection(templateLibraryCollection).DeleteOne(ctx, bson.M{"library_id": libraryID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete library template: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return -1, nil
+	}
+	subscriptions, err := mongoDB.Collection(templateSubscriptionsCollection).DeleteMany(ctx, bson.M{"library_id": libraryID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete template subscriptions: %w", err)
+	}
+	return subscriptions.DeletedCount, nil
+}
+
+// GetTemplateSubscriptions returns the library subscriptions of a shop ordered by library_id
+func GetTemplateSubscriptions(ctx context.Context, shopID string) ([]TemplateSubscription, error) {
+	return getTemplateSubscriptions(ctx, mongoDB, shopID)
+}
+
+func getTemplateSubscriptions(ctx context.Context, db *mongo.Database, shopID string) ([]TemplateSubscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := db.Collection(templateSubscriptionsCollection).Find(ctx, bson.M{"shopid": shopID},
+		options.Find().SetSort(bson.D{{Key: "library_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query templateSubscriptions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	subscriptions := []TemplateSubscription{}
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		return nil, fmt.Errorf("failed to decode templateSubscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+// SaveTemplateSubscription creates or replaces the subscription of a shop to a library template
+func SaveTemplateSubscription(subscription TemplateSubscription) (*TemplateSubscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	if subscription.AccountMap == nil {
+		subscription.AccountMap = map[string]string{}
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"account_map":       subscription.AccountMap,
+			"description":       subscription.Description,
+			"promptdescription": subscription.PromptDescription,
+			"journalbookcode":   subscription.JournalBookCode,
+			"updated_at":        now,
+		},
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	filter := bson.M{"shopid": subscription.ShopID, "library_id": subscription.LibraryID}
+	collection := mongoDB.Collection(templateSubscriptionsCollection)
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var saved TemplateSubscription
+	if err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("failed to save template subscription: %w", err)
+	}
+	return &saved, nil
+}
+
+// DeleteTemplateSubscription unsubscribes a shop (false = was not subscribed)
+func DeleteTemplateSubscription(shopID, libraryID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := mongoDB.Collection(templateSubscriptionsCollection).DeleteOne(ctx, bson.M{"shopid": shopID, "library_id": libraryID})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete template subscription: %w", err)
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// ResolveLibraryTemplate builds the documentFormate template a subscription gives the shop
+// (account codes mapped to the shop's chart, description overrides applied)
+func ResolveLibraryTemplate(template LibraryTemplate, subscription TemplateSubscription) bson.M {
+	details := bson.A{}
+	for _, detail := range template.Details {
+		code := detail.AccountCode
+		if mapped := strings.TrimSpace(subscription.AccountMap[code]); mapped != "" {
+			code = mapped
+		}
+		resolved := bson.M{"accountcode": code, "detail": detail.Detail}
+		if detail.Side != "" {
+			resolved["side"] = detail.Side
+		}
+		if detail.Formula != "" {
+			resolved["formula"] = detail.Formula
+		}
+		details = append(details, resolved)
+	}
+
+	resolved := bson.M{
+		"_id":               LibraryTemplateIDPrefix + template.LibraryID,
+		"shopid":            subscription.ShopID,
+		"description":       template.Description,
+		"promptdescription": template.PromptDescription,
+		"details":           details,
+		"library_id":        template.LibraryID,
+	}
+	if subscription.Description != "" {
+		resolved["description"] = subscription.Description
+	}
+	if subscription.PromptDescription != "" {
+		resolved["promptdescription"] = subscription.PromptDescription
+	}
+	if subscription.JournalBookCode != "" {
+		resolved["journalbookcode"] = subscription.JournalBookCode
+	}
+	return resolved
+}
+
+// subscribedLibraryTemplates returns the resolved library templates a shop subscribed to
+func subscribedLibraryTemplates(ctx context.Context, db *mongo.Database, shopID string) ([]bson.M, error) {
+	subscriptions, err := getTemplateSubscriptions(ctx, db, shopID)
+	if err != nil || len(subscriptions) == 0 {
+		return nil, err
+	}
+	ids := make([]string, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		ids = append(ids, subscription.LibraryID)
+	}
+	library, err := findLibraryTemplates(ctx, db, bson.M{"library_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]LibraryTemplate, len(library))
+	for _, template := range library {
+		byID[template.LibraryID] = template
+	}
+
+	templates := []bson.M{}
+	for _, subscription := range subscriptions {
+		if template, ok := byID[subscription.LibraryID]; ok && len(template.Details) > 0 {
+			templates = append(templates, ResolveLibraryTemplate(template, subscription))
+		}
+	}
+	return templates, nil
+}
+
+// mergeLibraryTemplates appends library templates whose description the shop does not already have
+// A shop template with the same description overrides the library one
+func mergeLibraryTemplates(shopTemplates, libraryTemplates []bson.M) []bson.M {
+	own := map[string]bool{}
+	for _, template := range shopTemplates {
+		if description, ok := template["description"].(string); ok {
+			own[strings.TrimSpace(description)] = true
+		}
+	}
+	for _, template := range libraryTemplates {
+		description, _ := template["description"].(string)
+		if own[strings.TrimSpace(description)] {
+			continue
+		}
+		shopTemplates = append(shopTemplates, template)
+	}
+	return shopTemplates
+}
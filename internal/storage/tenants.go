@@ -0,0 +1,421 @@
+// tenants.go - Multi-tenant routing: shopid / X-Tenant-ID → tenant database (TENANT_ROUTING_ENABLED)
+//
+// routing table = collection tenantRoutes ในฐานข้อมูลหลัก (MONGO_DB_NAME)
+// ข้อมูลของลูกค้า (master data, documentFormate, ocrResults) อยู่ใน database ของ tenant
+// ค่าที่ service นี้เป็นเจ้าของ (shopSettings, journalBookRules, vendorAccountMappings, usage ledger, audit log, ...)
+// อยู่ในฐานข้อมูลหลักเสมอ - shop ที่ไม่อยู่ใน routing table ใช้ฐานข้อมูลหลักตามเดิม
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bosocmputer/account_ocr_gemini/configs"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const tenantRoutesCollection = "tenantRoutes"
+
+// TenantRoute maps the shops of a tenant to their database
+type TenantRoute struct {
+	TenantID  string    `bson:"tenant_id" json:"tenant_id"`
+	MongoURI  string    `bson:"mongo_uri,omitempty" json:"-"` // Empty = main connection (MONGO_URI), never returned by the API
+	DBName    string    `bson:"db_name" json:"db_name"`
+	ShopIDs   []string  `bson:"shopids" json:"shopids"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+var (
+	// ErrUnknownTenant - the requested tenant is not in the routing table
+	ErrUnknownTenant = errors.New("unknown tenant")
+	// ErrTenantMismatch - the requested tenant is not the tenant of the shop
+	ErrTenantMismatch = errors.New("shop does not belong to the requested tenant")
+)
+
+type tenantContextKey struct{}
+
+// WithTenant returns ctx carrying the tenant requested by the caller (X-Tenant-ID)
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant requested by the caller ("" = none)
+func TenantFromContext(ctx context.Context) string {
+	tenantID, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenantID
+}
+
+// ensureTenantRouteIndexes creates the unique tenant_id index
+func ensureTenantRouteIndexes(ctx context.Context) error {
+	_, err := mongoDB.Collection(tenantRoutesCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenant_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", tenantRoutesCollection, err)
+	}
+	return nil
+}
+
+// GetTenantRoutes returns the routing table
+func GetTenantRoutes(ctx context.Context) ([]TenantRoute, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := mongoDB.Collection(tenantRoutesCollection).Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "tenant_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tenant routes: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	routes := []TenantRoute{}
+	if err := cursor.All(ctx, &routes); err != nil {
+		return nil, fmt.Errorf("failed to decode tenant routes: %w", err)
+	}
+	return routes, nil
+}
+
+// tenantStore is the Store of one tenant (own database, own master data cache)
+type tenantStore struct {
+	route TenantRoute
+	store *MongoStore
+}
+
+// TenantRouter implements Store by routing every call to the database of the shop's tenant
+type TenantRouter struct {
+	main      *MongoStore
+	refreshMu sync.Mutex // One Refresh at a time
+
+	mu      sync.RWMutex
+	tenants map[string]*tenantStore  // tenant_id → store
+	shops   map[string]string        // shopid → tenant_id
+	clients map[string]*mongo.Client // mongo_uri → connection pool shared by the tenants of one cluster
+}
+
+// NewTenantRouter returns a router with an empty routing table (every shop → main) - call Refresh to load it
+func NewTenantRouter(main *MongoStore) *TenantRouter {
+	return &TenantRouter{
+		main:    main,
+		tenants: map[string]*tenantStore{},
+		shops:   map[string]string{},
+		clients: map[string]*mongo.Client{},
+	}
+}
+
+// Refresh reloads the routing table
+// New clusters are connected, tenants whose database changed get a new store (empty cache),
+// clusters no tenant uses any more are disconnected. On error the current routes are kept
+func (r *TenantRouter) Refresh(ctx context.Context) error {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+
+	routes, err := GetTenantRoutes(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Step 1: Connect the clusters that are not connected yet (outside the lock - requests keep routing)
+	r.mu.RLock()
+	clients := make(map[string]*mongo.Client, len(r.clients))
+	for uri, client := range r.clients {
+		clients[uri] = client
+	}
+	current := r.tenants
+	r.mu.RUnlock()
+
+	var connected []*mongo.Client
+	for _, route := range routes {
+		if route.TenantID == "" || route.DBName == "" {
+			return fmt.Errorf("tenant route %q needs tenant_id and db_name", route.TenantID)
+		}
+		if route.MongoURI == "" || clients[route.MongoURI] != nil {
+			continue
+		}
+		client, err := connectTenantCluster(ctx, route.MongoURI)
+		if err != nil {
+			disconnectClients(connected)
+			return fmt.Errorf("tenant %s: %w", route.TenantID, err)
+		}
+		clients[route.MongoURI] = client
+		connected = append(connected, client)
+	}
+
+	// Step 2: Build the new routing table (unchanged tenants keep their store and cache)
+	tenants := make(map[string]*tenantStore, len(routes))
+	shops := map[string]string{}
+	var newDatabases []*mongo.Database
+	for _, route := range routes {
+		entry := current[route.TenantID]
+		if entry == nil || entry.route.MongoURI != route.MongoURI || entry.route.DBName != route.DBName {
+			client := mongoClient
+			if route.MongoURI != "" {
+				client = clients[route.MongoURI]
+			}
+			db := client.Database(route.DBName)
+			entry = &tenantStore{store: &MongoStore{db: db, serviceDB: r.main.serviceDB, cache: newMasterDataCacheSet()}}
+			newDatabases = append(newDatabases, db)
+		}
+		entry.route = route
+		tenants[route.TenantID] = entry
+
+		for _, shopID := range route.ShopIDs {
+			if other, exists := shops[shopID]; exists {
+				log.Printf("⚠️  Shop %s is routed to tenants %s and %s - using %s", shopID, other, route.TenantID, other)
+				continue
+			}
+			shops[shopID] = route.TenantID
+		}
+	}
+
+	// Step 3: Drop the clusters no tenant uses
+	used := map[string]bool{}
+	for _, route := range routes {
+		used[route.MongoURI] = true
+	}
+	var unused []*mongo.Client
+	for uri, client := range clients {
+		if !used[uri] {
+			unused = append(unused, client)
+			delete(clients, uri)
+		}
+	}
+
+	r.mu.Lock()
+	r.tenants, r.shops, r.clients = tenants, shops, clients
+	r.mu.Unlock()
+	disconnectClients(unused)
+
+	// ocrResults lives in the tenant database - it needs the same indexes (TTL) as in the main database
+	for _, db := range newDatabases {
+		if err := ensureOCRResultIndexes(ctx, db); err != nil {
+			log.Printf("⚠️  Failed to ensure indexes in tenant database %s: %v", db.Name(), err)
+		}
+	}
+	return nil
+}
+
+// connectTenantCluster opens a connection pool (TENANT_MAX_POOL_SIZE) to a tenant cluster
+func connectTenantCluster(ctx context.Context, uri string) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMaxPoolSize(uint64(configs.TENANT_MAX_POOL_SIZE)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to tenant cluster: %w", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to ping tenant cluster: %w", err)
+	}
+	return client, nil
+}
+
+// disconnectClients closes connection pools that are no longer routed to
+func disconnectClients(clients []*mongo.Client) {
+	for _, client := range clients {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		client.Disconnect(ctx)
+		cancel()
+	}
+}
+
+// Close disconnects every tenant cluster (the main connection is closed by CloseMongoDB)
+func (r *TenantRouter) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := make([]*mongo.Client, 0, len(r.clients))
+	for _, client := range r.clients {
+		clients = append(clients, client)
+	}
+	disconnectClients(clients)
+	r.clients = map[string]*mongo.Client{}
+}
+
+// HasTenant reports whether tenantID is in the routing table
+func (r *TenantRouter) HasTenant(tenantID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.tenants[tenantID]
+	return ok
+}
+
+// Routes returns the current routing table
+func (r *TenantRouter) Routes() []TenantRoute {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	routes := make([]TenantRoute, 0, len(r.tenants))
+	for _, entry := range r.tenants {
+		routes = append(routes, entry.route)
+	}
+	return routes
+}
+
+// Databases returns the main database and every tenant database (retention purge)
+func (r *TenantRouter) Databases() []*mongo.Database {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	dbs := []*mongo.Database{r.main.db}
+	for _, entry := range r.tenants {
+		dbs = append(dbs, entry.store.db)
+	}
+	return dbs
+}
+
+// storeFor returns the store of the shop's tenant (main for shops without a route)
+// A tenant requested in ctx must be the shop's tenant
+func (r *TenantRouter) storeFor(ctx context.Context, shopID string) (*MongoStore, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tenantID, routed := r.shops[shopID]
+	if requested := TenantFromContext(ctx); requested != "" {
+		if _, ok := r.tenants[requested]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, requested)
+		}
+		if requested != tenantID {
+			return nil, fmt.Errorf("%w: shop %s, tenant %s", ErrTenantMismatch, shopID, requested)
+		}
+	}
+	if !routed {
+		return r.main, nil
+	}
+	return r.tenants[tenantID].store, nil
+}
+
+// stores returns the store of the requested tenant, or main + every tenant when none was requested
+func (r *TenantRouter) stores(ctx context.Context) ([]*MongoStore, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if requested := TenantFromContext(ctx); requested != "" {
+		entry, ok := r.tenants[requested]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, requested)
+		}
+		return []*MongoStore{entry.store}, nil
+	}
+	stores := []*MongoStore{r.main}
+	for _, entry := range r.tenants {
+		stores = append(stores, entry.store)
+	}
+	return stores, nil
+}
+
+// GetOrLoadMasterData loads from the shop's tenant database (each tenant has its own cache)
+func (r *TenantRouter) GetOrLoadMasterData(ctx context.Context, shopID string) (*MasterDataCache, error) {
+	store, err := r.storeFor(ctx, shopID)
+	if err != nil {
+		return nil, err
+	}
+	return store.GetOrLoadMasterData(ctx, shopID)
+}
+
+// InvalidateCache removes the shop's cache in its tenant
+func (r *TenantRouter) InvalidateCache(shopID string) {
+	if store, err := r.storeFor(context.Background(), shopID); err == nil {
+		store.InvalidateCache(shopID)
+	}
+}
+
+// ClearAllCache removes the cache of every tenant
+func (r *TenantRouter) ClearAllCache() {
+	stores, _ := r.stores(context.Background())
+	for _, store := range stores {
+		store.ClearAllCache()
+	}
+}
+
+// ListDocumentTemplates reads documentFormate from the shop's tenant database
+func (r *TenantRouter) ListDocumentTemplates(ctx context.Context, shopID string) ([]bson.M, error) {
+	store, err := r.storeFor(ctx, shopID)
+	if err != nil {
+		return nil, err
+	}
+	return store.ListDocumentTemplates(ctx, shopID)
+}
+
+// GetDocumentTemplate reads one documentFormate template from the shop's tenant database
+func (r *TenantRouter) GetDocumentTemplate(ctx context.Context, shopID, templateID string) (bson.M, error) {
+	store, err := r.storeFor(ctx, shopID)
+	if err != nil {
+		return nil, err
+	}
+	return store.GetDocumentTemplate(ctx, shopID, templateID)
+}
+
+// SaveOCRResult stores the OCR text in the shop's tenant database
+func (r *TenantRouter) SaveOCRResult(record StoredOCRResult, ttl time.Duration) error {
+	store, err := r.storeFor(context.Background(), record.ShopID)
+	if err != nil {
+		return err
+	}
+	return store.SaveOCRResult(record, ttl)
+}
+
+// GetOCRResult looks the request up in the requested tenant, or in every database when none was requested
+func (r *TenantRouter) GetOCRResult(ctx context.Context, requestID string) (*StoredOCRResult, error) {
+	stores, err := r.stores(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, store := range stores {
+		record, err := store.GetOCRResult(ctx, requestID)
+		if err != nil || record != nil {
+			return record, err
+		}
+	}
+	return nil, nil
+}
+
+// SetOCRResultSummary updates the stored request in the shop's tenant database
+func (r *TenantRouter) SetOCRResultSummary(shopID, requestID string, summary OCRDocumentSummary, analysis *OCRAnalysisSnapshot) error {
+	store, err := r.storeFor(context.Background(), shopID)
+	if err != nil {
+		return err
+	}
+	return store.SetOCRResultSummary(shopID, requestID, summary, analysis)
+}
+
+// ApproveOCRResult freezes the request in the shop's tenant database
+func (r *TenantRouter) ApproveOCRResult(shopID, requestID string, summary OCRDocumentSummary, analysis OCRAnalysisSnapshot, approval OCRResultApproval) error {
+	store, err := r.storeFor(context.Background(), shopID)
+	if err != nil {
+		return err
+	}
+	return store.ApproveOCRResult(shopID, requestID, summary, analysis, approval)
+}
+
+// ListOCRResultsByDocumentDate lists the results in the shop's tenant database
+func (r *TenantRouter) ListOCRResultsByDocumentDate(ctx context.Context, shopID, fromDate, toDate string) ([]StoredOCRResult, error) {
+	store, err := r.storeFor(ctx, shopID)
+	if err != nil {
+		return nil, err
+	}
+	return store.ListOCRResultsByDocumentDate(ctx, shopID, fromDate, toDate)
+}
+
+// ListOCRResultsByCreditor lists the creditor's results in the shop's tenant database
+func (r *TenantRouter) ListOCRResultsByCreditor(ctx context.Context, shopID, creditorCode string, limit int) ([]StoredOCRResult, error) {
+	store, err := r.storeFor(ctx, shopID)
+	if err != nil {
+		return nil, err
+	}
+	return store.ListOCRResultsByCreditor(ctx, shopID, creditorCode, limit)
+}
+
+// SearchOCRResults searches the shop's tenant database
+func (r *TenantRouter) SearchOCRResults(ctx context.Context, query OCRSearchQuery) ([]StoredOCRResult, error) {
+	store, err := r.storeFor(ctx, query.ShopID)
+	if err != nil {
+		return nil, err
+	}
+	return store.SearchOCRResults(ctx, query)
+}
@@ -0,0 +1,214 @@
+// usage_ledger.go - Per-request token/cost ledger for billing reports
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const usageLedgerCollection = "usageLedger"
+
+// UsageLedgerPhase is the actual usage of one phase (ocr, template_match, accounting)
+type UsageLedgerPhase struct {
+	Phase        string  `bson:"phase" json:"phase"`
+	Provider     string  `bson:"provider" json:"provider"` // "gemini" or "mistral"
+	InputTokens  int     `bson:"input_tokens" json:"input_tokens"`
+	OutputTokens int     `bson:"output_tokens" json:"output_tokens"`
+	TotalTokens  int     `bson:"total_tokens" json:"total_tokens"` // Mistral: pages processed
+	CostUSD      float64 `bson:"cost_usd" json:"cost_usd"`
+	CostTHB      float64 `bson:"cost_thb" json:"cost_thb"`
+}
+
+// UsageLedgerEntry is one billed request
+type UsageLedgerEntry struct {
+	RequestID   string             `bson:"request_id" json:"request_id"`
+	ShopID      string             `bson:"shopid" json:"shopid"`
+	Endpoint    string             `bson:"endpoint" json:"endpoint"`
+	OCRProvider string             `bson:"ocr_provider" json:"ocr_provider"`
+	StatusCode  int                `bson:"status_code" json:"status_code"`
+	Phases      []UsageLedgerPhase `bson:"phases" json:"phases"`
+	TotalTokens int                `bson:"total_tokens" json:"total_tokens"`
+	CostUSD     float64            `bson:"cost_usd" json:"cost_usd"`
+	CostTHB     float64            `bson:"cost_thb" json:"cost_thb"`
+	Day         string             `bson:"day" json:"day"` // YYYY-MM-DD (server local time)
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// CostBreakdownRow aggregates usage for one phase + provider
+type CostBreakdownRow struct {
+	Phase        string  `bson:"phase" json:"phase"`
+	Provider     string  `bson:"provider" json:"provider"`
+	Requests     int     `bson:"requests" json:"requests"`
+	InputTokens  int     `bson:"input_tokens" json:"input_tokens"`
+	OutputTokens int     `bson:"output_tokens" json:"output_tokens"`
+	TotalTokens  int     `bson:"total_tokens" json:"total_tokens"`
+	CostUSD      float64 `bson:"cost_usd" json:"cost_usd"`
+	CostTHB      float64 `bson:"cost_thb" json:"cost_thb"`
+}
+
+// DailyCost aggregates usage for one day
+type DailyCost struct {
+	Day         string  `bson:"_id" json:"day"`
+	Requests    int     `bson:"requests" json:"requests"`
+	TotalTokens int     `bson:"total_tokens" json:"total_tokens"`
+	CostUSD     float64 `bson:"cost_usd" json:"cost_usd"`
+	CostTHB     float64 `bson:"cost_thb" json:"cost_thb"`
+}
+
+// ShopCostReport is the cost report of a shop for a period (month or day)
+type ShopCostReport struct {
+	ShopID      string             `json:"shopid"`
+	Period      string             `json:"period"`
+	From        time.Time          `json:"from"`
+	To          time.Time          `json:"to"`
+	Requests    int                `json:"requests"`
+	TotalTokens int                `json:"total_tokens"`
+	CostUSD     float64            `json:"cost_usd"`
+	CostTHB     float64            `json:"cost_thb"`
+	Breakdown   []CostBreakdownRow `json:"breakdown"` // by phase + provider
+	Daily       []DailyCost        `json:"daily"`
+}
+
+// ensureUsageLedgerIndexes creates indexes used by cost reports
+func ensureUsageLedgerIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(usageLedgerCollection)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "shopid", Value: 1}, {Key: "created_at", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "request_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", usageLedgerCollection, err)
+	}
+	return nil
+}
+
+// RecordUsage inserts a ledger entry (totals are computed from phases)
+func RecordUsage(entry UsageLedgerEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	entry.Day = entry.CreatedAt.Format("2006-01-02")
+	entry.TotalTokens, entry.CostUSD, entry.CostTHB = 0, 0, 0
+	for _, p := range entry.Phases {
+		entry.TotalTokens += p.TotalTokens
+		entry.CostUSD += p.CostUSD
+		entry.CostTHB += p.CostTHB
+	}
+
+	collection := mongoDB.Collection(usageLedgerCollection)
+	if _, err := collection.InsertOne(ctx, entry); err != nil {
+		return fmt.Errorf("failed to insert usage ledger entry: %w", err)
+	}
+	return nil
+}
+
+// GetUsageLedgerEntry returns the ledger entry of a request (nil = not billed / unknown request_id)
+func GetUsageLedgerEntry(ctx context.Context, requestID string) (*UsageLedgerEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var entry UsageLedgerEntry
+	collection := mongoDB.Collection(usageLedgerCollection)
+	err := collection.FindOne(ctx, bson.M{"request_id": requestID}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find usage ledger entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// GetShopCostReport aggregates the ledger of a shop for [from, to)
+func GetShopCostReport(ctx context.Context, shopID, period string, from, to time.Time) (*ShopCostReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(usageLedgerCollection)
+	match := bson.D{{Key: "$match", Value: bson.M{
+		"shopid":     shopID,
+		"created_at": bson.M{"$gte": from, "$lt": to},
+	}}}
+
+	report := &ShopCostReport{
+		ShopID:    shopID,
+		Period:    period,
+		From:      from,
+		To:        to,
+		Breakdown: []CostBreakdownRow{},
+		Daily:     []DailyCost{},
+	}
+
+	// Breakdown by phase + provider
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		match,
+		{{Key: "$unwind", Value: "$phases"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           bson.M{"phase": "$phases.phase", "provider": "$phases.provider"},
+			"requests":      bson.M{"$sum": 1},
+			"input_tokens":  bson.M{"$sum": "$phases.input_tokens"},
+			"output_tokens": bson.M{"$sum": "$phases.output_tokens"},
+			"total_tokens":  bson.M{"$sum": "$phases.total_tokens"},
+			"cost_usd":      bson.M{"$sum": "$phases.cost_usd"},
+			"cost_thb":      bson.M{"$sum": "$phases.cost_thb"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":           0,
+			"phase":         "$_id.phase",
+			"provider":      "$_id.provider",
+			"requests":      1,
+			"input_tokens":  1,
+			"output_tokens": 1,
+			"total_tokens":  1,
+			"cost_usd":      1,
+			"cost_thb":      1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "phase", Value: 1}, {Key: "provider", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate usage by phase: %w", err)
+	}
+	if err := cursor.All(ctx, &report.Breakdown); err != nil {
+		return nil, fmt.Errorf("failed to decode usage by phase: %w", err)
+	}
+
+	// Daily totals (also gives request count and grand totals)
+	cursor, err = collection.Aggregate(ctx, mongo.Pipeline{
+		match,
+		{{Key: "$group", Value: bson.M{
+			"_id":          "$day",
+			"requests":     bson.M{"$sum": 1},
+			"total_tokens": bson.M{"$sum": "$total_tokens"},
+			"cost_usd":     bson.M{"$sum": "$cost_usd"},
+			"cost_thb":     bson.M{"$sum": "$cost_thb"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate usage by day: %w", err)
+	}
+	if err := cursor.All(ctx, &report.Daily); err != nil {
+		return nil, fmt.Errorf("failed to decode usage by day: %w", err)
+	}
+
+	for _, day := range report.Daily {
+		report.Requests += day.Requests
+		report.TotalTokens += day.TotalTokens
+		report.CostUSD += day.CostUSD
+		report.CostTHB += day.CostTHB
+	}
+
+	return report, nil
+}
@@ -0,0 +1,112 @@
+// vendor_account_mappings.go - Per-shop creditor → account mappings learned from approvals
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const vendorAccountMappingsCollection = "vendorAccountMappings"
+
+// VendorAccountMapping - documents from CreditorCode go to AccountCode (approved by a user)
+type VendorAccountMapping struct {
+	ShopID         string    `bson:"shopid" json:"-"`
+	CreditorCode   string    `bson:"creditor_code" json:"creditor_code"`
+	CreditorName   string    `bson:"creditor_name" json:"creditor_name"`
+	AccountCode    string    `bson:"account_code" json:"account_code"`
+	AccountName    string    `bson:"account_name" json:"account_name"`
+	Approvals      int       `bson:"approvals" json:"approvals"` // Times this mapping was approved (reset when the account changes)
+	LastRequestID  string    `bson:"last_request_id,omitempty" json:"last_request_id,omitempty"`
+	CreatedAt      time.Time `bson:"created_at" json:"created_at"`
+	LastApprovedAt time.Time `bson:"last_approved_at" json:"last_approved_at"`
+}
+
+// ensureVendorAccountMappingIndexes creates the unique (shop, creditor) index
+func ensureVendorAccountMappingIndexes(ctx context.Context) error {
+	collection := mongoDB.Collection(vendorAccountMappingsCollection)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "shopid", Value: 1}, {Key: "creditor_code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s indexes: %w", vendorAccountMappingsCollection, err)
+	}
+	return nil
+}
+
+// GetVendorAccountMappings returns all learned mappings of a shop
+func GetVendorAccountMappings(ctx context.Context, shopID string) ([]VendorAccountMapping, error) {
+	return getVendorAccountMappings(ctx, mongoDB, shopID)
+}
+
+func getVendorAccountMappings(ctx context.Context, db *mongo.Database, shopID string) ([]VendorAccountMapping, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	collection := db.Collection(vendorAccountMappingsCollection)
+	cursor, err := collection.Find(ctx, bson.M{"shopid": shopID},
+		options.Find().SetSort(bson.D{{Key: "creditor_code", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query vendorAccountMappings: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	mappings := []VendorAccountMapping{}
+	if err := cursor.All(ctx, &mappings); err != nil {
+		return nil, fmt.Errorf("failed to decode vendorAccountMappings: %w", err)
+	}
+	return mappings, nil
+}
+
+// ApproveVendorAccountMapping records an approval: approvals +1 for the same account,
+// otherwise the mapping moves to the new account with approvals = 1
+func ApproveVendorAccountMapping(mapping VendorAccountMapping) (*VendorAccountMapping, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(vendorAccountMappingsCollection)
+	filter := bson.M{"shopid": mapping.ShopID, "creditor_code": mapping.CreditorCode}
+	now := time.Now()
+
+	var existing VendorAccountMapping
+	err := collection.FindOne(ctx, filter).Decode(&existing)
+	switch {
+	case err == nil && existing.AccountCode == mapping.AccountCode:
+		mapping.Approvals = existing.Approvals + 1
+		mapping.CreatedAt = existing.CreatedAt
+	case err == nil:
+		mapping.Approvals = 1
+		mapping.CreatedAt = existing.CreatedAt
+	case errors.Is(err, mongo.ErrNoDocuments):
+		mapping.Approvals = 1
+		mapping.CreatedAt = now
+	default:
+		return nil, fmt.Errorf("failed to query vendor account mapping: %w", err)
+	}
+	mapping.LastApprovedAt = now
+
+	if _, err := collection.ReplaceOne(ctx, filter, mapping, options.Replace().SetUpsert(true)); err != nil {
+		return nil, fmt.Errorf("failed to save vendor account mapping: %w", err)
+	}
+	return &mapping, nil
+}
+
+// DeleteVendorAccountMapping forgets the mapping of a creditor (false = not found)
+func DeleteVendorAccountMapping(shopID, creditorCode string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := mongoDB.Collection(vendorAccountMappingsCollection)
+	result, err := collection.DeleteOne(ctx, bson.M{"shopid": shopID, "creditor_code": creditorCode})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete vendor account mapping: %w", err)
+	}
+	return result.DeletedCount > 0, nil
+}